@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flowHeaderPrefix marks the response headers that carry flow metadata.
+// Cross-origin clients can only read them if they are listed in
+// Access-Control-Expose-Headers.
+const flowHeaderPrefix = "X-Flow-"
+
+// exposedHeaders are the other response headers cross-origin clients may
+// read, in the canonical form http.Header keys them by.
+var exposedHeaders = []string{"Deprecation", "Etag", "Sunset"}
+
+// corsConfig controls the cross-origin access granted to browser clients.
+// With no allowed origins CORS is disabled and no headers are added.
+type corsConfig struct {
+	AllowedOrigins   []string // exact origins, or "*" for any origin
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	MaxAge           int // seconds a preflight may be cached; 0 omits the header
+	AllowCredentials bool
+}
+
+// splitList parses a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// validate rejects configurations browsers would refuse anyway.
+func (c corsConfig) validate() error {
+	if c.AllowCredentials && slices.Contains(c.AllowedOrigins, "*") {
+		return errors.New("the wildcard CORS origin cannot be combined with credentials")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("CORS max-age must not be negative")
+	}
+	return nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or ""
+// if the origin is not allowed.
+func (c corsConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (c corsConfig) allowsMethod(method string) bool {
+	for _, allowed := range c.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap adds CORS headers to the responses of next and answers preflight
+// requests itself. Preflights from disallowed origins, or for disallowed
+// methods, get 403 without CORS headers.
+func (c corsConfig) wrap(next http.Handler) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := c.allowOrigin(origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed == "" || !c.allowsMethod(r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			c.setOriginHeaders(w, allowed)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+			if len(c.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			}
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.setOriginHeaders(w, allowed)
+		next.ServeHTTP(&exposeWriter{ResponseWriter: w}, r)
+	})
+}
+
+func (c corsConfig) setOriginHeaders(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// exposeWriter lists the X-Flow-* and exposedHeaders headers set by the
+// handler in Access-Control-Expose-Headers just before the header is written.
+type exposeWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (ew *exposeWriter) WriteHeader(code int) {
+	if !ew.wroteHeader {
+		ew.wroteHeader = true
+		var exposed []string
+		for name := range ew.Header() {
+			if strings.HasPrefix(name, flowHeaderPrefix) || slices.Contains(exposedHeaders, name) {
+				exposed = append(exposed, name)
+			}
+		}
+		if len(exposed) > 0 {
+			sort.Strings(exposed)
+			ew.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+		}
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *exposeWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	return ew.ResponseWriter.Write(b)
+}
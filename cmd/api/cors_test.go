@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testCORS() corsConfig {
+	return corsConfig{
+		AllowedOrigins: []string{"https://dashboard.example"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         600,
+	}
+}
+
+// flowStub stands in for an endpoint that reports flow metadata in headers.
+var flowStub = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Flow-Frames", "2")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+})
+
+func TestCORSPreflight(t *testing.T) {
+	handler := testCORS().wrap(flowStub)
+
+	tests := []struct {
+		name       string
+		origin     string
+		method     string
+		wantStatus int
+		wantOrigin string
+	}{
+		{"allowed origin", "https://dashboard.example", "POST", http.StatusNoContent, "https://dashboard.example"},
+		{"disallowed origin", "https://evil.example", "POST", http.StatusForbidden, ""},
+		{"disallowed method", "https://dashboard.example", "PUT", http.StatusForbidden, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "/flow", nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", tc.method)
+			req.Header.Set("Access-Control-Request-Headers", "content-type")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tc.wantOrigin, got)
+			}
+			if tc.wantOrigin == "" {
+				return
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+				t.Errorf("Unexpected Access-Control-Allow-Methods %q", got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type" {
+				t.Errorf("Unexpected Access-Control-Allow-Headers %q", got)
+			}
+			if got := rr.Header().Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("Unexpected Access-Control-Max-Age %q", got)
+			}
+		})
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler := testCORS().wrap(flowStub)
+
+	post := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/flow", strings.NewReader("{}"))
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post("https://dashboard.example")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example" {
+		t.Errorf("Expected the origin to be echoed, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "X-Flow-Frames" {
+		t.Errorf("Expected X-Flow-Frames to be exposed, got %q", got)
+	}
+	if got := rr.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Expected Vary: Origin, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Expected no credentials header, got %q", got)
+	}
+
+	rr = post("https://evil.example")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the request to reach the handler, got status %d", rr.Code)
+	}
+	for _, h := range []string{"Access-Control-Allow-Origin", "Access-Control-Expose-Headers"} {
+		if got := rr.Header().Get(h); got != "" {
+			t.Errorf("Expected no %s for a disallowed origin, got %q", h, got)
+		}
+	}
+}
+
+func TestCORSWildcardAndCredentials(t *testing.T) {
+	wildcard := corsConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"POST"}}
+	if err := wildcard.validate(); err != nil {
+		t.Errorf("Wildcard without credentials should be valid: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/trace", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	rr := httptest.NewRecorder()
+	wildcard.wrap(flowStub).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected wildcard origin, got %q", got)
+	}
+
+	wildcard.AllowCredentials = true
+	if err := wildcard.validate(); err == nil {
+		t.Error("Expected an error combining the wildcard origin with credentials")
+	}
+
+	credentialed := testCORS()
+	credentialed.AllowCredentials = true
+	rr = httptest.NewRecorder()
+	req.Header.Set("Origin", "https://dashboard.example")
+	credentialed.wrap(flowStub).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Expected credentials to be allowed, got %q", got)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "/flow", nil)
+	req.Header.Set("Origin", "https://dashboard.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	corsConfig{}.wrap(newMux()).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no CORS headers when disabled, got %q", got)
+	}
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected the handler's 405, got %d", rr.Code)
+	}
+}
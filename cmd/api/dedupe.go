@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dedupedRequests counts the requests answered with the response of an
+// identical request that was already being computed. It is published at
+// /debug/vars on the admin listener.
+var dedupedRequests = expvar.NewInt("deduplicated_requests")
+
+// inflight shares the responses of identical heavy requests that overlap.
+var inflight = flightGroup{flights: make(map[string]*flight)}
+
+// flightGroup runs one computation per key at a time and hands its
+// response to every request with that key that arrives meanwhile.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a computation in progress. val is set before done is closed.
+// waiters counts the requests still waiting for it, the first included;
+// when the last of them goes away cancel stops the computation.
+type flight struct {
+	done    chan struct{}
+	val     any
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// serve writes the response of compute to w. Requests with the same key
+// that arrive while compute runs wait for it and are sent the same status,
+// headers and body instead of computing their own. compute runs under a
+// context that is done once every request waiting for it, the first
+// included, is done, so a client that disconnects does not cancel the
+// response others wait for. Nothing is kept once compute returns, so later
+// requests compute afresh.
+func (g *flightGroup) serve(ctx context.Context, w http.ResponseWriter, key string, compute func(ctx context.Context, w http.ResponseWriter)) {
+	val, ok := g.do(ctx, key, func(ctx context.Context) any {
+		resp := newBufferedResponse()
+		compute(ctx, resp)
+		return resp
+	})
+	if !ok {
+		return
+	}
+	resp, _ := val.(*bufferedResponse)
+	if resp == nil {
+		// compute panicked; don't leave the waiters an empty 200.
+		resp = newBufferedResponse()
+		http.Error(resp, "Internal server error", http.StatusInternalServerError)
+	}
+	resp.writeTo(w)
+}
+
+// do is like serve for a computation whose result is a value rather than a
+// response: it returns the value compute returned for the first request
+// with key, or nil if compute panicked. It reports false if ctx is done
+// before a request that joined another's computation gets the value.
+func (g *flightGroup) do(ctx context.Context, key string, compute func(ctx context.Context) any) (any, bool) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		g.mu.Unlock()
+		dedupedRequests.Add(1)
+		select {
+		case <-f.done:
+			return f.val, true
+		case <-ctx.Done():
+			g.leave(key, f)
+			return nil, false
+		}
+	}
+	computeCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	f := &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.flights[key] = f
+	g.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() { g.leave(key, f) })
+	defer stop()
+
+	defer func() {
+		g.mu.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	f.val = compute(computeCtx)
+	return f.val, true
+}
+
+// leave records that a request waiting for f is done. Once none waits,
+// f's computation is cancelled and later requests no longer join it.
+func (g *flightGroup) leave(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f.waiters--; f.waiters > 0 {
+		return
+	}
+	f.cancel()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+// requestKey identifies a request by its endpoint, the params that decide
+// its response, and the paths it reads with their size and modification
+// time, so a request made after a file changes does not share a response
+// computed from the old file. params must encode to JSON deterministically,
+// as structs do. The paths are kept in request order, which matters for a
+// flow sequence.
+func requestKey(endpoint string, params any, paths []string) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	if err := enc.Encode(endpoint); err != nil {
+		return "", err
+	}
+	if err := enc.Encode(params); err != nil {
+		return "", fmt.Errorf("failed to encode request parameters: %w", err)
+	}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(h, "%q missing\n", path)
+			continue
+		}
+		fmt.Fprintf(h, "%q %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response so
+// that it can be sent to several clients.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// writeTo sends the kept response to w. It only reads b, so it may be
+// called for several clients at once.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"example/goflow/flow"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFlowDeduplicatesConcurrentRequests fires three identical /flow
+// requests at a backend that holds the first one until the other two are
+// waiting, and checks it ran once and all three got the flow map.
+func TestFlowDeduplicatesConcurrentRequests(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	var calls atomic.Int32
+	before := dedupedRequests.Value()
+	old := generateFlowMap
+	generateFlowMap = func(ctx context.Context, paths []string, resolutionFactor int, opts flow.FlowOptions) (flow.FlowResult, error) {
+		calls.Add(1)
+		for deadline := time.Now().Add(5 * time.Second); dedupedRequests.Value() < before+2; {
+			if time.Now().After(deadline) {
+				t.Error("Timed out waiting for the duplicate requests")
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return old(ctx, paths, resolutionFactor, opts)
+	}
+	t.Cleanup(func() { generateFlowMap = old })
+
+	body := `{"api_version": 2, "image_paths": ` + versionTestFrames + `, "options": {"resolution_factor": 8}}`
+	bodies := make([][]byte, 3)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := postFlow(t, "", body)
+			if rr.Code != http.StatusOK {
+				t.Errorf("Request %d: expected status 200, got %d: %s", i, rr.Code, rr.Body.String())
+			}
+			bodies[i] = rr.Body.Bytes()
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected the backend to run once, ran %d times", n)
+	}
+	if got := dedupedRequests.Value() - before; got != 2 {
+		t.Errorf("Expected 2 deduplicated requests, counted %d", got)
+	}
+	img, err := png.Decode(bytes.NewReader(bodies[0]))
+	if err != nil {
+		t.Fatalf("Failed to decode the flow map: %v", err)
+	}
+	if img.Bounds().Dx() != 1024/8 {
+		t.Errorf("Expected a %d pixel wide flow map, got %d", 1024/8, img.Bounds().Dx())
+	}
+	for i := 1; i < len(bodies); i++ {
+		if !bytes.Equal(bodies[i], bodies[0]) {
+			t.Errorf("Request %d got a different response from request 0", i)
+		}
+	}
+}
+
+// TestCountersOnlyOnAdminMux checks that /debug/vars, which publishes the
+// deduplication counter, is served by the admin listener and not the API.
+func TestCountersOnlyOnAdminMux(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newMux().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the API to answer /debug/vars with 404, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	newAdminMux().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "deduplicated_requests") {
+		t.Errorf("Expected the admin listener to publish the counters, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestFlowDeduplicatedSurvivesLeaderDisconnect disconnects the client of
+// the request computing a flow map while an identical request waits for
+// it, and checks the computation goes on for the one still waiting, then
+// that a computation is cancelled once both its clients are gone.
+func TestFlowDeduplicatedSurvivesLeaderDisconnect(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	var before int64
+	var disconnect []context.CancelFunc
+	var bothGone bool
+	var cancelled atomic.Bool
+	old := generateFlowMap
+	generateFlowMap = func(ctx context.Context, paths []string, resolutionFactor int, opts flow.FlowOptions) (flow.FlowResult, error) {
+		for deadline := time.Now().Add(5 * time.Second); dedupedRequests.Value() < before+1; {
+			if time.Now().After(deadline) {
+				t.Error("Timed out waiting for the duplicate request")
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if !bothGone {
+			disconnect[0]()
+			time.Sleep(20 * time.Millisecond)
+			if ctx.Err() != nil {
+				t.Error("Expected the computation to go on while a request waits for it")
+			}
+			return old(ctx, paths, resolutionFactor, opts)
+		}
+		for _, cancel := range disconnect {
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			cancelled.Store(true)
+		case <-time.After(5 * time.Second):
+		}
+		return flow.FlowResult{}, ctx.Err()
+	}
+	t.Cleanup(func() { generateFlowMap = old })
+
+	body := `{"api_version": 2, "image_paths": ` + versionTestFrames + `, "options": {"resolution_factor": 8}}`
+	// post sends two identical requests, the second once the first is
+	// computing, and returns their responses.
+	post := func() [2]*httptest.ResponseRecorder {
+		before = dedupedRequests.Value()
+		var rrs [2]*httptest.ResponseRecorder
+		var ctxs [2]context.Context
+		disconnect = make([]context.CancelFunc, 2)
+		for i := range ctxs {
+			ctxs[i], disconnect[i] = context.WithCancel(context.Background())
+			rrs[i] = httptest.NewRecorder()
+		}
+		var wg sync.WaitGroup
+		for i := range rrs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodPost, "/flow", strings.NewReader(body)).WithContext(ctxs[i])
+				flowHandler(rrs[i], req)
+			}()
+			if i == 0 {
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+		wg.Wait()
+		for _, cancel := range disconnect {
+			cancel()
+		}
+		return rrs
+	}
+
+	if rr := post()[1]; rr.Code != http.StatusOK {
+		t.Errorf("Expected the waiting request to get the flow map, got %d: %s", rr.Code, rr.Body.String())
+	}
+	bothGone = true
+	post()
+	if !cancelled.Load() {
+		t.Error("Expected the computation to be cancelled once no request waits for it")
+	}
+}
+
+func TestRequestKeyTracksModificationTime(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureFrame(t, dir, "a.png", 4, 4)
+	writeFixtureFrame(t, dir, "b.png", 4, 4)
+	paths := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png")}
+	key := func(params any, paths ...string) string {
+		t.Helper()
+		k, err := requestKey("/flow", params, paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	first := key(4, paths...)
+	if again := key(4, paths...); again != first {
+		t.Error("Expected identical requests to share a key")
+	}
+	if key(8, paths...) == first {
+		t.Error("Expected different parameters to change the key")
+	}
+	if key(4, paths[1], paths[0]) == first {
+		t.Error("Expected the order of the paths to change the key")
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(paths[1], later, later); err != nil {
+		t.Fatal(err)
+	}
+	if key(4, paths...) == first {
+		t.Error("Expected a modified file to change the key")
+	}
+}
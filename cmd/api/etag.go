@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentRehashInterval is how long the content hash of a file whose size
+// and modification time have not changed is trusted before the file is
+// read again, which catches a rewrite that kept both.
+const contentRehashInterval = 5 * time.Minute
+
+// maxContentHashes is the most files whose content hash is kept.
+const maxContentHashes = 4096
+
+// contentHashes caches the content hashes of the files requests read.
+var contentHashes = hashCache{entries: make(map[string]hashEntry), now: time.Now}
+
+// hashCache holds the SHA-256 of files, revalidated cheaply by their size
+// and modification time and rehashed every contentRehashInterval.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashEntry
+	now     func() time.Time
+}
+
+// hashEntry is the content hash of a file as it was at hashedAt.
+type hashEntry struct {
+	size     int64
+	modTime  time.Time
+	sum      [sha256.Size]byte
+	hashedAt time.Time
+}
+
+// hash returns the SHA-256 of the file at path, reading it only if it is
+// not cached, its size or modification time changed, or the cached hash is
+// older than contentRehashInterval.
+func (c *hashCache) hash(path string) ([sha256.Size]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	now := c.now()
+	c.mu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) && now.Sub(e.hashedAt) < contentRehashInterval {
+		return e.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	e = hashEntry{size: info.Size(), modTime: info.ModTime(), hashedAt: now}
+	h.Sum(e.sum[:0])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[path]; !ok && len(c.entries) >= maxContentHashes {
+		// Make room by dropping an arbitrary entry.
+		for old := range c.entries {
+			delete(c.entries, old)
+			break
+		}
+	}
+	c.entries[path] = e
+	return e.sum, nil
+}
+
+// contentETag returns a strong ETag for a response to endpoint that is
+// decided by params and the contents of the files at paths, in order. Like
+// requestKey, params must encode to JSON deterministically. It fails if a
+// file cannot be read.
+func contentETag(endpoint string, params any, paths []string) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	if err := enc.Encode(endpoint); err != nil {
+		return "", err
+	}
+	if err := enc.Encode(params); err != nil {
+		return "", fmt.Errorf("failed to encode request parameters: %w", err)
+	}
+	for _, path := range paths {
+		sum, err := contentHashes.hash(filepath.Clean(path))
+		if err != nil {
+			return "", err
+		}
+		h.Write(sum[:])
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
+
+// notModified reports whether the If-None-Match header of r lists etag, or
+// is "*". Entity tags are compared weakly, ignoring a W/ prefix, as
+// If-None-Match requires.
+func notModified(r *http.Request, etag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"example/goflow/flow"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubFlowMap replaces generateFlowMap with a backend that returns an empty
+// flow map and counts its calls.
+func stubFlowMap(t *testing.T) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	old := generateFlowMap
+	generateFlowMap = func(ctx context.Context, paths []string, resolutionFactor int, opts flow.FlowOptions) (flow.FlowResult, error) {
+		calls.Add(1)
+		return flow.FlowResult{Image: image.NewNRGBA(image.Rect(0, 0, 2, 2))}, nil
+	}
+	t.Cleanup(func() { generateFlowMap = old })
+	return &calls
+}
+
+// postFlowIfNoneMatch sends body to the /flow handler with an
+// If-None-Match header, if etag is not empty.
+func postFlowIfNoneMatch(t *testing.T, body, etag string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/flow", strings.NewReader(body))
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rr := httptest.NewRecorder()
+	flowHandler(rr, req)
+	return rr
+}
+
+// TestFlowConditionalRequest checks that a repeated /flow request is
+// answered 304 without computing the flow, and that changing a frame
+// changes the ETag.
+func TestFlowConditionalRequest(t *testing.T) {
+	calls := stubFlowMap(t)
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	writeFixtureFrame(t, dir, "a.png", 4, 4)
+	writeFixtureFrame(t, dir, "b.png", 4, 4)
+	body := `{"api_version": 2, "image_paths": ["` + filepath.Join(dir, "a.png") + `", "` + filepath.Join(dir, "b.png") + `"]}`
+
+	first := postFlowIfNoneMatch(t, body, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Fatalf("Expected 200 with a strong ETag, got %d with %q", first.Code, etag)
+	}
+	second := postFlowIfNoneMatch(t, body, etag)
+	if second.Code != http.StatusNotModified || second.Header().Get("ETag") != etag || second.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304 with ETag %s, got %d with %q", etag, second.Code, second.Header().Get("ETag"))
+	}
+	if got := postFlowIfNoneMatch(t, body, `"other", W/`+etag); got.Code != http.StatusNotModified {
+		t.Errorf("Expected a weak match in a list to give 304, got %d", got.Code)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected the flow to be computed once, got %d", n)
+	}
+	if got := postFlowIfNoneMatch(t, `{"api_version": 2, "image_paths": ["`+filepath.Join(dir, "a.png")+`", "`+filepath.Join(dir, "b.png")+`"], "options": {"flow_depth": 16}}`, etag); got.Code != http.StatusOK || got.Header().Get("ETag") == etag {
+		t.Errorf("Expected other options to give 200 with another ETag, got %d with %q", got.Code, got.Header().Get("ETag"))
+	}
+
+	writeFixtureFrame(t, dir, "b.png", 4, 6)
+	third := postFlowIfNoneMatch(t, body, etag)
+	if third.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after a frame changed, got %d: %s", third.Code, third.Body.String())
+	}
+	if got := third.Header().Get("ETag"); got == "" || got == etag {
+		t.Errorf("Expected a new ETag after a frame changed, got %q", got)
+	}
+}
+
+// TestHashCacheRehash checks that a content hash is revalidated by size and
+// modification time, and that a rewrite keeping both is caught once the
+// hash is contentRehashInterval old.
+func TestHashCacheRehash(t *testing.T) {
+	now := time.Date(2025, 10, 3, 12, 0, 0, 0, time.UTC)
+	c := hashCache{entries: make(map[string]hashEntry), now: func() time.Time { return now }}
+	path := filepath.Join(t.TempDir(), "frame.png")
+	mtime := now.Add(-time.Hour)
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func() [32]byte {
+		t.Helper()
+		sum, err := c.hash(path)
+		if err != nil {
+			t.Fatalf("hash failed: %v", err)
+		}
+		return sum
+	}
+
+	write("first")
+	first := hash()
+	// The same size and modification time trust the cached hash...
+	write("other")
+	if hash() != first {
+		t.Error("Expected the cached hash while the size and modification time are unchanged")
+	}
+	// ...until it is due for a rehash.
+	now = now.Add(contentRehashInterval)
+	second := hash()
+	if second == first {
+		t.Error("Expected a rehash to catch the rewrite")
+	}
+	// A new modification time is noticed at once.
+	mtime = mtime.Add(time.Minute)
+	write("third")
+	if hash() == second {
+		t.Error("Expected a changed modification time to rehash the file")
+	}
+
+	if _, err := c.hash(filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"example/goflow/fileutil"
+	"example/goflow/flow/synth"
+	"example/goflow/trace"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// production disables the /examples endpoints, which are meant for
+// developers trying the API out.
+var production bool
+
+// exampleFramesDir is the subdirectory of the data directory that holds
+// the synthetic frames of the examples when the data directory has none.
+// The frame listing only reads the data directory itself, so they never
+// show up in /frames or /latest.
+const exampleFramesDir = "examples"
+
+// exampleFrameCount is the most frames an example /flow or /tracks request
+// lists.
+const exampleFrameCount = 3
+
+// exampleEndpoint is an endpoint with examples: the handler that serves it
+// and how to build an example request body from the available frames, which
+// are sorted by timestamp and never empty.
+type exampleEndpoint struct {
+	handler http.HandlerFunc
+	body    func(frames []FrameInfo) any
+}
+
+var exampleEndpoints = map[string]exampleEndpoint{
+	"flow":   {flowHandler, exampleFlowRequest},
+	"trace":  {traceHandler, exampleTraceRequest},
+	"tracks": {tracksHandler, exampleTracksRequest},
+}
+
+// exampleFlowRequest returns a version 2 /flow request over the newest
+// frames.
+func exampleFlowRequest(frames []FrameInfo) any {
+	return FlowRequestV2{APIVersion: 2, ImagePaths: exampleSequence(frames)}
+}
+
+// exampleTracksRequest returns a /tracks request over the newest frames,
+// rendered over the last one.
+func exampleTracksRequest(frames []FrameInfo) any {
+	return TracksRequest{ImagePaths: exampleSequence(frames), Render: &TracksRender{Overlay: true}}
+}
+
+// exampleTraceRequest returns a version 2 /trace request searching east
+// from the centre of the newest frame to a little short of its edge.
+func exampleTraceRequest(frames []FrameInfo) any {
+	frame := frames[len(frames)-1]
+	return TraceRequestV2{
+		APIVersion:          2,
+		ImagePath:           frame.Path,
+		Origin:              trace.Point{X: float64(frame.Width / 2), Y: float64(frame.Height / 2)},
+		Direction:           trace.Point{X: 1, Y: 0},
+		FieldOfViewAngleDEG: 30,
+		Distance:            math.Max(1, float64(frame.Width/2-1)),
+	}
+}
+
+// exampleSequence returns the paths of the newest frames, up to
+// exampleFrameCount of them, that share the size of the newest one.
+func exampleSequence(frames []FrameInfo) []string {
+	newest := frames[len(frames)-1]
+	var paths []string
+	for i := len(frames) - 1; i >= 0 && len(paths) < exampleFrameCount; i-- {
+		if frames[i].Width == newest.Width && frames[i].Height == newest.Height {
+			paths = append(paths, frames[i].Path)
+		}
+	}
+	slices.Reverse(paths) // back into timestamp order
+	return paths
+}
+
+// exampleFrames returns the frames the examples use: those in the data
+// directory if at least two share the size of the newest, or else
+// synthetic frames written to exampleFramesDir.
+func exampleFrames() ([]FrameInfo, error) {
+	list, err := framesCache.list(dataDir)
+	if err == nil && len(list) > 0 && len(exampleSequence(list)) >= 2 {
+		return list, nil
+	}
+	return syntheticExampleFrames(filepath.Join(dataDir, exampleFramesDir))
+}
+
+// syntheticFramesMu keeps concurrent requests from writing the synthetic
+// frames at once.
+var syntheticFramesMu sync.Mutex
+
+// syntheticExampleFrames writes a short sequence of synthetic frames, a
+// texture drifting by (2, 1) pixels a frame, to dir unless it is already
+// there and returns it.
+func syntheticExampleFrames(dir string) ([]FrameInfo, error) {
+	syntheticFramesMu.Lock()
+	defer syntheticFramesMu.Unlock()
+	const size = 128
+	images, _ := synth.GenerateSequence(synth.MotionSpec{
+		Background: synth.Motion{Translate: synth.Point{X: 2, Y: 1}},
+	}, exampleFrameCount, size, size)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the example frames directory: %w", err)
+	}
+	start := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	frames := make([]FrameInfo, len(images))
+	for i, img := range images {
+		ts := start.Add(time.Duration(i) * 5 * time.Minute)
+		path := filepath.Join(dir, ts.Format(time.RFC3339)+".png")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := fileutil.WritePNG(path, img, false); err != nil {
+				return nil, fmt.Errorf("failed to write example frame: %w", err)
+			}
+		}
+		frames[i] = FrameInfo{Path: filepath.ToSlash(path), Timestamp: ts, Width: size, Height: size}
+	}
+	return frames, nil
+}
+
+// rateLimiter is a token bucket: it allows burst requests at once and
+// refills at rate requests per second.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newRateLimiter returns a full limiter allowing perMinute requests a
+// minute, burst of them at once.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{rate: float64(perMinute) / 60, burst: float64(burst), tokens: float64(burst), now: time.Now}
+}
+
+// allow takes a token if one is left. Otherwise it reports how long until
+// the next one.
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// exampleRuns limits POST /examples/{endpoint}/run, since every run does
+// the full work of the endpoint.
+var exampleRuns = newRateLimiter(6, 3)
+
+// examplesHandler routes the example endpoints:
+//
+//	GET  /examples/{endpoint}      an example request body for the endpoint
+//	POST /examples/{endpoint}/run  send that request and return its response
+//
+// The examples list the newest frames of the data directory, or synthetic
+// frames when it has too few. They are disabled under -production.
+func examplesHandler(w http.ResponseWriter, r *http.Request) {
+	if production {
+		http.NotFound(w, r)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/examples/")
+	name, action, _ := strings.Cut(rest, "/")
+	endpoint, ok := exampleEndpoints[name]
+	if !ok || (action != "" && action != "run") {
+		http.NotFound(w, r)
+		return
+	}
+	method := http.MethodGet
+	if action == "run" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		http.Error(w, fmt.Sprintf("Only %s method is allowed", method), http.StatusMethodNotAllowed)
+		return
+	}
+	if action == "run" {
+		if ok, wait := exampleRuns.allow(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many example runs; try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	frames, err := exampleFrames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(endpoint.body(frames))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if action == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, "/"+name, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	endpoint.handler(w, req)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveExample sends a bodiless request through the API's mux.
+func serveExample(t *testing.T, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	newMux().ServeHTTP(rr, req)
+	return rr
+}
+
+// useExampleRuns rate-limits example runs with limiter for the duration of
+// the test.
+func useExampleRuns(t *testing.T, limiter *rateLimiter) {
+	t.Helper()
+	old := exampleRuns
+	exampleRuns = limiter
+	t.Cleanup(func() { exampleRuns = old })
+}
+
+func TestTraceExample(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureFrame(t, dir, "2025-10-03T14:40:00Z.png", 64, 48)
+	writeFixtureFrame(t, dir, "2025-10-03T14:45:00Z.png", 64, 48)
+	useDataDir(t, dir)
+	useExampleRuns(t, newRateLimiter(6, 3))
+
+	rr := serveExample(t, http.MethodGet, "/examples/trace")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /examples/trace returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var example TraceRequestV2
+	if err := json.NewDecoder(rr.Body).Decode(&example); err != nil {
+		t.Fatalf("Failed to decode the example: %v", err)
+	}
+	if !strings.HasSuffix(example.ImagePath, "2025-10-03T14:45:00Z.png") {
+		t.Errorf("Expected the example to use the newest frame, got %q", example.ImagePath)
+	}
+	if _, err := os.Stat(example.ImagePath); err != nil {
+		t.Errorf("Expected the example's image to exist: %v", err)
+	}
+
+	rr = serveExample(t, http.MethodPost, "/examples/trace/run")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /examples/trace/run returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp TraceResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode the trace response: %v", err)
+	}
+	if len(resp.Projection) == 0 {
+		t.Error("Expected a projection from the example trace")
+	}
+}
+
+// TestFlowExampleSynthetic checks that an empty data directory gets
+// synthetic frames the example flow request can run on, without them
+// showing up as frames.
+func TestFlowExampleSynthetic(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	useExampleRuns(t, newRateLimiter(6, 3))
+
+	rr := serveExample(t, http.MethodGet, "/examples/flow")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /examples/flow returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var example FlowRequestV2
+	if err := json.NewDecoder(rr.Body).Decode(&example); err != nil {
+		t.Fatalf("Failed to decode the example: %v", err)
+	}
+	if len(example.ImagePaths) != exampleFrameCount {
+		t.Fatalf("Expected %d synthetic frames, got %q", exampleFrameCount, example.ImagePaths)
+	}
+	for _, path := range example.ImagePaths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected synthetic frame %s to exist: %v", path, err)
+		}
+	}
+	if frames := getFrames(t, ""); len(frames) != 0 {
+		t.Errorf("Expected the synthetic frames to stay out of /frames, got %v", frames)
+	}
+
+	rr = serveExample(t, http.MethodPost, "/examples/flow/run")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("POST /examples/flow/run returned %d (%s): %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+}
+
+func TestExamplesRateLimitAndProduction(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureFrame(t, dir, "2025-10-03T14:40:00Z.png", 32, 32)
+	writeFixtureFrame(t, dir, "2025-10-03T14:45:00Z.png", 32, 32)
+	useDataDir(t, dir)
+	now := time.Date(2025, 10, 3, 15, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(6, 1)
+	limiter.now = func() time.Time { return now }
+	useExampleRuns(t, limiter)
+
+	if rr := serveExample(t, http.MethodPost, "/examples/trace/run"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the first run to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := serveExample(t, http.MethodPost, "/examples/trace/run")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected 429 with Retry-After 10, got %d and %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	// Getting an example is not limited.
+	if rr := serveExample(t, http.MethodGet, "/examples/trace"); rr.Code != http.StatusOK {
+		t.Errorf("Expected GET to stay available, got %d", rr.Code)
+	}
+	now = now.Add(10 * time.Second)
+	if rr := serveExample(t, http.MethodPost, "/examples/trace/run"); rr.Code != http.StatusOK {
+		t.Errorf("Expected a run once the limit refilled, got %d", rr.Code)
+	}
+
+	if rr := serveExample(t, http.MethodGet, "/examples/frames"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an endpoint without examples, got %d", rr.Code)
+	}
+
+	production = true
+	t.Cleanup(func() { production = false })
+	if rr := serveExample(t, http.MethodGet, "/examples/trace"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 under -production, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"example/goflow/frames"
+	"fmt"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FrameInfo describes one radar frame available under the data directory.
+type FrameInfo struct {
+	Path      string    `json:"path"`
+	Timestamp time.Time `json:"timestamp"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// frameCache holds the most recent listing of a data directory. It is
+// rebuilt whenever the directory's modification time changes, which happens
+// whenever a frame is added, removed or renamed.
+type frameCache struct {
+	mu     sync.Mutex
+	dir    string
+	mtime  time.Time
+	frames []FrameInfo
+}
+
+var framesCache frameCache
+
+// list returns the frames in dir sorted by timestamp, using the cached
+// listing when the directory has not changed since it was built.
+func (c *frameCache) list(dir string) ([]FrameInfo, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat data directory: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frames != nil && c.dir == dir && c.mtime.Equal(info.ModTime()) {
+		return c.frames, nil
+	}
+
+	list, err := scanFrames(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.dir = dir
+	c.mtime = info.ModTime()
+	c.frames = list
+	return list, nil
+}
+
+// scanFrames reads the PNG frames directly inside dir. Files whose names do
+// not carry a timestamp are skipped, as are symlinks that resolve outside dir.
+func scanFrames(dir string) ([]FrameInfo, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve data directory: %w", err)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve data directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	list := make([]FrameInfo, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.EqualFold(filepath.Ext(name), ".png") {
+			continue
+		}
+		ts, err := frames.ParseTimestamp(name)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		if resolved, err = filepath.Abs(resolved); err != nil || !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+			continue
+		}
+
+		frame, err := readFrameInfo(path)
+		if err != nil {
+			continue
+		}
+		frame.Path = filepath.ToSlash(path)
+		frame.Timestamp = ts
+		list = append(list, frame)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Timestamp.Before(list[j].Timestamp)
+	})
+	return list, nil
+}
+
+// readFrameInfo reads the size of a PNG file and its dimensions from the
+// image header without decoding the pixel data.
+func readFrameInfo(path string) (FrameInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FrameInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return FrameInfo{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		return FrameInfo{}, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	cfg, err := png.DecodeConfig(file)
+	if err != nil {
+		return FrameInfo{}, fmt.Errorf("failed to read PNG header of %s: %w", path, err)
+	}
+	return FrameInfo{Width: cfg.Width, Height: cfg.Height, Bytes: stat.Size()}, nil
+}
+
+// framesHandler lists the frames under the data directory. The optional
+// query parameters after and before (RFC 3339) restrict the listing to
+// frames strictly inside that time range, and a positive limit caps the
+// number of frames returned.
+func framesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var after, before time.Time
+	var err error
+	if s := query.Get("after"); s != "" {
+		if after, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := query.Get("before"); s != "" {
+		if before, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	all, err := framesCache.list(dataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]FrameInfo, 0, len(all))
+	for _, frame := range all {
+		if !after.IsZero() && !frame.Timestamp.After(after) {
+			continue
+		}
+		if !before.IsZero() && !frame.Timestamp.Before(before) {
+			continue
+		}
+		resp = append(resp, frame)
+		if limit > 0 && len(resp) == limit {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
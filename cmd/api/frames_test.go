@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFixtureFrame writes a blank PNG of the given size to dir/name.
+func writeFixtureFrame(t *testing.T, dir, name string, width, height int) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("Failed to create fixture %s: %v", name, err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode fixture %s: %v", name, err)
+	}
+}
+
+// useDataDir points the API at dir for the duration of the test.
+func useDataDir(t *testing.T, dir string) {
+	t.Helper()
+	old := dataDir
+	dataDir = dir
+	t.Cleanup(func() { dataDir = old })
+}
+
+func getFrames(t *testing.T, query string) []FrameInfo {
+	t.Helper()
+	req, err := http.NewRequest("GET", "/frames"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(framesHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /frames%s returned %d: %s", query, rr.Code, rr.Body.String())
+	}
+	var resp []FrameInfo
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestFramesHandler(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureFrame(t, dir, "2025-10-03T14:50:00Z.png", 32, 16)
+	writeFixtureFrame(t, dir, "2025-10-03T14:40:00Z.png", 8, 8)
+	writeFixtureFrame(t, dir, "2025-10-03T14:45:00Z.png", 16, 24)
+	// Files without a timestamp in the name are not frames.
+	writeFixtureFrame(t, dir, "legend.png", 4, 4)
+	useDataDir(t, dir)
+
+	all := getFrames(t, "")
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 frames, got %d: %+v", len(all), all)
+	}
+
+	first := all[0]
+	if first.Path != filepath.ToSlash(filepath.Join(dir, "2025-10-03T14:40:00Z.png")) {
+		t.Errorf("Unexpected path %q", first.Path)
+	}
+	if !first.Timestamp.Equal(time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamp %v", first.Timestamp)
+	}
+	if first.Width != 8 || first.Height != 8 {
+		t.Errorf("Expected 8x8, got %dx%d", first.Width, first.Height)
+	}
+	stat, err := os.Stat(filepath.Join(dir, "2025-10-03T14:40:00Z.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Bytes != stat.Size() {
+		t.Errorf("Expected %d bytes, got %d", stat.Size(), first.Bytes)
+	}
+	if all[1].Width != 16 || all[1].Height != 24 || all[2].Width != 32 || all[2].Height != 16 {
+		t.Errorf("Frames not sorted by timestamp: %+v", all)
+	}
+
+	filtered := getFrames(t, "?after=2025-10-03T14:40:00Z&before=2025-10-03T14:50:00Z")
+	if len(filtered) != 1 || !filtered[0].Timestamp.Equal(time.Date(2025, 10, 3, 14, 45, 0, 0, time.UTC)) {
+		t.Errorf("Expected only the 14:45 frame, got %+v", filtered)
+	}
+
+	limited := getFrames(t, "?after=2025-10-03T14:40:00Z&limit=1")
+	if len(limited) != 1 || limited[0].Width != 16 {
+		t.Errorf("Expected only the 14:45 frame, got %+v", limited)
+	}
+}
+
+func TestFramesHandlerCacheInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureFrame(t, dir, "2025-10-03T14:40:00Z.png", 8, 8)
+	useDataDir(t, dir)
+
+	if got := len(getFrames(t, "")); got != 1 {
+		t.Fatalf("Expected 1 frame, got %d", got)
+	}
+
+	writeFixtureFrame(t, dir, "2025-10-03T14:45:00Z.png", 8, 8)
+	// Make sure the directory mtime moves even on coarse-grained filesystems.
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(dir, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(getFrames(t, "")); got != 2 {
+		t.Errorf("Expected 2 frames after adding one, got %d", got)
+	}
+}
+
+func TestFramesHandlerSymlinkOutsideRoot(t *testing.T) {
+	outside := t.TempDir()
+	writeFixtureFrame(t, outside, "2025-10-03T14:40:00Z.png", 8, 8)
+
+	dir := t.TempDir()
+	writeFixtureFrame(t, dir, "2025-10-03T14:45:00Z.png", 8, 8)
+	if err := os.Symlink(filepath.Join(outside, "2025-10-03T14:40:00Z.png"), filepath.Join(dir, "2025-10-03T14:40:00Z.png")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	useDataDir(t, dir)
+
+	all := getFrames(t, "")
+	if len(all) != 1 || all[0].Path != filepath.ToSlash(filepath.Join(dir, "2025-10-03T14:45:00Z.png")) {
+		t.Errorf("Expected the symlink outside the data root to be skipped, got %+v", all)
+	}
+}
+
+func TestFramesHandlerInvalidQuery(t *testing.T) {
+	useDataDir(t, t.TempDir())
+	for _, query := range []string{"?after=yesterday", "?before=1", "?limit=-1"} {
+		req, _ := http.NewRequest("GET", "/frames"+query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(framesHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("GET /frames%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"example/goflow/flow"
+	"example/goflow/nowcast"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers on the /latest responses. generatedAtHeader and frameTimeHeader
+// hold RFC 3339 times: when the result was generated and the timestamp of
+// the newest frame it covers. staleHeader is "true" while generation for a
+// newer frame keeps failing, so the result being served lags the data.
+const (
+	generatedAtHeader = flowHeaderPrefix + "Generated-At"
+	frameTimeHeader   = flowHeaderPrefix + "Frame-Time"
+	staleHeader       = flowHeaderPrefix + "Stale"
+)
+
+// latestConfig configures the background nowcast generation.
+type latestConfig struct {
+	// Interval is how often the data directory is polled for new frames.
+	// Zero disables the scheduler.
+	Interval time.Duration
+	// Window is the number of most recent frames each run covers.
+	Window int
+	// LeadTimes are the forecast lead times in minutes served by
+	// /latest/nowcast.
+	LeadTimes []int
+	// ResolutionFactor is the downscaling factor of the flow map, or
+	// flow.AutoResolution to let the flow package choose it.
+	ResolutionFactor int
+	// GridRes is the number of nowcast grid cells on each side.
+	GridRes int
+	// MaxAccelerationFraction bounds the displacement the fitted
+	// accelerations add over the longest lead time to this fraction of the
+	// displacement of the velocities alone. Zero leaves them as fitted.
+	MaxAccelerationFraction float64
+}
+
+// defaultLatestConfig is the configuration used unless flags override it.
+var defaultLatestConfig = latestConfig{
+	Interval:                30 * time.Second,
+	Window:                  6,
+	LeadTimes:               []int{15, 30, 60},
+	ResolutionFactor:        flow.AutoResolution,
+	GridRes:                 64,
+	MaxAccelerationFraction: 0.5,
+}
+
+// validate checks that cfg describes a usable scheduler.
+func (cfg latestConfig) validate() error {
+	if cfg.Interval < 0 {
+		return fmt.Errorf("latest interval must not be negative, got %v", cfg.Interval)
+	}
+	if cfg.Window < 3 {
+		return fmt.Errorf("latest window must be at least 3 frames, got %d", cfg.Window)
+	}
+	if len(cfg.LeadTimes) == 0 {
+		return errors.New("at least one latest lead time is required")
+	}
+	for _, lead := range cfg.LeadTimes {
+		if lead <= 0 {
+			return fmt.Errorf("latest lead times must be positive, got %d", lead)
+		}
+	}
+	if cfg.ResolutionFactor < 0 {
+		return fmt.Errorf("latest resolution factor must not be negative, got %d", cfg.ResolutionFactor)
+	}
+	if cfg.GridRes <= 0 {
+		return fmt.Errorf("latest grid resolution must be positive, got %d", cfg.GridRes)
+	}
+	if !(cfg.MaxAccelerationFraction >= 0) {
+		return fmt.Errorf("latest acceleration fraction must not be negative, got %v", cfg.MaxAccelerationFraction)
+	}
+	return nil
+}
+
+// parseLeadTimes parses a comma-separated list of lead times in minutes.
+func parseLeadTimes(s string) ([]int, error) {
+	var leads []int
+	for _, field := range splitList(s) {
+		lead, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lead time %q: %w", field, err)
+		}
+		leads = append(leads, lead)
+	}
+	return leads, nil
+}
+
+// NowcastCell is the forecast motion of one nowcast grid cell. DX and DY
+// are the displacement in pixels of the cell's contents from the newest
+// frame to the lead time, extrapolated from its fitted velocity and
+// acceleration.
+type NowcastCell struct {
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	DX         float64 `json:"dx"`
+	DY         float64 `json:"dy"`
+	Unreliable bool    `json:"unreliable,omitempty"`
+}
+
+// LatestNowcastResponse is the response to GET /latest/nowcast.
+type LatestNowcastResponse struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	FrameTime   time.Time     `json:"frame_time"`
+	LeadMinutes int           `json:"lead_minutes"`
+	GridRes     int           `json:"grid_res"`
+	Cells       []NowcastCell `json:"cells"`
+	// ClampedAccelerations is the number of cells whose acceleration was
+	// scaled down under latestConfig.MaxAccelerationFraction.
+	ClampedAccelerations int `json:"clamped_accelerations"`
+}
+
+// latestResult is one generated set of /latest responses, encoded up front
+// so requests are served without further work.
+type latestResult struct {
+	generatedAt time.Time
+	frameTime   time.Time
+	flowPNG     []byte
+	// grid is the nowcast's ExtrapolationData in the nowcast binary grid
+	// format.
+	grid     []byte
+	nowcasts map[int]LatestNowcastResponse
+}
+
+// latestScheduler regenerates the /latest results whenever a newer frame
+// appears in the data directory. Only one generation runs at a time: a poll
+// that finds another in progress returns without doing anything, and the
+// next poll picks up whatever frames arrived meanwhile.
+type latestScheduler struct {
+	cfg      latestConfig
+	now      func() time.Time
+	generate func(cfg latestConfig, frames []FrameInfo) (*latestResult, error)
+
+	mu       sync.Mutex
+	running  bool
+	result   *latestResult
+	failures int   // consecutive failed generations since the last success
+	lastErr  error // error of the last failed generation
+}
+
+var latestNowcasts = newLatestScheduler(defaultLatestConfig)
+
+// newLatestScheduler returns a scheduler that has generated nothing yet.
+func newLatestScheduler(cfg latestConfig) *latestScheduler {
+	return &latestScheduler{cfg: cfg, now: time.Now, generate: generateLatest}
+}
+
+// run polls the data directory every s.cfg.Interval, starting immediately,
+// until stop is closed.
+func (s *latestScheduler) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		s.poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll generates a new result if the newest frame in the data directory is
+// not the one the current result covers. Failed generations are retried on
+// every poll.
+func (s *latestScheduler) poll() {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	var covered time.Time
+	if s.result != nil {
+		covered = s.result.frameTime
+	}
+	s.mu.Unlock()
+
+	result, err := s.refresh(covered)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	switch {
+	case err != nil:
+		s.failures++
+		s.lastErr = err
+		if s.failures == 1 {
+			log.Printf("Latest nowcast generation failed: %v", err)
+		}
+	case result != nil:
+		if s.failures > 0 {
+			log.Printf("Latest nowcast generation recovered after %d failures", s.failures)
+		}
+		s.result = result
+		s.failures = 0
+		s.lastErr = nil
+	}
+}
+
+// refresh runs a generation over the newest frames unless the newest frame
+// is covered already, in which case it returns nil and no error.
+func (s *latestScheduler) refresh(covered time.Time) (*latestResult, error) {
+	all, err := framesCache.list(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(all) == 0 || all[len(all)-1].Timestamp.Equal(covered) {
+		return nil, nil
+	}
+	window := all[max(0, len(all)-s.cfg.Window):]
+	result, err := s.generate(s.cfg, window)
+	if err != nil {
+		return nil, err
+	}
+	result.generatedAt = s.now()
+	return result, nil
+}
+
+// generateLatest computes the flow map and nowcasts over frames, which are
+// ordered by timestamp. Frames exceeding the image limits fail the run.
+func generateLatest(cfg latestConfig, frames []FrameInfo) (*latestResult, error) {
+	if len(frames) < 3 {
+		return nil, fmt.Errorf("at least 3 frames are required, but the data directory has %d", len(frames))
+	}
+	paths := make([]string, len(frames))
+	for i, frame := range frames {
+		if err := limits.checkSize(frame.Path, frame.Width, frame.Height); err != nil {
+			return nil, err
+		}
+		paths[i] = frame.Path
+	}
+	last := frames[len(frames)-1].Timestamp
+	// The minutes per frame, averaged over the window.
+	step := last.Sub(frames[0].Timestamp).Minutes() / float64(len(frames)-1)
+	if step <= 0 {
+		return nil, errors.New("frames must have distinct timestamps")
+	}
+
+	flowResult, err := flow.GenerateAverageFlowMapWithOptions(paths, cfg.ResolutionFactor, flow.FlowOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate flow map: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, flowResult.Image); err != nil {
+		return nil, fmt.Errorf("failed to encode flow map: %w", err)
+	}
+
+	// With a time step of one, velocities are in pixels per frame and
+	// accelerations in pixels per frame squared.
+	limit := nowcast.AccelerationLimit{MaxLead: float64(slices.Max(cfg.LeadTimes)) / step, MaxFraction: cfg.MaxAccelerationFraction}
+	data, err := nowcast.ProcessImagesWithOptions(paths, cfg.GridRes, 1, nowcast.Options{AccelerationLimit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nowcast: %w", err)
+	}
+	if data.ClampedAccelerations > 0 {
+		log.Printf("Latest nowcast clamped the accelerations of %d of %d cells", data.ClampedAccelerations, len(data.Data))
+	}
+	var grid bytes.Buffer
+	if err := data.Encode(&grid); err != nil {
+		return nil, fmt.Errorf("failed to encode nowcast grid: %w", err)
+	}
+	result := &latestResult{frameTime: last, flowPNG: buf.Bytes(), grid: grid.Bytes(), nowcasts: make(map[int]LatestNowcastResponse)}
+	for _, lead := range cfg.LeadTimes {
+		t := float64(lead) / step
+		cells := make([]NowcastCell, 0, len(data.Data))
+		for _, pt := range data.Points() {
+			v := data.Data[pt]
+			cells = append(cells, NowcastCell{
+				X:          pt.X,
+				Y:          pt.Y,
+				DX:         v.Vx*t + v.Ax*t*t/2,
+				DY:         v.Vy*t + v.Ay*t*t/2,
+				Unreliable: v.Unreliable,
+			})
+		}
+		result.nowcasts[lead] = LatestNowcastResponse{
+			FrameTime:            last,
+			LeadMinutes:          lead,
+			GridRes:              data.GridRes,
+			ClampedAccelerations: data.ClampedAccelerations,
+			Cells:                cells,
+		}
+	}
+	return result, nil
+}
+
+// latest returns the current result, or writes a 503 response and returns
+// nil if there is none yet. It sets the /latest headers.
+func (s *latestScheduler) latest(w http.ResponseWriter) *latestResult {
+	s.mu.Lock()
+	result, failures, lastErr := s.result, s.failures, s.lastErr
+	s.mu.Unlock()
+
+	if result == nil {
+		msg := "No nowcast has been generated yet"
+		if lastErr != nil {
+			msg += ": " + lastErr.Error()
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return nil
+	}
+	w.Header().Set(generatedAtHeader, result.generatedAt.UTC().Format(time.RFC3339))
+	w.Header().Set(frameTimeHeader, result.frameTime.UTC().Format(time.RFC3339))
+	w.Header().Set(staleHeader, strconv.FormatBool(failures > 0))
+	return result
+}
+
+// flowHandler handles GET /latest/flow with the flow map of the newest
+// frames as a PNG.
+func (s *latestScheduler) flowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result := s.latest(w)
+	if result == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(result.flowPNG)
+}
+
+// gridHandler handles GET /latest/grid with the fitted nowcast grid, its
+// velocities in pixels per frame and accelerations in pixels per frame
+// squared, in the binary format nowcast.ExtrapolationData.Decode reads.
+func (s *latestScheduler) gridHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result := s.latest(w)
+	if result == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(result.grid)
+}
+
+// nowcastHandler handles GET /latest/nowcast. The lead query parameter
+// selects one of the configured lead times in minutes; it defaults to the
+// first.
+func (s *latestScheduler) nowcastHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lead := s.cfg.LeadTimes[0]
+	if v := r.URL.Query().Get("lead"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid lead parameter", http.StatusBadRequest)
+			return
+		}
+		lead = n
+	}
+	if !slices.Contains(s.cfg.LeadTimes, lead) {
+		http.Error(w, fmt.Sprintf("Unsupported lead time %d: supported lead times are %v minutes", lead, s.cfg.LeadTimes), http.StatusBadRequest)
+		return
+	}
+
+	result := s.latest(w)
+	if result == nil {
+		return
+	}
+	resp := result.nowcasts[lead]
+	resp.GeneratedAt = result.generatedAt
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
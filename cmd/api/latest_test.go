@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"example/goflow/nowcast"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// copyFixture copies the sample frame name from rainfall_data into dir,
+// through a temporary file so a concurrent poll never sees it half written.
+func copyFixture(t *testing.T, dir, name string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("../../rainfall_data", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", name, err)
+	}
+	tmp := filepath.Join(dir, "incoming.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		t.Fatalf("Failed to write fixture %s: %v", name, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		t.Fatalf("Failed to move fixture %s into place: %v", name, err)
+	}
+	bumpDirMtime(t, dir)
+}
+
+// bumpDirMtime moves the modification time of dir forward, so the frame
+// listing notices a change even on coarse-grained filesystems.
+func bumpDirMtime(t *testing.T, dir string) {
+	t.Helper()
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(dir, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func latestRequest(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	return rr
+}
+
+// waitForFrameTime polls /latest/flow until it serves a result for the frame
+// at want, failing the test after timeout.
+func waitForFrameTime(t *testing.T, s *latestScheduler, want time.Time, timeout time.Duration) *httptest.ResponseRecorder {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		rr := latestRequest(s.flowHandler, "/latest/flow")
+		if rr.Code == http.StatusOK && rr.Header().Get(frameTimeHeader) == want.Format(time.RFC3339) {
+			return rr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("/latest/flow did not reach frame %v within %v: status %d, frame time %q, body %q", want, timeout, rr.Code, rr.Header().Get(frameTimeHeader), rr.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLatestSchedulerPicksUpNewFrames(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	names := []string{"2025-10-03T14:40:00Z.png", "2025-10-03T14:45:00Z.png", "2025-10-03T14:50:00Z.png", "2025-10-03T14:55:00Z.png"}
+	for _, name := range names[:3] {
+		copyFixture(t, dir, name)
+	}
+
+	cfg := defaultLatestConfig
+	cfg.Interval = 50 * time.Millisecond
+	cfg.Window = 3
+	s := newLatestScheduler(cfg)
+	if rr := latestRequest(s.nowcastHandler, "/latest/nowcast"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before the first generation, got %d", rr.Code)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// Generation itself takes time, so allow it on top of the interval.
+	const timeout = time.Minute
+	first := waitForFrameTime(t, s, time.Date(2025, 10, 3, 14, 50, 0, 0, time.UTC), timeout)
+	if ct := first.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected image/png, got %q", ct)
+	}
+	if stale := first.Header().Get(staleHeader); stale != "false" {
+		t.Errorf("Expected %s: false, got %q", staleHeader, stale)
+	}
+	firstGenerated, err := time.Parse(time.RFC3339, first.Header().Get(generatedAtHeader))
+	if err != nil {
+		t.Fatalf("Invalid %s header: %v", generatedAtHeader, err)
+	}
+
+	copyFixture(t, dir, names[3])
+	newest := time.Date(2025, 10, 3, 14, 55, 0, 0, time.UTC)
+	second := waitForFrameTime(t, s, newest, timeout)
+	secondGenerated, err := time.Parse(time.RFC3339, second.Header().Get(generatedAtHeader))
+	if err != nil {
+		t.Fatalf("Invalid %s header: %v", generatedAtHeader, err)
+	}
+	if secondGenerated.Before(firstGenerated) {
+		t.Errorf("Expected the new result to be generated after %v, got %v", firstGenerated, secondGenerated)
+	}
+
+	rr := latestRequest(s.nowcastHandler, "/latest/nowcast?lead=30")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /latest/nowcast returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp LatestNowcastResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode nowcast: %v", err)
+	}
+	if !resp.FrameTime.Equal(newest) || resp.LeadMinutes != 30 || resp.GridRes != cfg.GridRes {
+		t.Errorf("Expected the 30 minute nowcast for %v on a %d pixel grid, got %v, %d and %d", newest, cfg.GridRes, resp.FrameTime, resp.LeadMinutes, resp.GridRes)
+	}
+	if len(resp.Cells) == 0 {
+		t.Error("Expected nowcast cells")
+	}
+
+	rr = latestRequest(s.gridHandler, "/latest/grid")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /latest/grid returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var grid nowcast.ExtrapolationData
+	if err := grid.Decode(rr.Body); err != nil {
+		t.Fatalf("Failed to decode the grid: %v", err)
+	}
+	if grid.GridRes != cfg.GridRes || len(grid.Data) != len(resp.Cells) {
+		t.Errorf("Expected the %d cells of a %d pixel grid, got %d of %d", len(resp.Cells), cfg.GridRes, len(grid.Data), grid.GridRes)
+	}
+}
+
+func TestLatestSchedulerStale(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	writeFixtureFrame(t, dir, "2025-10-03T14:40:00Z.png", 8, 8)
+
+	s := newLatestScheduler(defaultLatestConfig)
+	generatedAt := time.Date(2025, 10, 3, 14, 41, 0, 0, time.UTC)
+	s.now = func() time.Time { return generatedAt }
+	fail := false
+	calls := 0
+	s.generate = func(cfg latestConfig, frames []FrameInfo) (*latestResult, error) {
+		calls++
+		if fail {
+			return nil, errors.New("flow failed")
+		}
+		last := frames[len(frames)-1].Timestamp
+		return &latestResult{frameTime: last, nowcasts: map[int]LatestNowcastResponse{15: {FrameTime: last, LeadMinutes: 15}}}, nil
+	}
+
+	s.poll()
+	rr := latestRequest(s.flowHandler, "/latest/flow")
+	if rr.Code != http.StatusOK || rr.Header().Get(staleHeader) != "false" {
+		t.Fatalf("Expected a fresh result, got %d with %s %q", rr.Code, staleHeader, rr.Header().Get(staleHeader))
+	}
+	if got := rr.Header().Get(generatedAtHeader); got != generatedAt.Format(time.RFC3339) {
+		t.Errorf("Expected %s %v, got %q", generatedAtHeader, generatedAt, got)
+	}
+
+	// Nothing new: no generation.
+	s.poll()
+	if calls != 1 {
+		t.Errorf("Expected no generation without a new frame, got %d calls", calls)
+	}
+
+	// Generation for a new frame fails on every poll; the old result stays
+	// available, marked as stale.
+	fail = true
+	writeFixtureFrame(t, dir, "2025-10-03T14:45:00Z.png", 8, 8)
+	bumpDirMtime(t, dir)
+	s.poll()
+	s.poll()
+	if calls != 3 {
+		t.Errorf("Expected failed generations to be retried, got %d calls", calls)
+	}
+	rr = latestRequest(s.nowcastHandler, "/latest/nowcast?lead=15")
+	if rr.Code != http.StatusOK || rr.Header().Get(staleHeader) != "true" {
+		t.Errorf("Expected a stale result, got %d with %s %q", rr.Code, staleHeader, rr.Header().Get(staleHeader))
+	}
+	if got := rr.Header().Get(frameTimeHeader); got != "2025-10-03T14:40:00Z" {
+		t.Errorf("Expected the stale result for 14:40, got %q", got)
+	}
+
+	fail = false
+	s.poll()
+	rr = latestRequest(s.flowHandler, "/latest/flow")
+	if rr.Header().Get(staleHeader) != "false" || rr.Header().Get(frameTimeHeader) != "2025-10-03T14:45:00Z" {
+		t.Errorf("Expected a fresh result for 14:45 after recovering, got %s %q for %q", staleHeader, rr.Header().Get(staleHeader), rr.Header().Get(frameTimeHeader))
+	}
+}
+
+func TestLatestSchedulerSingleFlight(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	writeFixtureFrame(t, dir, "2025-10-03T14:40:00Z.png", 8, 8)
+
+	s := newLatestScheduler(defaultLatestConfig)
+	started, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	s.generate = func(cfg latestConfig, frames []FrameInfo) (*latestResult, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		close(started)
+		<-release
+		return &latestResult{frameTime: frames[len(frames)-1].Timestamp}, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.poll()
+		close(done)
+	}()
+	<-started
+	// A poll while the first is generating returns at once.
+	s.poll()
+	close(release)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("Expected one generation, got %d", calls)
+	}
+}
+
+func TestLatestNowcastLeadParameter(t *testing.T) {
+	s := newLatestScheduler(defaultLatestConfig)
+	s.result = &latestResult{nowcasts: map[int]LatestNowcastResponse{}}
+	for _, lead := range []string{"7", "abc"} {
+		if rr := latestRequest(s.nowcastHandler, "/latest/nowcast?lead="+lead); rr.Code != http.StatusBadRequest {
+			t.Errorf("lead=%s: expected 400, got %d", lead, rr.Code)
+		}
+	}
+	if rr := latestRequest(s.nowcastHandler, "/latest/nowcast?lead=60"); rr.Code != http.StatusOK {
+		t.Errorf("lead=60: expected 200, got %d", rr.Code)
+	}
+}
+
+func TestParseLeadTimes(t *testing.T) {
+	leads, err := parseLeadTimes("15, 30,60")
+	if err != nil || len(leads) != 3 || leads[0] != 15 || leads[1] != 30 || leads[2] != 60 {
+		t.Errorf("Expected [15 30 60], got %v, %v", leads, err)
+	}
+	if _, err := parseLeadTimes("15,soon"); err == nil {
+		t.Error("Expected an error for a non-numeric lead time")
+	}
+	cfg := defaultLatestConfig
+	cfg.LeadTimes = []int{0}
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected a zero lead time to be rejected")
+	}
+}
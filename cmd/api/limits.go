@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // So the headers of JPEG frames are checked too
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+)
+
+// imageLimits bounds the images a single request may make the API decode.
+// Image sizes are read from the image header before any pixel data is
+// decoded, so a small file declaring huge dimensions is rejected before it
+// can exhaust memory.
+type imageLimits struct {
+	// MaxWidth and MaxHeight bound each image's dimensions in pixels.
+	MaxWidth, MaxHeight int
+	// MaxPixels bounds each image's width times height.
+	MaxPixels int
+	// MaxImages bounds the number of images listed in one request.
+	MaxImages int
+}
+
+// defaultImageLimits are the limits used unless flags override them.
+var defaultImageLimits = imageLimits{
+	MaxWidth:  4096,
+	MaxHeight: 4096,
+	MaxPixels: 4096 * 4096,
+	MaxImages: 64,
+}
+
+// limits are the image limits enforced by the handlers.
+var limits = defaultImageLimits
+
+// validate checks that every limit is positive.
+func (l imageLimits) validate() error {
+	if l.MaxWidth <= 0 || l.MaxHeight <= 0 || l.MaxPixels <= 0 || l.MaxImages <= 0 {
+		return fmt.Errorf("image limits must be positive, got width %d, height %d, pixels %d and images %d", l.MaxWidth, l.MaxHeight, l.MaxPixels, l.MaxImages)
+	}
+	return nil
+}
+
+// Errors reported when a request exceeds the limits.
+var (
+	errImageTooLarge = errors.New("image exceeds the size limits")
+	errTooManyImages = errors.New("too many images in one request")
+)
+
+// The APIError codes of requests exceeding the limits.
+const (
+	codeImageTooLarge = "image_too_large"
+	codeTooManyImages = "too_many_images"
+)
+
+// APIError is a machine-readable error response.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// checkSize checks the dimensions of the image called name.
+func (l imageLimits) checkSize(name string, width, height int) error {
+	if width > l.MaxWidth || height > l.MaxHeight {
+		return fmt.Errorf("%w: %s is %dx%d pixels, more than the %dx%d allowed", errImageTooLarge, name, width, height, l.MaxWidth, l.MaxHeight)
+	}
+	if int64(width)*int64(height) > int64(l.MaxPixels) {
+		return fmt.Errorf("%w: %s has %d pixels, more than the %d allowed", errImageTooLarge, name, int64(width)*int64(height), l.MaxPixels)
+	}
+	return nil
+}
+
+// checkCount checks the number of images listed in a request.
+func (l imageLimits) checkCount(n int) error {
+	if n > l.MaxImages {
+		return fmt.Errorf("%w: got %d, at most %d are allowed", errTooManyImages, n, l.MaxImages)
+	}
+	return nil
+}
+
+// checkFile checks the dimensions in the header of the image at path. A file
+// whose header cannot be read passes, leaving the decoder to report it as it
+// would any other unreadable frame.
+func (l imageLimits) checkFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil
+	}
+	return l.checkSize(path, cfg.Width, cfg.Height)
+}
+
+// checkPaths checks the number of paths and the dimensions of each image.
+func (l imageLimits) checkPaths(paths []string) error {
+	if err := l.checkCount(len(paths)); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := l.checkFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxUploadBytes bounds the body of an uploaded frame: a PNG of MaxPixels at
+// 16-bit RGBA, stored uncompressed, with room for its headers.
+func (l imageLimits) maxUploadBytes() int64 {
+	return 8*int64(l.MaxPixels) + 1<<20
+}
+
+// decodePNG decodes an uploaded PNG, checking the dimensions in its header
+// before decoding the pixel data.
+func (l imageLimits) decodePNG(r io.Reader, name string) (image.Image, error) {
+	var header bytes.Buffer
+	cfg, err := png.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkSize(name, cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	return png.Decode(io.MultiReader(&header, r))
+}
+
+// writeLimitError writes a 413 response with a JSON APIError body and
+// returns true if err reports an exceeded limit.
+func writeLimitError(w http.ResponseWriter, err error) bool {
+	var code string
+	switch {
+	case errors.Is(err, errImageTooLarge):
+		code = codeImageTooLarge
+	case errors.Is(err, errTooManyImages):
+		code = codeTooManyImages
+	default:
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(APIError{Code: code, Message: err.Error()})
+	return true
+}
+
+// withinLimits reports whether paths are within the image limits, writing a
+// 413 response if not.
+func withinLimits(w http.ResponseWriter, paths []string) bool {
+	return !writeLimitError(w, limits.checkPaths(paths))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"example/goflow/trace"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngHeader returns a PNG holding only a valid IHDR chunk for an 8-bit
+// grayscale image of the given size, with no pixel data.
+func pngHeader(width, height uint32) []byte {
+	var ihdr bytes.Buffer
+	ihdr.WriteString("IHDR")
+	binary.Write(&ihdr, binary.BigEndian, width)
+	binary.Write(&ihdr, binary.BigEndian, height)
+	ihdr.Write([]byte{8, 0, 0, 0, 0}) // Bit depth, grayscale, default methods
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(ihdr.Len()-4))
+	buf.Write(ihdr.Bytes())
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr.Bytes()))
+	return buf.Bytes()
+}
+
+// assertLimitError checks that rr is a 413 with an APIError of code.
+func assertLimitError(t *testing.T, rr *httptest.ResponseRecorder, code string) {
+	t.Helper()
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var apiErr APIError
+	if err := json.NewDecoder(rr.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Failed to decode the error body: %v", err)
+	}
+	if apiErr.Code != code || apiErr.Message == "" {
+		t.Errorf("Expected error code %q with a message, got %+v", code, apiErr)
+	}
+}
+
+func TestImageLimitsRejectHugeHeader(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	huge := pngHeader(50000, 50000)
+	hugePath := filepath.Join(dir, "huge.png")
+	if err := os.WriteFile(hugePath, huge, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeFixtureFrame(t, dir, "small.png", 16, 16)
+	smallPath := filepath.Join(dir, "small.png")
+	paths, _ := json.Marshal([]string{smallPath, hugePath})
+
+	t.Run("flow", func(t *testing.T) {
+		assertLimitError(t, postFlow(t, "", `{"image_paths": `+string(paths)+`}`), codeImageTooLarge)
+	})
+
+	t.Run("trace", func(t *testing.T) {
+		body, _ := json.Marshal(TraceRequest{ImagePath: hugePath, Direction: trace.Point{X: 1}, FieldOfViewAngleDEG: 10, Distance: 5})
+		req := httptest.NewRequest(http.MethodPost, "/trace", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		traceHandler(rr, req)
+		assertLimitError(t, rr, codeImageTooLarge)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		rr := sessionRequest(t, "POST", "/flow/session", "application/json", []byte(`{"image_paths": `+string(paths)+`}`))
+		assertLimitError(t, rr, codeImageTooLarge)
+
+		rr = sessionRequest(t, "POST", "/flow/session", "", nil)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Failed to create a session: %d %s", rr.Code, rr.Body.String())
+		}
+		id := decodeSession(t, rr).ID
+		rr = sessionRequest(t, "POST", "/flow/session/"+id+"/frames", "image/png", huge)
+		assertLimitError(t, rr, codeImageTooLarge)
+		rr = sessionRequest(t, "POST", "/flow/session/"+id+"/frames", "application/json", []byte(`{"image_paths": `+string(paths)+`}`))
+		assertLimitError(t, rr, codeImageTooLarge)
+	})
+
+	t.Run("latest", func(t *testing.T) {
+		frames := []FrameInfo{{Path: smallPath, Width: 16, Height: 16}, {Path: smallPath, Width: 16, Height: 16}, {Path: hugePath, Width: 50000, Height: 50000}}
+		if _, err := generateLatest(defaultLatestConfig, frames); !errors.Is(err, errImageTooLarge) {
+			t.Errorf("Expected errImageTooLarge, got %v", err)
+		}
+	})
+}
+
+func TestImageLimitsPixelsAndCount(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	old := limits
+	limits = imageLimits{MaxWidth: 64, MaxHeight: 64, MaxPixels: 1000, MaxImages: 3}
+	t.Cleanup(func() { limits = old })
+
+	// Within the width and height limits but over the pixel count.
+	writeFixtureFrame(t, dir, "a.png", 40, 40)
+	writeFixtureFrame(t, dir, "b.png", 20, 20)
+	a, b := filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png")
+	paths, _ := json.Marshal([]string{b, a})
+	assertLimitError(t, postFlow(t, "", `{"image_paths": `+string(paths)+`}`), codeImageTooLarge)
+
+	paths, _ = json.Marshal([]string{b, b, b, b})
+	assertLimitError(t, postFlow(t, "", `{"image_paths": `+string(paths)+`}`), codeTooManyImages)
+
+	// Images within every limit are processed.
+	paths, _ = json.Marshal([]string{b, b, b})
+	if rr := postFlow(t, "", `{"image_paths": `+string(paths)+`}`); rr.Code == http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected images within the limits to pass, got %s", rr.Body.String())
+	}
+
+	if err := (imageLimits{MaxWidth: 1, MaxHeight: 1, MaxPixels: 1}).validate(); err == nil {
+		t.Error("Expected a zero image count limit to be rejected")
+	}
+}
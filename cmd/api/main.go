@@ -1,37 +1,212 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"example/goflow/flow"
 	"example/goflow/trace"
+	"expvar"
 	"flag"
 	"fmt"
 	"image/png"
 	"log"
 	"math"
 	"net/http"
+	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
 	"gocv.io/x/gocv"
 )
 
+// dataDir is the directory that holds the radar frames served by the API.
+// Request paths must lie inside it.
+var dataDir = "rainfall_data"
+
+// generateFlowMap computes the /flow response, stopping when the request's
+// context is done; tests replace it.
+var generateFlowMap = flow.GenerateAverageFlowMapWithContext
+
+// maxIdleWorkspaces is the most flow workspaces kept between /flow
+// requests.
+const maxIdleWorkspaces = 4
+
+// workspaces holds the flow workspaces of finished /flow requests for the
+// next ones to reuse.
+var workspaces = make(chan *flow.Workspace, maxIdleWorkspaces)
+
+// getWorkspace returns an idle flow workspace, or a new one if there is
+// none.
+func getWorkspace() *flow.Workspace {
+	select {
+	case ws := <-workspaces:
+		return ws
+	default:
+		return flow.NewWorkspace()
+	}
+}
+
+// putWorkspace returns ws, whose results are no longer used, for reuse, or
+// closes it if enough are idle.
+func putWorkspace(ws *flow.Workspace) {
+	select {
+	case workspaces <- ws:
+	default:
+		ws.Close()
+	}
+}
+
+// resolutionFactorHeader reports the resolution factor a flow map was
+// computed at, which is chosen by the flow package unless the request sets
+// one.
+const resolutionFactorHeader = flowHeaderPrefix + "Resolution-Factor"
+
+// verificationHeader carries, for a version 2 request that sets
+// options.verify_path, the FlowVerification of the flow map as JSON.
+const verificationHeader = flowHeaderPrefix + "Verification"
+
+// FlowRequest is the version 1 /flow request body. The resolution factor
+// comes from the "resn" query parameter.
 type FlowRequest struct {
 	ImagePaths []string `json:"image_paths"`
 }
 
+// FlowRequestV2 is the version 2 /flow request body.
+type FlowRequestV2 struct {
+	APIVersion int           `json:"api_version"`
+	ImagePaths []string      `json:"image_paths"`
+	Options    FlowOptionsV2 `json:"options"`
+}
+
+// FlowOptionsV2 holds the options of a version 2 /flow request.
+type FlowOptionsV2 struct {
+	// ResolutionFactor is the downscaling factor. Zero, the default,
+	// chooses the smallest factor that keeps the flow map within
+	// PixelBudget pixels; see flow.AutoResolution. The flow map holds field
+	// pixels at any factor, which the X-Flow-Resolution-Factor header gives.
+	ResolutionFactor int `json:"resolution_factor,omitempty"`
+	// PixelBudget is the pixel budget of an automatic resolution factor;
+	// zero means flow.DefaultPixelBudget.
+	PixelBudget int `json:"pixel_budget,omitempty"`
+	// FlowScale is the flow map levels per pixel of displacement; zero
+	// means flow.FlowScaleFactor. Lower values encode larger motion.
+	FlowScale float64 `json:"flow_scale,omitempty"`
+	// FlowDepth is the bits per channel of the flow map, 8 (the default)
+	// or 16.
+	FlowDepth int `json:"flow_depth,omitempty"`
+	// Method is "sparse" (the default), "dense" or "fused"; see flow.Method.
+	Method string `json:"method,omitempty"`
+	// Interpolation is "idw" (the default) or "delaunay"; see
+	// flow.InterpolationMode.
+	Interpolation string `json:"interpolation,omitempty"`
+	SkipBadFrames bool   `json:"skip_bad_frames,omitempty"`
+	// MinSurvivingFeatures is the fewest features the flow map may be
+	// interpolated from; zero means flow.DefaultMinSurvivingFeatures and a
+	// negative value accepts any number. A sequence that keeps fewer is
+	// answered with a 422 too_few_features error.
+	MinSurvivingFeatures int `json:"min_surviving_features,omitempty"`
+	// VerifyPath, if set, is the frame held out after the image paths.
+	// The last frame is warped one frame interval ahead with the flow and
+	// scored against it, and the result reported in the
+	// X-Flow-Verification header; see flow.VerifyFlow.
+	VerifyPath string `json:"verify_path,omitempty"`
+}
+
+// FlowVerification is the X-Flow-Verification header of a /flow response:
+// flow.VerificationReport with the grey-level errors of the warped last
+// frame and of persistence against options.verify_path.
+type FlowVerification struct {
+	ForecastMSE    float64 `json:"forecast_mse"`
+	PersistenceMSE float64 `json:"persistence_mse"`
+	MSEImprovement float64 `json:"mse_improvement"`
+	ForecastCSI    float64 `json:"forecast_csi"`
+	PersistenceCSI float64 `json:"persistence_csi"`
+	CSIImprovement float64 `json:"csi_improvement"`
+	Threshold      float64 `json:"threshold"`
+	Pixels         int     `json:"pixels"`
+}
+
+// TraceRequest is the version 1 /trace request body.
 type TraceRequest struct {
-	ImagePath           string      `json:"image_path"`
-	Origin              trace.Point `json:"origin"`
-	Direction           trace.Point `json:"direction"`
-	FieldOfViewAngleDEG float64     `json:"fov_deg"`
-	Distance            float64     `json:"distance"`
+	ImagePath string      `json:"image_path"`
+	Origin    trace.Point `json:"origin"`
+	Direction trace.Point `json:"direction"`
+	// BearingDEG is the search direction as a compass bearing in degrees
+	// clockwise from north (the top of the image), an alternative to
+	// Direction.
+	BearingDEG          *float64 `json:"bearing_deg,omitempty"`
+	FieldOfViewAngleDEG float64  `json:"fov_deg"`
+	Distance            float64  `json:"distance"`
+	// Mode selects "project" (the default) for an angular search profile,
+	// "2d" for a map of the wedge split across the direction as well as
+	// along it, or "march" for the raw samples along the centreline.
+	Mode string `json:"mode,omitempty"`
+	// StepSize is the sample spacing in pixels for "march"; default 1.
+	StepSize float64 `json:"step_size,omitempty"`
+	// AcrossBins is the number of bins across the wedge for "2d"; default 8.
+	AcrossBins int `json:"across_bins,omitempty"`
+}
+
+// TraceRequestV2 is the version 2 /trace request body. The mode and step
+// size move into Options.
+type TraceRequestV2 struct {
+	APIVersion          int            `json:"api_version"`
+	ImagePath           string         `json:"image_path"`
+	Origin              trace.Point    `json:"origin"`
+	Direction           trace.Point    `json:"direction"`
+	BearingDEG          *float64       `json:"bearing_deg,omitempty"`
+	FieldOfViewAngleDEG float64        `json:"fov_deg"`
+	Distance            float64        `json:"distance"`
+	Options             TraceOptionsV2 `json:"options"`
 }
 
+// TraceOptionsV2 holds the options of a version 2 /trace request; see
+// TraceRequest.Mode, TraceRequest.StepSize and TraceRequest.AcrossBins.
+type TraceOptionsV2 struct {
+	Mode       string  `json:"mode,omitempty"`
+	StepSize   float64 `json:"step_size,omitempty"`
+	AcrossBins int     `json:"across_bins,omitempty"`
+}
+
+// TraceResponse is the response to a "project" mode trace: the fields of
+// trace.ProjectionResult, and the warnings.
 type TraceResponse struct {
-	Projection []float64      `json:"projection"`
-	Triangle   trace.Triangle `json:"triangle"`
+	trace.ProjectionResult
+	Warnings []APIWarning `json:"warnings,omitempty"`
+}
+
+// TraceProjection2DResponse is the response to a "2d" mode trace: the
+// fields of trace.FanResult, and the warnings.
+type TraceProjection2DResponse struct {
+	trace.FanResult
+	Warnings []APIWarning `json:"warnings,omitempty"`
+}
+
+// defaultAcrossBins is the number of across bins of a "2d" trace that sets
+// none.
+const defaultAcrossBins = 8
+
+// TraceMarchResponse is the response to a "march" mode trace: the fields of
+// trace.RaySampleResult, and the warnings.
+type TraceMarchResponse struct {
+	trace.RaySampleResult
+	Warnings []APIWarning `json:"warnings,omitempty"`
+}
+
+// warningList returns warning as a list for a JSON response, or nil.
+func warningList(warning *APIWarning) []APIWarning {
+	if warning == nil {
+		return nil
+	}
+	return []APIWarning{*warning}
+}
+
+// insideDataDir reports whether path, once cleaned, lies inside dataDir.
+func insideDataDir(path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), filepath.Clean(dataDir)+string(filepath.Separator))
 }
 
 func traceHandler(w http.ResponseWriter, r *http.Request) {
@@ -41,48 +216,158 @@ func traceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req TraceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var reqV2 TraceRequestV2
+	version, ok := decodeVersioned(w, r, &req, &reqV2)
+	if !ok {
 		return
 	}
+	warning := deprecationWarning(w, version)
+	if version == 2 {
+		req = TraceRequest{
+			ImagePath:           reqV2.ImagePath,
+			Origin:              reqV2.Origin,
+			Direction:           reqV2.Direction,
+			BearingDEG:          reqV2.BearingDEG,
+			FieldOfViewAngleDEG: reqV2.FieldOfViewAngleDEG,
+			Distance:            reqV2.Distance,
+			Mode:                reqV2.Options.Mode,
+			StepSize:            reqV2.Options.StepSize,
+			AcrossBins:          reqV2.Options.AcrossBins,
+		}
+	}
+	if req.Mode != "" && req.Mode != "project" && req.Mode != "2d" && req.Mode != "march" {
+		http.Error(w, "Unknown trace mode", http.StatusBadRequest)
+		return
+	}
+	if req.BearingDEG != nil {
+		if req.Direction != (trace.Point{}) {
+			http.Error(w, "Specify either direction or bearing_deg, not both", http.StatusBadRequest)
+			return
+		}
+		req.Direction = trace.DirectionFromBearing(*req.BearingDEG)
+	}
 
 	cleanPath := filepath.Clean(req.ImagePath)
-	if !strings.HasPrefix(cleanPath, "rainfall_data/") {
+	if !insideDataDir(cleanPath) {
 		http.Error(w, "Invalid image path", http.StatusBadRequest)
 		return
 	}
-
-	mat := gocv.IMRead(cleanPath, gocv.IMReadGrayScale)
-	if mat.Empty() {
-		http.Error(w, "Failed to read image", http.StatusInternalServerError)
+	if !withinLimits(w, []string{cleanPath}) {
 		return
 	}
-	defer mat.Close()
 
-	img := make([][]float64, mat.Rows())
-	for i := 0; i < mat.Rows(); i++ {
-		img[i] = make([]float64, mat.Cols())
-		for j := 0; j < mat.Cols(); j++ {
-			img[i][j] = float64(mat.GetUCharAt(i, j))
-		}
-	}
-
-	projection, triangle, err := trace.ProjectAngularSearch(img, req.Origin, req.Direction, req.FieldOfViewAngleDEG*math.Pi/180.0, req.Distance)
+	key, err := requestKey("/trace", struct {
+		Version int
+		Request TraceRequest
+	}{version, req}, []string{cleanPath})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	inflight.serve(r.Context(), w, key, func(_ context.Context, w http.ResponseWriter) {
+		mat := gocv.IMRead(cleanPath, gocv.IMReadGrayScale)
+		if mat.Empty() {
+			http.Error(w, "Failed to read image", http.StatusInternalServerError)
+			return
+		}
+		defer mat.Close()
 
-	resp := TraceResponse{
-		Projection: projection,
-		Triangle:   triangle,
-	}
+		img := make([][]float64, mat.Rows())
+		for i := 0; i < mat.Rows(); i++ {
+			img[i] = make([]float64, mat.Cols())
+			for j := 0; j < mat.Cols(); j++ {
+				img[i][j] = float64(mat.GetUCharAt(i, j))
+			}
+		}
 
+		var resp any
+		switch req.Mode {
+		case "march":
+			stepSize := req.StepSize
+			if stepSize <= 0 {
+				stepSize = 1
+			}
+			result, err := trace.SampleRay(img, req.Origin, req.Direction, req.Distance, stepSize)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resp = TraceMarchResponse{RaySampleResult: result, Warnings: warningList(warning)}
+		case "2d":
+			acrossBins := req.AcrossBins
+			if acrossBins <= 0 {
+				acrossBins = defaultAcrossBins
+			}
+			result, err := trace.SearchFan(img, req.Origin, req.Direction, req.FieldOfViewAngleDEG*math.Pi/180.0, req.Distance, acrossBins, trace.ReduceMax)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp = TraceProjection2DResponse{FanResult: result, Warnings: warningList(warning)}
+		default:
+			result, err := trace.SearchProjection(img, req.Origin, req.Direction, req.FieldOfViewAngleDEG*math.Pi/180.0, req.Distance, trace.ProjectOptions{})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp = TraceResponse{ProjectionResult: result, Warnings: warningList(warning)}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// codeTooFewFeatures is the APIError code of a flow computation that keeps
+// too few features to interpolate a flow map from.
+const codeTooFewFeatures = "too_few_features"
+
+// writeFeatureError writes a 422 response with a JSON APIError body, whose
+// message gives the number of surviving features, and returns true if err
+// wraps flow.ErrTooFewFeatures.
+func writeFeatureError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, flow.ErrTooFewFeatures) {
+		return false
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(APIError{Code: codeTooFewFeatures, Message: err.Error()})
+	return true
+}
+
+// flowOptions returns the flow options of a version 2 /flow request,
+// leaving the resolution factor and verifying frame to the caller.
+func flowOptions(o FlowOptionsV2) (flow.FlowOptions, error) {
+	var opts flow.FlowOptions
+	if o.Method != "" {
+		method, err := flow.ParseMethod(o.Method)
+		if err != nil {
+			return flow.FlowOptions{}, err
+		}
+		opts.Method = method
+	}
+	if o.Interpolation != "" {
+		mode, err := flow.ParseInterpolationMode(o.Interpolation)
+		if err != nil {
+			return flow.FlowOptions{}, err
+		}
+		opts.Interpolation.Mode = mode
+	}
+	opts.SkipBadFrames = o.SkipBadFrames
+	opts.MinSurvivingFeatures = o.MinSurvivingFeatures
+	if opts.MinSurvivingFeatures == 0 {
+		opts.MinSurvivingFeatures = flow.DefaultMinSurvivingFeatures
 	}
+	opts.PixelBudget = o.PixelBudget
+	opts.Encoding.Scale = o.FlowScale
+	if d := o.FlowDepth; d != 0 && d != 8 && d != 16 {
+		return flow.FlowOptions{}, errors.New("flow_depth must be 8 or 16")
+	}
+	opts.Encoding.Depth = o.FlowDepth
+	return opts, nil
 }
 
 func flowHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,44 +377,219 @@ func flowHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req FlowRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var reqV2 FlowRequestV2
+	version, ok := decodeVersioned(w, r, &req, &reqV2)
+	if !ok {
 		return
 	}
+	warning := deprecationWarning(w, version)
+
+	opts := flow.FlowOptions{}
+	var verifyPath string
+	resolutionFactor := flow.AutoResolution
+	if version == 1 {
+		if n, err := strconv.Atoi(r.URL.Query().Get("resn")); err == nil && n > 0 {
+			resolutionFactor = n
+		}
+	} else {
+		req.ImagePaths = reqV2.ImagePaths
+		if n := reqV2.Options.ResolutionFactor; n > 0 {
+			resolutionFactor = n
+		}
+		var err error
+		if opts, err = flowOptions(reqV2.Options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		verifyPath = reqV2.Options.VerifyPath
+	}
 
 	if len(req.ImagePaths) < 2 {
 		http.Error(w, "At least two image paths are required", http.StatusBadRequest)
 		return
 	}
-
-	resolutionFactorStr := r.URL.Query().Get("resn")
-	resolutionFactor, err := strconv.Atoi(resolutionFactorStr)
-	if err != nil || resolutionFactor <= 0 {
-		resolutionFactor = 4
+	// The verifying frame is read like the others, so it is confined to
+	// the data directory and its contents decide the response too.
+	paths := req.ImagePaths
+	if verifyPath != "" {
+		paths = append(slices.Clip(paths), verifyPath)
+	}
+	if !validImagePaths(w, paths) || !withinLimits(w, paths) {
+		return
 	}
 
-	img, err := flow.GenerateAverageFlowMap(req.ImagePaths, resolutionFactor)
+	params := struct {
+		Version          int
+		ResolutionFactor int
+		Options          flow.FlowOptions
+		Verify           bool
+	}{version, resolutionFactor, opts, verifyPath != ""}
+	key, err := requestKey("/flow", params, paths)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	w.Header().Set("Content-Type", "image/png")
-	if err := png.Encode(w, img); err != nil {
-		http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+	// A flow map is decided by the request and the frames' contents. A
+	// frame that cannot be read leaves the response without an ETag, and
+	// the flow computation reports or skips it.
+	etag, err := contentETag("/flow", params, paths)
+	if err != nil {
+		etag = ""
+	}
+	if etag != "" && notModified(r, etag) {
+		setWarningHeader(w, warning)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	inflight.serve(r.Context(), w, key, func(ctx context.Context, w http.ResponseWriter) {
+		// The flow map is the workspace's image, so the workspace is
+		// only put back once it is encoded.
+		ws := getWorkspace()
+		defer putWorkspace(ws)
+		opts.Workspace = ws
+		result, err := generateFlowMap(ctx, req.ImagePaths, resolutionFactor, opts)
+		if writeFeatureError(w, err) {
+			return
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// Every client waiting for the flow map is gone.
+			http.Error(w, "Flow computation cancelled", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var verification []byte
+		if verifyPath != "" {
+			report, err := flow.VerifyFlowResult(result, req.ImagePaths, verifyPath, flow.VerifyOptions{})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if verification, err = json.Marshal(FlowVerification(report)); err != nil {
+				http.Error(w, "Failed to encode verification", http.StatusInternalServerError)
+				return
+			}
+		}
+		setWarningHeader(w, warning)
+		if result.Field != nil {
+			w.Header().Set(resolutionFactorHeader, strconv.Itoa(result.Field.ResolutionFactor))
+		}
+		if verification != nil {
+			w.Header().Set(verificationHeader, string(verification))
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if err := png.Encode(w, result.Image); err != nil {
+			http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// newMux registers the API endpoints.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flow", flowHandler)
+	mux.HandleFunc("/trace", traceHandler)
+	mux.HandleFunc("/frames", framesHandler)
+	mux.HandleFunc("/tracks", tracksHandler)
+	mux.HandleFunc("/flow/session", sessionCreateHandler)
+	mux.HandleFunc("/flow/session/", sessionHandler)
+	mux.HandleFunc("/latest/flow", latestNowcasts.flowHandler)
+	mux.HandleFunc("/latest/nowcast", latestNowcasts.nowcastHandler)
+	mux.HandleFunc("/latest/grid", latestNowcasts.gridHandler)
+	mux.HandleFunc("/examples/", examplesHandler)
+	return mux
+}
+
+// newAdminMux registers the operator endpoints, which are served apart
+// from the API so that they are not exposed with it.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// envOr returns the environment variable name, or fallback if it is unset.
+func envOr(name, fallback string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return fallback
 }
 
 func main() {
 	port := flag.Int("port", 8080, "Port to listen on")
+	flag.StringVar(&dataDir, "data-dir", dataDir, "Directory containing the radar frames")
+	corsOrigins := flag.String("cors-allowed-origins", envOr("CORS_ALLOWED_ORIGINS", ""), "Comma-separated origins allowed to call the API from a browser, or * for any; empty disables CORS (env CORS_ALLOWED_ORIGINS)")
+	corsMethods := flag.String("cors-allowed-methods", envOr("CORS_ALLOWED_METHODS", "GET, POST"), "Comma-separated methods allowed for cross-origin requests (env CORS_ALLOWED_METHODS)")
+	corsHeaders := flag.String("cors-allowed-headers", envOr("CORS_ALLOWED_HEADERS", "Content-Type"), "Comma-separated request headers allowed for cross-origin requests (env CORS_ALLOWED_HEADERS)")
+	corsMaxAge := flag.String("cors-max-age", envOr("CORS_MAX_AGE", "600"), "Seconds browsers may cache a preflight response (env CORS_MAX_AGE)")
+	corsCredentials := flag.Bool("cors-allow-credentials", envOr("CORS_ALLOW_CREDENTIALS", "") == "true", "Allow cross-origin requests with credentials; not allowed with origin * (env CORS_ALLOW_CREDENTIALS)")
+	latestInterval := flag.Duration("latest-interval", defaultLatestConfig.Interval, "How often to poll the data directory for new frames to regenerate /latest from; 0 disables it")
+	latestWindow := flag.Int("latest-window", defaultLatestConfig.Window, "Number of most recent frames /latest covers")
+	latestLeads := flag.String("latest-leads", "15,30,60", "Comma-separated lead times in minutes served by /latest/nowcast")
+	latestAccel := flag.Float64("latest-max-accel-fraction", defaultLatestConfig.MaxAccelerationFraction, "Largest fraction of the velocity displacement the fitted accelerations may add over the longest /latest lead time; 0 leaves them unbounded")
+	flag.IntVar(&limits.MaxWidth, "max-image-width", limits.MaxWidth, "Widest image in pixels a request may use; wider ones are rejected with 413")
+	flag.IntVar(&limits.MaxHeight, "max-image-height", limits.MaxHeight, "Tallest image in pixels a request may use; taller ones are rejected with 413")
+	flag.IntVar(&limits.MaxPixels, "max-image-pixels", limits.MaxPixels, "Most pixels an image a request uses may have; larger ones are rejected with 413")
+	flag.IntVar(&limits.MaxImages, "max-images", limits.MaxImages, "Most images one request may list; longer lists are rejected with 413")
+	adminAddr := flag.String("admin-addr", envOr("ADMIN_ADDR", ""), "Address, such as localhost:6060, to serve the /debug/vars counters on apart from the API; empty disables them (env ADMIN_ADDR)")
+	flag.BoolVar(&production, "production", envOr("PRODUCTION", "") == "true", "Disable the /examples endpoints meant for trying the API out (env PRODUCTION)")
 	flag.Parse()
 
-	http.HandleFunc("/flow", flowHandler)
-	http.HandleFunc("/trace", traceHandler)
+	maxAge, err := strconv.Atoi(*corsMaxAge)
+	if err != nil {
+		log.Fatalf("Invalid CORS max-age %q: %v", *corsMaxAge, err)
+	}
+	cors := corsConfig{
+		AllowedOrigins:   splitList(*corsOrigins),
+		AllowedMethods:   splitList(*corsMethods),
+		AllowedHeaders:   splitList(*corsHeaders),
+		MaxAge:           maxAge,
+		AllowCredentials: *corsCredentials,
+	}
+	if err := cors.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := limits.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	leads, err := parseLeadTimes(*latestLeads)
+	if err != nil {
+		log.Fatal(err)
+	}
+	latest := defaultLatestConfig
+	latest.Interval = *latestInterval
+	latest.Window = *latestWindow
+	latest.LeadTimes = leads
+	latest.MaxAccelerationFraction = *latestAccel
+	if err := latest.validate(); err != nil {
+		log.Fatal(err)
+	}
+	latestNowcasts = newLatestScheduler(latest)
+	if latest.Interval > 0 {
+		go latestNowcasts.run(nil)
+	}
+
+	if *adminAddr != "" {
+		go func() {
+			log.Printf("Starting admin server on %s", *adminAddr)
+			if err := http.ListenAndServe(*adminAddr, newAdminMux()); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("Starting server on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := http.ListenAndServe(addr, cors.wrap(newMux())); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -37,6 +38,7 @@ func calculateMSE(img1, img2 image.Image) (float64, error) {
 }
 
 func TestFlowHandler(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
 	image1Path := "../../rainfall_data/2025-10-03T14:40:00Z.png"
 	image2Path := "../../rainfall_data/2025-10-03T14:45:00Z.png"
 	image3Path := "../../rainfall_data/2025-10-03T14:50:00Z.png"
@@ -232,3 +234,168 @@ func TestTraceHandler_InvalidPath(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 }
+
+func TestTraceHandler_MarchMode(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Failed to change directory to project root: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	imagePath := "rainfall_data/2025-10-03T14:40:00Z.png"
+
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"image_path": imagePath,
+		"origin":     map[string]float64{"X": 10, "Y": 10},
+		"direction":  map[string]float64{"X": 1, "Y": 0},
+		"distance":   5000,
+		"mode":       "march",
+		"step_size":  2,
+	})
+	req, err := http.NewRequest("POST", "/trace", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(traceHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var resp TraceMarchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response body as JSON: %v", err)
+	}
+	if resp.Complete {
+		t.Error("Expected a 5000 pixel ray to leave the image")
+	}
+	if len(resp.Samples) < 2 {
+		t.Fatalf("Expected samples along the ray, got %d", len(resp.Samples))
+	}
+	for i, s := range resp.Samples {
+		if s.Position.X != 10+2*float64(i) || s.Position.Y != 10 || s.Distance != 2*float64(i) {
+			t.Errorf("Sample %d at %+v, distance %f", i, s.Position, s.Distance)
+			break
+		}
+	}
+}
+
+func TestTraceHandler_2DMode(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Failed to change directory to project root: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"api_version": 2,
+		"image_path":  "rainfall_data/2025-10-03T14:40:00Z.png",
+		"origin":      map[string]float64{"X": 10, "Y": 10},
+		"direction":   map[string]float64{"X": 1, "Y": 0},
+		"fov_deg":     30,
+		"distance":    100,
+		"options":     map[string]interface{}{"mode": "2d", "across_bins": 5},
+	})
+	req, err := http.NewRequest("POST", "/trace", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(traceHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var resp TraceProjection2DResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response body as JSON: %v", err)
+	}
+	if len(resp.Projection) == 0 {
+		t.Fatal("Expected a non-empty projection")
+	}
+	var empty, filled int
+	for i, row := range resp.Projection {
+		if len(row) != 5 {
+			t.Fatalf("Expected 5 across bins in along bin %d, got %d", i, len(row))
+		}
+		for _, v := range row {
+			if math.IsInf(v, -1) {
+				empty++
+			} else {
+				filled++
+			}
+		}
+	}
+	// The wedge is narrower than its outer bins near the apex.
+	if empty == 0 || filled == 0 {
+		t.Errorf("Expected both empty and filled bins, got %d and %d", empty, filled)
+	}
+}
+
+func TestTraceHandler_Bearing(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	img := image.NewGray(image.Rect(0, 0, 21, 21))
+	img.Pix[10*img.Stride+17] = 200 // Due east of the origin
+	imagePath := filepath.Join(dir, "east.png")
+	file, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	trace := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		body["image_path"] = imagePath
+		body["origin"] = map[string]float64{"X": 10.5, "Y": 10.5}
+		body["fov_deg"] = 15
+		body["distance"] = 10
+		// 2d mode, whose empty bins encode as null.
+		if body["api_version"] == 2 {
+			body["options"] = map[string]string{"mode": "2d"}
+		} else {
+			body["mode"] = "2d"
+		}
+		requestBody, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/trace", bytes.NewReader(requestBody))
+		rr := httptest.NewRecorder()
+		traceHandler(rr, req)
+		return rr
+	}
+	peak := func(rr *httptest.ResponseRecorder) float64 {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		var resp TraceProjection2DResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response body as JSON: %v", err)
+		}
+		max := 0.0
+		for _, row := range resp.Projection {
+			for _, v := range row {
+				max = math.Max(max, v)
+			}
+		}
+		return max
+	}
+
+	if got := peak(trace(map[string]interface{}{"bearing_deg": 90})); got != 200 {
+		t.Errorf("Expected bearing 90 to find the pixel due east, got a peak of %v", got)
+	}
+	if got := peak(trace(map[string]interface{}{"api_version": 2, "bearing_deg": 0})); got != 0 {
+		t.Errorf("Expected bearing 0 to look north and miss the pixel, got a peak of %v", got)
+	}
+	rr := trace(map[string]interface{}{"bearing_deg": 90, "direction": map[string]float64{"X": 1, "Y": 0}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected both direction and bearing_deg to be rejected, got %d", rr.Code)
+	}
+}
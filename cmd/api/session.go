@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example/goflow/flow"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long a flow session may stay idle before it expires.
+var sessionTTL = 30 * time.Minute
+
+// maxSessions bounds the live flow sessions, each of which holds its frames'
+// features and the last frame in memory until it expires.
+var maxSessions = 64
+
+// errTooManySessions is returned by sessionStore.create once maxSessions
+// sessions are live.
+var errTooManySessions = errors.New("too many flow sessions")
+
+// flowSession accumulates frames for repeated flow requests over a growing
+// sequence.
+type flowSession struct {
+	mu       sync.Mutex
+	acc      *flow.Accumulator
+	closed   bool      // set once the session has expired; guarded by mu
+	lastUsed time.Time // guarded by the store's mu
+}
+
+// lock locks the session and reports whether it is still usable. The
+// session is unlocked again if it has already expired.
+func (sess *flowSession) lock() bool {
+	sess.mu.Lock()
+	if sess.closed {
+		sess.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// sessionStore holds the live flow sessions. Expired sessions are removed
+// lazily whenever the store is accessed.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*flowSession
+	now      func() time.Time
+}
+
+var flowSessions = sessionStore{sessions: make(map[string]*flowSession), now: time.Now}
+
+// create registers a new session around acc and returns its ID.
+func (s *sessionStore) create(acc *flow.Accumulator) (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	id := hex.EncodeToString(raw[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	if len(s.sessions) >= maxSessions {
+		return "", fmt.Errorf("%w: %d are live", errTooManySessions, len(s.sessions))
+	}
+	s.sessions[id] = &flowSession{acc: acc, lastUsed: s.now()}
+	return id, nil
+}
+
+// get returns the session with the given ID and marks it as used.
+func (s *sessionStore) get(id string) (*flowSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	sess, ok := s.sessions[id]
+	if ok {
+		sess.lastUsed = s.now()
+	}
+	return sess, ok
+}
+
+// expireLocked removes sessions idle for longer than sessionTTL. s.mu must
+// be held.
+func (s *sessionStore) expireLocked() {
+	cutoff := s.now().Add(-sessionTTL)
+	for id, sess := range s.sessions {
+		if sess.lastUsed.Before(cutoff) {
+			delete(s.sessions, id)
+			// Free it without holding up the store while a request that
+			// still uses the session finishes.
+			go sess.close()
+		}
+	}
+}
+
+// close marks the session as expired and releases its accumulator.
+func (sess *flowSession) close() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.closed = true
+	sess.acc.Close()
+}
+
+// SessionResponse describes a flow session.
+type SessionResponse struct {
+	ID     string `json:"id"`
+	Frames int    `json:"frames"`
+}
+
+// sessionCreateHandler handles POST /flow/session. The optional body is a
+// FlowRequest whose image paths are added to the new session.
+func sessionCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FlowRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !validImagePaths(w, req.ImagePaths) || !withinLimits(w, req.ImagePaths) {
+		return
+	}
+
+	// A session tracks its frames as a version 2 /flow request with the
+	// default options does, so its map matches one over the same frames.
+	opts, err := flowOptions(FlowOptionsV2{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Add the initial frames before registering the session, so a failed
+	// request does not leave an unreachable session behind.
+	acc := flow.NewAccumulator(opts)
+	for _, path := range req.ImagePaths {
+		if err := acc.AddImagePath(path); err != nil {
+			acc.Close()
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	id, err := flowSessions.create(acc)
+	if errors.Is(err, errTooManySessions) {
+		acc.Close()
+		http.Error(w, "Too many flow sessions; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		acc.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeSessionResponse(w, http.StatusCreated, id, acc.Frames())
+}
+
+// sessionHandler routes the per-session endpoints:
+//
+//	POST /flow/session/{id}/frames  append frames, as a FlowRequest or a PNG body
+//	GET  /flow/session/{id}/map     current flow map (optional ?resn=)
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/flow/session/")
+	id, action, ok := strings.Cut(rest, "/")
+	if !ok || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	sess, found := flowSessions.get(id)
+	if !found || !sess.lock() {
+		http.Error(w, "Unknown or expired session", http.StatusNotFound)
+		return
+	}
+	defer sess.mu.Unlock()
+
+	switch action {
+	case "frames":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessionFramesHandler(w, r, id, sess)
+	case "map":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessionMapHandler(w, r, sess)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sessionFramesHandler appends frames to a session. A request with a
+// Content-Type of image/png carries a single uploaded frame, of at most
+// limits.maxUploadBytes; otherwise the body is a FlowRequest listing frame
+// paths. Frames that cannot be tracked are rejected and stop the request,
+// keeping the frames added before them. sess must be locked.
+func sessionFramesHandler(w http.ResponseWriter, r *http.Request, id string, sess *flowSession) {
+	if r.Header.Get("Content-Type") == "image/png" {
+		name := fmt.Sprintf("upload %d", sess.acc.Frames()+1)
+		upload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.maxUploadBytes()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeLimitError(w, fmt.Errorf("%w: %s is over %d bytes", errImageTooLarge, name, tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read uploaded frame", http.StatusBadRequest)
+			return
+		}
+		img, err := limits.decodePNG(bytes.NewReader(upload), name)
+		if writeLimitError(w, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to decode uploaded frame", http.StatusBadRequest)
+			return
+		}
+		if err := sess.acc.AddImage(img, name); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeSessionResponse(w, http.StatusOK, id, sess.acc.Frames())
+		return
+	}
+
+	var req FlowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.ImagePaths) == 0 {
+		http.Error(w, "At least one image path is required", http.StatusBadRequest)
+		return
+	}
+	if !validImagePaths(w, req.ImagePaths) || !withinLimits(w, req.ImagePaths) {
+		return
+	}
+	for _, path := range req.ImagePaths {
+		if err := sess.acc.AddImagePath(path); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	writeSessionResponse(w, http.StatusOK, id, sess.acc.Frames())
+}
+
+// sessionMapHandler returns the session's current flow map as a PNG, or a
+// 422 too_few_features error as /flow would. sess must be locked.
+func sessionMapHandler(w http.ResponseWriter, r *http.Request, sess *flowSession) {
+	resolutionFactor, err := strconv.Atoi(r.URL.Query().Get("resn"))
+	if err != nil || resolutionFactor <= 0 {
+		resolutionFactor = flow.AutoResolution
+	}
+
+	field, err := sess.acc.FlowField(resolutionFactor)
+	if writeFeatureError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set(resolutionFactorHeader, strconv.Itoa(field.ResolutionFactor))
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, field.Image()); err != nil {
+		http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+		return
+	}
+}
+
+// validImagePaths reports whether every path lies inside the data directory,
+// writing a 400 response if not.
+func validImagePaths(w http.ResponseWriter, paths []string) bool {
+	for _, path := range paths {
+		if !insideDataDir(path) {
+			http.Error(w, "Invalid image path", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// writeSessionResponse writes a session's ID and frame count as JSON.
+func writeSessionResponse(w http.ResponseWriter, status int, id string, frames int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SessionResponse{ID: id, Frames: frames})
+}
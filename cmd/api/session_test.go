@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// sessionRequest sends a request to the session endpoints and returns the
+// recorded response.
+func sessionRequest(t *testing.T, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rr := httptest.NewRecorder()
+	if path == "/flow/session" {
+		sessionCreateHandler(rr, req)
+	} else {
+		sessionHandler(rr, req)
+	}
+	return rr
+}
+
+func decodeSession(t *testing.T, rr *httptest.ResponseRecorder) SessionResponse {
+	t.Helper()
+	var resp SessionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode session response: %v", err)
+	}
+	return resp
+}
+
+func TestFlowSessionMatchesOneShot(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	framePaths := []string{
+		"../../rainfall_data/2025-10-03T14:40:00Z.png",
+		"../../rainfall_data/2025-10-03T14:45:00Z.png",
+		"../../rainfall_data/2025-10-03T14:50:00Z.png",
+		"../../rainfall_data/2025-10-03T14:55:00Z.png",
+	}
+
+	// One-shot reference, with the default version 2 options.
+	body, _ := json.Marshal(FlowRequestV2{APIVersion: 2, ImagePaths: framePaths})
+	req, err := http.NewRequest("POST", "/flow", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := httptest.NewRecorder()
+	flowHandler(want, req)
+	if want.Code != http.StatusOK {
+		t.Fatalf("/flow returned %d: %s", want.Code, want.Body.String())
+	}
+
+	// Session fed one frame at a time; the last frame is uploaded.
+	body, _ = json.Marshal(FlowRequest{ImagePaths: framePaths[:1]})
+	rr := sessionRequest(t, "POST", "/flow/session", "application/json", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Create returned %d: %s", rr.Code, rr.Body.String())
+	}
+	id := decodeSession(t, rr).ID
+
+	if rr := sessionRequest(t, "GET", "/flow/session/"+id+"/map", "", nil); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a map with one frame, got %d", rr.Code)
+	}
+
+	for _, path := range framePaths[1:3] {
+		body, _ := json.Marshal(FlowRequest{ImagePaths: []string{path}})
+		rr := sessionRequest(t, "POST", "/flow/session/"+id+"/frames", "application/json", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Adding %s returned %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+	upload, err := os.ReadFile(framePaths[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = sessionRequest(t, "POST", "/flow/session/"+id+"/frames", "image/png", upload)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Uploading frame returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if frames := decodeSession(t, rr).Frames; frames != len(framePaths) {
+		t.Errorf("Expected %d frames in the session, got %d", len(framePaths), frames)
+	}
+
+	got := sessionRequest(t, "GET", "/flow/session/"+id+"/map", "", nil)
+	if got.Code != http.StatusOK {
+		t.Fatalf("Map returned %d: %s", got.Code, got.Body.String())
+	}
+	if !bytes.Equal(got.Body.Bytes(), want.Body.Bytes()) {
+		t.Error("Session flow map differs from the one-shot /flow result")
+	}
+}
+
+func TestFlowSessionErrorsAndExpiry(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	now := time.Now()
+	oldNow := flowSessions.now
+	flowSessions.now = func() time.Time { return now }
+	t.Cleanup(func() { flowSessions.now = oldNow })
+
+	body, _ := json.Marshal(FlowRequest{ImagePaths: []string{"../../go.mod"}})
+	if rr := sessionRequest(t, "POST", "/flow/session", "application/json", body); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a path outside the data directory, got %d", rr.Code)
+	}
+
+	rr := sessionRequest(t, "POST", "/flow/session", "", nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Create returned %d: %s", rr.Code, rr.Body.String())
+	}
+	id := decodeSession(t, rr).ID
+
+	if rr := sessionRequest(t, "POST", "/flow/session/"+id+"/map", "", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST to map, got %d", rr.Code)
+	}
+	if rr := sessionRequest(t, "GET", "/flow/session/unknown/map", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", rr.Code)
+	}
+
+	oldLimits := limits
+	limits.MaxPixels = 1
+	t.Cleanup(func() { limits = oldLimits })
+	if rr := sessionRequest(t, "POST", "/flow/session/"+id+"/frames", "image/png", make([]byte, limits.maxUploadBytes()+1)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an upload over the size limit, got %d", rr.Code)
+	}
+
+	oldMax := maxSessions
+	maxSessions = 0
+	t.Cleanup(func() { maxSessions = oldMax })
+	if rr := sessionRequest(t, "POST", "/flow/session", "", nil); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 beyond the session limit, got %d", rr.Code)
+	}
+
+	now = now.Add(sessionTTL + time.Minute)
+	if rr := sessionRequest(t, "GET", "/flow/session/"+id+"/map", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an expired session, got %d", rr.Code)
+	}
+}
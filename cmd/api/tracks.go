@@ -0,0 +1,521 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"example/goflow/frames"
+	"example/goflow/newcast"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// defaultMaxFeatures is the number of features a /tracks request that sets
+// none tracks, and maxTrackFeatures the most one may set.
+const (
+	defaultMaxFeatures = 100
+	maxTrackFeatures   = 1000
+)
+
+// TracksRequest is the /tracks request body, the same in every
+// api_version. A body without one is version 1 and is answered with its
+// deprecation warning.
+type TracksRequest struct {
+	// ImagePaths are the frames, named after their RFC 3339 capture times
+	// like those of the data directory, in increasing time order.
+	ImagePaths []string `json:"image_paths"`
+	// MaxFeatures is the number of features detected in the first frame;
+	// default 100, at most 1000.
+	MaxFeatures int `json:"max_features,omitempty"`
+	// Render, if set, adds a rendering of the tracks to the response.
+	Render *TracksRender `json:"render,omitempty"`
+	// Histograms, if set, adds histograms of the tracks' latest speeds and
+	// directions to the response.
+	Histograms *TracksHistograms `json:"histograms,omitempty"`
+}
+
+// defaultDirectionSectors is the number of compass sectors of a /tracks
+// direction histogram that sets none.
+const defaultDirectionSectors = 16
+
+// TracksHistograms selects the motion histograms of a /tracks response,
+// computed by newcast.MotionHistograms.
+type TracksHistograms struct {
+	// SpeedBinWidth is the width of the speed bins in pixels per second.
+	// It must be positive.
+	SpeedBinWidth float64 `json:"speed_bin_width"`
+	// DirectionSectors is the number of compass sectors; default 16, at
+	// most newcast.MaxHistogramBins. A speed_bin_width that needs more
+	// bins than that for the fastest track is rejected too.
+	DirectionSectors int `json:"direction_sectors,omitempty"`
+	// Render adds a bar chart of each histogram.
+	Render bool `json:"render,omitempty"`
+}
+
+// TracksRender selects the rendering of a /tracks response, drawn with the
+// newcast visualizers.
+type TracksRender struct {
+	// Overlay draws the tracks over a frame instead of a black background.
+	Overlay bool `json:"overlay"`
+	// BackgroundIndex is the frame drawn under an overlay. Negative indexes
+	// count from the end, so -1, the default, is the last frame.
+	BackgroundIndex *int `json:"background_index,omitempty"`
+	// VectorScale, if positive, also draws each track's latest velocity,
+	// in pixels per second, scaled by it.
+	VectorScale float64 `json:"vector_scale,omitempty"`
+	// Extrapolate, if positive, also draws each track's path that many
+	// frames ahead.
+	Extrapolate int `json:"extrapolate,omitempty"`
+}
+
+// TracksPage selects the tracks of a /tracks response, from the query
+// parameters offset, limit and sort; see newcast.PageTracks.
+type TracksPage struct {
+	Offset int
+	// Limit is the most tracks returned; zero means all of them.
+	Limit int
+	Sort  newcast.TrackSort
+}
+
+// parseTracksPage reads the paging query parameters of a /tracks request.
+func parseTracksPage(query url.Values) (TracksPage, error) {
+	var page TracksPage
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"offset", &page.Offset}, {"limit", &page.Limit}} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return TracksPage{}, fmt.Errorf("invalid %s parameter %q: must be a non-negative integer", p.name, v)
+		}
+		*p.dst = n
+	}
+	if v := query.Get("sort"); v != "" {
+		by, err := newcast.ParseTrackSort(v)
+		if err != nil {
+			return TracksPage{}, err
+		}
+		page.Sort = by
+	}
+	return page, nil
+}
+
+// TracksResponse is the response to a /tracks request.
+type TracksResponse struct {
+	// Tracks is the page of tracks the query parameters select, by
+	// ascending ID unless they set a sort.
+	Tracks []TrackJSON `json:"tracks"`
+	// Total is the number of tracks on all pages, and Offset that of the
+	// tracks before this one.
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	// PNG is the rendering requested by TracksRequest.Render, base64
+	// encoded. It draws the tracks of every page, and is only sent with
+	// the first page, at offset 0.
+	PNG []byte `json:"png,omitempty"`
+	// Histograms holds the histograms requested by
+	// TracksRequest.Histograms, over the tracks of every page. Like PNG,
+	// it is only sent at offset 0.
+	Histograms *HistogramsJSON `json:"histograms,omitempty"`
+	Warnings   []APIWarning    `json:"warnings,omitempty"`
+}
+
+// HistogramsJSON is the speed and direction histograms of a
+// TracksResponse: SpeedBins[i] counts the speeds from SpeedEdges[i] up to
+// SpeedEdges[i+1], and DirectionBins[i] the tracks heading into compass
+// sector i, sector 0 being centered on north, up in the frames.
+type HistogramsJSON struct {
+	SpeedBins     []int     `json:"speed_bins"`
+	SpeedEdges    []float64 `json:"speed_edges"`
+	DirectionBins []int     `json:"direction_bins"`
+	// SpeedPNG and DirectionPNG are bar charts of the histograms, base64
+	// encoded, if TracksHistograms.Render is set and there are bins.
+	SpeedPNG     []byte `json:"speed_png,omitempty"`
+	DirectionPNG []byte `json:"direction_png,omitempty"`
+}
+
+// TrackJSON is one track of a TracksResponse.
+type TrackJSON struct {
+	ID     int              `json:"id"`
+	Points []TrackPointJSON `json:"points"`
+	// VX and VY are the latest velocity in pixels per second.
+	VX float32 `json:"vx"`
+	VY float32 `json:"vy"`
+}
+
+// TrackPointJSON is one observed position of a track.
+type TrackPointJSON struct {
+	Time time.Time `json:"time"`
+	X    float32   `json:"x"`
+	Y    float32   `json:"y"`
+}
+
+// frameTimes returns the capture times in the names of paths, the tracks'
+// time axis. It fails if a name has none or the times do not increase.
+func frameTimes(paths []string) ([]time.Time, error) {
+	times := make([]time.Time, len(paths))
+	for i, path := range paths {
+		ts, err := frames.ParseTimestamp(path)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && !ts.After(times[i-1]) {
+			return nil, fmt.Errorf("frame %s is not later than the one before it", path)
+		}
+		times[i] = ts
+	}
+	return times, nil
+}
+
+func tracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req TracksRequest
+	version, ok := decodeVersioned(w, r, &req, &req)
+	if !ok {
+		return
+	}
+	warning := deprecationWarning(w, version)
+	if len(req.ImagePaths) < 2 {
+		http.Error(w, "At least two image paths are required", http.StatusBadRequest)
+		return
+	}
+	if req.MaxFeatures > maxTrackFeatures {
+		http.Error(w, fmt.Sprintf("max_features must be at most %d", maxTrackFeatures), http.StatusBadRequest)
+		return
+	}
+	if req.MaxFeatures <= 0 {
+		req.MaxFeatures = defaultMaxFeatures
+	}
+	times, err := frameTimes(req.ImagePaths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	background := len(req.ImagePaths) - 1
+	if req.Render != nil && req.Render.BackgroundIndex != nil {
+		background = *req.Render.BackgroundIndex
+		if background < 0 {
+			background += len(req.ImagePaths)
+		}
+		if background < 0 || background >= len(req.ImagePaths) {
+			http.Error(w, "Background index out of range", http.StatusBadRequest)
+			return
+		}
+	}
+	if hist := req.Histograms; hist != nil {
+		if hist.SpeedBinWidth <= 0 {
+			http.Error(w, "Histogram speed_bin_width must be positive", http.StatusBadRequest)
+			return
+		}
+		if hist.DirectionSectors < 0 || hist.DirectionSectors > newcast.MaxHistogramBins {
+			http.Error(w, fmt.Sprintf("Histogram direction_sectors must be from 0 to %d", newcast.MaxHistogramBins), http.StatusBadRequest)
+			return
+		}
+		if hist.DirectionSectors == 0 {
+			hist.DirectionSectors = defaultDirectionSectors
+		}
+	}
+	page, err := parseTracksPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validImagePaths(w, req.ImagePaths) || !withinLimits(w, req.ImagePaths) {
+		return
+	}
+
+	// The tracking is keyed without the page, so that paging through the
+	// tracks of one request, even with concurrent requests for different
+	// pages, tracks its frames once.
+	key, err := requestKey("/tracks", req, req.ImagePaths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, ok := trackResults.get(key)
+	if !ok {
+		val, ok := inflight.do(r.Context(), key, func(_ context.Context) any {
+			if res, ok := trackResults.get(key); ok {
+				return res
+			}
+			failed := newBufferedResponse()
+			res := trackFrames(failed, req, times, background)
+			if res == nil {
+				return failed
+			}
+			trackResults.add(key, res)
+			return res
+		})
+		if !ok {
+			return
+		}
+		switch val := val.(type) {
+		case *trackResult:
+			res = val
+		case *bufferedResponse:
+			val.writeTo(w)
+			return
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	paged, total := newcast.PageTracks(res.tracks, page.Offset, page.Limit, page.Sort)
+	resp := TracksResponse{
+		Tracks:   make([]TrackJSON, len(paged)),
+		Total:    total,
+		Offset:   min(page.Offset, total),
+		Warnings: warningList(warning),
+	}
+	if page.Offset == 0 {
+		resp.PNG, resp.Histograms = res.png, res.histograms
+	}
+	for i, track := range paged {
+		resp.Tracks[i] = TrackJSON{ID: track.ID, VX: track.LatestVelocity.X, VY: track.LatestVelocity.Y}
+		for _, p := range track.Points {
+			resp.Tracks[i].Points = append(resp.Tracks[i].Points, TrackPointJSON{Time: p.Time, X: p.Vec.X, Y: p.Vec.Y})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// maxTrackResultBytes bounds the approximate memory held by the cached
+// /tracks results.
+var maxTrackResultBytes int64 = 64 << 20
+
+// trackResults keeps the results of recent /tracks requests by their
+// requestKey, which changes when a frame does.
+var trackResults = trackCache{entries: make(map[string]*list.Element), order: list.New()}
+
+// trackCache holds the results of /tracks requests, dropping the least
+// recently used once they hold more than maxTrackResultBytes.
+type trackCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the *trackEntry values, the most recently used first.
+	order *list.List
+	bytes int64
+}
+
+// trackEntry is a cached trackResult and its key.
+type trackEntry struct {
+	key string
+	res *trackResult
+}
+
+// trackResult is the part of a /tracks response that does not depend on
+// the page: every track, and the rendering and histograms drawn from them.
+// It is not modified once cached.
+type trackResult struct {
+	tracks     []*newcast.Track
+	png        []byte
+	histograms *HistogramsJSON
+}
+
+// trackBytes and trackPointBytes are the rough sizes of a newcast.Track,
+// without its points, and of one of its points.
+const (
+	trackBytes      = 256
+	trackPointBytes = 64
+)
+
+// size returns the approximate number of bytes r holds.
+func (r *trackResult) size() int64 {
+	n := int64(len(r.png))
+	if h := r.histograms; h != nil {
+		n += int64(len(h.SpeedPNG)+len(h.DirectionPNG)) + 8*int64(len(h.SpeedBins)+len(h.SpeedEdges)+len(h.DirectionBins))
+	}
+	for _, track := range r.tracks {
+		n += trackBytes + int64(len(track.Points))*trackPointBytes
+	}
+	return n
+}
+
+func (c *trackCache) get(key string) (*trackResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*trackEntry).res, true
+}
+
+// add caches res under key, unless it alone is over maxTrackResultBytes.
+func (c *trackCache) add(key string, res *trackResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	if res.size() > maxTrackResultBytes {
+		return
+	}
+	for c.bytes+res.size() > maxTrackResultBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&trackEntry{key: key, res: res})
+	c.bytes += res.size()
+}
+
+// remove drops the entry e. c.mu must be held.
+func (c *trackCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*trackEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.res.size()
+}
+
+// trackFrames tracks the frames of req, captured at times, drawing the
+// rendering over the frame at index background. It writes the error and
+// returns nil if that fails.
+func trackFrames(w http.ResponseWriter, req TracksRequest, times []time.Time, background int) *trackResult {
+	tracker, err := newcast.NewTracker(req.MaxFeatures)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	defer tracker.Close()
+
+	backgroundMat := gocv.NewMat()
+	defer backgroundMat.Close()
+	for i, path := range req.ImagePaths {
+		mat := gocv.IMRead(path, gocv.IMReadGrayScale)
+		if mat.Empty() {
+			http.Error(w, fmt.Sprintf("Failed to read image %s", path), http.StatusUnprocessableEntity)
+			return nil
+		}
+		err := tracker.AddImage(mat, times[i])
+		if i == background {
+			backgroundMat.Close()
+			backgroundMat = mat
+		} else {
+			mat.Close()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return nil
+		}
+	}
+
+	res := &trackResult{tracks: tracker.GetTracks()}
+	if req.Render != nil {
+		res.png, err = renderTracks(res.tracks, backgroundMat, *req.Render)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil
+		}
+	}
+	if req.Histograms != nil {
+		res.histograms, err = trackHistograms(res.tracks, *req.Histograms)
+		if errors.Is(err, newcast.ErrTooManyBins) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil
+		}
+	}
+	return res
+}
+
+// renderTracks draws tracks with the newcast visualizers as selected by
+// render, over background if render.Overlay is set, and returns the PNG.
+// The layers share one ColorAssigner, so a track has one color throughout.
+func renderTracks(tracks []*newcast.Track, background gocv.Mat, render TracksRender) ([]byte, error) {
+	width, height := background.Cols(), background.Rows()
+	colors := newcast.NewColorAssigner()
+	layers := []gocv.Mat{newcast.VisualizeTracks(tracks, width, height, colors)}
+	if render.VectorScale > 0 {
+		layers = append(layers, newcast.VisualizeVectors(tracks, width, height, float32(render.VectorScale), colors))
+	}
+	if render.Extrapolate > 0 {
+		layers = append(layers, newcast.VisualizeExtrapolatedTracks(tracks, width, height, render.Extrapolate, colors))
+	}
+	defer func() {
+		for _, layer := range layers {
+			layer.Close()
+		}
+	}()
+	drawing := layers[0]
+	for _, layer := range layers[1:] {
+		gocv.Max(drawing, layer, &drawing)
+	}
+
+	out := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
+	defer out.Close()
+	out.SetTo(gocv.NewScalar(0, 0, 0, 0))
+	if render.Overlay {
+		gocv.CvtColor(background, &out, gocv.ColorGrayToBGR)
+	}
+	// Copy the drawn pixels, those that are not black, over the background.
+	mask := gocv.NewMat()
+	defer mask.Close()
+	gocv.CvtColor(drawing, &mask, gocv.ColorBGRToGray)
+	gocv.Threshold(mask, &mask, 0, 255, gocv.ThresholdBinary)
+	drawing.CopyToWithMask(&out, mask)
+
+	png, err := encodePNG(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the track rendering: %w", err)
+	}
+	return png, nil
+}
+
+// trackHistograms computes the motion histograms of tracks selected by
+// hist and, if hist.Render is set, renders them.
+func trackHistograms(tracks []*newcast.Track, hist TracksHistograms) (*HistogramsJSON, error) {
+	var out HistogramsJSON
+	var err error
+	out.SpeedBins, out.SpeedEdges, out.DirectionBins, err = newcast.MotionHistograms(tracks, hist.SpeedBinWidth, hist.DirectionSectors)
+	if err != nil {
+		return nil, err
+	}
+	if !hist.Render {
+		return &out, nil
+	}
+	if len(out.SpeedBins) > 0 {
+		chart := newcast.VisualizeSpeedHistogram(out.SpeedBins, out.SpeedEdges)
+		defer chart.Close()
+		if out.SpeedPNG, err = encodePNG(chart); err != nil {
+			return nil, fmt.Errorf("failed to encode the speed histogram: %w", err)
+		}
+	}
+	chart := newcast.VisualizeDirectionHistogram(out.DirectionBins)
+	defer chart.Close()
+	if out.DirectionPNG, err = encodePNG(chart); err != nil {
+		return nil, fmt.Errorf("failed to encode the direction histogram: %w", err)
+	}
+	return &out, nil
+}
+
+// encodePNG returns mat encoded as a PNG.
+func encodePNG(mat gocv.Mat) ([]byte, error) {
+	buf, err := gocv.IMEncode(gocv.PNGFileExt, mat)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+	return append([]byte(nil), buf.GetBytes()...), nil
+}
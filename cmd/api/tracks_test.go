@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBlobFrames writes n 200x120 frames, one minute apart, with a static
+// bright cross on the left and a bright square moving 3 px right per frame
+// from (110, 50), and returns their paths.
+func writeBlobFrames(t *testing.T, dir string, n int) []string {
+	t.Helper()
+	start := time.Date(2025, 10, 4, 9, 0, 0, 0, time.UTC)
+	paths := make([]string, n)
+	for i := range paths {
+		img := image.NewGray(image.Rect(0, 0, 200, 120))
+		fill := func(r image.Rectangle, v uint8) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					img.SetGray(x, y, color.Gray{Y: v})
+				}
+			}
+		}
+		fill(image.Rect(30, 56, 70, 64), 255)
+		fill(image.Rect(46, 40, 54, 80), 255)
+		fill(image.Rect(110+3*i, 50, 126+3*i, 66), 200)
+
+		paths[i] = filepath.Join(dir, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339)+".png")
+		file, err := os.Create(paths[i])
+		if err != nil {
+			t.Fatalf("Failed to create frame %d: %v", i, err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatalf("Failed to encode frame %d: %v", i, err)
+		}
+		file.Close()
+	}
+	return paths
+}
+
+func postTracks(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return postTracksQuery(t, "", body)
+}
+
+// postTracksQuery posts body to /tracks with the given query string.
+func postTracksQuery(t *testing.T, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/tracks"+query, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	tracksHandler(rr, req)
+	return rr
+}
+
+// TestTracksRender checks that a render-enabled /tracks request returns the
+// tracks of the moving square together with a PNG of the frame size that
+// has track colors along the square's path.
+func TestTracksRender(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	paths, err := json.Marshal(writeBlobFrames(t, dir, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postTracks(t, fmt.Sprintf(`{"image_paths": %s, "max_features": 20,
+		"render": {"overlay": true, "background_index": -1, "vector_scale": 50, "extrapolate": 4}}`, paths))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+	var resp TracksResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	moving := 0
+	for _, track := range resp.Tracks {
+		if len(track.Points) == 5 && track.VX > 0 {
+			moving++
+		}
+	}
+	if moving == 0 {
+		t.Fatalf("Expected a track following the square over all 5 frames, got %+v", resp.Tracks)
+	}
+
+	img, err := png.Decode(bytes.NewReader(resp.PNG))
+	if err != nil {
+		t.Fatalf("Failed to decode the rendering: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 200, 120) {
+		t.Fatalf("Expected a 200x120 rendering, got %v", img.Bounds())
+	}
+	// The frames are gray, so any colored pixel is drawn.
+	colored := func(r image.Rectangle) int {
+		n := 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				if r != g || g != b {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if n := colored(image.Rect(105, 45, 150, 70)); n == 0 {
+		t.Error("Expected track colors along the path of the square")
+	}
+	if n := colored(image.Rect(0, 90, 200, 120)); n != 0 {
+		t.Errorf("Expected no drawing away from the features, got %d colored pixels", n)
+	}
+
+	// Later pages leave the rendering out.
+	rr = postTracksQuery(t, "?offset=1", fmt.Sprintf(`{"image_paths": %s, "max_features": 20,
+		"render": {"overlay": true, "background_index": -1, "vector_scale": 50, "extrapolate": 4}}`, paths))
+	var later TracksResponse
+	if err := json.NewDecoder(rr.Body).Decode(&later); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if later.PNG != nil {
+		t.Error("Expected no rendering past the first page")
+	}
+
+	if rr := postTracks(t, fmt.Sprintf(`{"image_paths": %s, "render": {"background_index": 5}}`, paths)); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an out-of-range background index, got %d", rr.Code)
+	}
+}
+
+// TestTracksRequestChecks checks that /tracks honours api_version, caps
+// max_features and takes its time axis only from frame names that carry
+// increasing capture times.
+func TestTracksRequestChecks(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	framePaths := writeBlobFrames(t, dir, 3)
+	paths, err := json.Marshal(framePaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for version, deprecated := range map[string]bool{"": true, `"api_version": 2, `: false} {
+		rr := postTracks(t, fmt.Sprintf(`{%s"image_paths": %s, "max_features": 20}`, version, paths))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Body %q: expected status 200, got %d: %s", version, rr.Code, rr.Body.String())
+		}
+		var resp TracksResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if got := len(resp.Warnings) == 1 && resp.Warnings[0].Code == "deprecated_api_version"; got != deprecated {
+			t.Errorf("Body %q: expected a deprecation warning %v, got %+v", version, deprecated, resp.Warnings)
+		}
+	}
+
+	renamed := filepath.Join(dir, "frame.png")
+	if err := os.Link(framePaths[2], renamed); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		"unknown api_version":   fmt.Sprintf(`{"api_version": 3, "image_paths": %s}`, paths),
+		"too many features":     fmt.Sprintf(`{"image_paths": %s, "max_features": %d}`, paths, maxTrackFeatures+1),
+		"name without a time":   fmt.Sprintf(`{"image_paths": [%q, %q]}`, framePaths[0], renamed),
+		"times out of order":    fmt.Sprintf(`{"image_paths": [%q, %q]}`, framePaths[1], framePaths[0]),
+		"repeated capture time": fmt.Sprintf(`{"image_paths": [%q, %q]}`, framePaths[0], framePaths[0]),
+	} {
+		if rr := postTracks(t, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", name, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// TestTracksHistograms checks that the histograms of a /tracks response
+// count every moving track, that the square moving right lands in the east
+// sector, and that the bar charts are PNGs.
+func TestTracksHistograms(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	paths, err := json.Marshal(writeBlobFrames(t, dir, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postTracks(t, fmt.Sprintf(`{"image_paths": %s, "max_features": 20,
+		"histograms": {"speed_bin_width": 0.01, "render": true}}`, paths))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp TracksResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	hist := resp.Histograms
+	if hist == nil {
+		t.Fatal("Expected histograms in the response")
+	}
+	moving := 0
+	for _, track := range resp.Tracks {
+		if track.VX != 0 || track.VY != 0 {
+			moving++
+		}
+	}
+	sum := func(bins []int) int {
+		n := 0
+		for _, b := range bins {
+			n += b
+		}
+		return n
+	}
+	if moving == 0 || sum(hist.SpeedBins) != moving || sum(hist.DirectionBins) != moving {
+		t.Errorf("Expected both histograms to count the %d moving tracks, got %v and %v", moving, hist.SpeedBins, hist.DirectionBins)
+	}
+	if len(hist.DirectionBins) != 16 || hist.DirectionBins[4] == 0 {
+		t.Errorf("Expected 16 sectors with tracks heading east, got %v", hist.DirectionBins)
+	}
+	if len(hist.SpeedEdges) != len(hist.SpeedBins)+1 {
+		t.Errorf("Expected %d speed edges, got %v", len(hist.SpeedBins)+1, hist.SpeedEdges)
+	}
+	for name, data := range map[string][]byte{"speed": hist.SpeedPNG, "direction": hist.DirectionPNG} {
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("Failed to decode the %s histogram: %v", name, err)
+		}
+	}
+
+	if rr := postTracks(t, fmt.Sprintf(`{"image_paths": %s, "histograms": {"direction_sectors": 8}}`, paths)); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a speed bin width, got %d", rr.Code)
+	}
+	for _, hist := range []string{`{"speed_bin_width": 1, "direction_sectors": 1001}`, `{"speed_bin_width": 0.000001}`} {
+		if rr := postTracks(t, fmt.Sprintf(`{"image_paths": %s, "max_features": 20, "histograms": %s}`, paths, hist)); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for too many bins with %s, got %d", hist, rr.Code)
+		}
+	}
+}
+
+// TestTracksPagination requests two consecutive pages of tracks sorted by
+// length and checks that they neither overlap nor miss a track, are
+// ordered longest first, and report the total.
+func TestTracksPagination(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	paths, err := json.Marshal(writeBlobFrames(t, dir, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"image_paths": %s, "max_features": 20}`, paths)
+	decode := func(query string) TracksResponse {
+		t.Helper()
+		rr := postTracksQuery(t, query, body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var resp TracksResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	all := decode("")
+	if all.Total != len(all.Tracks) || all.Total < 4 {
+		t.Fatalf("Expected an unpaged response to hold its total of at least 4 tracks, got %d of %d", len(all.Tracks), all.Total)
+	}
+	for i := 1; i < len(all.Tracks); i++ {
+		if all.Tracks[i-1].ID >= all.Tracks[i].ID {
+			t.Fatalf("Expected the tracks by ascending ID, got %d before %d", all.Tracks[i-1].ID, all.Tracks[i].ID)
+		}
+	}
+
+	half := all.Total / 2
+	first := decode(fmt.Sprintf("?offset=0&limit=%d&sort=length", half))
+	second := decode(fmt.Sprintf("?offset=%d&limit=%d&sort=length", half, all.Total))
+	if first.Total != all.Total || second.Total != all.Total || second.Offset != half {
+		t.Errorf("Expected totals of %d and an offset of %d, got %d, %d and %d", all.Total, half, first.Total, second.Total, second.Offset)
+	}
+	if len(first.Tracks) != half || len(second.Tracks) != all.Total-half {
+		t.Fatalf("Expected pages of %d and %d tracks, got %d and %d", half, all.Total-half, len(first.Tracks), len(second.Tracks))
+	}
+	seen := map[int]bool{}
+	pages := append(first.Tracks, second.Tracks...)
+	for i, track := range pages {
+		if seen[track.ID] {
+			t.Errorf("Track %d is on both pages", track.ID)
+		}
+		seen[track.ID] = true
+		if i > 0 {
+			prev := pages[i-1]
+			if len(prev.Points) < len(track.Points) || len(prev.Points) == len(track.Points) && prev.ID > track.ID {
+				t.Errorf("Expected longest first, ties by ID, got %d (%d points) before %d (%d points)", prev.ID, len(prev.Points), track.ID, len(track.Points))
+			}
+		}
+	}
+	for _, track := range all.Tracks {
+		if !seen[track.ID] {
+			t.Errorf("Track %d is on neither page", track.ID)
+		}
+	}
+
+	for _, query := range []string{"?offset=-1", "?limit=ten", "?sort=speed"} {
+		if rr := postTracksQuery(t, query, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+// TestTracksPagesTrackOnce pages through the tracks of one request after
+// its frames are overwritten with garbage of the same size and
+// modification time, which only a cached tracking can serve, and checks
+// that a frame whose modification time changes is tracked again.
+func TestTracksPagesTrackOnce(t *testing.T) {
+	dir := t.TempDir()
+	useDataDir(t, dir)
+	framePaths := writeBlobFrames(t, dir, 5)
+	paths, err := json.Marshal(framePaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"image_paths": %s, "max_features": 20}`, paths)
+	rr := postTracksQuery(t, "?limit=1", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, path := range framePaths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, info.Size()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var all TracksResponse
+	for offset := 0; ; offset++ {
+		rr := postTracksQuery(t, fmt.Sprintf("?offset=%d&limit=1", offset), body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("offset %d: expected the cached tracks, got status %d: %s", offset, rr.Code, rr.Body.String())
+		}
+		var resp TracksResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		all.Tracks = append(all.Tracks, resp.Tracks...)
+		if offset >= resp.Total {
+			all.Total = resp.Total
+			break
+		}
+	}
+	if all.Total == 0 || len(all.Tracks) != all.Total {
+		t.Errorf("Expected pages of one track to hold the total of %d, got %d", all.Total, len(all.Tracks))
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(framePaths[2], later, later); err != nil {
+		t.Fatal(err)
+	}
+	if rr := postTracksQuery(t, "?offset=1&limit=1", body); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a changed frame to be read again and fail with 422, got %d", rr.Code)
+	}
+}
+
+// TestTrackCacheEvictsLeastRecentlyUsed checks that the /tracks cache stays
+// within maxTrackResultBytes by dropping the result used longest ago, and
+// does not keep a result larger than the whole budget.
+func TestTrackCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	old := maxTrackResultBytes
+	maxTrackResultBytes = 300
+	t.Cleanup(func() { maxTrackResultBytes = old })
+	cache := trackCache{entries: make(map[string]*list.Element), order: list.New()}
+	result := func(n int) *trackResult { return &trackResult{png: make([]byte, n)} }
+
+	cache.add("a", result(100))
+	cache.add("b", result(100))
+	cache.add("c", result(100))
+	cache.get("a")
+	cache.add("d", result(100))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("Expected %q cached: %v, got %v", key, want, ok)
+		}
+	}
+	cache.add("big", result(301))
+	if _, ok := cache.get("big"); ok || cache.bytes != 300 {
+		t.Errorf("Expected a result over the budget to be left out, with 300 bytes cached, got %v with %d", ok, cache.bytes)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The request body versions the API accepts. A body without "api_version"
+// is version 1.
+const (
+	minAPIVersion = 1
+	maxAPIVersion = 2
+)
+
+// When version 1 request bodies were deprecated and when they stop being
+// accepted.
+var (
+	v1DeprecatedAt = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	v1Sunset       = time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// warningHeader carries the APIWarning of a response whose body is not
+// JSON, encoded as JSON.
+const warningHeader = flowHeaderPrefix + "Warning"
+
+// APIWarning is a machine-readable warning about a request that succeeded.
+type APIWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Sunset is when the deprecated feature stops working, if known.
+	Sunset *time.Time `json:"sunset,omitempty"`
+}
+
+// versionEnvelope holds the field shared by every versioned request body.
+type versionEnvelope struct {
+	APIVersion *int `json:"api_version"`
+}
+
+// decodeVersioned reads a JSON request body and decodes it into v1 or v2
+// according to its "api_version". On failure it writes a 400 response and
+// returns false; unknown versions are answered with the supported range.
+func decodeVersioned(w http.ResponseWriter, r *http.Request, v1, v2 any) (version int, ok bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	var env versionEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	version = minAPIVersion
+	if env.APIVersion != nil {
+		version = *env.APIVersion
+	}
+
+	var dst any
+	switch version {
+	case 1:
+		dst = v1
+	case 2:
+		dst = v2
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported api_version %d: supported versions are %d to %d", version, minAPIVersion, maxAPIVersion), http.StatusBadRequest)
+		return 0, false
+	}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(dst); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+// deprecationWarning marks the response to a request of the given version
+// as deprecated, if it is, with Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers, and returns the warning to include in the response. It returns
+// nil for current versions. Call it before writing any response, so error
+// responses are marked too.
+func deprecationWarning(w http.ResponseWriter, version int) *APIWarning {
+	if version != 1 {
+		return nil
+	}
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(v1DeprecatedAt.Unix(), 10))
+	w.Header().Set("Sunset", v1Sunset.Format(http.TimeFormat))
+	sunset := v1Sunset
+	return &APIWarning{
+		Code:    "deprecated_api_version",
+		Message: fmt.Sprintf("api_version 1 request bodies are deprecated; send \"api_version\": %d", maxAPIVersion),
+		Sunset:  &sunset,
+	}
+}
+
+// setWarningHeader puts warning in the warning header, for responses that
+// cannot carry it in their body. A nil warning sets nothing.
+func setWarningHeader(w http.ResponseWriter, warning *APIWarning) {
+	if warning == nil {
+		return
+	}
+	data, err := json.Marshal(warning)
+	if err != nil {
+		return
+	}
+	w.Header().Set(warningHeader, string(data))
+}
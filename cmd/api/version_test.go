@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postFlow sends body to the /flow handler, with query appended to the URL.
+func postFlow(t *testing.T, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/flow"+query, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	flowHandler(rr, req)
+	return rr
+}
+
+// flowWidth decodes the PNG flow map of a successful response and returns
+// its width.
+func flowWidth(t *testing.T, rr *httptest.ResponseRecorder) int {
+	t.Helper()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	img, err := png.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("Failed to decode the flow map: %v", err)
+	}
+	return img.Bounds().Dx()
+}
+
+const versionTestFrames = `["../../rainfall_data/2025-10-03T14:40:00Z.png", "../../rainfall_data/2025-10-03T14:45:00Z.png"]`
+
+func TestFlowRequestV1(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	for _, body := range []string{
+		`{"image_paths": ` + versionTestFrames + `}`,
+		`{"api_version": 1, "image_paths": ` + versionTestFrames + `}`,
+	} {
+		// Version 1 takes the resolution factor from the query and ignores
+		// version 2 options.
+		rr := postFlow(t, "?resn=8", strings.TrimSuffix(body, "}")+`, "options": {"resolution_factor": 16}}`)
+		if w := flowWidth(t, rr); w != 1024/8 {
+			t.Errorf("Expected a %d pixel wide flow map, got %d", 1024/8, w)
+		}
+
+		if got, want := rr.Header().Get("Deprecation"), "@1790812800"; got != want {
+			t.Errorf("Expected Deprecation %q, got %q", want, got)
+		}
+		sunset, err := http.ParseTime(rr.Header().Get("Sunset"))
+		if err != nil || !sunset.Equal(v1Sunset) {
+			t.Errorf("Expected Sunset %v, got %q (%v)", v1Sunset, rr.Header().Get("Sunset"), err)
+		}
+		var warning APIWarning
+		if err := json.Unmarshal([]byte(rr.Header().Get(warningHeader)), &warning); err != nil {
+			t.Fatalf("Failed to decode %s %q: %v", warningHeader, rr.Header().Get(warningHeader), err)
+		}
+		if warning.Code != "deprecated_api_version" || warning.Sunset == nil || !warning.Sunset.Equal(v1Sunset) {
+			t.Errorf("Unexpected warning %+v", warning)
+		}
+	}
+}
+
+func TestFlowRequestV2(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	rr := postFlow(t, "?resn=8", `{"api_version": 2, "image_paths": `+versionTestFrames+`, "options": {"resolution_factor": 16}}`)
+	if w := flowWidth(t, rr); w != 1024/16 {
+		t.Errorf("Expected the options to set a %d pixel wide flow map, got %d", 1024/16, w)
+	}
+	for _, h := range []string{"Deprecation", "Sunset", warningHeader} {
+		if got := rr.Header().Get(h); got != "" {
+			t.Errorf("Expected no %s header for version 2, got %q", h, got)
+		}
+	}
+
+	rr = postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`, "options": {"method": "optical"}}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown method, got %d", rr.Code)
+	}
+	rr = postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`, "options": {"interpolation": "kriging"}}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown interpolation, got %d", rr.Code)
+	}
+}
+
+// TestFlowTooFewFeatures checks that a sequence keeping fewer features than
+// options.min_surviving_features is answered 422 with their count.
+func TestFlowTooFewFeatures(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	rr := postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`, "options": {"min_surviving_features": 100000}}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var apiErr APIError
+	if err := json.NewDecoder(rr.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Failed to decode the error: %v", err)
+	}
+	if apiErr.Code != codeTooFewFeatures || !strings.Contains(apiErr.Message, "survived the sequence, fewer than the 100000 required") {
+		t.Errorf("Expected a too_few_features error with the surviving count, got %+v", apiErr)
+	}
+}
+
+func TestFlowRequestUnsupportedVersion(t *testing.T) {
+	rr := postFlow(t, "", `{"api_version": 99, "image_paths": `+versionTestFrames+`}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", rr.Code)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "supported versions are 1 to 2") {
+		t.Errorf("Expected the supported range in the error, got %q", body)
+	}
+	if got := rr.Header().Get("Deprecation"); got != "" {
+		t.Errorf("Expected no Deprecation header, got %q", got)
+	}
+}
+
+func TestFlowRequestAutoResolution(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	// The 1024x1024 frames need a factor of 2 to fit the default budget.
+	rr := postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`}`)
+	if w := flowWidth(t, rr); w != 1024/2 {
+		t.Errorf("Expected a %d pixel wide flow map, got %d", 1024/2, w)
+	}
+	if got := rr.Header().Get(resolutionFactorHeader); got != "2" {
+		t.Errorf("Expected %s 2, got %q", resolutionFactorHeader, got)
+	}
+
+	rr = postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`, "options": {"pixel_budget": 65536}}`)
+	if got := rr.Header().Get(resolutionFactorHeader); got != "4" {
+		t.Errorf("Expected %s 4 under a budget of 256x256 pixels, got %q", resolutionFactorHeader, got)
+	}
+}
+
+func TestFlowRequestVerification(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	rr := postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`, "options": {"resolution_factor": 4, "verify_path": "../../rainfall_data/2025-10-03T14:50:00Z.png"}}`)
+	if w := flowWidth(t, rr); w != 1024/4 {
+		t.Errorf("Expected a %d pixel wide flow map, got %d", 1024/4, w)
+	}
+	var v FlowVerification
+	if err := json.Unmarshal([]byte(rr.Header().Get(verificationHeader)), &v); err != nil {
+		t.Fatalf("Failed to decode %s %q: %v", verificationHeader, rr.Header().Get(verificationHeader), err)
+	}
+	t.Logf("verification: %+v", v)
+	if v.Pixels == 0 || v.ForecastMSE >= v.PersistenceMSE {
+		t.Errorf("Expected the warped MSE below persistence's, got %+v", v)
+	}
+
+	rr = postFlow(t, "", `{"api_version": 2, "image_paths": `+versionTestFrames+`}`)
+	if got := rr.Header().Get(verificationHeader); got != "" {
+		t.Errorf("Expected no %s header without verify_path, got %q", verificationHeader, got)
+	}
+}
+
+// TestFlowRequestPathsOutsideDataDir checks that /flow rejects image paths
+// and a verify_path that escape the data directory.
+func TestFlowRequestPathsOutsideDataDir(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	for _, body := range []string{
+		`{"api_version": 2, "image_paths": ["../../rainfall_data/../go.mod", "../../rainfall_data/2025-10-03T14:45:00Z.png"]}`,
+		`{"api_version": 2, "image_paths": ` + versionTestFrames + `, "options": {"verify_path": "../../rainfall_data/../../go.mod"}}`,
+		`{"image_paths": ["../../go.mod", "../../rainfall_data/2025-10-03T14:45:00Z.png"]}`,
+	} {
+		if rr := postFlow(t, "", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, rr.Code)
+		}
+	}
+}
+
+// TestFlowRequestCancelled checks that /flow stops computing once the
+// request's context is done.
+func TestFlowRequestCancelled(t *testing.T) {
+	useDataDir(t, "../../rainfall_data")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/flow", strings.NewReader(`{"api_version": 2, "image_paths": `+versionTestFrames+`}`)).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	flowHandler(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 for a cancelled request, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,20 +1,31 @@
 package main
 
 import (
+	"errors"
+	"example/goflow/fileutil"
 	"example/goflow/flow"
 	"flag"
 	"fmt"
 	"image"
+	"image/draw"
 	"image/png"
+	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"gocv.io/x/gocv"
 )
 
 func main() {
 	// Run with os.Args, which includes the command name as the first element
-	RunMain(os.Args)
+	if err := RunMain(os.Args); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // RunMain processes the command line arguments and performs the appropriate action.
@@ -30,18 +41,48 @@ func RunMain(args []string) error {
 }
 
 func runMainWithArgs(args []string) error {
+	if len(args) > 0 && args[0] == "inspect" {
+		if len(args) != 2 {
+			return fmt.Errorf("usage for inspect: go run . inspect <artifact>")
+		}
+		return inspect(args[1], os.Stdout)
+	}
+	if len(args) > 0 && args[0] == "compare" {
+		return compare(args[1:], os.Stdout)
+	}
+
 	// Create a new flag set to avoid conflicts with the global flag package
 	fs := flag.NewFlagSet("", flag.ExitOnError)
 
 	// --- Standard Flow Generation Flags ---
 	outputPath := fs.String("output", "output_flow_map.png", "Path to save the output flow map image.")
-	resolutionFactor := fs.Int("resolution-factor", 4, "The factor by which to downscale the images before processing.")
+	outputFormat := fs.String("output-format", "png", "Format of the output: png (an encoded flow map), npy (the flow field as a (2, H, W) float64 NumPy array, as pysteps reads motion fields) or flo (the flow field in the Middlebury .flo format).")
+	resolutionFactor := fs.Int("resolution-factor", flow.AutoResolution, "The factor by which to downscale the images before processing; 0 chooses the smallest factor that keeps the flow map within -pixel-budget pixels.")
+	pixelBudget := fs.Int("pixel-budget", flow.DefaultPixelBudget, "Most pixels of the flow map when -resolution-factor is 0.")
+	pathsOut := fs.String("paths-out", "", "If set, path to save a plot of every tracked feature's path over the first frame.")
+	skipBad := fs.Bool("skip-bad-frames", false, "Skip unreadable or wrong-size frames instead of failing.")
+	overwrite := fs.Bool("overwrite", false, "Replace output files that already exist instead of failing.")
+	method := fs.String("method", "sparse", "How to compute the flow: sparse (LK features), dense (Farneback) or fused (both, blended by confidence).")
+	interpolation := fs.String("interpolation", "idw", "How to spread the sparse features over the flow map: idw (inverse distance weighting) or delaunay (linear within the triangles between them, without IDW's rings around each feature).")
+	minFeatures := fs.Int("min-features", flow.DefaultMinSurvivingFeatures, "Fewest features that must survive the sequence for the sparse flow map to be interpolated; with fewer the command fails, reporting how many survived. Zero or a negative value accepts any number.")
+	pixelSize := fs.Float64("pixel-size", 0, "Side of a full-resolution pixel in meters; with -frame-interval, reports the mean speed in m/s.")
+	frameInterval := fs.Duration("frame-interval", 0, "Time between consecutive frames, such as 5m.")
+	flowScale := fs.Float64("flow-scale", 0, "Flow map levels per pixel of displacement; lower values encode larger motion. 0 means 10 for 8-bit maps and 100 for 16-bit ones. The forward transformation must use the scale the map was made with.")
+	flowDepth := fs.Int("flow-depth", 8, "Bits per channel of the flow map, 8 or 16.")
+	occlusionOut := fs.String("occlusion-out", "", "If set, also compute the flow over the frames in reverse order and save a mask of the occluded flow map pixels, which fail the forward-backward check, to this path.")
+	roiMask := fs.String("roi-mask", "", "Path to a region-of-interest mask image; its black or transparent pixels, such as burned-in overlays, get no features and no flow.")
+	verifyPath := fs.String("verify", "", "Path to the frame held out after the sequence; the last frame is warped one frame interval ahead with the flow and compared with it, and the MSE and CSI against persistence are logged.")
+	verifyThreshold := fs.Float64("verify-threshold", 0, "Grey level at or above which a pixel is an event for the -verify CSI; 0 means 128.")
+	perFrameDir := fs.String("per-frame-output-dir", "", "If set, directory to also save the flow map of each pair of consecutive frames to, as pair_000.png, pair_001.png and so on.")
+	occlusionThreshold := fs.Float64("occlusion-threshold", 0, "Largest forward-backward error in full-resolution pixels of a pixel that is not occluded; 0 means 1.")
 
 	// --- Forward Flow Transformation Flags ---
 	forwardMode := fs.Bool("forward", false, "Enable forward optical flow transformation.")
 	forwardInput := fs.String("forward-input-image", "", "Path to the input image for forward transformation.")
 	forwardOutput := fs.String("forward-output-image", "forward_output.png", "Path to save the forward-transformed image.")
 	forwardFactor := fs.Float64("forward-factor", 1.0, "Factor to scale the flow vectors in forward transformation.")
+	forwardOcclusion := fs.String("forward-occlusion", "", "Path to an occlusion mask saved with -occlusion-out; its occluded pixels are not warped.")
+	forwardFill := fs.String("forward-fill", "", "Path to the destination frame to fill occluded pixels from; without it they keep the input image's value.")
 
 	// Parse the provided arguments
 	if err := fs.Parse(args); err != nil {
@@ -64,21 +105,34 @@ func runMainWithArgs(args []string) error {
 		log.Printf("Output image: %s", *forwardOutput)
 		log.Printf("Forward factor: %.2f", *forwardFactor)
 
+		opts := flow.ForwardOptions{Encoding: flow.Encoding{Scale: *flowScale}}
+		var extra []string
+		if *forwardOcclusion != "" {
+			mask, err := readMask(*forwardOcclusion)
+			if err != nil {
+				return err
+			}
+			opts.Occlusion = mask
+			extra = append(extra, *forwardOcclusion)
+		}
+		if *forwardFill != "" {
+			fill, err := readImage(*forwardFill)
+			if err != nil {
+				return err
+			}
+			opts.Fill = fill
+			extra = append(extra, *forwardFill)
+		}
+
 		// Call the new forward function from the 'flow' package
-		img, err := flow.ForwardTransform(*forwardInput, flowMapPath, *forwardFactor)
+		img, prov, err := forwardTransform(*forwardInput, flowMapPath, *forwardFactor, opts, extra...)
 		if err != nil {
 			return fmt.Errorf("error during forward transformation: %w", err)
 		}
 
 		// Save the resulting image
-		file, err := os.Create(*forwardOutput)
-		if err != nil {
-			return fmt.Errorf("error creating output file for forward image: %w", err)
-		}
-		defer file.Close()
-
-		if err := png.Encode(file, img); err != nil {
-			return fmt.Errorf("error encoding forward image: %w", err)
+		if err := writePNG(*forwardOutput, img, prov, *overwrite); err != nil {
+			return fmt.Errorf("error saving forward image: %w", err)
 		}
 
 		log.Printf("Successfully saved forward-transformed image to %s\n", *forwardOutput)
@@ -90,65 +144,367 @@ func runMainWithArgs(args []string) error {
 			return fmt.Errorf("usage for standard mode: go run . [flags] <frame1.png> <frame2.png> ...")
 		}
 
-		log.Printf("Starting average optical flow generation for %d frames...\n", len(imagePaths))
+		flowMethod, err := flow.ParseMethod(*method)
+		if err != nil {
+			return err
+		}
+		interpolationMode, err := flow.ParseInterpolationMode(*interpolation)
+		if err != nil {
+			return err
+		}
+		if *flowDepth != 8 && *flowDepth != 16 {
+			return fmt.Errorf("invalid -flow-depth %d: must be 8 or 16", *flowDepth)
+		}
+		if *outputFormat != "png" && *outputFormat != "npy" && *outputFormat != "flo" {
+			return fmt.Errorf("invalid -output-format %q: must be png, npy or flo", *outputFormat)
+		}
 
-		img, err := flow.GenerateAverageFlowMap(imagePaths, *resolutionFactor)
+		var region *image.Alpha
+		if *roiMask != "" {
+			img, err := readImage(*roiMask)
+			if err != nil {
+				return fmt.Errorf("error reading region mask: %w", err)
+			}
+			region = flow.MaskFromImage(img)
+		}
+
+		log.Printf("Starting average %s optical flow generation for %d frames...\n", flowMethod, len(imagePaths))
+
+		opts := flow.FlowOptions{
+			RecordPaths:          *pathsOut != "",
+			HashInputs:           true,
+			SkipBadFrames:        *skipBad,
+			Method:               flowMethod,
+			Units:                flow.Units{PixelSize: *pixelSize, FrameInterval: *frameInterval},
+			PixelBudget:          *pixelBudget,
+			Encoding:             flow.Encoding{Scale: *flowScale, Depth: *flowDepth},
+			Occlusion:            flow.OcclusionOptions{Detect: *occlusionOut != "", Threshold: *occlusionThreshold},
+			RegionMask:           region,
+			Interpolation:        flow.InterpolationOptions{Mode: interpolationMode},
+			MinSurvivingFeatures: *minFeatures,
+		}
+		result, err := flow.GenerateAverageFlowMapWithOptions(imagePaths, *resolutionFactor, opts)
+		var few *flow.TooFewFeaturesError
+		if errors.As(err, &few) && few.Surviving > 0 {
+			return fmt.Errorf("error generating flow map: %w; pass -min-features %d to accept it", err, few.Surviving)
+		}
 		if err != nil {
 			return fmt.Errorf("error generating flow map: %w", err)
 		}
+		if *resolutionFactor == flow.AutoResolution {
+			log.Printf("Chose resolution factor %d\n", result.Field.ResolutionFactor)
+		}
 
-		file, err := os.Create(*outputPath)
+		switch *outputFormat {
+		case "npy":
+			err = writeField(*outputPath, flow.EncodeNpy, result.Field, result.Provenance, *overwrite)
+		case "flo":
+			err = writeField(*outputPath, flow.EncodeFlo, result.Field, result.Provenance, *overwrite)
+		default:
+			err = writePNG(*outputPath, result.Image, result.Provenance, *overwrite)
+		}
 		if err != nil {
-			return fmt.Errorf("error creating output file: %w", err)
+			return fmt.Errorf("error saving flow map: %w", err)
+		}
+
+		log.Printf("Successfully generated average flow map: %s\n", *outputPath)
+		if speed, n := meanSpeed(result.Field); n > 0 {
+			log.Printf("Mean speed: %.2f m/s over %d field pixels\n", speed, n)
+		}
+		if result.NonFinite > 0 {
+			log.Printf("Dropped %d non-finite features and pixels\n", result.NonFinite)
+		}
+		if d := result.Dropped; d.TrackingError > 0 || d.RoundTrip > 0 {
+			log.Printf("Dropped %d features with too large a tracking error and %d failing the forward-backward check\n", d.TrackingError, d.RoundTrip)
+		}
+		if result.Reseeded > 0 {
+			log.Printf("Reseeded %d features\n", result.Reseeded)
+		}
+		for _, r := range result.Retries {
+			log.Printf("Retried tracking to %s: %.0f%% of the features survived, against %.0f%% at first\n", r.Frame, 100*r.Kept, 100*r.Survived)
+		}
+		if *verifyPath != "" {
+			report, err := flow.VerifyFlowResult(result, imagePaths, *verifyPath, flow.VerifyOptions{Threshold: *verifyThreshold})
+			if err != nil {
+				return fmt.Errorf("error verifying flow map: %w", err)
+			}
+			log.Printf("Verification against %s over %d pixels:\n", *verifyPath, report.Pixels)
+			log.Printf("  MSE: %.2f warped, %.2f persistence (%+.1f%%)\n", report.ForecastMSE, report.PersistenceMSE, 100*report.MSEImprovement)
+			log.Printf("  CSI at %g: %.3f warped, %.3f persistence (%+.3f)\n", report.Threshold, report.ForecastCSI, report.PersistenceCSI, report.CSIImprovement)
+		}
+		if len(result.Skipped) > 0 {
+			log.Printf("Skipped %d of %d frames:\n", len(result.Skipped), len(imagePaths))
+			for _, s := range result.Skipped {
+				log.Printf("  [%d] %s: %v\n", s.Index, s.Path, s.Err)
+			}
 		}
-		defer file.Close()
 
-		if err := png.Encode(file, img); err != nil {
-			return fmt.Errorf("error encoding image: %w", err)
+		if *occlusionOut != "" {
+			if err := writePNG(*occlusionOut, result.Occlusion, result.Provenance, *overwrite); err != nil {
+				return fmt.Errorf("error saving occlusion mask: %w", err)
+			}
+			log.Printf("Successfully saved occlusion mask: %s\n", *occlusionOut)
 		}
 
-		log.Printf("Successfully generated average flow map: %s\n", *outputPath)
+		if *perFrameDir != "" {
+			// Under AutoResolution the pairs get the same factor as the flow
+			// map.
+			n, err := writePairFlowMaps(imagePaths, *resolutionFactor, opts, *perFrameDir, *overwrite)
+			if err != nil {
+				return err
+			}
+			log.Printf("Successfully saved %d per-pair flow maps to %s\n", n, *perFrameDir)
+		}
+
+		if *pathsOut != "" {
+			// The paths start at the first frame that was not skipped.
+			first := 0
+			for _, s := range result.Skipped {
+				if s.Index == first {
+					first++
+				}
+			}
+			if err := writeFeaturePaths(imagePaths[first], result.Paths, *pathsOut, result.Provenance, *overwrite); err != nil {
+				return err
+			}
+			log.Printf("Successfully saved %d feature paths: %s\n", len(result.Paths), *pathsOut)
+		}
 	}
 
 	return nil
 }
 
-// RunFlowGeneration runs the flow generation logic with given parameters for testing
+// meanSpeed returns the mean speed in meters per second over the pixels of
+// field that hold data, and their number. It returns 0, 0 if the field is
+// not calibrated.
+func meanSpeed(field *flow.FlowField) (float64, int) {
+	var sum float64
+	var n int
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			if vx, vy, ok := field.VelocityAt(x, y); ok {
+				sum += math.Hypot(vx, vy)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return sum / float64(n), n
+}
+
+// RunFlowGeneration runs the flow generation logic with given parameters for testing.
+// It fails if outputPath already exists.
 func RunFlowGeneration(imagePaths []string, resolutionFactor int, outputPath string) error {
-	img, err := flow.GenerateAverageFlowMap(imagePaths, resolutionFactor)
+	result, err := flow.GenerateAverageFlowMapWithOptions(imagePaths, resolutionFactor, flow.FlowOptions{HashInputs: true})
 	if err != nil {
 		return fmt.Errorf("error generating flow map: %w", err)
 	}
 
-	file, err := os.Create(outputPath)
+	if err := writePNG(outputPath, result.Image, result.Provenance, false); err != nil {
+		return fmt.Errorf("error saving flow map: %w", err)
+	}
+
+	log.Printf("Successfully generated average flow map: %s\n", outputPath)
+	return nil
+}
+
+// writePairFlowMaps computes the flow of each pair of consecutive frames of
+// imagePaths with flow.GenerateFlowSequence and writes their maps, encoded
+// with opts.Encoding, to dir as pair_000.png and on, creating dir if it
+// does not exist. It returns the number of maps written.
+func writePairFlowMaps(imagePaths []string, resolutionFactor int, opts flow.FlowOptions, dir string, overwrite bool) (int, error) {
+	seq, err := flow.GenerateFlowSequence(imagePaths, resolutionFactor, opts, flow.SequenceOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("error generating per-pair flow maps: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, fmt.Errorf("error creating per-pair output directory: %w", err)
+	}
+	for k, pair := range seq.Pairs {
+		path := filepath.Join(dir, fmt.Sprintf("pair_%03d.png", k))
+		if err := writePNG(path, pair.Field.ImageWithEncoding(opts.Encoding), seq.Provenance, overwrite); err != nil {
+			return 0, fmt.Errorf("error saving per-pair flow map: %w", err)
+		}
+	}
+	return len(seq.Pairs), nil
+}
+
+// writePNG writes img at path with prov embedded; see flow.EncodePNG.
+func writePNG(path string, img image.Image, prov flow.Provenance, overwrite bool) error {
+	return fileutil.WriteAtomic(path, func(w io.Writer) error {
+		if err := flow.EncodePNG(w, img, prov); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		return nil
+	}, overwrite)
+}
+
+// writeField writes field at path with encode, such as flow.EncodeNpy,
+// with prov in a sidecar.
+func writeField(path string, encode func(io.Writer, *flow.FlowField) error, field *flow.FlowField, prov flow.Provenance, overwrite bool) error {
+	if err := fileutil.WriteAtomic(path, func(w io.Writer) error {
+		return encode(w, field)
+	}, overwrite); err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(flow.SidecarPath(path), prov.WriteJSON, overwrite)
+}
+
+// forwardTransform runs flow.ForwardTransformWithOptions and returns its
+// provenance, which also lists the extra inputs opts was read from.
+func forwardTransform(inputImagePath, flowMapPath string, factor float64, opts flow.ForwardOptions, extra ...string) (image.Image, flow.Provenance, error) {
+	prov := flow.NewProvenance("ForwardTransform", append([]string{inputImagePath, flowMapPath}, extra...), time.Now())
+	prov.HashInputs()
+	prov.Parameters = map[string]string{"factor": strconv.FormatFloat(factor, 'g', -1, 64)}
+	if opts.Encoding.Scale != 0 {
+		prov.Parameters["scale"] = strconv.FormatFloat(opts.Encoding.Scale, 'g', -1, 64)
+	}
+	img, err := flow.ForwardTransformWithOptions(inputImagePath, flowMapPath, factor, opts)
 	if err != nil {
-		return fmt.Errorf("error creating output file: %w", err)
+		return nil, flow.Provenance{}, err
 	}
-	defer file.Close()
+	prov.Finish()
+	return img, prov, nil
+}
 
-	if err := png.Encode(file, img); err != nil {
-		return fmt.Errorf("error encoding image: %w", err)
+// inspect prints the provenance of the artifact at path to w as JSON.
+func inspect(path string, w io.Writer) error {
+	prov, err := flow.ReadProvenance(path)
+	if err != nil {
+		return fmt.Errorf("error reading provenance: %w", err)
 	}
+	return prov.WriteJSON(w)
+}
 
-	log.Printf("Successfully generated average flow map: %s\n", outputPath)
-	return nil
+// compare parses the arguments of the compare subcommand, two flow fields
+// and an optional -flow-scale, and prints the endpoint error of the first
+// against the second to w.
+func compare(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	flowScale := fs.Float64("flow-scale", 0, "Flow map levels per pixel of displacement the PNG flow maps were made with; 0 means the default of their depth.")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage for compare: go run . compare [-flow-scale <levels>] <estimate> <reference>")
+	}
+	enc := flow.Encoding{Scale: *flowScale}
+	estimate, err := readField(fs.Arg(0), enc)
+	if err != nil {
+		return err
+	}
+	reference, err := readField(fs.Arg(1), enc)
+	if err != nil {
+		return err
+	}
+	c, err := flow.CompareFlowFields(estimate, reference)
+	if err != nil {
+		return fmt.Errorf("error comparing flow fields: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "pixels: %d\nmean EPE: %.4f\nmedian EPE: %.4f\n> 1 px: %.2f%%\n> 3 px: %.2f%%\n",
+		c.Pixels, c.MeanEPE, c.MedianEPE, c.Over1Px, c.Over3Px)
+	return err
 }
 
-// RunForwardTransform runs the forward transformation logic with given parameters for testing
-func RunForwardTransform(inputImagePath, flowMapPath string, factor float64, outputImagePath string) error {
-	img, err := flow.ForwardTransform(inputImagePath, flowMapPath, factor)
+// readImage decodes the PNG image at path.
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("error during forward transformation: %w", err)
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// readMask reads the occlusion mask saved at path by -occlusion-out.
+func readMask(path string) (*image.Alpha, error) {
+	img, err := readImage(path)
+	if err != nil {
+		return nil, err
 	}
+	mask := image.NewAlpha(img.Bounds())
+	draw.Draw(mask, mask.Rect, img, img.Bounds().Min, draw.Src)
+	return mask, nil
+}
 
-	file, err := os.Create(outputImagePath)
+// readField reads the flow field at path: an NPY array or a .flo file by
+// their extension, or else a PNG flow map decoded with enc.
+func readField(path string, enc flow.Encoding) (*flow.FlowField, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".npy":
+		return flow.ReadNpy(path)
+	case ".flo":
+		return flow.ReadFlo(path)
+	}
+	img, err := readImage(path)
 	if err != nil {
-		return fmt.Errorf("error creating output file for forward image: %w", err)
+		return nil, err
+	}
+	return flow.DecodeFlowMap(img, enc), nil
+}
+
+// writeFeaturePaths draws the feature paths over the given background frame
+// and saves the result to outputPath, with the provenance of the flow they
+// came from: embedded in a PNG, in a sidecar for other formats.
+func writeFeaturePaths(backgroundPath string, paths [][]gocv.Point2f, outputPath string, prov flow.Provenance, overwrite bool) error {
+	background := gocv.IMRead(backgroundPath, gocv.IMReadColor)
+	if background.Empty() {
+		return fmt.Errorf("failed to read image %s with gocv", backgroundPath)
+	}
+	defer background.Close()
+
+	plot := flow.DrawFeaturePaths(background, paths, flow.DrawOptions{Coloring: flow.ColorByDisplacement})
+	defer plot.Close()
+
+	if strings.EqualFold(filepath.Ext(outputPath), ".png") {
+		img, err := plot.ToImage()
+		if err != nil {
+			return fmt.Errorf("error converting feature paths: %w", err)
+		}
+		if err := writePNG(outputPath, img, prov, overwrite); err != nil {
+			return fmt.Errorf("error writing feature paths: %w", err)
+		}
+		return nil
+	}
+	if err := writeMat(outputPath, plot, overwrite); err != nil {
+		return fmt.Errorf("error writing feature paths: %w", err)
+	}
+	if err := fileutil.WriteAtomic(flow.SidecarPath(outputPath), prov.WriteJSON, overwrite); err != nil {
+		return fmt.Errorf("error writing provenance of feature paths: %w", err)
+	}
+	return nil
+}
+
+// writeMat encodes mat in the format of path's extension and writes it
+// atomically.
+func writeMat(path string, mat gocv.Mat, overwrite bool) error {
+	return fileutil.WriteAtomic(path, func(w io.Writer) error {
+		buf, err := gocv.IMEncode(gocv.FileExt(filepath.Ext(path)), mat)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		defer buf.Close()
+		_, err = w.Write(buf.GetBytes())
+		return err
+	}, overwrite)
+}
+
+// RunForwardTransform runs the forward transformation logic with given parameters for testing.
+// It fails if outputImagePath already exists.
+func RunForwardTransform(inputImagePath, flowMapPath string, factor float64, outputImagePath string) error {
+	img, prov, err := forwardTransform(inputImagePath, flowMapPath, factor, flow.ForwardOptions{})
+	if err != nil {
+		return fmt.Errorf("error during forward transformation: %w", err)
 	}
-	defer file.Close()
 
-	if err := png.Encode(file, img); err != nil {
-		return fmt.Errorf("error encoding forward image: %w", err)
+	if err := writePNG(outputImagePath, img, prov, false); err != nil {
+		return fmt.Errorf("error saving forward image: %w", err)
 	}
 
 	log.Printf("Successfully saved forward-transformed image to %s\n", outputImagePath)
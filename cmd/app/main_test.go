@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"example/goflow/flow"
+	"example/goflow/flow/synth"
 	"fmt"
 	"image"
+	"image/draw"
 	"image/png"
+	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -209,3 +218,288 @@ func TestForwardOpticalFlowArgs(t *testing.T) {
 	t.Logf("Generated flow map: %s", flowMapPath)
 	t.Logf("Forward transformation result: %s", outputPath)
 }
+
+// TestInspect checks that a generated flow map carries its provenance and
+// that inspect prints it.
+func TestInspect(t *testing.T) {
+	flowMapPath := filepath.Join(t.TempDir(), "flow.png")
+	frames := []string{"../../rainfall_data/2025-10-03T14:40:00Z.png", "../../rainfall_data/2025-10-03T14:45:00Z.png"}
+	if err := runMainWithArgs(append([]string{"-output", flowMapPath, "-method", "dense"}, frames...)); err != nil {
+		t.Fatalf("Failed to generate flow map: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := inspect(flowMapPath, &out); err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	var prov flow.Provenance
+	if err := json.Unmarshal(out.Bytes(), &prov); err != nil {
+		t.Fatalf("Failed to decode the printed provenance: %v\n%s", err, out.String())
+	}
+	if prov.Options == nil || prov.Options.Method != "dense" || len(prov.Inputs) != 2 || prov.Inputs[1].Path != frames[1] {
+		t.Errorf("Unexpected provenance: %s", out.String())
+	}
+	if err := runMainWithArgs([]string{"inspect"}); err == nil {
+		t.Error("Expected an error for inspect without an artifact")
+	}
+}
+
+// TestOutputFormats checks that -output-format npy and flo write the flow
+// field itself, with its provenance in a sidecar.
+func TestOutputFormats(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "flow.npy")
+	frames := []string{"../../rainfall_data/2025-10-03T14:40:00Z.png", "../../rainfall_data/2025-10-03T14:45:00Z.png"}
+	if err := runMainWithArgs(append([]string{"-output", outputPath, "-output-format", "npy", "-resolution-factor", "4"}, frames...)); err != nil {
+		t.Fatalf("Failed to generate the flow field: %v", err)
+	}
+	field, err := flow.ReadNpy(outputPath)
+	if err != nil {
+		t.Fatalf("ReadNpy failed: %v", err)
+	}
+	if field.Width == 0 || field.Height == 0 {
+		t.Errorf("Expected a non-empty field, got %dx%d", field.Width, field.Height)
+	}
+	if field.ResolutionFactor != 4 {
+		t.Errorf("Expected ReadNpy to restore factor 4 from the sidecar, got %d", field.ResolutionFactor)
+	}
+	if prov, err := flow.ReadProvenance(outputPath); err != nil || prov.ResolutionFactor != 4 {
+		t.Errorf("Expected the sidecar provenance of factor 4, got %+v, %v", prov, err)
+	}
+
+	floPath := filepath.Join(t.TempDir(), "flow.flo")
+	if err := runMainWithArgs(append([]string{"-output", floPath, "-output-format", "flo", "-resolution-factor", "4"}, frames...)); err != nil {
+		t.Fatalf("Failed to generate the .flo field: %v", err)
+	}
+	flo, err := flow.ReadFlo(floPath)
+	if err != nil {
+		t.Fatalf("ReadFlo failed: %v", err)
+	}
+	if flo.Width != field.Width || flo.Height != field.Height || flo.ResolutionFactor != 4 {
+		t.Errorf("Expected the .flo field to match the NPY one, got %dx%d at factor %d and %dx%d", flo.Width, flo.Height, flo.ResolutionFactor, field.Width, field.Height)
+	}
+
+	if err := runMainWithArgs(append([]string{"-output", outputPath, "-output-format", "tiff"}, frames...)); err == nil {
+		t.Error("Expected an error for an unknown output format")
+	}
+}
+
+// TestCompare checks that compare reads two flow maps and prints the
+// endpoint error of a known offset between them.
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	reference, estimate := flow.NewFlowField(8, 8), flow.NewFlowField(8, 8)
+	for i := range estimate.DX {
+		reference.DX[i], reference.DY[i] = 1, -1
+		estimate.DX[i], estimate.DY[i] = 3, -1
+	}
+	for name, field := range map[string]*flow.FlowField{"estimate.png": estimate, "reference.png": reference} {
+		if err := writePNG(filepath.Join(dir, name), field.Image(), flow.Provenance{}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := flow.WriteFlo(filepath.Join(dir, "reference.flo"), reference); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"reference.png", "reference.flo"} {
+		var out bytes.Buffer
+		if err := compare([]string{filepath.Join(dir, "estimate.png"), filepath.Join(dir, ref)}, &out); err != nil {
+			t.Fatalf("compare with %s failed: %v", ref, err)
+		}
+		want := "pixels: 64\nmean EPE: 2.0000\nmedian EPE: 2.0000\n> 1 px: 100.00%\n> 3 px: 0.00%\n"
+		if out.String() != want {
+			t.Errorf("compare with %s: expected\n%s\ngot\n%s", ref, want, out.String())
+		}
+	}
+	if err := runMainWithArgs([]string{"compare", filepath.Join(dir, "estimate.png")}); err == nil {
+		t.Error("Expected an error for compare with one flow field")
+	}
+}
+
+// TestOcclusionFlags checks that -occlusion-out saves a mask of the flow
+// map's size and that forward mode reads it and its fill frame.
+func TestOcclusionFlags(t *testing.T) {
+	dir := t.TempDir()
+	frames := []string{"../../rainfall_data/2025-10-03T14:40:00Z.png", "../../rainfall_data/2025-10-03T14:45:00Z.png"}
+	flowMapPath, maskPath := filepath.Join(dir, "flow.png"), filepath.Join(dir, "occlusion.png")
+	if err := runMainWithArgs(append([]string{"-output", flowMapPath, "-method", "dense", "-resolution-factor", "4", "-occlusion-out", maskPath}, frames...)); err != nil {
+		t.Fatalf("Failed to generate the flow map and occlusion mask: %v", err)
+	}
+	flowMap, err := readImage(flowMapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask, err := readMask(maskPath)
+	if err != nil {
+		t.Fatalf("readMask failed: %v", err)
+	}
+	if mask.Bounds() != flowMap.Bounds() {
+		t.Errorf("Expected a mask of the flow map's bounds %v, got %v", flowMap.Bounds(), mask.Bounds())
+	}
+	if prov, err := flow.ReadProvenance(maskPath); err != nil || prov.Options == nil || prov.Options.OcclusionThreshold != flow.DefaultOcclusionThreshold {
+		t.Errorf("Expected the mask to record the occlusion threshold, got %+v, %v", prov.Options, err)
+	}
+
+	outputPath := filepath.Join(dir, "forward.png")
+	if err := runMainWithArgs([]string{"-forward", "-forward-input-image", frames[1], "-forward-output-image", outputPath, "-forward-occlusion", maskPath, "-forward-fill", frames[1], flowMapPath}); err != nil {
+		t.Fatalf("Failed to apply the forward transformation with the mask: %v", err)
+	}
+	if prov, err := flow.ReadProvenance(outputPath); err != nil || len(prov.Inputs) != 4 || prov.Inputs[2].Path != maskPath {
+		t.Errorf("Expected the provenance to list the mask and fill frame, got %+v, %v", prov.Inputs, err)
+	}
+}
+
+func TestRegionMaskFlag(t *testing.T) {
+	dir := t.TempDir()
+	// The mask excludes the left half of the frames, given at a quarter of
+	// their size.
+	roi := image.NewGray(image.Rect(0, 0, 256, 256))
+	draw.Draw(roi, image.Rect(128, 0, 256, 256), image.White, image.Point{}, draw.Src)
+	maskPath, flowMapPath := filepath.Join(dir, "roi.png"), filepath.Join(dir, "flow.png")
+	f, err := os.Create(maskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, roi); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Only two features lie in the region, too few by default.
+	args := []string{"-output", flowMapPath, "-resolution-factor", "4", "-roi-mask", maskPath, "../../test_data/centered.png", "../../test_data/shifted.png"}
+	if err := runMainWithArgs(args); err == nil || !strings.Contains(err.Error(), "2 survived") || !strings.Contains(err.Error(), "-min-features 2") {
+		t.Fatalf("Expected the surviving count and the flag accepting it in the error, got %v", err)
+	}
+	if err := runMainWithArgs([]string{"-output", flowMapPath, "-resolution-factor", "4", "-min-features", "2", "-roi-mask", maskPath, "../../test_data/centered.png", "../../test_data/shifted.png"}); err != nil {
+		t.Fatalf("Failed to generate the flow map with a region mask: %v", err)
+	}
+	flowMap, err := readImage(flowMapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := flowMap.Bounds()
+	if _, _, _, a := flowMap.At(b.Dx()/4, b.Dy()/2).RGBA(); a != 0 {
+		t.Error("Expected no flow outside the region")
+	}
+	if _, _, _, a := flowMap.At(3*b.Dx()/4, b.Dy()/2).RGBA(); a == 0 {
+		t.Error("Expected flow inside the region")
+	}
+	if prov, err := flow.ReadProvenance(flowMapPath); err != nil || prov.Options == nil || !prov.Options.RegionMask {
+		t.Errorf("Expected the flow map to record the region mask, got %+v, %v", prov.Options, err)
+	}
+	if err := runMainWithArgs([]string{"-output", flowMapPath, "-overwrite", "-roi-mask", filepath.Join(dir, "missing.png"), "../../test_data/centered.png", "../../test_data/shifted.png"}); err == nil {
+		t.Error("Expected an error for a missing region mask")
+	}
+}
+
+// TestVerifyFlag checks that -verify logs the warped and persistence
+// scores against the held-out frame, and fails for a missing one.
+func TestVerifyFlag(t *testing.T) {
+	dir := t.TempDir()
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 3, Y: 2}}}, 4, 256, 256)
+	paths := make([]string, len(frames))
+	for i, frame := range frames {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("frame%d.png", i))
+		f, err := os.Create(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, frame); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	flowMapPath := filepath.Join(dir, "flow.png")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	if err := runMainWithArgs(append([]string{"-output", flowMapPath, "-resolution-factor", "2", "-verify", paths[3]}, paths[:3]...)); err != nil {
+		t.Fatalf("Failed to generate and verify the flow map: %v", err)
+	}
+	var warped, persistence float64
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if i := strings.Index(line, "MSE: "); i >= 0 {
+			fmt.Sscanf(line[i:], "MSE: %f warped, %f persistence", &warped, &persistence)
+		}
+	}
+	if warped <= 0 || warped >= persistence {
+		t.Errorf("Expected the warped MSE to be logged below persistence's, got %v and %v in:\n%s", warped, persistence, logs.String())
+	}
+	if err := runMainWithArgs(append([]string{"-output", flowMapPath, "-overwrite", "-verify", filepath.Join(dir, "missing.png")}, paths[:3]...)); err == nil {
+		t.Error("Expected an error for a missing verifying frame")
+	}
+}
+
+func TestPerFrameOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 3, Y: 2}}}, 4, 256, 256)
+	paths := make([]string, len(frames))
+	for i, frame := range frames {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("frame%d.png", i))
+		f, err := os.Create(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, frame); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	pairDir := filepath.Join(dir, "pairs")
+	args := append([]string{"-output", filepath.Join(dir, "flow.png"), "-resolution-factor", "2", "-per-frame-output-dir", pairDir}, paths...)
+	if err := runMainWithArgs(args); err != nil {
+		t.Fatalf("Failed to generate the per-pair flow maps: %v", err)
+	}
+	for k := 0; k < len(paths)-1; k++ {
+		img, err := readImage(filepath.Join(pairDir, fmt.Sprintf("pair_%03d.png", k)))
+		if err != nil {
+			t.Fatalf("Failed to read pair %d: %v", k, err)
+		}
+		field := flow.DecodeFlowMap(img, flow.Encoding{})
+		var sumX, sumY float64
+		for i := range field.DX {
+			sumX += field.DX[i]
+			sumY += field.DY[i]
+		}
+		n := float64(len(field.DX))
+		if dx, dy := 2*sumX/n, 2*sumY/n; math.Abs(dx-3) > 0.5 || math.Abs(dy-2) > 0.5 {
+			t.Errorf("Expected pair %d to show the (3, 2) px per frame motion, got (%.2f, %.2f)", k, dx, dy)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pairDir, fmt.Sprintf("pair_%03d.png", len(paths)-1))); !os.IsNotExist(err) {
+		t.Errorf("Expected %d pair maps only, got %v for another", len(paths)-1, err)
+	}
+}
+
+// TestMinFeaturesFlag checks that a sequence keeping fewer features than
+// -min-features fails with their count, and the flag value that would
+// accept it, in the error.
+func TestMinFeaturesFlag(t *testing.T) {
+	dir := t.TempDir()
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 3, Y: 2}}}, 3, 256, 256)
+	paths := make([]string, len(frames))
+	for i, frame := range frames {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("frame%d.png", i))
+		f, err := os.Create(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, frame); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	outputPath := filepath.Join(dir, "flow.png")
+	err := runMainWithArgs(append([]string{"-output", outputPath, "-resolution-factor", "2", "-min-features", "100000"}, paths...))
+	if err == nil {
+		t.Fatal("Expected an error with fewer features than -min-features")
+	}
+	m := regexp.MustCompile(`(\d+) survived the sequence, fewer than the 100000 required.*; pass -min-features (\d+) to accept it`).FindStringSubmatch(err.Error())
+	if m == nil || m[1] != m[2] || m[1] == "0" {
+		t.Errorf("Expected the surviving count and the -min-features to accept it in the error, got %q", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no flow map to be written, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Package fileutil writes output files atomically, so readers never see a
+// partially written file and a failed write leaves any existing file intact.
+package fileutil
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrExists is returned when the output path already exists and overwriting
+// was not requested.
+var ErrExists = errors.New("output file already exists")
+
+// File is an output file being written to a temporary file next to its
+// final path. Nothing appears at the final path until Commit succeeds.
+type File struct {
+	*os.File
+	path      string
+	overwrite bool
+	done      bool
+}
+
+// Create starts writing the file at path. If overwrite is false and path
+// already exists it returns an error wrapping ErrExists. The caller must
+// call Commit to publish the file or Abort to discard it.
+func Create(path string, overwrite bool) (*File, error) {
+	if !overwrite {
+		if _, err := os.Lstat(path); err == nil {
+			return nil, fmt.Errorf("%s: %w", path, ErrExists)
+		}
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	return &File{File: tmp, path: path, overwrite: overwrite}, nil
+}
+
+// Commit flushes the file to disk and moves it into place. Without
+// overwrite, a file that appeared at the path in the meantime is left alone
+// and ErrExists is returned. The temporary file is removed on failure.
+func (f *File) Commit() error {
+	if f.done {
+		return fmt.Errorf("%s: already committed or aborted", f.path)
+	}
+	f.done = true
+	tmp := f.Name()
+	defer os.Remove(tmp) // no-op once renamed
+
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		return fmt.Errorf("failed to sync %s: %w", f.path, err)
+	}
+	if err := f.File.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.path, err)
+	}
+	// CreateTemp makes the file private; give it the usual output mode.
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", f.path, err)
+	}
+
+	if f.overwrite {
+		if err := os.Rename(tmp, f.path); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", f.path, err)
+		}
+	} else {
+		// Unlike rename, link fails if the target exists, so concurrent
+		// writers cannot clobber each other.
+		if err := os.Link(tmp, f.path); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("%s: %w", f.path, ErrExists)
+			}
+			return fmt.Errorf("failed to move %s into place: %w", f.path, err)
+		}
+	}
+	syncDir(filepath.Dir(f.path))
+	return nil
+}
+
+// Abort discards the file. It is safe to call after Commit, so it can be
+// deferred.
+func (f *File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// syncDir makes a rename in dir durable. Not every platform supports
+// syncing a directory, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// WriteAtomic writes the file at path with write. The data goes to a
+// temporary file in the same directory, which is synced and then moved to
+// path, so path holds either its previous contents or the complete new
+// file. If write fails, path is untouched and the temporary file is removed.
+// If overwrite is false and path exists, it returns an error wrapping
+// ErrExists without calling write.
+func WriteAtomic(path string, write func(io.Writer) error, overwrite bool) error {
+	f, err := Create(path, overwrite)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// WritePNG encodes img as a PNG at path using WriteAtomic.
+func WritePNG(path string, img image.Image, overwrite bool) error {
+	return WriteAtomic(path, func(w io.Writer) error {
+		if err := png.Encode(w, img); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		return nil
+	}, overwrite)
+}
@@ -0,0 +1,137 @@
+package fileutil
+
+import (
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirEntries returns the names of the files in dir.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriteAtomicFailedWriteKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flow.png")
+	if err := os.WriteFile(path, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	encodeErr := errors.New("encoder crashed")
+	err := WriteAtomic(path, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return encodeErr
+	}, true)
+	if !errors.Is(err, encodeErr) {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "original" {
+		t.Errorf("Original file was modified: %q", data)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("Expected only the original file, found %v", names)
+	}
+}
+
+func TestWriteAtomicOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	write := func(s string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, s)
+			return err
+		}
+	}
+
+	if err := WriteAtomic(path, write("first"), false); err != nil {
+		t.Fatalf("First write failed: %v", err)
+	}
+	if err := WriteAtomic(path, write("second"), false); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists without overwrite, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "first" {
+		t.Errorf("Expected the first contents to survive, got %q", data)
+	}
+
+	if err := WriteAtomic(path, write("third"), true); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "third" {
+		t.Errorf("Expected the overwritten contents, got %q", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("Expected mode 0644, got %v", info.Mode().Perm())
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("Expected no temporary files, found %v", names)
+	}
+}
+
+func TestCommitRacesWithoutOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+
+	f, err := Create(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "late")
+	// Another writer publishes the file first.
+	if err := os.WriteFile(path, []byte("early"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Commit(); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "early" {
+		t.Errorf("Expected the first writer's file to survive, got %q", data)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("Expected no temporary files, found %v", names)
+	}
+}
+
+func TestAbortRemovesTemporaryFile(t *testing.T) {
+	dir := t.TempDir()
+	f, err := Create(filepath.Join(dir, "out.arrow"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "data")
+	f.Abort()
+	if names := dirEntries(t, dir); len(names) != 0 {
+		t.Errorf("Expected an empty directory, found %v", names)
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "img.png")
+	if err := WritePNG(path, image.NewGray(image.Rect(0, 0, 4, 4)), false); err != nil {
+		t.Fatalf("WritePNG failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the PNG to exist: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// Accumulator tracks features through a sequence of frames that arrive one
+// at a time, so a growing sequence does not have to be reprocessed from the
+// start. Feeding it the frames of a sequence in order and calling FlowMap
+// gives the same result as GenerateAverageFlowMap over that sequence.
+//
+// An Accumulator is not safe for concurrent use.
+type Accumulator struct {
+	opts          FlowOptions
+	frames        int
+	width, height int // of the first frame
+	lastName      string
+	prevMat       gocv.Mat
+	initialPoints gocv.Mat
+	currentPoints gocv.Mat
+	paths         [][]gocv.Point2f
+	dense         *denseTracks // nil under MethodSparse
+	illumination  []IlluminationChange
+	dropped       DroppedFeatures
+	reseeded      int   // features detected under FlowOptions.Reseed
+	starts        []int // frame each feature was detected in, nil until a reseed
+	retries       []RetriedPair
+	loss          featureLoss // the frame pair that lost the most features
+}
+
+// NewAccumulator returns an empty Accumulator configured by opts.
+func NewAccumulator(opts FlowOptions) *Accumulator {
+	return &Accumulator{
+		opts:          opts,
+		prevMat:       gocv.NewMat(),
+		initialPoints: gocv.NewMat(),
+		currentPoints: gocv.NewMat(),
+	}
+}
+
+// Close releases the matrices held by the accumulator, returning the last
+// frame to FlowOptions.Workspace if it is set.
+func (a *Accumulator) Close() {
+	a.opts.Workspace.release(a.prevMat)
+	a.initialPoints.Close()
+	a.currentPoints.Close()
+}
+
+// Frames returns the number of frames added so far.
+func (a *Accumulator) Frames() int {
+	return a.frames
+}
+
+// Paths returns the recorded path of every surviving feature, or nil if
+// paths are not being recorded. See FlowResult.Paths. Features seeded under
+// FlowOptions.Reseed are left out, as they were not tracked through every
+// frame.
+func (a *Accumulator) Paths() [][]gocv.Point2f {
+	if a.reseeded == 0 {
+		return a.paths
+	}
+	paths := [][]gocv.Point2f{}
+	for _, path := range a.paths {
+		if len(path) == a.frames {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Illumination returns the illumination change estimated between each pair
+// of consecutive frames so far. See FlowResult.Illumination.
+func (a *Accumulator) Illumination() []IlluminationChange {
+	return a.illumination
+}
+
+// NonFinite returns the number of features dropped so far because LK
+// tracked them to a NaN or infinite position.
+func (a *Accumulator) NonFinite() int {
+	return a.dropped.NonFinite
+}
+
+// Dropped returns the features dropped so far while tracking, by reason.
+func (a *Accumulator) Dropped() DroppedFeatures {
+	return a.dropped
+}
+
+// AddImagePath loads the PNG frame at path and adds it to the sequence.
+func (a *Accumulator) AddImagePath(path string) error {
+	mat, err := loadAndPrepImage(path, a.opts.Workspace)
+	if err != nil {
+		return a.loadError(path, err)
+	}
+	return a.addMat(mat, path, a.frames)
+}
+
+// loadError wraps the error from loading the frame at path.
+func (a *Accumulator) loadError(path string, err error) error {
+	if a.frames == 0 {
+		return fmt.Errorf("failed to load initial image %s: %w", path, err)
+	}
+	return fmt.Errorf("failed to load image %s: %w", path, err)
+}
+
+// AddImage adds an already decoded frame to the sequence. name identifies
+// the frame in error messages.
+func (a *Accumulator) AddImage(img image.Image, name string) error {
+	mat, err := prepImage(img, a.opts.Workspace)
+	if err != nil {
+		return fmt.Errorf("failed to prepare image %s: %w", name, err)
+	}
+	return a.addMat(mat, name, a.frames)
+}
+
+// checkSize returns an error wrapping ErrFrameSize if mat differs in size
+// from the first frame added, and nil if no frame was added yet.
+func (a *Accumulator) checkSize(mat gocv.Mat) error {
+	if a.frames == 0 {
+		return nil
+	}
+	return checkFrameSize(mat, a.width, a.height)
+}
+
+// addMat adds a grayscale frame, taking ownership of mat. index is the
+// frame's position in the sequence, which a RetriedPair records. If the
+// frame cannot be tracked, or differs in size from the first frame, the
+// accumulator is left unchanged.
+func (a *Accumulator) addMat(mat gocv.Mat, name string, index int) error {
+	if err := a.checkSize(mat); err != nil {
+		mat.Close()
+		return fmt.Errorf("failed to add image %s: %w", name, err)
+	}
+	sparse := a.opts.Method != MethodDense
+	if a.frames == 0 {
+		if sparse {
+			points, err := findGoodFeatures(mat, name, a.opts.Features, a.opts.RegionMask)
+			if err != nil {
+				mat.Close()
+				return err
+			}
+			a.replace(mat, points, points.Clone())
+			if a.opts.RecordPaths {
+				a.paths = make([][]gocv.Point2f, a.currentPoints.Rows())
+				for j := range a.paths {
+					a.paths[j] = []gocv.Point2f{pointAt(a.currentPoints, j)}
+				}
+			}
+		} else {
+			a.replace(mat, gocv.NewMat(), gocv.NewMat())
+		}
+		if a.opts.Method != MethodSparse {
+			a.dense = newDenseTracks(mat.Cols(), mat.Rows())
+		}
+		a.frames, a.lastName = 1, name
+		a.width, a.height = mat.Cols(), mat.Rows()
+		return nil
+	}
+
+	var change IlluminationChange
+	if a.opts.Illumination != IlluminationIgnore {
+		var err error
+		change, err = EstimateIllumination(a.prevMat, mat)
+		if err != nil {
+			mat.Close()
+			return fmt.Errorf("failed to estimate the illumination change to %s: %w", name, err)
+		}
+		if a.opts.Illumination == IlluminationCorrect && change.Estimated {
+			corrected := correctIllumination(mat, change)
+			a.opts.Workspace.release(mat)
+			mat = corrected
+			change.Applied = true
+		}
+	}
+
+	var newInitialPoints, newCurrentPoints gocv.Mat
+	var keptRows []int
+	var seeded []gocv.Point2f
+	var retry *RetriedPair
+	var dropped DroppedFeatures
+	attempted := 0
+	if sparse {
+		initialPoints, currentPoints := a.initialPoints, a.currentPoints
+		if seeded = a.reseedPoints(); len(seeded) > 0 {
+			initialPoints = appendPoints(a.initialPoints, seeded)
+			currentPoints = appendPoints(a.currentPoints, seeded)
+			defer initialPoints.Close()
+			defer currentPoints.Close()
+		}
+		if currentPoints.Rows() == 0 {
+			mat.Close()
+			return fmt.Errorf("all features lost before reaching frame %s", name)
+		}
+		var t tracked
+		t, retry = a.track(mat, initialPoints, currentPoints, name, index)
+		if t.err != nil {
+			mat.Close()
+			return t.err
+		}
+		newInitialPoints, newCurrentPoints, keptRows, dropped = t.initialPoints, t.currentPoints, t.keptRows, t.dropped
+		attempted = currentPoints.Rows()
+	} else {
+		newInitialPoints, newCurrentPoints = gocv.NewMat(), gocv.NewMat()
+	}
+
+	if a.dense != nil {
+		if err := a.dense.advance(a.prevMat, mat, a.opts.Farneback); err != nil {
+			newInitialPoints.Close()
+			newCurrentPoints.Close()
+			mat.Close()
+			return fmt.Errorf("failed to track %s densely: %w", name, err)
+		}
+	}
+
+	if sparse && a.opts.RecordPaths {
+		// Keep only the paths of the surviving features, in the same
+		// order as the rows of newCurrentPoints, and extend them. The
+		// paths of reseeded features start in the last frame.
+		kept := make([][]gocv.Point2f, len(keptRows))
+		for idx, srcIdx := range keptRows {
+			var path []gocv.Point2f
+			if srcIdx < len(a.paths) {
+				path = a.paths[srcIdx]
+			} else {
+				path = []gocv.Point2f{seeded[srcIdx-len(a.paths)]}
+			}
+			kept[idx] = append(path, pointAt(newCurrentPoints, idx))
+		}
+		a.paths = kept
+	}
+
+	if a.opts.Illumination != IlluminationIgnore {
+		a.illumination = append(a.illumination, change)
+	}
+	if sparse {
+		a.keepStarts(keptRows, len(seeded))
+	}
+	a.replace(mat, newInitialPoints, newCurrentPoints)
+	a.dropped.add(dropped)
+	a.reseeded += len(seeded)
+	if retry != nil {
+		a.retries = append(a.retries, *retry)
+	}
+	a.loss.record(a.lastName, name, attempted-len(keptRows), attempted)
+	a.frames++
+	a.lastName = name
+	return nil
+}
+
+// replace releases the held matrices, as Close does, and takes ownership
+// of the given ones.
+func (a *Accumulator) replace(prevMat, initialPoints, currentPoints gocv.Mat) {
+	a.Close()
+	a.prevMat = prevMat
+	a.initialPoints = initialPoints
+	a.currentPoints = currentPoints
+}
+
+// FlowMap returns the dense flow visualization of the frames added so far,
+// as GenerateAverageFlowMap would, encoded with FlowOptions.Encoding.
+func (a *Accumulator) FlowMap(resolutionFactor int) (image.Image, error) {
+	field, err := a.FlowField(resolutionFactor)
+	if err != nil {
+		return nil, err
+	}
+	return field.image(a.opts.Encoding, a.opts.Workspace), nil
+}
+
+// FlowField returns the flow field of the frames added so far, computed
+// with the configured Method and calibrated with FlowOptions.Units. The
+// displacements span one frame interval per frame added after the first.
+// Pixels whose displacement is not finite are marked as no data and
+// counted, with the features dropped while tracking, in
+// FlowField.NonFinite; if that leaves no data at all, FlowField returns
+// ErrNonFiniteField. A resolutionFactor of AutoResolution chooses the
+// factor for the first frame's size under FlowOptions.PixelBudget; the
+// field's ResolutionFactor records the one used, and its displacements are
+// in full-resolution pixels.
+func (a *Accumulator) FlowField(resolutionFactor int) (*FlowField, error) {
+	return a.flowFieldContext(context.Background(), resolutionFactor)
+}
+
+// flowFieldContext is FlowField, returning ctx.Err() if ctx is done before
+// the interpolation completes.
+func (a *Accumulator) flowFieldContext(ctx context.Context, resolutionFactor int) (*FlowField, error) {
+	if a.frames < 2 {
+		return nil, fmt.Errorf("at least two images are required, but got %d", a.frames)
+	}
+	if err := a.checkSurvivors(); err != nil {
+		return nil, err
+	}
+	auto := resolutionFactor == AutoResolution
+	resolutionFactor = a.opts.resolveResolutionFactor(resolutionFactor, a.width, a.height)
+	field, err := a.flowField(ctx, resolutionFactor)
+	if err != nil {
+		return nil, err
+	}
+	field.NonFinite += a.dropped.NonFinite
+	if err := field.checkFinite(); err != nil {
+		return nil, err
+	}
+	field.ResolutionFactor = resolutionFactor
+	field.Intervals = a.frames - 1
+	field.Units = a.opts.Units
+	if auto {
+		field.toFullResolution()
+	}
+	return field, nil
+}
+
+// flowField computes the uncalibrated flow field with the configured Method,
+// on the size of the first frame divided by resolutionFactor.
+func (a *Accumulator) flowField(ctx context.Context, resolutionFactor int) (*FlowField, error) {
+	scaledWidth := a.width / resolutionFactor
+	scaledHeight := a.height / resolutionFactor
+	if a.opts.Method == MethodDense {
+		return a.dense.field(scaledWidth, scaledHeight, resolutionFactor, a.opts.noDataMask()), nil
+	}
+	currentPoints := a.currentPoints
+	if a.starts != nil {
+		currentPoints = a.spanningPoints()
+		defer currentPoints.Close()
+	}
+	field, confidence, err := interpolateFlowField(ctx, a.initialPoints, currentPoints, scaledWidth, scaledHeight, resolutionFactor, a.opts.noDataMask(), a.opts.Interpolation, a.opts.Workspace)
+	if err != nil || a.opts.Method == MethodSparse {
+		return field, err
+	}
+	dense := a.dense.field(scaledWidth, scaledHeight, resolutionFactor, a.opts.noDataMask())
+	return FuseFields(field, confidence, dense, a.opts.Fuse)
+}
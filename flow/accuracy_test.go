@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"testing"
+)
+
+// TestAccuracyRotatingScene reports the mean endpoint error of the sparse
+// LK and dense Farneback paths on a scene rotating about its centre, where
+// the displacement grows from zero at the centre to several pixels at the
+// edges.
+func TestAccuracyRotatingScene(t *testing.T) {
+	const resolutionFactor = 4
+	spec := synth.MotionSpec{Background: synth.Motion{
+		Rotation: 0.01,
+		Center:   synth.Point{X: frameSize / 2, Y: frameSize / 2},
+	}}
+	frames, truth := synth.GenerateSequence(spec, 2, frameSize, frameSize)
+
+	for _, tc := range []struct {
+		method Method
+		maxEPE float64
+	}{
+		{MethodSparse, 1},
+		{MethodDense, 1},
+	} {
+		acc := NewAccumulator(FlowOptions{Method: tc.method})
+		for _, f := range frames {
+			if err := acc.AddImage(f, "frame"); err != nil {
+				t.Fatalf("%s: AddImage failed: %v", tc.method, err)
+			}
+		}
+		field, err := acc.FlowField(resolutionFactor)
+		acc.Close()
+		if err != nil {
+			t.Fatalf("%s: FlowField failed: %v", tc.method, err)
+		}
+
+		// Compare at full resolution, away from the borders where the
+		// scene rotates in and out of the frame.
+		const border = 64 / resolutionFactor
+		var sum float64
+		var n int
+		for y := border; y < field.Height-border; y++ {
+			for x := border; x < field.Width-border; x++ {
+				dx, dy, valid := field.At(x, y)
+				if !valid {
+					continue
+				}
+				sum += truth[0].EndpointError(x*resolutionFactor, y*resolutionFactor, dx*resolutionFactor, dy*resolutionFactor)
+				n++
+			}
+		}
+		if n == 0 {
+			t.Fatalf("%s: no valid flow", tc.method)
+		}
+		epe := sum / float64(n)
+		t.Logf("%s: mean endpoint error %.3f pixels over %d samples", tc.method, epe, n)
+		if epe > tc.maxEPE {
+			t.Errorf("%s: mean endpoint error %.3f exceeds %.1f pixels", tc.method, epe, tc.maxEPE)
+		}
+	}
+}
@@ -1,18 +1,15 @@
 package main
 
 import (
+	"example/goflow/fileutil"
 	"example/goflow/flow"
 	"fmt"
 	"image"
-	"image/png"
 	"os"
 
 	"gocv.io/x/gocv"
 )
 
-const originalWidth = 1024
-const originalHeight = 1024
-
 // resizeImage loads an image and resizes it using gocv to match flow map dimensions.
 func resizeImage(imgPath string, width, height int) (image.Image, error) {
 	mat := gocv.IMRead(imgPath, gocv.IMReadColor)
@@ -45,8 +42,6 @@ func main() {
 
 	// Use a resolution factor to determine flow map size
 	resolutionFactor := 4
-	scaledWidth := originalWidth / resolutionFactor
-	scaledHeight := originalHeight / resolutionFactor
 
 	// 1. Generate the optical flow from image1 to image2
 	fmt.Println("Generating optical flow from image 1 to image 2...")
@@ -55,21 +50,15 @@ func main() {
 		fmt.Printf("Error generating flow map: %v\n", err)
 		os.Exit(1)
 	}
+	scaledWidth, scaledHeight := flowMap.Bounds().Dx(), flowMap.Bounds().Dy()
 
 	// 2. Save the flow map to a temporary file for the forward transform
 	tempFlowPath := "temp_flow_map.png"
-	file, err := os.Create(tempFlowPath)
-	if err != nil {
-		fmt.Printf("Error creating temporary flow map file: %v\n", err)
+	if err := fileutil.WritePNG(tempFlowPath, flowMap, true); err != nil {
+		fmt.Printf("Error saving temporary flow map: %v\n", err)
 		os.Exit(1)
 	}
 	defer os.Remove(tempFlowPath) // Clean up the temp file
-	defer file.Close()
-
-	if err := png.Encode(file, flowMap); err != nil {
-		fmt.Printf("Error encoding flow map: %v\n", err)
-		os.Exit(1)
-	}
 
 	// 3. Resize image2 to match the flow map dimensions
 	fmt.Printf("Resizing image 2 to match flow map dimensions (%d x %d)...\n", scaledWidth, scaledHeight)
@@ -81,18 +70,11 @@ func main() {
 
 	// 4. Save the resized image 2 to a temporary file
 	tempImage2Path := "temp_image2_resized.png"
-	tempImage2File, err := os.Create(tempImage2Path)
-	if err != nil {
-		fmt.Printf("Error creating temporary resized image 2 file: %v\n", err)
+	if err := fileutil.WritePNG(tempImage2Path, resizedImage2, true); err != nil {
+		fmt.Printf("Error saving temporary resized image 2: %v\n", err)
 		os.Exit(1)
 	}
 	defer os.Remove(tempImage2Path) // Clean up the temp file
-	defer tempImage2File.Close()
-
-	if err := png.Encode(tempImage2File, resizedImage2); err != nil {
-		fmt.Printf("Error encoding resized image 2: %v\n", err)
-		os.Exit(1)
-	}
 
 	// 5. Apply forward transformation to the resized image2 using the flow map from image1->image2
 	// This should generate something similar to the resized version of image3 if the motion pattern continues
@@ -105,15 +87,8 @@ func main() {
 
 	// 6. Save the forward-transformed image
 	forwardedPath := "forwarded_result.png"
-	forwardedFile, err := os.Create(forwardedPath)
-	if err != nil {
-		fmt.Printf("Error creating forwarded result file: %v\n", err)
-		os.Exit(1)
-	}
-	defer forwardedFile.Close()
-
-	if err := png.Encode(forwardedFile, forwardedImage); err != nil {
-		fmt.Printf("Error encoding forwarded image: %v\n", err)
+	if err := fileutil.WritePNG(forwardedPath, forwardedImage, true); err != nil {
+		fmt.Printf("Error saving forwarded image: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -127,15 +102,8 @@ func main() {
 
 	// 8. Save the resized image 3 for comparison
 	resizedImage3Path := "actual_image3_resized.png"
-	resizedImage3File, err := os.Create(resizedImage3Path)
-	if err != nil {
-		fmt.Printf("Error creating resized image 3 file: %v\n", err)
-		os.Exit(1)
-	}
-	defer resizedImage3File.Close()
-
-	if err := png.Encode(resizedImage3File, resizedImage3); err != nil {
-		fmt.Printf("Error encoding resized image 3: %v\n", err)
+	if err := fileutil.WritePNG(resizedImage3Path, resizedImage3, true); err != nil {
+		fmt.Printf("Error saving resized image 3: %v\n", err)
 		os.Exit(1)
 	}
 
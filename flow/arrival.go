@@ -0,0 +1,134 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ArrivalTimeMap estimates, for every pixel of mask, the number of frame
+// intervals until the rain in mask reaches it under field. mask is indexed
+// [y][x] and is true where it rains now. The field is resampled to the size
+// of mask and divided by its Intervals, as in GenerateForecast.
+//
+// The mask is advected maxSteps times. Every wet pixel starts a particle at
+// its centre, which each step moves by the field interpolated where it is;
+// the particles are then splatted to their nearest pixels, and the splat is
+// closed, dilated by one pixel and eroded back, which fills the holes it
+// leaves where the flow diverges without growing the rain area. Each pixel
+// gets the first step at which it is wet: 0 where it rains now and -1 if it
+// stays dry for maxSteps steps. Particles that leave the image or reach a
+// pixel without motion are dropped.
+func ArrivalTimeMap(mask [][]bool, field FlowField, maxSteps int) ([][]int, error) {
+	if len(mask) == 0 || len(mask[0]) == 0 {
+		return nil, errors.New("arrival time mask must not be empty")
+	}
+	width, height := len(mask[0]), len(mask)
+	for y, row := range mask {
+		if len(row) != width {
+			return nil, fmt.Errorf("arrival time mask row %d has %d pixels, want %d", y, len(row), width)
+		}
+	}
+	if field.Width == 0 || field.Height == 0 {
+		return nil, errors.New("arrival time map needs a non-empty flow field")
+	}
+	if maxSteps < 1 {
+		return nil, fmt.Errorf("arrival time steps must be at least 1, got %d", maxSteps)
+	}
+	step := field.perStep(width, height)
+
+	arrival := make([][]int, height)
+	var particles [][2]float64
+	for y, row := range mask {
+		arrival[y] = make([]int, width)
+		for x, wet := range row {
+			arrival[y][x] = -1
+			if wet {
+				arrival[y][x] = 0
+				particles = append(particles, [2]float64{float64(x), float64(y)})
+			}
+		}
+	}
+
+	wet := make([]bool, width*height)
+	for k := 1; k <= maxSteps && len(particles) > 0; k++ {
+		clear(wet)
+		moved := particles[:0]
+		for _, p := range particles {
+			dx, dy, ok := step.sample(p[0], p[1])
+			if !ok {
+				continue
+			}
+			p = [2]float64{p[0] + dx, p[1] + dy}
+			x, y := int(math.Round(p[0])), int(math.Round(p[1]))
+			if x < 0 || y < 0 || x >= width || y >= height {
+				continue
+			}
+			wet[y*width+x] = true
+			moved = append(moved, p)
+		}
+		particles = moved
+		for i, w := range closeMask(wet, width, height) {
+			if w && arrival[i/width][i%width] < 0 {
+				arrival[i/width][i%width] = k
+			}
+		}
+	}
+	return arrival, nil
+}
+
+// closeMask returns the morphological closing of a width x height mask by a
+// 3x3 square: a dilation followed by an erosion. Pixels beyond the border
+// count as set for the erosion, so the closing never clears a set pixel.
+func closeMask(mask []bool, width, height int) []bool {
+	morph := func(in []bool, dilate bool) []bool {
+		out := make([]bool, len(in))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				v := !dilate
+				for j := max(0, y-1); j <= min(height-1, y+1); j++ {
+					for i := max(0, x-1); i <= min(width-1, x+1); i++ {
+						if in[j*width+i] == dilate {
+							v = dilate
+						}
+					}
+				}
+				out[y*width+x] = v
+			}
+		}
+		return out
+	}
+	return morph(morph(mask, true), false)
+}
+
+// ArrivalImage renders an arrival time map, as returned by ArrivalTimeMap
+// with maxSteps, on the blue-green-red ramp of the path plots: red where the
+// rain arrives in the next interval, through green, to blue where it
+// arrives after maxSteps. Pixels where it rains now are white and pixels it
+// does not reach are transparent.
+func ArrivalImage(arrival [][]int, maxSteps int) *image.NRGBA {
+	height := len(arrival)
+	width := 0
+	if height > 0 {
+		width = len(arrival[0])
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y, row := range arrival {
+		for x, k := range row {
+			switch {
+			case k == 0:
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+			case k > 0:
+				t := 1.0
+				if maxSteps > 1 {
+					t = 1 - float64(k-1)/float64(maxSteps-1)
+				}
+				c := rampColor(t)
+				img.SetNRGBA(x, y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: c.A})
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,64 @@
+package flow
+
+import "testing"
+
+// TestArrivalTimeMapMovingBand checks that a vertical rain band moving right
+// at 2 pixels per step reaches the pixels ahead of it at times growing
+// linearly with their distance, and never reaches those behind it.
+func TestArrivalTimeMapMovingBand(t *testing.T) {
+	const width, height, maxSteps = 64, 16, 20
+	const bandStart, bandEnd = 8, 12 // x range of the band, end excluded
+	mask := make([][]bool, height)
+	for y := range mask {
+		mask[y] = make([]bool, width)
+		for x := bandStart; x < bandEnd; x++ {
+			mask[y][x] = true
+		}
+	}
+	field := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			field.Set(x, y, 2, 0)
+		}
+	}
+
+	arrival, err := ArrivalTimeMap(mask, *field, maxSteps)
+	if err != nil {
+		t.Fatalf("ArrivalTimeMap failed: %v", err)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// The band's leading edge is at bandEnd-1+2k after k steps.
+			want := -1
+			switch {
+			case x >= bandStart && x < bandEnd:
+				want = 0
+			case x >= bandEnd:
+				if k := (x - (bandEnd - 1) + 1) / 2; k <= maxSteps {
+					want = k
+				}
+			}
+			if arrival[y][x] != want {
+				t.Fatalf("arrival[%d][%d] = %d, want %d", y, x, arrival[y][x], want)
+			}
+		}
+	}
+
+	img := ArrivalImage(arrival, maxSteps)
+	if c := img.NRGBAAt(bandStart, 0); c.R != 255 || c.G != 255 || c.B != 255 || c.A != 255 {
+		t.Errorf("Expected the band to be white, got %v", c)
+	}
+	if c := img.NRGBAAt(bandEnd, 0); c.R != 255 || c.B != 0 {
+		t.Errorf("Expected the next step to be red, got %v", c)
+	}
+	if c := img.NRGBAAt(0, 0); c.A != 0 {
+		t.Errorf("Expected pixels behind the band to be transparent, got %v", c)
+	}
+
+	if _, err := ArrivalTimeMap(mask, *field, 0); err == nil {
+		t.Error("Expected an error for no steps")
+	}
+	if _, err := ArrivalTimeMap([][]bool{{true}, {true, false}}, *field, 1); err == nil {
+		t.Error("Expected an error for a ragged mask")
+	}
+}
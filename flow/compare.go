@@ -0,0 +1,66 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrNoCommonPixels is returned by CompareFlowFields when no pixel holds a
+// finite displacement in both fields.
+var ErrNoCommonPixels = errors.New("flow: flow fields have no pixels with data in common")
+
+// FlowComparison summarizes the endpoint error, the length of the
+// difference between two displacements, of one flow field against another,
+// in pixels of the fields.
+type FlowComparison struct {
+	MeanEPE, MedianEPE float64
+	// Over1Px and Over3Px are the percentages of the compared pixels whose
+	// endpoint error exceeds 1 and 3 pixels.
+	Over1Px, Over3Px float64
+	// Pixels is the number of pixels compared: those holding a finite
+	// displacement in both fields.
+	Pixels int
+}
+
+// CompareFlowFields returns the endpoint error of a against b in field
+// pixels, over the pixels where both hold a finite displacement. The fields must have the
+// same size; it returns ErrNoCommonPixels if they share no pixel with data.
+func CompareFlowFields(a, b *FlowField) (FlowComparison, error) {
+	if a.Width != b.Width || a.Height != b.Height {
+		return FlowComparison{}, fmt.Errorf("cannot compare a %dx%d flow field with a %dx%d one", a.Width, a.Height, b.Width, b.Height)
+	}
+	a, b = a.fieldPixels(), b.fieldPixels()
+	errs := make([]float64, 0, len(a.Valid))
+	var c FlowComparison
+	for i := range a.Valid {
+		if !a.Valid[i] || !b.Valid[i] {
+			continue
+		}
+		ex, ey := a.DX[i]-b.DX[i], a.DY[i]-b.DY[i]
+		if !finite(ex) || !finite(ey) {
+			continue
+		}
+		e := math.Hypot(ex, ey)
+		errs = append(errs, e)
+		c.MeanEPE += e
+		if e > 1 {
+			c.Over1Px++
+		}
+		if e > 3 {
+			c.Over3Px++
+		}
+	}
+	if len(errs) == 0 {
+		return FlowComparison{}, ErrNoCommonPixels
+	}
+	n := float64(len(errs))
+	c.Pixels = len(errs)
+	c.MeanEPE /= n
+	c.Over1Px *= 100 / n
+	c.Over3Px *= 100 / n
+	sort.Float64s(errs)
+	c.MedianEPE = findMedian(errs)
+	return c, nil
+}
@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// TestCompareFlowFields compares a field with copies of itself offset by a
+// known amount on some of their pixels.
+func TestCompareFlowFields(t *testing.T) {
+	const w, h = 10, 10
+	truth := NewFlowField(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			truth.Set(x, y, float64(x)/4, -float64(y)/2)
+		}
+	}
+
+	// Offset the first 20 pixels by (3, 4), 5 pixels of error, and the next
+	// 30 by (0.3, 0.4), half a pixel.
+	est := NewFlowField(w, h)
+	for i := range est.Valid {
+		dx, dy := truth.DX[i], truth.DY[i]
+		switch {
+		case i < 20:
+			dx, dy = dx+3, dy+4
+		case i < 50:
+			dx, dy = dx+0.3, dy+0.4
+		}
+		est.DX[i], est.DY[i] = dx, dy
+	}
+	c, err := CompareFlowFields(est, truth)
+	if err != nil {
+		t.Fatalf("CompareFlowFields failed: %v", err)
+	}
+	if want := (20*5 + 30*0.5) / 100.0; math.Abs(c.MeanEPE-want) > 1e-9 {
+		t.Errorf("Expected a mean EPE of %v, got %v", want, c.MeanEPE)
+	}
+	// Half the pixels are exact and 30 err by 0.5, so the middle two are 0
+	// and 0.5.
+	if math.Abs(c.MedianEPE-0.25) > 1e-9 {
+		t.Errorf("Expected a median EPE of 0.25, got %v", c.MedianEPE)
+	}
+	if c.Over1Px != 20 || c.Over3Px != 20 || c.Pixels != 100 {
+		t.Errorf("Expected 20%% over 1 and 3 pixels of 100, got %+v", c)
+	}
+
+	// Pixels without data, or with a non-finite displacement, in either
+	// field are left out.
+	est.SetNoData(0, 0)
+	truth.DX[1] = math.NaN()
+	truth.SetNoData(9, 9)
+	c, err = CompareFlowFields(est, truth)
+	if err != nil {
+		t.Fatalf("CompareFlowFields failed: %v", err)
+	}
+	if c.Pixels != 97 || math.Abs(c.Over3Px-18*100/97.0) > 1e-9 {
+		t.Errorf("Expected 18 of 97 pixels over 3 pixels, got %+v", c)
+	}
+	if c, err := CompareFlowFields(truth, truth); err != nil || c.MeanEPE != 0 || c.MedianEPE != 0 || c.Over1Px != 0 {
+		t.Errorf("Expected a field to match itself, got %+v, %v", c, err)
+	}
+}
+
+func TestCompareFlowFieldsErrors(t *testing.T) {
+	if _, err := CompareFlowFields(NewFlowField(4, 4), NewFlowField(4, 5)); err == nil {
+		t.Error("Expected an error for fields of different sizes")
+	}
+	a, b := NewFlowField(2, 1), NewFlowField(2, 1)
+	a.SetNoData(0, 0)
+	b.SetNoData(1, 0)
+	if _, err := CompareFlowFields(a, b); !errors.Is(err, ErrNoCommonPixels) {
+		t.Errorf("Expected ErrNoCommonPixels, got %v", err)
+	}
+}
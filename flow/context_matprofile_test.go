@@ -0,0 +1,55 @@
+//go:build matprofile
+
+package flow
+
+import (
+	"bytes"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// TestContextCancelReleasesMats checks with gocv.MatProfile, which needs
+// the matprofile build tag, that a cancelled computation leaves no matrix
+// open, whatever the method and wherever it stops.
+func TestContextCancelReleasesMats(t *testing.T) {
+	paths := []string{"../test_data/centered.png", "../test_data/shifted.png", "../test_data/centered.png"}
+	for _, base := range []FlowOptions{
+		{},
+		{Method: MethodFused, RecordPaths: true},
+		{Occlusion: OcclusionOptions{Detect: true}},
+	} {
+		for _, index := range []int{0, len(paths) - 1} {
+			before := gocv.MatProfile.Count()
+			ctx, opts, _ := cancelAt(base, index)
+			if _, err := GenerateAverageFlowMapWithContext(ctx, paths, 4, opts); err == nil {
+				t.Fatalf("Method %v, cancelled at frame %d: expected an error", base.Method, index)
+			}
+			if after := gocv.MatProfile.Count(); after != before {
+				var b bytes.Buffer
+				gocv.MatProfile.WriteTo(&b, 1)
+				t.Errorf("Method %v, cancelled at frame %d: %d matrices leaked:\n%s", base.Method, index, after-before, b.String())
+			}
+		}
+	}
+}
+
+// TestGenerateFlowSequenceContextReleasesMats is TestContextCancelReleasesMats
+// for GenerateFlowSequenceWithContext.
+func TestGenerateFlowSequenceContextReleasesMats(t *testing.T) {
+	paths := []string{"../test_data/centered.png", "../test_data/shifted.png", "../test_data/centered.png"}
+	for _, seq := range []SequenceOptions{{Cumulative: true}, {PerPair: true, Workers: 2}} {
+		for _, index := range []int{0, len(paths) - 1} {
+			before := gocv.MatProfile.Count()
+			ctx, opts, _ := cancelAt(FlowOptions{}, index)
+			if _, err := GenerateFlowSequenceWithContext(ctx, paths, 4, opts, seq); err == nil {
+				t.Fatalf("%+v, cancelled at frame %d: expected an error", seq, index)
+			}
+			if after := gocv.MatProfile.Count(); after != before {
+				var b bytes.Buffer
+				gocv.MatProfile.WriteTo(&b, 1)
+				t.Errorf("%+v, cancelled at frame %d: %d matrices leaked:\n%s", seq, index, after-before, b.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"example/goflow/flow/synth"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// cancelAt returns options whose OnProgress cancels the returned context
+// once frame index has been tracked, and the indices it was called with.
+func cancelAt(opts FlowOptions, index int) (context.Context, FlowOptions, *[]int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls []int
+	opts.OnProgress = func(frameIndex, totalFrames int) {
+		calls = append(calls, frameIndex)
+		if frameIndex == index {
+			cancel()
+		}
+	}
+	return ctx, opts, &calls
+}
+
+// TestProgress checks that OnProgress reports every frame, skipped ones
+// included, in order and with the sequence's length.
+func TestProgress(t *testing.T) {
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 2, Y: 1}}}, 4, 128, 128)
+	frames = append(frames[:2], append([]image.Image{image.NewGray(image.Rect(0, 0, 64, 64))}, frames[2:]...)...)
+	var calls [][2]int
+	_, err := GenerateAverageFlowMapFromImagesWithOptions(frames, 2, FlowOptions{
+		SkipBadFrames: true,
+		OnProgress: func(frameIndex, totalFrames int) {
+			calls = append(calls, [2]int{frameIndex, totalFrames})
+		},
+	})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if want := [][2]int{{0, 5}, {1, 5}, {2, 5}, {3, 5}, {4, 5}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected progress %v, got %v", want, calls)
+	}
+}
+
+// TestContextCancel cancels the computation after the first frame, and
+// after the last one, where only the interpolation is left to stop.
+func TestContextCancel(t *testing.T) {
+	paths := []string{"../test_data/centered.png", "../test_data/shifted.png", "../test_data/centered.png"}
+	for _, index := range []int{0, len(paths) - 1} {
+		ctx, opts, calls := cancelAt(FlowOptions{}, index)
+		_, err := GenerateAverageFlowMapWithContext(ctx, paths, 4, opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Cancelled at frame %d: expected context.Canceled, got %v", index, err)
+		}
+		if len(*calls) != index+1 {
+			t.Errorf("Cancelled at frame %d: expected no frame to be tracked after it, got progress %v", index, *calls)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GenerateAverageFlowMapFromImagesWithContext(ctx, []image.Image{readPNG(t, paths[0]), readPNG(t, paths[1])}, 4, FlowOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled for a context cancelled up front, got %v", err)
+	}
+}
+
+// TestGenerateFlowSequenceContext cancels a sequence after its first frame
+// and after its last pair, chained and per pair.
+func TestGenerateFlowSequenceContext(t *testing.T) {
+	paths := []string{"../test_data/centered.png", "../test_data/shifted.png", "../test_data/centered.png"}
+	for _, seq := range []SequenceOptions{{Cumulative: true}, {PerPair: true}} {
+		ctx, opts, calls := cancelAt(FlowOptions{}, -1)
+		if _, err := GenerateFlowSequenceWithContext(ctx, paths, 4, opts, seq); err != nil {
+			t.Fatalf("GenerateFlowSequenceWithContext failed: %v", err)
+		}
+		if want := []int{0, 1, 2}; !reflect.DeepEqual(*calls, want) {
+			t.Errorf("%+v: expected progress %v, got %v", seq, want, *calls)
+		}
+
+		for _, index := range []int{0, len(paths) - 1} {
+			ctx, opts, calls := cancelAt(FlowOptions{}, index)
+			_, err := GenerateFlowSequenceWithContext(ctx, paths, 4, opts, seq)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%+v, cancelled at frame %d: expected context.Canceled, got %v", seq, index, err)
+			}
+			if len(*calls) != index+1 {
+				t.Errorf("%+v, cancelled at frame %d: expected no pair to be computed after it, got progress %v", seq, index, *calls)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package flow
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// InterpolationMode selects how InterpolationOptions spreads the sparse
+// feature displacements over a flow field.
+type InterpolationMode int
+
+const (
+	// InterpolationIDW weighs the features near each pixel by their inverse
+	// distance. It is smooth where the features are dense, but each feature
+	// leaves a ring of its own displacement around it where they are not.
+	InterpolationIDW InterpolationMode = iota
+	// InterpolationDelaunay triangulates the features and interpolates
+	// each pixel linearly from the corners of the triangle holding it, so
+	// the field is continuous and reproduces an affine motion exactly. The
+	// pixels outside the triangulation, beyond the convex hull of the
+	// features, are interpolated as under InterpolationIDW.
+	InterpolationDelaunay
+)
+
+func (m InterpolationMode) String() string {
+	switch m {
+	case InterpolationIDW:
+		return "idw"
+	case InterpolationDelaunay:
+		return "delaunay"
+	}
+	return "unknown"
+}
+
+// ParseInterpolationMode returns the InterpolationMode named s: "idw" or
+// "delaunay".
+func ParseInterpolationMode(s string) (InterpolationMode, error) {
+	for _, m := range []InterpolationMode{InterpolationIDW, InterpolationDelaunay} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown interpolation mode %q (want idw or delaunay)", s)
+}
+
+// triangleMap assigns the pixels of a field to the Delaunay triangles of
+// its sparse points.
+type triangleMap struct {
+	width int
+	// tris holds the corners of each triangle, as indices of the points,
+	// ordered so that their orient is positive.
+	tris [][3]int
+	// pixel holds, row by row, the index in tris of the triangle holding
+	// each pixel, or -1 outside the triangulation.
+	pixel []int32
+}
+
+// newTriangleMap triangulates points, which must be distinct, and maps the
+// width x height pixels onto the triangles. A pixel on an edge belongs to
+// the first triangle holding it.
+func newTriangleMap(points []image.Point, width, height int) *triangleMap {
+	m := &triangleMap{width: width, tris: delaunay(points), pixel: make([]int32, width*height)}
+	for i := range m.pixel {
+		m.pixel[i] = -1
+	}
+	for t, tri := range m.tris {
+		a, b, c := points[tri[0]], points[tri[1]], points[tri[2]]
+		bounds := image.Rect(min(a.X, b.X, c.X), min(a.Y, b.Y, c.Y), max(a.X, b.X, c.X)+1, max(a.Y, b.Y, c.Y)+1).Intersect(image.Rect(0, 0, width, height))
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				p := image.Pt(x, y)
+				if m.pixel[y*width+x] < 0 && orient(a, b, p) >= 0 && orient(b, c, p) >= 0 && orient(c, a, p) >= 0 {
+					m.pixel[y*width+x] = int32(t)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// interpolate returns the displacement at (x, y) interpolated linearly from
+// the corners of its triangle, and false if the pixel is outside the
+// triangulation or m is nil.
+func (m *triangleMap) interpolate(x, y int, points []image.Point, disps func(i int) (float64, float64)) (float64, float64, bool) {
+	if m == nil {
+		return 0, 0, false
+	}
+	t := m.pixel[y*m.width+x]
+	if t < 0 {
+		return 0, 0, false
+	}
+	tri := m.tris[t]
+	a, b, c, p := points[tri[0]], points[tri[1]], points[tri[2]], image.Pt(x, y)
+	area := float64(orient(a, b, c))
+	// The barycentric weight of each corner is the area of the triangle
+	// the pixel forms with the opposite edge.
+	weights := [3]float64{float64(orient(b, c, p)) / area, float64(orient(c, a, p)) / area, float64(orient(a, b, p)) / area}
+	var dx, dy float64
+	for k, i := range tri {
+		ix, iy := disps(i)
+		dx += weights[k] * ix
+		dy += weights[k] * iy
+	}
+	return dx, dy, true
+}
+
+// delaunay returns the Delaunay triangles of points, which must be
+// distinct, by Bowyer-Watson insertion, each with its corners ordered so
+// that their orient is positive. Fewer than three points, or points all on
+// one line, have no triangles. Of four or more points on one circle, the
+// diagonals depend on the order of the points.
+func delaunay(points []image.Point) [][3]int {
+	if len(points) < 3 {
+		return nil
+	}
+	// The super triangle encloses every point well away from it; its
+	// corners are the indices n, n+1 and n+2.
+	bounds := image.Rectangle{Min: points[0], Max: points[0]}
+	for _, p := range points {
+		bounds = bounds.Union(image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))})
+	}
+	span := max(bounds.Dx(), bounds.Dy(), 1) * 64
+	center := bounds.Min.Add(bounds.Max).Div(2)
+	n := len(points)
+	all := append(points[:n:n],
+		image.Pt(center.X-2*span, center.Y+span),
+		image.Pt(center.X+2*span, center.Y+span),
+		image.Pt(center.X, center.Y-2*span))
+
+	tris := [][3]int{ccw(all, [3]int{n, n + 1, n + 2})}
+	for i := 0; i < n; i++ {
+		p := all[i]
+		// The triangles whose circumcircle holds p make a cavity, which is
+		// refilled with the triangles joining p to its boundary edges.
+		edges := map[[2]int]int{}
+		kept := tris[:0:0]
+		for _, tri := range tris {
+			if !inCircumcircle(all[tri[0]], all[tri[1]], all[tri[2]], p) {
+				kept = append(kept, tri)
+				continue
+			}
+			for k := 0; k < 3; k++ {
+				edges[[2]int{tri[k], tri[(k+1)%3]}]++
+			}
+		}
+		var boundary [][2]int
+		for e := range edges {
+			// An edge inside the cavity is walked once each way.
+			if edges[[2]int{e[1], e[0]}] == 0 {
+				boundary = append(boundary, e)
+			}
+		}
+		sortEdges(boundary)
+		for _, e := range boundary {
+			if orient(all[e[0]], all[e[1]], p) > 0 {
+				kept = append(kept, [3]int{e[0], e[1], i})
+			}
+		}
+		tris = kept
+	}
+
+	var out [][3]int
+	for _, tri := range tris {
+		if tri[0] < n && tri[1] < n && tri[2] < n && orient(all[tri[0]], all[tri[1]], all[tri[2]]) > 0 {
+			out = append(out, tri)
+		}
+	}
+	return out
+}
+
+// sortEdges orders edges by their vertices, so the triangles, and the edge
+// pixels they claim, do not depend on map iteration order.
+func sortEdges(edges [][2]int) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+}
+
+// ccw returns tri with its corners ordered so that their orient is
+// positive.
+func ccw(points []image.Point, tri [3]int) [3]int {
+	if orient(points[tri[0]], points[tri[1]], points[tri[2]]) < 0 {
+		tri[1], tri[2] = tri[2], tri[1]
+	}
+	return tri
+}
+
+// orient returns twice the signed area of the triangle a, b, c: positive
+// for one winding of its corners, negative for the other, and zero if they
+// are on one line.
+func orient(a, b, c image.Point) int64 {
+	return int64(b.X-a.X)*int64(c.Y-a.Y) - int64(b.Y-a.Y)*int64(c.X-a.X)
+}
+
+// inCircumcircle reports whether d lies strictly inside the circumcircle of
+// the triangle a, b, c, whose orient is positive. The determinant is taken
+// in floating point and, when it is too close to zero to trust, exactly.
+func inCircumcircle(a, b, c, d image.Point) bool {
+	adx, ady := float64(a.X-d.X), float64(a.Y-d.Y)
+	bdx, bdy := float64(b.X-d.X), float64(b.Y-d.Y)
+	cdx, cdy := float64(c.X-d.X), float64(c.Y-d.Y)
+	al, bl, cl := adx*adx+ady*ady, bdx*bdx+bdy*bdy, cdx*cdx+cdy*cdy
+	det := al*(bdx*cdy-cdx*bdy) + bl*(cdx*ady-adx*cdy) + cl*(adx*bdy-bdx*ady)
+	permanent := al*(math.Abs(bdx*cdy)+math.Abs(cdx*bdy)) + bl*(math.Abs(cdx*ady)+math.Abs(adx*cdy)) + cl*(math.Abs(adx*bdy)+math.Abs(bdx*ady))
+	if math.Abs(det) > 1e-12*permanent {
+		return det > 0
+	}
+	return exactInCircumcircle(a, b, c, d) > 0
+}
+
+// exactInCircumcircle returns the sign of the in-circle determinant of
+// inCircumcircle in exact integer arithmetic.
+func exactInCircumcircle(a, b, c, d image.Point) int {
+	diff := func(p image.Point) (*big.Int, *big.Int, *big.Int) {
+		x, y := big.NewInt(int64(p.X-d.X)), big.NewInt(int64(p.Y-d.Y))
+		l := new(big.Int).Add(new(big.Int).Mul(x, x), new(big.Int).Mul(y, y))
+		return x, y, l
+	}
+	cross := func(px, py, qx, qy *big.Int) *big.Int {
+		return new(big.Int).Sub(new(big.Int).Mul(px, qy), new(big.Int).Mul(qx, py))
+	}
+	ax, ay, al := diff(a)
+	bx, by, bl := diff(b)
+	cx, cy, cl := diff(c)
+	det := new(big.Int).Mul(al, cross(bx, by, cx, cy))
+	det.Add(det, new(big.Int).Mul(bl, cross(cx, cy, ax, ay)))
+	det.Add(det, new(big.Int).Mul(cl, cross(ax, ay, bx, by)))
+	return det.Sign()
+}
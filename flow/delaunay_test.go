@@ -0,0 +1,183 @@
+package flow
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// affinePointMats returns features at the corners of a width x height
+// field, on a coarse grid, whose points are cocircular in fours, and at
+// random, all displaced by the affine motion disp.
+func affinePointMats(width, height int, disp func(x, y float64) (float64, float64)) (gocv.Mat, gocv.Mat) {
+	seen := map[image.Point]bool{}
+	var pts []image.Point
+	add := func(pt image.Point) {
+		if !seen[pt] {
+			seen[pt] = true
+			pts = append(pts, pt)
+		}
+	}
+	for _, pt := range []image.Point{{0, 0}, {width - 1, 0}, {0, height - 1}, {width - 1, height - 1}} {
+		add(pt)
+	}
+	for y := 16; y < height; y += 32 {
+		for x := 16; x < width; x += 32 {
+			add(image.Pt(x, y))
+		}
+	}
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 40; i++ {
+		add(image.Pt(rng.Intn(width), rng.Intn(height)))
+	}
+	initial := gocv.NewMatWithSize(len(pts), 2, gocv.MatTypeCV32F)
+	current := gocv.NewMatWithSize(len(pts), 2, gocv.MatTypeCV32F)
+	for i, pt := range pts {
+		dx, dy := disp(float64(pt.X), float64(pt.Y))
+		initial.SetFloatAt(i, 0, float32(pt.X))
+		initial.SetFloatAt(i, 1, float32(pt.Y))
+		current.SetFloatAt(i, 0, float32(float64(pt.X)+dx))
+		current.SetFloatAt(i, 1, float32(float64(pt.Y)+dy))
+	}
+	return initial, current
+}
+
+// TestInterpolateFlowFieldDelaunayAffine checks that Delaunay interpolation
+// reproduces an affine displacement field at every pixel, which inverse
+// distance weighting does not.
+func TestInterpolateFlowFieldDelaunayAffine(t *testing.T) {
+	const width, height = 160, 96
+	// The coefficients are powers of two, so the displacements survive the
+	// float32 point matrices exactly.
+	disp := func(x, y float64) (float64, float64) {
+		return 0.25*x - 0.125*y + 1.5, 0.0625*x + 0.5*y - 2
+	}
+	initial, current := affinePointMats(width, height, disp)
+	defer initial.Close()
+	defer current.Close()
+
+	_, idwConfidence, err := InterpolateFlowFieldWithOptions(initial, current, width, height, 1, nil, InterpolationOptions{})
+	if err != nil {
+		t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+	}
+	maxError := func(opts InterpolationOptions) float64 {
+		field, confidence, err := InterpolateFlowFieldWithOptions(initial, current, width, height, 1, nil, opts)
+		if err != nil {
+			t.Fatalf("InterpolateFlowFieldWithOptions(%v) failed: %v", opts.Mode, err)
+		}
+		var worst float64
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				dx, dy, valid := field.At(x, y)
+				wantX, wantY := disp(float64(x), float64(y))
+				if !valid {
+					t.Fatalf("%v: expected data at (%d, %d)", opts.Mode, x, y)
+				}
+				if opts.Mode == InterpolationDelaunay && confidence[y][x] != idwConfidence[y][x] {
+					t.Fatalf("Expected the inverse distance confidence %v at (%d, %d), got %v", idwConfidence[y][x], x, y, confidence[y][x])
+				}
+				worst = math.Max(worst, math.Hypot(dx-wantX, dy-wantY))
+			}
+		}
+		return worst
+	}
+	if e := maxError(InterpolationOptions{Mode: InterpolationDelaunay}); e > 1e-9 {
+		t.Errorf("Expected Delaunay interpolation to reproduce the affine field, got an error of up to %g px", e)
+	}
+	if e := maxError(InterpolationOptions{}); e < 0.5 {
+		t.Errorf("Expected inverse distance weighting to miss the affine field, got an error of up to %g px", e)
+	}
+}
+
+// TestFuseFieldsDelaunay checks that fusion falls back to the dense flow in
+// the middle of a large Delaunay triangle, far from its corners, and keeps
+// the triangulated flow near them.
+func TestFuseFieldsDelaunay(t *testing.T) {
+	const width, height = 200, 200
+	corners := []image.Point{{0, 0}, {width - 1, 0}, {0, height - 1}, {width - 1, height - 1}}
+	initial := gocv.NewMatWithSize(len(corners), 2, gocv.MatTypeCV32F)
+	current := gocv.NewMatWithSize(len(corners), 2, gocv.MatTypeCV32F)
+	defer initial.Close()
+	defer current.Close()
+	for i, pt := range corners {
+		initial.SetFloatAt(i, 0, float32(pt.X))
+		initial.SetFloatAt(i, 1, float32(pt.Y))
+		current.SetFloatAt(i, 0, float32(pt.X+2))
+		current.SetFloatAt(i, 1, float32(pt.Y))
+	}
+	sparse, confidence, err := InterpolateFlowFieldWithOptions(initial, current, width, height, 1, nil, InterpolationOptions{Mode: InterpolationDelaunay})
+	if err != nil {
+		t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+	}
+	if c := confidence[height/2][width/2]; c != 0 {
+		t.Errorf("Expected no confidence beyond the radius of every corner, got %v", c)
+	}
+	dense := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dense.Set(x, y, 0, 1)
+		}
+	}
+	fused, err := FuseFields(sparse, confidence, dense, FuseOptions{})
+	if err != nil {
+		t.Fatalf("FuseFields failed: %v", err)
+	}
+	if dx, dy, _ := fused.At(width/2, height/2); math.Abs(dx) > 1e-9 || math.Abs(dy-1) > 1e-9 {
+		t.Errorf("Expected the dense flow (0, 1) in the middle, got (%v, %v)", dx, dy)
+	}
+	if dx, dy, _ := fused.At(1, 1); dx < 1 || dy > 0.5 {
+		t.Errorf("Expected mostly the triangulated flow (2, 0) near a corner, got (%v, %v)", dx, dy)
+	}
+}
+
+// TestDelaunayEmptyCircumcircles checks that the triangles of a set of
+// points with collinear and cocircular runs tile their convex hull and
+// hold no point inside their circumcircle.
+func TestDelaunayEmptyCircumcircles(t *testing.T) {
+	const width, height = 97, 61
+	initial, current := affinePointMats(width, height, func(x, y float64) (float64, float64) { return 0, 0 })
+	current.Close()
+	defer initial.Close()
+	var points []image.Point
+	for i := 0; i < initial.Rows(); i++ {
+		points = append(points, image.Pt(int(initial.GetFloatAt(i, 0)), int(initial.GetFloatAt(i, 1))))
+	}
+	// Points on the edges of the hull, between the corners.
+	points = append(points, image.Pt(40, 0), image.Pt(0, 30), image.Pt(width-1, 50))
+
+	tris := delaunay(points)
+	var area int64
+	for _, tri := range tris {
+		a, b, c := points[tri[0]], points[tri[1]], points[tri[2]]
+		if orient(a, b, c) <= 0 {
+			t.Fatalf("Expected triangle %v to be positively oriented", tri)
+		}
+		area += orient(a, b, c)
+		for i, p := range points {
+			if i != tri[0] && i != tri[1] && i != tri[2] && exactInCircumcircle(a, b, c, p) > 0 {
+				t.Fatalf("Point %v lies inside the circumcircle of %v %v %v", p, a, b, c)
+			}
+		}
+	}
+	if want := int64(2 * (width - 1) * (height - 1)); area != want {
+		t.Errorf("Expected the triangles to cover the hull's doubled area %d, got %d", want, area)
+	}
+
+	if tris := delaunay([]image.Point{{0, 0}, {5, 5}, {10, 10}}); len(tris) != 0 {
+		t.Errorf("Expected no triangles of collinear points, got %v", tris)
+	}
+}
+
+func TestParseInterpolationMode(t *testing.T) {
+	for _, m := range []InterpolationMode{InterpolationIDW, InterpolationDelaunay} {
+		if got, err := ParseInterpolationMode(m.String()); err != nil || got != m {
+			t.Errorf("ParseInterpolationMode(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+	if _, err := ParseInterpolationMode("kriging"); err == nil {
+		t.Error("Expected an error for an unknown interpolation mode")
+	}
+}
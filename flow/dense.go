@@ -0,0 +1,186 @@
+package flow
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// Method selects how the flow map is computed.
+type Method int
+
+const (
+	// MethodSparse interpolates the flow of LK-tracked features.
+	MethodSparse Method = iota
+	// MethodDense follows every pixel through Farneback flow.
+	MethodDense
+	// MethodFused blends the sparse and dense flow with FuseFields.
+	MethodFused
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodSparse:
+		return "sparse"
+	case MethodDense:
+		return "dense"
+	case MethodFused:
+		return "fused"
+	}
+	return "unknown"
+}
+
+// ParseMethod returns the Method named s: "sparse", "dense" or "fused".
+func ParseMethod(s string) (Method, error) {
+	for _, m := range []Method{MethodSparse, MethodDense, MethodFused} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown flow method %q (want sparse, dense or fused)", s)
+}
+
+// Defaults of FarnebackOptions, the parameters the nowcast package uses.
+const (
+	DefaultFarnebackPyrScale   = 0.5
+	DefaultFarnebackLevels     = 3
+	DefaultFarnebackWinSize    = 15
+	DefaultFarnebackIterations = 3
+	DefaultFarnebackPolyN      = 5
+	DefaultFarnebackPolySigma  = 1.2
+)
+
+// FarnebackOptions configures the Farneback flow of MethodDense and
+// MethodFused. Every zero or negative field takes its default.
+type FarnebackOptions struct {
+	// PyrScale is the scale between pyramid levels, below 1. Zero means
+	// DefaultFarnebackPyrScale.
+	PyrScale float64
+	// Levels is the number of pyramid levels, including the full
+	// resolution frame. More levels follow larger motion. Zero means
+	// DefaultFarnebackLevels.
+	Levels int
+	// WinSize is the side in pixels of the averaging window. Larger windows
+	// are more robust to noise and follow faster motion, but blur the
+	// flow. Zero means DefaultFarnebackWinSize.
+	WinSize int
+	// Iterations is the number of iterations on each pyramid level. Zero
+	// means DefaultFarnebackIterations.
+	Iterations int
+	// PolyN and PolySigma are the size of the pixel neighbourhood fitted
+	// with a polynomial and the standard deviation of the Gaussian that
+	// weights it. Zero means DefaultFarnebackPolyN and
+	// DefaultFarnebackPolySigma.
+	PolyN     int
+	PolySigma float64
+}
+
+func (o FarnebackOptions) params() (pyrScale float64, levels, winSize, iterations, polyN int, polySigma float64) {
+	pyrScale, levels, winSize, iterations, polyN, polySigma = DefaultFarnebackPyrScale, DefaultFarnebackLevels, DefaultFarnebackWinSize, DefaultFarnebackIterations, DefaultFarnebackPolyN, DefaultFarnebackPolySigma
+	if o.PyrScale > 0 {
+		pyrScale = o.PyrScale
+	}
+	if o.Levels > 0 {
+		levels = o.Levels
+	}
+	if o.WinSize > 0 {
+		winSize = o.WinSize
+	}
+	if o.Iterations > 0 {
+		iterations = o.Iterations
+	}
+	if o.PolyN > 0 {
+		polyN = o.PolyN
+	}
+	if o.PolySigma > 0 {
+		polySigma = o.PolySigma
+	}
+	return
+}
+
+// GenerateDenseFlowMapFarneback is GenerateAverageFlowMap under MethodDense:
+// it follows every pixel through the Farneback flow between consecutive
+// frames, configured by opts, and encodes the total displacement in the
+// same flow map format, so ForwardTransform takes it unchanged. Unlike the
+// sparse flow it does not depend on finding features.
+func GenerateDenseFlowMapFarneback(imagePaths []string, resolutionFactor int, opts FarnebackOptions) (image.Image, error) {
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, resolutionFactor, FlowOptions{Method: MethodDense, Farneback: opts})
+	if err != nil {
+		return nil, err
+	}
+	return result.Image, nil
+}
+
+// denseTracks follows every pixel of the first frame through the Farneback
+// flow between consecutive frames, so its total displacement is measured
+// the same way as that of an LK-tracked feature.
+type denseTracks struct {
+	width, height int
+	// x and y hold the current position of the pixel that started at
+	// (i%width, i/width). They are NaN once the pixel has left the frame.
+	x, y []float32
+}
+
+func newDenseTracks(width, height int) *denseTracks {
+	d := &denseTracks{
+		width:  width,
+		height: height,
+		x:      make([]float32, width*height),
+		y:      make([]float32, width*height),
+	}
+	for i := range d.x {
+		d.x[i] = float32(i % width)
+		d.y[i] = float32(i / width)
+	}
+	return d
+}
+
+// advance moves every pixel by the Farneback flow from prev to next, computed
+// with opts and sampled at its nearest pixel.
+func (d *denseTracks) advance(prev, next gocv.Mat, opts FarnebackOptions) error {
+	flow := gocv.NewMat()
+	defer flow.Close()
+	pyrScale, levels, winSize, iterations, polyN, polySigma := opts.params()
+	gocv.CalcOpticalFlowFarneback(prev, next, &flow, pyrScale, levels, winSize, iterations, polyN, polySigma, 0)
+	data, err := flow.DataPtrFloat32()
+	if err != nil {
+		return fmt.Errorf("failed to read dense flow: %w", err)
+	}
+	for i := range d.x {
+		if math.IsNaN(float64(d.x[i])) {
+			continue
+		}
+		px := int(math.Round(float64(d.x[i])))
+		py := int(math.Round(float64(d.y[i])))
+		if px < 0 || py < 0 || px >= d.width || py >= d.height {
+			d.x[i], d.y[i] = float32(math.NaN()), float32(math.NaN())
+			continue
+		}
+		j := 2 * (py*d.width + px)
+		d.x[i] += data[j]
+		d.y[i] += data[j+1]
+	}
+	return nil
+}
+
+// field returns the total displacement of the pixels on a width x height
+// grid, scaled down by resolutionFactor like InterpolateFlowField. Pixels
+// that left the frame, or fall on a no-data pixel of mask, have no data.
+func (d *denseTracks) field(width, height, resolutionFactor int, mask *image.Alpha) *FlowField {
+	field := NewFlowField(width, height)
+	rf := float64(resolutionFactor)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := x*resolutionFactor, y*resolutionFactor
+			i := sy*d.width + sx
+			if masked(mask, x, y, width, height) || math.IsNaN(float64(d.x[i])) {
+				field.SetNoData(x, y)
+				continue
+			}
+			field.Set(x, y, (float64(d.x[i])-float64(sx))/rf, (float64(d.y[i])-float64(sy))/rf)
+		}
+	}
+	return field
+}
@@ -0,0 +1,110 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"image"
+	"math"
+	"sort"
+	"testing"
+)
+
+// frameSize is the side of the frames the tests generate, as of the images
+// in test_data.
+const frameSize = 1024
+
+// translatingFrames returns n full-size frames of a synth texture moving by
+// (dx, dy) pixels per frame.
+func translatingFrames(n int, dx, dy float64) []image.Image {
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: dx, Y: dy}}}, n, frameSize, frameSize)
+	return frames
+}
+
+// TestDenseMethodFollowsShift checks that MethodDense measures the total
+// displacement over a sequence, scaled down like the sparse flow.
+func TestDenseMethodFollowsShift(t *testing.T) {
+	const resolutionFactor = 4
+	acc := NewAccumulator(FlowOptions{Method: MethodDense})
+	defer acc.Close()
+	for _, frame := range translatingFrames(3, 3, 2) {
+		if err := acc.AddImage(frame, "frame"); err != nil {
+			t.Fatalf("AddImage failed: %v", err)
+		}
+	}
+	field, err := acc.FlowField(resolutionFactor)
+	if err != nil {
+		t.Fatalf("FlowField failed: %v", err)
+	}
+
+	// Stay clear of the borders, where pixels enter and leave the frame.
+	var sumX, sumY float64
+	var n int
+	for y := 20; y < field.Height-20; y++ {
+		for x := 20; x < field.Width-20; x++ {
+			dx, dy, valid := field.At(x, y)
+			if !valid {
+				t.Fatalf("Pixel (%d, %d) has no data", x, y)
+			}
+			sumX += dx
+			sumY += dy
+			n++
+		}
+	}
+	wantX, wantY := 6.0/resolutionFactor, 4.0/resolutionFactor
+	gotX, gotY := sumX/float64(n), sumY/float64(n)
+	if math.Abs(gotX-wantX) > 0.25 || math.Abs(gotY-wantY) > 0.25 {
+		t.Errorf("Expected mean flow close to (%.2f, %.2f), got (%.2f, %.2f)", wantX, wantY, gotX, gotY)
+	}
+	if paths := acc.Paths(); paths != nil {
+		t.Errorf("Expected no feature paths under MethodDense, got %d", len(paths))
+	}
+}
+
+// TestGenerateDenseFlowMapFarneback checks that the dense flow map of the
+// test frames shows their (20, 10) shift, scaled down by the resolution
+// factor, along the edges of the square. Its flat inside has no texture for
+// Farneback to follow, and the default parameters, meant for the few pixels
+// radar echoes move per frame, do not reach a 20 pixel jump.
+func TestGenerateDenseFlowMapFarneback(t *testing.T) {
+	const resolutionFactor = 4
+	imagePaths := []string{"../test_data/centered.png", "../test_data/shifted.png"}
+	flowMap, err := GenerateDenseFlowMapFarneback(imagePaths, resolutionFactor, FarnebackOptions{Levels: 5, WinSize: 21, Iterations: 5})
+	if err != nil {
+		t.Fatalf("GenerateDenseFlowMapFarneback failed: %v", err)
+	}
+	if b := flowMap.Bounds(); b.Dx() != frameSize/resolutionFactor || b.Dy() != frameSize/resolutionFactor {
+		t.Fatalf("Expected a %dx%d flow map, got %v", frameSize/resolutionFactor, frameSize/resolutionFactor, b)
+	}
+
+	// The square spans [412, 612) in the first frame, [103, 153) scaled.
+	inner, outer := image.Rect(106, 106, 150, 150), image.Rect(100, 100, 156, 156)
+	field := DecodeFlowMap(flowMap, Encoding{})
+	var dxs, dys []float64
+	for y := outer.Min.Y; y < outer.Max.Y; y++ {
+		for x := outer.Min.X; x < outer.Max.X; x++ {
+			if image.Pt(x, y).In(inner) {
+				continue
+			}
+			dx, dy, _ := field.At(x, y)
+			dxs = append(dxs, dx)
+			dys = append(dys, dy)
+		}
+	}
+	sort.Float64s(dxs)
+	sort.Float64s(dys)
+	wantX, wantY := 20.0/resolutionFactor, 10.0/resolutionFactor
+	if dx, dy := dxs[len(dxs)/2], dys[len(dys)/2]; math.Abs(dx-wantX) > 0.5 || math.Abs(dy-wantY) > 0.5 {
+		t.Errorf("Expected a median flow close to (%.1f, %.1f) along the square's edges, got (%.2f, %.2f)", wantX, wantY, dx, dy)
+	}
+}
+
+func TestParseMethod(t *testing.T) {
+	for _, m := range []Method{MethodSparse, MethodDense, MethodFused} {
+		got, err := ParseMethod(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseMethod(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+	if _, err := ParseMethod("farneback"); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
@@ -1,22 +1,149 @@
 package flow
 
 import (
+	"context"
 	"image"
-	"image/color"
 	"math"
+	"runtime"
+	"sort"
 
 	"gocv.io/x/gocv"
 )
 
 // GenerateDenseFlowMap creates a dense flow visualization from sparse feature points.
 func GenerateDenseFlowMap(initialPoints, currentPoints gocv.Mat, width, height, resolutionFactor int) (image.Image, error) {
-	// Create a Go image for the dense flow visualization
-	resultImg := image.NewRGBA(image.Rect(0, 0, width, height))
+	field, err := InterpolateFlowField(initialPoints, currentPoints, width, height, resolutionFactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return field.Image(), nil
+}
+
+// InterpolateFlowField builds a dense width x height flow field from sparse
+// feature points using inverse distance weighting. If mask is not nil, field
+// pixels that fall on its zero-alpha pixels are marked as no data and
+// features starting there are ignored, so no flow bleeds into or out of the
+// masked regions. The mask may be given at any resolution. Features with a
+// NaN or infinite coordinate are ignored and counted in the field's
+// NonFinite.
+func InterpolateFlowField(initialPoints, currentPoints gocv.Mat, width, height, resolutionFactor int, mask *image.Alpha) (*FlowField, error) {
+	field, _, err := InterpolateFlowFieldWithConfidence(initialPoints, currentPoints, width, height, resolutionFactor, mask)
+	return field, err
+}
+
+// InterpolateFlowFieldWithConfidence is like InterpolateFlowField but also
+// returns, indexed [y][x], how well each pixel is supported by the sparse
+// features: the total inverse distance weight, capped at 1, of the features
+// within DefaultIDWRadius. Pixels holding a feature have confidence 1, and
+// pixels without data or without a feature nearby have confidence 0.
+func InterpolateFlowFieldWithConfidence(initialPoints, currentPoints gocv.Mat, width, height, resolutionFactor int, mask *image.Alpha) (*FlowField, [][]float64, error) {
+	return InterpolateFlowFieldWithOptions(initialPoints, currentPoints, width, height, resolutionFactor, mask, InterpolationOptions{})
+}
+
+const (
+	// DefaultAspectRatio is the default InterpolationOptions.AspectRatio.
+	DefaultAspectRatio = 4.0
+	// DefaultIDWRadius is the default InterpolationOptions.Radius.
+	DefaultIDWRadius = 50.0
+	// DefaultIDWPower is the default InterpolationOptions.Power.
+	DefaultIDWPower = 2.0
+)
+
+// InterpolationOptions configures how sparse feature displacements are
+// spread over a flow field.
+type InterpolationOptions struct {
+	// Anisotropic elongates the influence of each feature along its own
+	// displacement and shortens it across, so that the boundary between
+	// regions moving side by side in different directions, such as a squall
+	// line and the calm air behind it, is not smeared. Features that did
+	// not move keep an isotropic influence.
+	Anisotropic bool
+	// AspectRatio is, under Anisotropic, the ratio of the length of a
+	// feature's influence along its displacement to its width across it.
+	// Zero means DefaultAspectRatio.
+	AspectRatio float64
+	// Workers is the number of goroutines interpolating rows of the field
+	// at once. The field is the same for any number. Zero means
+	// runtime.NumCPU(); 1 interpolates serially.
+	Workers int
+	// Radius is the distance in field pixels, after the anisotropic
+	// rescaling, beyond which a feature does not contribute to a pixel. A
+	// small radius leaves pixels far from every feature without flow where
+	// the features are sparse; a large one smooths the flow where they are
+	// dense. Zero means DefaultIDWRadius; a negative radius is unlimited.
+	Radius float64
+	// Power is the exponent of the inverse distance weights. Higher powers
+	// let the nearest features dominate. Zero or negative means
+	// DefaultIDWPower, inverse distance squared.
+	Power float64
+	// NearestFallback gives the pixels that no feature within Radius
+	// reaches the displacement of the nearest feature, by plain distance,
+	// instead of zero flow. Their confidence stays zero.
+	NearestFallback bool
+	// Mode selects inverse distance weighting, the default, or Delaunay
+	// interpolation. Under InterpolationDelaunay the options above only
+	// apply outside the triangulation, and the confidence is the inverse
+	// distance weight in either mode. Triangles may span a masked region:
+	// its pixels stay without data, but those beyond it are interpolated
+	// from features on the far side.
+	Mode InterpolationMode
+}
+
+func (o InterpolationOptions) radius() float64 {
+	switch {
+	case o.Radius > 0:
+		return o.Radius
+	case o.Radius < 0:
+		return math.Inf(1)
+	}
+	return DefaultIDWRadius
+}
+
+func (o InterpolationOptions) power() float64 {
+	if o.Power > 0 {
+		return o.Power
+	}
+	return DefaultIDWPower
+}
+
+func (o InterpolationOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.NumCPU()
+}
+
+func (o InterpolationOptions) aspectRatio() float64 {
+	if o.AspectRatio > 0 {
+		return o.AspectRatio
+	}
+	return DefaultAspectRatio
+}
+
+// InterpolateFlowFieldWithOptions is like InterpolateFlowFieldWithConfidence
+// but spreads the features as configured by opts. Under opts.Anisotropic
+// the distance from each feature is measured in a frame rotated to its
+// displacement, with the component along it divided by the square root of
+// the aspect ratio and the component across it multiplied by it, before
+// it is weighted.
+func InterpolateFlowFieldWithOptions(initialPoints, currentPoints gocv.Mat, width, height, resolutionFactor int, mask *image.Alpha, opts InterpolationOptions) (*FlowField, [][]float64, error) {
+	return interpolateFlowField(context.Background(), initialPoints, currentPoints, width, height, resolutionFactor, mask, opts, nil)
+}
+
+// interpolateFlowField is InterpolateFlowFieldWithOptions, returning the
+// field and confidence map of ws if ws is not nil. It stops between bands
+// of rows once ctx is done, and returns ctx.Err().
+func interpolateFlowField(ctx context.Context, initialPoints, currentPoints gocv.Mat, width, height, resolutionFactor int, mask *image.Alpha, opts InterpolationOptions, ws *Workspace) (*FlowField, [][]float64, error) {
+	field := ws.flowField(width, height)
+	confidence := ws.confidenceMap(width, height)
 	resFactor := float32(resolutionFactor)
 
 	// Calculate displacement vectors from initialPoints to currentPoints
 	// Store them in a map for sparse to dense conversion
-	displacementMap := make(map[image.Point]image.Point)
+	displacementMap := make(map[image.Point]gocv.Point2f)
+	// directionMap holds, under opts.Anisotropic, the unit displacement of
+	// each moving feature.
+	directionMap := make(map[image.Point]gocv.Point2f)
 	for i := 0; i < initialPoints.Rows(); i++ {
 		// Get original point
 		p0x := initialPoints.GetFloatAt(i, 0)
@@ -26,78 +153,262 @@ func GenerateDenseFlowMap(initialPoints, currentPoints gocv.Mat, width, height,
 		p1x := currentPoints.GetFloatAt(i, 0)
 		p1y := currentPoints.GetFloatAt(i, 1)
 
+		// A non-finite coordinate would poison the weighted sums below.
+		if !finitePoint(gocv.Point2f{X: p0x, Y: p0y}) || !finitePoint(gocv.Point2f{X: p1x, Y: p1y}) {
+			field.NonFinite++
+			continue
+		}
+
 		// Calculate displacement vector and scale it
 		dx := (p1x - p0x) / resFactor
 		dy := (p1y - p0y) / resFactor
 
 		// Store displacement vector at the original position, scaled down
 		pt := image.Pt(int(p0x/resFactor), int(p0y/resFactor))
-		displacementMap[pt] = image.Pt(int(dx), int(dy))
-	}
-
-	// Since OpenCV doesn't have a direct sparse interpolation function in gocv,
-	// we'll use our efficient algorithm but make it more optimized
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			pt := image.Pt(x, y)
-
-			// Check if we have a direct displacement vector for this point
-			if disp, exists := displacementMap[pt]; exists {
-				// Use the direct displacement
-				dx := float64(disp.X)
-				dy := float64(disp.Y)
-
-				// Map x,y vectors to r,g scaled by the flow scale factor and centered around the mid level
-				// This allows negative values to be represented properly
-				r := uint8(math.Min(255, math.Max(0, FlowMidLevel+dx*FlowScaleFactor)))
-				g := uint8(math.Min(255, math.Max(0, FlowMidLevel+dy*FlowScaleFactor)))
-				b := uint8(0) // Blue channel set to 0
-
-				resultImg.Set(x, y, color.RGBA{r, g, b, 255})
-			} else {
-				// Interpolate from nearby sparse points using inverse distance weighting
-				var totalX, totalY, totalWeight float64
-
-				// Process all sparse points and calculate weighted contributions
-				for sparsePt, disp := range displacementMap {
-					dx := float64(x - sparsePt.X)
-					dy := float64(y - sparsePt.Y)
-					distanceSquared := dx*dx + dy*dy
-
-					// Skip if the distance is too large (optional optimization)
-					if distanceSquared > 2500 { // 50^2, limiting influence to 50 pixels
-						continue
-					}
+		if masked(mask, pt.X, pt.Y, width, height) {
+			continue
+		}
+		displacementMap[pt] = gocv.Point2f{X: dx, Y: dy}
+		delete(directionMap, pt)
+		if n := math.Hypot(float64(dx), float64(dy)); opts.Anisotropic && n > 0 {
+			directionMap[pt] = gocv.Point2f{X: dx / float32(n), Y: dy / float32(n)}
+		}
+	}
+	stretch := math.Sqrt(opts.aspectRatio())
 
-					if distanceSquared < 1.0 {
-						distanceSquared = 1.0 // Avoid division by zero
-					}
+	// Visit the sparse points in a fixed order so the floating-point sums
+	// below, and therefore the output, do not depend on map iteration order.
+	sparsePoints := make([]image.Point, 0, len(displacementMap))
+	for pt := range displacementMap {
+		sparsePoints = append(sparsePoints, pt)
+	}
+	sortPointsYX(sparsePoints)
+	sparseDisps := make([]gocv.Point2f, len(sparsePoints))
+	sparseDirs := make([]gocv.Point2f, len(sparsePoints))
+	for i, pt := range sparsePoints {
+		sparseDisps[i] = displacementMap[pt]
+		sparseDirs[i] = directionMap[pt]
+	}
 
-					weight := 1.0 / distanceSquared // Inverse distance squared weighting
+	// Bucket the sparse points so each pixel only visits those in nearby
+	// cells, which hold every point within the radius of it. Under
+	// opts.Anisotropic a feature reaches stretch times further along its
+	// displacement (or 1/stretch across it), so more cells are searched.
+	radius, power := opts.radius(), opts.power()
+	reach := 1
+	if opts.Anisotropic {
+		reach = int(math.Ceil(math.Max(stretch, 1/stretch)))
+	}
+	grid := newSparseGrid(sparsePoints, radius, reach)
+	var triangles *triangleMap
+	if opts.Mode == InterpolationDelaunay {
+		triangles = newTriangleMap(sparsePoints, width, height)
+	}
+	sparseDisp := func(i int) (float64, float64) {
+		return float64(sparseDisps[i].X), float64(sparseDisps[i].Y)
+	}
+
+	// Rows are interpolated in bands of interpolationBand rows, on up to
+	// opts.Workers goroutines. Each pixel is written by exactly one band and
+	// its sum taken in the same order, so the result does not depend on the
+	// number of workers.
+	bands := (height + interpolationBand - 1) / interpolationBand
+	parallelFor(bands, opts.workers(), func(band int) {
+		if ctx.Err() != nil {
+			return
+		}
+		var candidates [][]int
+		for y := band * interpolationBand; y < min(height, (band+1)*interpolationBand); y++ {
+			if candidates == nil || y%grid.cell == 0 {
+				candidates = grid.row(y/grid.cell, width)
+			}
+			for x := 0; x < width; x++ {
+				pt := image.Pt(x, y)
 
-					totalX += float64(disp.X) * weight
-					totalY += float64(disp.Y) * weight
-					totalWeight += weight
+				if masked(mask, x, y, width, height) {
+					field.SetNoData(x, y)
+					continue
 				}
 
-				if totalWeight > 0 {
-					// Average the weighted contributions
-					avgX := totalX / totalWeight
-					avgY := totalY / totalWeight
+				// Check if we have a direct displacement vector for this point
+				if disp, exists := displacementMap[pt]; exists {
+					// Use the direct displacement
+					field.Set(x, y, float64(disp.X), float64(disp.Y))
+					confidence[y][x] = 1
+				} else {
+					// Interpolate from nearby sparse points using inverse distance weighting
+					var totalX, totalY, totalWeight float64
 
-					// Map x,y vectors to r,g scaled by the flow scale factor and centered around the mid level
-					r := uint8(math.Min(255, math.Max(0, FlowMidLevel+avgX*FlowScaleFactor)))
-					g := uint8(math.Min(255, math.Max(0, FlowMidLevel+avgY*FlowScaleFactor)))
-					b := uint8(0) // Blue channel set to 0
+					// Process the nearby sparse points, in the fixed order, and
+					// calculate weighted contributions
+					for _, i := range candidates[x/grid.cell] {
+						sparsePt := sparsePoints[i]
+						disp := sparseDisps[i]
+						dx := float64(x - sparsePt.X)
+						dy := float64(y - sparsePt.Y)
+						if dir := sparseDirs[i]; dir != (gocv.Point2f{}) {
+							ux, uy := float64(dir.X), float64(dir.Y)
+							dx, dy = (dx*ux+dy*uy)/stretch, (dy*ux-dx*uy)*stretch
+						}
+						distanceSquared := dx*dx + dy*dy
 
-					resultImg.Set(x, y, color.RGBA{r, g, b, 255})
-				} else {
-					// No nearby sparse points, set to middle value (no flow)
-					resultImg.Set(x, y, color.RGBA{FlowMidLevel, FlowMidLevel, 0, 255})
+						// Skip if the distance is too large
+						if distanceSquared > radius*radius {
+							continue
+						}
+
+						if distanceSquared < 1.0 {
+							distanceSquared = 1.0 // Avoid division by zero
+						}
+
+						weight := 1.0 / distanceSquared // Inverse distance squared weighting
+						if power != 2 {
+							weight = math.Pow(distanceSquared, -power/2)
+						}
+
+						totalX += float64(disp.X) * weight
+						totalY += float64(disp.Y) * weight
+						totalWeight += weight
+					}
+
+					// Inside the triangulation the triangle's corners give the
+					// flow, but the confidence is still the weight of the
+					// features nearby, so that fusion trusts the dense flow
+					// in large triangles.
+					if dx, dy, ok := triangles.interpolate(x, y, sparsePoints, sparseDisp); ok {
+						field.Set(x, y, dx, dy)
+						confidence[y][x] = math.Min(1, totalWeight)
+					} else if totalWeight > 0 {
+						// Average the weighted contributions
+						field.Set(x, y, totalX/totalWeight, totalY/totalWeight)
+						confidence[y][x] = math.Min(1, totalWeight)
+					} else if opts.NearestFallback {
+						if i, ok := grid.nearest(pt, sparsePoints); ok {
+							disp := sparseDisps[i]
+							field.Set(x, y, float64(disp.X), float64(disp.Y))
+						}
+					}
+					// Otherwise there are no nearby sparse points and the
+					// pixel keeps zero flow.
 				}
 			}
 		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return field, confidence, nil
+}
+
+// interpolationBand is the number of rows of a flow field interpolated by
+// one task of parallelFor.
+const interpolationBand = 16
+
+// sparseGrid buckets the indices of sparse points into square cells as wide
+// as the interpolation radius, or into a single cell if it is unlimited.
+type sparseGrid struct {
+	cells map[image.Point][]int
+	// cell is the side of the cells in pixels.
+	cell int
+	// reach is the number of cells searched on each side of a pixel's own.
+	reach int
+	// all holds every index, in order, for an unlimited radius.
+	all []int
+}
+
+func newSparseGrid(points []image.Point, radius float64, reach int) sparseGrid {
+	if math.IsInf(radius, 1) {
+		all := make([]int, len(points))
+		for i := range all {
+			all[i] = i
+		}
+		return sparseGrid{cell: math.MaxInt32, all: all}
+	}
+	g := sparseGrid{cells: make(map[image.Point][]int), cell: max(1, int(math.Ceil(radius))), reach: reach}
+	for i, pt := range points {
+		c := image.Pt(floorDiv(pt.X, g.cell), floorDiv(pt.Y, g.cell))
+		g.cells[c] = append(g.cells[c], i)
 	}
+	return g
+}
 
-	return resultImg, nil
+// row returns, for each cell of the cell row cy across width pixels, the
+// ascending indices of the points at most reach cells away from it. Visiting
+// them in that order sums a pixel's contributions in the same order as
+// visiting every point would.
+func (g sparseGrid) row(cy, width int) [][]int {
+	if g.cells == nil {
+		return [][]int{g.all}
+	}
+	row := make([][]int, (width+g.cell-1)/g.cell)
+	for cx := range row {
+		var near []int
+		for y := cy - g.reach; y <= cy+g.reach; y++ {
+			for x := cx - g.reach; x <= cx+g.reach; x++ {
+				near = append(near, g.cells[image.Pt(x, y)]...)
+			}
+		}
+		sort.Ints(near)
+		row[cx] = near
+	}
+	return row
+}
+
+// nearest returns the index of the point of points closest to pt, the
+// lowest index among equally close ones, searching the cells in rings
+// around pt's own until no closer point can remain. It reports false if
+// there are no points.
+func (g sparseGrid) nearest(pt image.Point, points []image.Point) (int, bool) {
+	best, bestD := -1, math.MaxInt
+	consider := func(i int) {
+		d := (points[i].X-pt.X)*(points[i].X-pt.X) + (points[i].Y-pt.Y)*(points[i].Y-pt.Y)
+		if d < bestD || d == bestD && i < best {
+			best, bestD = i, d
+		}
+	}
+	if g.cells == nil {
+		for _, i := range g.all {
+			consider(i)
+		}
+		return best, best >= 0
+	}
+	c := image.Pt(floorDiv(pt.X, g.cell), floorDiv(pt.Y, g.cell))
+	for ring, seen := 0, 0; seen < len(points); ring++ {
+		// The points of this ring and beyond are more than ring-1 cells
+		// away.
+		if far := (ring - 1) * g.cell; best >= 0 && ring > 0 && bestD <= far*far {
+			break
+		}
+		visit := func(x, y int) {
+			for _, i := range g.cells[image.Pt(x, y)] {
+				consider(i)
+				seen++
+			}
+		}
+		if ring == 0 {
+			visit(c.X, c.Y)
+			continue
+		}
+		// Only the perimeter of the ring: its top and bottom rows, then
+		// the rest of its left and right columns.
+		for x := c.X - ring; x <= c.X+ring; x++ {
+			visit(x, c.Y-ring)
+			visit(x, c.Y+ring)
+		}
+		for y := c.Y - ring + 1; y < c.Y+ring; y++ {
+			visit(c.X-ring, y)
+			visit(c.X+ring, y)
+		}
+	}
+	return best, best >= 0
+}
+
+// floorDiv returns a/b rounded toward negative infinity, for b > 0.
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
 }
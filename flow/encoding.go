@@ -0,0 +1,130 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	// FlowScaleFactor16 and FlowMidLevel16 are the defaults of a 16-bit
+	// Encoding: steps of 0.01 pixels up to about 327 pixels either way.
+	FlowScaleFactor16 = 100.0
+	FlowMidLevel16    = 32768
+)
+
+// Encoding is how a flow map stores displacements in its red and green
+// channels: a level of MidLevel + d*Scale for a displacement of d pixels,
+// clamped to the levels of the channel depth. Its zero value is the 8-bit
+// encoding of FlowScaleFactor and FlowMidLevel, which covers displacements
+// up to 12.7 pixels in steps of 0.1; a smaller Scale trades precision for
+// range, and a Depth of 16 gains both.
+type Encoding struct {
+	// Scale is the levels per pixel of displacement. Zero means
+	// FlowScaleFactor, or FlowScaleFactor16 at 16 bits.
+	Scale float64
+	// MidLevel is the level of zero displacement. Zero means FlowMidLevel,
+	// or FlowMidLevel16 at 16 bits.
+	MidLevel uint16
+	// Depth is the bits per channel, 8 or 16. Zero means 8. A 16-bit
+	// flow map is an *image.NRGBA64.
+	Depth int
+}
+
+func (e Encoding) is16() bool {
+	return e.Depth == 16
+}
+
+func (e Encoding) scale() float64 {
+	switch {
+	case e.Scale > 0:
+		return e.Scale
+	case e.is16():
+		return FlowScaleFactor16
+	}
+	return FlowScaleFactor
+}
+
+func (e Encoding) midLevel() float64 {
+	switch {
+	case e.MidLevel > 0:
+		return float64(e.MidLevel)
+	case e.is16():
+		return FlowMidLevel16
+	}
+	return FlowMidLevel
+}
+
+// maxLevel returns the highest level of a channel.
+func (e Encoding) maxLevel() float64 {
+	if e.is16() {
+		return math.MaxUint16
+	}
+	return math.MaxUint8
+}
+
+// Encode returns the red and green levels of the displacement (dx, dy).
+// Displacements beyond the range of the encoding saturate.
+func (e Encoding) Encode(dx, dy float64) (r, g uint16) {
+	return e.level(dx), e.level(dy)
+}
+
+// Decode returns the displacement the red and green levels r and g encode.
+func (e Encoding) Decode(r, g uint16) (dx, dy float64) {
+	return (float64(r) - e.midLevel()) / e.scale(), (float64(g) - e.midLevel()) / e.scale()
+}
+
+// MaxDisplacement returns the largest displacement, in pixels, the encoding
+// stores in the positive direction without saturating.
+func (e Encoding) MaxDisplacement() float64 {
+	return (e.maxLevel() - e.midLevel()) / e.scale()
+}
+
+// level returns the level of the displacement d. 8-bit levels truncate, as
+// flow maps always have; 16-bit levels round, to within half a step.
+func (e Encoding) level(d float64) uint16 {
+	v := e.midLevel() + d*e.scale()
+	if e.is16() {
+		v = math.Round(v)
+	}
+	return uint16(math.Min(e.maxLevel(), math.Max(0, v)))
+}
+
+// is16Bit reports whether img stores 16 bits per channel.
+func is16Bit(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// DecodeFlowMap returns the flow field a flow map encodes, the inverse of
+// FlowField.ImageWithEncoding. The bit depth is taken from img, so enc
+// only needs the Scale and MidLevel the map was made with, zero for the
+// defaults of its depth. Transparent pixels are no data. The field's
+// ResolutionFactor and Intervals are left at zero, which count as one.
+func DecodeFlowMap(img image.Image, enc Encoding) *FlowField {
+	enc.Depth = 8
+	if is16Bit(img) {
+		enc.Depth = 16
+	}
+	bounds := img.Bounds()
+	field := NewFlowField(bounds.Dx(), bounds.Dy())
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+			if c.A < 0x8000 {
+				field.SetNoData(x, y)
+				continue
+			}
+			r, g := c.R, c.G
+			if !enc.is16() {
+				r, g = r>>8, g>>8
+			}
+			dx, dy := enc.Decode(r, g)
+			field.Set(x, y, dx, dy)
+		}
+	}
+	return field
+}
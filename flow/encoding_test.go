@@ -0,0 +1,177 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for _, enc := range []Encoding{{}, {Scale: 2}, {Scale: 0.5, MidLevel: 100}, {Depth: 16}} {
+		max := enc.MaxDisplacement()
+		for _, d := range []float64{0, 1.5, -3, max / 2, -max / 2} {
+			r, g := enc.Encode(d, -d)
+			dx, dy := enc.Decode(r, g)
+			if tol := 1 / enc.scale(); math.Abs(dx-d) > tol || math.Abs(dy+d) > tol {
+				t.Errorf("%+v: (%v, %v) decoded as (%v, %v)", enc, d, -d, dx, dy)
+			}
+		}
+		if r, _ := enc.Encode(2*max, 0); float64(r) != enc.maxLevel() {
+			t.Errorf("%+v: expected %v to saturate at %v, got %d", enc, 2*max, enc.maxLevel(), r)
+		}
+	}
+	if got := (Encoding{}).MaxDisplacement(); got != 12.7 {
+		t.Errorf("Expected the default encoding to reach 12.7 pixels, got %v", got)
+	}
+}
+
+// TestForwardTransformEncodingScale checks that a 30 pixel displacement,
+// beyond the range of the default encoding, survives a flow map encoded
+// with a scale of 2.
+func TestForwardTransformEncodingScale(t *testing.T) {
+	const width, height, shift = 96, 32, 30
+	const stripe = 20 // left edge of the input's white stripe
+	dir := t.TempDir()
+	enc := Encoding{Scale: 2}
+
+	field := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			field.Set(x, y, shift, 0)
+		}
+	}
+	flowPath := filepath.Join(dir, "flow.png")
+	writePNG(t, flowPath, field.ImageWithEncoding(enc))
+
+	input := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(60)
+			if x >= stripe && x < stripe+4 {
+				v = 255
+			}
+			input.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	inputPath := filepath.Join(dir, "input.png")
+	writePNG(t, inputPath, input)
+
+	// leftEdge returns the first column of the stripe in row height/2.
+	leftEdge := func(img image.Image) int {
+		for x := 0; x < width; x++ {
+			if r, _, _, _ := img.At(x, height/2).RGBA(); r>>8 == 255 {
+				return x
+			}
+		}
+		return -1
+	}
+
+	out, err := ForwardTransformWithEncoding(inputPath, flowPath, 1.0, enc)
+	if err != nil {
+		t.Fatalf("ForwardTransformWithEncoding failed: %v", err)
+	}
+	if got := leftEdge(out); math.Abs(float64(got-stripe-shift)) > 1 {
+		t.Errorf("Expected the stripe moved by %d pixels to start at x=%d, got %d", shift, stripe+shift, got)
+	}
+
+	// The default encoding clips the same displacement to 12.7 pixels.
+	writePNG(t, flowPath, field.Image())
+	out, err = ForwardTransform(inputPath, flowPath, 1.0)
+	if err != nil {
+		t.Fatalf("ForwardTransform failed: %v", err)
+	}
+	if got := leftEdge(out); got > stripe+13 {
+		t.Errorf("Expected the default encoding to saturate, but the stripe starts at x=%d", got)
+	}
+}
+
+// TestFlowMap16BitRoundTrip checks that random displacements within 200
+// pixels survive a 16-bit flow map written to and read back from a PNG
+// to within 0.02 pixels, and that DecodeFlowMap tells the depths apart.
+func TestFlowMap16BitRoundTrip(t *testing.T) {
+	const width, height = 64, 48
+	rng := rand.New(rand.NewSource(3))
+	field := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			field.Set(x, y, rng.Float64()*400-200, rng.Float64()*400-200)
+		}
+	}
+	field.SetNoData(5, 7)
+
+	enc := Encoding{Depth: 16}
+	if max := enc.MaxDisplacement(); max < 200 {
+		t.Fatalf("Expected a 16-bit range of at least 200 pixels, got %v", max)
+	}
+	path := filepath.Join(t.TempDir(), "flow.png")
+	writePNG(t, path, field.ImageWithEncoding(enc))
+	img := readPNG(t, path)
+	if !is16Bit(img) {
+		t.Fatalf("Expected a 16-bit PNG, got %T", img)
+	}
+
+	// DecodeFlowMap detects the depth; the zero Encoding means its defaults.
+	decoded := DecodeFlowMap(img, Encoding{})
+	var maxErr float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			want, wantY, wantValid := field.At(x, y)
+			got, gotY, valid := decoded.At(x, y)
+			if valid != wantValid {
+				t.Fatalf("Pixel (%d, %d): valid %v, want %v", x, y, valid, wantValid)
+			}
+			maxErr = math.Max(maxErr, math.Max(math.Abs(got-want), math.Abs(gotY-wantY)))
+		}
+	}
+	if maxErr >= 0.02 {
+		t.Errorf("Expected a round-trip error below 0.02 pixels, got %v", maxErr)
+	}
+
+	// An 8-bit map of the same field decodes at 8 bits, clipped and coarse.
+	decoded = DecodeFlowMap(field.Image(), Encoding{})
+	if dx, _, _ := decoded.At(0, 0); math.Abs(dx) > (Encoding{}).MaxDisplacement()+0.1 {
+		t.Errorf("Expected an 8-bit map to clip to its range, got %v", dx)
+	}
+}
+
+// TestForwardTransform16Bit checks that ForwardTransform reads a 16-bit
+// flow map without being told its depth.
+func TestForwardTransform16Bit(t *testing.T) {
+	const width, height, shift = 96, 32, 30
+	dir := t.TempDir()
+	field := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			field.Set(x, y, shift, 0)
+		}
+	}
+	flowPath := filepath.Join(dir, "flow.png")
+	writePNG(t, flowPath, field.ImageWithEncoding(Encoding{Depth: 16}))
+
+	input := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(60)
+			if x >= 20 && x < 24 {
+				v = 255
+			}
+			input.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	inputPath := filepath.Join(dir, "input.png")
+	writePNG(t, inputPath, input)
+
+	out, err := ForwardTransform(inputPath, flowPath, 1.0)
+	if err != nil {
+		t.Fatalf("ForwardTransform failed: %v", err)
+	}
+	for x := 0; x < width; x++ {
+		r, _, _, _ := out.At(x, height/2).RGBA()
+		if want := x >= 20+shift && x < 24+shift; (r>>8 == 255) != want {
+			t.Errorf("Pixel %d of the shifted row: stripe %v, want %v", x, r>>8 == 255, want)
+		}
+	}
+}
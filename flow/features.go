@@ -0,0 +1,187 @@
+package flow
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// Defaults of FeatureOptions, which are also those of OpenCV's
+// GoodFeaturesToTrack and CalcOpticalFlowPyrLK as the flow package
+// has always called them.
+const (
+	DefaultMaxFeatures   = 100
+	DefaultQualityLevel  = 0.3
+	DefaultMinDistance   = 7.0
+	DefaultWindowSize    = 21
+	DefaultPyramidLevels = 3
+	// DefaultMaxRoundTripError is the default FeatureOptions.MaxRoundTripError,
+	// in pixels.
+	DefaultMaxRoundTripError = 1.0
+)
+
+// FeatureOptions configures the detection of features with Shi-Tomasi
+// GoodFeaturesToTrack, or their placement on a grid, and their tracking with pyramidal Lucas-Kanade. The
+// zero value detects and tracks them as GenerateAverageFlowMap always has;
+// every zero or negative field takes its default.
+type FeatureOptions struct {
+	// MaxFeatures is the most features detected in a frame, strongest
+	// first. More features give a denser flow field at a higher cost.
+	// Zero means DefaultMaxFeatures.
+	MaxFeatures int
+	// QualityLevel is the minimum corner strength kept, relative to the
+	// strongest corner of the frame, in (0, 1]. Lowering it finds features
+	// on low-contrast frames, such as weak rainfall, that the default
+	// rejects, but admits noisier ones. Zero means DefaultQualityLevel.
+	QualityLevel float64
+	// MinDistance is the minimum distance in pixels between detected
+	// features. Lowering it packs features into small textured regions;
+	// raising it spreads them out. Zero means DefaultMinDistance.
+	MinDistance float64
+	// WindowSize is the side in pixels of the square window LK matches
+	// around each feature on every pyramid level. Larger windows are more
+	// robust to noise and track larger motion, but blur motion boundaries.
+	// Zero means DefaultWindowSize.
+	WindowSize int
+	// PyramidLevels is the number of pyramid levels LK uses above the full
+	// resolution frame. Each level doubles the motion it can track, at the
+	// risk of matching coarse structure wrongly. Zero means
+	// DefaultPyramidLevels.
+	PyramidLevels int
+	// ForwardBackward also tracks every feature from the next frame back to
+	// the previous one and drops it unless it returns to within
+	// MaxRoundTripError of where it started. It catches features LK reports
+	// found that have drifted onto the wrong structure, at the cost of
+	// tracking twice.
+	ForwardBackward bool
+	// MaxRoundTripError is the largest forward-backward round-trip error,
+	// in pixels, of a feature kept under ForwardBackward. Zero means
+	// DefaultMaxRoundTripError.
+	MaxRoundTripError float64
+	// MaxTrackingError is the largest LK match error, the mean absolute
+	// difference in grey levels between a feature's window in the two
+	// frames, of a feature kept. LK reports a feature found wherever it
+	// settles, even between uncorrelated frames, where the windows differ
+	// by about a third of the intensity range, so a limit of about 50
+	// drops the features of such frames. Zero keeps every feature LK
+	// finds.
+	MaxTrackingError float64
+	// Seeding selects how features are placed: on corners, or under
+	// SeedGrid on a regular grid that samples the whole frame, for the
+	// interpolation to have data everywhere. QualityLevel and MinDistance
+	// only apply to SeedCorners, GridSpacing and MinVariance only to
+	// SeedGrid. MaxFeatures applies to both.
+	Seeding Seeding
+	// GridSpacing is the side in pixels of the SeedGrid cells. The grid is
+	// widened when it would hold more than MaxFeatures cells. Zero means
+	// DefaultGridSpacing.
+	GridSpacing int
+	// MinVariance is the intensity variance, in grey levels squared, below
+	// which a SeedGrid cell gets no feature. Zero keeps every cell.
+	MinVariance float64
+}
+
+func (o FeatureOptions) maxFeatures() int {
+	if o.MaxFeatures > 0 {
+		return o.MaxFeatures
+	}
+	return DefaultMaxFeatures
+}
+
+func (o FeatureOptions) qualityLevel() float64 {
+	if o.QualityLevel > 0 {
+		return o.QualityLevel
+	}
+	return DefaultQualityLevel
+}
+
+func (o FeatureOptions) minDistance() float64 {
+	if o.MinDistance > 0 {
+		return o.MinDistance
+	}
+	return DefaultMinDistance
+}
+
+func (o FeatureOptions) windowSize() int {
+	if o.WindowSize > 0 {
+		return o.WindowSize
+	}
+	return DefaultWindowSize
+}
+
+func (o FeatureOptions) maxRoundTripError() float64 {
+	if o.MaxRoundTripError > 0 {
+		return o.MaxRoundTripError
+	}
+	return DefaultMaxRoundTripError
+}
+
+// maxTrackingError returns MaxTrackingError, or +Inf when it is not set.
+func (o FeatureOptions) maxTrackingError() float64 {
+	if o.MaxTrackingError > 0 {
+		return o.MaxTrackingError
+	}
+	return math.Inf(1)
+}
+
+func (o FeatureOptions) pyramidLevels() int {
+	if o.PyramidLevels > 0 {
+		return o.PyramidLevels
+	}
+	return DefaultPyramidLevels
+}
+
+func (o FeatureOptions) gridSpacing() int {
+	if o.GridSpacing > 0 {
+		return o.GridSpacing
+	}
+	return DefaultGridSpacing
+}
+
+// detect runs GoodFeaturesToTrack on img with up to maxFeatures features,
+// or places them with GridFeatures under SeedGrid. If region is set, only
+// features inside it are kept. gocv does not expose GoodFeaturesToTrack's
+// mask argument, so corners are then detected without a limit, at the
+// quality level relative to the strongest corner inside region, and the
+// strongest maxFeatures inside it kept.
+func (o FeatureOptions) detect(img gocv.Mat, corners *gocv.Mat, maxFeatures int, region *image.Alpha) {
+	if o.Seeding == SeedGrid {
+		points := GridFeatures(img, o.gridSpacing(), maxFeatures, o.MinVariance)
+		if region != nil {
+			grid := pointsMat(points)
+			defer grid.Close()
+			points = inRegion(grid, region, img.Cols(), img.Rows(), 0)
+		}
+		corners.Close()
+		*corners = pointsMat(points)
+		return
+	}
+	if region == nil {
+		gocv.GoodFeaturesToTrack(img, corners, maxFeatures, o.qualityLevel(), o.minDistance())
+		return
+	}
+	var points []gocv.Point2f
+	if quality := regionQuality(img, region, o.qualityLevel()); quality > 0 {
+		all := gocv.NewMat()
+		defer all.Close()
+		gocv.GoodFeaturesToTrack(img, &all, 0, quality, o.minDistance())
+		points = inRegion(all, region, img.Cols(), img.Rows(), maxFeatures)
+	}
+	corners.Close()
+	*corners = pointsMat(points)
+}
+
+// track runs CalcOpticalFlowPyrLK from prevPoints in prevMat to nextMat.
+// Unless the window or pyramid is set it makes the same call as
+// GenerateAverageFlowMap always has.
+func (o FeatureOptions) track(prevMat, nextMat, prevPoints, nextPoints gocv.Mat, status, errMat *gocv.Mat) {
+	if o.WindowSize <= 0 && o.PyramidLevels <= 0 {
+		gocv.CalcOpticalFlowPyrLK(prevMat, nextMat, prevPoints, nextPoints, status, errMat)
+		return
+	}
+	criteria := gocv.NewTermCriteria(gocv.Count+gocv.EPS, 30, 0.01)
+	win := o.windowSize()
+	gocv.CalcOpticalFlowPyrLKWithParams(prevMat, nextMat, prevPoints, nextPoints, status, errMat,
+		image.Pt(win, win), o.pyramidLevels(), criteria, 0, 1e-4)
+}
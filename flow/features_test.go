@@ -0,0 +1,132 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// faintSquareFrames returns two 120x80 frames with a bright square and a
+// faint one, both moved 3 px right in the second frame.
+func faintSquareFrames() []image.Image {
+	imgs := make([]image.Image, 2)
+	for i := range imgs {
+		img := image.NewGray(image.Rect(0, 0, 120, 80))
+		fill := func(r image.Rectangle, v uint8) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					img.SetGray(x, y, color.Gray{Y: v})
+				}
+			}
+		}
+		fill(image.Rect(20+3*i, 30, 40+3*i, 50), 255)
+		fill(image.Rect(70+3*i, 30, 90+3*i, 50), 80)
+		imgs[i] = img
+	}
+	return imgs
+}
+
+// TestFeatureOptions checks that spelling out the defaults reproduces the
+// zero value, that a lower quality level picks up the faint square's
+// corners the default rejects, and that MaxFeatures caps the detection.
+func TestFeatureOptions(t *testing.T) {
+	imgs := faintSquareFrames()
+	run := func(features FeatureOptions) FlowResult {
+		t.Helper()
+		result, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 1, FlowOptions{Features: features, RecordPaths: true})
+		if err != nil {
+			t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions(%+v) failed: %v", features, err)
+		}
+		return result
+	}
+
+	defaults := run(FeatureOptions{})
+	explicit := run(FeatureOptions{
+		MaxFeatures:   DefaultMaxFeatures,
+		QualityLevel:  DefaultQualityLevel,
+		MinDistance:   DefaultMinDistance,
+		WindowSize:    DefaultWindowSize,
+		PyramidLevels: DefaultPyramidLevels,
+	})
+	compareImages(t, explicit.Image, defaults.Image, 0)
+
+	faint := func(result FlowResult) int {
+		n := 0
+		for _, path := range result.Paths {
+			if path[0].X > 60 {
+				n++
+			}
+		}
+		return n
+	}
+	if n := faint(defaults); n != 0 {
+		t.Errorf("Expected the default quality level to reject the faint square, got %d features on it", n)
+	}
+	if n := faint(run(FeatureOptions{QualityLevel: 0.05})); n == 0 {
+		t.Error("Expected a quality level of 0.05 to find features on the faint square")
+	}
+
+	if n := len(run(FeatureOptions{MaxFeatures: 2}).Paths); n == 0 || n > 2 {
+		t.Errorf("Expected one or two features under MaxFeatures 2, got %d", n)
+	}
+}
+
+// TestForwardBackward adds 5x5 white speckles to the background of the
+// first test frame that are gone from the shifted one. LK reports most of
+// them found, at drifting positions, and they drag the flow away from the
+// square's (20, 10) shift; the forward-backward check drops them all and
+// keeps the square's corners.
+func TestForwardBackward(t *testing.T) {
+	const resolutionFactor = 4
+	first := readPNG(t, "../test_data/centered.png")
+	noisy := image.NewNRGBA(first.Bounds())
+	draw.Draw(noisy, noisy.Bounds(), first, first.Bounds().Min, draw.Src)
+	for i := 0; i < 12; i++ {
+		draw.Draw(noisy, image.Rect(0, 0, 5, 5).Add(image.Pt(80+70*i, 150+300*(i%3))), image.White, image.Point{}, draw.Src)
+	}
+	imgs := []image.Image{noisy, readPNG(t, "../test_data/shifted.png")}
+	wantX, wantY := 20.0/resolutionFactor, 10.0/resolutionFactor
+
+	run := func(features FeatureOptions) FlowResult {
+		t.Helper()
+		result, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, resolutionFactor, FlowOptions{Features: features, RecordPaths: true})
+		if err != nil {
+			t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions(%+v) failed: %v", features, err)
+		}
+		return result
+	}
+	// bad counts the paths that do not move by the square's shift.
+	bad := func(result FlowResult) int {
+		n := 0
+		for _, path := range result.Paths {
+			if math.Hypot(float64(path[1].X-path[0].X)-20, float64(path[1].Y-path[0].Y)-10) > 0.5 {
+				n++
+			}
+		}
+		return n
+	}
+
+	plain := run(FeatureOptions{})
+	if n := bad(plain); n == 0 {
+		t.Fatal("Expected the speckles to yield features with wrong motion without the check")
+	}
+	if dx, dy := calculateAverageFlow(t, plain.Image); math.Abs(dx-wantX) < 1 && math.Abs(dy-wantY) < 1 {
+		t.Errorf("Expected the speckles to pull the average flow away from (%v, %v), got (%.2f, %.2f)", wantX, wantY, dx, dy)
+	}
+
+	checked := run(FeatureOptions{ForwardBackward: true})
+	if n := bad(checked); n != 0 || len(checked.Paths) < 4 {
+		t.Errorf("Expected the square's corners alone to survive the check, got %d paths, %d with wrong motion", len(checked.Paths), n)
+	}
+	if dx, dy := calculateAverageFlow(t, checked.Image); math.Abs(dx-wantX) > 0.1 || math.Abs(dy-wantY) > 0.1 {
+		t.Errorf("Expected an average flow of (%v, %v) with the check, got (%.2f, %.2f)", wantX, wantY, dx, dy)
+	}
+	if plain.Dropped.RoundTrip != 0 || checked.Dropped.RoundTrip == 0 {
+		t.Errorf("Expected the speckles dropped by the check to be counted, got %+v without it and %+v with it", plain.Dropped, checked.Dropped)
+	}
+	if got := checked.Provenance.Options; !got.ForwardBackward {
+		t.Error("Expected the provenance to record the forward-backward check")
+	}
+}
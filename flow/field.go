@@ -0,0 +1,191 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ErrNonFiniteField is returned when every displacement of a flow field
+// that holds any is NaN or infinite, so there is nothing worth encoding.
+var ErrNonFiniteField = errors.New("flow: flow field has no finite displacements")
+
+// FlowField is a dense displacement field stored row by row. Pixels marked
+// as not valid carry no data; they are encoded as the neutral flow value
+// with zero alpha.
+type FlowField struct {
+	Width, Height int
+	DX, DY        []float64
+	Valid         []bool
+	// ResolutionFactor is the number of full-resolution pixels per field
+	// pixel and Intervals the number of frame intervals the displacements
+	// span; zero means 1. Together with Units they let DisplacementAt and
+	// VelocityAt report physical values. Accumulator.FlowField sets all
+	// three.
+	ResolutionFactor int
+	Intervals        int
+	Units            Units
+	// FullResolution reports that DX and DY are in full-resolution pixels
+	// rather than field pixels. The flow functions return fields so under
+	// AutoResolution, where the caller did not choose the factor, and the
+	// functions that take a field accept either.
+	FullResolution bool
+	// NonFinite is the number of sparse features left out, and of pixels
+	// marked as no data, because their displacement was NaN or infinite.
+	NonFinite int
+}
+
+// NewFlowField returns a width x height field of zero, valid displacements.
+func NewFlowField(width, height int) *FlowField {
+	n := width * height
+	f := &FlowField{
+		Width:  width,
+		Height: height,
+		DX:     make([]float64, n),
+		DY:     make([]float64, n),
+		Valid:  make([]bool, n),
+	}
+	for i := range f.Valid {
+		f.Valid[i] = true
+	}
+	return f
+}
+
+// At returns the displacement at (x, y) and whether it holds data.
+func (f *FlowField) At(x, y int) (dx, dy float64, valid bool) {
+	i := y*f.Width + x
+	return f.DX[i], f.DY[i], f.Valid[i]
+}
+
+// Set stores a valid displacement at (x, y).
+func (f *FlowField) Set(x, y int, dx, dy float64) {
+	i := y*f.Width + x
+	f.DX[i], f.DY[i], f.Valid[i] = dx, dy, true
+}
+
+// SetNoData marks (x, y) as holding no data.
+func (f *FlowField) SetNoData(x, y int) {
+	i := y*f.Width + x
+	f.DX[i], f.DY[i], f.Valid[i] = 0, 0, false
+}
+
+// checkFinite marks the valid pixels whose displacement is not finite as no
+// data, counting them in NonFinite. It returns ErrNonFiniteField if that
+// leaves no valid pixel.
+func (f *FlowField) checkFinite() error {
+	dropped, valid := 0, 0
+	for i := range f.Valid {
+		if !f.Valid[i] {
+			continue
+		}
+		if !finite(f.DX[i]) || !finite(f.DY[i]) {
+			f.DX[i], f.DY[i], f.Valid[i] = 0, 0, false
+			dropped++
+			continue
+		}
+		valid++
+	}
+	f.NonFinite += dropped
+	if dropped > 0 && valid == 0 {
+		return fmt.Errorf("%w: all %d pixels with data were NaN or infinite", ErrNonFiniteField, dropped)
+	}
+	return nil
+}
+
+// Image encodes the field as a flow map: x and y displacements are mapped to
+// the red and green channels around FlowMidLevel, scaled by FlowScaleFactor.
+// No-data pixels, and pixels whose displacement is not finite, get the
+// neutral value and an alpha of 0. The image is non-premultiplied so the
+// neutral value survives PNG encoding. The map holds the displacements in
+// field pixels, whose range the encoding scale is chosen for, even if
+// f.FullResolution is set.
+func (f *FlowField) Image() *image.NRGBA {
+	return f.fieldPixels().image8(Encoding{}, nil)
+}
+
+// ImageWithEncoding is like Image but encodes the displacements with enc:
+// as an *image.NRGBA at 8 bits and an *image.NRGBA64 at 16.
+func (f *FlowField) ImageWithEncoding(enc Encoding) image.Image {
+	return f.image(enc, nil)
+}
+
+// image is like ImageWithEncoding but draws into the image of ws if ws is
+// not nil.
+func (f *FlowField) image(enc Encoding, ws *Workspace) image.Image {
+	f = f.fieldPixels()
+	if enc.is16() {
+		return f.image16(enc, ws)
+	}
+	return f.image8(enc, ws)
+}
+
+// image8 returns the 8-bit flow map of f under enc, drawn into the image of
+// ws if ws is not nil.
+func (f *FlowField) image8(enc Encoding, ws *Workspace) *image.NRGBA {
+	img := ws.nrgba(f.Width, f.Height)
+	neutral := uint8(enc.midLevel())
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			dx, dy, valid := f.At(x, y)
+			if !valid || !finite(dx) || !finite(dy) {
+				img.SetNRGBA(x, y, color.NRGBA{R: neutral, G: neutral, B: 0, A: 0})
+				continue
+			}
+			r, g := enc.Encode(dx, dy)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(r), G: uint8(g), B: 0, A: 255})
+		}
+	}
+	return img
+}
+
+// image16 is like image8 at 16 bits.
+func (f *FlowField) image16(enc Encoding, ws *Workspace) *image.NRGBA64 {
+	img := ws.nrgba64(f.Width, f.Height)
+	neutral := uint16(enc.midLevel())
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			dx, dy, valid := f.At(x, y)
+			if !valid || !finite(dx) || !finite(dy) {
+				img.SetNRGBA64(x, y, color.NRGBA64{R: neutral, G: neutral, B: 0, A: 0})
+				continue
+			}
+			r, g := enc.Encode(dx, dy)
+			img.SetNRGBA64(x, y, color.NRGBA64{R: r, G: g, B: 0, A: math.MaxUint16})
+		}
+	}
+	return img
+}
+
+// PaletteIndexMask returns a no-data mask for a paletted image in which the
+// pixels holding the given palette index (such as 0 for "no echo") are
+// marked as no data.
+func PaletteIndexMask(img *image.Paletted, index uint8) *image.Alpha {
+	bounds := img.Bounds()
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if img.ColorIndexAt(x, y) != index {
+				mask.SetAlpha(x, y, color.Alpha{A: 255})
+			}
+		}
+	}
+	return mask
+}
+
+// masked reports whether field pixel (x, y) of a width x height field falls
+// on a no-data pixel of mask. The mask may have any size; it is sampled at
+// the nearest corresponding pixel. A nil mask masks nothing.
+func masked(mask *image.Alpha, x, y, width, height int) bool {
+	if mask == nil {
+		return false
+	}
+	bounds := mask.Bounds()
+	mx := bounds.Min.X + x*bounds.Dx()/width
+	my := bounds.Min.Y + y*bounds.Dy()/height
+	if !(image.Point{X: mx, Y: my}).In(bounds) {
+		return true
+	}
+	return mask.AlphaAt(mx, my).A == 0
+}
@@ -0,0 +1,552 @@
+package flow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	stripeTop    = 28
+	stripeBottom = 36 // exclusive
+)
+
+// stripeMask returns a size x size mask with a horizontal no-data stripe.
+func stripeMask(size int) *image.Alpha {
+	mask := image.NewAlpha(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		if y >= stripeTop && y < stripeBottom {
+			continue
+		}
+		for x := 0; x < size; x++ {
+			mask.SetAlpha(x, y, color.Alpha{A: 255})
+		}
+	}
+	return mask
+}
+
+// uniformPointMats returns features on a grid, all displaced by (dx, dy).
+func uniformPointMats(size, step int, dx, dy float32) (gocv.Mat, gocv.Mat) {
+	var pts []image.Point
+	for y := step / 2; y < size; y += step {
+		for x := step / 2; x < size; x += step {
+			pts = append(pts, image.Pt(x, y))
+		}
+	}
+	initial := gocv.NewMatWithSize(len(pts), 2, gocv.MatTypeCV32F)
+	current := gocv.NewMatWithSize(len(pts), 2, gocv.MatTypeCV32F)
+	for i, pt := range pts {
+		initial.SetFloatAt(i, 0, float32(pt.X))
+		initial.SetFloatAt(i, 1, float32(pt.Y))
+		current.SetFloatAt(i, 0, float32(pt.X)+dx)
+		current.SetFloatAt(i, 1, float32(pt.Y)+dy)
+	}
+	return initial, current
+}
+
+func TestInterpolateFlowFieldMaskedStripe(t *testing.T) {
+	const size = 64
+	initial, current := uniformPointMats(size, 8, 0, 6)
+	defer initial.Close()
+	defer current.Close()
+
+	field, err := InterpolateFlowField(initial, current, size, size, 1, stripeMask(size))
+	if err != nil {
+		t.Fatalf("InterpolateFlowField failed: %v", err)
+	}
+
+	// Round-trip through PNG to check the neutral, transparent encoding survives.
+	path := filepath.Join(t.TempDir(), "flow.png")
+	writePNG(t, path, field.Image())
+	decoded := readPNG(t, path)
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			inStripe := y >= stripeTop && y < stripeBottom
+			if inStripe {
+				if _, _, valid := field.At(x, y); valid {
+					t.Fatalf("Expected (%d, %d) in the stripe to hold no data", x, y)
+				}
+				if c != (color.NRGBA{R: FlowMidLevel, G: FlowMidLevel, B: 0, A: 0}) {
+					t.Fatalf("Expected neutral transparent pixel at (%d, %d), got %v", x, y, c)
+				}
+			} else if want := FlowMidLevel + 6*FlowScaleFactor; c.A != 255 || float64(c.G) < want-1 || float64(c.G) > want {
+				// Interpolated values may truncate one level down.
+				t.Fatalf("Expected opaque flow (0, 6) at (%d, %d), got %v", x, y, c)
+			}
+		}
+	}
+}
+
+func TestInterpolateFlowFieldDropsNonFinite(t *testing.T) {
+	const size = 64
+	initial, current := uniformPointMats(size, 8, 0, 6)
+	defer initial.Close()
+	defer current.Close()
+	// Poison a few rows as LK does on degenerate pyramids.
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	current.SetFloatAt(3, 0, nan)
+	current.SetFloatAt(10, 1, inf)
+	initial.SetFloatAt(20, 1, nan)
+
+	field, err := InterpolateFlowField(initial, current, size, size, 1, nil)
+	if err != nil {
+		t.Fatalf("InterpolateFlowField failed: %v", err)
+	}
+	if field.NonFinite != 3 {
+		t.Errorf("Expected 3 dropped features, got %d", field.NonFinite)
+	}
+	if err := field.checkFinite(); err != nil {
+		t.Fatalf("checkFinite failed: %v", err)
+	}
+
+	img := field.Image()
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy, valid := field.At(x, y)
+			if !valid || dx != 0 || math.Abs(dy-6) > 1e-6 {
+				t.Fatalf("Expected flow (0, 6) at (%d, %d), got (%v, %v) valid=%v", x, y, dx, dy, valid)
+			}
+			// Interpolated values may truncate one level down.
+			if c, want := img.NRGBAAt(x, y), FlowMidLevel+6*FlowScaleFactor; c.A != 255 || float64(c.G) < want-1 || float64(c.G) > want {
+				t.Fatalf("Expected opaque flow (0, 6) at (%d, %d), got %v", x, y, c)
+			}
+		}
+	}
+}
+
+// TestAnisotropicInterpolationKeepsShearSharp checks that on a grid of
+// features moving down left of x = 32 and up right of it, elongating each
+// feature's influence along its motion narrows the band around the boundary
+// where the interpolated flow has lost a quarter of its magnitude.
+func TestAnisotropicInterpolationKeepsShearSharp(t *testing.T) {
+	const size, speed = 64, 6
+	initial, current := uniformPointMats(size, 8, 0, speed)
+	defer initial.Close()
+	defer current.Close()
+	for i := 0; i < initial.Rows(); i++ {
+		if initial.GetFloatAt(i, 0) > size/2 {
+			current.SetFloatAt(i, 1, initial.GetFloatAt(i, 1)-speed)
+		}
+	}
+
+	transition := func(opts InterpolationOptions) int {
+		t.Helper()
+		field, _, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, opts)
+		if err != nil {
+			t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+		}
+		n := 0
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if _, dy, _ := field.At(x, y); math.Abs(dy) < 0.75*speed {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	isotropic := transition(InterpolationOptions{})
+	anisotropic := transition(InterpolationOptions{Anisotropic: true})
+	if isotropic == 0 {
+		t.Fatal("Expected a transition zone under isotropic weighting")
+	}
+	if anisotropic >= isotropic {
+		t.Errorf("Expected a narrower transition zone under anisotropic weighting, got %d pixels against %d", anisotropic, isotropic)
+	}
+	if aspect1 := transition(InterpolationOptions{Anisotropic: true, AspectRatio: 1}); aspect1 != isotropic {
+		t.Errorf("Expected an aspect ratio of 1 to match isotropic weighting, got %d pixels against %d", aspect1, isotropic)
+	}
+}
+
+func TestCheckFinite(t *testing.T) {
+	field := NewFlowField(2, 2)
+	field.Set(0, 0, math.NaN(), 0)
+	field.Set(1, 0, 0, math.Inf(-1))
+	if err := field.checkFinite(); err != nil {
+		t.Fatalf("checkFinite failed: %v", err)
+	}
+	if field.NonFinite != 2 {
+		t.Errorf("Expected 2 non-finite pixels, got %d", field.NonFinite)
+	}
+	if _, _, valid := field.At(0, 0); valid {
+		t.Error("Expected the NaN pixel to hold no data")
+	}
+	if c := field.Image().NRGBAAt(1, 0); c != (color.NRGBA{R: FlowMidLevel, G: FlowMidLevel, B: 0, A: 0}) {
+		t.Errorf("Expected the infinite pixel to be neutral and transparent, got %v", c)
+	}
+
+	field.Set(0, 1, math.NaN(), math.NaN())
+	field.Set(1, 1, math.Inf(1), 0)
+	if err := field.checkFinite(); !errors.Is(err, ErrNonFiniteField) {
+		t.Errorf("Expected ErrNonFiniteField for a field without finite data, got %v", err)
+	}
+}
+
+func TestForwardTransformSkipsMaskedSources(t *testing.T) {
+	const size = 64
+	dir := t.TempDir()
+	initial, current := uniformPointMats(size, 8, 0, 6)
+	defer initial.Close()
+	defer current.Close()
+
+	field, err := InterpolateFlowField(initial, current, size, size, 1, stripeMask(size))
+	if err != nil {
+		t.Fatalf("InterpolateFlowField failed: %v", err)
+	}
+	flowPath := filepath.Join(dir, "flow.png")
+	writePNG(t, flowPath, field.Image())
+
+	// The input is grey everywhere except for a red stripe under the mask.
+	red := color.RGBA{R: 255, A: 255}
+	input := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if y >= stripeTop && y < stripeBottom {
+				input.Set(x, y, red)
+			} else {
+				input.Set(x, y, color.RGBA{R: 90, G: 90, B: 90, A: 255})
+			}
+		}
+	}
+	inputPath := filepath.Join(dir, "input.png")
+	writePNG(t, inputPath, input)
+
+	out, err := ForwardTransform(inputPath, flowPath, 1.0)
+	if err != nil {
+		t.Fatalf("ForwardTransform failed: %v", err)
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			r, g, b, a := out.At(x, y).RGBA()
+			if a != 0 && r>>8 == 255 && g == 0 && b == 0 {
+				t.Fatalf("Output pixel (%d, %d) was sourced from inside the masked stripe", x, y)
+			}
+			// Rows whose source (y - 6) falls in the stripe must be transparent.
+			if srcY := y - 6; srcY >= stripeTop && srcY < stripeBottom && a != 0 {
+				t.Fatalf("Expected transparent output at (%d, %d), got alpha %d", x, y, a>>8)
+			}
+		}
+	}
+}
+
+func writePNG(t testing.TB, path string, img image.Image) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readPNG(t testing.TB, path string) image.Image {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// randomPointMats returns n features scattered over, and a little beyond, a
+// size x size field with random displacements of up to 8 pixels.
+func randomPointMats(n, size int, seed int64) (gocv.Mat, gocv.Mat) {
+	rng := rand.New(rand.NewSource(seed))
+	initial := gocv.NewMatWithSize(n, 2, gocv.MatTypeCV32F)
+	current := gocv.NewMatWithSize(n, 2, gocv.MatTypeCV32F)
+	for i := 0; i < n; i++ {
+		x := float32(rng.Float64()*float64(size+40) - 20)
+		y := float32(rng.Float64()*float64(size+40) - 20)
+		initial.SetFloatAt(i, 0, x)
+		initial.SetFloatAt(i, 1, y)
+		current.SetFloatAt(i, 0, x+float32(rng.Float64()*16-8))
+		current.SetFloatAt(i, 1, y+float32(rng.Float64()*16-8))
+	}
+	return initial, current
+}
+
+// bruteForceFlowField interpolates like InterpolateFlowFieldWithOptions
+// without a mask at resolution factor 1, but visits every sparse point for
+// every pixel.
+func bruteForceFlowField(initial, current gocv.Mat, width, height int, opts InterpolationOptions) (*FlowField, [][]float64) {
+	field := NewFlowField(width, height)
+	confidence := make([][]float64, height)
+	disps := make(map[image.Point]gocv.Point2f)
+	dirs := make(map[image.Point]gocv.Point2f)
+	for i := 0; i < initial.Rows(); i++ {
+		p0x, p0y := initial.GetFloatAt(i, 0), initial.GetFloatAt(i, 1)
+		dx, dy := current.GetFloatAt(i, 0)-p0x, current.GetFloatAt(i, 1)-p0y
+		pt := image.Pt(int(p0x), int(p0y))
+		disps[pt] = gocv.Point2f{X: dx, Y: dy}
+		delete(dirs, pt)
+		if n := math.Hypot(float64(dx), float64(dy)); opts.Anisotropic && n > 0 {
+			dirs[pt] = gocv.Point2f{X: dx / float32(n), Y: dy / float32(n)}
+		}
+	}
+	var points []image.Point
+	for pt := range disps {
+		points = append(points, pt)
+	}
+	sortPointsYX(points)
+	stretch := math.Sqrt(opts.aspectRatio())
+	radius, power := opts.radius(), opts.power()
+	for y := 0; y < height; y++ {
+		confidence[y] = make([]float64, width)
+		for x := 0; x < width; x++ {
+			if disp, ok := disps[image.Pt(x, y)]; ok {
+				field.Set(x, y, float64(disp.X), float64(disp.Y))
+				confidence[y][x] = 1
+				continue
+			}
+			var totalX, totalY, totalWeight float64
+			for _, pt := range points {
+				dx, dy := float64(x-pt.X), float64(y-pt.Y)
+				if dir := dirs[pt]; dir != (gocv.Point2f{}) {
+					ux, uy := float64(dir.X), float64(dir.Y)
+					dx, dy = (dx*ux+dy*uy)/stretch, (dy*ux-dx*uy)*stretch
+				}
+				d2 := dx*dx + dy*dy
+				if d2 > radius*radius {
+					continue
+				}
+				weight := 1 / math.Max(d2, 1)
+				if power != 2 {
+					weight = math.Pow(math.Max(d2, 1), -power/2)
+				}
+				totalX += float64(disps[pt].X) * weight
+				totalY += float64(disps[pt].Y) * weight
+				totalWeight += weight
+			}
+			if totalWeight > 0 {
+				field.Set(x, y, totalX/totalWeight, totalY/totalWeight)
+				confidence[y][x] = math.Min(1, totalWeight)
+			}
+		}
+	}
+	return field, confidence
+}
+
+// TestInterpolateFlowFieldMatchesBruteForce checks that the spatially
+// indexed interpolation gives exactly the field and confidence of visiting
+// every sparse point for every pixel.
+func TestInterpolateFlowFieldMatchesBruteForce(t *testing.T) {
+	const size = 200
+	initial, current := randomPointMats(150, size, 1)
+	defer initial.Close()
+	defer current.Close()
+	for _, opts := range []InterpolationOptions{{}, {Anisotropic: true}, {Anisotropic: true, AspectRatio: 0.25}, {Radius: 23, Power: 3}, {Radius: -1, Power: 1.5}} {
+		field, confidence, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, opts)
+		if err != nil {
+			t.Fatalf("InterpolateFlowFieldWithOptions(%+v) failed: %v", opts, err)
+		}
+		want, wantConfidence := bruteForceFlowField(initial, current, size, size, opts)
+		if !reflect.DeepEqual(field.DX, want.DX) || !reflect.DeepEqual(field.DY, want.DY) {
+			t.Errorf("%+v: indexed field differs from the brute force one", opts)
+		}
+		if !reflect.DeepEqual(confidence, wantConfidence) {
+			t.Errorf("%+v: indexed confidence differs from the brute force one", opts)
+		}
+	}
+}
+
+// sparsePointMats returns four features far apart in a size x size field,
+// each displaced by its own (dx, dy).
+func sparsePointMats(size int) (gocv.Mat, gocv.Mat, [][2]float32) {
+	pts := []image.Point{{20, 20}, {size - 20, 20}, {20, size - 20}, {size - 20, size - 20}}
+	disps := [][2]float32{{4, 0}, {0, 4}, {-4, 0}, {0, -4}}
+	initial := gocv.NewMatWithSize(len(pts), 2, gocv.MatTypeCV32F)
+	current := gocv.NewMatWithSize(len(pts), 2, gocv.MatTypeCV32F)
+	for i, pt := range pts {
+		initial.SetFloatAt(i, 0, float32(pt.X))
+		initial.SetFloatAt(i, 1, float32(pt.Y))
+		current.SetFloatAt(i, 0, float32(pt.X)+disps[i][0])
+		current.SetFloatAt(i, 1, float32(pt.Y)+disps[i][1])
+	}
+	return initial, current, disps
+}
+
+// gaps counts the pixels of field without flow and, if confidence is not
+// nil, without confidence.
+func gaps(field *FlowField, confidence [][]float64) int {
+	n := 0
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			if dx, dy, _ := field.At(x, y); dx == 0 && dy == 0 && (confidence == nil || confidence[y][x] == 0) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// TestInterpolateFlowFieldRadius checks that the default radius, given as
+// zero or as DefaultIDWRadius, leaves pixels far from four sparse features
+// without flow, and that a wider radius, an unlimited (negative) one, or
+// the nearest-feature fallback fills them.
+func TestInterpolateFlowFieldRadius(t *testing.T) {
+	const size = 200
+	initial, current, disps := sparsePointMats(size)
+	defer initial.Close()
+	defer current.Close()
+	for _, radius := range []float64{0, DefaultIDWRadius} {
+		field, confidence, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{Radius: radius})
+		if err != nil {
+			t.Fatalf("InterpolateFlowFieldWithOptions(radius %v) failed: %v", radius, err)
+		}
+		if n := gaps(field, confidence); n < size*size/2 {
+			t.Fatalf("Expected over half the pixels without flow at radius %v, got %d", radius, n)
+		}
+	}
+	for _, radius := range []float64{150, -1} {
+		field, confidence, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{Radius: radius})
+		if err != nil {
+			t.Fatalf("InterpolateFlowFieldWithOptions(radius %v) failed: %v", radius, err)
+		}
+		if n := gaps(field, confidence); n != 0 {
+			t.Errorf("Expected no pixel without flow at radius %v, got %d", radius, n)
+		}
+	}
+
+	// The fallback takes the displacement of the nearest feature, here the
+	// top left one, without adding confidence.
+	field, confidence, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{NearestFallback: true})
+	if err != nil {
+		t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+	}
+	if dx, dy, _ := field.At(90, 80); dx != float64(disps[0][0]) || dy != float64(disps[0][1]) || confidence[80][90] != 0 {
+		t.Errorf("Expected the nearest feature's (%v, %v) at zero confidence at (90, 80), got (%v, %v) at %v", disps[0][0], disps[0][1], dx, dy, confidence[80][90])
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if dx, dy, _ := field.At(x, y); dx == 0 && dy == 0 {
+				t.Fatalf("Expected the fallback to leave no pixel without flow, got one at (%d, %d)", x, y)
+			}
+		}
+	}
+
+	// A higher power lets the nearer feature dominate.
+	soft, _, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{Radius: -1, Power: 1})
+	if err != nil {
+		t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+	}
+	sharp, _, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{Radius: -1, Power: 4})
+	if err != nil {
+		t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+	}
+	softX, _, _ := soft.At(60, 40)
+	sharpX, _, _ := sharp.At(60, 40)
+	if !(sharpX > softX) {
+		t.Errorf("Expected power 4 to follow the nearest feature's dx more closely than power 1, got %.3f and %.3f", sharpX, softX)
+	}
+}
+
+// TestSparseGridNearest checks the ring search of sparseGrid.nearest against
+// a scan of every point, from pixels inside and far outside the points.
+func TestSparseGridNearest(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	points := make([]image.Point, 30)
+	for i := range points {
+		points[i] = image.Pt(rng.Intn(400), rng.Intn(300))
+	}
+	grid := newSparseGrid(points, 20, 1)
+	for trial := 0; trial < 500; trial++ {
+		pt := image.Pt(rng.Intn(1200)-400, rng.Intn(900)-300)
+		want, wantD := -1, math.MaxInt
+		for i, p := range points {
+			if d := (p.X-pt.X)*(p.X-pt.X) + (p.Y-pt.Y)*(p.Y-pt.Y); d < wantD {
+				want, wantD = i, d
+			}
+		}
+		if got, ok := grid.nearest(pt, points); !ok || got != want {
+			t.Fatalf("nearest(%v) = %d, %v, want %d", pt, got, ok, want)
+		}
+	}
+	if _, ok := grid.nearest(image.Pt(0, 0), nil); ok {
+		t.Error("Expected no nearest point among none")
+	}
+}
+
+// BenchmarkInterpolateFlowField compares the indexed interpolation of a few
+// hundred features over a 1024x1024 field with visiting every feature for
+// every pixel.
+func BenchmarkInterpolateFlowField(b *testing.B) {
+	const size = 1024
+	initial, current := randomPointMats(300, size, 1)
+	defer initial.Close()
+	defer current.Close()
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("brute_force", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bruteForceFlowField(initial, current, size, size, InterpolationOptions{})
+		}
+	})
+}
+
+// TestInterpolateFlowFieldWorkers checks that the field does not depend on
+// the number of workers interpolating it.
+func TestInterpolateFlowFieldWorkers(t *testing.T) {
+	const size = 300
+	initial, current := randomPointMats(200, size, 2)
+	defer initial.Close()
+	defer current.Close()
+	serial, serialConfidence, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, stripeMask(size), InterpolationOptions{Workers: 1})
+	if err != nil {
+		t.Fatalf("InterpolateFlowFieldWithOptions failed: %v", err)
+	}
+	for _, workers := range []int{0, 3, 8} {
+		field, confidence, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, stripeMask(size), InterpolationOptions{Workers: workers})
+		if err != nil {
+			t.Fatalf("InterpolateFlowFieldWithOptions(%d workers) failed: %v", workers, err)
+		}
+		if !reflect.DeepEqual(field, serial) || !reflect.DeepEqual(confidence, serialConfidence) {
+			t.Errorf("Expected %d workers to reproduce the serial field", workers)
+		}
+		if !bytes.Equal(field.Image().Pix, serial.Image().Pix) {
+			t.Errorf("Expected %d workers to encode the same image as the serial field", workers)
+		}
+	}
+}
+
+// BenchmarkInterpolateFlowFieldWorkers interpolates a few hundred features
+// over a 1024x1024 field on 1, 4 and 8 workers.
+func BenchmarkInterpolateFlowFieldWorkers(b *testing.B) {
+	const size = 1024
+	initial, current := randomPointMats(300, size, 1)
+	defer initial.Close()
+	defer current.Close()
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers_%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := InterpolateFlowFieldWithOptions(initial, current, size, size, 1, nil, InterpolationOptions{Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
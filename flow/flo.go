@@ -0,0 +1,117 @@
+package flow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"example/goflow/fileutil"
+)
+
+// ErrFloFormat is returned for a file that is not a Middlebury .flo flow
+// field.
+var ErrFloFormat = errors.New("flow: not a Middlebury .flo file")
+
+const (
+	// floMagic starts every .flo file: "PIEH", the float32 202021.25.
+	floMagic = "PIEH"
+	// floUnknown is the value a .flo file stores for flow that is not
+	// known, and floUnknownThreshold the magnitude above which a value
+	// counts as unknown, as in the Middlebury flow-code.
+	floUnknown          = 1e10
+	floUnknownThreshold = 1e9
+)
+
+// EncodeFlo writes field to w in the Middlebury .flo format: the magic
+// "PIEH", the width and height as little-endian int32, then interleaved
+// float32 (u, v) pairs row by row. This is the format PyTorch and OpenCV
+// optical flow baselines read and write. The displacements are written per
+// frame interval in full-resolution pixels, as EncodeNpy writes them, and
+// no-data pixels get the format's unknown-flow value.
+func EncodeFlo(w io.Writer, field *FlowField) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(floMagic)
+	binary.Write(bw, binary.LittleEndian, [2]int32{int32(field.Width), int32(field.Height)})
+	var buf [8]byte
+	perFrame := field.perFrame()
+	for i := range field.Valid {
+		u, v := float32(field.DX[i]*perFrame), float32(field.DY[i]*perFrame)
+		if !field.Valid[i] {
+			u, v = floUnknown, floUnknown
+		}
+		binary.LittleEndian.PutUint32(buf[:4], math.Float32bits(u))
+		binary.LittleEndian.PutUint32(buf[4:], math.Float32bits(v))
+		bw.Write(buf[:])
+	}
+	return bw.Flush()
+}
+
+// DecodeFlo reads a .flo flow field. Pixels with unknown or NaN flow are
+// no data. As with DecodeNpy, the field's ResolutionFactor and Intervals
+// are 1, and ReadFlo restores the resolution factor from a sidecar.
+func DecodeFlo(r io.Reader) (*FlowField, error) {
+	br := bufio.NewReader(r)
+	var header struct {
+		Magic         [4]byte
+		Width, Height int32
+	}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFloFormat, err)
+	}
+	if string(header.Magic[:]) != floMagic {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrFloFormat, header.Magic[:])
+	}
+	if header.Width < 0 || header.Height < 0 {
+		return nil, fmt.Errorf("%w: size %dx%d", ErrFloFormat, header.Width, header.Height)
+	}
+	if int64(header.Width)*int64(header.Height) > MaxDecodedPixels {
+		return nil, fmt.Errorf("%w: %dx%d field, more than the %d pixels allowed", ErrFloFormat, header.Width, header.Height, MaxDecodedPixels)
+	}
+
+	field := NewFlowField(int(header.Width), int(header.Height))
+	field.ResolutionFactor, field.Intervals = 1, 1
+	var buf [8]byte
+	for i := range field.Valid {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFloFormat, err)
+		}
+		u := float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[:4])))
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4:])))
+		if math.IsNaN(u) || math.IsNaN(v) || math.Abs(u) > floUnknownThreshold || math.Abs(v) > floUnknownThreshold {
+			field.SetNoData(i%field.Width, i/field.Width)
+			continue
+		}
+		field.DX[i], field.DY[i] = u, v
+	}
+	return field, nil
+}
+
+// WriteFlo writes field to the file at path with EncodeFlo, replacing it
+// atomically; see fileutil.WriteAtomic.
+func WriteFlo(path string, field *FlowField) error {
+	return fileutil.WriteAtomic(path, func(w io.Writer) error {
+		return EncodeFlo(w, field)
+	}, true)
+}
+
+// ReadFlo reads the field in the .flo file at path with DecodeFlo, with the
+// resolution factor recorded in its provenance sidecar, if it has one.
+func ReadFlo(path string) (*FlowField, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	field, err := DecodeFlo(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := restoreResolution(path, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
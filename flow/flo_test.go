@@ -0,0 +1,126 @@
+package flow
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tinyFlo is the field of testdata/tiny.flo, a hand-built 3x2 .flo file
+// whose top right pixel is unknown.
+func tinyFlo() *FlowField {
+	field := NewFlowField(3, 2)
+	for i, d := range [][2]float64{{1, -0.5}, {2.25, 0}, {0, 0}, {-3, 4}, {0.125, -8}, {100, -100}} {
+		field.Set(i%3, i/3, d[0], d[1])
+	}
+	field.SetNoData(2, 0)
+	return field
+}
+
+func TestFloGolden(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("testdata", "tiny.flo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := tinyFlo()
+
+	field, err := DecodeFlo(bytes.NewReader(golden))
+	if err != nil {
+		t.Fatalf("DecodeFlo failed: %v", err)
+	}
+	if field.Width != want.Width || field.Height != want.Height {
+		t.Fatalf("Expected a %dx%d field, got %dx%d", want.Width, want.Height, field.Width, field.Height)
+	}
+	for y := 0; y < want.Height; y++ {
+		for x := 0; x < want.Width; x++ {
+			wantX, wantY, wantValid := want.At(x, y)
+			dx, dy, valid := field.At(x, y)
+			if dx != wantX || dy != wantY || valid != wantValid {
+				t.Errorf("Pixel (%d, %d): got (%v, %v, %v), want (%v, %v, %v)", x, y, dx, dy, valid, wantX, wantY, wantValid)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeFlo(&buf, want); err != nil {
+		t.Fatalf("EncodeFlo failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Errorf("EncodeFlo differs from the golden file:\n got %x\nwant %x", buf.Bytes(), golden)
+	}
+}
+
+// TestFloRoundTrip checks WriteFlo and ReadFlo, and the conversion of a
+// .flo field to a flow map and back.
+func TestFloRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flow.flo")
+	if err := WriteFlo(path, tinyFlo()); err != nil {
+		t.Fatalf("WriteFlo failed: %v", err)
+	}
+	field, err := ReadFlo(path)
+	if err != nil {
+		t.Fatalf("ReadFlo failed: %v", err)
+	}
+
+	// The map rounds to the nearest step of 0.01 pixels.
+	enc := Encoding{Depth: 16}
+	decoded := DecodeFlowMap(field.ImageWithEncoding(enc), enc)
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			wantX, wantY, wantValid := field.At(x, y)
+			dx, dy, valid := decoded.At(x, y)
+			if valid != wantValid || math.Abs(dx-wantX) > 0.0051 || math.Abs(dy-wantY) > 0.0051 {
+				t.Errorf("Pixel (%d, %d) through a 16-bit flow map: got (%v, %v, %v), want (%v, %v, %v)", x, y, dx, dy, valid, wantX, wantY, wantValid)
+			}
+		}
+	}
+
+	if _, err := DecodeFlo(bytes.NewReader([]byte("PIEF\x01\x00\x00\x00\x01\x00\x00\x00"))); !errors.Is(err, ErrFloFormat) {
+		t.Errorf("Expected ErrFloFormat for a bad magic, got %v", err)
+	}
+	if _, err := DecodeFlo(bytes.NewReader([]byte("PIEH\x02\x00\x00\x00\x01\x00\x00\x00\x00\x00"))); !errors.Is(err, ErrFloFormat) {
+		t.Errorf("Expected ErrFloFormat for truncated data, got %v", err)
+	}
+	if _, err := DecodeFlo(bytes.NewReader([]byte("PIEH\xff\xff\xff\x7f\xff\xff\xff\x7f"))); !errors.Is(err, ErrFloFormat) {
+		t.Errorf("Expected ErrFloFormat for a field too large to allocate, got %v", err)
+	}
+}
+
+// TestFloUnits checks that EncodeFlo writes the displacements per frame
+// interval in full-resolution pixels, and that ReadFlo restores the
+// resolution factor from a provenance sidecar.
+func TestFloUnits(t *testing.T) {
+	field := NewFlowField(1, 1)
+	field.ResolutionFactor, field.Intervals = 4, 2
+	field.Set(0, 0, 3, -1)
+
+	var buf bytes.Buffer
+	if err := EncodeFlo(&buf, field); err != nil {
+		t.Fatalf("EncodeFlo failed: %v", err)
+	}
+	decoded, err := DecodeFlo(&buf)
+	if err != nil {
+		t.Fatalf("DecodeFlo failed: %v", err)
+	}
+	if dx, dy, _ := decoded.At(0, 0); dx != 6 || dy != -2 || decoded.ResolutionFactor != 1 || decoded.Intervals != 1 {
+		t.Errorf("Expected (6, -2) at factor 1 over 1 interval, got (%v, %v) at factor %d over %d", dx, dy, decoded.ResolutionFactor, decoded.Intervals)
+	}
+
+	path := filepath.Join(t.TempDir(), "flow.flo")
+	if err := WriteFlo(path, field); err != nil {
+		t.Fatalf("WriteFlo failed: %v", err)
+	}
+	if err := os.WriteFile(SidecarPath(path), []byte(`{"operation": "test", "resolution_factor": 4}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFlo(path)
+	if err != nil {
+		t.Fatalf("ReadFlo failed: %v", err)
+	}
+	if dx, dy, _ := got.At(0, 0); dx != 1.5 || dy != -0.5 || got.ResolutionFactor != 4 {
+		t.Errorf("Expected (1.5, -0.5) field pixels per frame at factor 4, got (%v, %v) at factor %d", dx, dy, got.ResolutionFactor)
+	}
+}
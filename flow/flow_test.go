@@ -1,6 +1,7 @@
 package flow
 
 import (
+	"example/goflow/flow/synth"
 	"fmt"
 	"image"
 	"image/png"
@@ -15,22 +16,16 @@ import (
 // It only considers pixels that represent non-zero flow to avoid the background skewing the result.
 func calculateAverageFlow(t *testing.T, img image.Image) (float64, float64) {
 	t.Helper()
-	bounds := img.Bounds()
+	field := DecodeFlowMap(img, Encoding{})
 	var totalDx, totalDy float64
 	numPixels := 0
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, _, _ := img.At(x, y).RGBA()
-			r8 := uint8(r >> 8)
-			g8 := uint8(g >> 8)
-
-			// Decode the flow vector from the pixel color
-			dx := (float64(r8) - FlowMidLevel) / FlowScaleFactor
-			dy := (float64(g8) - FlowMidLevel) / FlowScaleFactor
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			dx, dy, valid := field.At(x, y)
 
 			// Only include pixels with significant flow in the average
-			if math.Abs(dx) > 0.1 || math.Abs(dy) > 0.1 {
+			if valid && (math.Abs(dx) > 0.1 || math.Abs(dy) > 0.1) {
 				totalDx += dx
 				totalDy += dy
 				numPixels++
@@ -109,6 +104,61 @@ func TestShiftedFlow(t *testing.T) {
 	}
 }
 
+// TestSubPixelShift checks that a shift of half a pixel survives into the
+// flow map instead of being truncated to zero.
+func TestSubPixelShift(t *testing.T) {
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 0.5}}}, 2, 256, 256)
+	flowMap, err := GenerateAverageFlowMapFromImages(frames, 1)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImages failed: %v", err)
+	}
+	avgDx, avgDy := calculateAverageFlow(t, flowMap)
+	if math.Abs(avgDx-0.5) > 0.15 || math.Abs(avgDy) > 0.15 {
+		t.Errorf("Expected average flow close to (0.5, 0), got (%f, %f)", avgDx, avgDy)
+	}
+}
+
+// TestSparseFlowSpansSequence checks that the sparse flow of a sequence of
+// more than two frames measures the displacement over every interval, from
+// each feature's position in the first frame, as FlowField.Intervals says.
+func TestSparseFlowSpansSequence(t *testing.T) {
+	const resolutionFactor = 4
+	acc := NewAccumulator(FlowOptions{})
+	defer acc.Close()
+	for _, frame := range translatingFrames(4, 4, 8) {
+		if err := acc.AddImage(frame, "frame"); err != nil {
+			t.Fatalf("AddImage failed: %v", err)
+		}
+	}
+	field, err := acc.FlowField(resolutionFactor)
+	if err != nil {
+		t.Fatalf("FlowField failed: %v", err)
+	}
+	if field.Intervals != 3 {
+		t.Fatalf("Expected the field to span 3 intervals, got %d", field.Intervals)
+	}
+
+	var sumX, sumY float64
+	var n int
+	for y := 20; y < field.Height-20; y++ {
+		for x := 20; x < field.Width-20; x++ {
+			if dx, dy, valid := field.At(x, y); valid {
+				sumX += dx
+				sumY += dy
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatal("Expected flow inside the frame")
+	}
+	wantX, wantY := 12.0/resolutionFactor, 24.0/resolutionFactor
+	gotX, gotY := sumX/float64(n), sumY/float64(n)
+	if math.Abs(gotX-wantX) > 0.25 || math.Abs(gotY-wantY) > 0.25 {
+		t.Errorf("Expected mean flow close to (%.2f, %.2f) over the 3 intervals, got (%.2f, %.2f)", wantX, wantY, gotX, gotY)
+	}
+}
+
 // TestForwardFlow checks that applying a calculated flow map in forward can reconstruct the original image.
 func TestForwardFlow(t *testing.T) {
 	imageAPath := "../test_data/centered.png"
@@ -192,6 +242,27 @@ func TestSymmetricFlow(t *testing.T) {
 	}
 }
 
+// TestFlowFromImagesMatchesPaths checks that decoded frames give the same
+// flow map as the files they were decoded from.
+func TestFlowFromImagesMatchesPaths(t *testing.T) {
+	imagePaths := []string{"../test_data/centered.png", "../test_data/shifted.png"}
+	imgs := []image.Image{readPNG(t, imagePaths[0]), readPNG(t, imagePaths[1])}
+
+	want, err := GenerateAverageFlowMap(imagePaths, 4)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMap failed: %v", err)
+	}
+	got, err := GenerateAverageFlowMapFromImages(imgs, 4)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImages failed: %v", err)
+	}
+	compareImages(t, got, want, 0)
+
+	if _, err := GenerateAverageFlowMapFromImages(imgs[:1], 4); err == nil {
+		t.Error("Expected an error for a single image")
+	}
+}
+
 // --- Test Helpers ---
 
 // resizeImage loads an image and resizes it using gocv.
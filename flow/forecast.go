@@ -0,0 +1,249 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ForecastScheme selects how GenerateForecast carries a frame forward over
+// several lead times.
+type ForecastScheme int
+
+const (
+	// ForecastIterative forward-warps the previous forecast by the field once
+	// per step, moving each pixel to its nearest destination. Destinations
+	// no pixel reaches are left as holes and those several reach keep one of
+	// them, so both accumulate with every step.
+	ForecastIterative ForecastScheme = iota
+	// ForecastComposed composes the field with itself, using Compose, into
+	// the displacement over the whole lead time, then warps the last frame
+	// once per lead time by backward mapping with bilinear interpolation.
+	// Every output pixel pulls a value, so no holes open inside the image.
+	ForecastComposed
+)
+
+// ForecastOptions configures GenerateForecast. The zero value uses
+// ForecastIterative.
+type ForecastOptions struct {
+	Scheme ForecastScheme
+}
+
+// GenerateForecast extrapolates last by field for lead times of 1 to steps
+// frame intervals and returns one forecast per lead time. The field is
+// resampled to the size of last, so it may have any resolution factor, and
+// its displacements are divided by its Intervals to get the motion over one
+// interval. Forecast pixels without a source, and those whose motion is
+// unknown, have zero alpha.
+func GenerateForecast(last image.Image, field *FlowField, steps int, opts ForecastOptions) ([]*image.NRGBA, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("forecast steps must be at least 1, got %d", steps)
+	}
+	if field == nil || field.Width == 0 || field.Height == 0 {
+		return nil, errors.New("forecast needs a non-empty flow field")
+	}
+	bounds := last.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New("forecast needs a non-empty frame")
+	}
+	frame := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(frame, frame.Bounds(), last, bounds.Min, draw.Src)
+	step := field.perStep(bounds.Dx(), bounds.Dy())
+
+	forecasts := make([]*image.NRGBA, 0, steps)
+	switch opts.Scheme {
+	case ForecastIterative:
+		current := frame
+		for i := 0; i < steps; i++ {
+			current = forwardWarp(current, step)
+			forecasts = append(forecasts, current)
+		}
+	case ForecastComposed:
+		back := step.scaled(-1)
+		total := back
+		for i := 0; i < steps; i++ {
+			if i > 0 {
+				var err error
+				if total, err = Compose(total, back); err != nil {
+					return nil, err
+				}
+			}
+			forecasts = append(forecasts, backwardWarp(frame, total))
+		}
+	default:
+		return nil, fmt.Errorf("unknown forecast scheme %d", opts.Scheme)
+	}
+	return forecasts, nil
+}
+
+// Compose returns the field that displaces each pixel p by a and then by b
+// from where a left it: a(p) + b(p + a(p)), with b interpolated bilinearly.
+// A pixel holds no data if a does there or if p + a(p) falls outside b or
+// has no data around it. The fields must have the same size; the result
+// spans the intervals of both, in the units of a.
+func Compose(a, b *FlowField) (*FlowField, error) {
+	if a.Width != b.Width || a.Height != b.Height {
+		return nil, fmt.Errorf("cannot compose a %dx%d flow field with a %dx%d one", a.Width, a.Height, b.Width, b.Height)
+	}
+	full := a.FullResolution
+	a, b = a.fieldPixels(), b.fieldPixels()
+	rf, ia := a.scale()
+	_, ib := b.scale()
+	out := NewFlowField(a.Width, a.Height)
+	out.ResolutionFactor, out.Intervals, out.Units = rf, ia+ib, a.Units
+	for y := 0; y < a.Height; y++ {
+		for x := 0; x < a.Width; x++ {
+			dx, dy, valid := a.At(x, y)
+			if !valid {
+				out.SetNoData(x, y)
+				continue
+			}
+			bx, by, ok := b.sample(float64(x)+dx, float64(y)+dy)
+			if !ok {
+				out.SetNoData(x, y)
+				continue
+			}
+			out.Set(x, y, dx+bx, dy+by)
+		}
+	}
+	if full {
+		out.toFullResolution()
+	}
+	return out, nil
+}
+
+// sample interpolates the displacement at (x, y), in pixels of f,
+// bilinearly between the valid pixels around it. ok is false outside the
+// field or if none of those pixels holds data.
+func (f *FlowField) sample(x, y float64) (dx, dy float64, ok bool) {
+	if x < -0.5 || y < -0.5 || x >= float64(f.Width)-0.5 || y >= float64(f.Height)-0.5 {
+		return 0, 0, false
+	}
+	var weights float64
+	bilinearSample(f.Width, f.Height, x, y, func(px, py int, w float64) {
+		pdx, pdy, valid := f.At(px, py)
+		if !valid {
+			return
+		}
+		dx += w * pdx
+		dy += w * pdy
+		weights += w
+	})
+	if weights == 0 {
+		return 0, 0, false
+	}
+	return dx / weights, dy / weights, true
+}
+
+// bilinearSample calls visit with each of the up to four pixels of a
+// width x height grid around (x, y) and its bilinear weight. Positions
+// beyond the outer pixel centres are clamped to them.
+func bilinearSample(width, height int, x, y float64, visit func(px, py int, w float64)) {
+	x = math.Max(0, math.Min(x, float64(width-1)))
+	y = math.Max(0, math.Min(y, float64(height-1)))
+	x0, y0 := int(x), int(y)
+	fx, fy := x-float64(x0), y-float64(y0)
+	for j, wy := range [2]float64{1 - fy, fy} {
+		for i, wx := range [2]float64{1 - fx, fx} {
+			if w := wx * wy; w > 0 {
+				visit(min(x0+i, width-1), min(y0+j, height-1), w)
+			}
+		}
+	}
+}
+
+// perStep returns f resampled to width x height pixels, with its
+// displacements scaled to those pixels and to one frame interval.
+func (f *FlowField) perStep(width, height int) *FlowField {
+	f = f.fieldPixels()
+	_, intervals := f.scale()
+	sx, sy := float64(f.Width)/float64(width), float64(f.Height)/float64(height)
+	out := NewFlowField(width, height)
+	out.Units = f.Units
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy, ok := f.sample((float64(x)+0.5)*sx-0.5, (float64(y)+0.5)*sy-0.5)
+			if !ok {
+				out.SetNoData(x, y)
+				continue
+			}
+			out.Set(x, y, dx/(sx*float64(intervals)), dy/(sy*float64(intervals)))
+		}
+	}
+	return out
+}
+
+// scaled returns a copy of f with its displacements multiplied by factor.
+func (f *FlowField) scaled(factor float64) *FlowField {
+	out := *f
+	out.DX = make([]float64, len(f.DX))
+	out.DY = make([]float64, len(f.DY))
+	out.Valid = append([]bool(nil), f.Valid...)
+	for i := range f.DX {
+		out.DX[i], out.DY[i] = f.DX[i]*factor, f.DY[i]*factor
+	}
+	return &out
+}
+
+// forwardWarp moves every opaque pixel of img to the nearest pixel of its
+// destination under field, which has the size of img. Pixels without
+// motion, or moving out of the image, are dropped.
+func forwardWarp(img *image.NRGBA, field *FlowField) *image.NRGBA {
+	out := image.NewNRGBA(img.Bounds())
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			c := img.NRGBAAt(x, y)
+			dx, dy, valid := field.At(x, y)
+			if c.A == 0 || !valid {
+				continue
+			}
+			tx, ty := int(math.Round(float64(x)+dx)), int(math.Round(float64(y)+dy))
+			if tx < 0 || ty < 0 || tx >= field.Width || ty >= field.Height {
+				continue
+			}
+			out.SetNRGBA(tx, ty, c)
+		}
+	}
+	return out
+}
+
+// backwardWarp returns the image whose pixel q is img interpolated
+// bilinearly at q + field(q). Pixels whose displacement is unknown or whose
+// source lies outside img are transparent.
+func backwardWarp(img *image.NRGBA, field *FlowField) *image.NRGBA {
+	out := image.NewNRGBA(img.Bounds())
+	width, height := field.Width, field.Height
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy, valid := field.At(x, y)
+			sx, sy := float64(x)+dx, float64(y)+dy
+			if !valid || sx < -0.5 || sy < -0.5 || sx >= float64(width)-0.5 || sy >= float64(height)-0.5 {
+				continue
+			}
+			// Interpolate premultiplied values so transparent neighbours do
+			// not darken the edges.
+			var r, g, b, a float64
+			bilinearSample(width, height, sx, sy, func(px, py int, w float64) {
+				c := img.NRGBAAt(px, py)
+				wa := w * float64(c.A)
+				r += wa * float64(c.R)
+				g += wa * float64(c.G)
+				b += wa * float64(c.B)
+				a += wa
+			})
+			if a == 0 {
+				continue
+			}
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(math.Round(r / a)),
+				G: uint8(math.Round(g / a)),
+				B: uint8(math.Round(b / a)),
+				A: uint8(math.Round(a)),
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// texturedBlob returns a size x size image holding a textured disc of the
+// given radius around centre, pixel (x, y) showing the scene at at(x, y),
+// and transparent elsewhere.
+func texturedBlob(size int, centre, radius float64, at func(x, y float64) (float64, float64)) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			sx, sy := at(float64(x), float64(y))
+			if math.Hypot(sx-centre, sy-centre) > radius {
+				continue
+			}
+			v := 128 + 100*math.Sin(sx/3)*math.Cos(sy/4)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(v), G: uint8(255 - v), B: 50, A: 255})
+		}
+	}
+	return img
+}
+
+// forecastErrors counts the pixels opaque in want but transparent in got,
+// and returns the mean squared red error over the opaque pixels of want,
+// with holes counting as black.
+func forecastErrors(got, want *image.NRGBA) (holes int, mse float64) {
+	var n int
+	for i := 0; i < len(want.Pix); i += 4 {
+		if want.Pix[i+3] == 0 {
+			continue
+		}
+		n++
+		r := 0.0
+		if got.Pix[i+3] == 0 {
+			holes++
+		} else {
+			r = float64(got.Pix[i])
+		}
+		d := r - float64(want.Pix[i])
+		mse += d * d
+	}
+	return holes, mse / float64(n)
+}
+
+func TestGenerateForecastComposedHasFewerHoles(t *testing.T) {
+	const size, steps = 96, 5
+	const centre, radius, growth = 47.5, 14.0, 0.08
+
+	// The blob expands from the centre by growth per step.
+	field := NewFlowField(size, size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			field.Set(x, y, growth*(float64(x)-centre), growth*(float64(y)-centre))
+		}
+	}
+	last := texturedBlob(size, centre, radius, func(x, y float64) (float64, float64) { return x, y })
+	scale := math.Pow(1+growth, steps)
+	want := texturedBlob(size, centre, radius, func(x, y float64) (float64, float64) {
+		return centre + (x-centre)/scale, centre + (y-centre)/scale
+	})
+
+	iterative, err := GenerateForecast(last, field, steps, ForecastOptions{Scheme: ForecastIterative})
+	if err != nil {
+		t.Fatalf("Iterative forecast failed: %v", err)
+	}
+	composed, err := GenerateForecast(last, field, steps, ForecastOptions{Scheme: ForecastComposed})
+	if err != nil {
+		t.Fatalf("Composed forecast failed: %v", err)
+	}
+	if len(iterative) != steps || len(composed) != steps {
+		t.Fatalf("Expected %d forecasts, got %d and %d", steps, len(iterative), len(composed))
+	}
+
+	iterHoles, iterMSE := forecastErrors(iterative[steps-1], want)
+	compHoles, compMSE := forecastErrors(composed[steps-1], want)
+	t.Logf("after %d steps: iterative %d holes, MSE %.1f; composed %d holes, MSE %.1f", steps, iterHoles, iterMSE, compHoles, compMSE)
+	if compHoles >= iterHoles {
+		t.Errorf("Expected fewer holes with the composed scheme: %d vs %d", compHoles, iterHoles)
+	}
+	if compMSE >= iterMSE {
+		t.Errorf("Expected a lower MSE with the composed scheme: %.1f vs %.1f", compMSE, iterMSE)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	a, b := NewFlowField(8, 8), NewFlowField(8, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			a.Set(x, y, 1, 0)
+			b.Set(x, y, 0.5*float64(x), 2)
+		}
+	}
+	b.SetNoData(4, 3)
+	b.SetNoData(5, 3)
+	c, err := Compose(a, b)
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	// (2, 1) moves to (3, 1) under a, then by b there.
+	if dx, dy, valid := c.At(2, 1); !valid || dx != 2.5 || dy != 2 {
+		t.Errorf("Composed displacement at (2, 1) = (%v, %v, %v), want (2.5, 2, true)", dx, dy, valid)
+	}
+	// (3, 3) lands on b's no-data pixel (4, 3), and (7, 0) leaves the field.
+	for _, pt := range []image.Point{{3, 3}, {7, 0}} {
+		if _, _, valid := c.At(pt.X, pt.Y); valid {
+			t.Errorf("Expected no data at %v", pt)
+		}
+	}
+	if c.Intervals != 2 {
+		t.Errorf("Expected the composed field to span 2 intervals, got %d", c.Intervals)
+	}
+
+	if _, err := Compose(a, NewFlowField(4, 8)); err == nil {
+		t.Error("Expected fields of different sizes to be rejected")
+	}
+}
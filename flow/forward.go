@@ -11,7 +11,42 @@ import (
 
 // ForwardTransform applies an optical flow map in forward to an image.
 // It uses the flow vectors to move pixels from a source image to a new destination image.
+// If the flow map has an alpha channel, its transparent pixels are treated as
+// no data: output pixels whose flow is missing, or whose source pixel lies in
+// a no-data region, are left transparent.
 func ForwardTransform(inputImagePath, flowMapPath string, factor float64) (image.Image, error) {
+	return ForwardTransformWithEncoding(inputImagePath, flowMapPath, factor, Encoding{})
+}
+
+// ForwardTransformWithEncoding is like ForwardTransform for a flow map
+// encoded with enc, such as one made under FlowOptions.Encoding. The bit
+// depth is taken from the flow map, so 8-bit and 16-bit maps both decode
+// with the Scale and MidLevel of enc.
+func ForwardTransformWithEncoding(inputImagePath, flowMapPath string, factor float64, enc Encoding) (image.Image, error) {
+	return ForwardTransformWithOptions(inputImagePath, flowMapPath, factor, ForwardOptions{Encoding: enc})
+}
+
+// ForwardOptions configures ForwardTransformWithOptions.
+type ForwardOptions struct {
+	// Encoding is the encoding of the flow map, as for
+	// ForwardTransformWithEncoding.
+	Encoding Encoding
+	// Occlusion, if set, marks the occluded pixels of the flow map with a
+	// nonzero alpha, as FlowResult.Occlusion does. It may have any size and
+	// is sampled at the nearest corresponding pixel. The flow there is not
+	// trusted: those output pixels keep the input image's value at the
+	// same position, or take Fill's.
+	Occlusion *image.Alpha
+	// Fill, if set, is the destination frame, of the input image's size,
+	// that occluded pixels are filled from.
+	Fill image.Image
+}
+
+// ForwardTransformWithOptions is like ForwardTransformWithEncoding for a
+// flow map encoded with opts.Encoding, leaving the pixels opts.Occlusion
+// marks unwarped.
+func ForwardTransformWithOptions(inputImagePath, flowMapPath string, factor float64, opts ForwardOptions) (image.Image, error) {
+	enc := opts.Encoding
 	// 1. Load the input image using OpenCV for proper format handling
 	inputMat := gocv.IMRead(inputImagePath, gocv.IMReadColor)
 	if inputMat.Empty() {
@@ -21,14 +56,24 @@ func ForwardTransform(inputImagePath, flowMapPath string, factor float64) (image
 
 	// Get input image dimensions
 	width, height := inputMat.Cols(), inputMat.Rows()
+	if opts.Fill != nil {
+		if size := opts.Fill.Bounds().Size(); size.X != width || size.Y != height {
+			return nil, fmt.Errorf("fill image is %dx%d, want the input image's %dx%d", size.X, size.Y, width, height)
+		}
+	}
 
-	// 2. Load the flow map using OpenCV
-	flowMat := gocv.IMRead(flowMapPath, gocv.IMReadColor)
+	// 2. Load the flow map using OpenCV, keeping the no-data alpha channel if present
+	flowMat := gocv.IMRead(flowMapPath, gocv.IMReadUnchanged)
 	if flowMat.Empty() {
 		return nil, fmt.Errorf("failed to read flow map %s with gocv", flowMapPath)
 	}
 	defer flowMat.Close()
 
+	if flowMat.Channels() == 1 {
+		gocv.CvtColor(flowMat, &flowMat, gocv.ColorGrayToBGR)
+	}
+	hasAlpha := flowMat.Channels() == 4
+
 	flowWidth := flowMat.Cols()
 	flowHeight := flowMat.Rows()
 
@@ -42,21 +87,56 @@ func ForwardTransform(inputImagePath, flowMapPath string, factor float64) (image
 		processedFlowMat = flowMat
 	}
 
+	// Read the levels as floats whatever the depth of the map, 8 or 16 bits.
+	enc.Depth = 8
+	if processedFlowMat.Type()&7 == gocv.MatTypeCV16U { // the depth bits of the type
+		enc.Depth = 16
+	}
+	levels := gocv.NewMat()
+	defer levels.Close()
+	processedFlowMat.ConvertTo(&levels, gocv.MatTypeCV32F)
+
+	// noData reports whether the flow at (x, y) is missing. Interpolation
+	// during resizing blurs the alpha edge, so anything below half is no data.
+	halfAlpha := float32((enc.maxLevel() + 1) / 2)
+	noData := func(x, y int) bool {
+		return hasAlpha && levels.GetVecfAt(y, x)[3] < halfAlpha
+	}
+
 	// 4. Create output image
 	outputImg := image.NewRGBA(image.Rect(0, 0, width, height))
 
 	// 5. Iterate through each pixel and apply the flow
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
+			// A pixel without flow cannot be predicted
+			if noData(x, y) {
+				outputImg.Set(x, y, color.RGBA{R: 0, G: 0, B: 0, A: 0})
+				continue
+			}
+
+			// Occluded pixels are not moved, and come from the fill
+			// frame if there is one
+			if occluded(opts.Occlusion, x, y, width, height) {
+				if opts.Fill != nil {
+					bounds := opts.Fill.Bounds()
+					r, g, b, _ := opts.Fill.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					setPixel(outputImg, x, y, uint8(r>>8), uint8(g>>8), uint8(b>>8))
+				} else {
+					srcBGR := inputMat.GetVecbAt(y, x)
+					setPixel(outputImg, x, y, srcBGR[2], srcBGR[1], srcBGR[0])
+				}
+				continue
+			}
+
 			// Get the flow vector from the processed flow map
-			bgr := processedFlowMat.GetVecbAt(y, x)
+			bgr := levels.GetVecfAt(y, x)
 			// The flow is encoded in R and G channels (B is unused)
-			r8 := uint8(bgr[2]) // Red channel
-			g8 := uint8(bgr[1]) // Green channel
+			r := uint16(bgr[2]) // Red channel
+			g := uint16(bgr[1]) // Green channel
 
 			// Reverse the encoding formula to get the displacement vector
-			dx := (float64(r8) - FlowMidLevel) / FlowScaleFactor
-			dy := (float64(g8) - FlowMidLevel) / FlowScaleFactor
+			dx, dy := enc.Decode(r, g)
 
 			// Calculate the source coordinates from where to pull the pixel
 			// We subtract the scaled displacement vector
@@ -81,19 +161,27 @@ func ForwardTransform(inputImagePath, flowMapPath string, factor float64) (image
 				finalSrcY = height - 1
 			}
 
-			// Get the color from the input Mat
-			srcBGR := inputMat.GetVecbAt(finalSrcY, finalSrcX)
-			srcR, srcG, srcB := srcBGR[2], srcBGR[1], srcBGR[0] // BGR to RGB conversion
-
-			// Check if the source pixel has zero value (black), which should be transparent
-			// If all RGB values are 0, this represents a transparent pixel
-			if srcR == 0 && srcG == 0 && srcB == 0 {
+			// Never pull pixels out of a no-data region
+			if noData(finalSrcX, finalSrcY) {
 				outputImg.Set(x, y, color.RGBA{R: 0, G: 0, B: 0, A: 0})
-			} else {
-				outputImg.Set(x, y, color.RGBA{R: srcR, G: srcG, B: srcB, A: 255})
+				continue
 			}
+
+			// Get the color from the input Mat
+			srcBGR := inputMat.GetVecbAt(finalSrcY, finalSrcX)
+			setPixel(outputImg, x, y, srcBGR[2], srcBGR[1], srcBGR[0]) // BGR to RGB conversion
 		}
 	}
 
 	return outputImg, nil
 }
+
+// setPixel sets pixel (x, y) of img to the color (r, g, b), or to
+// transparent if it is black, which represents a transparent pixel.
+func setPixel(img *image.RGBA, x, y int, r, g, b uint8) {
+	if r == 0 && g == 0 && b == 0 {
+		img.Set(x, y, color.RGBA{R: 0, G: 0, B: 0, A: 0})
+		return
+	}
+	img.Set(x, y, color.RGBA{R: r, G: g, B: b, A: 255})
+}
@@ -0,0 +1,139 @@
+package flow
+
+import "fmt"
+
+const (
+	// defaultDenseConfidence is comparable to the inverse distance weight of
+	// a single feature about three pixels away, so the sparse flow wins only
+	// close to tracked features.
+	defaultDenseConfidence  = 0.1
+	defaultFuseSmoothRadius = 2
+)
+
+// FuseOptions configures FuseFields.
+type FuseOptions struct {
+	// DenseConfidence is the confidence of every dense flow pixel, on the
+	// scale of the sparse confidence (0 to 1). Zero uses a default of 0.1.
+	DenseConfidence float64
+	// DenseConfidenceMap, if set, gives the dense confidence per pixel,
+	// indexed [y][x], for example from a local texture measure. It takes
+	// precedence over DenseConfidence.
+	DenseConfidenceMap [][]float64
+	// SmoothRadius is the radius of the box filter run over the blend
+	// weights so the switch from one field to the other does not leave a
+	// visible seam. Zero uses a default of 2 pixels; a negative radius
+	// disables smoothing.
+	SmoothRadius int
+}
+
+// FuseFields blends a sparse flow field, such as one interpolated from LK
+// features by InterpolateFlowFieldWithConfidence, with a dense field such as
+// Farneback flow. Each pixel is weighted by the two confidences: the sparse
+// field gets sparse/(sparse+dense) of the weight, after smoothing. Where only
+// one field has data it is used as is; where neither does, the result has no
+// data. The fields and confidence maps must all have the same size.
+func FuseFields(sparse *FlowField, sparseConfidence [][]float64, dense *FlowField, opts FuseOptions) (*FlowField, error) {
+	sparse, dense = sparse.fieldPixels(), dense.fieldPixels()
+	width, height := sparse.Width, sparse.Height
+	if dense.Width != width || dense.Height != height {
+		return nil, fmt.Errorf("cannot fuse a %dx%d sparse field with a %dx%d dense field", width, height, dense.Width, dense.Height)
+	}
+	if err := checkConfidenceSize(sparseConfidence, width, height); err != nil {
+		return nil, fmt.Errorf("sparse confidence: %w", err)
+	}
+	if opts.DenseConfidenceMap != nil {
+		if err := checkConfidenceSize(opts.DenseConfidenceMap, width, height); err != nil {
+			return nil, fmt.Errorf("dense confidence: %w", err)
+		}
+	}
+	denseConfidence := opts.DenseConfidence
+	if denseConfidence == 0 {
+		denseConfidence = defaultDenseConfidence
+	}
+	radius := opts.SmoothRadius
+	if radius == 0 {
+		radius = defaultFuseSmoothRadius
+	}
+
+	weights := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			cs := sparseConfidence[y][x]
+			cd := denseConfidence
+			if opts.DenseConfidenceMap != nil {
+				cd = opts.DenseConfidenceMap[y][x]
+			}
+			w := 0.5
+			if cs+cd > 0 {
+				w = cs / (cs + cd)
+			}
+			weights[y*width+x] = w
+		}
+	}
+	if radius > 0 {
+		weights = boxBlur(weights, width, height, radius)
+	}
+
+	fused := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sdx, sdy, sValid := sparse.At(x, y)
+			ddx, ddy, dValid := dense.At(x, y)
+			w := weights[y*width+x]
+			switch {
+			case sValid && dValid:
+				fused.Set(x, y, w*sdx+(1-w)*ddx, w*sdy+(1-w)*ddy)
+			case sValid:
+				fused.Set(x, y, sdx, sdy)
+			case dValid:
+				fused.Set(x, y, ddx, ddy)
+			default:
+				fused.SetNoData(x, y)
+			}
+		}
+	}
+	return fused, nil
+}
+
+// checkConfidenceSize verifies that a confidence map has width x height
+// entries.
+func checkConfidenceSize(confidence [][]float64, width, height int) error {
+	if len(confidence) != height {
+		return fmt.Errorf("expected %d rows, got %d", height, len(confidence))
+	}
+	for y, row := range confidence {
+		if len(row) != width {
+			return fmt.Errorf("expected %d columns, got %d in row %d", width, len(row), y)
+		}
+	}
+	return nil
+}
+
+// boxBlur returns the mean of v, a width x height image stored row by row,
+// over a (2*radius+1)^2 window around each pixel, clipped to the image.
+func boxBlur(v []float64, width, height, radius int) []float64 {
+	tmp := make([]float64, len(v))
+	for y := 0; y < height; y++ {
+		row := v[y*width : (y+1)*width]
+		for x := 0; x < width; x++ {
+			lo, hi := max(0, x-radius), min(width-1, x+radius)
+			var sum float64
+			for i := lo; i <= hi; i++ {
+				sum += row[i]
+			}
+			tmp[y*width+x] = sum / float64(hi-lo+1)
+		}
+	}
+	out := make([]float64, len(v))
+	for y := 0; y < height; y++ {
+		lo, hi := max(0, y-radius), min(height-1, y+radius)
+		for x := 0; x < width; x++ {
+			var sum float64
+			for i := lo; i <= hi; i++ {
+				sum += tmp[i*width+x]
+			}
+			out[y*width+x] = sum / float64(hi-lo+1)
+		}
+	}
+	return out
+}
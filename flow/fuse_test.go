@@ -0,0 +1,141 @@
+package flow
+
+import (
+	"math"
+	"testing"
+)
+
+const fuseSize = 64
+
+// fuseTruth is the ground truth flow of the fusion tests: a horizontal shear
+// plus a constant vertical drift.
+func fuseTruth(x, y int) (float64, float64) {
+	return 2 + 3*float64(y)/fuseSize, 1
+}
+
+// splitFields returns a sparse field that is exact on the left half and off
+// by (3, -2) on the right, with matching confidence, and a dense field that
+// is exact on the right half and off by (-2, 3) on the left.
+func splitFields() (sparse *FlowField, confidence [][]float64, dense *FlowField) {
+	sparse = NewFlowField(fuseSize, fuseSize)
+	dense = NewFlowField(fuseSize, fuseSize)
+	confidence = make([][]float64, fuseSize)
+	for y := 0; y < fuseSize; y++ {
+		confidence[y] = make([]float64, fuseSize)
+		for x := 0; x < fuseSize; x++ {
+			dx, dy := fuseTruth(x, y)
+			if x < fuseSize/2 {
+				sparse.Set(x, y, dx, dy)
+				confidence[y][x] = 1
+				dense.Set(x, y, dx-2, dy+3)
+			} else {
+				sparse.Set(x, y, dx+3, dy-2)
+				confidence[y][x] = 0.01
+				dense.Set(x, y, dx, dy)
+			}
+		}
+	}
+	return sparse, confidence, dense
+}
+
+// rmsError returns the RMS end-point error of field against fuseTruth.
+func rmsError(t *testing.T, field *FlowField) float64 {
+	t.Helper()
+	var sum float64
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			dx, dy, valid := field.At(x, y)
+			if !valid {
+				t.Fatalf("Pixel (%d, %d) has no data", x, y)
+			}
+			tx, ty := fuseTruth(x, y)
+			sum += (dx-tx)*(dx-tx) + (dy-ty)*(dy-ty)
+		}
+	}
+	return math.Sqrt(sum / float64(field.Width*field.Height))
+}
+
+func TestFuseFieldsBeatsBoth(t *testing.T) {
+	sparse, confidence, dense := splitFields()
+	fused, err := FuseFields(sparse, confidence, dense, FuseOptions{})
+	if err != nil {
+		t.Fatalf("FuseFields failed: %v", err)
+	}
+
+	sparseErr, denseErr, fusedErr := rmsError(t, sparse), rmsError(t, dense), rmsError(t, fused)
+	t.Logf("RMS error: sparse %.3f, dense %.3f, fused %.3f", sparseErr, denseErr, fusedErr)
+	if fusedErr >= sparseErr || fusedErr >= denseErr {
+		t.Errorf("Fused error %.3f is not below sparse %.3f and dense %.3f", fusedErr, sparseErr, denseErr)
+	}
+	if fusedErr > sparseErr/2 {
+		t.Errorf("Expected fusion to at least halve the error, got %.3f from %.3f", fusedErr, sparseErr)
+	}
+}
+
+// maxColumnStep returns the largest change in DX between horizontally
+// adjacent pixels of row y.
+func maxColumnStep(field *FlowField, y int) float64 {
+	var step float64
+	for x := 1; x < field.Width; x++ {
+		a, _, _ := field.At(x-1, y)
+		b, _, _ := field.At(x, y)
+		step = math.Max(step, math.Abs(b-a))
+	}
+	return step
+}
+
+func TestFuseFieldsSmoothsSeam(t *testing.T) {
+	// Both fields are smooth but biased in opposite directions, so any seam
+	// in the output comes from the blend weights switching at the middle.
+	sparse, confidence, _ := splitFields()
+	dense := NewFlowField(fuseSize, fuseSize)
+	for y := 0; y < fuseSize; y++ {
+		for x := 0; x < fuseSize; x++ {
+			dx, dy := fuseTruth(x, y)
+			sparse.Set(x, y, dx+1, dy)
+			dense.Set(x, y, dx-1, dy)
+		}
+	}
+
+	sharp, err := FuseFields(sparse, confidence, dense, FuseOptions{SmoothRadius: -1})
+	if err != nil {
+		t.Fatalf("FuseFields failed: %v", err)
+	}
+	smooth, err := FuseFields(sparse, confidence, dense, FuseOptions{SmoothRadius: 3})
+	if err != nil {
+		t.Fatalf("FuseFields failed: %v", err)
+	}
+
+	const row = fuseSize / 2
+	sharpStep, smoothStep := maxColumnStep(sharp, row), maxColumnStep(smooth, row)
+	if smoothStep > sharpStep/3 {
+		t.Errorf("Expected smoothing to spread the seam, max step %.3f against %.3f unsmoothed", smoothStep, sharpStep)
+	}
+}
+
+func TestFuseFieldsNoData(t *testing.T) {
+	sparse, confidence, dense := splitFields()
+	sparse.SetNoData(1, 1)
+	confidence[1][1] = 0
+	dense.SetNoData(40, 1)
+	sparse.SetNoData(50, 2)
+	dense.SetNoData(50, 2)
+
+	fused, err := FuseFields(sparse, confidence, dense, FuseOptions{})
+	if err != nil {
+		t.Fatalf("FuseFields failed: %v", err)
+	}
+	if dx, dy, valid := fused.At(1, 1); !valid || dx != 0.046875 || dy != 4 {
+		t.Errorf("Expected the dense flow where the sparse field has no data, got (%v, %v, %v)", dx, dy, valid)
+	}
+	if dx, dy, valid := fused.At(40, 1); !valid || dx != 5.046875 || dy != -1 {
+		t.Errorf("Expected the sparse flow where the dense field has no data, got (%v, %v, %v)", dx, dy, valid)
+	}
+	if _, _, valid := fused.At(50, 2); valid {
+		t.Error("Expected no data where neither field has data")
+	}
+
+	if _, err := FuseFields(sparse, confidence[1:], dense, FuseOptions{}); err == nil {
+		t.Error("Expected an error for a confidence map of the wrong size")
+	}
+}
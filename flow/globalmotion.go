@@ -0,0 +1,277 @@
+package flow
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// MotionModel selects the global motion EstimateGlobalMotion fits.
+type MotionModel int
+
+const (
+	// MotionTranslation moves the whole frame by one displacement, the
+	// median of the features' displacements in x and in y.
+	MotionTranslation MotionModel = iota
+	// MotionAffine maps the frame by an affine transform, which also
+	// covers rotation, scaling and shear, fitted by least squares to the
+	// features agreeing with the best of a set of random three-feature
+	// fits.
+	MotionAffine
+)
+
+func (m MotionModel) String() string {
+	switch m {
+	case MotionTranslation:
+		return "translation"
+	case MotionAffine:
+		return "affine"
+	}
+	return "unknown"
+}
+
+// ParseMotionModel returns the MotionModel named s: "translation" or
+// "affine".
+func ParseMotionModel(s string) (MotionModel, error) {
+	for _, m := range []MotionModel{MotionTranslation, MotionAffine} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown motion model %q (want translation or affine)", s)
+}
+
+const (
+	// globalInlierThreshold is the farthest, in pixels, that a feature may
+	// be tracked from where a global motion puts it and still count as
+	// one of its inliers.
+	globalInlierThreshold = 1.0
+	// ransacIterations is the number of random three-feature fits the
+	// affine estimate tries. Even with half the features outliers, the
+	// chance that no sample is all inliers is below 1e-28.
+	ransacIterations = 500
+	// ransacSeed seeds the sampling, so an estimate is reproducible.
+	ransacSeed = 1
+	// refineIterations bounds the least squares refits of the affine
+	// estimate to its growing inlier set.
+	refineIterations = 5
+)
+
+// GlobalMotion is a single motion of the whole frame between two frames:
+// a point (x, y) moves to
+//
+//	(Matrix[0]*x + Matrix[1]*y + Matrix[2], Matrix[3]*x + Matrix[4]*y + Matrix[5])
+//
+// in pixels of the frames. A translation has the identity in Matrix[0],
+// [1], [3] and [4].
+type GlobalMotion struct {
+	Model  MotionModel
+	Matrix [6]float64
+	// Inliers is the number of features the motion moves to within a
+	// pixel of where they were tracked, of the Features finite ones.
+	Inliers  int
+	Features int
+}
+
+// Apply returns where p moves under m.
+func (m GlobalMotion) Apply(p gocv.Point2f) gocv.Point2f {
+	x, y := m.apply(float64(p.X), float64(p.Y))
+	return gocv.Point2f{X: float32(x), Y: float32(y)}
+}
+
+// apply is Apply at full precision.
+func (m GlobalMotion) apply(x, y float64) (float64, float64) {
+	a := m.Matrix
+	return a[0]*x + a[1]*y + a[2], a[3]*x + a[4]*y + a[5]
+}
+
+// Translation returns the displacement of the origin, the top left corner
+// of the frame: the whole displacement under MotionTranslation.
+func (m GlobalMotion) Translation() (dx, dy float64) {
+	return m.Matrix[2], m.Matrix[5]
+}
+
+// EstimateGlobalMotion fits a motion of the given model to features tracked
+// from initialPoints to currentPoints, Nx2 CV32F point matrices in pixels
+// like those InterpolateFlowField takes. Features with a non-finite
+// coordinate are ignored. MotionTranslation needs one feature and
+// MotionAffine three that are not collinear; with fewer the error wraps
+// ErrTooFewFeatures.
+//
+// The affine fit is robust to features that move on their own, such as a
+// growing cell over a stratiform field, as long as most move with the frame:
+// it keeps the three-feature fit that most features agree with, then refits
+// by least squares to those that do. The sampling is seeded, so the result
+// depends only on the points.
+func EstimateGlobalMotion(initialPoints, currentPoints gocv.Mat, model MotionModel) (GlobalMotion, error) {
+	if initialPoints.Rows() != currentPoints.Rows() {
+		return GlobalMotion{}, fmt.Errorf("got %d initial points but %d current points", initialPoints.Rows(), currentPoints.Rows())
+	}
+	var from, to []gocv.Point2f
+	for i := 0; i < initialPoints.Rows(); i++ {
+		p0, p1 := pointAt(initialPoints, i), pointAt(currentPoints, i)
+		if finitePoint(p0) && finitePoint(p1) {
+			from = append(from, p0)
+			to = append(to, p1)
+		}
+	}
+
+	motion := GlobalMotion{Model: model, Features: len(from)}
+	switch model {
+	case MotionTranslation:
+		if len(from) == 0 {
+			return GlobalMotion{}, fmt.Errorf("%w to estimate the global motion: none are finite", ErrTooFewFeatures)
+		}
+		dxs, dys := make([]float64, len(from)), make([]float64, len(from))
+		for i := range from {
+			dxs[i] = float64(to[i].X) - float64(from[i].X)
+			dys[i] = float64(to[i].Y) - float64(from[i].Y)
+		}
+		sort.Float64s(dxs)
+		sort.Float64s(dys)
+		motion.Matrix = [6]float64{1, 0, findMedian(dxs), 0, 1, findMedian(dys)}
+	case MotionAffine:
+		if len(from) < 3 {
+			return GlobalMotion{}, fmt.Errorf("%w to estimate the global motion: an affine motion needs 3, got %d", ErrTooFewFeatures, len(from))
+		}
+		matrix, ok := ransacAffine(from, to)
+		if !ok {
+			return GlobalMotion{}, fmt.Errorf("%w to estimate the global motion: the %d features are collinear", ErrTooFewFeatures, len(from))
+		}
+		motion.Matrix = matrix
+	default:
+		return GlobalMotion{}, fmt.Errorf("unknown motion model %d", model)
+	}
+	motion.Inliers = len(motion.inliers(from, to))
+	return motion, nil
+}
+
+// inliers returns the indexes of the features m moves to within
+// globalInlierThreshold of their tracked position.
+func (m GlobalMotion) inliers(from, to []gocv.Point2f) []int {
+	var idx []int
+	for i := range from {
+		x, y := m.apply(float64(from[i].X), float64(from[i].Y))
+		if math.Hypot(x-float64(to[i].X), y-float64(to[i].Y)) <= globalInlierThreshold {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// ransacAffine returns the affine matrix fitted by least squares to the
+// inliers of the three-feature fit with the most inliers, refitted until
+// they stop growing. It reports false if every sample is collinear.
+func ransacAffine(from, to []gocv.Point2f) ([6]float64, bool) {
+	rng := rand.New(rand.NewSource(ransacSeed))
+	var best GlobalMotion
+	bestInliers := 0
+	for it := 0; it < ransacIterations; it++ {
+		i := rng.Intn(len(from))
+		j := rng.Intn(len(from) - 1)
+		if j >= i {
+			j++
+		}
+		k := rng.Intn(len(from))
+		if k == i || k == j {
+			continue
+		}
+		matrix, ok := fitAffine(from, to, []int{i, j, k})
+		if !ok {
+			continue
+		}
+		candidate := GlobalMotion{Matrix: matrix}
+		if n := len(candidate.inliers(from, to)); n > bestInliers {
+			best, bestInliers = candidate, n
+		}
+	}
+	if bestInliers == 0 {
+		// Every sample was collinear, which the features as a whole need
+		// not be.
+		matrix, ok := fitAffine(from, to, nil)
+		return matrix, ok
+	}
+
+	inliers := best.inliers(from, to)
+	for it := 0; it < refineIterations; it++ {
+		matrix, ok := fitAffine(from, to, inliers)
+		if !ok {
+			break
+		}
+		refit := GlobalMotion{Matrix: matrix}
+		next := refit.inliers(from, to)
+		if len(next) < len(inliers) {
+			break
+		}
+		best = refit
+		if len(next) == len(inliers) {
+			break
+		}
+		inliers = next
+	}
+	return best.Matrix, true
+}
+
+// fitAffine returns the least squares affine matrix moving the features at
+// idx, or every feature if idx is nil, to their tracked positions. It
+// reports false if they are collinear. The fit is taken about their
+// centroid, which separates the translation from the linear part.
+func fitAffine(from, to []gocv.Point2f, idx []int) ([6]float64, bool) {
+	if idx == nil {
+		idx = make([]int, len(from))
+		for i := range idx {
+			idx[i] = i
+		}
+	}
+	var mx, my, mx1, my1 float64
+	for _, i := range idx {
+		mx += float64(from[i].X)
+		my += float64(from[i].Y)
+		mx1 += float64(to[i].X)
+		my1 += float64(to[i].Y)
+	}
+	n := float64(len(idx))
+	mx, my, mx1, my1 = mx/n, my/n, mx1/n, my1/n
+
+	var suu, suv, svv, sux, svx, suy, svy float64
+	for _, i := range idx {
+		u, v := float64(from[i].X)-mx, float64(from[i].Y)-my
+		x1, y1 := float64(to[i].X)-mx1, float64(to[i].Y)-my1
+		suu += u * u
+		suv += u * v
+		svv += v * v
+		sux += u * x1
+		svx += v * x1
+		suy += u * y1
+		svy += v * y1
+	}
+	det := suu*svv - suv*suv
+	if !(det > 1e-9*(suu+svv)*(suu+svv)) {
+		return [6]float64{}, false
+	}
+	a := (svv*sux - suv*svx) / det
+	b := (suu*svx - suv*sux) / det
+	c := (svv*suy - suv*svy) / det
+	d := (suu*svy - suv*suy) / det
+	return [6]float64{a, b, mx1 - a*mx - b*my, c, d, my1 - c*mx - d*my}, true
+}
+
+// ApplyGlobalMotion warps img, a matrix of any type, by m: the content at
+// each point moves to where m takes it, interpolated bilinearly, so a frame
+// warped by the motion estimated from it to the next frame predicts that
+// frame. The result has the size of img; pixels that m brings in from
+// outside the frame are zero. The caller closes it.
+func ApplyGlobalMotion(img gocv.Mat, m GlobalMotion) gocv.Mat {
+	matrix := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV64F)
+	defer matrix.Close()
+	for i, v := range m.Matrix {
+		matrix.SetDoubleAt(i/3, i%3, v)
+	}
+	warped := gocv.NewMat()
+	gocv.WarpAffine(img, &warped, matrix, image.Pt(img.Cols(), img.Rows()))
+	return warped
+}
@@ -0,0 +1,186 @@
+package flow
+
+import (
+	"errors"
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// globalMotionPoints returns n features scattered over a size x size frame
+// moved by motion, with a little tracking noise, except every fifth, which
+// also drifts 2 to 22 pixels right and 2 to 12 up.
+func globalMotionPoints(n, size int, motion GlobalMotion) (initial, current gocv.Mat, outliers int) {
+	rng := rand.New(rand.NewSource(7))
+	initial = gocv.NewMatWithSize(n, 2, gocv.MatTypeCV32F)
+	current = gocv.NewMatWithSize(n, 2, gocv.MatTypeCV32F)
+	for i := 0; i < n; i++ {
+		x, y := rng.Float64()*float64(size), rng.Float64()*float64(size)
+		x1, y1 := motion.apply(x, y)
+		if i%5 == 0 {
+			x1 += rng.Float64()*20 + 2
+			y1 -= rng.Float64()*10 + 2
+			outliers++
+		} else {
+			x1 += (rng.Float64() - 0.5) * 0.2
+			y1 += (rng.Float64() - 0.5) * 0.2
+		}
+		initial.SetFloatAt(i, 0, float32(x))
+		initial.SetFloatAt(i, 1, float32(y))
+		current.SetFloatAt(i, 0, float32(x1))
+		current.SetFloatAt(i, 1, float32(y1))
+	}
+	return initial, current, outliers
+}
+
+// checkMatrix fails t unless got is within tolerance of want, linearTol for
+// the linear part and shiftTol pixels for the translation.
+func checkMatrix(t *testing.T, got, want [6]float64, linearTol, shiftTol float64) {
+	t.Helper()
+	for i := range got {
+		tol := linearTol
+		if i == 2 || i == 5 {
+			tol = shiftTol
+		}
+		if math.Abs(got[i]-want[i]) > tol {
+			t.Errorf("Expected matrix %v, got %v", want, got)
+			return
+		}
+	}
+}
+
+// TestEstimateGlobalMotionTranslation recovers a (20, 10) shift with both
+// models despite a fifth of the features moving on their own.
+func TestEstimateGlobalMotionTranslation(t *testing.T) {
+	shift := GlobalMotion{Matrix: [6]float64{1, 0, 20, 0, 1, 10}}
+	initial, current, outliers := globalMotionPoints(200, 512, shift)
+	defer initial.Close()
+	defer current.Close()
+
+	for _, model := range []MotionModel{MotionTranslation, MotionAffine} {
+		motion, err := EstimateGlobalMotion(initial, current, model)
+		if err != nil {
+			t.Fatalf("EstimateGlobalMotion(%v) failed: %v", model, err)
+		}
+		checkMatrix(t, motion.Matrix, shift.Matrix, 1e-3, 0.1)
+		if dx, dy := motion.Translation(); math.Abs(dx-20) > 0.1 || math.Abs(dy-10) > 0.1 {
+			t.Errorf("%v: expected a translation of (20, 10), got (%.3f, %.3f)", model, dx, dy)
+		}
+		if motion.Model != model || motion.Features != 200 || motion.Inliers != 200-outliers {
+			t.Errorf("%v: expected %d inliers of 200 features, got %+v", model, 200-outliers, motion)
+		}
+	}
+}
+
+// TestEstimateGlobalMotionRotation recovers a 2 degree rotation about the
+// frame center with a shift under the affine model.
+func TestEstimateGlobalMotionRotation(t *testing.T) {
+	const size, theta = 512, 2 * math.Pi / 180
+	sin, cos := math.Sin(theta), math.Cos(theta)
+	c := float64(size) / 2
+	rotation := GlobalMotion{Matrix: [6]float64{cos, -sin, c - cos*c + sin*c + 4, sin, cos, c - sin*c - cos*c - 3}}
+	initial, current, outliers := globalMotionPoints(200, size, rotation)
+	defer initial.Close()
+	defer current.Close()
+
+	motion, err := EstimateGlobalMotion(initial, current, MotionAffine)
+	if err != nil {
+		t.Fatalf("EstimateGlobalMotion failed: %v", err)
+	}
+	checkMatrix(t, motion.Matrix, rotation.Matrix, 1e-3, 0.2)
+	if got := math.Atan2(motion.Matrix[3], motion.Matrix[0]); math.Abs(got-theta) > 1e-3 {
+		t.Errorf("Expected a rotation of %.5f rad, got %.5f", theta, got)
+	}
+	if motion.Inliers != 200-outliers {
+		t.Errorf("Expected %d inliers, got %d", 200-outliers, motion.Inliers)
+	}
+	// A translation cannot follow the rotation, so the corners disagree.
+	shift, err := EstimateGlobalMotion(initial, current, MotionTranslation)
+	if err != nil {
+		t.Fatalf("EstimateGlobalMotion failed: %v", err)
+	}
+	if shift.Inliers >= motion.Inliers {
+		t.Errorf("Expected fewer translation inliers than affine ones, got %d and %d", shift.Inliers, motion.Inliers)
+	}
+}
+
+func TestEstimateGlobalMotionErrors(t *testing.T) {
+	initial, current := gocv.NewMatWithSize(2, 2, gocv.MatTypeCV32F), gocv.NewMatWithSize(2, 2, gocv.MatTypeCV32F)
+	defer initial.Close()
+	defer current.Close()
+	if _, err := EstimateGlobalMotion(initial, current, MotionAffine); !errors.Is(err, ErrTooFewFeatures) {
+		t.Errorf("Expected ErrTooFewFeatures for two affine features, got %v", err)
+	}
+	if _, err := EstimateGlobalMotion(initial, current, MotionTranslation); err != nil {
+		t.Errorf("Expected two features to give a translation, got %v", err)
+	}
+
+	collinear, moved := gocv.NewMatWithSize(5, 2, gocv.MatTypeCV32F), gocv.NewMatWithSize(5, 2, gocv.MatTypeCV32F)
+	defer collinear.Close()
+	defer moved.Close()
+	for i := 0; i < 5; i++ {
+		collinear.SetFloatAt(i, 0, float32(10*i))
+		collinear.SetFloatAt(i, 1, float32(5*i))
+		moved.SetFloatAt(i, 0, float32(10*i+3))
+		moved.SetFloatAt(i, 1, float32(5*i))
+	}
+	if _, err := EstimateGlobalMotion(collinear, moved, MotionAffine); !errors.Is(err, ErrTooFewFeatures) {
+		t.Errorf("Expected ErrTooFewFeatures for collinear features, got %v", err)
+	}
+	if _, err := EstimateGlobalMotion(collinear, current, MotionTranslation); err == nil {
+		t.Error("Expected an error for point matrices of different lengths")
+	}
+
+	if m, err := ParseMotionModel("affine"); err != nil || m != MotionAffine {
+		t.Errorf("Expected ParseMotionModel(affine) to give MotionAffine, got %v, %v", m, err)
+	}
+	if _, err := ParseMotionModel("projective"); err == nil {
+		t.Error("Expected an error for an unknown motion model")
+	}
+}
+
+// TestApplyGlobalMotion warps a frame by a (20, 10) shift and checks that
+// tracking the warped frame recovers it.
+func TestApplyGlobalMotion(t *testing.T) {
+	frame, err := prepImage(readPNG(t, "../test_data/centered.png"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frame.Close()
+	shift := GlobalMotion{Matrix: [6]float64{1, 0, 20, 0, 1, 10}}
+	warped := ApplyGlobalMotion(frame, shift)
+	defer warped.Close()
+
+	if warped.Rows() != frame.Rows() || warped.Cols() != frame.Cols() {
+		t.Fatalf("Expected a %dx%d frame, got %dx%d", frame.Cols(), frame.Rows(), warped.Cols(), warped.Rows())
+	}
+	for _, p := range []image.Point{{100, 80}, {frame.Cols() / 2, frame.Rows() / 2}} {
+		if got, want := warped.GetUCharAt(p.Y+10, p.X+20), frame.GetUCharAt(p.Y, p.X); got != want {
+			t.Errorf("Expected pixel %v to move to %v with value %d, got %d", p, p.Add(image.Pt(20, 10)), want, got)
+		}
+	}
+	if v := warped.GetUCharAt(5, 5); v != 0 {
+		t.Errorf("Expected the uncovered corner to be zero, got %d", v)
+	}
+
+	acc := NewAccumulator(FlowOptions{})
+	defer acc.Close()
+	for i, mat := range []gocv.Mat{frame, warped} {
+		if err := acc.addMat(mat.Clone(), []string{"frame", "warped"}[i], i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initial, current := acc.sparseFlow().mats()
+	defer initial.Close()
+	defer current.Close()
+	motion, err := EstimateGlobalMotion(initial, current, MotionTranslation)
+	if err != nil {
+		t.Fatalf("EstimateGlobalMotion failed: %v", err)
+	}
+	if dx, dy := motion.Translation(); math.Abs(dx-20) > 0.5 || math.Abs(dy-10) > 0.5 {
+		t.Errorf("Expected to track a (20, 10) shift, got (%.2f, %.2f)", dx, dy)
+	}
+}
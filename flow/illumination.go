@@ -0,0 +1,131 @@
+package flow
+
+import (
+	"fmt"
+
+	"gocv.io/x/gocv"
+)
+
+// IlluminationMode selects what is done about global intensity changes
+// between frames, such as a radar composite changing its calibration.
+type IlluminationMode int
+
+const (
+	// IlluminationIgnore leaves the frames as they are.
+	IlluminationIgnore IlluminationMode = iota
+	// IlluminationReport estimates the change between every pair of frames
+	// and records it in FlowResult.Illumination, without correcting it.
+	IlluminationReport
+	// IlluminationCorrect also normalizes each frame to the intensities of
+	// the frame before it, and so of the first frame, before computing the
+	// flow.
+	IlluminationCorrect
+)
+
+func (m IlluminationMode) String() string {
+	switch m {
+	case IlluminationIgnore:
+		return "ignore"
+	case IlluminationReport:
+		return "report"
+	case IlluminationCorrect:
+		return "correct"
+	}
+	return "unknown"
+}
+
+// IlluminationChange is the global intensity change estimated between two
+// consecutive frames: the second is about Gain times the first plus Offset.
+type IlluminationChange struct {
+	Gain   float64
+	Offset float64
+	// Estimated is false when the frames did not have enough unsaturated
+	// intensities in common to fit the change; Gain is then 1 and Offset 0.
+	Estimated bool
+	// Applied is true when the second frame was corrected.
+	Applied bool
+}
+
+// illuminationQuantiles is the number of intensity quantiles matched between
+// two frames to fit an IlluminationChange. A global shift of the image moves
+// pixels around but leaves its histogram, and so its quantiles, nearly
+// unchanged, so the fit needs no pixel correspondences.
+const illuminationQuantiles = 200
+
+// minIlluminationPairs is the fewest unsaturated quantile pairs, with at
+// least two distinct intensities, needed for a fit.
+const minIlluminationPairs = 10
+
+// EstimateIllumination fits the global gain and offset between two 8-bit
+// single-channel frames by least squares over their matched intensity
+// quantiles. Quantiles at 0 or 255 in either frame are left out, since
+// clipping hides how those pixels changed.
+func EstimateIllumination(prev, next gocv.Mat) (IlluminationChange, error) {
+	noChange := IlluminationChange{Gain: 1}
+	qPrev, err := intensityQuantiles(prev)
+	if err != nil {
+		return noChange, err
+	}
+	qNext, err := intensityQuantiles(next)
+	if err != nil {
+		return noChange, err
+	}
+
+	var n, sumX, sumY, sumXX, sumXY float64
+	for i := range qPrev {
+		x, y := qPrev[i], qNext[i]
+		if x == 0 || x == 255 || y == 0 || y == 255 {
+			continue
+		}
+		n++
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	varX := n*sumXX - sumX*sumX
+	if n < minIlluminationPairs || varX <= 0 {
+		return noChange, nil
+	}
+	gain := (n*sumXY - sumX*sumY) / varX
+	if gain <= 0 {
+		return noChange, nil
+	}
+	return IlluminationChange{Gain: gain, Offset: (sumY - gain*sumX) / n, Estimated: true}, nil
+}
+
+// intensityQuantiles returns illuminationQuantiles evenly spaced quantiles
+// of the pixel values of an 8-bit single-channel frame.
+func intensityQuantiles(mat gocv.Mat) ([]float64, error) {
+	if mat.Type() != gocv.MatTypeCV8U {
+		return nil, fmt.Errorf("illumination estimate needs an 8-bit single-channel frame")
+	}
+	data, err := mat.DataPtrUint8()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frame for illumination estimate: %w", err)
+	}
+	var hist [256]int
+	for _, v := range data {
+		hist[v]++
+	}
+	total := len(data)
+	quantiles := make([]float64, illuminationQuantiles)
+	value, below := 0, hist[0]
+	for i := range quantiles {
+		rank := (2*i + 1) * total / (2 * illuminationQuantiles)
+		for below <= rank && value < 255 {
+			value++
+			below += hist[value]
+		}
+		quantiles[i] = float64(value)
+	}
+	return quantiles, nil
+}
+
+// correctIllumination returns next with change undone, mapping its
+// intensities back to those of the frame before it.
+func correctIllumination(next gocv.Mat, change IlluminationChange) gocv.Mat {
+	corrected := gocv.NewMat()
+	next.ConvertToWithParams(&corrected, gocv.MatTypeCV8U, float32(1/change.Gain), float32(-change.Offset/change.Gain))
+	return corrected
+}
@@ -0,0 +1,83 @@
+package flow
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// brighten returns a copy of a grayscale frame with its intensities scaled
+// by gain, saturating at 255.
+func brighten(img image.Image, gain float64) *image.Gray {
+	src := img.(*image.Gray)
+	out := image.NewGray(src.Bounds())
+	for i, v := range src.Pix {
+		out.Pix[i] = uint8(math.Min(255, math.Round(gain*float64(v))))
+	}
+	return out
+}
+
+// denseShiftError runs MethodDense over frames and returns the distance of
+// the mean flow away from the borders from (wantX, wantY), and the
+// recorded illumination changes.
+func denseShiftError(t *testing.T, frames []image.Image, mode IlluminationMode, wantX, wantY float64) (float64, []IlluminationChange) {
+	t.Helper()
+	const resolutionFactor = 4
+	acc := NewAccumulator(FlowOptions{Method: MethodDense, Illumination: mode})
+	defer acc.Close()
+	for _, f := range frames {
+		if err := acc.AddImage(f, "frame"); err != nil {
+			t.Fatalf("AddImage failed: %v", err)
+		}
+	}
+	field, err := acc.FlowField(resolutionFactor)
+	if err != nil {
+		t.Fatalf("FlowField failed: %v", err)
+	}
+	var sumX, sumY float64
+	var n int
+	for y := 20; y < field.Height-20; y++ {
+		for x := 20; x < field.Width-20; x++ {
+			if dx, dy, valid := field.At(x, y); valid {
+				sumX += dx
+				sumY += dy
+				n++
+			}
+		}
+	}
+	gotX, gotY := sumX/float64(n), sumY/float64(n)
+	return math.Hypot(gotX-wantX/resolutionFactor, gotY-wantY/resolutionFactor), acc.Illumination()
+}
+
+func TestIlluminationCorrection(t *testing.T) {
+	frames := translatingFrames(2, 6, 3)
+	first, second := frames[0], frames[1]
+	brightened := brighten(second, 1.2)
+
+	plainErr, _ := denseShiftError(t, []image.Image{first, second}, IlluminationCorrect, 6, 3)
+	correctedErr, changes := denseShiftError(t, []image.Image{first, brightened}, IlluminationCorrect, 6, 3)
+	t.Logf("Shift error: unbrightened %.3f, corrected %.3f", plainErr, correctedErr)
+
+	if correctedErr > plainErr+0.05 {
+		t.Errorf("Corrected shift error %.3f is worse than the unbrightened %.3f", correctedErr, plainErr)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Expected one illumination change, got %d", len(changes))
+	}
+	c := changes[0]
+	if !c.Estimated || !c.Applied || math.Abs(c.Gain-1.2) > 0.03 || math.Abs(c.Offset) > 3 {
+		t.Errorf("Expected an applied gain of 1.2 and no offset, got %+v", c)
+	}
+}
+
+func TestIlluminationReportOnly(t *testing.T) {
+	still := translatingFrames(1, 0, 0)[0]
+	frames := []image.Image{still, brighten(still, 0.8)}
+	_, changes := denseShiftError(t, frames, IlluminationReport, 0, 0)
+	if len(changes) != 1 {
+		t.Fatalf("Expected one illumination change, got %d", len(changes))
+	}
+	if c := changes[0]; !c.Estimated || c.Applied || math.Abs(c.Gain-0.8) > 0.03 {
+		t.Errorf("Expected an unapplied gain of 0.8, got %+v", c)
+	}
+}
@@ -1,91 +1,350 @@
 package flow
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image"
-	"image/png"
-	"os"
+	"image/draw"
+	"math"
+	"strconv"
+	"time"
 
 	"gocv.io/x/gocv"
 )
 
 const (
-	// Original image dimensions
-	originalWidth  = 1024
-	originalHeight = 1024
-
-	// Flow visualization constants
+	// Flow visualization constants, the defaults of Encoding
 	FlowScaleFactor = 10.0 // Scaling factor for flow vectors
 	FlowMidLevel    = 128  // Mid-level value for centering flow visualization
 )
 
+// ErrFrameSize is returned for a frame whose size differs from that of the
+// first frame of its sequence.
+var ErrFrameSize = errors.New("flow: frame size differs from the first frame")
+
 // GenerateAverageFlowMap loads a sequence of images, calculates the sparse optical flow
 // by tracking features through the entire sequence, and returns a visualization
-// of the total displacement vectors.
+// of the total displacement vectors. The frames may be of any size, but all
+// the same size as the first; the map is that size divided by
+// resolutionFactor. A resolutionFactor of AutoResolution chooses the
+// factor with ResolutionFactorFor and DefaultPixelBudget.
+//
+// ComputeAverageFlow returns the same displacements at full precision, and
+// EncodeFlowMap renders them as this map.
 func GenerateAverageFlowMap(imagePaths []string, resolutionFactor int) (image.Image, error) {
-	if len(imagePaths) < 2 {
-		return nil, fmt.Errorf("at least two images are required, but got %d", len(imagePaths))
-	}
-
-	initialPoints, currentPoints, err := calculateSparseOpticalFlow(imagePaths)
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, resolutionFactor, FlowOptions{})
 	if err != nil {
 		return nil, err
 	}
-	defer initialPoints.Close()
-	defer currentPoints.Close()
+	return result.Image, nil
+}
 
-	scaledWidth := originalWidth / resolutionFactor
-	scaledHeight := originalHeight / resolutionFactor
+// FlowOptions configures GenerateAverageFlowMapWithOptions.
+type FlowOptions struct {
+	// RecordPaths keeps the position of every surviving feature in every
+	// frame and returns them in FlowResult.Paths.
+	RecordPaths bool
+	// HashInputs records the SHA-256 of every input frame in the
+	// provenance, reading each file once more. Without it the frames are
+	// recorded by size and modification time only.
+	HashInputs bool
+	// NoDataMask, if set, marks the regions without data (zero alpha). They
+	// are left neutral and transparent in the flow map; see
+	// InterpolateFlowField.
+	NoDataMask *image.Alpha
+	// RegionMask, if set, is the region of interest: features are only
+	// detected at its pixels with a non-zero alpha, and the pixels outside
+	// it are left without flow like those of NoDataMask, neutral and
+	// transparent in the flow map. It keeps the tracking off static
+	// structure burned into the frames, such as range rings, coastlines
+	// and logos, whose features would otherwise pull the flow toward zero.
+	// It may have any size and is sampled at the nearest pixel; see
+	// MaskFromImage and MaskFromMat.
+	RegionMask *image.Alpha
+	// SkipBadFrames skips frames that cannot be read or differ in size from
+	// the first good frame instead of failing. Tracking continues from the last good frame, and
+	// the skipped frames are reported in FlowResult.Skipped. At least two
+	// good frames are still required.
+	SkipBadFrames bool
+	// Method selects sparse LK, dense Farneback or fused flow. Under
+	// MethodDense no features are tracked and Paths stays empty.
+	Method Method
+	// Farneback configures the dense flow under MethodDense and
+	// MethodFused.
+	Farneback FarnebackOptions
+	// Fuse configures the blending under MethodFused.
+	Fuse FuseOptions
+	// Illumination selects whether global intensity changes between frames
+	// are estimated, and corrected, before the flow is computed.
+	Illumination IlluminationMode
+	// Units, if set, calibrates the flow fields for DisplacementAt and
+	// VelocityAt. It does not change the flow map.
+	Units Units
+	// Features configures the detection and LK tracking of the sparse
+	// features. Its zero value is the detection and tracking
+	// GenerateAverageFlowMap uses.
+	Features FeatureOptions
+	// Interpolation configures how the sparse feature displacements are
+	// spread over the flow field; see InterpolateFlowFieldWithOptions.
+	Interpolation InterpolationOptions
+	// Reseed configures detecting new features when too few survive on a
+	// long sequence. It is off by default.
+	Reseed ReseedOptions
+	// Retry configures tracking a frame pair again with other LK
+	// parameters when too few features survive it, as happens on a single
+	// blurred or partly scanned frame. It is off by default.
+	Retry RetryPolicy
+	// MinSurvivingFeatures is the fewest features the sparse flow may be
+	// interpolated from: with fewer surviving the sequence the computation
+	// fails with a *TooFewFeaturesError. Zero or a negative value, the
+	// default, applies no minimum, so that only a frame pair that loses
+	// every feature fails; DefaultMinSurvivingFeatures is the minimum the
+	// CLI and the API apply. It does not apply under MethodDense.
+	MinSurvivingFeatures int
+	// PixelBudget is, for a resolutionFactor of AutoResolution, the most
+	// pixels the flow field may have; the smallest factor that keeps it
+	// within the budget is chosen. Zero means DefaultPixelBudget.
+	PixelBudget int
+	// Encoding sets how FlowResult.Image stores the displacements. A map
+	// must be decoded, as by ForwardTransformWithEncoding, with the same
+	// Encoding.
+	Encoding Encoding
+	// Workspace, if set, lends the buffers of the computation, which then
+	// reuses them from call to call; the result's Image and Field are its
+	// buffers until its next use. See Workspace.
+	Workspace *Workspace
+	// Occlusion configures checking the flow against the flow over the
+	// frames in reverse order, to find the occluded pixels. It is off by
+	// default.
+	Occlusion OcclusionOptions
+	// OnProgress, if set, is called after each frame of the sequence is
+	// tracked, or skipped, with its index and the number of frames, so a
+	// long computation can report its progress. It is called on the
+	// goroutine of the computation; the backward pass of Occlusion does not
+	// report progress. GenerateFlowSequenceWithContext calls it per pair.
+	// It is left out of the options' JSON encoding.
+	OnProgress func(frameIndex, totalFrames int) `json:"-"`
+}
 
-	return GenerateDenseFlowMap(initialPoints, currentPoints, scaledWidth, scaledHeight, resolutionFactor)
+// SkippedFrame describes a frame left out under FlowOptions.SkipBadFrames.
+type SkippedFrame struct {
+	Index int    // position of the frame in the input sequence
+	Path  string // path of the frame, empty for a frame held in memory
+	Err   error  // why the frame could not be used
 }
 
-// calculateSparseOpticalFlow computes the sparse optical flow for a sequence of images.
-func calculateSparseOpticalFlow(imagePaths []string) (gocv.Mat, gocv.Mat, error) {
-	prevMat, err := loadAndPrepImage(imagePaths[0])
-	if err != nil {
-		return gocv.NewMat(), gocv.NewMat(), fmt.Errorf("failed to load initial image %s: %w", imagePaths[0], err)
+// DroppedFeatures counts the features dropped while tracking, by reason.
+type DroppedFeatures struct {
+	// NonFinite were tracked to a NaN or infinite position.
+	NonFinite int
+	// TrackingError had an LK error above
+	// FeatureOptions.MaxTrackingError.
+	TrackingError int
+	// RoundTrip failed the check of FeatureOptions.ForwardBackward.
+	RoundTrip int
+}
+
+// add adds the counts of d2 to d.
+func (d *DroppedFeatures) add(d2 DroppedFeatures) {
+	d.NonFinite += d2.NonFinite
+	d.TrackingError += d2.TrackingError
+	d.RoundTrip += d2.RoundTrip
+}
+
+// FlowResult is the output of GenerateAverageFlowMapWithOptions.
+type FlowResult struct {
+	// Image is the dense flow visualization, as returned by GenerateAverageFlowMap.
+	Image image.Image
+	// Field is the flow field Image encodes, calibrated with
+	// FlowOptions.Units.
+	Field *FlowField
+	// Paths holds, when FlowOptions.RecordPaths is set, the position of each
+	// feature that survived the whole sequence in every frame, in full
+	// resolution pixel coordinates. Each path has one point per good image.
+	Paths [][]gocv.Point2f
+	// Skipped lists, in order, the frames left out under
+	// FlowOptions.SkipBadFrames.
+	Skipped []SkippedFrame
+	// Illumination holds, unless FlowOptions.Illumination is
+	// IlluminationIgnore, the change estimated between each pair of
+	// consecutive good frames, in order.
+	Illumination []IlluminationChange
+	// NonFinite is the number of features and flow field pixels dropped
+	// because their values were NaN or infinite; see FlowField.NonFinite.
+	NonFinite int
+	// Dropped counts the features dropped while tracking, over every
+	// frame pair.
+	Dropped DroppedFeatures
+	// Reseeded is the number of features detected on intermediate frames
+	// under FlowOptions.Reseed.
+	Reseeded int
+	// Retries lists, in order, the frame pairs tracked again under
+	// FlowOptions.Retry. They are also recorded in Provenance.
+	Retries []RetriedPair
+	// Occlusion marks, under FlowOptions.Occlusion.Detect, the pixels of
+	// Field that fail the forward-backward check with an alpha of 255. It
+	// has the size of Field; see OcclusionMask.
+	Occlusion *image.Alpha
+	// Provenance records the frames, options and code version that
+	// produced the result, for EncodePNG.
+	Provenance Provenance
+}
+
+// GenerateAverageFlowMapWithOptions is like GenerateAverageFlowMap but
+// returns a FlowResult and honours opts.
+func GenerateAverageFlowMapWithOptions(imagePaths []string, resolutionFactor int, opts FlowOptions) (FlowResult, error) {
+	return GenerateAverageFlowMapWithContext(context.Background(), imagePaths, resolutionFactor, opts)
+}
+
+// GenerateAverageFlowMapWithContext is like
+// GenerateAverageFlowMapWithOptions but stops when ctx is done. ctx is
+// checked before each frame and between the bands of rows of the
+// interpolation; once it is done, every matrix is released and ctx.Err()
+// returned.
+func GenerateAverageFlowMapWithContext(ctx context.Context, imagePaths []string, resolutionFactor int, opts FlowOptions) (FlowResult, error) {
+	if len(imagePaths) < 2 {
+		return FlowResult{}, fmt.Errorf("at least two images are required, but got %d", len(imagePaths))
+	}
+	prov := NewProvenance("GenerateAverageFlowMap", imagePaths, time.Now())
+	if opts.HashInputs {
+		prov.HashInputs()
 	}
-	defer prevMat.Close()
+	return averageFlow(ctx, imagePaths, len(imagePaths), func(i int) (gocv.Mat, error) {
+		return loadAndPrepImage(imagePaths[i], opts.Workspace)
+	}, resolutionFactor, opts, prov)
+}
 
-	initialPoints, err := findGoodFeatures(prevMat, imagePaths[0])
+// GenerateAverageFlowMapFromImages is like GenerateAverageFlowMap for frames
+// already decoded, such as generated ones, so they need not be written to
+// disk first.
+func GenerateAverageFlowMapFromImages(imgs []image.Image, resolutionFactor int) (image.Image, error) {
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, resolutionFactor, FlowOptions{})
 	if err != nil {
-		return gocv.NewMat(), gocv.NewMat(), err
+		return nil, err
 	}
+	return result.Image, nil
+}
 
-	currentPoints := initialPoints.Clone()
+// GenerateAverageFlowMapFromImagesWithOptions is like
+// GenerateAverageFlowMapWithOptions for frames already decoded. Frames are
+// named by their index in errors, and skipped frames have no Path. The
+// provenance record has no inputs.
+func GenerateAverageFlowMapFromImagesWithOptions(imgs []image.Image, resolutionFactor int, opts FlowOptions) (FlowResult, error) {
+	return GenerateAverageFlowMapFromImagesWithContext(context.Background(), imgs, resolutionFactor, opts)
+}
 
-	for i := 1; i < len(imagePaths); i++ {
-		nextMat, err := loadAndPrepImage(imagePaths[i])
+// GenerateAverageFlowMapFromImagesWithContext is like
+// GenerateAverageFlowMapFromImagesWithOptions but stops when ctx is done,
+// as GenerateAverageFlowMapWithContext does.
+func GenerateAverageFlowMapFromImagesWithContext(ctx context.Context, imgs []image.Image, resolutionFactor int, opts FlowOptions) (FlowResult, error) {
+	if len(imgs) < 2 {
+		return FlowResult{}, fmt.Errorf("at least two images are required, but got %d", len(imgs))
+	}
+	prov := NewProvenance("GenerateAverageFlowMapFromImages", nil, time.Now())
+	prov.Parameters = map[string]string{"images": strconv.Itoa(len(imgs))}
+	return averageFlow(ctx, nil, len(imgs), func(i int) (gocv.Mat, error) {
+		return prepImage(imgs[i], opts.Workspace)
+	}, resolutionFactor, opts, prov)
+}
+
+// averageFlow tracks the n frames returned by load through an Accumulator
+// and returns their flow, completing prov. paths names the frames, or is
+// nil for frames held in memory. It returns ctx.Err() once ctx is done.
+func averageFlow(ctx context.Context, paths []string, n int, load func(i int) (gocv.Mat, error), resolutionFactor int, opts FlowOptions, prov Provenance) (FlowResult, error) {
+	prov.Options = RecordOptions(opts)
+
+	acc := NewAccumulator(opts)
+	defer acc.Close()
+	var skipped []SkippedFrame
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return FlowResult{}, err
+		}
+		name, path := strconv.Itoa(i), ""
+		if paths != nil {
+			name, path = paths[i], paths[i]
+		}
+		mat, err := load(i)
+		if err == nil {
+			if err = acc.checkSize(mat); err != nil {
+				opts.Workspace.release(mat)
+			}
+		}
 		if err != nil {
-			return gocv.NewMat(), gocv.NewMat(), fmt.Errorf("failed to load image %s: %w", imagePaths[i], err)
+			if !opts.SkipBadFrames {
+				return FlowResult{}, acc.loadError(name, err)
+			}
+			skipped = append(skipped, SkippedFrame{Index: i, Path: path, Err: err})
+		} else {
+			if err := acc.addMat(mat, name, i); err != nil {
+				return FlowResult{}, err
+			}
 		}
-		defer nextMat.Close()
+		if opts.OnProgress != nil {
+			opts.OnProgress(i, n)
+		}
+	}
+	if acc.Frames() < 2 && len(skipped) > 0 {
+		return FlowResult{}, fmt.Errorf("at least two readable images are required, but %d of %d were skipped", len(skipped), n)
+	}
 
-		if currentPoints.Rows() == 0 {
-			return gocv.NewMat(), gocv.NewMat(), fmt.Errorf("all features lost before reaching frame %s", imagePaths[i])
+	field, err := acc.flowFieldContext(ctx, resolutionFactor)
+	if err != nil {
+		return FlowResult{}, err
+	}
+	prov.ResolutionFactor = field.ResolutionFactor
+	// Skipped frames still take up their frame interval.
+	field.Intervals = spannedIntervals(n, skipped)
+	var occlusion *image.Alpha
+	if opts.Occlusion.Detect {
+		if occlusion, err = backwardOcclusion(ctx, field, paths, n, load, field.ResolutionFactor, opts, prov); err != nil {
+			return FlowResult{}, err
 		}
+	}
+	img := field.image(opts.Encoding, opts.Workspace)
+	prov.Retries = acc.Retries()
+	prov.Finish()
+	return FlowResult{Image: img, Field: field, Paths: acc.Paths(), Skipped: skipped, Illumination: acc.Illumination(), NonFinite: field.NonFinite, Dropped: acc.Dropped(), Reseeded: acc.Reseeded(), Retries: acc.Retries(), Occlusion: occlusion, Provenance: prov}, nil
+}
 
-		newInitialPoints, newCurrentPoints, err := trackFeatures(prevMat, nextMat, initialPoints, currentPoints, imagePaths[i-1], imagePaths[i])
-		if err != nil {
-			return gocv.NewMat(), gocv.NewMat(), err
+// spannedIntervals returns the number of frame intervals between the first
+// and last of n frames that are not in skipped, which is ordered by index.
+func spannedIntervals(n int, skipped []SkippedFrame) int {
+	first, last := 0, n-1
+	for _, s := range skipped {
+		if s.Index == first {
+			first++
 		}
+	}
+	for i := len(skipped) - 1; i >= 0; i-- {
+		if skipped[i].Index == last {
+			last--
+		}
+	}
+	return last - first
+}
 
-		initialPoints.Close()
-		currentPoints.Close()
+// pointAt returns row i of an Nx2 CV32F point matrix.
+func pointAt(points gocv.Mat, i int) gocv.Point2f {
+	return gocv.Point2f{X: points.GetFloatAt(i, 0), Y: points.GetFloatAt(i, 1)}
+}
 
-		initialPoints = newInitialPoints
-		currentPoints = newCurrentPoints
-		prevMat = nextMat.Clone()
-	}
+// finitePoint reports whether both coordinates of pt are finite.
+func finitePoint(pt gocv.Point2f) bool {
+	return finite(float64(pt.X)) && finite(float64(pt.Y))
+}
 
-	return initialPoints, currentPoints, nil
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
 }
 
-// findGoodFeatures detects good features to track in an image.
-func findGoodFeatures(image gocv.Mat, imagePath string) (gocv.Mat, error) {
+// findGoodFeatures detects good features to track in an image, inside
+// region if it is set.
+func findGoodFeatures(img gocv.Mat, imagePath string, opts FeatureOptions, region *image.Alpha) (gocv.Mat, error) {
 	points := gocv.NewMat()
-	gocv.GoodFeaturesToTrack(image, &points, 100, 0.3, 7)
+	opts.detect(img, &points, opts.maxFeatures(), region)
 	if points.Rows() == 0 {
 		return gocv.NewMat(), fmt.Errorf("no features found to track in %s", imagePath)
 	}
@@ -93,7 +352,13 @@ func findGoodFeatures(image gocv.Mat, imagePath string) (gocv.Mat, error) {
 }
 
 // trackFeatures tracks features between two images using Lucas-Kanade.
-func trackFeatures(prevMat, nextMat, initialPoints, currentPoints gocv.Mat, prevImagePath, nextImagePath string) (gocv.Mat, gocv.Mat, error) {
+// It also returns, for each row of the returned matrices, the row of
+// currentPoints it was tracked from, and the features dropped: those LK
+// reported found at a NaN or infinite position, which it does on
+// degenerate pyramids, or with too large an error. Under
+// opts.ForwardBackward the features that fail the round trip back to
+// prevMat are dropped too.
+func trackFeatures(prevMat, nextMat, initialPoints, currentPoints gocv.Mat, prevImagePath, nextImagePath string, opts FeatureOptions) (gocv.Mat, gocv.Mat, []int, DroppedFeatures, error) {
 	nextPoints := gocv.NewMat()
 	status := gocv.NewMat()
 	errMat := gocv.NewMat()
@@ -101,25 +366,44 @@ func trackFeatures(prevMat, nextMat, initialPoints, currentPoints gocv.Mat, prev
 	defer status.Close()
 	defer errMat.Close()
 
-	gocv.CalcOpticalFlowPyrLK(prevMat, nextMat, currentPoints, nextPoints, &status, &errMat)
+	opts.track(prevMat, nextMat, currentPoints, nextPoints, &status, &errMat)
+	var roundTrip func(j int) bool
+	if opts.ForwardBackward {
+		roundTrip = roundTripCheck(prevMat, nextMat, currentPoints, nextPoints, opts)
+	}
 
 	newInitialRows := []int{}
+	var dropped DroppedFeatures
+	maxErr := opts.maxTrackingError()
 	for j := 0; j < status.Rows(); j++ {
-		if status.GetUCharAt(j, 0) == 1 {
-			newInitialRows = append(newInitialRows, j)
+		if status.GetUCharAt(j, 0) != 1 {
+			continue
+		}
+		if !finitePoint(pointAt(nextPoints, j)) {
+			dropped.NonFinite++
+			continue
+		}
+		if !(float64(errMat.GetFloatAt(j, 0)) <= maxErr) {
+			dropped.TrackingError++
+			continue
+		}
+		if roundTrip != nil && !roundTrip(j) {
+			dropped.RoundTrip++
+			continue
 		}
+		newInitialRows = append(newInitialRows, j)
 	}
-
 	if len(newInitialRows) == 0 {
-		return gocv.NewMat(), gocv.NewMat(), fmt.Errorf("all features lost tracking from %s to %s", prevImagePath, nextImagePath)
+		lost := &TooFewFeaturesError{Previous: prevImagePath, Frame: nextImagePath, Lost: currentPoints.Rows(), Tracked: currentPoints.Rows()}
+		return gocv.NewMat(), gocv.NewMat(), nil, dropped, lost
 	}
 
 	newInitialPoints := gocv.NewMatWithSize(len(newInitialRows), 2, gocv.MatTypeCV32F)
 	newCurrentPoints := gocv.NewMatWithSize(len(newInitialRows), 2, gocv.MatTypeCV32F)
 
 	for idx, srcIdx := range newInitialRows {
-		x1 := currentPoints.GetFloatAt(srcIdx, 0)
-		y1 := currentPoints.GetFloatAt(srcIdx, 1)
+		x1 := initialPoints.GetFloatAt(srcIdx, 0)
+		y1 := initialPoints.GetFloatAt(srcIdx, 1)
 		x2 := nextPoints.GetFloatAt(srcIdx, 0)
 		y2 := nextPoints.GetFloatAt(srcIdx, 1)
 
@@ -129,35 +413,96 @@ func trackFeatures(prevMat, nextMat, initialPoints, currentPoints gocv.Mat, prev
 		newCurrentPoints.SetFloatAt(idx, 1, y2)
 	}
 
-	return newInitialPoints, newCurrentPoints, nil
+	return newInitialPoints, newCurrentPoints, newInitialRows, dropped, nil
 }
 
-// loadAndPrepImage opens an image file, verifies its dimensions, and converts it to grayscale.
-func loadAndPrepImage(path string) (gocv.Mat, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return gocv.NewMat(), err
+// roundTripCheck tracks nextPoints in nextMat back to prevMat and returns
+// a function reporting whether row j returned to within
+// opts.MaxRoundTripError of row j of currentPoints.
+func roundTripCheck(prevMat, nextMat, currentPoints, nextPoints gocv.Mat, opts FeatureOptions) func(j int) bool {
+	backPoints := gocv.NewMat()
+	status := gocv.NewMat()
+	errMat := gocv.NewMat()
+	defer backPoints.Close()
+	defer status.Close()
+	defer errMat.Close()
+	opts.track(nextMat, prevMat, nextPoints, backPoints, &status, &errMat)
+
+	maxErr := opts.maxRoundTripError()
+	ok := make([]bool, status.Rows())
+	for j := range ok {
+		if status.GetUCharAt(j, 0) != 1 {
+			continue
+		}
+		start, back := pointAt(currentPoints, j), pointAt(backPoints, j)
+		ok[j] = math.Hypot(float64(back.X-start.X), float64(back.Y-start.Y)) <= maxErr
 	}
-	defer f.Close()
+	return func(j int) bool { return j < len(ok) && ok[j] }
+}
 
-	img, err := png.Decode(f)
+// loadAndPrepImage opens an image file and converts it to grayscale, into
+// a matrix from ws if ws is not nil.
+func loadAndPrepImage(path string, ws *Workspace) (gocv.Mat, error) {
+	img, err := decodePNGFile(path)
 	if err != nil {
-		return gocv.NewMat(), fmt.Errorf("failed to decode PNG image: %w", err)
+		return gocv.NewMat(), err
 	}
+	return prepImage(img, ws)
+}
 
+// prepImage converts a decoded image to grayscale, into a matrix from ws if
+// ws is not nil. It fails for an empty image. The conversion is OpenCV's,
+// with the luminance weights 0.299, 0.587 and 0.114 rounded rather than
+// truncated, on the premultiplied colors; a grayscale image is copied as
+// it is.
+func prepImage(img image.Image, ws *Workspace) (gocv.Mat, error) {
 	bounds := img.Bounds()
-	if bounds.Dx() != originalWidth || bounds.Dy() != originalHeight {
-		return gocv.NewMat(), fmt.Errorf("image is not %dx%d (got %dx%d)",
-			originalWidth, originalHeight, bounds.Dx(), bounds.Dy())
+	if bounds.Empty() {
+		return gocv.NewMat(), errors.New("image is empty")
 	}
+	grayMat := ws.mat(bounds.Dy(), bounds.Dx(), gocv.MatTypeCV8UC1)
 
-	grayMat := gocv.NewMatWithSize(bounds.Dy(), bounds.Dx(), gocv.MatTypeCV8UC1)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			gray := uint8(0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8))
-			grayMat.SetUCharAt(y, x, gray)
+	// gocv reads the pixels of images at the origin whose rows are
+	// contiguous directly, so others are copied into one first.
+	if gray, ok := img.(*image.Gray); ok {
+		if bounds.Min != (image.Point{}) || gray.Stride != bounds.Dx() {
+			gray = image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+			draw.Draw(gray, gray.Rect, img, bounds.Min, draw.Src)
 		}
+		src, err := gocv.ImageGrayToMatGray(gray)
+		if err != nil {
+			ws.release(grayMat)
+			return gocv.NewMat(), fmt.Errorf("failed to convert image: %w", err)
+		}
+		defer src.Close()
+		src.CopyTo(&grayMat)
+		return grayMat, nil
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) || rgba.Stride != 4*bounds.Dx() {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	}
+	src, err := gocv.ImageToMatRGBA(rgba)
+	if err != nil {
+		ws.release(grayMat)
+		return gocv.NewMat(), fmt.Errorf("failed to convert image: %w", err)
 	}
+	defer src.Close()
+	// ImageToMatRGBA leaves the channels in OpenCV's BGR order.
+	code := gocv.ColorBGRAToGray
+	if src.Channels() == 3 {
+		code = gocv.ColorBGRToGray
+	}
+	gocv.CvtColor(src, &grayMat, code)
 	return grayMat, nil
 }
+
+// checkFrameSize returns an error wrapping ErrFrameSize unless mat is width
+// pixels wide and height pixels high.
+func checkFrameSize(mat gocv.Mat, width, height int) error {
+	if mat.Cols() != width || mat.Rows() != height {
+		return fmt.Errorf("%w: got %dx%d, want %dx%d", ErrFrameSize, mat.Cols(), mat.Rows(), width, height)
+	}
+	return nil
+}
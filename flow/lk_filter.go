@@ -95,10 +95,9 @@ func motionVectorsToMats(vectors []motionVector) (gocv.Mat, gocv.Mat) {
 		// Return empty, valid Mats
 		return gocv.NewMat(), gocv.NewMat()
 	}
+	// The caller owns the returned Mats and is responsible for closing them.
 	prevPoints := gocv.NewMatWithSize(len(vectors), 2, gocv.MatTypeCV32F)
-	defer prevPoints.Close()
 	nextPoints := gocv.NewMatWithSize(len(vectors), 2, gocv.MatTypeCV32F)
-	defer nextPoints.Close()
 
 	for i, v := range vectors {
 		p0 := v.Point
@@ -154,8 +153,9 @@ func detectOutliers(vectors []motionVector, k int, stdDevThreshold float64) []mo
 			neighborsWithDist = append(neighborsWithDist, distVec{dist, otherVec})
 		}
 
-		// 2. Sort by distance to find the k-nearest
-		sort.Slice(neighborsWithDist, func(a, b int) bool {
+		// 2. Sort by distance to find the k-nearest. The stable sort breaks
+		// ties by input order so the chosen neighbours are reproducible.
+		sort.SliceStable(neighborsWithDist, func(a, b int) bool {
 			return neighborsWithDist[a].dist < neighborsWithDist[b].dist
 		})
 
@@ -247,6 +247,8 @@ func euclideanDistance(p1, p2 [2]float32) float64 {
 // --- Filter 2: Spatial Declustering (Grid Median) ---
 
 // declusterVectors takes a list of vectors and replaces dense clusters
+// with a single median vector per grid cell. The output is ordered by cell,
+// row by row (Y then X), so the same input always yields the same output.
 func declusterVectors(vectors []motionVector, gridCellSize int, minSamplesInCell int) []motionVector {
 	// 1. Bin vectors into grid cells
 	// map[grid_cell_coord] -> list_of_vectors_in_that_cell
@@ -263,10 +265,17 @@ func declusterVectors(vectors []motionVector, gridCellSize int, minSamplesInCell
 		grid[cell] = append(grid[cell], v)
 	}
 
-	// 2. Iterate over cells, calculate median, and create new vector list
+	// 2. Iterate over cells in a fixed order, calculate median, and create new vector list
 	var declusteredVectors []motionVector
 
-	for cell, vectorsInCell := range grid {
+	cells := make([]image.Point, 0, len(grid))
+	for cell := range grid {
+		cells = append(cells, cell)
+	}
+	sortPointsYX(cells)
+
+	for _, cell := range cells {
+		vectorsInCell := grid[cell]
 		// 3. Only keep cells with enough samples
 		if len(vectorsInCell) >= minSamplesInCell {
 			// 4. Calculate the median velocity for the cell
@@ -325,3 +334,13 @@ func findMedian(sortedValues []float64) float64 {
 	mid2 := sortedValues[n/2]
 	return (mid1 + mid2) / 2.0
 }
+
+// sortPointsYX sorts points row by row: by Y, then by X.
+func sortPointsYX(points []image.Point) {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Y != points[j].Y {
+			return points[i].Y < points[j].Y
+		}
+		return points[i].X < points[j].X
+	})
+}
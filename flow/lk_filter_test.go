@@ -0,0 +1,81 @@
+package flow
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// makeVectorMats builds CV_32FC2 point Mats and a status Mat for n random
+// vectors moving roughly by (3, -2) across a 256x256 area.
+func makeVectorMats(n int, seed int64) (gocv.Mat, gocv.Mat, gocv.Mat) {
+	rng := rand.New(rand.NewSource(seed))
+	prev := gocv.NewMatWithSize(n, 1, gocv.MatTypeCV32FC2)
+	next := gocv.NewMatWithSize(n, 1, gocv.MatTypeCV32FC2)
+	status := gocv.NewMatWithSize(n, 1, gocv.MatTypeCV8UC1)
+	for i := 0; i < n; i++ {
+		x := rng.Float32() * 256
+		y := rng.Float32() * 256
+		prev.SetFloatAt(i, 0, x)
+		prev.SetFloatAt(i, 1, y)
+		next.SetFloatAt(i, 0, x+3+rng.Float32()*0.2)
+		next.SetFloatAt(i, 1, y-2+rng.Float32()*0.2)
+		status.SetUCharAt(i, 0, 1)
+	}
+	return prev, next, status
+}
+
+func TestCleanseMotionVectorsMatDeterministic(t *testing.T) {
+	prev, next, status := makeVectorMats(400, 1)
+	defer prev.Close()
+	defer next.Close()
+	defer status.Close()
+
+	run := func() ([]byte, []byte) {
+		cleanPrev, cleanNext := CleanseMotionVectorsMat(prev, next, status, 5, 3.0, 32, 2)
+		defer cleanPrev.Close()
+		defer cleanNext.Close()
+		if cleanPrev.Rows() == 0 {
+			t.Fatal("Expected some vectors to survive cleansing")
+		}
+		return cleanPrev.ToBytes(), cleanNext.ToBytes()
+	}
+
+	prevA, nextA := run()
+	for i := 0; i < 5; i++ {
+		prevB, nextB := run()
+		if !bytes.Equal(prevA, prevB) || !bytes.Equal(nextA, nextB) {
+			t.Fatalf("Run %d produced different Mat contents", i+2)
+		}
+	}
+}
+
+func TestDeclusterVectorsOrdering(t *testing.T) {
+	var vectors []motionVector
+	// Two vectors in each of a 4x4 block of cells, inserted in reverse order.
+	for cy := 3; cy >= 0; cy-- {
+		for cx := 3; cx >= 0; cx-- {
+			for k := 0; k < 2; k++ {
+				vectors = append(vectors, motionVector{
+					Point:    [2]float32{float32(cx*10 + 2 + k), float32(cy*10 + 2 + k)},
+					Velocity: [2]float32{1, 1},
+				})
+			}
+		}
+	}
+
+	declustered := declusterVectors(vectors, 10, 2)
+	if len(declustered) != 16 {
+		t.Fatalf("Expected 16 declustered vectors, got %d", len(declustered))
+	}
+	for i := 1; i < len(declustered); i++ {
+		a := image.Pt(int(declustered[i-1].Point[0]), int(declustered[i-1].Point[1]))
+		b := image.Pt(int(declustered[i].Point[0]), int(declustered[i].Point[1]))
+		if a.Y > b.Y || (a.Y == b.Y && a.X >= b.X) {
+			t.Fatalf("Vectors not ordered by Y then X: %v before %v", a, b)
+		}
+	}
+}
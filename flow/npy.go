@@ -0,0 +1,238 @@
+package flow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"example/goflow/fileutil"
+)
+
+// ErrNpyFormat is returned for an NPY file that does not hold a (2, H, W)
+// array of little-endian floats in C order.
+var ErrNpyFormat = errors.New("flow: not a (2, H, W) float NPY array")
+
+// npyMagic starts every NPY file.
+const npyMagic = "\x93NUMPY"
+
+// MaxDecodedPixels is the most pixels a field read by DecodeNpy or
+// DecodeFlo may have, 8192 x 8192. The header is checked against it before
+// the field is allocated, so that a small corrupt file cannot exhaust
+// memory.
+const MaxDecodedPixels = 8192 * 8192
+
+// maxNpyHeaderLen bounds the header of an NPY file, which NumPy itself
+// keeps to a few hundred bytes for the arrays DecodeNpy reads.
+const maxNpyHeaderLen = 1 << 16
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// EncodeNpy writes field to w as an NPY version 1.0 array of shape
+// (2, H, W) in little-endian float64: the x displacements, then the y
+// displacements, row by row. This is the layout pysteps expects of a
+// motion field. The displacements are written per frame interval in
+// full-resolution pixels, as pysteps and other baselines measure them, and
+// no-data pixels are NaN.
+func EncodeNpy(w io.Writer, field *FlowField) error {
+	header := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (2, %d, %d), }", field.Height, field.Width)
+	// The magic, version and header length take 10 bytes, and the header
+	// is padded with spaces and ended by a newline so that the data starts
+	// at a multiple of 64 bytes.
+	padded := (10 + len(header) + 1 + 63) / 64 * 64
+	header += strings.Repeat(" ", padded-10-len(header)-1) + "\n"
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(npyMagic)
+	bw.Write([]byte{1, 0})
+	binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+	bw.WriteString(header)
+	var buf [8]byte
+	perFrame := field.perFrame()
+	for _, plane := range [][]float64{field.DX, field.DY} {
+		for i, v := range plane {
+			v *= perFrame
+			if !field.Valid[i] {
+				v = math.NaN()
+			}
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			bw.Write(buf[:])
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeNpy reads a field written by EncodeNpy, or any (2, H, W) NPY array
+// of little-endian float32 or float64 in C order, such as a pysteps motion
+// field. NaN pixels are no data. The field holds the displacements per frame
+// interval in full-resolution pixels, so its ResolutionFactor and Intervals
+// are 1; ReadNpy restores the resolution factor of a file written with a
+// provenance sidecar.
+func DecodeNpy(r io.Reader) (*FlowField, error) {
+	br := bufio.NewReader(r)
+	var preamble [8]byte
+	if _, err := io.ReadFull(br, preamble[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNpyFormat, err)
+	}
+	if string(preamble[:6]) != npyMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrNpyFormat)
+	}
+	var headerLen int
+	switch preamble[6] {
+	case 1:
+		var n uint16
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNpyFormat, err)
+		}
+		headerLen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNpyFormat, err)
+		}
+		headerLen = int(n)
+	default:
+		return nil, fmt.Errorf("%w: unsupported version %d.%d", ErrNpyFormat, preamble[6], preamble[7])
+	}
+	if headerLen > maxNpyHeaderLen {
+		return nil, fmt.Errorf("%w: %d byte header", ErrNpyFormat, headerLen)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNpyFormat, err)
+	}
+	width, height, size, err := parseNpyHeader(string(header))
+	if err != nil {
+		return nil, err
+	}
+	if width > 0 && height > MaxDecodedPixels/width {
+		return nil, fmt.Errorf("%w: %dx%d field, more than the %d pixels allowed", ErrNpyFormat, width, height, MaxDecodedPixels)
+	}
+
+	field := NewFlowField(width, height)
+	field.ResolutionFactor, field.Intervals = 1, 1
+	buf := make([]byte, size)
+	for _, plane := range [][]float64{field.DX, field.DY} {
+		for i := range plane {
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNpyFormat, err)
+			}
+			var v float64
+			if size == 4 {
+				v = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf)))
+			} else {
+				v = math.Float64frombits(binary.LittleEndian.Uint64(buf))
+			}
+			plane[i] = v
+		}
+	}
+	for i := range field.Valid {
+		if math.IsNaN(field.DX[i]) || math.IsNaN(field.DY[i]) {
+			field.DX[i], field.DY[i], field.Valid[i] = 0, 0, false
+		}
+	}
+	return field, nil
+}
+
+// parseNpyHeader returns the width, height and element size of the
+// (2, H, W) float array an NPY header describes.
+func parseNpyHeader(header string) (width, height, size int, err error) {
+	descr := npyDescr.FindStringSubmatch(header)
+	fortran := npyFortran.FindStringSubmatch(header)
+	shape := npyShape.FindStringSubmatch(header)
+	if descr == nil || fortran == nil || shape == nil {
+		return 0, 0, 0, fmt.Errorf("%w: malformed header %q", ErrNpyFormat, header)
+	}
+	switch descr[1] {
+	case "<f4":
+		size = 4
+	case "<f8":
+		size = 8
+	default:
+		return 0, 0, 0, fmt.Errorf("%w: unsupported dtype %s", ErrNpyFormat, descr[1])
+	}
+	if fortran[1] == "True" {
+		return 0, 0, 0, fmt.Errorf("%w: Fortran order", ErrNpyFormat)
+	}
+	var dims []int
+	for _, s := range strings.Split(shape[1], ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, 0, fmt.Errorf("%w: bad shape (%s)", ErrNpyFormat, shape[1])
+		}
+		dims = append(dims, n)
+	}
+	if len(dims) != 3 || dims[0] != 2 {
+		return 0, 0, 0, fmt.Errorf("%w: shape (%s)", ErrNpyFormat, shape[1])
+	}
+	return dims[2], dims[1], size, nil
+}
+
+// perFrame returns the factor that converts the displacements of f to
+// full-resolution pixels per frame interval.
+func (f *FlowField) perFrame() float64 {
+	_, intervals := f.scale()
+	return float64(f.unitScale()) / float64(intervals)
+}
+
+// restoreResolution gives field, decoded from the NPY or .flo file at path
+// in full-resolution pixels, the resolution factor recorded in the file's
+// provenance sidecar, converting its displacements back to field pixels.
+// A file without a sidecar is left at a factor of 1.
+func restoreResolution(path string, field *FlowField) error {
+	prov, err := ReadProvenance(path)
+	if errors.Is(err, ErrNoProvenance) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if prov.ResolutionFactor <= 1 {
+		return nil
+	}
+	field.ResolutionFactor = prov.ResolutionFactor
+	for i := range field.DX {
+		field.DX[i] /= float64(prov.ResolutionFactor)
+		field.DY[i] /= float64(prov.ResolutionFactor)
+	}
+	return nil
+}
+
+// WriteNpy writes field to the file at path with EncodeNpy, replacing it
+// atomically; see fileutil.WriteAtomic.
+func WriteNpy(path string, field *FlowField) error {
+	return fileutil.WriteAtomic(path, func(w io.Writer) error {
+		return EncodeNpy(w, field)
+	}, true)
+}
+
+// ReadNpy reads the field in the NPY file at path with DecodeNpy, with the
+// resolution factor recorded in its provenance sidecar, if it has one.
+func ReadNpy(path string) (*FlowField, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	field, err := DecodeNpy(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := restoreResolution(path, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
@@ -0,0 +1,182 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNpyRoundTrip(t *testing.T) {
+	const width, height = 37, 23
+	rng := rand.New(rand.NewSource(5))
+	field := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			field.Set(x, y, rng.NormFloat64()*50, rng.NormFloat64()*50)
+		}
+	}
+	field.SetNoData(3, 4)
+	field.SetNoData(36, 22)
+
+	path := filepath.Join(t.TempDir(), "flow.npy")
+	if err := WriteNpy(path, field); err != nil {
+		t.Fatalf("WriteNpy failed: %v", err)
+	}
+	got, err := ReadNpy(path)
+	if err != nil {
+		t.Fatalf("ReadNpy failed: %v", err)
+	}
+	if got.Width != width || got.Height != height {
+		t.Fatalf("Expected a %dx%d field, got %dx%d", width, height, got.Width, got.Height)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			wantX, wantY, wantValid := field.At(x, y)
+			dx, dy, valid := got.At(x, y)
+			if dx != wantX || dy != wantY || valid != wantValid {
+				t.Fatalf("Pixel (%d, %d): got (%v, %v, %v), want (%v, %v, %v)", x, y, dx, dy, valid, wantX, wantY, wantValid)
+			}
+		}
+	}
+}
+
+// TestNpyHeader checks the bytes of a small field against the NPY 1.0
+// layout: the magic and version, a header describing a (2, H, W) float64
+// array padded to 64 bytes, then the x plane and the y plane row by row.
+func TestNpyHeader(t *testing.T) {
+	field := NewFlowField(3, 2)
+	for i := range field.DX {
+		field.DX[i], field.DY[i] = float64(i), float64(-i)
+	}
+	field.SetNoData(1, 1)
+	var buf bytes.Buffer
+	if err := EncodeNpy(&buf, field); err != nil {
+		t.Fatalf("EncodeNpy failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	if string(data[:8]) != "\x93NUMPY\x01\x00" {
+		t.Fatalf("Expected the NPY 1.0 magic, got %q", data[:8])
+	}
+	headerLen := int(binary.LittleEndian.Uint16(data[8:10]))
+	offset := 10 + headerLen
+	if offset%64 != 0 {
+		t.Errorf("Expected the data to start at a multiple of 64 bytes, got %d", offset)
+	}
+	header := string(data[10:offset])
+	if !strings.HasPrefix(header, "{'descr': '<f8', 'fortran_order': False, 'shape': (2, 2, 3), }") || !strings.HasSuffix(header, " \n") {
+		t.Errorf("Unexpected header %q", header)
+	}
+	if len(data) != offset+2*6*8 {
+		t.Fatalf("Expected %d bytes, got %d", offset+2*6*8, len(data))
+	}
+	at := func(i int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[offset+8*i:]))
+	}
+	if at(1) != 1 || at(6+2) != -2 || !math.IsNaN(at(4)) || !math.IsNaN(at(6+4)) {
+		t.Errorf("Unexpected data: x[1] = %v, y[2] = %v, no data %v and %v", at(1), at(6+2), at(4), at(6+4))
+	}
+}
+
+// npyFile returns an NPY 1.0 file with the given header dictionary and
+// float32 data.
+func npyFile(dict string, values []float32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY\x01\x00")
+	header := dict + "\n"
+	binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	binary.Write(&buf, binary.LittleEndian, values)
+	return buf.Bytes()
+}
+
+func TestDecodeNpyFloat32(t *testing.T) {
+	data := npyFile("{'descr': '<f4', 'fortran_order': False, 'shape': (2, 1, 2), }", []float32{1.5, float32(math.NaN()), -2, 0.25})
+	field, err := DecodeNpy(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeNpy failed: %v", err)
+	}
+	if dx, dy, ok := field.At(0, 0); !ok || dx != 1.5 || dy != -2 {
+		t.Errorf("Expected (1.5, -2), got (%v, %v, %v)", dx, dy, ok)
+	}
+	if _, _, ok := field.At(1, 0); ok {
+		t.Error("Expected a NaN pixel to be no data")
+	}
+
+	for _, dict := range []string{
+		"{'descr': '<f4', 'fortran_order': False, 'shape': (3, 1, 2), }",
+		"{'descr': '<f4', 'fortran_order': True, 'shape': (2, 1, 2), }",
+		"{'descr': '<i4', 'fortran_order': False, 'shape': (2, 1, 2), }",
+		"{'descr': '<f4', 'fortran_order': False, 'shape': (2, 2), }",
+	} {
+		if _, err := DecodeNpy(bytes.NewReader(npyFile(dict, []float32{0, 0, 0, 0}))); !errors.Is(err, ErrNpyFormat) {
+			t.Errorf("%s: expected ErrNpyFormat, got %v", dict, err)
+		}
+	}
+	if _, err := DecodeNpy(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrNpyFormat) {
+		t.Errorf("Expected ErrNpyFormat for truncated data, got %v", err)
+	}
+
+	// Huge shapes and headers are rejected before anything is allocated.
+	for _, shape := range []string{"(2, 8193, 8192)", "(2, 4294967296, 4294967296)"} {
+		huge := npyFile("{'descr': '<f4', 'fortran_order': False, 'shape': "+shape+", }", nil)
+		if _, err := DecodeNpy(bytes.NewReader(huge)); !errors.Is(err, ErrNpyFormat) {
+			t.Errorf("Shape %s: expected ErrNpyFormat, got %v", shape, err)
+		}
+	}
+	v2 := []byte("\x93NUMPY\x02\x00\xff\xff\xff\xff")
+	if _, err := DecodeNpy(bytes.NewReader(v2)); !errors.Is(err, ErrNpyFormat) {
+		t.Errorf("Expected ErrNpyFormat for a 4 GiB header, got %v", err)
+	}
+}
+
+// TestNpyUnits checks that EncodeNpy writes the displacements per frame
+// interval in full-resolution pixels, and that ReadNpy restores the
+// resolution factor from a provenance sidecar.
+func TestNpyUnits(t *testing.T) {
+	field := NewFlowField(2, 1)
+	field.ResolutionFactor, field.Intervals = 4, 2
+	field.Set(0, 0, 3, -1)
+	field.Set(1, 0, 0.5, 2)
+
+	path := filepath.Join(t.TempDir(), "flow.npy")
+	if err := WriteNpy(path, field); err != nil {
+		t.Fatalf("WriteNpy failed: %v", err)
+	}
+	got, err := ReadNpy(path)
+	if err != nil {
+		t.Fatalf("ReadNpy failed: %v", err)
+	}
+	if got.ResolutionFactor != 1 || got.Intervals != 1 {
+		t.Errorf("Expected factor 1 over 1 interval without a sidecar, got %d over %d", got.ResolutionFactor, got.Intervals)
+	}
+	if dx, dy, _ := got.At(0, 0); dx != 6 || dy != -2 {
+		t.Errorf("Expected (6, -2) full-resolution pixels per frame, got (%v, %v)", dx, dy)
+	}
+
+	if err := os.WriteFile(SidecarPath(path), []byte(`{"operation": "test", "resolution_factor": 4}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ReadNpy(path)
+	if err != nil {
+		t.Fatalf("ReadNpy failed: %v", err)
+	}
+	if got.ResolutionFactor != 4 || got.Intervals != 1 {
+		t.Errorf("Expected factor 4 over 1 interval from the sidecar, got %d over %d", got.ResolutionFactor, got.Intervals)
+	}
+	for y := 0; y < field.Height; y++ {
+		for x := 0; x < field.Width; x++ {
+			wantX, wantY, _ := field.PixelDisplacementAt(x, y)
+			dx, dy, _ := got.PixelDisplacementAt(x, y)
+			if dx != wantX/2 || dy != wantY/2 {
+				t.Errorf("Pixel (%d, %d): got (%v, %v) full-resolution pixels per frame, want (%v, %v)", x, y, dx, dy, wantX/2, wantY/2)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultOcclusionThreshold is the forward-backward error, in
+// full-resolution pixels, beyond which a pixel is occluded when
+// OcclusionOptions.Threshold is zero.
+const DefaultOcclusionThreshold = 1.0
+
+// OcclusionOptions configures the detection of occluded pixels, set in
+// FlowOptions.Occlusion.
+type OcclusionOptions struct {
+	// Detect computes the flow over the frames in reverse order as well,
+	// which doubles the work, and returns in FlowResult.Occlusion the
+	// pixels where the two disagree; see OcclusionMask.
+	Detect bool
+	// Threshold is the largest forward-backward error, in full-resolution
+	// pixels, of a pixel that is not occluded; zero means
+	// DefaultOcclusionThreshold.
+	Threshold float64
+}
+
+func (o OcclusionOptions) threshold() float64 {
+	if o.Threshold == 0 {
+		return DefaultOcclusionThreshold
+	}
+	return o.Threshold
+}
+
+// OcclusionMask returns the pixels of forward, the flow from frame A to
+// frame B, that have no consistent match in B. backward is the flow from B
+// to A on the same grid. A pixel x with data is consistent if
+//
+//	|forward(x) + backward(x + forward(x))| <= threshold
+//
+// with backward interpolated bilinearly and threshold in full-resolution
+// pixels; zero means DefaultOcclusionThreshold. Pixels covered in B by
+// something moving differently, and those whose match falls outside B or
+// on no data, fail it. The mask has an alpha of 255 at those pixels and 0
+// elsewhere, including the pixels of forward without data.
+func OcclusionMask(forward, backward *FlowField, threshold float64) (*image.Alpha, error) {
+	if forward.Width != backward.Width || forward.Height != backward.Height {
+		return nil, fmt.Errorf("cannot check a %dx%d flow field against a %dx%d one", forward.Width, forward.Height, backward.Width, backward.Height)
+	}
+	if threshold < 0 {
+		return nil, fmt.Errorf("occlusion threshold must not be negative, got %v", threshold)
+	}
+	if threshold == 0 {
+		threshold = DefaultOcclusionThreshold
+	}
+	forward, backward = forward.fieldPixels(), backward.fieldPixels()
+	rf, _ := forward.scale()
+	limit := threshold / float64(rf)
+	mask := image.NewAlpha(image.Rect(0, 0, forward.Width, forward.Height))
+	for y := 0; y < forward.Height; y++ {
+		for x := 0; x < forward.Width; x++ {
+			dx, dy, valid := forward.At(x, y)
+			if !valid {
+				continue
+			}
+			bx, by, ok := backward.sample(float64(x)+dx, float64(y)+dy)
+			if !ok || math.Hypot(dx+bx, dy+by) > limit {
+				mask.SetAlpha(x, y, color.Alpha{A: 255})
+			}
+		}
+	}
+	return mask, nil
+}
+
+// occluded reports whether pixel (x, y) of a width x height grid falls on
+// an occluded pixel of mask, which may have any size and is sampled at the
+// nearest corresponding pixel like a no-data mask. A nil mask marks
+// nothing.
+func occluded(mask *image.Alpha, x, y, width, height int) bool {
+	if mask == nil {
+		return false
+	}
+	bounds := mask.Bounds()
+	p := image.Pt(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height)
+	return p.In(bounds) && mask.AlphaAt(p.X, p.Y).A != 0
+}
+
+// backwardOcclusion computes the flow over the same frames as averageFlow
+// in reverse order, at the resolutionFactor the forward field was computed
+// at, and returns the OcclusionMask of forward against it.
+func backwardOcclusion(ctx context.Context, forward *FlowField, paths []string, n int, load func(i int) (gocv.Mat, error), resolutionFactor int, opts FlowOptions, prov Provenance) (*image.Alpha, error) {
+	var reversed []string
+	if paths != nil {
+		reversed = make([]string, n)
+		for i := range reversed {
+			reversed[i] = paths[n-1-i]
+		}
+	}
+	// The forward result may hold the workspace's buffers, so the backward
+	// pass must not share them.
+	threshold := opts.Occlusion.threshold()
+	opts.Occlusion, opts.RecordPaths, opts.Workspace, opts.OnProgress = OcclusionOptions{}, false, nil, nil
+	backward, err := averageFlow(ctx, reversed, n, func(i int) (gocv.Mat, error) {
+		return load(n - 1 - i)
+	}, resolutionFactor, opts, prov)
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the backward flow: %w", err)
+	}
+	return OcclusionMask(forward, backward.Field, threshold)
+}
@@ -0,0 +1,176 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// TestOcclusionDetectCoveredBackground moves a square over a static
+// background and checks that the strip of background it covers is marked
+// as occluded, and the rest of the background is not.
+func TestOcclusionDetectCoveredBackground(t *testing.T) {
+	const rf = 2
+	// The square spans x 70 to 130 and y 98 to 158, and covers x 130 to
+	// 142 in the second frame.
+	spec := synth.MotionSpec{Blobs: []synth.Blob{{
+		Shape:  synth.BlobSquare,
+		Center: synth.Point{X: 100, Y: 128},
+		Size:   30,
+		Motion: synth.Motion{Translate: synth.Point{X: 12}},
+	}}}
+	frames, _ := synth.GenerateSequence(spec, 2, 256, 256)
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(frames, rf, FlowOptions{
+		Method:    MethodDense,
+		Farneback: FarnebackOptions{WinSize: 9},
+		Occlusion: OcclusionOptions{Detect: true},
+	})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	mask := result.Occlusion
+	if mask == nil || mask.Bounds() != image.Rect(0, 0, result.Field.Width, result.Field.Height) {
+		t.Fatalf("Expected an occlusion mask of the field's size, got %v", mask)
+	}
+	// occludedShare returns the share of the full-resolution rectangle r
+	// that is marked as occluded.
+	occludedShare := func(r image.Rectangle) float64 {
+		n := 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if mask.AlphaAt(x/rf, y/rf).A != 0 {
+					n++
+				}
+			}
+		}
+		return float64(n) / float64(r.Dx()*r.Dy())
+	}
+	if got := occludedShare(image.Rect(132, 100, 142, 156)); got < 0.8 {
+		t.Errorf("Expected the covered background to be occluded, got %.2f of it", got)
+	}
+	if got := occludedShare(image.Rect(75, 100, 125, 156)); got > 0.15 {
+		t.Errorf("Expected the square itself to be mostly consistent, got %.2f occluded", got)
+	}
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 256, 80), image.Rect(0, 180, 256, 256)} {
+		if got := occludedShare(r); got != 0 {
+			t.Errorf("Expected no occlusion in the background %v, got %.2f", r, got)
+		}
+	}
+	if got := result.Provenance.Options.OcclusionThreshold; got != DefaultOcclusionThreshold {
+		t.Errorf("Expected the provenance to record the threshold %v, got %v", DefaultOcclusionThreshold, got)
+	}
+}
+
+// TestOcclusionMask checks the forward-backward test on fields built by
+// hand.
+func TestOcclusionMask(t *testing.T) {
+	const w, h = 8, 4
+	forward, backward := NewFlowField(w, h), NewFlowField(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			forward.Set(x, y, 2, 0)
+			backward.Set(x, y, -2, 0)
+		}
+	}
+	// What moves to column 4 there comes back from it by only one pixel.
+	backward.Set(4, 1, -1, 0)
+	forward.SetNoData(0, 3)
+
+	mask, err := OcclusionMask(forward, backward, 0.5)
+	if err != nil {
+		t.Fatalf("OcclusionMask failed: %v", err)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Columns 6 and 7 move out of the frame.
+			want := x >= 6 || x == 2 && y == 1
+			if got := mask.AlphaAt(x, y).A != 0; got != want {
+				t.Errorf("Pixel (%d, %d): expected occluded %v, got %v", x, y, want, got)
+			}
+		}
+	}
+	// A looser threshold accepts the pixel that is one off.
+	if mask, _ := OcclusionMask(forward, backward, 1.5); mask.AlphaAt(2, 1).A != 0 {
+		t.Error("Expected a 1 pixel error to pass a 1.5 pixel threshold")
+	}
+	// The threshold is in full-resolution pixels.
+	forward.ResolutionFactor = 4
+	if mask, _ := OcclusionMask(forward, backward, 2); mask.AlphaAt(2, 1).A == 0 {
+		t.Error("Expected a 1 pixel error at factor 4 to be 4 full-resolution pixels, over a threshold of 2")
+	} else if mask, _ := OcclusionMask(forward, backward, 5); mask.AlphaAt(2, 1).A != 0 {
+		t.Error("Expected 4 full-resolution pixels to pass a threshold of 5")
+	}
+
+	if _, err := OcclusionMask(forward, NewFlowField(w, h+1), 0); err == nil {
+		t.Error("Expected an error for fields of different sizes")
+	}
+	if _, err := OcclusionMask(forward, backward, -1); err == nil {
+		t.Error("Expected an error for a negative threshold")
+	}
+}
+
+// TestForwardTransformOcclusion checks that occluded pixels keep their
+// input value, or take the fill frame's, instead of being warped.
+func TestForwardTransformOcclusion(t *testing.T) {
+	const width, height, shift = 32, 8, 4
+	dir := t.TempDir()
+	field := NewFlowField(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			field.Set(x, y, shift, 0)
+		}
+	}
+	flowPath := filepath.Join(dir, "flow.png")
+	writePNG(t, flowPath, field.Image())
+
+	input := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill := image.NewRGBA(input.Rect)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			input.Set(x, y, color.RGBA{R: uint8(8 * x), G: 100, B: 100, A: 255})
+			fill.Set(x, y, color.RGBA{R: 7, G: 7, B: 7, A: 255})
+		}
+	}
+	inputPath := filepath.Join(dir, "input.png")
+	writePNG(t, inputPath, input)
+
+	// The mask is at half the flow map's size, covering x 16 to 24.
+	mask := image.NewAlpha(image.Rect(0, 0, width/2, height/2))
+	for y := 0; y < height/2; y++ {
+		for x := 8; x < 12; x++ {
+			mask.SetAlpha(x, y, color.Alpha{A: 255})
+		}
+	}
+	red := func(img image.Image, x int) uint8 {
+		r, _, _, _ := img.At(x, 3).RGBA()
+		return uint8(r >> 8)
+	}
+
+	out, err := ForwardTransformWithOptions(inputPath, flowPath, 1, ForwardOptions{Occlusion: mask})
+	if err != nil {
+		t.Fatalf("ForwardTransformWithOptions failed: %v", err)
+	}
+	if got := red(out, 10); got != 8*(10-shift) {
+		t.Errorf("Expected pixel 10 to be warped from %d, got red %d", 10-shift, got)
+	}
+	if got := red(out, 20); got != 8*20 {
+		t.Errorf("Expected occluded pixel 20 to keep its input value %d, got %d", 8*20, got)
+	}
+
+	out, err = ForwardTransformWithOptions(inputPath, flowPath, 1, ForwardOptions{Occlusion: mask, Fill: fill})
+	if err != nil {
+		t.Fatalf("ForwardTransformWithOptions failed: %v", err)
+	}
+	if got := red(out, 20); got != 7 {
+		t.Errorf("Expected occluded pixel 20 to come from the fill frame, got red %d", got)
+	}
+	if got := red(out, 26); got != 8*(26-shift) {
+		t.Errorf("Expected pixel 26 to be warped, got red %d", got)
+	}
+
+	if _, err := ForwardTransformWithOptions(inputPath, flowPath, 1, ForwardOptions{Fill: image.NewRGBA(image.Rect(0, 0, 4, 4))}); err == nil {
+		t.Error("Expected an error for a fill frame of another size")
+	}
+}
@@ -0,0 +1,105 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// PathColoring selects how DrawFeaturePaths colors each path.
+type PathColoring int
+
+const (
+	// ColorByIndex gives each path a distinct color derived from its index.
+	ColorByIndex PathColoring = iota
+	// ColorByDisplacement colors each path on a blue-to-red ramp by the
+	// distance between its first and last point.
+	ColorByDisplacement
+)
+
+// DrawOptions configures DrawFeaturePaths.
+type DrawOptions struct {
+	Coloring PathColoring
+	// Thickness is the line thickness in pixels. Zero means 1.
+	Thickness int
+	// MaxDisplacement is the displacement in pixels that maps to the red end
+	// of the ramp for ColorByDisplacement. Zero uses the largest displacement
+	// among the paths.
+	MaxDisplacement float64
+}
+
+// DrawFeaturePaths draws each path as a polyline over a copy of background
+// (a "spaghetti plot"). A single-channel background is converted to BGR
+// first. The background itself is not modified.
+func DrawFeaturePaths(background gocv.Mat, paths [][]gocv.Point2f, opts DrawOptions) gocv.Mat {
+	img := gocv.NewMat()
+	if background.Channels() == 1 {
+		gocv.CvtColor(background, &img, gocv.ColorGrayToBGR)
+	} else {
+		background.CopyTo(&img)
+	}
+
+	thickness := opts.Thickness
+	if thickness <= 0 {
+		thickness = 1
+	}
+
+	maxDisplacement := opts.MaxDisplacement
+	if opts.Coloring == ColorByDisplacement && maxDisplacement <= 0 {
+		for _, path := range paths {
+			maxDisplacement = math.Max(maxDisplacement, pathDisplacement(path))
+		}
+	}
+
+	for i, path := range paths {
+		if len(path) < 2 {
+			continue
+		}
+
+		var c color.RGBA
+		switch opts.Coloring {
+		case ColorByDisplacement:
+			t := 0.0
+			if maxDisplacement > 0 {
+				t = pathDisplacement(path) / maxDisplacement
+			}
+			c = rampColor(t)
+		default:
+			// The golden ratio spreads consecutive indices around the ramp.
+			_, frac := math.Modf(float64(i) * 0.618033988749895)
+			c = rampColor(frac)
+		}
+
+		for j := 0; j < len(path)-1; j++ {
+			p1 := image.Pt(int(math.Round(float64(path[j].X))), int(math.Round(float64(path[j].Y))))
+			p2 := image.Pt(int(math.Round(float64(path[j+1].X))), int(math.Round(float64(path[j+1].Y))))
+			gocv.Line(&img, p1, p2, c, thickness)
+		}
+	}
+
+	return img
+}
+
+// pathDisplacement returns the straight-line distance between the first and
+// last point of a path.
+func pathDisplacement(path []gocv.Point2f) float64 {
+	if len(path) < 2 {
+		return 0
+	}
+	first, last := path[0], path[len(path)-1]
+	return math.Hypot(float64(last.X-first.X), float64(last.Y-first.Y))
+}
+
+// rampColor maps t in [0, 1] onto a blue-green-red ramp. Values outside the
+// range are clamped.
+func rampColor(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	if t < 0.5 {
+		s := t / 0.5
+		return color.RGBA{R: 0, G: uint8(255 * s), B: uint8(255 * (1 - s)), A: 255}
+	}
+	s := (t - 0.5) / 0.5
+	return color.RGBA{R: uint8(255 * s), G: uint8(255 * (1 - s)), B: 0, A: 255}
+}
@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"math"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// TestFeaturePathsUniformShift checks that with a known uniform shift every
+// recorded path is a segment parallel to the shift and of the same length.
+func TestFeaturePathsUniformShift(t *testing.T) {
+	imagePaths := []string{"../test_data/centered.png", "../test_data/shifted.png"}
+	const shiftX, shiftY = 20.0, 10.0
+	shiftLen := math.Hypot(shiftX, shiftY)
+
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, 4, FlowOptions{RecordPaths: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+	}
+	if len(result.Paths) == 0 {
+		t.Fatal("Expected at least one feature path")
+	}
+
+	for i, path := range result.Paths {
+		if len(path) != len(imagePaths) {
+			t.Fatalf("Path %d: expected %d points, got %d", i, len(imagePaths), len(path))
+		}
+		dx := float64(path[1].X - path[0].X)
+		dy := float64(path[1].Y - path[0].Y)
+		if length := math.Hypot(dx, dy); math.Abs(length-shiftLen) > 1.0 {
+			t.Errorf("Path %d: expected length %.2f, got %.2f", i, shiftLen, length)
+		}
+		// The cross product with the shift, normalized by the shift length,
+		// is the perpendicular deviation in pixels.
+		if cross := (dx*shiftY - dy*shiftX) / shiftLen; math.Abs(cross) > 1.0 {
+			t.Errorf("Path %d: (%.2f, %.2f) is not parallel to the shift", i, dx, dy)
+		}
+	}
+
+	// Without the option no paths are kept.
+	result, err = GenerateAverageFlowMapWithOptions(imagePaths, 4, FlowOptions{})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+	}
+	if result.Paths != nil {
+		t.Errorf("Expected no paths without RecordPaths, got %d", len(result.Paths))
+	}
+}
+
+func TestDrawFeaturePaths(t *testing.T) {
+	background := gocv.NewMatWithSize(40, 40, gocv.MatTypeCV8UC1)
+	defer background.Close()
+	background.SetTo(gocv.NewScalar(0, 0, 0, 0))
+
+	paths := [][]gocv.Point2f{
+		{{X: 5, Y: 10}, {X: 15, Y: 10}, {X: 25, Y: 10}},
+		{{X: 5, Y: 30}, {X: 10, Y: 30}},
+	}
+	for _, coloring := range []PathColoring{ColorByIndex, ColorByDisplacement} {
+		plot := DrawFeaturePaths(background, paths, DrawOptions{Coloring: coloring})
+		if plot.Channels() != 3 || plot.Rows() != 40 || plot.Cols() != 40 {
+			t.Fatalf("Expected a 40x40 BGR plot, got %dx%d with %d channels", plot.Cols(), plot.Rows(), plot.Channels())
+		}
+		for _, pt := range []struct{ x, y int }{{20, 10}, {7, 30}} {
+			v := plot.GetVecbAt(pt.y, pt.x)
+			if v[0] == 0 && v[1] == 0 && v[2] == 0 {
+				t.Errorf("Coloring %d: expected path pixel at (%d, %d)", coloring, pt.x, pt.y)
+			}
+		}
+		if v := plot.GetVecbAt(20, 20); v[0] != 0 || v[1] != 0 || v[2] != 0 {
+			t.Errorf("Coloring %d: expected background at (20, 20), got %v", coloring, v)
+		}
+		plot.Close()
+	}
+	if background.GetUCharAt(10, 20) != 0 {
+		t.Error("Expected the background to be left unchanged")
+	}
+}
@@ -0,0 +1,489 @@
+package flow
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ErrNoProvenance is returned by ReadProvenance for an artifact that has no
+// provenance record.
+var ErrNoProvenance = errors.New("flow: artifact has no provenance record")
+
+// ProvenanceKeyword is the keyword of the PNG iTXt chunk that holds the
+// provenance record.
+const ProvenanceKeyword = "goflow-provenance"
+
+// modulePath is the module the flow package belongs to.
+const modulePath = "example/goflow"
+
+// Provenance records how an artifact was produced, so that a flow map can be
+// traced back to its frames, parameters and code long after it was made.
+type Provenance struct {
+	// Operation is the function that produced the artifact, such as
+	// "GenerateAverageFlowMap".
+	Operation string `json:"operation"`
+	// Inputs are the files read, in order.
+	Inputs []InputFile `json:"inputs"`
+	// ResolutionFactor is the downscaling factor of the flow, if any.
+	ResolutionFactor int `json:"resolution_factor,omitempty"`
+	// Options are the flow options used, if any.
+	Options *ProvenanceOptions `json:"options,omitempty"`
+	// Parameters holds the other settings of operations that take no
+	// FlowOptions, by name.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Retries are the frame pairs tracked again under FlowOptions.Retry,
+	// and the LK parameters used for them.
+	Retries []RetriedPair `json:"retries,omitempty"`
+	// Version is the version of this module that produced the artifact: its
+	// module version, or the VCS revision of a development build.
+	Version string `json:"version"`
+	// Created is when the operation started, and Duration how long it took.
+	Created  time.Time     `json:"created"`
+	Duration time.Duration `json:"duration_ns"`
+
+	start time.Time // Created with its monotonic clock reading
+}
+
+// InputFile identifies an input file by size and modification time, and by
+// content once hashed.
+type InputFile struct {
+	Path string `json:"path"`
+	// SHA256 is the hex SHA-256 of the file, empty unless it was hashed.
+	SHA256  string    `json:"sha256,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Err is why the file could not be read, if it could not.
+	Err string `json:"error,omitempty"`
+}
+
+// ProvenanceOptions is the record of a FlowOptions. The no-data and region
+// masks and the dense confidence map are recorded only by whether they were
+// set.
+type ProvenanceOptions struct {
+	Method             string  `json:"method"`
+	Illumination       string  `json:"illumination"`
+	SkipBadFrames      bool    `json:"skip_bad_frames,omitempty"`
+	RecordPaths        bool    `json:"record_paths,omitempty"`
+	NoDataMask         bool    `json:"no_data_mask,omitempty"`
+	RegionMask         bool    `json:"region_mask,omitempty"`
+	DenseConfidence    float64 `json:"dense_confidence,omitempty"`
+	DenseConfidenceMap bool    `json:"dense_confidence_map,omitempty"`
+	SmoothRadius       int     `json:"smooth_radius,omitempty"`
+	// PixelSize is in meters and FrameInterval in nanoseconds, as in Units.
+	PixelSize     float64       `json:"pixel_size,omitempty"`
+	FrameInterval time.Duration `json:"frame_interval_ns,omitempty"`
+	// FarnebackPyrScale through FarnebackPolySigma are
+	// FlowOptions.Farneback, zero for the defaults.
+	FarnebackPyrScale   float64 `json:"farneback_pyr_scale,omitempty"`
+	FarnebackLevels     int     `json:"farneback_levels,omitempty"`
+	FarnebackWinSize    int     `json:"farneback_win_size,omitempty"`
+	FarnebackIterations int     `json:"farneback_iterations,omitempty"`
+	FarnebackPolyN      int     `json:"farneback_poly_n,omitempty"`
+	FarnebackPolySigma  float64 `json:"farneback_poly_sigma,omitempty"`
+	// MaxFeatures through PyramidLevels are FlowOptions.Features, zero for
+	// the defaults.
+	MaxFeatures   int     `json:"max_features,omitempty"`
+	QualityLevel  float64 `json:"quality_level,omitempty"`
+	MinDistance   float64 `json:"min_distance,omitempty"`
+	WindowSize    int     `json:"window_size,omitempty"`
+	PyramidLevels int     `json:"pyramid_levels,omitempty"`
+	// ForwardBackward and MaxRoundTripError are the forward-backward
+	// check of FlowOptions.Features.
+	ForwardBackward   bool    `json:"forward_backward,omitempty"`
+	MaxRoundTripError float64 `json:"max_round_trip_error,omitempty"`
+	// MaxTrackingError is FlowOptions.Features.MaxTrackingError, zero for
+	// no limit.
+	MaxTrackingError float64 `json:"max_tracking_error,omitempty"`
+	// Seeding, GridSpacing and MinVariance are the grid seeding of
+	// FlowOptions.Features, empty and zero under SeedCorners.
+	Seeding     string  `json:"seeding,omitempty"`
+	GridSpacing int     `json:"grid_spacing,omitempty"`
+	MinVariance float64 `json:"min_variance,omitempty"`
+	// AspectRatio is FlowOptions.Interpolation.AspectRatio, zero unless
+	// the interpolation is anisotropic.
+	AspectRatio float64 `json:"aspect_ratio,omitempty"`
+	// IDWRadius, IDWPower and NearestFallback are the rest of
+	// FlowOptions.Interpolation; a zero radius or power is the default
+	// and a negative radius is unlimited.
+	IDWRadius       float64 `json:"idw_radius,omitempty"`
+	IDWPower        float64 `json:"idw_power,omitempty"`
+	NearestFallback bool    `json:"nearest_fallback,omitempty"`
+	// Interpolation is the name of FlowOptions.Interpolation.Mode, empty
+	// for the default inverse distance weighting.
+	Interpolation string `json:"interpolation,omitempty"`
+	// ReseedBelow and ReseedMaxFeatures are FlowOptions.Reseed.
+	ReseedBelow       int `json:"reseed_below,omitempty"`
+	ReseedMaxFeatures int `json:"reseed_max_features,omitempty"`
+	// RetryBelow and RetryLadder are FlowOptions.Retry, with the ladder
+	// used written as window size x pyramid levels, such as "31x4,41x4".
+	RetryBelow  float64 `json:"retry_below,omitempty"`
+	RetryLadder string  `json:"retry_ladder,omitempty"`
+	// PixelBudget is FlowOptions.PixelBudget; the factor it chose is the
+	// provenance's ResolutionFactor.
+	PixelBudget int `json:"pixel_budget,omitempty"`
+	// EncodingScale, EncodingMidLevel and EncodingDepth are
+	// FlowOptions.Encoding, zero for the defaults; a flow map must be
+	// decoded with them.
+	EncodingScale    float64 `json:"encoding_scale,omitempty"`
+	EncodingMidLevel uint16  `json:"encoding_mid_level,omitempty"`
+	EncodingDepth    int     `json:"encoding_depth,omitempty"`
+	// OcclusionThreshold is the forward-backward threshold of
+	// FlowOptions.Occlusion, zero unless it is detected.
+	OcclusionThreshold float64 `json:"occlusion_threshold,omitempty"`
+}
+
+// RecordOptions returns the provenance record of opts.
+func RecordOptions(opts FlowOptions) *ProvenanceOptions {
+	return &ProvenanceOptions{
+		Method:              opts.Method.String(),
+		Illumination:        opts.Illumination.String(),
+		SkipBadFrames:       opts.SkipBadFrames,
+		RecordPaths:         opts.RecordPaths,
+		NoDataMask:          opts.NoDataMask != nil,
+		RegionMask:          opts.RegionMask != nil,
+		DenseConfidence:     opts.Fuse.DenseConfidence,
+		DenseConfidenceMap:  opts.Fuse.DenseConfidenceMap != nil,
+		SmoothRadius:        opts.Fuse.SmoothRadius,
+		PixelSize:           opts.Units.PixelSize,
+		FrameInterval:       opts.Units.FrameInterval,
+		FarnebackPyrScale:   opts.Farneback.PyrScale,
+		FarnebackLevels:     opts.Farneback.Levels,
+		FarnebackWinSize:    opts.Farneback.WinSize,
+		FarnebackIterations: opts.Farneback.Iterations,
+		FarnebackPolyN:      opts.Farneback.PolyN,
+		FarnebackPolySigma:  opts.Farneback.PolySigma,
+		MaxFeatures:         opts.Features.MaxFeatures,
+		QualityLevel:        opts.Features.QualityLevel,
+		MinDistance:         opts.Features.MinDistance,
+		WindowSize:          opts.Features.WindowSize,
+		PyramidLevels:       opts.Features.PyramidLevels,
+		ForwardBackward:     opts.Features.ForwardBackward,
+		MaxRoundTripError:   opts.Features.MaxRoundTripError,
+		MaxTrackingError:    opts.Features.MaxTrackingError,
+		Seeding:             recordedSeeding(opts.Features),
+		GridSpacing:         recordedGridSpacing(opts.Features),
+		MinVariance:         recordedMinVariance(opts.Features),
+		AspectRatio:         recordedAspectRatio(opts.Interpolation),
+		IDWRadius:           opts.Interpolation.Radius,
+		IDWPower:            opts.Interpolation.Power,
+		NearestFallback:     opts.Interpolation.NearestFallback,
+		Interpolation:       recordedInterpolation(opts.Interpolation),
+		ReseedBelow:         opts.Reseed.Below,
+		ReseedMaxFeatures:   opts.Reseed.MaxFeatures,
+		RetryBelow:          opts.Retry.Below,
+		RetryLadder:         recordedRetryLadder(opts.Retry),
+		PixelBudget:         opts.PixelBudget,
+		EncodingScale:       opts.Encoding.Scale,
+		EncodingMidLevel:    opts.Encoding.MidLevel,
+		EncodingDepth:       opts.Encoding.Depth,
+		OcclusionThreshold:  recordedOcclusionThreshold(opts.Occlusion),
+	}
+}
+
+// recordedSeeding returns the name of opts.Seeding, or "" for the default
+// SeedCorners, whose provenance predates grid seeding.
+func recordedSeeding(opts FeatureOptions) string {
+	if opts.Seeding == SeedCorners {
+		return ""
+	}
+	return opts.Seeding.String()
+}
+
+// recordedGridSpacing returns the grid spacing opts uses, or zero unless it
+// seeds on a grid.
+func recordedGridSpacing(opts FeatureOptions) int {
+	if opts.Seeding != SeedGrid {
+		return 0
+	}
+	return opts.gridSpacing()
+}
+
+// recordedMinVariance returns the minimum cell variance opts uses, or zero
+// unless it seeds on a grid.
+func recordedMinVariance(opts FeatureOptions) float64 {
+	if opts.Seeding != SeedGrid {
+		return 0
+	}
+	return opts.MinVariance
+}
+
+// recordedOcclusionThreshold returns the threshold of the occlusion check
+// opts selects, or zero if occlusion is not detected.
+func recordedOcclusionThreshold(opts OcclusionOptions) float64 {
+	if !opts.Detect {
+		return 0
+	}
+	return opts.threshold()
+}
+
+// recordedRetryLadder returns the ladder the retry policy opts uses, or ""
+// if it is off. Zero parameters of a step are recorded as zero.
+func recordedRetryLadder(opts RetryPolicy) string {
+	if opts.Below <= 0 {
+		return ""
+	}
+	steps := make([]string, len(opts.ladder()))
+	for i, step := range opts.ladder() {
+		steps[i] = fmt.Sprintf("%dx%d", step.WindowSize, step.PyramidLevels)
+	}
+	return strings.Join(steps, ",")
+}
+
+// recordedInterpolation returns the name of opts.Mode, or "" for the
+// default InterpolationIDW, whose provenance predates the modes.
+func recordedInterpolation(opts InterpolationOptions) string {
+	if opts.Mode == InterpolationIDW {
+		return ""
+	}
+	return opts.Mode.String()
+}
+
+// recordedAspectRatio returns the aspect ratio the interpolation opts
+// select, or zero for isotropic interpolation.
+func recordedAspectRatio(opts InterpolationOptions) float64 {
+	if !opts.Anisotropic {
+		return 0
+	}
+	return opts.aspectRatio()
+}
+
+// NewProvenance starts the provenance record of an operation that began at
+// start and reads inputs, recording the size and modification time of each.
+// Call HashInputs to record their content too, and Finish once the
+// operation is done.
+func NewProvenance(operation string, inputs []string, start time.Time) Provenance {
+	p := Provenance{Operation: operation, Version: ModuleVersion(), Created: start.UTC(), start: start}
+	for _, path := range inputs {
+		p.Inputs = append(p.Inputs, describeInput(path))
+	}
+	return p
+}
+
+// Finish sets Duration to the time since the operation started.
+func (p *Provenance) Finish() {
+	p.Duration = time.Since(p.start)
+}
+
+// HashInputs sets the SHA256 of every input that could be read, reading
+// each of them in full.
+func (p *Provenance) HashInputs() {
+	for i := range p.Inputs {
+		p.Inputs[i].hash()
+	}
+}
+
+// describeInput returns the InputFile of path, without its hash.
+func describeInput(path string) InputFile {
+	in := InputFile{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		in.Err = err.Error()
+		return in
+	}
+	in.Size, in.ModTime = info.Size(), info.ModTime().UTC()
+	return in
+}
+
+// hash sets in.SHA256, or in.Err if the file cannot be read.
+func (in *InputFile) hash() {
+	if in.Err != "" {
+		return
+	}
+	f, err := os.Open(in.Path)
+	if err != nil {
+		in.Err = err.Error()
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		in.Err = err.Error()
+		return
+	}
+	in.SHA256 = hex.EncodeToString(h.Sum(nil))
+}
+
+// ModuleVersion returns the version of this module in the running program:
+// its module version, followed by the VCS revision when the build recorded
+// one, or "unknown".
+func ModuleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := ""
+	if info.Main.Path == modulePath {
+		version = info.Main.Version
+	} else {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+			}
+		}
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision":
+			version += "+" + s.Value
+		case s.Key == "vcs.modified" && s.Value == "true":
+			version += "-dirty"
+		}
+	}
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+// WriteJSON writes p as indented JSON, as stored in a sidecar file.
+func (p Provenance) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		return fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	return nil
+}
+
+// SidecarPath returns the path of the provenance sidecar of a non-PNG
+// artifact at path.
+func SidecarPath(path string) string {
+	return path + ".json"
+}
+
+// EncodePNG encodes img as a PNG to w with p embedded as a zlib-compressed
+// JSON iTXt chunk under ProvenanceKeyword, the text chunk for UTF-8. Image
+// decoders ignore the chunk.
+func EncodePNG(w io.Writer, img image.Image, p Provenance) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	var chunk bytes.Buffer
+	chunk.WriteString(ProvenanceKeyword)
+	chunk.WriteByte(0)            // keyword terminator
+	chunk.WriteByte(1)            // compressed
+	chunk.WriteByte(0)            // compression method: zlib
+	chunk.WriteString("\x00\x00") // no language tag or translated keyword
+	zw := zlib.NewWriter(&chunk)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress provenance: %w", err)
+	}
+
+	// Text chunks may go anywhere after IHDR, which png.Encode writes
+	// first, right after the signature.
+	encoded := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(encoded[len(pngSignature):]))
+	if _, err := w.Write(encoded[:ihdrEnd]); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "iTXt", chunk.Bytes()); err != nil {
+		return err
+	}
+	_, err = w.Write(encoded[ihdrEnd:])
+	return err
+}
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// writePNGChunk writes a PNG chunk of the given type and data.
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadProvenance returns the provenance record of the artifact at path:
+// the iTXt chunk of a PNG, or otherwise the JSON sidecar at SidecarPath. It
+// returns an error wrapping ErrNoProvenance if there is neither.
+func ReadProvenance(path string) (Provenance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Provenance{}, err
+	}
+	if bytes.HasPrefix(data, []byte(pngSignature)) {
+		if p, ok, err := pngProvenance(data); ok || err != nil {
+			if err != nil {
+				return Provenance{}, fmt.Errorf("%s: %w", path, err)
+			}
+			return p, nil
+		}
+	}
+
+	sidecar, err := os.ReadFile(SidecarPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return Provenance{}, fmt.Errorf("%s: %w", path, ErrNoProvenance)
+	}
+	if err != nil {
+		return Provenance{}, err
+	}
+	var p Provenance
+	if err := json.Unmarshal(sidecar, &p); err != nil {
+		return Provenance{}, fmt.Errorf("failed to decode %s: %w", SidecarPath(path), err)
+	}
+	return p, nil
+}
+
+// pngProvenance looks for the provenance chunk in the PNG data, reporting
+// false if there is none. It also reads the zTXt chunk of earlier versions.
+func pngProvenance(data []byte) (Provenance, bool, error) {
+	prefixes := map[string][]byte{
+		"iTXt": []byte(ProvenanceKeyword + "\x00\x01\x00\x00\x00"),
+		"zTXt": []byte(ProvenanceKeyword + "\x00\x00"),
+	}
+	for rest := data[len(pngSignature):]; len(rest) >= 12; {
+		length := int(binary.BigEndian.Uint32(rest))
+		if length > len(rest)-12 {
+			return Provenance{}, false, errors.New("truncated PNG chunk")
+		}
+		typ, body := string(rest[4:8]), rest[8:8+length]
+		rest = rest[12+length:]
+		if typ == "IEND" {
+			break
+		}
+		prefix, ok := prefixes[typ]
+		if !ok || !bytes.HasPrefix(body, prefix) {
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(body[len(prefix):]))
+		if err != nil {
+			return Provenance{}, false, fmt.Errorf("failed to decompress provenance: %w", err)
+		}
+		var p Provenance
+		if err := json.NewDecoder(zr).Decode(&p); err != nil {
+			return Provenance{}, false, fmt.Errorf("failed to decode provenance: %w", err)
+		}
+		return p, true, nil
+	}
+	return Provenance{}, false, nil
+}
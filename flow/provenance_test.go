@@ -0,0 +1,94 @@
+package flow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvenanceRoundTrip(t *testing.T) {
+	imagePaths := []string{"../test_data/centered.png", "../test_data/shifted.png"}
+	opts := FlowOptions{
+		Method:        MethodDense,
+		SkipBadFrames: true,
+		HashInputs:    true,
+		Units:         Units{PixelSize: 500, FrameInterval: 5 * time.Minute},
+	}
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, 8, opts)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, result.Image, result.Provenance); err != nil {
+		t.Fatalf("EncodePNG failed: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("iTXt"+ProvenanceKeyword)) {
+		t.Error("Expected the provenance in an iTXt chunk")
+	}
+	path := filepath.Join(t.TempDir(), "flow.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected the flow map to stay a valid PNG, got %v", err)
+	}
+	compareImages(t, img, result.Image, 0)
+
+	prov, err := ReadProvenance(path)
+	if err != nil {
+		t.Fatalf("ReadProvenance failed: %v", err)
+	}
+	if prov.Operation != "GenerateAverageFlowMap" || prov.ResolutionFactor != 8 || prov.Version == "" {
+		t.Errorf("Unexpected provenance header: %+v", prov)
+	}
+	if prov.Options == nil || *prov.Options != (ProvenanceOptions{Method: "dense", Illumination: "ignore", SkipBadFrames: true, PixelSize: 500, FrameInterval: 5 * time.Minute}) {
+		t.Errorf("Recorded options %+v do not match %+v", prov.Options, opts)
+	}
+	if len(prov.Inputs) != len(imagePaths) {
+		t.Fatalf("Expected %d inputs, got %+v", len(imagePaths), prov.Inputs)
+	}
+	for i, in := range prov.Inputs {
+		data, err := os.ReadFile(imagePaths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if in.Path != imagePaths[i] || in.SHA256 != hex.EncodeToString(sum[:]) || in.Size != int64(len(data)) {
+			t.Errorf("Input %d recorded as %+v, want %s with SHA-256 %x", i, in, imagePaths[i], sum)
+		}
+	}
+	if unhashed := NewProvenance("GenerateAverageFlowMap", imagePaths, time.Now()); unhashed.Inputs[0].SHA256 != "" || unhashed.Inputs[0].Size != prov.Inputs[0].Size {
+		t.Errorf("Expected the size but no hash without HashInputs, got %+v", unhashed.Inputs[0])
+	}
+	if prov.Created.IsZero() || prov.Duration <= 0 {
+		t.Errorf("Expected a start time and duration, got %v and %v", prov.Created, prov.Duration)
+	}
+
+	// Other formats keep the record in a sidecar.
+	other := filepath.Join(t.TempDir(), "paths.jpg")
+	if err := os.WriteFile(other, []byte("not a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadProvenance(other); !errors.Is(err, ErrNoProvenance) {
+		t.Errorf("Expected ErrNoProvenance without a sidecar, got %v", err)
+	}
+	sidecar, err := os.Create(SidecarPath(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Provenance.WriteJSON(sidecar); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	sidecar.Close()
+	if fromSidecar, err := ReadProvenance(other); err != nil || fromSidecar.Inputs[1] != prov.Inputs[1] {
+		t.Errorf("Expected the sidecar record, got %+v, %v", fromSidecar, err)
+	}
+}
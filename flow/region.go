@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// MaskFromImage returns the region-of-interest mask of img for
+// FlowOptions.RegionMask: pixels that are black or transparent in img are
+// outside the region, all others inside.
+func MaskFromImage(img image.Image) *image.Alpha {
+	bounds := img.Bounds()
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r, g, b, a := img.At(x, y).RGBA(); a != 0 && r|g|b != 0 {
+				mask.SetAlpha(x, y, color.Alpha{A: 255})
+			}
+		}
+	}
+	return mask
+}
+
+// MaskFromMat returns the region-of-interest mask of an 8-bit
+// single-channel mat for FlowOptions.RegionMask: zero pixels are outside
+// the region, all others inside.
+func MaskFromMat(mat gocv.Mat) (*image.Alpha, error) {
+	if mat.Type() != gocv.MatTypeCV8U {
+		return nil, fmt.Errorf("region mask must be an 8-bit single-channel mat, got type %v", mat.Type())
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, mat.Cols(), mat.Rows()))
+	for i, v := range mat.ToBytes() {
+		if v != 0 {
+			mask.Pix[i] = 255
+		}
+	}
+	return mask, nil
+}
+
+// noDataMask returns the pixels of the flow field without data: those of
+// NoDataMask and those outside RegionMask. Either alone is returned as it
+// is; both are combined at the size of RegionMask.
+func (o FlowOptions) noDataMask() *image.Alpha {
+	if o.RegionMask == nil {
+		return o.NoDataMask
+	}
+	if o.NoDataMask == nil {
+		return o.RegionMask
+	}
+	bounds := o.RegionMask.Bounds()
+	mask := image.NewAlpha(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if !masked(o.RegionMask, x, y, bounds.Dx(), bounds.Dy()) && !masked(o.NoDataMask, x, y, bounds.Dx(), bounds.Dy()) {
+				mask.SetAlpha(bounds.Min.X+x, bounds.Min.Y+y, color.Alpha{A: 255})
+			}
+		}
+	}
+	return mask
+}
+
+// inRegion returns the first maxFeatures rows of the point matrix points, a
+// width x height frame's features, that lie inside region, or all of them
+// if maxFeatures is zero or negative.
+func inRegion(points gocv.Mat, region *image.Alpha, width, height, maxFeatures int) []gocv.Point2f {
+	var kept []gocv.Point2f
+	for i := 0; i < points.Rows() && (maxFeatures <= 0 || len(kept) < maxFeatures); i++ {
+		pt := pointAt(points, i)
+		x, y := int(pt.X+0.5), int(pt.Y+0.5)
+		if x < 0 || y < 0 || x >= width || y >= height || masked(region, x, y, width, height) {
+			continue
+		}
+		kept = append(kept, pt)
+	}
+	return kept
+}
+
+// regionQuality returns the quality level at which GoodFeaturesToTrack,
+// whose quality is relative to the strongest corner of the whole frame,
+// keeps the corners inside region that reach quality relative to the
+// strongest corner there, as OpenCV's mask argument would. The strengths
+// are the minimum eigenvalues of the gradient covariance over 3x3 blocks,
+// GoodFeaturesToTrack's measure. It returns zero if region holds no corner.
+func regionQuality(img gocv.Mat, region *image.Alpha, quality float64) float64 {
+	gray, release := grayFrame(img)
+	defer release()
+	w, h := gray.Cols(), gray.Rows()
+	if w < 3 || h < 3 {
+		return 0
+	}
+	pix := gray.ToBytes()
+	at := func(x, y int) float64 {
+		return float64(pix[min(max(y, 0), h-1)*w+min(max(x, 0), w-1)])
+	}
+	// The 3x3 Sobel gradients, with the border replicated.
+	ix, iy := make([]float64, w*h), make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ix[y*w+x] = at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			iy[y*w+x] = at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+		}
+	}
+	var strongest, inside float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			var a, b, c float64
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					gx, gy := ix[(y+dy)*w+x+dx], iy[(y+dy)*w+x+dx]
+					a, b, c = a+gx*gx, b+gx*gy, c+gy*gy
+				}
+			}
+			eig := (a+c)/2 - math.Sqrt((a-c)*(a-c)/4+b*b)
+			strongest = max(strongest, eig)
+			if !masked(region, x, y, w, h) {
+				inside = max(inside, eig)
+			}
+		}
+	}
+	if inside <= 0 {
+		return 0
+	}
+	return quality * inside / strongest
+}
@@ -0,0 +1,131 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// TestRegionMask burns a bright static logo into a moving texture and
+// checks that masking it out keeps the tracking off it, and leaves it
+// without flow.
+func TestRegionMask(t *testing.T) {
+	const size, rf = 256, 2
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 2, Y: 1}}}, 2, size, size)
+	logo := image.Rect(24, 24, 64, 64)
+	for i, frame := range frames {
+		burned := image.NewGray(frame.Bounds())
+		draw.Draw(burned, burned.Rect, frame, frame.Bounds().Min, draw.Src)
+		draw.Draw(burned, logo, image.White, image.Point{}, draw.Src)
+		frames[i] = burned
+	}
+	// still counts the paths that do not move.
+	still := func(result FlowResult) int {
+		n := 0
+		for _, path := range result.Paths {
+			if math.Hypot(float64(path[1].X-path[0].X), float64(path[1].Y-path[0].Y)) < 0.5 {
+				n++
+			}
+		}
+		return n
+	}
+
+	plain, err := GenerateAverageFlowMapFromImagesWithOptions(frames, rf, FlowOptions{RecordPaths: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if still(plain) == 0 {
+		t.Fatal("Expected the logo's corners to yield features that do not move without a mask")
+	}
+
+	// The mask excludes the logo with a margin, and is given at half the
+	// frames' size.
+	roi := image.NewGray(image.Rect(0, 0, size/2, size/2))
+	draw.Draw(roi, roi.Rect, image.White, image.Point{}, draw.Src)
+	draw.Draw(roi, image.Rect(8, 8, 36, 36), image.Black, image.Point{}, draw.Src)
+	mask := MaskFromImage(roi)
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(frames, rf, FlowOptions{RecordPaths: true, RegionMask: mask})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if n := still(result); n != 0 || len(result.Paths) == 0 {
+		t.Errorf("Expected no features that do not move under the mask, got %d of %d", n, len(result.Paths))
+	}
+	for _, path := range result.Paths {
+		if image.Pt(int(path[0].X), int(path[0].Y)).In(image.Rect(16, 16, 72, 72)) {
+			t.Errorf("Expected no feature detected in the masked region, got one at %v", path[0])
+		}
+	}
+	if e := meanError(t, result.Field, 2, 1); e > 0.1 {
+		t.Errorf("Expected the masked flow to follow the (2, 1) motion, got a mean error of %.3f", e)
+	}
+
+	// The field is at half the frames' size, like the mask.
+	neutral := color.NRGBA{R: 128, G: 128}
+	for y := 0; y < result.Field.Height; y++ {
+		for x := 0; x < result.Field.Width; x++ {
+			_, _, valid := result.Field.At(x, y)
+			outside := image.Pt(x, y).In(image.Rect(8, 8, 36, 36))
+			if valid == outside {
+				t.Fatalf("Pixel (%d, %d): expected data %v, got %v", x, y, !outside, valid)
+			}
+			if got := result.Image.At(x, y); outside && got != neutral {
+				t.Fatalf("Expected pixel (%d, %d) outside the region to be neutral and transparent, got %v", x, y, got)
+			}
+		}
+	}
+	if !result.Provenance.Options.RegionMask {
+		t.Error("Expected the provenance to record the region mask")
+	}
+
+	// A no-data mask still applies alongside the region.
+	noData := image.NewAlpha(image.Rect(0, 0, size, size))
+	draw.Draw(noData, noData.Rect, image.Opaque, image.Point{}, draw.Src)
+	draw.Draw(noData, image.Rect(200, 200, 256, 256), image.Transparent, image.Point{}, draw.Src)
+	both, err := GenerateAverageFlowMapFromImagesWithOptions(frames, rf, FlowOptions{RegionMask: mask, NoDataMask: noData})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if _, _, valid := both.Field.At(10, 10); valid {
+		t.Error("Expected no data outside the region with both masks")
+	}
+	if _, _, valid := both.Field.At(110, 110); valid {
+		t.Error("Expected no data in the no-data mask with both masks")
+	}
+	if _, _, valid := both.Field.At(60, 60); !valid {
+		t.Error("Expected data inside the region and the no-data mask")
+	}
+}
+
+func TestMaskFromMat(t *testing.T) {
+	mat := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV8U)
+	defer mat.Close()
+	mat.SetUCharAt(0, 1, 1)
+	mat.SetUCharAt(1, 2, 255)
+	mask, err := MaskFromMat(mat)
+	if err != nil {
+		t.Fatalf("MaskFromMat failed: %v", err)
+	}
+	if mask.Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Fatalf("Expected a 3x2 mask, got %v", mask.Bounds())
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			want := x == 1 && y == 0 || x == 2 && y == 1
+			if got := mask.AlphaAt(x, y).A == 255; got != want {
+				t.Errorf("Pixel (%d, %d): expected inside %v, got %v", x, y, want, got)
+			}
+		}
+	}
+
+	bgr := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV8UC3)
+	defer bgr.Close()
+	if _, err := MaskFromMat(bgr); err == nil {
+		t.Error("Expected an error for a 3-channel mat")
+	}
+}
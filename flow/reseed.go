@@ -0,0 +1,127 @@
+package flow
+
+import (
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// ReseedOptions configures FlowOptions.Reseed.
+type ReseedOptions struct {
+	// Below enables reseeding: whenever fewer than this many features
+	// survive, new ones are detected in the last frame added before the
+	// next frame is tracked. Zero disables reseeding.
+	Below int
+	// MaxFeatures is the most features detected per reseed, before those
+	// too close to a surviving feature are dropped. Zero means
+	// FlowOptions.Features.MaxFeatures.
+	MaxFeatures int
+}
+
+func (o ReseedOptions) maxFeatures(features FeatureOptions) int {
+	if o.MaxFeatures > 0 {
+		return o.MaxFeatures
+	}
+	return features.maxFeatures()
+}
+
+// Reseeded returns the number of features detected so far under
+// FlowOptions.Reseed.
+func (a *Accumulator) Reseeded() int {
+	return a.reseeded
+}
+
+// reseedPoints returns the features to add in the last frame before
+// tracking to the next, or nil unless FlowOptions.Reseed asks for them.
+// New features keep FlowOptions.Features.MinDistance from the surviving
+// ones. They start at their position in the last frame, and their
+// displacement is scaled to the whole sequence by spanningPoints.
+func (a *Accumulator) reseedPoints() []gocv.Point2f {
+	if a.opts.Reseed.Below <= 0 || a.currentPoints.Rows() >= a.opts.Reseed.Below {
+		return nil
+	}
+	corners := gocv.NewMat()
+	defer corners.Close()
+	a.opts.Features.detect(a.prevMat, &corners, a.opts.Reseed.maxFeatures(a.opts.Features), a.opts.RegionMask)
+
+	var seeded []gocv.Point2f
+	for i := 0; i < corners.Rows(); i++ {
+		pt := pointAt(corners, i)
+		if !a.nearFeature(pt) {
+			seeded = append(seeded, pt)
+		}
+	}
+	return seeded
+}
+
+// nearFeature reports whether a surviving feature lies closer than
+// FlowOptions.Features.MinDistance to pt.
+func (a *Accumulator) nearFeature(pt gocv.Point2f) bool {
+	minDistance := a.opts.Features.minDistance()
+	for i := 0; i < a.currentPoints.Rows(); i++ {
+		q := pointAt(a.currentPoints, i)
+		if math.Hypot(float64(pt.X-q.X), float64(pt.Y-q.Y)) < minDistance {
+			return true
+		}
+	}
+	return false
+}
+
+// keepStarts updates the frame each feature was detected in for the rows
+// of the features kept after tracking, of which those from seeded on
+// were reseeded in the last frame.
+func (a *Accumulator) keepStarts(keptRows []int, seeded int) {
+	if seeded == 0 && a.starts == nil {
+		return
+	}
+	tracked := a.currentPoints.Rows()
+	starts := make([]int, len(keptRows))
+	for idx, srcIdx := range keptRows {
+		switch {
+		case srcIdx >= tracked:
+			starts[idx] = a.frames - 1
+		case a.starts != nil:
+			starts[idx] = a.starts[srcIdx]
+		}
+	}
+	a.starts = starts
+}
+
+// spanScale returns the factor that scales the displacement of feature i
+// from the intervals it was tracked over to every interval added so far.
+func (a *Accumulator) spanScale(i int) float64 {
+	if a.starts == nil || a.starts[i] == 0 {
+		return 1
+	}
+	return float64(a.frames-1) / float64(a.frames-1-a.starts[i])
+}
+
+// spanningPoints returns a new Nx2 CV32F matrix of the current positions
+// of the features with the displacement of each reseeded one scaled by
+// spanScale, so that all of them span FlowField.Intervals.
+func (a *Accumulator) spanningPoints() gocv.Mat {
+	out := gocv.NewMatWithSize(a.currentPoints.Rows(), 2, gocv.MatTypeCV32F)
+	for i := 0; i < a.currentPoints.Rows(); i++ {
+		p0, p1 := pointAt(a.initialPoints, i), pointAt(a.currentPoints, i)
+		scale := float32(a.spanScale(i))
+		out.SetFloatAt(i, 0, p0.X+(p1.X-p0.X)*scale)
+		out.SetFloatAt(i, 1, p0.Y+(p1.Y-p0.Y)*scale)
+	}
+	return out
+}
+
+// appendPoints returns a new Nx2 CV32F point matrix holding the rows of
+// points followed by extra.
+func appendPoints(points gocv.Mat, extra []gocv.Point2f) gocv.Mat {
+	n := points.Rows()
+	out := gocv.NewMatWithSize(n+len(extra), 2, gocv.MatTypeCV32F)
+	for i := 0; i < n; i++ {
+		out.SetFloatAt(i, 0, points.GetFloatAt(i, 0))
+		out.SetFloatAt(i, 1, points.GetFloatAt(i, 1))
+	}
+	for i, pt := range extra {
+		out.SetFloatAt(n+i, 0, pt.X)
+		out.SetFloatAt(n+i, 1, pt.Y)
+	}
+	return out
+}
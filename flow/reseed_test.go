@@ -0,0 +1,70 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// panningFrames returns n 120x80 frames of a row of white squares that
+// moves 8 px left per frame, so every square in the first frame has left
+// within 16 frames and new ones have entered on the right. The squares are
+// spaced irregularly so LK cannot match a feature to the wrong square.
+func panningFrames(n int) []image.Image {
+	squares := []image.Point{{10, 30}, {75, 15}, {150, 40}, {215, 20}, {290, 35}}
+	imgs := make([]image.Image, n)
+	for i := range imgs {
+		img := image.NewGray(image.Rect(0, 0, 120, 80))
+		for _, sq := range squares {
+			r := image.Rect(sq.X-8*i, sq.Y, sq.X-8*i+20, sq.Y+20).Intersect(img.Bounds())
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					img.SetGray(x, y, color.Gray{Y: 255})
+				}
+			}
+		}
+		imgs[i] = img
+	}
+	return imgs
+}
+
+// TestReseedKeepsTrackingAcrossPan checks that features lost off the edge of
+// a panning sequence are replaced under Reseed, and that the flow then
+// shows the 8 px per frame pan over every interval, although each reseeded
+// feature only measures it from the frame it was detected in.
+func TestReseedKeepsTrackingAcrossPan(t *testing.T) {
+	imgs := panningFrames(20)
+	if _, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 1, FlowOptions{}); err == nil || !strings.Contains(err.Error(), "all features lost") {
+		t.Fatalf("Expected all features to be lost without reseeding, got %v", err)
+	}
+
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 1, FlowOptions{Reseed: ReseedOptions{Below: 8}, RecordPaths: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if result.Reseeded == 0 {
+		t.Error("Expected features to be reseeded")
+	}
+	for _, path := range result.Paths {
+		if len(path) != len(imgs) {
+			t.Fatalf("Expected only paths through all %d frames, got one of %d", len(imgs), len(path))
+		}
+	}
+	if gaps(result.Field, nil) == len(result.Field.DX) {
+		t.Fatal("Expected pixels with flow")
+	}
+	for y := 0; y < result.Field.Height; y++ {
+		for x := 0; x < result.Field.Width; x++ {
+			dx, dy, _ := result.Field.At(x, y)
+			if dx == 0 && dy == 0 {
+				continue // beyond the interpolation radius of every feature
+			}
+			dx, dy = dx/float64(result.Field.Intervals), dy/float64(result.Field.Intervals)
+			if math.Abs(dx+8) > 1 || math.Abs(dy) > 1 {
+				t.Fatalf("Expected a flow close to (-8, 0) px per frame at (%d, %d), got (%f, %f)", x, y, dx, dy)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package flow
+
+// DefaultPixelBudget is the default FlowOptions.PixelBudget: the pixel count
+// of a 512x512 flow field.
+const DefaultPixelBudget = 512 * 512
+
+// AutoResolution is the resolution factor that asks the flow functions to
+// choose one with ResolutionFactorFor. The fields they return then hold
+// full-resolution pixels, so the caller need not know the factor chosen;
+// their flow maps still hold field pixels, as at any factor.
+const AutoResolution = 0
+
+// ResolutionFactorFor returns the smallest resolution factor that brings a
+// width x height frame down to at most budget flow field pixels. A budget of
+// zero or less means DefaultPixelBudget.
+func ResolutionFactorFor(width, height, budget int) int {
+	if budget <= 0 {
+		budget = DefaultPixelBudget
+	}
+	factor := 1
+	for (width/factor)*(height/factor) > budget {
+		factor++
+	}
+	return factor
+}
+
+// pixelBudget returns the budget of FlowOptions.PixelBudget.
+func (o FlowOptions) pixelBudget() int {
+	if o.PixelBudget > 0 {
+		return o.PixelBudget
+	}
+	return DefaultPixelBudget
+}
+
+// resolveResolutionFactor returns resolutionFactor, or under AutoResolution
+// the factor ResolutionFactorFor chooses for a width x height frame.
+func (o FlowOptions) resolveResolutionFactor(resolutionFactor, width, height int) int {
+	if resolutionFactor != AutoResolution {
+		return resolutionFactor
+	}
+	return ResolutionFactorFor(width, height, o.pixelBudget())
+}
+
+// PixelDisplacementAt returns the displacement at (x, y) in full-resolution
+// pixels, whatever resolution factor the field was computed at. ok is false
+// if the pixel holds no data.
+func (f *FlowField) PixelDisplacementAt(x, y int) (dx, dy float64, ok bool) {
+	dx, dy, valid := f.At(x, y)
+	if !valid {
+		return 0, 0, false
+	}
+	rf := f.unitScale()
+	return dx * float64(rf), dy * float64(rf), true
+}
+
+// toFullResolution converts the displacements of f to full-resolution
+// pixels in place, as the flow functions return them under AutoResolution.
+func (f *FlowField) toFullResolution() {
+	rf := float64(f.unitScale())
+	for i := range f.DX {
+		f.DX[i] *= rf
+		f.DY[i] *= rf
+	}
+	f.FullResolution = true
+}
+
+// fieldPixels returns f with its displacements in field pixels, as the
+// functions that move pixels by them need: f itself, or a converted copy
+// if they are in full-resolution pixels.
+func (f *FlowField) fieldPixels() *FlowField {
+	if !f.FullResolution {
+		return f
+	}
+	rf, _ := f.scale()
+	out := f.scaled(1 / float64(rf))
+	out.FullResolution = false
+	return out
+}
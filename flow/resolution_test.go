@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestResolutionFactorFor(t *testing.T) {
+	for _, tc := range []struct {
+		width, height, budget, want int
+	}{
+		{2048, 2048, 0, 4},
+		{1024, 1024, 0, 2},
+		{512, 512, 0, 1},
+		{300, 200, 0, 1},
+		{2048, 2048, 1024 * 1024, 2},
+		{1000, 1000, 100, 91}, // 10x10 field pixels
+	} {
+		if got := ResolutionFactorFor(tc.width, tc.height, tc.budget); got != tc.want {
+			t.Errorf("ResolutionFactorFor(%d, %d, %d) = %d, want %d", tc.width, tc.height, tc.budget, got, tc.want)
+		}
+	}
+}
+
+// TestAutoResolution checks that 2048x2048 frames flowed at AutoResolution
+// get the factor of the default budget, recorded in the field and the
+// provenance, and that the field's displacements are in full-resolution
+// pixels, where an explicit factor of 4 and the flow map give field pixels.
+func TestAutoResolution(t *testing.T) {
+	const size, shift = 2048, 4
+	imgs := make([]image.Image, 2)
+	for i := range imgs {
+		img := image.NewGray(image.Rect(0, 0, size, size))
+		for _, r := range []image.Rectangle{image.Rect(600, 700, 900, 1000), image.Rect(1200, 900, 1400, 1300)} {
+			r = r.Add(image.Pt(shift*i, 0))
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					img.SetGray(x, y, color.Gray{Y: 255})
+				}
+			}
+		}
+		imgs[i] = img
+	}
+
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, AutoResolution, FlowOptions{RecordPaths: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	const want = 4
+	if result.Field.ResolutionFactor != want || result.Provenance.ResolutionFactor != want {
+		t.Fatalf("Expected resolution factor %d in the field and provenance, got %d and %d", want, result.Field.ResolutionFactor, result.Provenance.ResolutionFactor)
+	}
+	if b := result.Image.Bounds(); b.Dx() != size/want || b.Dy() != size/want {
+		t.Errorf("Expected a %dx%d flow map, got %v", size/want, size/want, b)
+	}
+
+	if len(result.Paths) == 0 {
+		t.Fatal("Expected tracked features")
+	}
+	explicit, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, want, FlowOptions{})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if !result.Field.FullResolution || explicit.Field.FullResolution {
+		t.Errorf("Expected only the automatic field in full-resolution pixels, got %v and %v", result.Field.FullResolution, explicit.Field.FullResolution)
+	}
+	flowMap := DecodeFlowMap(result.Image, Encoding{})
+	for _, path := range result.Paths {
+		p := path[0]
+		x, y := int(p.X)/want, int(p.Y)/want
+		dx, dy, ok := result.Field.PixelDisplacementAt(x, y)
+		if !ok || math.Abs(dx-shift) > 0.5 || math.Abs(dy) > 0.5 {
+			t.Errorf("Expected a displacement of (%d, 0) full-resolution pixels at %v, got (%.2f, %.2f)", shift, p, dx, dy)
+		}
+		if dx, _, _ := result.Field.At(x, y); math.Abs(dx-shift) > 0.5 {
+			t.Errorf("Expected DX %d at %v, got %.2f", shift, p, dx)
+		}
+		if dx, _, _ := flowMap.At(x, y); math.Abs(dx-shift/want) > 0.5 {
+			t.Errorf("Expected the flow map to hold %d at %v, got %.2f", shift/want, p, dx)
+		}
+		if dx, _, _ := explicit.Field.At(x, y); math.Abs(dx-shift/want) > 0.5 {
+			t.Errorf("Expected DX %d at factor %d at %v, got %.2f", shift/want, want, p, dx)
+		}
+	}
+}
+
+// TestFullResolutionFields checks that the functions that move pixels by a
+// field take one in full-resolution pixels as the same field in field
+// pixels.
+func TestFullResolutionFields(t *testing.T) {
+	field := NewFlowField(8, 8)
+	field.ResolutionFactor = 4
+	for i := range field.DX {
+		field.DX[i] = 1
+	}
+	full := field.scaled(1)
+	full.toFullResolution()
+	if dx, _, _ := full.At(0, 0); dx != 4 {
+		t.Fatalf("Expected 4 full-resolution pixels, got %v", dx)
+	}
+
+	composed, err := Compose(full, full)
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	if dx, _, ok := composed.At(0, 0); !ok || dx != 8 || !composed.FullResolution {
+		t.Errorf("Expected 8 full-resolution pixels composed, got %v (%v, full resolution %v)", dx, ok, composed.FullResolution)
+	}
+	// Pixel 6 moves one field pixel, not four, so it stays on the field.
+	if _, _, ok := composed.At(6, 0); !ok {
+		t.Error("Expected pixel 6 to stay on the field")
+	}
+	c, err := CompareFlowFields(full, field)
+	if err != nil {
+		t.Fatalf("CompareFlowFields failed: %v", err)
+	}
+	if c.MeanEPE != 0 {
+		t.Errorf("Expected no endpoint error between the units, got %v", c.MeanEPE)
+	}
+}
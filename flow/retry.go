@@ -0,0 +1,132 @@
+package flow
+
+import "gocv.io/x/gocv"
+
+// RetryStep is one rung of RetryPolicy.Ladder: the LK parameters a frame
+// pair is tracked again with. A zero field keeps the value of
+// FlowOptions.Features.
+type RetryStep struct {
+	WindowSize    int `json:"window_size,omitempty"`
+	PyramidLevels int `json:"pyramid_levels,omitempty"`
+}
+
+// DefaultRetryLadder is the RetryPolicy.Ladder used when it is empty: ever
+// larger windows over ever more pyramid levels, which ride out the blur and
+// noise of a bad frame at the cost of motion detail.
+var DefaultRetryLadder = []RetryStep{
+	{WindowSize: 31, PyramidLevels: 4},
+	{WindowSize: 41, PyramidLevels: 4},
+	{WindowSize: 61, PyramidLevels: 5},
+}
+
+// RetryPolicy configures FlowOptions.Retry.
+type RetryPolicy struct {
+	// Below enables retries: whenever less than this fraction, in (0, 1],
+	// of the features tracked from one frame to the next survive, including
+	// when none does, the pair is tracked again with each step of Ladder in
+	// turn until one keeps at least that fraction. The attempt keeping the
+	// most features is used. Zero disables retries.
+	Below float64
+	// Ladder is the LK parameters tried, in order. Empty means
+	// DefaultRetryLadder.
+	Ladder []RetryStep
+}
+
+func (o RetryPolicy) ladder() []RetryStep {
+	if len(o.Ladder) > 0 {
+		return o.Ladder
+	}
+	return DefaultRetryLadder
+}
+
+// RetriedPair records a frame pair tracked again under FlowOptions.Retry.
+type RetriedPair struct {
+	// Index is the position of the pair's later frame in the sequence, and
+	// Frame its name.
+	Index int    `json:"index"`
+	Frame string `json:"frame"`
+	// Survived is the fraction of the features that survived the first
+	// attempt, and Kept that of the attempt used.
+	Survived float64 `json:"survived"`
+	Kept     float64 `json:"kept"`
+	// Attempts is the number of ladder steps tried.
+	Attempts int `json:"attempts"`
+	// Step is the window size and pyramid levels of the attempt used, nil
+	// if no step kept more features than the first attempt.
+	Step *RetryStep `json:"step,omitempty"`
+}
+
+// withStep returns o with the non-zero parameters of step.
+func (o FeatureOptions) withStep(step RetryStep) FeatureOptions {
+	if step.WindowSize > 0 {
+		o.WindowSize = step.WindowSize
+	}
+	if step.PyramidLevels > 0 {
+		o.PyramidLevels = step.PyramidLevels
+	}
+	return o
+}
+
+// Retries returns the frame pairs tracked again so far under
+// FlowOptions.Retry, in order. Their Index counts the frames added, or, for
+// the functions that take a sequence, the frames of the sequence, skipped
+// ones included.
+func (a *Accumulator) Retries() []RetriedPair {
+	return a.retries
+}
+
+// tracked is the outcome of one trackFeatures attempt.
+type tracked struct {
+	initialPoints, currentPoints gocv.Mat
+	keptRows                     []int
+	dropped                      DroppedFeatures
+	err                          error
+}
+
+func (t tracked) close() {
+	t.initialPoints.Close()
+	t.currentPoints.Close()
+}
+
+// track tracks the features from the last frame to mat with trackFeatures,
+// retrying under FlowOptions.Retry when too few of them survive. It
+// returns the outcome used, and the retry to record, if any, for mat at
+// position index of the sequence.
+func (a *Accumulator) track(mat, initialPoints, currentPoints gocv.Mat, name string, index int) (tracked, *RetriedPair) {
+	return trackRetrying(a.prevMat, mat, initialPoints, currentPoints, a.lastName, name, index, a.opts)
+}
+
+// trackRetrying is Accumulator.track from prev to next, named prevName and
+// nextName, with opts.
+func trackRetrying(prev, next, initialPoints, currentPoints gocv.Mat, prevName, nextName string, index int, opts FlowOptions) (tracked, *RetriedPair) {
+	attempt := func(features FeatureOptions) tracked {
+		var t tracked
+		t.initialPoints, t.currentPoints, t.keptRows, t.dropped, t.err = trackFeatures(prev, next, initialPoints, currentPoints, prevName, nextName, features)
+		return t
+	}
+	best := attempt(opts.Features)
+	below := opts.Retry.Below
+	total := float64(currentPoints.Rows())
+	if below <= 0 || float64(len(best.keptRows)) >= below*total {
+		return best, nil
+	}
+
+	retry := &RetriedPair{Index: index, Frame: nextName, Survived: float64(len(best.keptRows)) / total}
+	for _, step := range opts.Retry.ladder() {
+		features := opts.Features.withStep(step)
+		retry.Attempts++
+		t := attempt(features)
+		if len(t.keptRows) <= len(best.keptRows) {
+			t.close()
+			continue
+		}
+		best.close()
+		best = t
+		retry.Step = &RetryStep{WindowSize: features.windowSize(), PyramidLevels: features.pyramidLevels()}
+		if float64(len(best.keptRows)) >= below*total {
+			break
+		}
+	}
+	retry.Kept = float64(len(best.keptRows)) / total
+	return best, retry
+}
@@ -0,0 +1,123 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"fmt"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+// blurredFrameSequence returns five 160x160 frames of a texture moving
+// (3, 1) px per frame whose middle frame is box blurred, as a frame with
+// a bad scan would be.
+func blurredFrameSequence() []image.Image {
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 3, Y: 1}}}, 5, 160, 160)
+	gray := frames[2].(*image.Gray)
+	pix := make([]float64, len(gray.Pix))
+	for i, v := range gray.Pix {
+		pix[i] = float64(v)
+	}
+	blurred := image.NewGray(gray.Rect)
+	for i, v := range boxBlur(pix, gray.Rect.Dx(), gray.Rect.Dy(), 8) {
+		blurred.Pix[i] = uint8(v + 0.5)
+	}
+	frames[2] = blurred
+	return frames
+}
+
+// TestRetryRecoversBlurredFrame checks that retrying the pairs around a
+// blurred frame with larger LK windows keeps most features, and a flow map
+// without gaps, where the default parameters lose most of them.
+func TestRetryRecoversBlurredFrame(t *testing.T) {
+	frames := blurredFrameSequence()
+	opts := FlowOptions{RecordPaths: true, Features: FeatureOptions{ForwardBackward: true}}
+	plain, err := GenerateAverageFlowMapFromImagesWithOptions(frames, 1, opts)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	opts.Retry = RetryPolicy{Below: 0.5}
+	retried, err := GenerateAverageFlowMapFromImagesWithOptions(frames, 1, opts)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if n := len(plain.Paths); 2*n >= len(retried.Paths) {
+		t.Errorf("Expected the blurred frame to cost most features without retries, got %d paths against %d with them", n, len(retried.Paths))
+	}
+	if n := gaps(plain.Field, nil); n == 0 {
+		t.Error("Expected pixels without flow without retries")
+	}
+	if n := gaps(retried.Field, nil); n != 0 {
+		t.Errorf("Expected no pixel without flow with retries, got %d", n)
+	}
+	if e := meanError(t, retried.Field, 12, 4); e > 1 {
+		t.Errorf("Expected the retried flow to follow the (12, 4) motion, got a mean error of %.3f", e)
+	}
+
+	// Both pairs of the blurred frame needed a retry, and nothing else.
+	if len(plain.Retries) != 0 {
+		t.Errorf("Expected no retries without a policy, got %v", plain.Retries)
+	}
+	if len(retried.Retries) != 2 {
+		t.Fatalf("Expected the two pairs of the blurred frame to be retried, got %+v", retried.Retries)
+	}
+	for i, retry := range retried.Retries {
+		if retry.Index != 2+i || retry.Survived >= 0.5 || retry.Kept < 0.5 || retry.Step == nil || retry.Step.WindowSize <= DefaultWindowSize {
+			t.Errorf("Expected frame %d to be recovered with a larger window, got %+v", 2+i, retry)
+		}
+	}
+	if got := retried.Provenance.Retries; len(got) != 2 || got[0].Index != 2 {
+		t.Errorf("Expected the provenance to record the retries, got %+v", got)
+	}
+	if o := retried.Provenance.Options; o.RetryBelow != 0.5 || o.RetryLadder != "31x4,41x4,61x5" {
+		t.Errorf("Expected the provenance to record the retry policy, got %v and %q", o.RetryBelow, o.RetryLadder)
+	}
+}
+
+// TestRetryIndexCountsSkippedFrames checks that a retried pair records the
+// position of its frame in the input, counting the frames skipped before it.
+func TestRetryIndexCountsSkippedFrames(t *testing.T) {
+	frames := blurredFrameSequence()
+	frames = append(frames[:1], append([]image.Image{image.NewGray(image.Rect(0, 0, 10, 10))}, frames[1:]...)...)
+	opts := FlowOptions{SkipBadFrames: true, Features: FeatureOptions{ForwardBackward: true}, Retry: RetryPolicy{Below: 0.5}}
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(frames, 1, opts)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	if len(result.Skipped) != 1 || len(result.Retries) != 2 {
+		t.Fatalf("Expected one skipped frame and two retries, got %+v and %+v", result.Skipped, result.Retries)
+	}
+	for i, retry := range result.Retries {
+		if retry.Index != 3+i {
+			t.Errorf("Expected retry %d at frame %d, got %d", i, 3+i, retry.Index)
+		}
+	}
+}
+
+// TestRetrySequence checks that a chained GenerateFlowSequence retries the
+// pairs of the blurred frame as the Accumulator does.
+func TestRetrySequence(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, frame := range blurredFrameSequence() {
+		path := filepath.Join(dir, fmt.Sprintf("frame%02d.png", i))
+		writePNG(t, path, frame)
+		paths = append(paths, path)
+	}
+	opts := FlowOptions{Features: FeatureOptions{ForwardBackward: true}, Retry: RetryPolicy{Below: 0.5}}
+	seq, err := GenerateFlowSequence(paths, 1, opts, SequenceOptions{})
+	if err != nil {
+		t.Fatalf("GenerateFlowSequence failed: %v", err)
+	}
+	if len(seq.Retries) != 2 {
+		t.Fatalf("Expected the two pairs of the blurred frame to be retried, got %+v", seq.Retries)
+	}
+	for i, retry := range seq.Retries {
+		if retry.Index != 2+i || retry.Frame != paths[2+i] || retry.Kept < 0.5 || retry.Step == nil {
+			t.Errorf("Expected frame %d to be recovered with a larger window, got %+v", 2+i, retry)
+		}
+	}
+	if got := seq.Provenance.Retries; len(got) != 2 || got[0].Index != 2 {
+		t.Errorf("Expected the provenance to record the retries, got %+v", got)
+	}
+}
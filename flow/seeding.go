@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultGridSpacing is the side in pixels of the cells of SeedGrid when
+// FeatureOptions.GridSpacing is zero.
+const DefaultGridSpacing = 32
+
+// Seeding selects how FeatureOptions places the features LK tracks.
+type Seeding int
+
+const (
+	// SeedCorners detects Shi-Tomasi corners with GoodFeaturesToTrack. They
+	// are the best features to track, but cluster on the few bright cells
+	// of a frame and leave the rest of it to be interpolated.
+	SeedCorners Seeding = iota
+	// SeedGrid places one feature at the center of every cell of a regular
+	// grid, so that the features cover the whole frame; see GridFeatures.
+	SeedGrid
+)
+
+func (s Seeding) String() string {
+	switch s {
+	case SeedCorners:
+		return "corners"
+	case SeedGrid:
+		return "grid"
+	}
+	return "unknown"
+}
+
+// ParseSeeding returns the Seeding named s: "corners" or "grid".
+func ParseSeeding(s string) (Seeding, error) {
+	for _, k := range []Seeding{SeedCorners, SeedGrid} {
+		if k.String() == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown seeding %q (want corners or grid)", s)
+}
+
+// GridFeatures returns features at the centers of the cells of a grid of
+// spacing x spacing pixels over img, those at the right and bottom edges
+// being cut short by the frame. Cells whose intensity variance, in grey
+// levels squared, is below minVariance are skipped, which keeps features
+// off featureless background where LK cannot match them. If the grid would
+// hold more than maxFeatures cells, its spacing is widened until it does
+// not; zero or negative means no limit. The features come most textured
+// cell first, like GoodFeaturesToTrack's strongest first. img may be
+// grayscale or BGR.
+func GridFeatures(img gocv.Mat, spacing, maxFeatures int, minVariance float64) []gocv.Point2f {
+	gray, release := grayFrame(img)
+	defer release()
+	w, h := gray.Cols(), gray.Rows()
+	if spacing <= 0 || w == 0 || h == 0 {
+		return nil
+	}
+	cells := func(s int) int {
+		return ((w + s - 1) / s) * ((h + s - 1) / s)
+	}
+	if maxFeatures > 0 && cells(spacing) > maxFeatures {
+		spacing = max(spacing, int(math.Sqrt(float64(w*h)/float64(maxFeatures))))
+		for cells(spacing) > maxFeatures {
+			spacing++
+		}
+	}
+
+	type cell struct {
+		pt       gocv.Point2f
+		variance float64
+	}
+	var found []cell
+	pix := gray.ToBytes()
+	for y0 := 0; y0 < h; y0 += spacing {
+		for x0 := 0; x0 < w; x0 += spacing {
+			r := image.Rect(x0, y0, min(x0+spacing, w), min(y0+spacing, h))
+			v := cellVariance(pix, w, r)
+			if v < minVariance {
+				continue
+			}
+			found = append(found, cell{
+				pt:       gocv.Point2f{X: float32(r.Min.X+r.Max.X-1) / 2, Y: float32(r.Min.Y+r.Max.Y-1) / 2},
+				variance: v,
+			})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].variance > found[j].variance })
+	points := make([]gocv.Point2f, len(found))
+	for i, c := range found {
+		points[i] = c.pt
+	}
+	return points
+}
+
+// cellVariance returns the variance of the pixels of r in the 8-bit
+// single-channel image pix of the given width.
+func cellVariance(pix []byte, width int, r image.Rectangle) float64 {
+	var sum, sumSq float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for _, v := range pix[y*width+r.Min.X : y*width+r.Max.X] {
+			sum += float64(v)
+			sumSq += float64(v) * float64(v)
+		}
+	}
+	n := float64(r.Dx() * r.Dy())
+	mean := sum / n
+	return max(sumSq/n-mean*mean, 0)
+}
+
+// pointsMat returns points as an Nx2 CV32F point matrix.
+func pointsMat(points []gocv.Point2f) gocv.Mat {
+	empty := gocv.NewMat()
+	defer empty.Close()
+	return appendPoints(empty, points)
+}
@@ -0,0 +1,125 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"image"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// quadrants returns the number of points in each quadrant of a width x
+// height frame: top left, top right, bottom left and bottom right.
+func quadrants(points []gocv.Point2f, width, height int) [4]int {
+	var n [4]int
+	for _, pt := range points {
+		q := 0
+		if int(pt.X) >= width/2 {
+			q++
+		}
+		if int(pt.Y) >= height/2 {
+			q += 2
+		}
+		n[q]++
+	}
+	return n
+}
+
+// TestGridFeaturesCoverQuadrants checks that grid seeding samples every
+// quadrant of the shifted test frame evenly, where its corners are those of
+// the square alone, and that MinVariance and MaxFeatures thin the grid.
+func TestGridFeaturesCoverQuadrants(t *testing.T) {
+	mat, err := prepImage(readPNG(t, "../test_data/shifted.png"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mat.Close()
+	w, h := mat.Cols(), mat.Rows()
+	// The square spans x 432 to 632 and y 422 to 622.
+	square := image.Rect(432, 422, 632, 622)
+	detect := func(features FeatureOptions) []gocv.Point2f {
+		t.Helper()
+		points := gocv.NewMat()
+		defer points.Close()
+		features.detect(mat, &points, features.maxFeatures(), nil)
+		out := make([]gocv.Point2f, points.Rows())
+		for i := range out {
+			out[i] = pointAt(points, i)
+		}
+		return out
+	}
+
+	for _, pt := range detect(FeatureOptions{}) {
+		if !image.Pt(int(pt.X), int(pt.Y)).In(square.Inset(-4)) {
+			t.Errorf("Expected the corners to lie on the square, got %v", pt)
+		}
+	}
+
+	// The default 32 pixel grid would hold 1024 cells, so it is widened to
+	// fit DefaultMaxFeatures.
+	grid := detect(FeatureOptions{Seeding: SeedGrid})
+	if n := len(grid); n == 0 || n > DefaultMaxFeatures {
+		t.Fatalf("Expected up to %d grid features, got %d", DefaultMaxFeatures, n)
+	}
+	for q, n := range quadrants(grid, w, h) {
+		if n != len(grid)/4 {
+			t.Errorf("Expected quadrant %d to hold a quarter of the %d grid features, got %d", q, len(grid), n)
+		}
+	}
+	var reach image.Rectangle
+	for _, pt := range grid {
+		reach = reach.Union(image.Rect(int(pt.X), int(pt.Y), int(pt.X)+1, int(pt.Y)+1))
+	}
+	if spacing := w / 9; reach.Min.X > spacing || reach.Min.Y > spacing || reach.Max.X < w-spacing || reach.Max.Y < h-spacing {
+		t.Errorf("Expected the grid to reach every edge of the frame, got features over %v", reach)
+	}
+
+	// Only the cells on the square's border vary.
+	varied := detect(FeatureOptions{Seeding: SeedGrid, GridSpacing: 64, MaxFeatures: 1000, MinVariance: 100})
+	if len(varied) == 0 || len(varied) >= len(grid) {
+		t.Fatalf("Expected MinVariance to keep a few cells, got %d", len(varied))
+	}
+	for _, pt := range varied {
+		cell := image.Rect(0, 0, 64, 64).Add(image.Pt(int(pt.X)/64*64, int(pt.Y)/64*64))
+		if !cell.Overlaps(square) || square.Inset(1).Intersect(cell) == cell {
+			t.Errorf("Expected only cells on the square's border to be kept, got %v", pt)
+		}
+	}
+
+	if n := len(detect(FeatureOptions{Seeding: SeedGrid, GridSpacing: 8, MaxFeatures: 50})); n == 0 || n > 50 {
+		t.Errorf("Expected MaxFeatures to cap a fine grid at 50 features, got %d", n)
+	}
+}
+
+// TestGridSeedingFlow tracks a translating texture from a grid of
+// features and checks that they all survive, cover the frame, and recover
+// the motion.
+func TestGridSeedingFlow(t *testing.T) {
+	const size = 256
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 2, Y: 1}}}, 2, size, size)
+	features := FeatureOptions{Seeding: SeedGrid}
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(frames, 2, FlowOptions{Features: features, RecordPaths: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	starts := make([]gocv.Point2f, len(result.Paths))
+	for i, path := range result.Paths {
+		starts[i] = path[0]
+	}
+	if got, want := quadrants(starts, size, size), [4]int{16, 16, 16, 16}; got != want {
+		t.Errorf("Expected the 64 cells of the grid to be tracked, 16 per quadrant, got %v", got)
+	}
+	if e := meanError(t, result.Field, 2, 1); e > 0.05 {
+		t.Errorf("Expected the grid to recover the (2, 1) motion, got a mean error of %.3f", e)
+	}
+	if got := result.Provenance.Options; got.Seeding != "grid" || got.GridSpacing != DefaultGridSpacing {
+		t.Errorf("Expected the provenance to record the grid and its spacing, got %q and %d", got.Seeding, got.GridSpacing)
+	}
+
+	if s, err := ParseSeeding("grid"); err != nil || s != SeedGrid {
+		t.Errorf("Expected ParseSeeding(grid) to give SeedGrid, got %v, %v", s, err)
+	}
+	if _, err := ParseSeeding("random"); err == nil {
+		t.Error("Expected an error for an unknown seeding")
+	}
+}
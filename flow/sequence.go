@@ -0,0 +1,410 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// ErrChainedParallel is returned when SequenceOptions asks for more than one
+// worker without PerPair.
+var ErrChainedParallel = errors.New("flow: chained flow sequences are computed serially; set PerPair to use several workers")
+
+// SequenceOptions configures GenerateFlowSequence.
+type SequenceOptions struct {
+	// PerPair detects new features in the first frame of every pair instead
+	// of tracking the features of the first frame through the sequence.
+	// Pairs then no longer depend on each other, so they can be computed
+	// concurrently, and features lost in one pair are replaced in the next.
+	PerPair bool
+	// Workers is the number of frames decoded, and under PerPair of pairs
+	// computed, at once. Zero or 1 computes everything serially; more than
+	// 1 requires PerPair, and interpolates each pair's field serially unless
+	// FlowOptions.Interpolation.Workers is set, so that the pairs do not
+	// oversubscribe the CPUs.
+	Workers int
+	// Cumulative also computes the flow over the whole sequence, as
+	// GenerateAverageFlowMapWithOptions does, into SequenceResult.Cumulative,
+	// tracking the features of the first frame through every good frame
+	// once more.
+	Cumulative bool
+}
+
+func (o SequenceOptions) workers() int {
+	if o.Workers < 1 {
+		return 1
+	}
+	return o.Workers
+}
+
+func (o SequenceOptions) validate() error {
+	if o.Workers > 1 && !o.PerPair {
+		return ErrChainedParallel
+	}
+	return nil
+}
+
+// PairFlow is the flow between two consecutive good frames of a sequence.
+type PairFlow struct {
+	// From and To are the positions of the frames in the input sequence.
+	From, To int
+	// Field holds the displacements from frame From to frame To, spanning
+	// To-From frame intervals and calibrated with FlowOptions.Units.
+	Field *FlowField
+	// Illumination is the change estimated from frame From to frame To,
+	// unless FlowOptions.Illumination is IlluminationIgnore.
+	Illumination IlluminationChange
+	// Dropped counts the features dropped while tracking the pair.
+	Dropped DroppedFeatures
+
+	retry *RetriedPair // under FlowOptions.Retry, nil if not retried
+}
+
+// SequenceResult is the output of GenerateFlowSequence.
+type SequenceResult struct {
+	// Pairs holds the flow of each pair of consecutive good frames, in order.
+	Pairs []PairFlow
+	// Cumulative holds, under SequenceOptions.Cumulative, the displacements
+	// from the first good frame to the last, spanning every frame interval
+	// between them. On steady motion each pair's field is about Cumulative
+	// divided by its number of intervals.
+	Cumulative *FlowField
+	// Skipped lists, in order, the frames left out under
+	// FlowOptions.SkipBadFrames.
+	Skipped []SkippedFrame
+	// Retries lists, in order, the pairs tracked again under
+	// FlowOptions.Retry; the Index of each is its To. They are also
+	// recorded in Provenance.
+	Retries []RetriedPair
+	// Provenance records the frames, options and code version that
+	// produced the result.
+	Provenance Provenance
+}
+
+// GenerateFlowSequence loads a sequence of images and returns the flow
+// between each pair of consecutive frames, rather than over the whole
+// sequence as GenerateAverageFlowMapWithOptions does. opts applies to every
+// pair, except that RecordPaths is ignored.
+//
+// By default the features detected in the first frame are tracked from pair
+// to pair, and each pair's field interpolates the displacements of those
+// still alive, so the pairs are computed serially and in order. Under
+// seq.PerPair features are re-detected in the first frame of every pair
+// instead, and seq.Workers pairs are computed concurrently; the results are
+// the same for any number of workers. Dense Farneback flow has no state to
+// chain and is computed per pair either way. Illumination is estimated, and
+// corrected, separately for each pair. Under seq.Cumulative the flow over
+// the whole sequence is returned alongside the pairs. Under AutoResolution
+// every field is in full-resolution pixels, as Accumulator.FlowField returns
+// it.
+func GenerateFlowSequence(imagePaths []string, resolutionFactor int, opts FlowOptions, seq SequenceOptions) (SequenceResult, error) {
+	return GenerateFlowSequenceWithContext(context.Background(), imagePaths, resolutionFactor, opts, seq)
+}
+
+// GenerateFlowSequenceWithContext is like GenerateFlowSequence but stops
+// when ctx is done, as GenerateAverageFlowMapWithContext does. ctx is
+// checked before each pair and between the bands of rows of each
+// interpolation. FlowOptions.OnProgress is called for the first good frame
+// and each skipped one, then after each pair with the index of its later
+// frame; under PerPair with several workers the pairs report as they
+// finish, one at a time.
+func GenerateFlowSequenceWithContext(ctx context.Context, imagePaths []string, resolutionFactor int, opts FlowOptions, seq SequenceOptions) (SequenceResult, error) {
+	if len(imagePaths) < 2 {
+		return SequenceResult{}, fmt.Errorf("at least two images are required, but got %d", len(imagePaths))
+	}
+	if err := seq.validate(); err != nil {
+		return SequenceResult{}, err
+	}
+	workers := seq.workers()
+	prov := NewProvenance("GenerateFlowSequence", imagePaths, time.Now())
+	if opts.HashInputs {
+		prov.HashInputs()
+	}
+	prov.Options = RecordOptions(opts)
+	prov.Parameters = map[string]string{
+		"per_pair":   strconv.FormatBool(seq.PerPair),
+		"workers":    strconv.Itoa(workers),
+		"cumulative": strconv.FormatBool(seq.Cumulative),
+	}
+
+	// Decode every frame once up front, so that no frame is decoded again
+	// for the second pair it belongs to.
+	mats := make([]gocv.Mat, len(imagePaths))
+	loadErrs := make([]error, len(imagePaths))
+	parallelFor(len(imagePaths), workers, func(i int) {
+		mats[i], loadErrs[i] = loadAndPrepImage(imagePaths[i], nil)
+	})
+	defer func() {
+		for _, mat := range mats {
+			mat.Close()
+		}
+	}()
+
+	// Every frame must match the size of the first one that loaded.
+	first := -1
+	for i, err := range loadErrs {
+		switch {
+		case err != nil:
+		case first < 0:
+			first = i
+		default:
+			loadErrs[i] = checkFrameSize(mats[i], mats[first].Cols(), mats[first].Rows())
+		}
+	}
+	auto := resolutionFactor == AutoResolution
+	if first >= 0 {
+		resolutionFactor = opts.resolveResolutionFactor(resolutionFactor, mats[first].Cols(), mats[first].Rows())
+	}
+	prov.ResolutionFactor = resolutionFactor
+
+	var progress sync.Mutex
+	report := func(i int) {
+		if opts.OnProgress != nil {
+			progress.Lock()
+			defer progress.Unlock()
+			opts.OnProgress(i, len(imagePaths))
+		}
+	}
+
+	var result SequenceResult
+	var good []int
+	for i, err := range loadErrs {
+		if err == nil {
+			if good = append(good, i); len(good) == 1 {
+				report(i)
+			}
+			continue
+		}
+		if !opts.SkipBadFrames {
+			return SequenceResult{}, fmt.Errorf("failed to load image %s: %w", imagePaths[i], err)
+		}
+		result.Skipped = append(result.Skipped, SkippedFrame{Index: i, Path: imagePaths[i], Err: err})
+		report(i)
+	}
+	if len(good) < 2 {
+		return SequenceResult{}, fmt.Errorf("at least two readable images are required, but %d of %d were skipped", len(result.Skipped), len(imagePaths))
+	}
+
+	result.Pairs = make([]PairFlow, len(good)-1)
+	pairErrs := make([]error, len(result.Pairs))
+	pairOpts := opts
+	if workers > 1 && pairOpts.Interpolation.Workers == 0 {
+		pairOpts.Interpolation.Workers = 1
+	}
+	compute := func(k int, points gocv.Mat) gocv.Mat {
+		from, to := good[k], good[k+1]
+		if err := ctx.Err(); err != nil {
+			pairErrs[k] = err
+			return gocv.NewMat()
+		}
+		var survivors gocv.Mat
+		result.Pairs[k], survivors, pairErrs[k] = pairFlow(ctx, mats[from], mats[to], points, imagePaths[from], imagePaths[to], to, resolutionFactor, pairOpts)
+		result.Pairs[k].From, result.Pairs[k].To = from, to
+		if result.Pairs[k].Field != nil {
+			result.Pairs[k].Field.Intervals = to - from
+		}
+		if pairErrs[k] == nil {
+			report(to)
+		}
+		return survivors
+	}
+
+	if seq.PerPair {
+		parallelFor(len(result.Pairs), workers, func(k int) {
+			points := gocv.NewMat()
+			survivors := compute(k, points)
+			points.Close()
+			survivors.Close()
+		})
+	} else {
+		points := gocv.NewMat()
+		for k := range result.Pairs {
+			survivors := compute(k, points)
+			points.Close()
+			points = survivors
+			if pairErrs[k] != nil {
+				break
+			}
+		}
+		points.Close()
+	}
+	if err := ctx.Err(); err != nil {
+		return SequenceResult{}, err
+	}
+	for _, err := range pairErrs {
+		if err != nil {
+			return SequenceResult{}, err
+		}
+	}
+	for _, pair := range result.Pairs {
+		if pair.retry != nil {
+			result.Retries = append(result.Retries, *pair.retry)
+		}
+	}
+	if seq.Cumulative {
+		field, err := cumulativeFlow(ctx, mats, good, imagePaths, resolutionFactor, opts)
+		if err != nil {
+			return SequenceResult{}, err
+		}
+		field.Intervals = spannedIntervals(len(imagePaths), result.Skipped)
+		result.Cumulative = field
+	}
+	if auto {
+		for _, pair := range result.Pairs {
+			pair.Field.toFullResolution()
+		}
+		if result.Cumulative != nil {
+			result.Cumulative.toFullResolution()
+		}
+	}
+	prov.Retries = result.Retries
+	prov.Finish()
+	result.Provenance = prov
+	return result, nil
+}
+
+// cumulativeFlow returns the flow over the frames mats[i] of every i in
+// good, named by paths, through an Accumulator, stopping when ctx is done.
+// mats is only read.
+func cumulativeFlow(ctx context.Context, mats []gocv.Mat, good []int, paths []string, resolutionFactor int, opts FlowOptions) (*FlowField, error) {
+	opts.RecordPaths, opts.Workspace = false, nil
+	acc := NewAccumulator(opts)
+	defer acc.Close()
+	for _, i := range good {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := acc.addMat(mats[i].Clone(), paths[i], i); err != nil {
+			return nil, err
+		}
+	}
+	return acc.flowFieldContext(ctx, resolutionFactor)
+}
+
+// pairFlow computes the flow from prev to next, frame index of the
+// sequence, with opts. Under the sparse methods it tracks points, or the
+// features it detects in prev if points is empty, retrying under
+// FlowOptions.Retry as an Accumulator does, and also returns the positions
+// in next of the features that survived, which the caller must close. It
+// fails with a *TooFewFeaturesError if fewer than
+// FlowOptions.MinSurvivingFeatures survive the pair.
+// prev, next and points are only read, so pairs sharing frames can be
+// computed concurrently. The interpolation stops with ctx.Err() once ctx
+// is done.
+func pairFlow(ctx context.Context, prev, next, points gocv.Mat, prevName, nextName string, index, resolutionFactor int, opts FlowOptions) (PairFlow, gocv.Mat, error) {
+	var pair PairFlow
+	if opts.Illumination != IlluminationIgnore {
+		change, err := EstimateIllumination(prev, next)
+		if err != nil {
+			return PairFlow{}, gocv.NewMat(), fmt.Errorf("failed to estimate the illumination change to %s: %w", nextName, err)
+		}
+		if opts.Illumination == IlluminationCorrect && change.Estimated {
+			next = correctIllumination(next, change)
+			defer next.Close()
+			change.Applied = true
+		}
+		pair.Illumination = change
+	}
+
+	scaledWidth := prev.Cols() / resolutionFactor
+	scaledHeight := prev.Rows() / resolutionFactor
+	survivors := gocv.NewMat()
+	var field *FlowField
+	if opts.Method != MethodDense {
+		if points.Empty() {
+			detected, err := findGoodFeatures(prev, prevName, opts.Features, opts.RegionMask)
+			if err != nil {
+				return PairFlow{}, survivors, err
+			}
+			defer detected.Close()
+			points = detected
+		}
+		t, retry := trackRetrying(prev, next, points, points, prevName, nextName, index, opts)
+		if t.err != nil {
+			return PairFlow{}, survivors, t.err
+		}
+		defer t.initialPoints.Close()
+		survivors.Close()
+		survivors, pair.Dropped, pair.retry = t.currentPoints, t.dropped, retry
+		if n := survivors.Rows(); n < opts.MinSurvivingFeatures {
+			return PairFlow{}, survivors, &TooFewFeaturesError{
+				Surviving: n,
+				Min:       opts.MinSurvivingFeatures,
+				Previous:  prevName,
+				Frame:     nextName,
+				Lost:      points.Rows() - n,
+				Tracked:   points.Rows(),
+			}
+		}
+
+		var confidence [][]float64
+		var err error
+		field, confidence, err = interpolateFlowField(ctx, t.initialPoints, t.currentPoints, scaledWidth, scaledHeight, resolutionFactor, opts.noDataMask(), opts.Interpolation, nil)
+		if err == nil && opts.Method == MethodFused {
+			var dense *FlowField
+			if dense, err = denseField(prev, next, nextName, scaledWidth, scaledHeight, resolutionFactor, opts); err == nil {
+				field, err = FuseFields(field, confidence, dense, opts.Fuse)
+			}
+		}
+		if err != nil {
+			return PairFlow{}, survivors, err
+		}
+	} else {
+		var err error
+		if field, err = denseField(prev, next, nextName, scaledWidth, scaledHeight, resolutionFactor, opts); err != nil {
+			return PairFlow{}, survivors, err
+		}
+	}
+
+	field.NonFinite += pair.Dropped.NonFinite
+	if err := field.checkFinite(); err != nil {
+		return PairFlow{}, survivors, err
+	}
+	field.ResolutionFactor = resolutionFactor
+	field.Intervals = 1
+	field.Units = opts.Units
+	pair.Field = field
+	return pair, survivors, nil
+}
+
+// denseField returns the Farneback flow from prev to next, named nextName,
+// on a width x height grid.
+func denseField(prev, next gocv.Mat, nextName string, width, height, resolutionFactor int, opts FlowOptions) (*FlowField, error) {
+	d := newDenseTracks(prev.Cols(), prev.Rows())
+	if err := d.advance(prev, next, opts.Farneback); err != nil {
+		return nil, fmt.Errorf("failed to track %s densely: %w", nextName, err)
+	}
+	return d.field(width, height, resolutionFactor, opts.noDataMask()), nil
+}
+
+// parallelFor calls f for every index in [0, n) on up to workers
+// goroutines, and returns once all calls have.
+func parallelFor(n, workers int, f func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
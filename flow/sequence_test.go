@@ -0,0 +1,144 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// translatingSequence writes n frames of a texture moving by (3, 2) pixels
+// per frame to a temporary directory and returns their paths.
+func translatingSequence(tb testing.TB, n int) []string {
+	tb.Helper()
+	dir := tb.TempDir()
+	var paths []string
+	for i, frame := range translatingFrames(n, 3, 2) {
+		path := filepath.Join(dir, fmt.Sprintf("frame%02d.png", i))
+		writePNG(tb, path, frame)
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// TestGenerateFlowSequenceParallelMatchesSerial checks that computing the
+// pairs of a PerPair sequence concurrently gives the same fields as
+// computing them one after another.
+func TestGenerateFlowSequenceParallelMatchesSerial(t *testing.T) {
+	paths := translatingSequence(t, 5)
+	if _, err := GenerateFlowSequence(paths, 4, FlowOptions{}, SequenceOptions{Workers: 4}); !errors.Is(err, ErrChainedParallel) {
+		t.Fatalf("Expected ErrChainedParallel for a chained sequence with 4 workers, got %v", err)
+	}
+
+	for _, method := range []Method{MethodSparse, MethodFused} {
+		opts := FlowOptions{Method: method}
+		serial, err := GenerateFlowSequence(paths, 4, opts, SequenceOptions{PerPair: true})
+		if err != nil {
+			t.Fatalf("%s: serial GenerateFlowSequence failed: %v", method, err)
+		}
+		parallel, err := GenerateFlowSequence(paths, 4, opts, SequenceOptions{PerPair: true, Workers: 4})
+		if err != nil {
+			t.Fatalf("%s: parallel GenerateFlowSequence failed: %v", method, err)
+		}
+		if len(parallel.Pairs) != len(paths)-1 {
+			t.Fatalf("%s: expected %d pairs, got %d", method, len(paths)-1, len(parallel.Pairs))
+		}
+		for k, pair := range parallel.Pairs {
+			if pair.From != k || pair.To != k+1 || pair.Field.Intervals != 1 {
+				t.Errorf("%s: pair %d spans frames %d to %d over %d intervals", method, k, pair.From, pair.To, pair.Field.Intervals)
+			}
+			if !reflect.DeepEqual(pair.Field, serial.Pairs[k].Field) {
+				t.Errorf("%s: pair %d differs between the serial and parallel runs", method, k)
+			}
+		}
+	}
+
+	// Chaining tracks the same features as PerPair over the first pair, and
+	// both follow the texture over every pair after it.
+	chained, err := GenerateFlowSequence(paths, 4, FlowOptions{}, SequenceOptions{})
+	if err != nil {
+		t.Fatalf("chained GenerateFlowSequence failed: %v", err)
+	}
+	perPair, err := GenerateFlowSequence(paths, 4, FlowOptions{}, SequenceOptions{PerPair: true})
+	if err != nil {
+		t.Fatalf("GenerateFlowSequence failed: %v", err)
+	}
+	if len(chained.Pairs) != len(perPair.Pairs) {
+		t.Fatalf("Expected %d chained pairs, got %d", len(perPair.Pairs), len(chained.Pairs))
+	}
+	if !reflect.DeepEqual(chained.Pairs[0].Field, perPair.Pairs[0].Field) {
+		t.Error("Expected the first pair to match between the chained and PerPair runs")
+	}
+	for k, pair := range chained.Pairs {
+		if pair.From != perPair.Pairs[k].From || pair.To != perPair.Pairs[k].To {
+			t.Errorf("Chained pair %d spans frames %d to %d, PerPair %d to %d", k, pair.From, pair.To, perPair.Pairs[k].From, perPair.Pairs[k].To)
+		}
+		chainedErr, perPairErr := meanError(t, pair.Field, 3, 2), meanError(t, perPair.Pairs[k].Field, 3, 2)
+		if chainedErr > 0.1 || perPairErr > 0.1 {
+			t.Errorf("Pair %d: expected the (3, 2) motion in both runs, got an endpoint error of %.2f chained and %.2f PerPair", k, chainedErr, perPairErr)
+		}
+	}
+}
+
+func BenchmarkGenerateFlowSequence(b *testing.B) {
+	paths := translatingSequence(b, 9)
+	for _, bc := range []struct {
+		name string
+		seq  SequenceOptions
+	}{
+		{"chained", SequenceOptions{}},
+		{"serial", SequenceOptions{PerPair: true}},
+		{"parallel", SequenceOptions{PerPair: true, Workers: 8}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GenerateFlowSequence(paths, 4, FlowOptions{}, bc.seq); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestGenerateFlowSequenceCumulative checks that on a constant-velocity
+// sequence every pair's field is the cumulative one divided by the number
+// of frame intervals.
+func TestGenerateFlowSequenceCumulative(t *testing.T) {
+	const n = 5
+	paths := translatingSequence(t, n)
+	for _, seq := range []SequenceOptions{{Cumulative: true}, {PerPair: true, Cumulative: true}} {
+		result, err := GenerateFlowSequence(paths, 4, FlowOptions{}, seq)
+		if err != nil {
+			t.Fatalf("GenerateFlowSequence failed: %v", err)
+		}
+		total := result.Cumulative
+		if total == nil || total.Intervals != n-1 {
+			t.Fatalf("PerPair %v: expected a cumulative field over %d intervals, got %+v", seq.PerPair, n-1, total)
+		}
+		if e := meanError(t, total, 3*(n-1), 2*(n-1)); e > 0.2 {
+			t.Errorf("PerPair %v: expected the cumulative field to follow the (%d, %d) motion, got a mean error of %.3f", seq.PerPair, 3*(n-1), 2*(n-1), e)
+		}
+		share := NewFlowField(total.Width, total.Height)
+		for i := range share.DX {
+			share.DX[i], share.DY[i] = total.DX[i]/(n-1), total.DY[i]/(n-1)
+		}
+		for k, pair := range result.Pairs {
+			c, err := CompareFlowFields(pair.Field, share)
+			if err != nil {
+				t.Fatalf("CompareFlowFields failed: %v", err)
+			}
+			if e := c.MeanEPE * float64(total.ResolutionFactor); e > 0.1 {
+				t.Errorf("PerPair %v: pair %d is %.3f px from the cumulative field divided by %d", seq.PerPair, k, e, n-1)
+			}
+		}
+	}
+
+	result, err := GenerateFlowSequence(paths, 4, FlowOptions{}, SequenceOptions{})
+	if err != nil {
+		t.Fatalf("GenerateFlowSequence failed: %v", err)
+	}
+	if result.Cumulative != nil {
+		t.Error("Expected no cumulative field without Cumulative")
+	}
+}
@@ -0,0 +1,61 @@
+package flow
+
+import (
+	"errors"
+	"image"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// TestFlowMapFollowsFrameSize checks that a sequence of frames that are not
+// 1024x1024 gives a flow map of their own size, downscaled, and that a frame
+// of another size is rejected, or skipped under SkipBadFrames.
+func TestFlowMapFollowsFrameSize(t *testing.T) {
+	const width, height, resolutionFactor = 500, 400, 4
+	// Crop the test_data frames, shifted by (20, 10), around their centre.
+	crop := image.Rect(262, 312, 262+width, 312+height)
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"centered", "shifted"} {
+		img := readPNG(t, "../test_data/"+name+".png").(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(crop)
+		path := filepath.Join(dir, name+".png")
+		writePNG(t, path, img)
+		paths = append(paths, path)
+	}
+
+	flowMap, err := GenerateAverageFlowMap(paths, resolutionFactor)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMap failed: %v", err)
+	}
+	mapPath := filepath.Join(dir, "flow.png")
+	writePNG(t, mapPath, flowMap)
+	decoded := readPNG(t, mapPath)
+	if b := decoded.Bounds(); b.Dx() != width/resolutionFactor || b.Dy() != height/resolutionFactor {
+		t.Fatalf("Expected a %dx%d flow map, got %dx%d", width/resolutionFactor, height/resolutionFactor, b.Dx(), b.Dy())
+	}
+	avgDx, avgDy := calculateAverageFlow(t, decoded)
+	if math.Abs(avgDx-20.0/resolutionFactor) > 1.0 || math.Abs(avgDy-10.0/resolutionFactor) > 1.0 {
+		t.Errorf("Expected average flow close to (%v, %v), got (%v, %v)", 20.0/resolutionFactor, 10.0/resolutionFactor, avgDx, avgDy)
+	}
+
+	// A frame of another size fails the sequence, or is skipped.
+	odd := filepath.Join(dir, "odd.png")
+	writePNG(t, odd, image.NewGray(image.Rect(0, 0, height, width)))
+	mixed := []string{paths[0], odd, paths[1]}
+	if _, err := GenerateAverageFlowMap(mixed, resolutionFactor); !errors.Is(err, ErrFrameSize) {
+		t.Fatalf("Expected ErrFrameSize for a frame of another size, got %v", err)
+	}
+	result, err := GenerateAverageFlowMapWithOptions(mixed, resolutionFactor, FlowOptions{SkipBadFrames: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].Index != 1 || !errors.Is(result.Skipped[0].Err, ErrFrameSize) {
+		t.Errorf("Expected frame 1 to be skipped for its size, got %+v", result.Skipped)
+	}
+	if _, err := GenerateFlowSequence(mixed, resolutionFactor, FlowOptions{}, SequenceOptions{}); !errors.Is(err, ErrFrameSize) {
+		t.Errorf("Expected GenerateFlowSequence to fail with ErrFrameSize, got %v", err)
+	}
+}
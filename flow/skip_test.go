@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSkipBadFrames checks that a truncated frame in the middle of a
+// sequence is skipped and tracking continues from the frame before it.
+func TestSkipBadFrames(t *testing.T) {
+	data, err := os.ReadFile("../test_data/centered.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(t.TempDir(), "truncated.png")
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	imagePaths := []string{"../test_data/centered.png", truncated, "../test_data/shifted.png"}
+
+	if _, err := GenerateAverageFlowMap(imagePaths, 4); err == nil {
+		t.Fatal("Expected the truncated frame to fail without SkipBadFrames")
+	}
+
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, 4, FlowOptions{SkipBadFrames: true})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].Index != 1 || result.Skipped[0].Path != truncated {
+		t.Fatalf("Expected frame 1 to be skipped, got %+v", result.Skipped)
+	}
+
+	want, err := GenerateAverageFlowMap([]string{imagePaths[0], imagePaths[2]}, 4)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMap failed: %v", err)
+	}
+	compareImages(t, result.Image, want, 0)
+	// The skipped frame's interval still counts towards the time covered.
+	if result.Field.Intervals != 2 {
+		t.Errorf("Expected the field to span 2 frame intervals, got %d", result.Field.Intervals)
+	}
+
+	// Too few good frames is still an error.
+	if _, err := GenerateAverageFlowMapWithOptions([]string{imagePaths[0], truncated}, 4, FlowOptions{SkipBadFrames: true}); err == nil {
+		t.Error("Expected an error when fewer than two good frames remain")
+	}
+}
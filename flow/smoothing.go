@@ -0,0 +1,227 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultSmoothingWindow is the number of pair fields a FlowAccumulator
+// averages when SmoothingOptions.Window is zero.
+const DefaultSmoothingWindow = 5
+
+// Smoothing selects how a FlowAccumulator weights the pair fields in its
+// window.
+type Smoothing int
+
+const (
+	// SmoothBoxcar weights every pair field in the window equally.
+	SmoothBoxcar Smoothing = iota
+	// SmoothExponential weights the pair field k pairs older than the
+	// newest by (1-Alpha)^k.
+	SmoothExponential
+)
+
+func (s Smoothing) String() string {
+	switch s {
+	case SmoothBoxcar:
+		return "boxcar"
+	case SmoothExponential:
+		return "exponential"
+	}
+	return "unknown"
+}
+
+// ParseSmoothing returns the Smoothing named s: "boxcar" or "exponential".
+func ParseSmoothing(s string) (Smoothing, error) {
+	for _, k := range []Smoothing{SmoothBoxcar, SmoothExponential} {
+		if k.String() == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown smoothing %q (want boxcar or exponential)", s)
+}
+
+// SmoothingOptions configures a FlowAccumulator. The zero value is a
+// boxcar average of the last DefaultSmoothingWindow pairs.
+type SmoothingOptions struct {
+	Kind Smoothing
+	// Window is the number of most recent pair fields kept and averaged;
+	// zero means DefaultSmoothingWindow.
+	Window int
+	// Alpha is the weight of the newest pair under SmoothExponential, in
+	// (0, 1]; zero means 2/(Window+1), whose weights have the same center
+	// of mass as a boxcar of Window pairs.
+	Alpha float64
+}
+
+func (o SmoothingOptions) window() int {
+	if o.Window == 0 {
+		return DefaultSmoothingWindow
+	}
+	return o.Window
+}
+
+func (o SmoothingOptions) alpha() float64 {
+	if o.Alpha == 0 {
+		return 2 / float64(o.window()+1)
+	}
+	return o.Alpha
+}
+
+func (o SmoothingOptions) validate() error {
+	if o.Kind != SmoothBoxcar && o.Kind != SmoothExponential {
+		return fmt.Errorf("unknown smoothing %d", o.Kind)
+	}
+	if o.Window < 0 {
+		return fmt.Errorf("smoothing window must not be negative, got %d", o.Window)
+	}
+	if o.Alpha < 0 || o.Alpha > 1 {
+		return fmt.Errorf("smoothing alpha must be in (0, 1], got %v", o.Alpha)
+	}
+	return nil
+}
+
+// weight returns the weight of the pair field age pairs older than the
+// newest.
+func (o SmoothingOptions) weight(age int) float64 {
+	if o.Kind == SmoothExponential {
+		return math.Pow(1-o.alpha(), float64(age))
+	}
+	return 1
+}
+
+// FlowAccumulator smooths the flow of a stream of frame pairs over time.
+// Each pair's field is computed on its own, with features detected in its
+// first frame as under SequenceOptions.PerPair, and Current averages the
+// last SmoothingOptions.Window of them. Where an Accumulator follows the
+// features that survive the whole sequence and reports their total
+// displacement, a FlowAccumulator reports the recent mean motion per frame
+// interval, which damps the jitter of noisy frame-to-frame flow.
+//
+// A FlowAccumulator is not safe for concurrent use.
+type FlowAccumulator struct {
+	opts             FlowOptions
+	smoothing        SmoothingOptions
+	resolutionFactor int
+	auto             bool         // resolutionFactor was AutoResolution
+	width, height    int          // of the first pair
+	fields           []*FlowField // the newest last
+	pairs            int
+}
+
+// NewFlowAccumulator returns an empty FlowAccumulator that computes each
+// pair's field with opts at resolutionFactor, or under AutoResolution the
+// factor for the first pair's size, and smooths them as configured by
+// smoothing. RecordPaths and Reseed do not apply to single pairs and are
+// ignored.
+func NewFlowAccumulator(resolutionFactor int, opts FlowOptions, smoothing SmoothingOptions) (*FlowAccumulator, error) {
+	if err := smoothing.validate(); err != nil {
+		return nil, err
+	}
+	return &FlowAccumulator{opts: opts, smoothing: smoothing, resolutionFactor: resolutionFactor, auto: resolutionFactor == AutoResolution}, nil
+}
+
+// Pairs returns the number of pairs added so far.
+func (a *FlowAccumulator) Pairs() int {
+	return a.pairs
+}
+
+// AddPair computes the flow from prev to next and adds it to the window,
+// dropping the oldest pair field once the window is full. The frames may be
+// grayscale or BGR, and must match the size of the first pair. prev and next
+// are only read. If the flow cannot be computed the accumulator is left
+// unchanged.
+func (a *FlowAccumulator) AddPair(prev, next gocv.Mat) error {
+	name := fmt.Sprintf("pair %d", a.pairs+1)
+	prev, closePrev := grayFrame(prev)
+	defer closePrev()
+	next, closeNext := grayFrame(next)
+	defer closeNext()
+	if err := checkFrameSize(next, prev.Cols(), prev.Rows()); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	resolutionFactor := a.resolutionFactor
+	if a.pairs == 0 {
+		resolutionFactor = a.opts.resolveResolutionFactor(resolutionFactor, prev.Cols(), prev.Rows())
+	} else if err := checkFrameSize(prev, a.width, a.height); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+
+	points := gocv.NewMat()
+	defer points.Close()
+	pair, survivors, err := pairFlow(context.Background(), prev, next, points, name+" first frame", name+" second frame", a.pairs+1, resolutionFactor, a.opts)
+	survivors.Close()
+	if err != nil {
+		return err
+	}
+	if a.pairs == 0 {
+		a.resolutionFactor = resolutionFactor
+		a.width, a.height = prev.Cols(), prev.Rows()
+	}
+	a.fields = append(a.fields, pair.Field)
+	if n := len(a.fields) - a.smoothing.window(); n > 0 {
+		a.fields = append(a.fields[:0], a.fields[n:]...)
+	}
+	a.pairs++
+	return nil
+}
+
+// grayFrame returns mat as a single-channel frame, converting a BGR or BGRA
+// one, and a function that releases what the conversion allocated.
+func grayFrame(mat gocv.Mat) (gocv.Mat, func()) {
+	var code gocv.ColorConversionCode
+	switch mat.Channels() {
+	case 3:
+		code = gocv.ColorBGRToGray
+	case 4:
+		code = gocv.ColorBGRAToGray
+	default:
+		return mat, func() {}
+	}
+	gray := gocv.NewMat()
+	gocv.CvtColor(mat, &gray, code)
+	return gray, func() { gray.Close() }
+}
+
+// Current returns the smoothed flow field: at every pixel, the weighted
+// mean displacement of the pair fields in the window that hold data there.
+// A pixel holds no data if none of them does. The displacements span one
+// frame interval and are calibrated with FlowOptions.Units; under
+// AutoResolution they are in full-resolution pixels.
+func (a *FlowAccumulator) Current() (*FlowField, error) {
+	if len(a.fields) == 0 {
+		return nil, errors.New("at least one pair is required")
+	}
+	first := a.fields[0]
+	out := NewFlowField(first.Width, first.Height)
+	out.ResolutionFactor, out.Intervals, out.Units = a.resolutionFactor, 1, a.opts.Units
+	weights := make([]float64, len(out.Valid))
+	for k, field := range a.fields {
+		w := a.smoothing.weight(len(a.fields) - 1 - k)
+		out.NonFinite += field.NonFinite
+		for i, valid := range field.Valid {
+			if !valid {
+				continue
+			}
+			out.DX[i] += w * field.DX[i]
+			out.DY[i] += w * field.DY[i]
+			weights[i] += w
+		}
+	}
+	for i, w := range weights {
+		if w == 0 {
+			out.DX[i], out.DY[i], out.Valid[i] = 0, 0, false
+			continue
+		}
+		out.DX[i] /= w
+		out.DY[i] /= w
+	}
+	if a.auto {
+		out.toFullResolution()
+	}
+	return out, nil
+}
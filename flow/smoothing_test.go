@@ -0,0 +1,134 @@
+package flow
+
+import (
+	"errors"
+	"example/goflow/flow/synth"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// jitteredPairs returns n pairs of 256x256 frames of a synth texture
+// moving by (1, 0.5) pixels per frame, taken so that the pairs alternate
+// between 3 and 1 frames of motion: (3, 1.5) and (1, 0.5) pixels, a mean
+// of (2, 1) per pair.
+func jitteredPairs(t *testing.T, n int) [][2]gocv.Mat {
+	t.Helper()
+	const size = 256
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 1, Y: 0.5}}}, 2*n+2, size, size)
+	mats := make([]gocv.Mat, len(frames))
+	for i, frame := range frames {
+		mat, err := prepImage(frame, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mats[i] = mat
+	}
+	t.Cleanup(func() {
+		for _, mat := range mats {
+			mat.Close()
+		}
+	})
+	pairs := make([][2]gocv.Mat, n)
+	for k, from := 0, 0; k < n; k++ {
+		to := from + 3 - 2*(k%2)
+		pairs[k] = [2]gocv.Mat{mats[from], mats[to]}
+		from = to
+	}
+	return pairs
+}
+
+// meanError returns the endpoint error of field against a uniform motion
+// of (dx, dy) full-resolution pixels per interval.
+func meanError(t *testing.T, field *FlowField, dx, dy float64) float64 {
+	t.Helper()
+	rf := float64(field.ResolutionFactor)
+	truth := NewFlowField(field.Width, field.Height)
+	for i := range truth.DX {
+		truth.DX[i], truth.DY[i] = dx/rf, dy/rf
+	}
+	c, err := CompareFlowFields(field, truth)
+	if err != nil {
+		t.Fatalf("CompareFlowFields failed: %v", err)
+	}
+	return c.MeanEPE * rf
+}
+
+// TestFlowAccumulatorConverges checks that smoothing alternating pair
+// motion of (3, 1.5) and (1, 0.5) pixels converges to the mean (2, 1),
+// which no single pair shows.
+func TestFlowAccumulatorConverges(t *testing.T) {
+	pairs := jitteredPairs(t, 8)
+	for _, tc := range []struct {
+		smoothing SmoothingOptions
+		maxError  float64
+	}{
+		{SmoothingOptions{Window: 4}, 0.1},
+		{SmoothingOptions{Kind: SmoothExponential, Window: 8, Alpha: 0.1}, 0.15},
+	} {
+		acc, err := NewFlowAccumulator(2, FlowOptions{}, tc.smoothing)
+		if err != nil {
+			t.Fatalf("NewFlowAccumulator failed: %v", err)
+		}
+		for k, pair := range pairs {
+			if err := acc.AddPair(pair[0], pair[1]); err != nil {
+				t.Fatalf("%v: AddPair %d failed: %v", tc.smoothing.Kind, k, err)
+			}
+		}
+		field, err := acc.Current()
+		if err != nil {
+			t.Fatalf("%v: Current failed: %v", tc.smoothing.Kind, err)
+		}
+		if field.ResolutionFactor != 2 || field.Intervals != 1 || acc.Pairs() != len(pairs) {
+			t.Errorf("%v: expected factor 2 over 1 interval after %d pairs, got %d, %d and %d", tc.smoothing.Kind, len(pairs), field.ResolutionFactor, field.Intervals, acc.Pairs())
+		}
+		if e := meanError(t, field, 2, 1); e > tc.maxError {
+			t.Errorf("%v: expected to converge to (2, 1) within %.2f pixels, got a mean error of %.3f", tc.smoothing.Kind, tc.maxError, e)
+		}
+	}
+
+	// A window of one is the last pair alone, a pixel off the mean.
+	acc, _ := NewFlowAccumulator(2, FlowOptions{}, SmoothingOptions{Window: 1})
+	for _, pair := range pairs {
+		if err := acc.AddPair(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	field, err := acc.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := meanError(t, field, 1, 0.5); e > 0.1 {
+		t.Errorf("Expected a window of one to follow the last pair's (1, 0.5), got a mean error of %.3f", e)
+	}
+}
+
+func TestFlowAccumulatorErrors(t *testing.T) {
+	for _, smoothing := range []SmoothingOptions{{Window: -1}, {Alpha: 1.5}, {Kind: 7}} {
+		if _, err := NewFlowAccumulator(2, FlowOptions{}, smoothing); err == nil {
+			t.Errorf("Expected an error for %+v", smoothing)
+		}
+	}
+	acc, err := NewFlowAccumulator(2, FlowOptions{}, SmoothingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acc.Current(); err == nil {
+		t.Error("Expected an error before any pair")
+	}
+	pairs := jitteredPairs(t, 1)
+	small := gocv.NewMatWithSize(128, 128, gocv.MatTypeCV8UC1)
+	defer small.Close()
+	if err := acc.AddPair(pairs[0][0], small); !errors.Is(err, ErrFrameSize) {
+		t.Errorf("Expected ErrFrameSize for frames of different sizes, got %v", err)
+	}
+	if acc.Pairs() != 0 {
+		t.Errorf("Expected a failed pair to leave the accumulator empty, got %d pairs", acc.Pairs())
+	}
+	if s, err := ParseSmoothing("exponential"); err != nil || s != SmoothExponential {
+		t.Errorf("Expected ParseSmoothing(exponential) to give SmoothExponential, got %v, %v", s, err)
+	}
+	if _, err := ParseSmoothing("median"); err == nil {
+		t.Error("Expected an error for an unknown smoothing")
+	}
+}
@@ -0,0 +1,83 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMinSurvivingFeatures is the FlowOptions.MinSurvivingFeatures the
+// CLI and the API apply unless told otherwise. A flow field interpolated
+// from fewer features is mostly the displacement of a few of them spread
+// over the frame.
+const DefaultMinSurvivingFeatures = 10
+
+// ErrTooFewFeatures is wrapped by the errors returned when there are too
+// few features for a result: by EstimateGlobalMotion when there are not
+// enough finite ones, or not enough in general position, to fit the model,
+// and by a *TooFewFeaturesError when fewer than
+// FlowOptions.MinSurvivingFeatures survive a sequence.
+var ErrTooFewFeatures = errors.New("flow: too few features")
+
+// TooFewFeaturesError is returned by the sparse flow computations when
+// fewer than FlowOptions.MinSurvivingFeatures features survive the
+// sequence, or a frame pair of GenerateFlowSequence, or when every feature is lost tracking one frame pair, such as
+// two frames of uncorrelated noise. It wraps ErrTooFewFeatures.
+type TooFewFeaturesError struct {
+	// Surviving is the number of features left after the last frame, and
+	// Min the number required. When every feature is lost, Surviving and
+	// Min are zero and the sequence stops at Frame.
+	Surviving, Min int
+	// Frame is the frame the most features were lost tracking to, from
+	// Previous, and Lost the number lost there out of Tracked. They are
+	// empty and zero if no feature was lost, when too few were detected.
+	Previous, Frame string
+	Lost, Tracked   int
+}
+
+func (e *TooFewFeaturesError) Error() string {
+	if e.Surviving == 0 {
+		return fmt.Sprintf("%v: all features lost tracking from %s to %s", ErrTooFewFeatures, e.Previous, e.Frame)
+	}
+	msg := fmt.Sprintf("%v: %d survived the sequence, fewer than the %d required", ErrTooFewFeatures, e.Surviving, e.Min)
+	if e.Lost > 0 {
+		msg += fmt.Sprintf("; the most, %d of %d, were lost tracking from %s to %s", e.Lost, e.Tracked, e.Previous, e.Frame)
+	}
+	return msg
+}
+
+func (e *TooFewFeaturesError) Unwrap() error {
+	return ErrTooFewFeatures
+}
+
+// featureLoss records the frame pair of a sequence that lost the most
+// features.
+type featureLoss struct {
+	previous, frame string
+	lost, tracked   int
+}
+
+// record notes that lost of tracked features were lost from previous to
+// frame, keeping the earliest pair of the largest loss.
+func (l *featureLoss) record(previous, frame string, lost, tracked int) {
+	if lost > l.lost {
+		*l = featureLoss{previous: previous, frame: frame, lost: lost, tracked: tracked}
+	}
+}
+
+// checkSurvivors returns a *TooFewFeaturesError if fewer features than
+// FlowOptions.MinSurvivingFeatures survive, and nil under MethodDense,
+// which tracks none.
+func (a *Accumulator) checkSurvivors() error {
+	required := a.opts.MinSurvivingFeatures
+	if a.opts.Method == MethodDense || a.currentPoints.Rows() >= required {
+		return nil
+	}
+	return &TooFewFeaturesError{
+		Surviving: a.currentPoints.Rows(),
+		Min:       required,
+		Previous:  a.loss.previous,
+		Frame:     a.loss.frame,
+		Lost:      a.loss.lost,
+		Tracked:   a.loss.tracked,
+	}
+}
@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// noiseFrames returns n 128x128 frames of independent uniform noise.
+func noiseFrames(n int) []image.Image {
+	rng := rand.New(rand.NewSource(1))
+	imgs := make([]image.Image, n)
+	for k := range imgs {
+		img := image.NewGray(image.Rect(0, 0, 128, 128))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(rng.Intn(256))
+		}
+		imgs[k] = img
+	}
+	return imgs
+}
+
+// TestTooFewFeaturesNoise feeds two uncorrelated noise frames, between
+// which LK matches no feature within a tracking error of 50 grey levels,
+// and checks that the error says where they were lost.
+func TestTooFewFeaturesNoise(t *testing.T) {
+	opts := FlowOptions{Features: FeatureOptions{MaxTrackingError: 50}}
+	_, err := GenerateAverageFlowMapFromImagesWithOptions(noiseFrames(2), 1, opts)
+	var few *TooFewFeaturesError
+	if !errors.As(err, &few) || !errors.Is(err, ErrTooFewFeatures) {
+		t.Fatalf("Expected a *TooFewFeaturesError, got %v", err)
+	}
+	if few.Surviving != 0 || few.Previous != "0" || few.Frame != "1" || few.Lost == 0 || few.Lost != few.Tracked {
+		t.Errorf("Expected every feature lost from frame 0 to 1, got %+v", few)
+	}
+}
+
+// TestGenerateAverageFlowMapTooFewFeatures checks that GenerateAverageFlowMap
+// accepts the four corners of the single square in the test_data frames, as
+// it always has, and that DefaultMinSurvivingFeatures rejects them.
+func TestGenerateAverageFlowMapTooFewFeatures(t *testing.T) {
+	imagePaths := []string{"../test_data/centered.png", "../test_data/shifted.png"}
+	if _, err := GenerateAverageFlowMap(imagePaths, 4); err != nil {
+		t.Fatalf("GenerateAverageFlowMap failed: %v", err)
+	}
+	_, err := GenerateAverageFlowMapWithOptions(imagePaths, 4, FlowOptions{MinSurvivingFeatures: DefaultMinSurvivingFeatures})
+	var few *TooFewFeaturesError
+	if !errors.As(err, &few) {
+		t.Fatalf("Expected a *TooFewFeaturesError, got %v", err)
+	}
+	if few.Surviving != 4 || few.Min != DefaultMinSurvivingFeatures || few.Lost != 0 {
+		t.Errorf("Expected 4 features surviving of %d required, none lost, got %+v", DefaultMinSurvivingFeatures, few)
+	}
+}
+
+// TestMinSurvivingFeatures checks that a sequence keeping fewer features
+// than FlowOptions.MinSurvivingFeatures fails with their count and the
+// frame pair that lost the most, and that no minimum accepts it.
+func TestMinSurvivingFeatures(t *testing.T) {
+	imgs := panningFrames(4)
+	opts := FlowOptions{RecordPaths: true}
+	result, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 1, opts)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+	}
+	surviving := len(result.Paths)
+
+	opts.MinSurvivingFeatures = surviving + 1
+	_, err = GenerateAverageFlowMapFromImagesWithOptions(imgs, 1, opts)
+	var few *TooFewFeaturesError
+	if !errors.As(err, &few) {
+		t.Fatalf("Expected a *TooFewFeaturesError, got %v", err)
+	}
+	if few.Surviving != surviving || few.Min != surviving+1 {
+		t.Errorf("Expected %d surviving of %d required, got %+v", surviving, surviving+1, few)
+	}
+	if few.Lost == 0 || few.Frame == "" || few.Tracked < few.Lost {
+		t.Errorf("Expected the frame pair that lost the most features, got %+v", few)
+	}
+
+	opts.MinSurvivingFeatures = surviving
+	if _, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 1, opts); err != nil {
+		t.Errorf("MinSurvivingFeatures %d: %v", surviving, err)
+	}
+}
+
+// TestFlowSequenceTooFewFeatures checks that GenerateFlowSequence applies
+// FlowOptions.MinSurvivingFeatures to every frame pair, failing on the
+// first pair of uncorrelated noise frames instead of interpolating the
+// few features LK matches by chance.
+func TestFlowSequenceTooFewFeatures(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, frame := range noiseFrames(3) {
+		path := filepath.Join(dir, fmt.Sprintf("noise%d.png", i))
+		writePNG(t, path, frame)
+		paths = append(paths, path)
+	}
+	opts := FlowOptions{MinSurvivingFeatures: 1000}
+	for _, seq := range []SequenceOptions{{}, {PerPair: true}} {
+		_, err := GenerateFlowSequence(paths, 1, opts, seq)
+		var few *TooFewFeaturesError
+		if !errors.As(err, &few) {
+			t.Fatalf("PerPair %v: expected a *TooFewFeaturesError, got %v", seq.PerPair, err)
+		}
+		if few.Frame != paths[1] || few.Previous != paths[0] {
+			t.Errorf("PerPair %v: expected the first pair to fail, got %+v", seq.PerPair, few)
+		}
+		if few.Surviving > 0 && few.Min != opts.MinSurvivingFeatures {
+			t.Errorf("PerPair %v: expected a minimum of %d, got %+v", seq.PerPair, opts.MinSurvivingFeatures, few)
+		}
+	}
+
+	opts.Method = MethodDense
+	if _, err := GenerateFlowSequence(paths, 1, opts, SequenceOptions{}); err != nil {
+		t.Errorf("Expected no minimum under MethodDense, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+// Package synth generates synthetic frame sequences with known ground-truth
+// flow, for testing and measuring the accuracy of optical flow methods.
+//
+// A scene is a smoothly textured background with textured blobs on top. The background and every blob move independently under their own
+// affine Motion, which can combine translation, rotation about a point and
+// scaling. Frames are rendered by mapping every pixel back to the first
+// frame exactly, so the only difference between the frames and the
+// ground-truth fields is 8-bit quantization.
+package synth
+
+import (
+	"image"
+	"math"
+)
+
+// Point is a position or displacement in pixels.
+type Point struct {
+	X, Y float64
+}
+
+// Motion is an affine motion repeated every frame: a point p moves to
+//
+//	Center + Scale * R(Rotation) * (p - Center) + Translate
+//
+// where R rotates clockwise on screen (x right, y down) for positive angles.
+// The zero value does not move.
+type Motion struct {
+	// Translate is the displacement per frame.
+	Translate Point
+	// Rotation is the angle per frame in radians about Center.
+	Rotation float64
+	// Scale is the zoom factor per frame about Center; zero means 1.
+	Scale float64
+	// Center is the fixed point of Rotation and Scale, in image
+	// coordinates.
+	Center Point
+}
+
+// scale returns the zoom factor of m.
+func (m Motion) scale() float64 {
+	if m.Scale == 0 {
+		return 1
+	}
+	return m.Scale
+}
+
+// Apply returns where p is one frame later.
+func (m Motion) Apply(p Point) Point {
+	s, sin, cos := m.scale(), math.Sin(m.Rotation), math.Cos(m.Rotation)
+	x, y := p.X-m.Center.X, p.Y-m.Center.Y
+	return Point{
+		X: m.Center.X + s*(cos*x-sin*y) + m.Translate.X,
+		Y: m.Center.Y + s*(sin*x+cos*y) + m.Translate.Y,
+	}
+}
+
+// invert returns where the point at p was one frame earlier.
+func (m Motion) invert(p Point) Point {
+	s, sin, cos := m.scale(), math.Sin(m.Rotation), math.Cos(m.Rotation)
+	x, y := p.X-m.Translate.X-m.Center.X, p.Y-m.Translate.Y-m.Center.Y
+	return Point{
+		X: m.Center.X + (cos*x+sin*y)/s,
+		Y: m.Center.Y + (-sin*x+cos*y)/s,
+	}
+}
+
+// origin returns where the point at p in frame k was in the first frame.
+func (m Motion) origin(p Point, k int) Point {
+	for ; k > 0; k-- {
+		p = m.invert(p)
+	}
+	return p
+}
+
+// BlobShape is the outline of a Blob.
+type BlobShape int
+
+const (
+	// BlobDisc is a disc of radius Size.
+	BlobDisc BlobShape = iota
+	// BlobSquare is an axis-aligned square, in the first frame, of half
+	// side Size.
+	BlobSquare
+)
+
+// Blob is a textured object moving independently of the background.
+type Blob struct {
+	Shape BlobShape
+	// Center and Size give the position and extent of the blob in the
+	// first frame.
+	Center Point
+	Size   float64
+	Motion Motion
+}
+
+// contains reports whether p, in first frame coordinates, is inside b.
+func (b Blob) contains(p Point) bool {
+	dx, dy := p.X-b.Center.X, p.Y-b.Center.Y
+	if b.Shape == BlobSquare {
+		return math.Abs(dx) <= b.Size && math.Abs(dy) <= b.Size
+	}
+	return dx*dx+dy*dy <= b.Size*b.Size
+}
+
+// MotionSpec describes a scene. Later blobs are drawn over earlier ones.
+type MotionSpec struct {
+	Background Motion
+	// FlatBackground draws the background at a uniform dark level instead
+	// of textured, like clear air around echoes, so that only the blobs
+	// carry motion a flow method can see. Its ground truth is still
+	// Background.
+	FlatBackground bool
+	Blobs          []Blob
+}
+
+// FlowField is a dense ground-truth displacement field stored row by row,
+// laid out like flow.FlowField. Every pixel holds data.
+type FlowField struct {
+	Width, Height int
+	DX, DY        []float64
+}
+
+// At returns the displacement at (x, y).
+func (f *FlowField) At(x, y int) (dx, dy float64) {
+	i := y*f.Width + x
+	return f.DX[i], f.DY[i]
+}
+
+// EndpointError returns the distance between the displacement (dx, dy) and
+// the ground truth at (x, y).
+func (f *FlowField) EndpointError(x, y int, dx, dy float64) float64 {
+	tx, ty := f.At(x, y)
+	return math.Hypot(dx-tx, dy-ty)
+}
+
+// Texture parameters. Blobs are brighter than the background so their
+// outlines are visible as well as their texture. The texture sums two
+// octaves of value noise, which unlike a periodic pattern gives flow
+// methods a single best match.
+const (
+	backgroundLevel = 100
+	flatLevel       = 30 // of a MotionSpec.FlatBackground
+	blobLevel       = 170
+	coarseAmp       = 90 // peak-to-peak amplitude of the coarse octave
+	fineAmp         = 40 // peak-to-peak amplitude of the fine octave
+	coarseCell      = 16 // lattice spacing of the coarse octave in pixels
+	fineCell        = 6  // lattice spacing of the fine octave in pixels
+)
+
+// texture returns the intensity of layer (0 for the background, i+1 for
+// blob i) at p in first frame coordinates. Every layer has its own noise.
+func texture(p Point, layer int) float64 {
+	level := float64(backgroundLevel)
+	if layer > 0 {
+		level = blobLevel
+	}
+	coarse := valueNoise(p.X/coarseCell, p.Y/coarseCell, 2*layer)
+	fine := valueNoise(p.X/fineCell, p.Y/fineCell, 2*layer+1)
+	return level + coarseAmp*(coarse-0.5) + fineAmp*(fine-0.5)
+}
+
+// valueNoise returns smoothly interpolated pseudo-random values in [0, 1)
+// attached to the integer lattice, one independent pattern per seed. It is
+// continuously differentiable, so frames sampled at transformed positions
+// show exactly the motion of the ground truth.
+func valueNoise(x, y float64, seed int) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	ix, iy := int(x0), int(y0)
+	fx, fy := smoothstep(x-x0), smoothstep(y-y0)
+	top := lerp(lattice(ix, iy, seed), lattice(ix+1, iy, seed), fx)
+	bottom := lerp(lattice(ix, iy+1, seed), lattice(ix+1, iy+1, seed), fx)
+	return lerp(top, bottom, fy)
+}
+
+// lattice hashes a lattice point and seed to a value in [0, 1).
+func lattice(ix, iy, seed int) float64 {
+	h := uint64(int64(ix))*0x9E3779B97F4A7C15 ^ uint64(int64(iy))*0xC2B2AE3D27D4EB4F ^ uint64(int64(seed))*0x165667B19E3779F9
+	h ^= h >> 33
+	h *= 0xFF51AFD7ED558CCD
+	h ^= h >> 33
+	return float64(h>>11) / (1 << 53)
+}
+
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// GenerateSequence renders frames width x height grayscale frames of spec,
+// the first at the positions given in spec, and returns them with the
+// frames-1 ground-truth fields: field i holds, for every pixel of frame i,
+// its displacement to frame i+1 under the motion of the layer visible
+// there. It returns nil for an empty sequence or frame size.
+func GenerateSequence(spec MotionSpec, frames, width, height int) ([]image.Image, []FlowField) {
+	if frames <= 0 || width <= 0 || height <= 0 {
+		return nil, nil
+	}
+	images := make([]image.Image, frames)
+	fields := make([]FlowField, frames-1)
+	for k := range images {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		var field *FlowField
+		if k < len(fields) {
+			fields[k] = FlowField{
+				Width:  width,
+				Height: height,
+				DX:     make([]float64, width*height),
+				DY:     make([]float64, width*height),
+			}
+			field = &fields[k]
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p := Point{X: float64(x), Y: float64(y)}
+				layer, motion := 0, spec.Background
+				for i := len(spec.Blobs) - 1; i >= 0; i-- {
+					if spec.Blobs[i].contains(spec.Blobs[i].Motion.origin(p, k)) {
+						layer, motion = i+1, spec.Blobs[i].Motion
+						break
+					}
+				}
+				g := float64(flatLevel)
+				if layer > 0 || !spec.FlatBackground {
+					g = texture(motion.origin(p, k), layer)
+				}
+				img.Pix[y*img.Stride+x] = uint8(math.Max(0, math.Min(255, math.Round(g))))
+				if field != nil {
+					next := motion.Apply(p)
+					field.DX[y*width+x] = next.X - p.X
+					field.DY[y*width+x] = next.Y - p.Y
+				}
+			}
+		}
+		images[k] = img
+	}
+	return images, fields
+}
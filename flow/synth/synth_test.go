@@ -0,0 +1,102 @@
+package synth
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestMotionInvert(t *testing.T) {
+	m := Motion{Translate: Point{X: 3, Y: -2}, Rotation: 0.3, Scale: 1.1, Center: Point{X: 40, Y: 25}}
+	p := Point{X: 7, Y: 61}
+	q := m.invert(m.Apply(p))
+	if math.Hypot(q.X-p.X, q.Y-p.Y) > 1e-9 {
+		t.Errorf("Expected invert to undo Apply, got %v from %v", q, p)
+	}
+
+	// A quarter turn clockwise on screen takes the point right of the
+	// centre to the point below it.
+	r := Motion{Rotation: math.Pi / 2, Center: Point{X: 10, Y: 10}}.Apply(Point{X: 15, Y: 10})
+	if math.Hypot(r.X-10, r.Y-15) > 1e-9 {
+		t.Errorf("Expected (10, 15), got %v", r)
+	}
+}
+
+func TestGenerateSequenceMatchesGroundTruth(t *testing.T) {
+	spec := MotionSpec{
+		Background: Motion{Translate: Point{X: 2, Y: 1}},
+		Blobs: []Blob{
+			{Shape: BlobDisc, Center: Point{X: 30, Y: 30}, Size: 12, Motion: Motion{Translate: Point{X: -3}}},
+			{Shape: BlobSquare, Center: Point{X: 70, Y: 60}, Size: 10, Motion: Motion{Translate: Point{Y: 4}}},
+		},
+	}
+	frames, fields := GenerateSequence(spec, 3, 100, 90)
+	if len(frames) != 3 || len(fields) != 2 {
+		t.Fatalf("Expected 3 frames and 2 fields, got %d and %d", len(frames), len(fields))
+	}
+
+	// With integer displacements every pixel reappears exactly where its
+	// ground truth says, unless it moves out of the frame or is covered.
+	for k, field := range fields {
+		prev, next := frames[k].(*image.Gray), frames[k+1].(*image.Gray)
+		var matched, total int
+		for y := 0; y < field.Height; y++ {
+			for x := 0; x < field.Width; x++ {
+				dx, dy := field.At(x, y)
+				nx, ny := x+int(dx), y+int(dy)
+				if nx < 0 || ny < 0 || nx >= field.Width || ny >= field.Height {
+					continue
+				}
+				total++
+				if prev.GrayAt(x, y) == next.GrayAt(nx, ny) {
+					matched++
+				}
+			}
+		}
+		if matched < total*95/100 {
+			t.Errorf("Field %d: only %d of %d pixels reappear at their ground-truth position", k, matched, total)
+		}
+	}
+
+	if dx, dy := fields[1].At(30-3, 30); dx != -3 || dy != 0 {
+		t.Errorf("Expected the disc to move by (-3, 0) in the second field, got (%v, %v)", dx, dy)
+	}
+	if dx, dy := fields[0].At(70, 60); dx != 0 || dy != 4 {
+		t.Errorf("Expected the square to move by (0, 4), got (%v, %v)", dx, dy)
+	}
+	if dx, dy := fields[0].At(5, 5); dx != 2 || dy != 1 {
+		t.Errorf("Expected the background to move by (2, 1), got (%v, %v)", dx, dy)
+	}
+}
+
+func TestGenerateSequenceRotation(t *testing.T) {
+	const theta = 0.02
+	_, fields := GenerateSequence(MotionSpec{Background: Motion{Rotation: theta, Center: Point{X: 50, Y: 50}}}, 2, 101, 101)
+	// Points move tangentially by about r * theta.
+	dx, dy := fields[0].At(90, 50)
+	if math.Abs(dx) > 0.01 || math.Abs(dy-40*theta) > 0.01 {
+		t.Errorf("Expected about (0, %.2f) at radius 40, got (%.3f, %.3f)", 40*theta, dx, dy)
+	}
+	if e := fields[0].EndpointError(50, 50, 0, 0); e > 1e-9 {
+		t.Errorf("Expected the centre to stay put, got an endpoint error of %v", e)
+	}
+}
+
+func TestGenerateSequenceFlatBackground(t *testing.T) {
+	spec := MotionSpec{
+		Background:     Motion{Translate: Point{X: 2}},
+		FlatBackground: true,
+		Blobs:          []Blob{{Shape: BlobSquare, Center: Point{X: 40, Y: 40}, Size: 10, Motion: Motion{Translate: Point{X: 5}}}},
+	}
+	frames, fields := GenerateSequence(spec, 2, 80, 80)
+	img := frames[1].(*image.Gray)
+	if g := img.GrayAt(5, 5).Y; g != flatLevel {
+		t.Errorf("Expected the flat background at %d, got %d", flatLevel, g)
+	}
+	if g := img.GrayAt(45, 40).Y; g <= flatLevel+20 {
+		t.Errorf("Expected the blob to stand out from the flat background, got %d", g)
+	}
+	if dx, dy := fields[0].At(5, 5); dx != 2 || dy != 0 {
+		t.Errorf("Expected the flat background's ground truth to be (2, 0), got (%v, %v)", dx, dy)
+	}
+}
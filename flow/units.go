@@ -0,0 +1,81 @@
+package flow
+
+import "time"
+
+// Units calibrates flow displacements in physical units. The zero value
+// leaves them in pixels.
+type Units struct {
+	// PixelSize is the side of a full-resolution pixel in meters.
+	PixelSize float64
+	// FrameInterval is the time between consecutive frames.
+	FrameInterval time.Duration
+}
+
+// hasDistance reports whether u can convert pixels to meters, and
+// hasSpeed whether it can also convert frames to seconds.
+func (u Units) hasDistance() bool {
+	return u.PixelSize > 0
+}
+
+func (u Units) hasSpeed() bool {
+	return u.hasDistance() && u.FrameInterval > 0
+}
+
+// Meters converts a full-resolution pixel distance to meters.
+func (u Units) Meters(pixels float64) float64 {
+	return pixels * u.PixelSize
+}
+
+// MetersPerSecond converts a displacement of pixels full-resolution pixels
+// over the given number of frame intervals to a speed in meters per second.
+func (u Units) MetersPerSecond(pixels float64, intervals int) float64 {
+	return u.Meters(pixels) / (float64(intervals) * u.FrameInterval.Seconds())
+}
+
+// scale returns the number of full-resolution pixels per field pixel and
+// the number of frame intervals the displacements of f span.
+func (f *FlowField) scale() (resolutionFactor, intervals int) {
+	resolutionFactor, intervals = f.ResolutionFactor, f.Intervals
+	if resolutionFactor <= 0 {
+		resolutionFactor = 1
+	}
+	if intervals <= 0 {
+		intervals = 1
+	}
+	return resolutionFactor, intervals
+}
+
+// unitScale returns the number of full-resolution pixels per unit of the
+// displacements of f: 1 if they are in full-resolution pixels, and
+// otherwise its resolution factor.
+func (f *FlowField) unitScale() int {
+	if f.FullResolution {
+		return 1
+	}
+	rf, _ := f.scale()
+	return rf
+}
+
+// DisplacementAt returns the displacement at (x, y) in meters. ok is false
+// if the pixel holds no data or f.Units has no pixel size.
+func (f *FlowField) DisplacementAt(x, y int) (dx, dy float64, ok bool) {
+	dx, dy, valid := f.At(x, y)
+	if !valid || !f.Units.hasDistance() {
+		return 0, 0, false
+	}
+	rf := f.unitScale()
+	return f.Units.Meters(dx * float64(rf)), f.Units.Meters(dy * float64(rf)), true
+}
+
+// VelocityAt returns the mean velocity at (x, y) over the sequence in
+// meters per second. ok is false if the pixel holds no data or f.Units
+// lacks the pixel size or the frame interval.
+func (f *FlowField) VelocityAt(x, y int) (vx, vy float64, ok bool) {
+	dx, dy, valid := f.At(x, y)
+	if !valid || !f.Units.hasSpeed() {
+		return 0, 0, false
+	}
+	rf := f.unitScale()
+	_, intervals := f.scale()
+	return f.Units.MetersPerSecond(dx*float64(rf), intervals), f.Units.MetersPerSecond(dy*float64(rf), intervals), true
+}
@@ -0,0 +1,81 @@
+package flow
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// radarUnits is a 1 km grid imaged every 5 minutes.
+var radarUnits = Units{PixelSize: 1000, FrameInterval: 5 * time.Minute}
+
+func TestVelocityAt(t *testing.T) {
+	field := NewFlowField(4, 4)
+	field.Units = radarUnits
+	field.Set(1, 2, 3, -3)
+	// 3 km in 5 minutes is 10 m/s.
+	vx, vy, ok := field.VelocityAt(1, 2)
+	if !ok || math.Abs(vx-10) > 1e-9 || math.Abs(vy+10) > 1e-9 {
+		t.Errorf("Expected (10, -10) m/s, got (%v, %v), %v", vx, vy, ok)
+	}
+	dx, _, ok := field.DisplacementAt(1, 2)
+	if !ok || math.Abs(dx-3000) > 1e-9 {
+		t.Errorf("Expected a 3000 m displacement, got %v, %v", dx, ok)
+	}
+
+	// The same motion at a quarter of the resolution over two intervals.
+	field.ResolutionFactor, field.Intervals = 4, 2
+	field.Set(1, 2, 1.5, 0)
+	if vx, _, _ := field.VelocityAt(1, 2); math.Abs(vx-10) > 1e-9 {
+		t.Errorf("Expected 10 m/s with a resolution factor and two intervals, got %v", vx)
+	}
+
+	field.SetNoData(0, 0)
+	if _, _, ok := field.VelocityAt(0, 0); ok {
+		t.Error("Expected no velocity at a no-data pixel")
+	}
+	field.Units = Units{PixelSize: 1000}
+	if _, _, ok := field.VelocityAt(1, 2); ok {
+		t.Error("Expected no velocity without a frame interval")
+	}
+	if _, _, ok := field.DisplacementAt(1, 2); !ok {
+		t.Error("Expected a displacement with only a pixel size")
+	}
+}
+
+// TestAccumulatorUnits checks that the accumulator calibrates its field: a
+// 3 px shift per 5-minute frame on a 1 km grid is 10 m/s.
+func TestAccumulatorUnits(t *testing.T) {
+	const resolutionFactor = 4
+	acc := NewAccumulator(FlowOptions{Method: MethodDense, Units: radarUnits})
+	defer acc.Close()
+	for _, frame := range translatingFrames(3, 3, 0) {
+		if err := acc.AddImage(frame, "frame"); err != nil {
+			t.Fatalf("AddImage failed: %v", err)
+		}
+	}
+	field, err := acc.FlowField(resolutionFactor)
+	if err != nil {
+		t.Fatalf("FlowField failed: %v", err)
+	}
+	if field.ResolutionFactor != resolutionFactor || field.Intervals != 2 || field.Units != radarUnits {
+		t.Fatalf("Expected the field to carry its calibration, got factor %d, %d intervals and %+v", field.ResolutionFactor, field.Intervals, field.Units)
+	}
+
+	var sum float64
+	var n int
+	for y := 20; y < field.Height-20; y++ {
+		for x := 20; x < field.Width-20; x++ {
+			if vx, _, ok := field.VelocityAt(x, y); ok {
+				sum += vx
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatal("No valid velocities")
+	}
+	if mean := sum / float64(n); math.Abs(mean-10) > 0.5 {
+		t.Errorf("Expected a mean velocity near 10 m/s, got %.2f", mean)
+	}
+}
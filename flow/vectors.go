@@ -0,0 +1,94 @@
+package flow
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// FlowVector is the displacement of one tracked feature, in pixels of the
+// full-resolution frames.
+type FlowVector struct {
+	X, Y   float64 // where the feature was in the first frame of the pair
+	DX, DY float64 // how far it moved
+}
+
+// SparseFlow is the sparse flow of a sequence at full precision: the feature
+// displacements a flow map interpolates, quantizes and clamps to the
+// range FlowScaleFactor allows.
+type SparseFlow struct {
+	// Width and Height are the size of the frames in pixels.
+	Width, Height int
+	Vectors       []FlowVector
+}
+
+// ComputeAverageFlow tracks features through the images at imagePaths, as
+// GenerateAverageFlowMap does, and returns their displacements instead of a
+// flow map. EncodeFlowMap turns the result into that flow map.
+func ComputeAverageFlow(imagePaths []string) (SparseFlow, error) {
+	if len(imagePaths) < 2 {
+		return SparseFlow{}, fmt.Errorf("at least two images are required, but got %d", len(imagePaths))
+	}
+	acc := NewAccumulator(FlowOptions{})
+	defer acc.Close()
+	for _, path := range imagePaths {
+		if err := acc.AddImagePath(path); err != nil {
+			return SparseFlow{}, err
+		}
+	}
+	if err := acc.checkSurvivors(); err != nil {
+		return SparseFlow{}, err
+	}
+	return acc.sparseFlow(), nil
+}
+
+// EncodeFlowMap interpolates flow onto a grid of its frame size divided by
+// resolutionFactor and encodes it as a flow map, as GenerateAverageFlowMap
+// returns it. A resolutionFactor of AutoResolution chooses the factor with
+// ResolutionFactorFor and DefaultPixelBudget.
+func EncodeFlowMap(flow SparseFlow, resolutionFactor int) (image.Image, error) {
+	resolutionFactor = FlowOptions{}.resolveResolutionFactor(resolutionFactor, flow.Width, flow.Height)
+	initial, current := flow.mats()
+	defer initial.Close()
+	defer current.Close()
+	field, err := InterpolateFlowField(initial, current, flow.Width/resolutionFactor, flow.Height/resolutionFactor, resolutionFactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := field.checkFinite(); err != nil {
+		return nil, err
+	}
+	return field.Image(), nil
+}
+
+// mats returns the start and end positions of the vectors as Nx2 CV32F
+// point matrices.
+func (f SparseFlow) mats() (initial, current gocv.Mat) {
+	initial = gocv.NewMatWithSize(len(f.Vectors), 2, gocv.MatTypeCV32F)
+	current = gocv.NewMatWithSize(len(f.Vectors), 2, gocv.MatTypeCV32F)
+	for i, v := range f.Vectors {
+		initial.SetFloatAt(i, 0, float32(v.X))
+		initial.SetFloatAt(i, 1, float32(v.Y))
+		current.SetFloatAt(i, 0, float32(v.X+v.DX))
+		current.SetFloatAt(i, 1, float32(v.Y+v.DY))
+	}
+	return initial, current
+}
+
+// sparseFlow returns the displacements of the tracked features so far,
+// those of reseeded features scaled by spanScale.
+func (a *Accumulator) sparseFlow() SparseFlow {
+	flow := SparseFlow{Width: a.width, Height: a.height, Vectors: make([]FlowVector, a.initialPoints.Rows())}
+	for i := range flow.Vectors {
+		p0, p1 := pointAt(a.initialPoints, i), pointAt(a.currentPoints, i)
+		scale := a.spanScale(i)
+		flow.Vectors[i] = FlowVector{
+			X:  float64(p0.X),
+			Y:  float64(p0.Y),
+			DX: (float64(p1.X) - float64(p0.X)) * scale,
+			DY: (float64(p1.Y) - float64(p0.Y)) * scale,
+		}
+	}
+	return flow
+}
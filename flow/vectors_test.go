@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// TestComputeAverageFlowKeepsPrecision checks that the sparse vectors carry
+// the (20, 10) shift of the test frames beyond the range a flow map can
+// encode at full resolution, and that encoding them gives the flow map of
+// GenerateAverageFlowMapWithOptions.
+func TestComputeAverageFlowKeepsPrecision(t *testing.T) {
+	imagePaths := []string{"../test_data/centered.png", "../test_data/shifted.png"}
+	flow, err := ComputeAverageFlow(imagePaths)
+	if err != nil {
+		t.Fatalf("ComputeAverageFlow failed: %v", err)
+	}
+	if flow.Width != frameSize || flow.Height != frameSize || len(flow.Vectors) == 0 {
+		t.Fatalf("Expected vectors over %dx%d frames, got %d over %dx%d", frameSize, frameSize, len(flow.Vectors), flow.Width, flow.Height)
+	}
+	var dxs, dys []float64
+	for _, v := range flow.Vectors {
+		dxs = append(dxs, v.DX)
+		dys = append(dys, v.DY)
+	}
+	sort.Float64s(dxs)
+	sort.Float64s(dys)
+	if dx, dy := dxs[len(dxs)/2], dys[len(dys)/2]; math.Abs(dx-20) > 0.5 || math.Abs(dy-10) > 0.5 {
+		t.Errorf("Expected a median displacement close to (20, 10), got (%v, %v)", dx, dy)
+	}
+
+	for _, resolutionFactor := range []int{1, 4} {
+		got, err := EncodeFlowMap(flow, resolutionFactor)
+		if err != nil {
+			t.Fatalf("EncodeFlowMap failed: %v", err)
+		}
+		want, err := GenerateAverageFlowMapWithOptions(imagePaths, resolutionFactor, FlowOptions{})
+		if err != nil {
+			t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+		}
+		compareImages(t, got, want.Image, 0)
+	}
+
+	if _, err := ComputeAverageFlow(imagePaths[:1]); err == nil {
+		t.Error("Expected an error for a single image")
+	}
+}
@@ -0,0 +1,200 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// DefaultVerifyThreshold is the grey level at or above which a pixel counts
+// as an event, such as rain, for the CSI of VerifyFlow when
+// VerifyOptions.Threshold is zero.
+const DefaultVerifyThreshold = 128
+
+// VerifyOptions configures VerifyFlow.
+type VerifyOptions struct {
+	// Threshold is the grey level, 0 to 255, at or above which a pixel is
+	// an event for the CSI. Zero means DefaultVerifyThreshold.
+	Threshold float64
+	// Forecast sets how the last frame is carried forward by the flow.
+	Forecast ForecastOptions
+}
+
+func (o VerifyOptions) threshold() float64 {
+	if o.Threshold == 0 {
+		return DefaultVerifyThreshold
+	}
+	return o.Threshold
+}
+
+func (o VerifyOptions) validate() error {
+	if o.Threshold < 0 || o.Threshold > 255 {
+		return fmt.Errorf("verification threshold must be between 0 and 255, got %v", o.Threshold)
+	}
+	return nil
+}
+
+// VerificationReport compares the forecast of the frame following a
+// sequence, the last frame warped one frame interval ahead by the
+// sequence's flow, with the frame that was observed, and with persistence,
+// the last frame itself taken as the forecast. The errors are in grey
+// levels and taken over the pixels the forecast covers, so both forecasts
+// are scored on the same pixels.
+type VerificationReport struct {
+	// ForecastMSE and PersistenceMSE are the mean squared errors of the
+	// warped and the unchanged last frame against the observed one.
+	ForecastMSE, PersistenceMSE float64
+	// MSEImprovement is the share of PersistenceMSE the forecast removes,
+	// 1 - ForecastMSE/PersistenceMSE; it is negative for a forecast worse
+	// than persistence, and zero if persistence is exact.
+	MSEImprovement float64
+	// ForecastCSI and PersistenceCSI are the critical success indices,
+	// hits / (hits + misses + false alarms), of the events at or above
+	// Threshold. They are zero if neither forecast nor observation holds
+	// an event.
+	ForecastCSI, PersistenceCSI float64
+	// CSIImprovement is ForecastCSI - PersistenceCSI.
+	CSIImprovement float64
+	// Threshold is the event threshold used.
+	Threshold float64
+	// Pixels is the number of pixels compared.
+	Pixels int
+}
+
+// VerifyFlow forecasts the frame following last by warping it one frame
+// interval ahead with field, as GenerateForecast does, and scores the
+// forecast and persistence against observed, which must have the size of
+// last. field may have any resolution factor.
+func VerifyFlow(field *FlowField, last, observed image.Image, opts VerifyOptions) (VerificationReport, error) {
+	if err := opts.validate(); err != nil {
+		return VerificationReport{}, err
+	}
+	size := last.Bounds().Size()
+	if got := observed.Bounds().Size(); got != size {
+		return VerificationReport{}, fmt.Errorf("verifying frame is %dx%d, want the last frame's %dx%d", got.X, got.Y, size.X, size.Y)
+	}
+	forecasts, err := GenerateForecast(last, field, 1, opts.Forecast)
+	if err != nil {
+		return VerificationReport{}, err
+	}
+	forecast := forecasts[0]
+
+	report := VerificationReport{Threshold: opts.threshold()}
+	var forecastSq, persistenceSq float64
+	var forecastCSI, persistenceCSI csiCounts
+	lastMin, observedMin := last.Bounds().Min, observed.Bounds().Min
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := forecast.NRGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			// Score the colour a partly covered pixel has, not its
+			// premultiplied value.
+			c.A = 255
+			f := greyLevel(c)
+			p := greyLevel(last.At(lastMin.X+x, lastMin.Y+y))
+			o := greyLevel(observed.At(observedMin.X+x, observedMin.Y+y))
+			forecastSq += (f - o) * (f - o)
+			persistenceSq += (p - o) * (p - o)
+			forecastCSI.add(f >= report.Threshold, o >= report.Threshold)
+			persistenceCSI.add(p >= report.Threshold, o >= report.Threshold)
+			report.Pixels++
+		}
+	}
+	if report.Pixels == 0 {
+		return VerificationReport{}, errors.New("the forecast covers no pixel of the verifying frame")
+	}
+	report.ForecastMSE = forecastSq / float64(report.Pixels)
+	report.PersistenceMSE = persistenceSq / float64(report.Pixels)
+	if report.PersistenceMSE > 0 {
+		report.MSEImprovement = 1 - report.ForecastMSE/report.PersistenceMSE
+	}
+	report.ForecastCSI, report.PersistenceCSI = forecastCSI.csi(), persistenceCSI.csi()
+	report.CSIImprovement = report.ForecastCSI - report.PersistenceCSI
+	return report, nil
+}
+
+// VerifyFlowResult verifies result, the flow of the frames at imagePaths,
+// against the frame at verifyPath with VerifyFlow. The last frame is the
+// last of imagePaths that result did not skip.
+func VerifyFlowResult(result FlowResult, imagePaths []string, verifyPath string, opts VerifyOptions) (VerificationReport, error) {
+	last := len(imagePaths) - 1
+	for i := len(result.Skipped) - 1; i >= 0 && last >= 0 && result.Skipped[i].Index == last; i-- {
+		last--
+	}
+	if last < 0 || result.Field == nil {
+		return VerificationReport{}, errors.New("no flow to verify")
+	}
+	lastImg, err := decodePNGFile(imagePaths[last])
+	if err != nil {
+		return VerificationReport{}, fmt.Errorf("failed to load last image %s: %w", imagePaths[last], err)
+	}
+	observed, err := decodePNGFile(verifyPath)
+	if err != nil {
+		return VerificationReport{}, fmt.Errorf("failed to load verifying image %s: %w", verifyPath, err)
+	}
+	return VerifyFlow(result.Field, lastImg, observed, opts)
+}
+
+// GenerateAndVerify computes the flow map of the frames at imagePaths, as
+// GenerateAverageFlowMapWithOptions does, and verifies it against the frame
+// at verifyPath, the one held out after them, with VerifyFlowResult.
+func GenerateAndVerify(imagePaths []string, verifyPath string, resolutionFactor int, opts FlowOptions, verify VerifyOptions) (image.Image, VerificationReport, error) {
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, resolutionFactor, opts)
+	if err != nil {
+		return nil, VerificationReport{}, err
+	}
+	report, err := VerifyFlowResult(result, imagePaths, verifyPath, verify)
+	if err != nil {
+		return nil, VerificationReport{}, err
+	}
+	return result.Image, report, nil
+}
+
+// csiCounts counts the outcomes of an event forecast.
+type csiCounts struct {
+	hits, misses, falseAlarms int
+}
+
+func (c *csiCounts) add(forecast, observed bool) {
+	switch {
+	case forecast && observed:
+		c.hits++
+	case observed:
+		c.misses++
+	case forecast:
+		c.falseAlarms++
+	}
+}
+
+func (c csiCounts) csi() float64 {
+	if n := c.hits + c.misses + c.falseAlarms; n > 0 {
+		return float64(c.hits) / float64(n)
+	}
+	return 0
+}
+
+// greyLevel returns the 0 to 255 grey level of c, weighted as prepImage
+// weighs the channels.
+func greyLevel(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)
+}
+
+// decodePNGFile opens and decodes the PNG image at path.
+func decodePNGFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PNG image: %w", err)
+	}
+	return img, nil
+}
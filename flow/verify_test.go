@@ -0,0 +1,60 @@
+package flow
+
+import (
+	"example/goflow/flow/synth"
+	"fmt"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerateAndVerify computes the flow of a translating sequence with its
+// last frame held out and checks that the warped last frame beats
+// persistence on it.
+func TestGenerateAndVerify(t *testing.T) {
+	frames, _ := synth.GenerateSequence(synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: 3, Y: 2}}}, 4, 256, 256)
+	dir := t.TempDir()
+	paths := make([]string, len(frames))
+	for i, frame := range frames {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("frame%d.png", i))
+		writePNG(t, paths[i], frame)
+	}
+	imagePaths, verifyPath := paths[:3], paths[3]
+
+	img, report, err := GenerateAndVerify(imagePaths, verifyPath, 2, FlowOptions{}, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("GenerateAndVerify failed: %v", err)
+	}
+	want, err := GenerateAverageFlowMap(imagePaths, 2)
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMap failed: %v", err)
+	}
+	compareImages(t, img, want, 0)
+	t.Logf("report: %+v", report)
+	if report.ForecastMSE >= report.PersistenceMSE || report.MSEImprovement <= 0.5 {
+		t.Errorf("Expected the warped MSE well below persistence's, got %.1f against %.1f", report.ForecastMSE, report.PersistenceMSE)
+	}
+	if report.ForecastCSI <= report.PersistenceCSI || report.CSIImprovement != report.ForecastCSI-report.PersistenceCSI {
+		t.Errorf("Expected the warped CSI above persistence's, got %.3f against %.3f", report.ForecastCSI, report.PersistenceCSI)
+	}
+	if report.Threshold != DefaultVerifyThreshold || report.Pixels < 256*256*9/10 {
+		t.Errorf("Expected the default threshold over most of the frame, got %v over %d pixels", report.Threshold, report.Pixels)
+	}
+
+	// Verifying the last frame of the sequence against itself leaves
+	// persistence exact.
+	result, err := GenerateAverageFlowMapWithOptions(imagePaths, 2, FlowOptions{})
+	if err != nil {
+		t.Fatalf("GenerateAverageFlowMapWithOptions failed: %v", err)
+	}
+	if self, err := VerifyFlowResult(result, imagePaths, imagePaths[2], VerifyOptions{}); err != nil || self.PersistenceMSE != 0 || self.PersistenceCSI != 1 {
+		t.Errorf("Expected persistence to be exact against the last frame itself, got %+v, %v", self, err)
+	}
+
+	if _, err := VerifyFlow(result.Field, frames[2], image.NewGray(image.Rect(0, 0, 8, 8)), VerifyOptions{}); err == nil {
+		t.Error("Expected an error for a verifying frame of another size")
+	}
+	if _, err := VerifyFlow(result.Field, frames[2], frames[3], VerifyOptions{Threshold: 300}); err == nil {
+		t.Error("Expected an error for a threshold above 255")
+	}
+}
@@ -0,0 +1,145 @@
+package flow
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// maxPooledMats is the most matrices a Workspace keeps for reuse; it holds
+// the two grayscale frames a flow computation works on at a time, with
+// room for a frame size change.
+const maxPooledMats = 4
+
+// Workspace holds the large buffers of a sparse flow computation so that a
+// service computing flow over and over can reuse them instead of
+// allocating them anew on every call: the grayscale frame matrices, the
+// flow field and interpolation confidence, and the flow map image. Set it
+// in FlowOptions.Workspace. Buffers are sized lazily on first use and
+// reallocated when the frame or field size changes. A nil Workspace
+// allocates fresh buffers every time, as the flow package always has.
+//
+// The Image and Field of a FlowResult computed with a Workspace are its
+// buffers, overwritten by the next computation that uses it; copy them, or
+// finish with them, first. A Workspace may be reused sequentially but is
+// not safe for concurrent use: give each worker its own. Close releases
+// its matrices.
+type Workspace struct {
+	mats       []gocv.Mat
+	field      FlowField
+	confidence [][]float64
+	confBuf    []float64
+	image8     *image.NRGBA
+	image16    *image.NRGBA64
+}
+
+// NewWorkspace returns an empty Workspace.
+func NewWorkspace() *Workspace {
+	return &Workspace{}
+}
+
+// Close releases the matrices held by the workspace. It may still be used
+// afterwards and allocates them again.
+func (w *Workspace) Close() {
+	for _, m := range w.mats {
+		m.Close()
+	}
+	w.mats = nil
+}
+
+// mat returns a rows x cols matrix of type mt, with arbitrary contents,
+// from the pool, or a new one if none fits.
+func (w *Workspace) mat(rows, cols int, mt gocv.MatType) gocv.Mat {
+	if w != nil {
+		for i, m := range w.mats {
+			if m.Rows() == rows && m.Cols() == cols && m.Type() == mt {
+				w.mats = append(w.mats[:i], w.mats[i+1:]...)
+				return m
+			}
+		}
+	}
+	return gocv.NewMatWithSize(rows, cols, mt)
+}
+
+// release returns m, which the caller no longer uses, to the pool, or
+// closes it if there is no workspace or the pool is full.
+func (w *Workspace) release(m gocv.Mat) {
+	if w == nil || m.Empty() || len(w.mats) >= maxPooledMats {
+		m.Close()
+		return
+	}
+	w.mats = append(w.mats, m)
+}
+
+// flowField returns a width x height field of zero, valid displacements
+// like NewFlowField, reusing the workspace's field.
+func (w *Workspace) flowField(width, height int) *FlowField {
+	if w == nil {
+		return NewFlowField(width, height)
+	}
+	n := width * height
+	f := &w.field
+	if cap(f.DX) < n {
+		*f = *NewFlowField(width, height)
+		return f
+	}
+	*f = FlowField{Width: width, Height: height, DX: f.DX[:n], DY: f.DY[:n], Valid: f.Valid[:n]}
+	clear(f.DX)
+	clear(f.DY)
+	for i := range f.Valid {
+		f.Valid[i] = true
+	}
+	return f
+}
+
+// confidenceMap returns a zeroed width x height confidence map indexed
+// [y][x], reusing the workspace's.
+func (w *Workspace) confidenceMap(width, height int) [][]float64 {
+	if w == nil {
+		confidence := make([][]float64, height)
+		for y := range confidence {
+			confidence[y] = make([]float64, width)
+		}
+		return confidence
+	}
+	n := width * height
+	if cap(w.confBuf) < n {
+		w.confBuf = make([]float64, n)
+	}
+	w.confBuf = w.confBuf[:n]
+	clear(w.confBuf)
+	if cap(w.confidence) < height {
+		w.confidence = make([][]float64, height)
+	}
+	w.confidence = w.confidence[:height]
+	for y := range w.confidence {
+		w.confidence[y] = w.confBuf[y*width : (y+1)*width : (y+1)*width]
+	}
+	return w.confidence
+}
+
+// nrgba returns a width x height 8-bit image with arbitrary contents,
+// reusing the workspace's.
+func (w *Workspace) nrgba(width, height int) *image.NRGBA {
+	r := image.Rect(0, 0, width, height)
+	if w == nil {
+		return image.NewNRGBA(r)
+	}
+	if w.image8 == nil || w.image8.Rect != r {
+		w.image8 = image.NewNRGBA(r)
+	}
+	return w.image8
+}
+
+// nrgba64 returns a width x height 16-bit image with arbitrary contents,
+// reusing the workspace's.
+func (w *Workspace) nrgba64(width, height int) *image.NRGBA64 {
+	r := image.Rect(0, 0, width, height)
+	if w == nil {
+		return image.NewNRGBA64(r)
+	}
+	if w.image16 == nil || w.image16.Rect != r {
+		w.image16 = image.NewNRGBA64(r)
+	}
+	return w.image16
+}
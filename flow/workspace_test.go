@@ -0,0 +1,169 @@
+package flow
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// pixelBytes returns the pixels of a flow map.
+func pixelBytes(img image.Image) []byte {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return img.Pix
+	case *image.NRGBA64:
+		return img.Pix
+	}
+	return nil
+}
+
+// TestWorkspace checks that flow computed with a reused Workspace matches
+// flow computed without one, across encodings that need differently typed
+// images.
+func TestWorkspace(t *testing.T) {
+	imgs := []image.Image{readPNG(t, "../test_data/centered.png"), readPNG(t, "../test_data/shifted.png")}
+	ws := NewWorkspace()
+	defer ws.Close()
+	for i, enc := range []Encoding{{}, {Depth: 16}, {}} {
+		want, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 2, FlowOptions{Encoding: enc})
+		if err != nil {
+			t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions failed: %v", err)
+		}
+		got, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 2, FlowOptions{Encoding: enc, Workspace: ws})
+		if err != nil {
+			t.Fatalf("GenerateAverageFlowMapFromImagesWithOptions with a workspace failed: %v", err)
+		}
+		if !reflect.DeepEqual(got.Field, want.Field) {
+			t.Errorf("Run %d: expected the workspace to reproduce the flow field", i)
+		}
+		if reflect.TypeOf(got.Image) != reflect.TypeOf(want.Image) || !bytes.Equal(pixelBytes(got.Image), pixelBytes(want.Image)) {
+			t.Errorf("Run %d: expected the workspace to reproduce the flow map", i)
+		}
+	}
+}
+
+// TestPrepImageTypes checks that the typed fast paths of prepImage convert
+// like the generic one.
+func TestPrepImageTypes(t *testing.T) {
+	src := readPNG(t, "../test_data/shifted.png")
+	b := src.Bounds()
+	typed := []draw.Image{image.NewNRGBA(b), image.NewRGBA(b), image.NewGray(b)}
+	for _, img := range typed {
+		draw.Draw(img, b, src, b.Min, draw.Src)
+		want, err := prepImage(struct{ image.Image }{img}, nil)
+		if err != nil {
+			t.Fatalf("prepImage failed: %v", err)
+		}
+		got, err := prepImage(img, nil)
+		if err != nil {
+			t.Fatalf("prepImage(%T) failed: %v", img, err)
+		}
+		if !bytes.Equal(got.ToBytes(), want.ToBytes()) {
+			t.Errorf("Expected %T to convert like any image", img)
+		}
+		got.Close()
+		want.Close()
+	}
+}
+
+// loopGray is the grayscale conversion prepImage made before it used
+// OpenCV's, one pixel at a time: the luminance of the premultiplied colors,
+// truncated.
+func loopGray(img image.Image) gocv.Mat {
+	bounds := img.Bounds()
+	mat := gocv.NewMatWithSize(bounds.Dy(), bounds.Dx(), gocv.MatTypeCV8UC1)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			mat.SetUCharAt(y-bounds.Min.Y, x-bounds.Min.X, uint8(0.299*float64(r>>8)+0.587*float64(g>>8)+0.114*float64(b>>8)))
+		}
+	}
+	return mat
+}
+
+// TestPrepImageLuminance checks that prepImage stays within one grey level
+// of the per-pixel conversion, for translucent colors and sub-images too.
+func TestPrepImageLuminance(t *testing.T) {
+	colors := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			colors.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8(x*y + 7), A: uint8(255 - x - y)})
+		}
+	}
+	frame := readPNG(t, "../rainfall_data/2025-10-03T14:40:00Z.png")
+	sub := frame.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(image.Rect(100, 200, 400, 350))
+	for _, img := range []image.Image{colors, colors.SubImage(image.Rect(5, 9, 40, 60)), frame, sub} {
+		want := loopGray(img)
+		got, err := prepImage(img, nil)
+		if err != nil {
+			t.Fatalf("prepImage failed: %v", err)
+		}
+		if got.Rows() != want.Rows() || got.Cols() != want.Cols() {
+			t.Fatalf("Expected a %dx%d matrix, got %dx%d", want.Cols(), want.Rows(), got.Cols(), got.Rows())
+		}
+		pix := got.ToBytes()
+		for i, w := range want.ToBytes() {
+			if d := int(pix[i]) - int(w); d < -1 || d > 1 {
+				t.Errorf("%T %v: pixel %d is %d, want %d within 1", img, img.Bounds(), i, pix[i], w)
+				break
+			}
+		}
+		got.Close()
+		want.Close()
+	}
+}
+
+// BenchmarkPrepImage converts a radar frame to grayscale one pixel at a
+// time, as prepImage used to, and with OpenCV, as it does now.
+func BenchmarkPrepImage(b *testing.B) {
+	frame := readPNG(b, "../rainfall_data/2025-10-03T14:40:00Z.png")
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mat := loopGray(frame)
+			mat.Close()
+		}
+	})
+	b.Run("cvtcolor", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mat, err := prepImage(frame, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			mat.Close()
+		}
+	})
+}
+
+// BenchmarkGenerateAverageFlowMap computes the flow of an in-memory frame
+// pair with fresh buffers and with a reused Workspace.
+func BenchmarkGenerateAverageFlowMap(b *testing.B) {
+	imgs := make([]image.Image, 2)
+	for i, path := range []string{"../test_data/centered.png", "../test_data/shifted.png"} {
+		imgs[i] = readPNG(b, path)
+	}
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 2, FlowOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("workspace", func(b *testing.B) {
+		ws := NewWorkspace()
+		defer ws.Close()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := GenerateAverageFlowMapFromImagesWithOptions(imgs, 2, FlowOptions{Workspace: ws}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,26 @@
+// Package frames parses the capture time encoded in radar frame filenames.
+//
+// Frames in rainfall_data are named after the RFC 3339 time they were
+// captured, for example "2025-10-03T14:40:00Z.png". Code that needs a
+// frame's timestamp should use ParseTimestamp rather than parsing names
+// itself so that every tool agrees on the convention.
+package frames
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ParseTimestamp returns the capture time encoded in the base name of path.
+// Any directory components and the file extension are ignored.
+func ParseTimestamp(path string) (time.Time, error) {
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	ts, err := time.Parse(time.RFC3339, name)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse timestamp from filename %q: %w", base, err)
+	}
+	return ts, nil
+}
@@ -0,0 +1,41 @@
+package frames
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+
+	for _, path := range []string{
+		"2025-10-03T14:40:00Z.png",
+		"rainfall_data/2025-10-03T14:40:00Z.png",
+		"../../rainfall_data/2025-10-03T14:40:00Z.png",
+	} {
+		got, err := ParseTimestamp(path)
+		if err != nil {
+			t.Fatalf("ParseTimestamp(%q) returned error: %v", path, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseTimestamp(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestParseTimestampOffset(t *testing.T) {
+	got, err := ParseTimestamp("2025-10-03T16:40:00+02:00.png")
+	if err != nil {
+		t.Fatalf("ParseTimestamp returned error: %v", err)
+	}
+	want := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestParseTimestampInvalid(t *testing.T) {
+	if _, err := ParseTimestamp("frame01.png"); err == nil {
+		t.Error("Expected an error for a filename without a timestamp")
+	}
+}
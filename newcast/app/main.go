@@ -1,12 +1,17 @@
 package main
 
 import (
+	"errors"
+	"example/goflow/fileutil"
 	"example/goflow/newcast"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"gocv.io/x/gocv"
@@ -25,6 +30,22 @@ func main() {
 	maxTracksPerCell := flag.Int("maxTracksPerCell", 5, "Maximum number of smoothest tracks to keep from a dense cell.")
 	minTrackLength := flag.Int("minTrackLength", 6, "Minimum number of points a track must have to be considered.")
 	extrapolate := flag.Int("extrapolate", 0, "Number of future points to extrapolate and draw.")
+	arrowOut := flag.String("arrow-out", "", "If set, stream all track points to this Arrow IPC (Feather) file as frames are processed.")
+	simplify := flag.Float64("simplify", 0, "If positive, write the -arrow-out track points once tracking ends, dropping those within this many pixels of the simplified space-time track.")
+	snapshotsOut := flag.String("snapshots-out", "", "If set, append a JSON-lines snapshot of the tracks' positions and velocities to this file at every frame.")
+	recordOut := flag.String("record", "", "If set, record the tracker's image processing results to this JSON-lines file for replay without OpenCV.")
+	overwrite := flag.Bool("overwrite", false, "Replace output files that already exist instead of failing.")
+	legend := flag.Bool("legend", false, "Save a legend mapping the track IDs to their colors in the visualizations.")
+	uncertainty := flag.Bool("uncertainty", false, "Draw the uncertainty radius around extrapolated points.")
+	rescue := flag.Bool("rescue", false, "Retry lost and outlier points seeded with the frame's median displacement.")
+	reseedBelow := flag.Int("reseedBelow", 0, "Detect new features whenever fewer than this many tracks remain (0 disables reseeding).")
+	minSeparation := flag.Float64("minSeparation", newcast.DefaultMinFeatureSeparation, "Minimum distance in pixels between a new feature and any existing track.")
+	retryDetection := flag.Bool("retryDetection", false, "Detect features again with relaxed thresholds in a first frame that has none, before skipping it.")
+	kalman := flag.Bool("kalman", false, "Smooth track positions and velocities with a Kalman filter and use it for the track motion.")
+	histograms := flag.Float64("histograms", 0, "If positive, save bar charts of the tracks' latest speeds, in bins this many pixels per second wide, and of their directions in 16 compass sectors.")
+	forecastOut := flag.String("forecast-out", "", "If set, write the filtered tracks' forecast positions at the -forecast-leads lead times to this file, as GeoJSON for a .geojson or .json extension and CSV otherwise.")
+	forecastLeads := flag.String("forecast-leads", "10m,30m", "Comma-separated lead times of the -forecast-out positions.")
+	geoTransform := flag.String("geo-transform", "", "Comma-separated GDAL geotransform of the frames (lon0, dlon/dx, dlon/dy, lat0, dlat/dx, dlat/dy); adds longitude and latitude to the -forecast-out positions.")
 	flag.Parse()
 
 	fmt.Printf("Running with parameters: numImages=%d, maxFeatures=%d, vectorScale=%.2f, minTrackLength=%d, extrapolate=%d\n",
@@ -78,12 +99,57 @@ func main() {
 
 	// --- Run Tracker ---
 	fmt.Println("Running tracker on rainfall data...")
-	tracker, err := newcast.NewTracker(*maxFeatures)
+	opts := newcast.TrackerOptions{
+		GroupMotionRescue:    *rescue,
+		ReseedBelow:          *reseedBelow,
+		MinFeatureSeparation: *minSeparation,
+		KalmanMotion:         *kalman,
+		RetryDetection:       *retryDetection,
+	}
+	var snapshotWriter *newcast.SnapshotWriter
+	if *snapshotsOut != "" {
+		snapshotWriter, err = newcast.OpenSnapshotFile(*snapshotsOut)
+		if err != nil {
+			fmt.Printf("Error opening %s: %v\n", *snapshotsOut, err)
+			os.Exit(1)
+		}
+		opts.OnFrame = snapshotWriter.Write
+	}
+	var recorder *newcast.Recorder
+	var recordFile *fileutil.File
+	if *recordOut != "" {
+		recordFile, err = fileutil.Create(*recordOut, *overwrite)
+		if err != nil {
+			fmt.Printf("Error creating %s: %v\n", *recordOut, err)
+			os.Exit(1)
+		}
+		defer recordFile.Abort()
+		recorder = newcast.NewRecorder(recordFile)
+		opts.Record = recorder
+	}
+	tracker, err := newcast.NewTrackerWithOptions(*maxFeatures, opts)
 	if err != nil {
 		fmt.Printf("Error creating tracker: %v\n", err)
 		os.Exit(1)
 	}
 	defer tracker.Close()
+	tracker.SetOutputFilters(buildFilters(*filterType, *minTrackLength, *smoothness, *maxAngle, *gridCellSize, *minTracksPerCell, *maxTracksPerCell)...)
+
+	var arrowWriter *newcast.ArrowWriter
+	var arrowFile *fileutil.File
+	if *arrowOut != "" {
+		arrowFile, err = fileutil.Create(*arrowOut, *overwrite)
+		if err != nil {
+			fmt.Printf("Error creating %s: %v\n", *arrowOut, err)
+			os.Exit(1)
+		}
+		defer arrowFile.Abort()
+		arrowWriter, err = newcast.NewArrowWriter(arrowFile, 0)
+		if err != nil {
+			fmt.Printf("Error creating Arrow writer: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	var width, height int
 	for i, imgPath := range testImagePaths {
@@ -100,74 +166,237 @@ func main() {
 		}
 
 		ts := time.Now().Add(time.Duration(i) * time.Minute)
-		if err := tracker.AddImage(img, ts); err != nil {
+		if err := tracker.AddImage(img, ts); errors.Is(err, newcast.ErrNoFeatures) {
+			// The tracker still awaits its first frame.
+			fmt.Printf("  %s: no features to track, skipping\n", imgPath)
+			continue
+		} else if err != nil {
 			fmt.Printf("Error adding image %s: %v\n", imgPath, err)
 			os.Exit(1)
 		}
+		if stats := tracker.Stats(); (*rescue || *reseedBelow > 0) && len(stats) > 0 {
+			last := stats[len(stats)-1]
+			fmt.Printf("  %s: %d tracked, %d lost, %d rescued, %d reseeded\n", imgPath, last.Tracked, last.Lost, last.Rescued, last.Reseeded)
+		}
+		if arrowWriter != nil && *simplify <= 0 {
+			if err := arrowWriter.WriteTracks(tracker.GetAllTracks()); err != nil {
+				fmt.Printf("Error writing track points: %v\n", err)
+				arrowFile.Abort()
+				os.Exit(1)
+			}
+		}
 	}
 	fmt.Println("Tracking complete.")
-
-	// --- Filter and Generate Visualizations ---
-	allTracks := tracker.GetTracks()
-	fmt.Printf("Found %d surviving tracks.\n", len(allTracks))
-
-	// Pre-filter by track length
-	var longTracks []*newcast.Track
-	for _, track := range allTracks {
-		if len(track.Points) >= *minTrackLength {
-			longTracks = append(longTracks, track)
+	if arrowWriter != nil {
+		// Simplified tracks are only final once tracking has ended.
+		if *simplify > 0 {
+			for _, track := range tracker.GetAllTracks() {
+				if err := arrowWriter.WriteTrack(newcast.SimplifyTrack(track, *simplify)); err != nil {
+					fmt.Printf("Error writing track points: %v\n", err)
+					arrowFile.Abort()
+					os.Exit(1)
+				}
+			}
+		}
+		if err := arrowWriter.Close(); err != nil {
+			fmt.Printf("Error finishing %s: %v\n", *arrowOut, err)
+			arrowFile.Abort()
+			os.Exit(1)
+		}
+		if err := arrowFile.Commit(); err != nil {
+			fmt.Printf("Error saving %s: %v\n", *arrowOut, err)
+			os.Exit(1)
 		}
+		fmt.Printf("Track points saved to %s\n", *arrowOut)
 	}
-	fmt.Printf("Found %d tracks with at least %d points.\n", len(longTracks), *minTrackLength)
-
-	var filteredTracks []*newcast.Track
-	switch *filterType {
-	case "density":
-		// First, apply a baseline smoothness filter
-		smoothTracks := newcast.FilterTracksBySmoothness(longTracks, *smoothness)
-		fmt.Printf("Found %d tracks passing the smoothness threshold.\n", len(smoothTracks))
-		// Then, apply the density filter to the smooth tracks
-		filteredTracks = newcast.FilterTracksByDensityAndSmoothness(smoothTracks, *gridCellSize, *minTracksPerCell, *maxTracksPerCell)
-		fmt.Printf("Filtered down to %d tracks using density filter.\n", len(filteredTracks))
-	case "max_angle":
-		filteredTracks = newcast.FilterTracksByMaxAngleChange(longTracks, *maxAngle)
-		fmt.Printf("Filtered down to %d tracks using max_angle filter.\n", len(filteredTracks))
-	default: // "smoothness"
-		filteredTracks = newcast.FilterTracksBySmoothness(longTracks, *smoothness)
-		fmt.Printf("Filtered down to %d tracks using smoothness filter.\n", len(filteredTracks))
+	if snapshotWriter != nil {
+		if err := snapshotWriter.Close(); err != nil {
+			fmt.Printf("Error finishing %s: %v\n", *snapshotsOut, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Frame snapshots appended to %s\n", *snapshotsOut)
+	}
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			fmt.Printf("Error finishing %s: %v\n", *recordOut, err)
+			os.Exit(1)
+		}
+		if err := recordFile.Commit(); err != nil {
+			fmt.Printf("Error saving %s: %v\n", *recordOut, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Tracker recording saved to %s\n", *recordOut)
 	}
 
+	// --- Filter and Generate Visualizations ---
+	fmt.Printf("Found %d surviving tracks.\n", len(tracker.GetAllTracks()))
+	filteredTracks := tracker.GetTracks()
+	fmt.Printf("Filtered down to %d tracks of at least %d points using %s filter.\n", len(filteredTracks), *minTrackLength, *filterType)
+
+	// One assigner for every image, so a track has the same color in each.
+	colors := newcast.NewColorAssigner()
+
 	// Visualize tracks as lines
-	trackImg := newcast.VisualizeTracks(filteredTracks, width, height)
+	trackImg := newcast.VisualizeTracks(filteredTracks, width, height, colors)
 	defer trackImg.Close()
 	trackImgPath := "rainfall_tracks.png"
-	if ok := gocv.IMWrite(trackImgPath, trackImg); !ok {
-		fmt.Printf("Error writing track visualization to %s\n", trackImgPath)
+	if err := writeMat(trackImgPath, trackImg, *overwrite); err != nil {
+		fmt.Printf("Error writing track visualization to %s: %v\n", trackImgPath, err)
 		os.Exit(1)
 	}
 	fmt.Printf("Track visualization saved to %s\n", trackImgPath)
 
 	// Visualize final velocity vectors
-	vectorImg := newcast.VisualizeVectors(filteredTracks, width, height, float32(*vectorScale))
+	vectorImg := newcast.VisualizeVectors(filteredTracks, width, height, float32(*vectorScale), colors)
 	defer vectorImg.Close()
 	vectorImgPath := "rainfall_vectors.png"
-	if ok := gocv.IMWrite(vectorImgPath, vectorImg); !ok {
-		fmt.Printf("Error writing vector visualization to %s\n", vectorImgPath)
+	if err := writeMat(vectorImgPath, vectorImg, *overwrite); err != nil {
+		fmt.Printf("Error writing vector visualization to %s: %v\n", vectorImgPath, err)
 		os.Exit(1)
 	}
 	fmt.Printf("Vector visualization saved to %s\n", vectorImgPath)
 
 	// Visualize extrapolated tracks if requested
 	if *extrapolate > 0 {
-		extrapolatedImg := newcast.VisualizeExtrapolatedTracks(filteredTracks, width, height, *extrapolate)
+		var extrapolatedImg gocv.Mat
+		if *uncertainty {
+			extrapolatedImg = newcast.VisualizeExtrapolatedTracksWithUncertainty(filteredTracks, width, height, *extrapolate, colors)
+		} else {
+			extrapolatedImg = newcast.VisualizeExtrapolatedTracks(filteredTracks, width, height, *extrapolate, colors)
+		}
 		defer extrapolatedImg.Close()
 		extrapolatedImgPath := "rainfall_tracks_extrapolated.png"
-		if ok := gocv.IMWrite(extrapolatedImgPath, extrapolatedImg); !ok {
-			fmt.Printf("Error writing extrapolated track visualization to %s\n", extrapolatedImgPath)
+		if err := writeMat(extrapolatedImgPath, extrapolatedImg, *overwrite); err != nil {
+			fmt.Printf("Error writing extrapolated track visualization to %s: %v\n", extrapolatedImgPath, err)
 			os.Exit(1)
 		}
 		fmt.Printf("Extrapolated track visualization saved to %s\n", extrapolatedImgPath)
 	}
+
+	// Save the motion histograms if requested
+	if *histograms > 0 {
+		speedBins, speedEdges, directionBins, err := newcast.MotionHistograms(filteredTracks, *histograms, 16)
+		if err != nil {
+			fmt.Printf("Error computing the motion histograms: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Speed histogram (bins of %g px/s): %v\n", *histograms, speedBins)
+		fmt.Printf("Direction histogram (N, NNE, ... NNW): %v\n", directionBins)
+		charts := []struct {
+			path  string
+			chart gocv.Mat
+		}{
+			{"rainfall_speed_histogram.png", newcast.VisualizeSpeedHistogram(speedBins, speedEdges)},
+			{"rainfall_direction_histogram.png", newcast.VisualizeDirectionHistogram(directionBins)},
+		}
+		for _, c := range charts {
+			defer c.chart.Close()
+			if c.chart.Empty() {
+				fmt.Printf("No moving tracks; skipping %s.\n", c.path)
+				continue
+			}
+			if err := writeMat(c.path, c.chart, *overwrite); err != nil {
+				fmt.Printf("Error writing histogram to %s: %v\n", c.path, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Histogram saved to %s\n", c.path)
+		}
+	}
+
+	// Export the forecast positions if requested
+	if *forecastOut != "" {
+		if err := writeForecast(*forecastOut, filteredTracks, *forecastLeads, *geoTransform, *overwrite); err != nil {
+			fmt.Printf("Error writing forecast positions to %s: %v\n", *forecastOut, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Forecast positions saved to %s\n", *forecastOut)
+	}
+
+	// Save the key to the track colors if requested
+	if *legend {
+		legendImg := colors.Legend()
+		defer legendImg.Close()
+		if legendImg.Empty() {
+			fmt.Println("No tracks drawn; skipping the legend.")
+			return
+		}
+		legendImgPath := "rainfall_legend.png"
+		if err := writeMat(legendImgPath, legendImg, *overwrite); err != nil {
+			fmt.Printf("Error writing track color legend to %s: %v\n", legendImgPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Track color legend saved to %s\n", legendImgPath)
+	}
+}
+
+// buildFilters returns the output filter chain selected by the flags: a
+// minimum length filter followed by the filterType filter. The density
+// filter runs on tracks that pass the smoothness threshold.
+func buildFilters(filterType string, minTrackLength int, smoothness, maxAngle float64, gridCellSize, minTracksPerCell, maxTracksPerCell int) newcast.FilterChain {
+	chain := newcast.FilterChain{newcast.MinLengthFilter{MinPoints: minTrackLength}}
+	switch filterType {
+	case "density":
+		chain = append(chain,
+			newcast.SmoothnessFilter{MaxAverageAngleChange: smoothness},
+			newcast.DensityFilter{GridCellSize: gridCellSize, MinTracksPerCell: minTracksPerCell, MaxTracksPerCell: maxTracksPerCell})
+	case "max_angle":
+		chain = append(chain, newcast.MaxAngleChangeFilter{MaxAngleChange: maxAngle})
+	default: // "smoothness"
+		chain = append(chain, newcast.SmoothnessFilter{MaxAverageAngleChange: smoothness})
+	}
+	return chain
+}
+
+// writeForecast writes the forecast positions of tracks at the
+// comma-separated lead times to path atomically, in the format of its
+// extension, geolocated by the comma-separated geotransform if it is not
+// empty.
+func writeForecast(path string, tracks []*newcast.Track, leads, geoTransform string, overwrite bool) error {
+	var leadTimes []time.Duration
+	for _, s := range strings.Split(leads, ",") {
+		lead, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid lead time: %w", err)
+		}
+		leadTimes = append(leadTimes, lead)
+	}
+	var opts newcast.ForecastExportOptions
+	if geoTransform != "" {
+		fields := strings.Split(geoTransform, ",")
+		if len(fields) != 6 {
+			return fmt.Errorf("a geotransform has 6 coefficients, got %d", len(fields))
+		}
+		var geo newcast.GeoTransform
+		for i, field := range fields {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return fmt.Errorf("invalid geotransform coefficient: %w", err)
+			}
+			geo[i] = v
+		}
+		opts.Geo = &geo
+	}
+	format := newcast.ForecastCSV
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".geojson" || ext == ".json" {
+		format = newcast.ForecastGeoJSON
+	}
+	return fileutil.WriteAtomic(path, func(w io.Writer) error {
+		return newcast.ExportForecastPositionsWithOptions(tracks, leadTimes, w, format, opts)
+	}, overwrite)
+}
+
+// writeMat encodes mat in the format of path's extension and writes it
+// atomically.
+func writeMat(path string, mat gocv.Mat, overwrite bool) error {
+	return fileutil.WriteAtomic(path, func(w io.Writer) error {
+		buf, err := gocv.IMEncode(gocv.FileExt(filepath.Ext(path)), mat)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		defer buf.Close()
+		_, err = w.Write(buf.GetBytes())
+		return err
+	}, overwrite)
 }
 
 // loadImageAsGrayscale loads an image from the given path and converts it to a grayscale gocv.Mat.
@@ -0,0 +1,204 @@
+package newcast
+
+import (
+	"example/goflow/fileutil"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// DefaultArrowBatchRows is the number of rows per record batch used when
+// NewArrowWriter is given a non-positive batch size.
+const DefaultArrowBatchRows = 64 * 1024
+
+// TrackPointSchema is the schema of the track point files written by
+// ArrowWriter. t is the point's time in Unix milliseconds, x and y are in
+// pixels, and vx and vy are the finite-difference velocity from the previous
+// point of the same track in pixels per second (null for a track's first
+// point). score is the track's combined RMS fit residual in pixels at the
+// time the point was written (null if the track had no fit yet); lower is
+// better.
+var TrackPointSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "track_id", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "t", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "x", Type: arrow.PrimitiveTypes.Float32},
+	{Name: "y", Type: arrow.PrimitiveTypes.Float32},
+	{Name: "vx", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+	{Name: "vy", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+	{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+}, nil)
+
+// ArrowWriter streams track points to an Apache Arrow IPC file (Feather v2).
+// It remembers how many points of each track it has already written, so it
+// can be called with the tracker's tracks after every frame and will only
+// append the new points.
+type ArrowWriter struct {
+	fw        *ipc.FileWriter
+	builder   *array.RecordBuilder
+	batchRows int
+	pending   int
+	written   map[int]int // track ID -> number of points written
+}
+
+// NewArrowWriter starts an Arrow IPC file on w. Points are buffered and
+// written as one record batch every batchRows rows. Close must be called to
+// flush the last batch and write the file footer.
+func NewArrowWriter(w io.Writer, batchRows int) (*ArrowWriter, error) {
+	if batchRows <= 0 {
+		batchRows = DefaultArrowBatchRows
+	}
+	mem := memory.NewGoAllocator()
+	fw, err := ipc.NewFileWriter(w, ipc.WithSchema(TrackPointSchema), ipc.WithAllocator(mem))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create arrow file writer: %w", err)
+	}
+	builder := array.NewRecordBuilder(mem, TrackPointSchema)
+	builder.Reserve(batchRows)
+	return &ArrowWriter{
+		fw:        fw,
+		builder:   builder,
+		batchRows: batchRows,
+		written:   make(map[int]int),
+	}, nil
+}
+
+// WriteTracks appends the points of tracks that have not been written yet.
+func (aw *ArrowWriter) WriteTracks(tracks []*Track) error {
+	for _, track := range tracks {
+		if err := aw.WriteTrack(track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTrack appends the points of track that have not been written yet.
+func (aw *ArrowWriter) WriteTrack(track *Track) error {
+	start := aw.written[track.ID]
+	if start >= len(track.Points) {
+		return nil
+	}
+
+	fitted := track.PolyX != (Polynomial{}) || track.PolyY != (Polynomial{})
+	score := math.Hypot(track.ResidualX, track.ResidualY)
+
+	b := aw.builder
+	for i := start; i < len(track.Points); i++ {
+		p := track.Points[i]
+		b.Field(0).(*array.Int64Builder).Append(int64(track.ID))
+		b.Field(1).(*array.Int64Builder).Append(p.Time.UnixMilli())
+		b.Field(2).(*array.Float32Builder).Append(p.Vec.X)
+		b.Field(3).(*array.Float32Builder).Append(p.Vec.Y)
+
+		vx, vy := b.Field(4).(*array.Float32Builder), b.Field(5).(*array.Float32Builder)
+		if dt := pointInterval(track.Points, i); dt > 0 {
+			prev := track.Points[i-1]
+			vx.Append((p.Vec.X - prev.Vec.X) / float32(dt))
+			vy.Append((p.Vec.Y - prev.Vec.Y) / float32(dt))
+		} else {
+			vx.AppendNull()
+			vy.AppendNull()
+		}
+
+		if fitted {
+			b.Field(6).(*array.Float64Builder).Append(score)
+		} else {
+			b.Field(6).(*array.Float64Builder).AppendNull()
+		}
+
+		aw.pending++
+		if aw.pending >= aw.batchRows {
+			if err := aw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	aw.written[track.ID] = len(track.Points)
+	return nil
+}
+
+// pointInterval returns the time in seconds between point i and the one
+// before it, or 0 for the first point.
+func pointInterval(points []Point, i int) float64 {
+	if i == 0 {
+		return 0
+	}
+	return points[i].Time.Sub(points[i-1].Time).Seconds()
+}
+
+// Flush writes the buffered points as a record batch, if there are any.
+func (aw *ArrowWriter) Flush() error {
+	if aw.pending == 0 {
+		return nil
+	}
+	rec := aw.builder.NewRecordBatch()
+	defer rec.Release()
+	aw.pending = 0
+	if err := aw.fw.Write(rec); err != nil {
+		return fmt.Errorf("failed to write arrow record batch: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the remaining points and finishes the file. It does not
+// close the underlying writer.
+func (aw *ArrowWriter) Close() error {
+	defer aw.builder.Release()
+	if err := aw.Flush(); err != nil {
+		return err
+	}
+	if err := aw.fw.Close(); err != nil {
+		return fmt.Errorf("failed to close arrow file writer: %w", err)
+	}
+	return nil
+}
+
+// ExportOptions configures WriteTracksArrowWithOptions.
+type ExportOptions struct {
+	// Simplify prunes the points of each track with
+	// SimplifyTrackWithOptions before it is written; the zero value writes
+	// every point. ArrowWriter, which streams points as they are tracked,
+	// cannot simplify, since later points decide which earlier ones stay.
+	Simplify SimplifyOptions
+}
+
+// WriteTracksArrow writes all points of tracks to an Arrow IPC file at path,
+// atomically replacing any existing file.
+func WriteTracksArrow(path string, tracks []*Track) error {
+	return WriteTracksArrowWithOptions(path, tracks, ExportOptions{})
+}
+
+// WriteTracksArrowWithOptions is like WriteTracksArrow, with the points
+// written chosen by opts.
+func WriteTracksArrowWithOptions(path string, tracks []*Track, opts ExportOptions) error {
+	file, err := fileutil.Create(path, true)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	aw, err := NewArrowWriter(file, 0)
+	if err != nil {
+		return err
+	}
+	if opts.Simplify.Epsilon > 0 {
+		simplified := make([]*Track, len(tracks))
+		for i, track := range tracks {
+			simplified[i] = SimplifyTrackWithOptions(track, opts.Simplify)
+		}
+		tracks = simplified
+	}
+	if err := aw.WriteTracks(tracks); err != nil {
+		aw.Close()
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	return file.Commit()
+}
@@ -0,0 +1,91 @@
+package newcast
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"gocv.io/x/gocv"
+)
+
+func TestArrowWriterStreamingRoundTrip(t *testing.T) {
+	const numTracks, numFrames = 3, 5
+	t0 := time.Date(2025, 10, 3, 14, 0, 0, 0, time.UTC)
+	tracks := make([]*Track, numTracks)
+	for i := range tracks {
+		tracks[i] = &Track{ID: 10 + i}
+	}
+
+	var buf bytes.Buffer
+	aw, err := NewArrowWriter(&buf, 4)
+	if err != nil {
+		t.Fatalf("NewArrowWriter failed: %v", err)
+	}
+	// Grow the tracks one frame at a time and write after each frame, as the
+	// app does while the tracker runs.
+	for f := 0; f < numFrames; f++ {
+		for i, track := range tracks {
+			track.Points = append(track.Points, Point{
+				Time: t0.Add(time.Duration(f) * time.Minute),
+				Vec:  gocv.Point2f{X: float32(100*i + 6*f), Y: float32(50 + 3*f)},
+			})
+		}
+		if err := aw.WriteTracks(tracks); err != nil {
+			t.Fatalf("WriteTracks failed at frame %d: %v", f, err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := ipc.NewFileReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewFileReader failed: %v", err)
+	}
+	defer r.Close()
+	if !r.Schema().Equal(TrackPointSchema) {
+		t.Fatalf("Unexpected schema: %v", r.Schema())
+	}
+
+	rows := 0
+	for i := 0; i < r.NumRecords(); i++ {
+		rec, err := r.RecordBatch(i)
+		if err != nil {
+			t.Fatalf("RecordBatch(%d) failed: %v", i, err)
+		}
+		if rec.NumRows() > 4 {
+			t.Errorf("Batch %d has %d rows, more than the batch size", i, rec.NumRows())
+		}
+
+		ids := rec.Column(0).(*array.Int64)
+		ts := rec.Column(1).(*array.Int64)
+		xs := rec.Column(2).(*array.Float32)
+		ys := rec.Column(3).(*array.Float32)
+		vxs := rec.Column(4).(*array.Float32)
+		vys := rec.Column(5).(*array.Float32)
+		for j := 0; j < int(rec.NumRows()); j++ {
+			f := int(ts.Value(j)-t0.UnixMilli()) / int(time.Minute/time.Millisecond)
+			track := int(ids.Value(j)) - 10
+			if want := float32(100*track + 6*f); xs.Value(j) != want {
+				t.Errorf("Track %d frame %d: expected x %.1f, got %.1f", track, f, want, xs.Value(j))
+			}
+			if want := float32(50 + 3*f); ys.Value(j) != want {
+				t.Errorf("Track %d frame %d: expected y %.1f, got %.1f", track, f, want, ys.Value(j))
+			}
+			if f == 0 {
+				if !vxs.IsNull(j) || !vys.IsNull(j) {
+					t.Errorf("Track %d: expected null velocity for the first point", track)
+				}
+			} else if math.Abs(float64(vxs.Value(j))-0.1) > 1e-6 || math.Abs(float64(vys.Value(j))-0.05) > 1e-6 {
+				t.Errorf("Track %d frame %d: expected velocity (0.1, 0.05) px/s, got (%f, %f)", track, f, vxs.Value(j), vys.Value(j))
+			}
+		}
+		rows += int(rec.NumRows())
+	}
+	if rows != numTracks*numFrames {
+		t.Errorf("Expected %d rows, got %d", numTracks*numFrames, rows)
+	}
+}
@@ -0,0 +1,99 @@
+package newcast
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// ColorAssigner picks the color of each track from its ID, so every
+// visualization drawn with the same assigner colors a track the same way.
+// Create one per run and pass it to all the visualizers; it remembers the
+// tracks it colored for Legend.
+//
+// A ColorAssigner is not safe for concurrent use.
+type ColorAssigner struct {
+	used map[int]bool
+}
+
+// NewColorAssigner returns an assigner that has colored no tracks yet.
+func NewColorAssigner() *ColorAssigner {
+	return &ColorAssigner{used: make(map[int]bool)}
+}
+
+// goldenRatioConjugate spaces the hues of consecutive IDs as far apart as
+// possible however many tracks there are.
+const goldenRatioConjugate = 0.618033988749895
+
+// Color returns the color of track id: a bright, saturated hue that differs
+// clearly from those of nearby IDs. It depends only on id.
+func (a *ColorAssigner) Color(id int) color.RGBA {
+	a.used[id] = true
+	_, hue := math.Modf(float64(id) * goldenRatioConjugate)
+	return hsvColor(hue, 0.85, 1)
+}
+
+// hsvColor converts a hue, saturation and value between 0 and 1 to an
+// opaque color.
+func hsvColor(h, s, v float64) color.RGBA {
+	h *= 6
+	sector := math.Floor(h)
+	f := h - sector
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var r, g, b float64
+	switch int(sector) % 6 {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return color.RGBA{R: uint8(math.Round(r * 255)), G: uint8(math.Round(g * 255)), B: uint8(math.Round(b * 255)), A: 255}
+}
+
+// Legend layout, in pixels.
+const (
+	legendRowHeight  = 20
+	legendRowsPerCol = 40
+	legendColWidth   = 110
+)
+
+// Legend draws a key of the tracks colored so far, in order of ID: a swatch
+// of each track's color next to its ID, in columns of up to 40 rows. It
+// returns an empty Mat if no track has been colored. The caller must close
+// the returned Mat.
+func (a *ColorAssigner) Legend() gocv.Mat {
+	ids := make([]int, 0, len(a.used))
+	for id := range a.used {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return gocv.NewMat()
+	}
+	sort.Ints(ids)
+
+	rows := min(len(ids), legendRowsPerCol)
+	cols := (len(ids) + legendRowsPerCol - 1) / legendRowsPerCol
+	img := gocv.NewMatWithSize(rows*legendRowHeight, cols*legendColWidth, gocv.MatTypeCV8UC3)
+	img.SetTo(gocv.NewScalar(0, 0, 0, 0)) // Black background
+	for i, id := range ids {
+		x := (i / legendRowsPerCol) * legendColWidth
+		y := (i % legendRowsPerCol) * legendRowHeight
+		swatch := image.Rect(x+4, y+4, x+20, y+legendRowHeight-4)
+		gocv.Rectangle(&img, swatch, a.Color(id), -1)
+		gocv.PutText(&img, fmt.Sprintf("track %d", id), image.Pt(x+26, y+legendRowHeight-6),
+			gocv.FontHersheySimplex, 0.45, color.RGBA{R: 255, G: 255, B: 255, A: 255}, 1)
+	}
+	return img
+}
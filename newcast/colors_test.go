@@ -0,0 +1,92 @@
+package newcast
+
+import (
+	"image/color"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// straightTracks returns n tracks of three points moving right, 20 pixels
+// apart vertically, with IDs from firstID.
+func straightTracks(n, firstID int) []*Track {
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	tracks := make([]*Track, n)
+	for i := range tracks {
+		y := float32(10 + 20*i)
+		track := &Track{ID: firstID + i, LatestVelocity: gocv.Point2f{X: 1}}
+		for j := 0; j < 3; j++ {
+			track.Points = append(track.Points, Point{Time: ts.Add(time.Duration(j) * time.Minute), Vec: gocv.Point2f{X: float32(10 + 10*j), Y: y}})
+		}
+		tracks[i] = track
+	}
+	return tracks
+}
+
+// pixelColor returns the color of pixel (x, y) of a BGR image.
+func pixelColor(img gocv.Mat, x, y int) color.RGBA {
+	v := img.GetVecbAt(y, x)
+	return color.RGBA{R: v[2], G: v[1], B: v[0], A: 255}
+}
+
+func TestColorAssignerConsistentAcrossVisualizations(t *testing.T) {
+	tracks := straightTracks(5, 7)
+	colors := NewColorAssigner()
+
+	// Draw the vectors in a different track order, as a filter might.
+	reversed := make([]*Track, len(tracks))
+	for i, track := range tracks {
+		reversed[len(tracks)-1-i] = track
+	}
+	paths := VisualizeTracks(tracks, 64, 128, colors)
+	defer paths.Close()
+	vectors := VisualizeVectors(reversed, 64, 128, 10, colors)
+	defer vectors.Close()
+
+	seen := make(map[color.RGBA]int)
+	for _, track := range tracks {
+		// The paths pass through the middle point, and every vector starts
+		// at the last one.
+		mid, last := track.Points[1].Vec, track.Points[2].Vec
+		inPaths := pixelColor(paths, int(mid.X), int(mid.Y))
+		inVectors := pixelColor(vectors, int(last.X), int(last.Y))
+		if inPaths != inVectors {
+			t.Errorf("Track %d is %v in the paths but %v in the vectors", track.ID, inPaths, inVectors)
+		}
+		if want := colors.Color(track.ID); inPaths != want {
+			t.Errorf("Track %d is drawn %v, want %v", track.ID, inPaths, want)
+		}
+		if other, ok := seen[inPaths]; ok {
+			t.Errorf("Tracks %d and %d share the color %v", other, track.ID, inPaths)
+		}
+		seen[inPaths] = track.ID
+	}
+
+	// A second assigner colors the same IDs the same way.
+	if NewColorAssigner().Color(9) != colors.Color(9) {
+		t.Error("Expected the color of a track to depend only on its ID")
+	}
+}
+
+func TestColorAssignerLegend(t *testing.T) {
+	colors := NewColorAssigner()
+	empty := colors.Legend()
+	defer empty.Close()
+	if !empty.Empty() {
+		t.Error("Expected an empty legend before any track is colored")
+	}
+
+	paths := VisualizeTracks(straightTracks(45, 0), 64, 1024, colors)
+	defer paths.Close()
+	legend := colors.Legend()
+	defer legend.Close()
+	// 45 tracks take a full column of 40 and a second one.
+	if legend.Rows() != legendRowsPerCol*legendRowHeight || legend.Cols() != 2*legendColWidth {
+		t.Errorf("Expected a %dx%d legend, got %dx%d", 2*legendColWidth, legendRowsPerCol*legendRowHeight, legend.Cols(), legend.Rows())
+	}
+	// The swatch of the first track in the second column.
+	if got, want := pixelColor(legend, legendColWidth+10, legendRowHeight/2), colors.Color(40); got != want {
+		t.Errorf("Expected the swatch of track 40 to be %v, got %v", want, got)
+	}
+}
@@ -2,6 +2,7 @@ package newcast
 
 import (
 	"errors"
+	"math"
 )
 
 // Polynomial represents the coefficients of a degree 2 polynomial: a*t^2 + b*t + c
@@ -112,7 +113,6 @@ func copyMatrix(m [][]float64) [][]float64 {
 	return c
 }
 
-
 // Eval evaluates the polynomial at a given time t.
 func (p *Polynomial) Eval(t float64) float64 {
 	return p.A*t*t + p.B*t + p.C
@@ -126,4 +126,24 @@ func (p *Polynomial) Velocity(t float64) float64 {
 // Acceleration evaluates the acceleration (second derivative) of the polynomial.
 func (p *Polynomial) Acceleration() float64 {
 	return 2 * p.A
-}
\ No newline at end of file
+}
+
+// ResidualRMS returns the root-mean-square residual of polyX and polyY
+// against the X and Y coordinates of points, with time measured in seconds
+// from the first point as in FitQuadratic.
+func ResidualRMS(points []Point, polyX, polyY Polynomial) (rmsX, rmsY float64) {
+	if len(points) == 0 {
+		return 0, 0
+	}
+	t0 := points[0].Time
+	var sumX, sumY float64
+	for _, p := range points {
+		t := p.Time.Sub(t0).Seconds()
+		dx := float64(p.Vec.X) - polyX.Eval(t)
+		dy := float64(p.Vec.Y) - polyY.Eval(t)
+		sumX += dx * dx
+		sumY += dy * dy
+	}
+	n := float64(len(points))
+	return math.Sqrt(sumX / n), math.Sqrt(sumY / n)
+}
@@ -0,0 +1,148 @@
+package newcast
+
+import (
+	"image"
+	"math"
+	"time"
+)
+
+// EventKind identifies what happened to a track in a TrackEvent.
+type EventKind int
+
+const (
+	// TrackCreated is emitted when a track starts, in the first frame or
+	// when reseeding.
+	TrackCreated EventKind = iota
+	// TrackLost is emitted when a track's feature could not be followed
+	// into the new frame. TrackEvent.Reason says why.
+	TrackLost
+	// TrackAccelerated is emitted when a track's acceleration rises above
+	// TrackerOptions.AccelerationThreshold. It is emitted again only after
+	// the acceleration has dropped back below the threshold.
+	TrackAccelerated
+	// TrackEnteredRegion is emitted when a track moves from outside to
+	// inside one of TrackerOptions.Regions. Tracks created inside a region
+	// have not entered it.
+	TrackEnteredRegion
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case TrackCreated:
+		return "created"
+	case TrackLost:
+		return "lost"
+	case TrackAccelerated:
+		return "accelerated"
+	case TrackEnteredRegion:
+		return "entered_region"
+	}
+	return "unknown"
+}
+
+// LostReason says why a track was lost.
+type LostReason int
+
+const (
+	// LostNotMatched means optical flow found no match for the feature
+	// inside the frame.
+	LostNotMatched LostReason = iota
+	// LostLeftImage means the track's last known motion carries it out of
+	// the frame, or too close to its edge for the LK window to fit.
+	LostLeftImage
+)
+
+func (r LostReason) String() string {
+	switch r {
+	case LostNotMatched:
+		return "not_matched"
+	case LostLeftImage:
+		return "left_image"
+	}
+	return "unknown"
+}
+
+// lostEdgeMargin is half the default 21x21 LK window: a feature predicted
+// closer than this to the frame edge cannot be matched in full.
+const lostEdgeMargin = 10
+
+// Region is a named watch area in image coordinates.
+type Region struct {
+	Name   string
+	Bounds image.Rectangle
+}
+
+// contains reports whether pt lies inside the region.
+func (r Region) contains(pt Point) bool {
+	x, y := float64(pt.Vec.X), float64(pt.Vec.Y)
+	return x >= float64(r.Bounds.Min.X) && x < float64(r.Bounds.Max.X) &&
+		y >= float64(r.Bounds.Min.Y) && y < float64(r.Bounds.Max.Y)
+}
+
+// TrackEvent is a track lifecycle event passed to TrackerOptions.OnEvent.
+type TrackEvent struct {
+	Kind EventKind
+	// Time is the capture time of the frame in which the event happened.
+	Time time.Time
+	// Track is a copy of the track when the event happened; it does not
+	// change as tracking continues.
+	Track Track
+	// Reason is set for TrackLost.
+	Reason LostReason
+	// Acceleration is the acceleration magnitude in pixels per second
+	// squared for TrackAccelerated.
+	Acceleration float64
+	// Region is the name of the region entered for TrackEnteredRegion.
+	Region string
+}
+
+// emit fills in the track snapshot and passes event to the OnEvent hook.
+func (t *Tracker) emit(event TrackEvent, track *Track) {
+	if t.opts.OnEvent == nil {
+		return
+	}
+	event.Track = *track
+	event.Track.Points = append([]Point(nil), track.Points...)
+	t.opts.OnEvent(event)
+}
+
+// emitLost reports a lost track. The track is taken to have left the image
+// if its last point, moved on by its velocity to timestamp, is outside the
+// previous frame or within lostEdgeMargin of its edge.
+func (t *Tracker) emitLost(track *Track, timestamp time.Time) {
+	delete(t.accelerating, track.ID)
+	if t.opts.OnEvent == nil {
+		return
+	}
+	last := track.Points[len(track.Points)-1].Vec
+	dt := float32(timestamp.Sub(t.lastTime).Seconds())
+	x := last.X + track.LatestVelocity.X*dt
+	y := last.Y + track.LatestVelocity.Y*dt
+	reason := LostNotMatched
+	if x < lostEdgeMargin || y < lostEdgeMargin || x >= float32(t.frameSize.X-lostEdgeMargin) || y >= float32(t.frameSize.Y-lostEdgeMargin) {
+		reason = LostLeftImage
+	}
+	t.emit(TrackEvent{Kind: TrackLost, Time: timestamp, Reason: reason}, track)
+}
+
+// checkMotionEvents reports the acceleration and region events of a track
+// that has just been extended to timestamp.
+func (t *Tracker) checkMotionEvents(track *Track, timestamp time.Time) {
+	if threshold := t.opts.AccelerationThreshold; threshold > 0 {
+		accel := math.Hypot(float64(track.LatestAcceleration.X), float64(track.LatestAcceleration.Y))
+		switch {
+		case accel > threshold && !t.accelerating[track.ID]:
+			t.accelerating[track.ID] = true
+			t.emit(TrackEvent{Kind: TrackAccelerated, Time: timestamp, Acceleration: accel}, track)
+		case accel <= threshold:
+			delete(t.accelerating, track.ID)
+		}
+	}
+
+	n := len(track.Points)
+	for _, region := range t.opts.Regions {
+		if region.contains(track.Points[n-1]) && !region.contains(track.Points[n-2]) {
+			t.emit(TrackEvent{Kind: TrackEnteredRegion, Time: timestamp, Region: region.Name}, track)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package newcast
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// squareFrames returns frames of a fixed random texture square moving
+// right, one frame per entry of xs, which holds the square's left edge.
+func squareFrames(width, height, size, y int, xs []int) []gocv.Mat {
+	rng := rand.New(rand.NewSource(11))
+	texture := make([]uint8, size*size)
+	for i := range texture {
+		texture[i] = uint8(64 + rng.Intn(192))
+	}
+	frames := make([]gocv.Mat, len(xs))
+	for f, x0 := range xs {
+		mat := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8U)
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				if x := x0 + i; x >= 0 && x < width {
+					mat.SetUCharAt(y+j, x, texture[j*size+i])
+				}
+			}
+		}
+		frames[f] = mat
+	}
+	return frames
+}
+
+func TestTrackEvents(t *testing.T) {
+	// Steady at 4 px/s, then a jump to 16 px/s, through the watch region
+	// and off the right edge of the image.
+	xs := []int{10, 14, 18, 22, 38, 54, 70, 86, 102, 118, 134, 150, 166, 182}
+	frames := squareFrames(160, 100, 24, 38, xs)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	var events []TrackEvent
+	opts := TrackerOptions{
+		OnEvent:               func(e TrackEvent) { events = append(events, e) },
+		Regions:               []Region{{Name: "watch", Bounds: image.Rect(90, 0, 120, 100)}},
+		AccelerationThreshold: 2,
+	}
+	tracker, err := NewTrackerWithOptions(30, opts)
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("Failed to add frame %d: %v", i, err)
+		}
+	}
+
+	byTrack := map[int][]TrackEvent{}
+	for _, e := range events {
+		byTrack[e.Track.ID] = append(byTrack[e.Track.ID], e)
+	}
+	if len(byTrack) == 0 {
+		t.Fatal("Expected events")
+	}
+
+	// Every track is created, accelerates at the jump and then enters the
+	// region. Matches of the partly visible square at the image edge are
+	// unreliable, so after that only require each track to end lost.
+	want := []EventKind{TrackCreated, TrackAccelerated, TrackEnteredRegion}
+	leftImage := 0
+	for id, evs := range byTrack {
+		var kinds []EventKind
+		for _, e := range evs {
+			kinds = append(kinds, e.Kind)
+		}
+		if len(kinds) < len(want)+1 {
+			t.Errorf("Track %d: got events %v, want %v followed by lost", id, kinds, want)
+			continue
+		}
+		for i := range want {
+			if kinds[i] != want[i] {
+				t.Errorf("Track %d: got events %v, want %v first", id, kinds, want)
+				break
+			}
+		}
+		lost := evs[len(evs)-1]
+		if lost.Kind != TrackLost {
+			t.Errorf("Track %d: got events %v, want the last to be lost", id, kinds)
+			continue
+		}
+		for _, e := range evs[:len(evs)-1] {
+			if e.Kind == TrackLost {
+				t.Errorf("Track %d: lost more than once: %v", id, kinds)
+			}
+		}
+		if lost.Reason == LostLeftImage {
+			leftImage++
+		}
+
+		created, accel, entered := evs[0], evs[1], evs[2]
+		if !created.Time.Equal(ts) || len(created.Track.Points) != 1 {
+			t.Errorf("Track %d: created at %v with %d points, want the first frame", id, created.Time, len(created.Track.Points))
+		}
+		if !accel.Time.Equal(ts.Add(4*time.Second)) || accel.Acceleration <= opts.AccelerationThreshold {
+			t.Errorf("Track %d: accelerated at %v by %.2f, want the jump at frame 4", id, accel.Time, accel.Acceleration)
+		}
+		if entered.Region != "watch" {
+			t.Errorf("Track %d: entered region %q", id, entered.Region)
+		}
+		if x := entered.Track.Points[len(entered.Track.Points)-1].Vec.X; x < 90 || x >= 120 {
+			t.Errorf("Track %d: entered the region at x=%.1f", id, x)
+		}
+		// Snapshots are copies taken when the event happened.
+		if len(created.Track.Points) >= len(lost.Track.Points) {
+			t.Errorf("Track %d: snapshots share points: %d at creation, %d when lost", id, len(created.Track.Points), len(lost.Track.Points))
+		}
+	}
+	if leftImage == 0 {
+		t.Error("Expected some tracks to be lost by leaving the image")
+	}
+	if got := len(tracker.GetTracks()); got != 0 {
+		t.Errorf("Expected every track to be lost, %d remain", got)
+	}
+}
@@ -2,6 +2,8 @@ package newcast
 
 import (
 	"gocv.io/x/gocv"
+	"math"
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -29,31 +31,31 @@ func TestExtrapolationTimeCalculation(t *testing.T) {
 		totalDuration := track.Points[len(track.Points)-1].Time.Sub(track.Points[0].Time).Seconds()
 		avgDt = totalDuration / float64(len(track.Points)-1)
 	}
-	
+
 	expectedAvgDt := 2.0 // 8 seconds total / 4 intervals = 2 seconds per interval
 	if avgDt != expectedAvgDt {
 		t.Errorf("Expected average time interval to be %.1f, got %.1f", expectedAvgDt, avgDt)
 	}
-	
+
 	// Now test the polynomial fitting
 	polyX, polyY, err := FitQuadratic(points)
 	if err != nil {
 		t.Fatalf("FitQuadratic failed: %v", err)
 	}
-	
+
 	track.PolyX = polyX
 	track.PolyY = polyY
-	
+
 	// Simulate the extrapolation calculation
 	lastT := points[len(points)-1].Time.Sub(points[0].Time).Seconds() // Should be 8.0
 	numFuturePoints := 4
-	
+
 	// Calculate where the extrapolated points should be
 	extrapolatedTimes := make([]float64, numFuturePoints)
 	for j := 1; j <= numFuturePoints; j++ {
 		extrapolatedTimes[j-1] = lastT + float64(j)*avgDt // 8 + j*2
 	}
-	
+
 	// The extrapolated times should be: 10, 12, 14, 16 seconds
 	expectedTimes := []float64{10.0, 12.0, 14.0, 16.0}
 	for i, expected := range expectedTimes {
@@ -61,4 +63,68 @@ func TestExtrapolationTimeCalculation(t *testing.T) {
 			t.Errorf("Expected extrapolated time %d to be %.1f, got %.1f", i, expected, extrapolatedTimes[i])
 		}
 	}
-}
\ No newline at end of file
+}
+
+// noisyLinearTrack returns a track moving at constant velocity with
+// reproducible Gaussian noise of the given amplitude added to every point,
+// fitted the same way the tracker fits its tracks.
+func noisyLinearTrack(t *testing.T, amplitude float64) *Track {
+	t.Helper()
+	rng := rand.New(rand.NewSource(42))
+	t0 := time.Now()
+	track := &Track{ID: 1}
+	for i := 0; i < 20; i++ {
+		track.Points = append(track.Points, Point{
+			Time: t0.Add(time.Duration(i) * time.Minute),
+			Vec: gocv.Point2f{
+				X: float32(10 + 3*float64(i) + amplitude*rng.NormFloat64()),
+				Y: float32(50 + 1*float64(i) + amplitude*rng.NormFloat64()),
+			},
+		})
+	}
+	polyX, polyY, err := FitQuadratic(track.Points)
+	if err != nil {
+		t.Fatalf("FitQuadratic failed: %v", err)
+	}
+	track.PolyX, track.PolyY = polyX, polyY
+	track.ResidualX, track.ResidualY = ResidualRMS(track.Points, polyX, polyY)
+	return track
+}
+
+func TestPredictPositionsRadius(t *testing.T) {
+	const numFuturePoints = 8
+	track := noisyLinearTrack(t, 1.0)
+
+	predictions := track.PredictPositions(numFuturePoints)
+	if len(predictions) != numFuturePoints {
+		t.Fatalf("Expected %d predictions, got %d", numFuturePoints, len(predictions))
+	}
+	for j := 1; j < len(predictions); j++ {
+		if predictions[j].Radius <= predictions[j-1].Radius {
+			t.Errorf("Radius did not grow at step %d: %.3f <= %.3f", j+1, predictions[j].Radius, predictions[j-1].Radius)
+		}
+		if !predictions[j].Time.After(predictions[j-1].Time) {
+			t.Errorf("Prediction times are not increasing at step %d", j+1)
+		}
+	}
+
+	// The noise is the same sequence scaled, so the residuals and therefore
+	// the radii scale with the amplitude.
+	noisier := noisyLinearTrack(t, 3.0).PredictPositions(numFuturePoints)
+	for j := range predictions {
+		ratio := noisier[j].Radius / predictions[j].Radius
+		if math.Abs(ratio-3) > 0.01 {
+			t.Errorf("Expected radius to triple with the noise at step %d, got ratio %.3f", j+1, ratio)
+		}
+	}
+}
+
+func TestPredictPositionsUnfitted(t *testing.T) {
+	track := &Track{ID: 1, Points: []Point{
+		{Time: time.Now(), Vec: gocv.Point2f{X: 1, Y: 1}},
+		{Time: time.Now().Add(time.Minute), Vec: gocv.Point2f{X: 2, Y: 2}},
+	}}
+	if predictions := track.PredictPositions(4); predictions != nil {
+		t.Errorf("Expected no predictions for an unfitted track, got %d", len(predictions))
+	}
+}
@@ -8,6 +8,76 @@ import (
 	"gocv.io/x/gocv"
 )
 
+// TrackFilter selects tracks, for example to drop noisy ones before they are
+// visualized or extrapolated. Apply must be pure: it returns a new slice
+// and modifies neither the input slice nor the tracks.
+type TrackFilter interface {
+	Apply(tracks []*Track) []*Track
+}
+
+// TrackFilterFunc adapts a function to the TrackFilter interface.
+type TrackFilterFunc func(tracks []*Track) []*Track
+
+func (f TrackFilterFunc) Apply(tracks []*Track) []*Track {
+	return f(tracks)
+}
+
+// FilterChain is a TrackFilter that applies its filters in order, each to
+// the output of the one before.
+type FilterChain []TrackFilter
+
+func (c FilterChain) Apply(tracks []*Track) []*Track {
+	out := append([]*Track(nil), tracks...)
+	for _, f := range c {
+		out = f.Apply(out)
+	}
+	return out
+}
+
+// MinLengthFilter keeps the tracks with at least MinPoints points.
+type MinLengthFilter struct {
+	MinPoints int
+}
+
+func (f MinLengthFilter) Apply(tracks []*Track) []*Track {
+	var long []*Track
+	for _, track := range tracks {
+		if len(track.Points) >= f.MinPoints {
+			long = append(long, track)
+		}
+	}
+	return long
+}
+
+// SmoothnessFilter is FilterTracksBySmoothness as a TrackFilter.
+type SmoothnessFilter struct {
+	MaxAverageAngleChange float64
+}
+
+func (f SmoothnessFilter) Apply(tracks []*Track) []*Track {
+	return FilterTracksBySmoothness(tracks, f.MaxAverageAngleChange)
+}
+
+// DensityFilter is FilterTracksByDensityAndSmoothness as a TrackFilter.
+type DensityFilter struct {
+	GridCellSize     int
+	MinTracksPerCell int
+	MaxTracksPerCell int
+}
+
+func (f DensityFilter) Apply(tracks []*Track) []*Track {
+	return FilterTracksByDensityAndSmoothness(tracks, f.GridCellSize, f.MinTracksPerCell, f.MaxTracksPerCell)
+}
+
+// MaxAngleChangeFilter is FilterTracksByMaxAngleChange as a TrackFilter.
+type MaxAngleChangeFilter struct {
+	MaxAngleChange float64
+}
+
+func (f MaxAngleChangeFilter) Apply(tracks []*Track) []*Track {
+	return FilterTracksByMaxAngleChange(tracks, f.MaxAngleChange)
+}
+
 // calculateSmoothnessMetric calculates the smoothness of a track.
 // A lower value means a smoother track. Returns a large value if smoothness
 // cannot be calculated.
@@ -75,10 +145,24 @@ func FilterTracksByDensityAndSmoothness(tracks []*Track, gridCellSize, minTracks
 		grid[cell] = append(grid[cell], track)
 	}
 
+	// Visit the cells in a fixed order so the output does not depend on map
+	// iteration order.
+	cells := make([]image.Point, 0, len(grid))
+	for cell := range grid {
+		cells = append(cells, cell)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Y != cells[j].Y {
+			return cells[i].Y < cells[j].Y
+		}
+		return cells[i].X < cells[j].X
+	})
+
 	var finalTracks []*Track
 
 	// 2. Filter within each grid cell
-	for _, tracksInCell := range grid {
+	for _, cell := range cells {
+		tracksInCell := grid[cell]
 		if len(tracksInCell) < minTracksPerCell {
 			continue // Skip sparse cells
 		}
@@ -0,0 +1,125 @@
+package newcast
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// randomTracks returns tracks of varying length heading right, with random
+// jitter so that some are smooth and some are not.
+func randomTracks(n int) []*Track {
+	rng := rand.New(rand.NewSource(5))
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	tracks := make([]*Track, n)
+	for i := range tracks {
+		x, y := rng.Float32()*200, rng.Float32()*200
+		jitter := rng.Float32() * 6
+		track := &Track{ID: i}
+		for j := 0; j < 3+rng.Intn(8); j++ {
+			track.Points = append(track.Points, Point{
+				Time: ts.Add(time.Duration(j) * time.Minute),
+				Vec:  gocv.Point2f{X: x + 4*float32(j), Y: y + jitter*(rng.Float32()-0.5)},
+			})
+		}
+		tracks[i] = track
+	}
+	return tracks
+}
+
+// deepCopy returns copies of tracks that share nothing with them.
+func deepCopy(tracks []*Track) []Track {
+	out := make([]Track, len(tracks))
+	for i, tr := range tracks {
+		out[i] = *tr
+		out[i].Points = append([]Point(nil), tr.Points...)
+	}
+	return out
+}
+
+func trackIDs(tracks []*Track) []int {
+	ids := []int{}
+	for _, tr := range tracks {
+		ids = append(ids, tr.ID)
+	}
+	return ids
+}
+
+func TestFilterChainMatchesSequentialApplication(t *testing.T) {
+	tracks := randomTracks(120)
+	before := deepCopy(tracks)
+	order := trackIDs(tracks)
+
+	chain := FilterChain{
+		MinLengthFilter{MinPoints: 5},
+		SmoothnessFilter{MaxAverageAngleChange: 0.5},
+		DensityFilter{GridCellSize: 64, MinTracksPerCell: 2, MaxTracksPerCell: 3},
+	}
+	got := chain.Apply(tracks)
+
+	var long []*Track
+	for _, tr := range tracks {
+		if len(tr.Points) >= 5 {
+			long = append(long, tr)
+		}
+	}
+	want := FilterTracksByDensityAndSmoothness(FilterTracksBySmoothness(long, 0.5), 64, 2, 3)
+
+	if len(want) == 0 || len(want) == len(tracks) {
+		t.Fatalf("Test data should make the chain drop some but not all tracks, kept %d of %d", len(want), len(tracks))
+	}
+	if !reflect.DeepEqual(trackIDs(got), trackIDs(want)) {
+		t.Errorf("Chain kept %v, sequential application kept %v", trackIDs(got), trackIDs(want))
+	}
+
+	// The filters are pure.
+	if !reflect.DeepEqual(trackIDs(tracks), order) {
+		t.Error("Filtering reordered the input slice")
+	}
+	for i, tr := range tracks {
+		if !reflect.DeepEqual(*tr, before[i]) {
+			t.Fatalf("Filtering modified track %d", tr.ID)
+		}
+	}
+}
+
+func TestTrackerOutputFilters(t *testing.T) {
+	frames := squareFrames(160, 100, 24, 38, []int{10, 14, 18, 22})
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	tracker, err := NewTracker(30)
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("AddImage failed on frame %d: %v", i, err)
+		}
+	}
+
+	all := tracker.GetAllTracks()
+	if len(all) == 0 {
+		t.Fatal("Expected active tracks")
+	}
+	keepFirst := TrackFilterFunc(func(tracks []*Track) []*Track { return tracks[:1] })
+	tracker.SetOutputFilters(MinLengthFilter{MinPoints: len(frames)}, keepFirst)
+	if got := tracker.GetTracks(); len(got) != 1 || got[0] != all[0] {
+		t.Errorf("Expected GetTracks to apply the filters, got %d tracks", len(got))
+	}
+	if got := len(tracker.GetAllTracks()); got != len(all) {
+		t.Errorf("Expected GetAllTracks to stay unfiltered, got %d of %d tracks", got, len(all))
+	}
+
+	tracker.SetOutputFilters(MinLengthFilter{MinPoints: len(frames) + 1})
+	if got := tracker.GetTracks(); got == nil || len(got) != 0 {
+		t.Errorf("Expected an empty, non-nil result, got %v", got)
+	}
+}
@@ -0,0 +1,255 @@
+package newcast
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ForecastFormat selects the encoding of ExportForecastPositions.
+type ForecastFormat int
+
+const (
+	// ForecastCSV writes a header row and one row per forecast position.
+	ForecastCSV ForecastFormat = iota
+	// ForecastGeoJSON writes a FeatureCollection with a Point feature per
+	// forecast position.
+	ForecastGeoJSON
+)
+
+func (f ForecastFormat) String() string {
+	switch f {
+	case ForecastCSV:
+		return "csv"
+	case ForecastGeoJSON:
+		return "geojson"
+	}
+	return "unknown"
+}
+
+// ParseForecastFormat returns the ForecastFormat named s: "csv" or
+// "geojson".
+func ParseForecastFormat(s string) (ForecastFormat, error) {
+	for _, f := range []ForecastFormat{ForecastCSV, ForecastGeoJSON} {
+		if f.String() == s {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown forecast format %q (want csv or geojson)", s)
+}
+
+// The motion models a forecast position can come from.
+const (
+	// ForecastModelQuadratic evaluates the track's fitted polynomials, as
+	// PredictPositions does.
+	ForecastModelQuadratic = "quadratic"
+	// ForecastModelConstantVelocity moves the track's last position by its
+	// LatestVelocity, for a track without fitted polynomials.
+	ForecastModelConstantVelocity = "constant_velocity"
+)
+
+// GeoTransform maps pixel coordinates to longitude and latitude with the
+// coefficients of a GDAL geotransform:
+//
+//	lon = g[0] + x*g[1] + y*g[2]
+//	lat = g[3] + x*g[4] + y*g[5]
+type GeoTransform [6]float64
+
+// Apply returns the longitude and latitude of the pixel position (x, y).
+func (g GeoTransform) Apply(x, y float64) (lon, lat float64) {
+	return g[0] + x*g[1] + y*g[2], g[3] + x*g[4] + y*g[5]
+}
+
+// ForecastExportOptions configures ExportForecastPositionsWithOptions.
+type ForecastExportOptions struct {
+	// Geo, if set, adds the longitude and latitude of every position, and
+	// makes them the GeoJSON coordinates instead of the pixel position.
+	Geo *GeoTransform
+}
+
+// forecastPosition is the forecast of one track at one lead time.
+type forecastPosition struct {
+	trackID  int
+	leadTime time.Duration
+	time     time.Time
+	x, y     float64
+	// radius is the uncertainty radius in pixels, NaN if unknown.
+	radius float64
+	model  string
+}
+
+// forecastAt returns the position of tr leadTime after its last point. With
+// fitted polynomials the radius grows from the combined RMS residual as in
+// PredictPositions, by sqrt(1 + j) after j average intervals; without, it
+// is unknown. It reports false for a lost track or one of fewer than 2
+// points.
+func (tr *Track) forecastAt(leadTime time.Duration) (forecastPosition, bool) {
+	if tr.Lost || len(tr.Points) < 2 {
+		return forecastPosition{}, false
+	}
+	first, last := tr.Points[0], tr.Points[len(tr.Points)-1]
+	f := forecastPosition{trackID: tr.ID, leadTime: leadTime, time: last.Time.Add(leadTime)}
+	dt := leadTime.Seconds()
+	if tr.PolyX == (Polynomial{}) && tr.PolyY == (Polynomial{}) {
+		f.x = float64(last.Vec.X) + float64(tr.LatestVelocity.X)*dt
+		f.y = float64(last.Vec.Y) + float64(tr.LatestVelocity.Y)*dt
+		f.radius = math.NaN()
+		f.model = ForecastModelConstantVelocity
+		return f, true
+	}
+	lastT := last.Time.Sub(first.Time).Seconds()
+	f.x, f.y = tr.PolyX.Eval(lastT+dt), tr.PolyY.Eval(lastT+dt)
+	steps := 0.0
+	if avgDt := lastT / float64(len(tr.Points)-1); avgDt > 0 {
+		steps = dt / avgDt
+	}
+	f.radius = math.Hypot(tr.ResidualX, tr.ResidualY) * math.Sqrt(1+steps)
+	f.model = ForecastModelQuadratic
+	return f, true
+}
+
+// ExportForecastPositions writes the forecast position of every track at
+// every lead time to w in format, by track and then by lead time. Each
+// position is leadTime after the track's last point and extrapolated with
+// ForecastModelQuadratic if the track has fitted polynomials, or else with
+// ForecastModelConstantVelocity. Lost tracks and tracks of fewer than 2
+// points are skipped.
+//
+// Every position carries its track_id, lead_time in seconds, time, x and y
+// in pixels, uncertainty_radius in pixels, and model. The radius is empty
+// in CSV, and null in GeoJSON, under ForecastModelConstantVelocity, which
+// has no residuals to derive it from. Without a GeoTransform the GeoJSON
+// coordinates are the pixel position.
+func ExportForecastPositions(tracks []*Track, leadTimes []time.Duration, w io.Writer, format ForecastFormat) error {
+	return ExportForecastPositionsWithOptions(tracks, leadTimes, w, format, ForecastExportOptions{})
+}
+
+// ExportForecastPositionsWithOptions is like ExportForecastPositions, with
+// the geographic position added as configured by opts.
+func ExportForecastPositionsWithOptions(tracks []*Track, leadTimes []time.Duration, w io.Writer, format ForecastFormat, opts ForecastExportOptions) error {
+	for _, lead := range leadTimes {
+		if lead < 0 {
+			return fmt.Errorf("lead time must not be negative, got %v", lead)
+		}
+	}
+	var positions []forecastPosition
+	for _, track := range tracks {
+		for _, lead := range leadTimes {
+			if f, ok := track.forecastAt(lead); ok {
+				positions = append(positions, f)
+			}
+		}
+	}
+	switch format {
+	case ForecastCSV:
+		return writeForecastCSV(w, positions, opts.Geo)
+	case ForecastGeoJSON:
+		return writeForecastGeoJSON(w, positions, opts.Geo)
+	}
+	return fmt.Errorf("unknown forecast format %d", format)
+}
+
+// formatFloat formats v with the fewest digits that read back as v, or as
+// empty if v is NaN.
+func formatFloat(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeForecastCSV writes positions as CSV, with lon and lat columns if geo
+// is set.
+func writeForecastCSV(w io.Writer, positions []forecastPosition, geo *GeoTransform) error {
+	cw := csv.NewWriter(w)
+	header := []string{"track_id", "lead_time", "time", "x", "y"}
+	if geo != nil {
+		header = append(header, "lon", "lat")
+	}
+	header = append(header, "uncertainty_radius", "model")
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write forecast header: %w", err)
+	}
+	for _, f := range positions {
+		row := []string{strconv.Itoa(f.trackID), formatFloat(f.leadTime.Seconds()), f.time.Format(time.RFC3339Nano), formatFloat(f.x), formatFloat(f.y)}
+		if geo != nil {
+			lon, lat := geo.Apply(f.x, f.y)
+			row = append(row, formatFloat(lon), formatFloat(lat))
+		}
+		row = append(row, formatFloat(f.radius), f.model)
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to write forecast of track %d: %w", f.trackID, err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write forecast positions: %w", err)
+	}
+	return nil
+}
+
+// geoJSONCollection is a GeoJSON FeatureCollection of forecast positions.
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature is one forecast position as a GeoJSON Point feature.
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties forecastProperties `json:"properties"`
+}
+
+// forecastProperties are the GeoJSON properties of a forecast position.
+type forecastProperties struct {
+	TrackID           int       `json:"track_id"`
+	LeadTime          float64   `json:"lead_time"`
+	Time              time.Time `json:"time"`
+	X                 float64   `json:"x"`
+	Y                 float64   `json:"y"`
+	Lon               *float64  `json:"lon,omitempty"`
+	Lat               *float64  `json:"lat,omitempty"`
+	UncertaintyRadius *float64  `json:"uncertainty_radius"`
+	Model             string    `json:"model"`
+}
+
+// writeForecastGeoJSON writes positions as a GeoJSON FeatureCollection, at
+// longitude and latitude if geo is set.
+func writeForecastGeoJSON(w io.Writer, positions []forecastPosition, geo *GeoTransform) error {
+	collection := geoJSONCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, len(positions))}
+	for i, f := range positions {
+		feature := &collection.Features[i]
+		feature.Type = "Feature"
+		feature.Geometry.Type = "Point"
+		feature.Geometry.Coordinates = [2]float64{f.x, f.y}
+		feature.Properties = forecastProperties{
+			TrackID:  f.trackID,
+			LeadTime: f.leadTime.Seconds(),
+			Time:     f.time,
+			X:        f.x,
+			Y:        f.y,
+			Model:    f.model,
+		}
+		if geo != nil {
+			lon, lat := geo.Apply(f.x, f.y)
+			feature.Geometry.Coordinates = [2]float64{lon, lat}
+			feature.Properties.Lon, feature.Properties.Lat = &lon, &lat
+		}
+		if !math.IsNaN(f.radius) {
+			radius := f.radius
+			feature.Properties.UncertaintyRadius = &radius
+		}
+	}
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		return fmt.Errorf("failed to write forecast positions: %w", err)
+	}
+	return nil
+}
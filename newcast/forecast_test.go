@@ -0,0 +1,159 @@
+package newcast
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// constantVelocityTrack returns a track of 2 points a minute apart moving
+// (30, -30) pixels, refit by finite differences to a velocity of
+// (0.5, -0.5) pixels per second.
+func constantVelocityTrack(t *testing.T) *Track {
+	t0 := time.Date(2025, 10, 5, 12, 0, 0, 0, time.UTC)
+	track := &Track{ID: 7, Points: []Point{
+		{Time: t0, Vec: gocv.Point2f{X: 100, Y: 50}},
+		{Time: t0.Add(time.Minute), Vec: gocv.Point2f{X: 130, Y: 20}},
+	}}
+	if err := RefitTracks([]*Track{track}, MotionFiniteDifference); err != nil {
+		t.Fatalf("RefitTracks failed: %v", err)
+	}
+	return track
+}
+
+var forecastLeads = []time.Duration{5 * time.Minute, 10 * time.Minute}
+
+// TestExportForecastPositionsCSV checks that the CSV positions of a
+// constant-velocity track are its last position plus v·Δt exactly.
+func TestExportForecastPositionsCSV(t *testing.T) {
+	track := constantVelocityTrack(t)
+	lost := constantVelocityTrack(t)
+	lost.ID, lost.Lost = 8, true
+
+	var buf bytes.Buffer
+	if err := ExportForecastPositions([]*Track{track, lost}, forecastLeads, &buf, ForecastCSV); err != nil {
+		t.Fatalf("ExportForecastPositions failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read the CSV: %v", err)
+	}
+	if len(rows) != 1+len(forecastLeads) {
+		t.Fatalf("Expected a header and %d rows, without the lost track, got %v", len(forecastLeads), rows)
+	}
+	if got := rows[0]; len(got) != 7 || got[0] != "track_id" || got[5] != "uncertainty_radius" {
+		t.Errorf("Unexpected header %v", got)
+	}
+	last := track.Points[1]
+	for i, lead := range forecastLeads {
+		row := rows[1+i]
+		x, _ := strconv.ParseFloat(row[3], 64)
+		y, _ := strconv.ParseFloat(row[4], 64)
+		wantX := float64(last.Vec.X) + float64(track.LatestVelocity.X)*lead.Seconds()
+		wantY := float64(last.Vec.Y) + float64(track.LatestVelocity.Y)*lead.Seconds()
+		if x != wantX || y != wantY {
+			t.Errorf("Lead %v: expected (%v, %v), got (%v, %v)", lead, wantX, wantY, x, y)
+		}
+		if row[0] != "7" || row[1] != formatFloat(lead.Seconds()) || row[2] != last.Time.Add(lead).Format(time.RFC3339Nano) {
+			t.Errorf("Lead %v: unexpected track, lead time or time in %v", lead, row)
+		}
+		if row[5] != "" || row[6] != ForecastModelConstantVelocity {
+			t.Errorf("Lead %v: expected no radius and the constant velocity model, got %v", lead, row)
+		}
+	}
+	if x, _ := strconv.ParseFloat(rows[2][3], 64); x != 430 {
+		t.Errorf("Expected x = 130 + 0.5 * 600 = 430 at 10 minutes, got %v", x)
+	}
+}
+
+// TestExportForecastPositionsGeoJSON checks the GeoJSON features of a
+// constant-velocity and a quadratic track, with a geotransform.
+func TestExportForecastPositionsGeoJSON(t *testing.T) {
+	track := constantVelocityTrack(t)
+	quadratic, _ := quadraticTrack(10)
+	if err := RefitTracks([]*Track{quadratic}, MotionQuadratic); err != nil {
+		t.Fatalf("RefitTracks failed: %v", err)
+	}
+	geo := GeoTransform{10, 0.01, 0, 50, 0, -0.01}
+
+	var buf bytes.Buffer
+	if err := ExportForecastPositionsWithOptions([]*Track{track, quadratic}, forecastLeads, &buf, ForecastGeoJSON, ForecastExportOptions{Geo: &geo}); err != nil {
+		t.Fatalf("ExportForecastPositions failed: %v", err)
+	}
+	var collection struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [2]float64
+			}
+			Properties struct {
+				TrackID           int     `json:"track_id"`
+				LeadTime          float64 `json:"lead_time"`
+				X, Y, Lon, Lat    float64
+				UncertaintyRadius *float64 `json:"uncertainty_radius"`
+				Model             string
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &collection); err != nil {
+		t.Fatalf("Failed to decode the GeoJSON: %v", err)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 4 {
+		t.Fatalf("Expected a FeatureCollection of 4 features, got %q with %d", collection.Type, len(collection.Features))
+	}
+	last := track.Points[1]
+	for i, lead := range forecastLeads {
+		f := collection.Features[i]
+		wantX := float64(last.Vec.X) + float64(track.LatestVelocity.X)*lead.Seconds()
+		wantY := float64(last.Vec.Y) + float64(track.LatestVelocity.Y)*lead.Seconds()
+		if f.Properties.X != wantX || f.Properties.Y != wantY {
+			t.Errorf("Lead %v: expected (%v, %v), got (%v, %v)", lead, wantX, wantY, f.Properties.X, f.Properties.Y)
+		}
+		lon, lat := geo.Apply(wantX, wantY)
+		if f.Geometry.Type != "Point" || f.Geometry.Coordinates != [2]float64{lon, lat} || f.Properties.Lon != lon || f.Properties.Lat != lat {
+			t.Errorf("Lead %v: expected a point at (%v, %v), got %+v", lead, lon, lat, f)
+		}
+		if f.Properties.TrackID != 7 || f.Properties.LeadTime != lead.Seconds() || f.Properties.UncertaintyRadius != nil {
+			t.Errorf("Lead %v: unexpected properties %+v", lead, f.Properties)
+		}
+	}
+
+	// The quadratic track continues its parabola, with a growing radius.
+	var prevRadius float64
+	for i, lead := range forecastLeads {
+		f := collection.Features[2+i]
+		tt := 90 + lead.Seconds()
+		wantX, wantY := 10+0.5*tt+0.001*tt*tt, 20-0.2*tt+0.0005*tt*tt
+		if math.Abs(f.Properties.X-wantX) > 0.01 || math.Abs(f.Properties.Y-wantY) > 0.01 {
+			t.Errorf("Lead %v: expected about (%.2f, %.2f), got (%v, %v)", lead, wantX, wantY, f.Properties.X, f.Properties.Y)
+		}
+		if f.Properties.Model != ForecastModelQuadratic || f.Properties.UncertaintyRadius == nil || *f.Properties.UncertaintyRadius < prevRadius {
+			t.Errorf("Lead %v: expected the quadratic model with a growing radius, got %+v", lead, f.Properties)
+		} else {
+			prevRadius = *f.Properties.UncertaintyRadius
+		}
+	}
+}
+
+func TestExportForecastPositionsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ExportForecastPositions(nil, []time.Duration{-time.Minute}, &buf, ForecastCSV); err == nil {
+		t.Error("Expected an error for a negative lead time")
+	}
+	if err := ExportForecastPositions(nil, forecastLeads, &buf, 5); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if f, err := ParseForecastFormat("geojson"); err != nil || f != ForecastGeoJSON {
+		t.Errorf("Expected ParseForecastFormat(geojson) to give ForecastGeoJSON, got %v, %v", f, err)
+	}
+	if _, err := ParseForecastFormat("kml"); err == nil {
+		t.Error("Expected an error for an unknown format name")
+	}
+}
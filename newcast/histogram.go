@@ -0,0 +1,142 @@
+package newcast
+
+import (
+	"errors"
+	"example/goflow/trace"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// MaxHistogramBins is the most bins either histogram of MotionHistograms
+// may have.
+const MaxHistogramBins = 1000
+
+// ErrTooManyBins is returned by MotionHistograms when a histogram would
+// have more than MaxHistogramBins bins.
+var ErrTooManyBins = errors.New("newcast: too many histogram bins")
+
+// MotionHistograms summarizes the latest velocities of tracks, in pixels
+// per second, as a histogram of their speeds and one of their directions.
+// Tracks that are lost or have no velocity yet, such as those of a single
+// point, are skipped.
+//
+// speedBins[i] counts the speeds from speedEdges[i] up to, but excluding,
+// speedEdges[i+1]; the bins are speedBinWidth wide, start at 0 and end with
+// the bin of the fastest track. directionBins[i] counts the tracks heading
+// into sector i of directionSectors equal sectors of the compass, where
+// north is up in the image and sector 0 is centered on it, so that with 16
+// sectors they are N, NNE, NE and so on clockwise. A non-positive
+// speedBinWidth or directionSectors leaves the corresponding histogram nil.
+// The error wraps ErrTooManyBins if either histogram would have more than
+// MaxHistogramBins bins.
+func MotionHistograms(tracks []*Track, speedBinWidth float64, directionSectors int) (speedBins []int, speedEdges []float64, directionBins []int, err error) {
+	if directionSectors > MaxHistogramBins {
+		return nil, nil, nil, fmt.Errorf("%w: %d direction sectors, at most %d are allowed", ErrTooManyBins, directionSectors, MaxHistogramBins)
+	}
+	if directionSectors > 0 {
+		directionBins = make([]int, directionSectors)
+	}
+	sector := 360 / float64(directionSectors)
+	for _, track := range tracks {
+		v := track.LatestVelocity
+		if track.Lost || (v.X == 0 && v.Y == 0) {
+			continue
+		}
+		if speedBinWidth > 0 {
+			speed := math.Hypot(float64(v.X), float64(v.Y))
+			if speed/speedBinWidth >= MaxHistogramBins {
+				return nil, nil, nil, fmt.Errorf("%w: a speed of %g px/s needs more than %d bins %g px/s wide", ErrTooManyBins, speed, MaxHistogramBins, speedBinWidth)
+			}
+			bin := int(speed / speedBinWidth)
+			for len(speedBins) <= bin {
+				speedBins = append(speedBins, 0)
+			}
+			speedBins[bin]++
+		}
+		if directionSectors > 0 {
+			bin := int(math.Floor((trace.BearingFromDirection(trace.Point{X: float64(v.X), Y: float64(v.Y)})+sector/2)/sector)) % directionSectors
+			directionBins[bin]++
+		}
+	}
+	if speedBins != nil {
+		speedEdges = make([]float64, len(speedBins)+1)
+		for i := range speedEdges {
+			speedEdges[i] = float64(i) * speedBinWidth
+		}
+	}
+	return speedBins, speedEdges, directionBins, nil
+}
+
+// compassPoints names the 16 sectors of the compass, clockwise from north.
+var compassPoints = [16]string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// Histogram chart layout, in pixels.
+const (
+	histogramBarWidth   = 36
+	histogramPlotHeight = 160
+	histogramMargin     = 20
+	histogramLabelSpace = 24
+)
+
+// VisualizeSpeedHistogram draws the speed histogram of MotionHistograms as
+// a bar chart, each bar labeled with the lower edge of its bin. It returns
+// an empty Mat if there are no bins. The caller must close the returned
+// Mat.
+func VisualizeSpeedHistogram(bins []int, edges []float64) gocv.Mat {
+	labels := make([]string, len(bins))
+	for i := range labels {
+		labels[i] = fmt.Sprintf("%.3g", edges[i])
+	}
+	return visualizeHistogram(bins, labels)
+}
+
+// VisualizeDirectionHistogram draws the direction histogram of
+// MotionHistograms as a bar chart, each bar labeled with the compass point
+// its sector is centered on or, for a number of sectors that does not
+// divide 16, its bearing in degrees. It returns an empty Mat if there are
+// no bins. The caller must close the returned Mat.
+func VisualizeDirectionHistogram(bins []int) gocv.Mat {
+	labels := make([]string, len(bins))
+	for i := range labels {
+		if len(compassPoints)%len(bins) == 0 {
+			labels[i] = compassPoints[i*len(compassPoints)/len(bins)]
+		} else {
+			labels[i] = fmt.Sprintf("%g", float64(i)*360/float64(len(bins)))
+		}
+	}
+	return visualizeHistogram(bins, labels)
+}
+
+// visualizeHistogram draws bins as bars scaled to the largest count on a
+// black background, with the count above each bar and its label below.
+func visualizeHistogram(bins []int, labels []string) gocv.Mat {
+	if len(bins) == 0 {
+		return gocv.NewMat()
+	}
+	maxCount := 1
+	for _, n := range bins {
+		maxCount = max(maxCount, n)
+	}
+
+	width := len(bins)*histogramBarWidth + 2*histogramMargin
+	height := histogramPlotHeight + 2*histogramMargin + histogramLabelSpace
+	img := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
+	img.SetTo(gocv.NewScalar(0, 0, 0, 0)) // Black background
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	baseline := histogramMargin + histogramPlotHeight
+	for i, n := range bins {
+		x := histogramMargin + i*histogramBarWidth
+		if n > 0 {
+			top := baseline - n*histogramPlotHeight/maxCount
+			gocv.Rectangle(&img, image.Rect(x+3, top, x+histogramBarWidth-3, baseline), color.RGBA{R: 70, G: 150, B: 255, A: 255}, -1)
+			gocv.PutText(&img, fmt.Sprint(n), image.Pt(x+4, top-4), gocv.FontHersheySimplex, 0.35, white, 1)
+		}
+		gocv.PutText(&img, labels[i], image.Pt(x+2, baseline+16), gocv.FontHersheySimplex, 0.35, white, 1)
+	}
+	gocv.Line(&img, image.Pt(histogramMargin, baseline+1), image.Pt(width-histogramMargin, baseline+1), white, 1)
+	return img
+}
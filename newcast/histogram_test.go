@@ -0,0 +1,100 @@
+package newcast
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// velocityTracks returns one two-point track per velocity, in pixels per
+// second.
+func velocityTracks(velocities ...gocv.Point2f) []*Track {
+	tracks := make([]*Track, len(velocities))
+	for i, v := range velocities {
+		tracks[i] = &Track{ID: i, LatestVelocity: v, Points: make([]Point, 2)}
+	}
+	return tracks
+}
+
+func TestMotionHistograms(t *testing.T) {
+	tracks := velocityTracks(
+		gocv.Point2f{X: 0, Y: -1},    // N, speed 1
+		gocv.Point2f{X: 0, Y: -2.5},  // N, speed 2.5
+		gocv.Point2f{X: 3, Y: 0},     // E, speed 3
+		gocv.Point2f{X: 3, Y: 4},     // SE (143 degrees), speed 5
+		gocv.Point2f{X: -2, Y: 0},    // W, speed 2
+		gocv.Point2f{X: -1, Y: -1.2}, // NW (320 degrees), speed 1.56
+		gocv.Point2f{X: 0.2, Y: -3},  // N (3.8 degrees), speed 3.01
+		gocv.Point2f{},               // no velocity yet
+	)
+	lost := velocityTracks(gocv.Point2f{X: 9, Y: 9})[0]
+	lost.Lost = true
+	tracks = append(tracks, lost)
+
+	speedBins, speedEdges, directionBins, err := MotionHistograms(tracks, 1, 16)
+	if err != nil {
+		t.Fatalf("MotionHistograms failed: %v", err)
+	}
+	if want := []int{0, 2, 2, 2, 0, 1}; !reflect.DeepEqual(speedBins, want) {
+		t.Errorf("Expected speed bins %v, got %v", want, speedBins)
+	}
+	if want := []float64{0, 1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(speedEdges, want) {
+		t.Errorf("Expected speed edges %v, got %v", want, speedEdges)
+	}
+	want := make([]int, 16)
+	want[0], want[4], want[6], want[12], want[14] = 3, 1, 1, 1, 1
+	if !reflect.DeepEqual(directionBins, want) {
+		t.Errorf("Expected direction bins %v, got %v", want, directionBins)
+	}
+
+	// Sector 0 of 4 spans 315 to 45 degrees, so NW falls in N.
+	if _, _, got, _ := MotionHistograms(tracks, 1, 4); !reflect.DeepEqual(got, []int{4, 1, 1, 1}) {
+		t.Errorf("Expected 4-sector bins [4 1 1 1], got %v", got)
+	}
+	if got, edges, _, _ := MotionHistograms(tracks, 2.5, 0); !reflect.DeepEqual(got, []int{3, 3, 1}) || len(edges) != 4 || edges[3] != 7.5 {
+		t.Errorf("Expected 2.5 wide speed bins [3 3 1] up to 7.5, got %v with edges %v", got, edges)
+	}
+	if speedBins, speedEdges, directionBins, _ := MotionHistograms(nil, 1, 8); speedBins != nil || speedEdges != nil || !reflect.DeepEqual(directionBins, make([]int, 8)) {
+		t.Errorf("Expected empty histograms without tracks, got %v, %v and %v", speedBins, speedEdges, directionBins)
+	}
+
+	// A speed of 5 px/s needs 5000 bins of 0.001 px/s.
+	if _, _, _, err := MotionHistograms(tracks, 0.001, 16); !errors.Is(err, ErrTooManyBins) {
+		t.Errorf("Expected ErrTooManyBins for too narrow speed bins, got %v", err)
+	}
+	if _, _, _, err := MotionHistograms(tracks, 1, MaxHistogramBins+1); !errors.Is(err, ErrTooManyBins) {
+		t.Errorf("Expected ErrTooManyBins for too many direction sectors, got %v", err)
+	}
+}
+
+func TestVisualizeHistograms(t *testing.T) {
+	empty := VisualizeSpeedHistogram(nil, nil)
+	defer empty.Close()
+	if !empty.Empty() {
+		t.Error("Expected an empty chart without bins")
+	}
+
+	chart := VisualizeSpeedHistogram([]int{1, 4, 0}, []float64{0, 1, 2, 3})
+	defer chart.Close()
+	if want := 3*histogramBarWidth + 2*histogramMargin; chart.Cols() != want || chart.Rows() != histogramPlotHeight+2*histogramMargin+histogramLabelSpace {
+		t.Fatalf("Unexpected %dx%d chart", chart.Cols(), chart.Rows())
+	}
+	// barDrawn reports whether the center of bar i is drawn y pixels
+	// above the baseline.
+	baseline := histogramMargin + histogramPlotHeight
+	barDrawn := func(img gocv.Mat, i, y int) bool {
+		return pixelColor(img, histogramMargin+i*histogramBarWidth+histogramBarWidth/2, baseline-y).B != 0
+	}
+	// The tallest bar fills the plot, the others are scaled to it.
+	if !barDrawn(chart, 1, histogramPlotHeight-1) || !barDrawn(chart, 0, histogramPlotHeight/4-2) || barDrawn(chart, 0, histogramPlotHeight/4+2) || barDrawn(chart, 2, 2) {
+		t.Error("Expected bars of heights 1/4, 1 and 0 of the plot")
+	}
+
+	rose := VisualizeDirectionHistogram(make([]int, 16))
+	defer rose.Close()
+	if rose.Cols() != 16*histogramBarWidth+2*histogramMargin {
+		t.Errorf("Expected a chart 16 bars wide, got %d pixels", rose.Cols())
+	}
+}
@@ -0,0 +1,227 @@
+package newcast
+
+import (
+	"gocv.io/x/gocv"
+)
+
+// Default Kalman filter noise levels; see TrackerOptions.ProcessNoise and
+// TrackerOptions.MeasurementNoise.
+const (
+	DefaultProcessNoise     = 0.05
+	DefaultMeasurementNoise = 0.5
+)
+
+func (o TrackerOptions) kalman() bool {
+	return o.KalmanSmoothing || o.KalmanMotion
+}
+
+func (o TrackerOptions) processNoise() float64 {
+	if o.ProcessNoise > 0 {
+		return o.ProcessNoise
+	}
+	return DefaultProcessNoise
+}
+
+func (o TrackerOptions) measurementNoise() float64 {
+	if o.MeasurementNoise > 0 {
+		return o.MeasurementNoise
+	}
+	return DefaultMeasurementNoise
+}
+
+// kalmanAxis is the constant-acceleration state of one coordinate, position,
+// velocity and acceleration in frame units, with its covariance.
+type kalmanAxis struct {
+	s [3]float64
+	p [3][3]float64
+}
+
+// trackFilter is the Kalman filter of a track. The x and y coordinates are
+// filtered independently with the same model. Time is measured in frames of
+// interval seconds, the gap between the track's first two points, so the
+// noise levels do not depend on the frame rate.
+type trackFilter struct {
+	interval float64
+	axes     [2]kalmanAxis
+}
+
+// newTrackFilter starts a filter at the last of three points from the
+// quadratic through them, with the covariance that the measurement noise r
+// induces on it. It returns nil if the points are not in time order.
+func newTrackFilter(points [3]Point, r float64) *trackFilter {
+	interval := points[1].Time.Sub(points[0].Time).Seconds()
+	if interval <= 0 || !points[2].Time.After(points[1].Time) {
+		return nil
+	}
+	// tau are the point times relative to the last, in frames. The
+	// quadratic through the points has, at tau = 0, position z[2], velocity
+	// sum(cv[i] z[i]) and acceleration sum(ca[i] z[i]).
+	var tau [3]float64
+	for i, p := range points {
+		tau[i] = p.Time.Sub(points[2].Time).Seconds() / interval
+	}
+	coeffs := [3][3]float64{{0, 0, 1}}
+	for i := range tau {
+		a, b := tau[(i+1)%3], tau[(i+2)%3]
+		d := (tau[i] - a) * (tau[i] - b)
+		coeffs[1][i] = -(a + b) / d
+		coeffs[2][i] = 2 / d
+	}
+
+	f := &trackFilter{interval: interval}
+	for axis := range f.axes {
+		z := [3]float64{}
+		for i, p := range points {
+			z[i] = coordinate(p.Vec, axis)
+		}
+		k := &f.axes[axis]
+		for row := range coeffs {
+			for i := range z {
+				k.s[row] += coeffs[row][i] * z[i]
+			}
+			for col := range coeffs {
+				for i := range z {
+					k.p[row][col] += r * r * coeffs[row][i] * coeffs[col][i]
+				}
+			}
+		}
+	}
+	return f
+}
+
+// coordinate returns the x (axis 0) or y (axis 1) coordinate of pt.
+func coordinate(pt gocv.Point2f, axis int) float64 {
+	if axis == 0 {
+		return float64(pt.X)
+	}
+	return float64(pt.Y)
+}
+
+// update advances the filter by dt seconds and corrects it with the
+// measured position z. q is the standard deviation of the change in
+// acceleration per frame and r that of the measurement, in pixels.
+func (f *trackFilter) update(dt float64, z gocv.Point2f, q, r float64) {
+	tau := dt / f.interval
+	for axis := range f.axes {
+		f.axes[axis].predict(tau, q)
+		f.axes[axis].correct(coordinate(z, axis), r)
+	}
+}
+
+// predict advances the state by tau frames. The acceleration takes a random
+// step of standard deviation q per frame, scaled with tau.
+func (k *kalmanAxis) predict(tau, q float64) {
+	transition := [3][3]float64{
+		{1, tau, tau * tau / 2},
+		{0, 1, tau},
+		{0, 0, 1},
+	}
+	noise := [3]float64{tau * tau * tau / 6, tau * tau / 2, tau}
+
+	var s [3]float64
+	var fp, p [3][3]float64
+	for i := range transition {
+		for j := range transition {
+			s[i] += transition[i][j] * k.s[j]
+			for l := range transition {
+				fp[i][j] += transition[i][l] * k.p[l][j]
+			}
+		}
+	}
+	for i := range transition {
+		for j := range transition {
+			for l := range transition {
+				p[i][j] += fp[i][l] * transition[j][l]
+			}
+			p[i][j] += q * q * noise[i] * noise[j]
+		}
+	}
+	k.s, k.p = s, p
+}
+
+// correct folds in a position measurement z of standard deviation r.
+func (k *kalmanAxis) correct(z, r float64) {
+	innovation := z - k.s[0]
+	variance := k.p[0][0] + r*r
+	var gain [3]float64
+	for i := range gain {
+		gain[i] = k.p[i][0] / variance
+		k.s[i] += gain[i] * innovation
+	}
+	row := k.p[0]
+	for i := range gain {
+		for j := range row {
+			k.p[i][j] -= gain[i] * row[j]
+		}
+	}
+}
+
+// position, velocity and acceleration return the filtered estimates in
+// pixels, pixels per second and pixels per second squared.
+func (f *trackFilter) position() gocv.Point2f {
+	return gocv.Point2f{X: float32(f.axes[0].s[0]), Y: float32(f.axes[1].s[0])}
+}
+
+func (f *trackFilter) velocity() gocv.Point2f {
+	return gocv.Point2f{X: float32(f.axes[0].s[1] / f.interval), Y: float32(f.axes[1].s[1] / f.interval)}
+}
+
+func (f *trackFilter) acceleration() gocv.Point2f {
+	scale := f.interval * f.interval
+	return gocv.Point2f{X: float32(f.axes[0].s[2] / scale), Y: float32(f.axes[1].s[2] / scale)}
+}
+
+// updateFilter runs the Kalman filter of track over its newest point and
+// stores the estimates in that point. The filter starts at the third point.
+func (t *Tracker) updateFilter(track *Track) {
+	n := len(track.Points)
+	if n < 3 {
+		return
+	}
+	r := t.opts.measurementNoise()
+	last := &track.Points[n-1]
+	if track.filter == nil {
+		track.filter = newTrackFilter([3]Point{track.Points[n-3], track.Points[n-2], *last}, r)
+		if track.filter == nil {
+			return
+		}
+	} else {
+		dt := last.Time.Sub(track.Points[n-2].Time).Seconds()
+		if dt <= 0 {
+			return
+		}
+		track.filter.update(dt, last.Vec, t.opts.processNoise(), r)
+	}
+	last.Smoothed = track.filter.position()
+	last.SmoothedVelocity = track.filter.velocity()
+	last.Filtered = true
+}
+
+// filteredMotion sets the motion estimates of track from its Kalman filter,
+// with polynomials that reproduce the filtered state at the last point. It
+// returns false if the filter has not started.
+func (t *Tracker) filteredMotion(track *Track) bool {
+	f := track.filter
+	if f == nil || !track.Points[len(track.Points)-1].Filtered {
+		return false
+	}
+	t0 := track.Points[0].Time
+	lastT := track.Points[len(track.Points)-1].Time.Sub(t0).Seconds()
+	pos, vel, acc := f.position(), f.velocity(), f.acceleration()
+	track.PolyX = localQuadratic(float64(pos.X), float64(vel.X), float64(acc.X), lastT)
+	track.PolyY = localQuadratic(float64(pos.Y), float64(vel.Y), float64(acc.Y), lastT)
+	track.ResidualX, track.ResidualY = ResidualRMS(track.Points, track.PolyX, track.PolyY)
+	track.LatestVelocity = vel
+	track.LatestAcceleration = acc
+	return true
+}
+
+// localQuadratic returns the polynomial in t with position x, velocity v and
+// acceleration a at t = at.
+func localQuadratic(x, v, a, at float64) Polynomial {
+	return Polynomial{
+		A: a / 2,
+		B: v - a*at,
+		C: x - v*at + a*at*at/2,
+	}
+}
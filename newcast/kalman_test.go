@@ -0,0 +1,130 @@
+package newcast
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// noisyTrack feeds a track moving at a constant (vx, vy) pixels per second,
+// observed every interval with Gaussian noise of standard deviation sigma,
+// through the tracker's per-point update.
+func noisyTrack(tracker *Tracker, n int, interval time.Duration, vx, vy, sigma float64) *Track {
+	rng := rand.New(rand.NewSource(8))
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	track := &Track{}
+	for i := 0; i < n; i++ {
+		s := (time.Duration(i) * interval).Seconds()
+		track.Points = append(track.Points, Point{
+			Time: ts.Add(time.Duration(i) * interval),
+			Vec: gocv.Point2f{
+				X: float32(100 + vx*s + sigma*rng.NormFloat64()),
+				Y: float32(50 + vy*s + sigma*rng.NormFloat64()),
+			},
+		})
+		tracker.updateFilter(track)
+		tracker.estimateMotion(track)
+	}
+	return track
+}
+
+// meanVariance returns the mean and variance of values.
+func meanVariance(values []float64) (mean, variance float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, variance / float64(len(values))
+}
+
+func TestKalmanSmoothingVelocity(t *testing.T) {
+	// Radar cadence: 6 pixels per 5-minute frame.
+	const vx, vy, sigma, burnIn = 0.02, -0.01, 0.5, 20
+	interval := 5 * time.Minute
+	tracker, err := NewTrackerWithOptions(10, TrackerOptions{KalmanMotion: true})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	track := noisyTrack(tracker, 200, interval, vx, vy, sigma)
+
+	var filtered, differenced []float64
+	for i, p := range track.Points {
+		if p.Filtered != (i >= 2) {
+			t.Fatalf("Point %d: Filtered is %v", i, p.Filtered)
+		}
+		if i < burnIn {
+			continue
+		}
+		filtered = append(filtered, float64(p.SmoothedVelocity.X))
+		prev := track.Points[i-1]
+		differenced = append(differenced, float64(p.Vec.X-prev.Vec.X)/interval.Seconds())
+	}
+	filteredMean, filteredVar := meanVariance(filtered)
+	diffMean, diffVar := meanVariance(differenced)
+	t.Logf("Vx: filtered %.5f ± %.5f, finite differences %.5f ± %.5f px/s", filteredMean, math.Sqrt(filteredVar), diffMean, math.Sqrt(diffVar))
+
+	if filteredVar > diffVar/5 {
+		t.Errorf("Expected the filtered velocity variance %.3g to be under a fifth of the finite-difference variance %.3g", filteredVar, diffVar)
+	}
+	if math.Abs(filteredMean-vx) > 0.05*vx {
+		t.Errorf("Expected an unbiased filtered velocity of %v, got %v", vx, filteredMean)
+	}
+
+	// KalmanMotion reports the filter's state as the track motion.
+	last := track.Points[len(track.Points)-1]
+	if track.LatestVelocity != last.SmoothedVelocity {
+		t.Errorf("Expected LatestVelocity %v to be the smoothed velocity %v", track.LatestVelocity, last.SmoothedVelocity)
+	}
+	if v := float64(track.LatestVelocity.Y); math.Abs(v-vy) > 0.1*math.Abs(vy) {
+		t.Errorf("Expected a Y velocity near %v, got %v", vy, v)
+	}
+	predictions := track.PredictPositions(1)
+	if len(predictions) != 1 {
+		t.Fatal("Expected a prediction from the filtered motion")
+	}
+	wantX := float64(last.Smoothed.X) + float64(last.SmoothedVelocity.X)*interval.Seconds()
+	if math.Abs(float64(predictions[0].Vec.X)-wantX) > 0.1 {
+		t.Errorf("Expected the prediction to extrapolate the filtered state to x=%.2f, got %.2f", wantX, predictions[0].Vec.X)
+	}
+}
+
+func TestKalmanSmoothingInTracker(t *testing.T) {
+	frames := squareFrames(160, 100, 24, 38, []int{10, 14, 18, 22, 26})
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	tracker, err := NewTrackerWithOptions(30, TrackerOptions{KalmanSmoothing: true})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("AddImage failed on frame %d: %v", i, err)
+		}
+	}
+
+	tracks := tracker.GetTracks()
+	if len(tracks) == 0 {
+		t.Fatal("Expected active tracks")
+	}
+	for _, track := range tracks {
+		last := track.Points[len(track.Points)-1]
+		if !last.Filtered {
+			t.Fatalf("Track %d: expected its last point to be filtered", track.ID)
+		}
+		if math.Abs(float64(last.SmoothedVelocity.X)-4) > 0.5 || math.Abs(float64(last.SmoothedVelocity.Y)) > 0.5 {
+			t.Errorf("Track %d: expected a smoothed velocity near (4, 0), got %v", track.ID, last.SmoothedVelocity)
+		}
+	}
+}
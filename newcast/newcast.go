@@ -1,7 +1,10 @@
 package newcast
 
 import (
+	"errors"
+	"example/goflow/flow"
 	"fmt"
+	"image"
 	"time"
 
 	"gocv.io/x/gocv"
@@ -10,7 +13,14 @@ import (
 // Point represents a point in time and space.
 type Point struct {
 	Time time.Time
-	Vec  gocv.Point2f
+	Vec  gocv.Point2f // raw observed position
+	// Smoothed and SmoothedVelocity are the Kalman filter's position and
+	// velocity, in pixels per second, at Time. Filtered reports whether they
+	// are set, which under TrackerOptions.KalmanSmoothing is from a track's
+	// third point on.
+	Smoothed         gocv.Point2f
+	SmoothedVelocity gocv.Point2f
+	Filtered         bool
 }
 
 // Track represents the path of a single feature over time.
@@ -22,175 +32,390 @@ type Track struct {
 	Lost               bool
 	PolyX              Polynomial // Polynomial for X coordinate
 	PolyY              Polynomial // Polynomial for Y coordinate
+	ResidualX          float64    // RMS residual of PolyX in pixels
+	ResidualY          float64    // RMS residual of PolyY in pixels
+	// filter is the Kalman filter under TrackerOptions.KalmanSmoothing, nil
+	// until the track's third point.
+	filter *trackFilter
+}
+
+// TrackerOptions configures optional tracking behaviour.
+type TrackerOptions struct {
+	// GroupMotionRescue retries points that LK lost, or whose displacement
+	// disagrees with the frame's median displacement, seeding the search at
+	// their previous position plus the median displacement.
+	GroupMotionRescue bool
+	// RescueMaxError is the largest LK error at which a retried match is
+	// accepted. Zero means DefaultRescueMaxError.
+	RescueMaxError float32
+	// OutlierDistance is how far, in pixels, a point's displacement may be
+	// from the median displacement before it is retried. Zero means
+	// DefaultOutlierDistance.
+	OutlierDistance float64
+	// ReseedBelow enables reseeding: whenever fewer than this many tracks
+	// remain after a frame, new features are detected in that frame to bring
+	// the tracker back up to maxFeatures. Zero disables reseeding.
+	ReseedBelow int
+	// MinFeatureSeparation is the minimum distance in pixels between a
+	// detected feature and any other feature, including the endpoints of
+	// existing tracks when reseeding. Zero means DefaultMinFeatureSeparation.
+	MinFeatureSeparation float64
+	// Seeding selects how features are detected in the first frame and
+	// when reseeding: on corners, or under flow.SeedGrid at the centers of
+	// a regular grid that covers the whole frame, to track motion where
+	// corners are scarce; see flow.GridFeatures. Grid cells are
+	// GridSpacing pixels wide and skipped if their intensity variance is
+	// below MinVariance.
+	Seeding flow.Seeding
+	// GridSpacing is the side in pixels of the flow.SeedGrid cells. Zero
+	// means flow.DefaultGridSpacing.
+	GridSpacing int
+	// MinVariance is the intensity variance, in grey levels squared, below
+	// which a flow.SeedGrid cell gets no feature. Zero keeps every cell.
+	MinVariance float64
+	// RetryDetection detects features in a first frame that has none once
+	// more, with relaxed thresholds, before AddImage gives up with
+	// ErrNoFeatures: a tenth of the corner quality level or, under
+	// flow.SeedGrid, no MinVariance. The quality level is relative to the
+	// strongest corner of the frame, so a frame without any corner, such
+	// as a blank one, has none at any level; the grid retry samples every
+	// frame.
+	RetryDetection bool
+	// OnFrame, if set, is called at the end of every successful AddImage,
+	// including the first, with a snapshot of the active tracks.
+	OnFrame func(snapshot FrameSnapshot) `json:"-"`
+	// OnEvent, if set, receives the track lifecycle events detected in
+	// AddImage, in the order they occur.
+	OnEvent func(event TrackEvent) `json:"-"`
+	// Regions are the watch areas for TrackEnteredRegion events.
+	Regions []Region
+	// AccelerationThreshold is the acceleration magnitude, in pixels per
+	// second squared, above which a TrackAccelerated event is emitted. Zero
+	// disables these events.
+	AccelerationThreshold float64
+	// StaticSuppression keeps features off static structure, such as
+	// permanent echoes, that would otherwise be tracked at zero velocity
+	// forever. The tracker keeps a running activity map, the exponentially
+	// smoothed absolute difference of consecutive frames, and only detects
+	// and reseeds features near pixels whose activity exceeds MinActivity.
+	// The first frame has no activity yet, so no tracks are started until
+	// the second, and the tracker seeds after every frame while it has no
+	// tracks. See ActivityMap.
+	StaticSuppression bool
+	// ActivitySmoothing is the weight of the newest frame difference in the
+	// activity map, between 0 and 1. Zero means DefaultActivitySmoothing.
+	ActivitySmoothing float64
+	// MinActivity is the activity, in grey levels, below which a pixel is
+	// considered static. Zero means DefaultMinActivity.
+	MinActivity float64
+	// KalmanSmoothing runs a constant-acceleration Kalman filter over every
+	// track and stores its estimates in Point.Smoothed and
+	// Point.SmoothedVelocity, leaving Point.Vec raw.
+	KalmanSmoothing bool
+	// KalmanMotion implies KalmanSmoothing and makes the track motion
+	// (LatestVelocity, LatestAcceleration and the polynomials) follow the
+	// filter instead of a quadratic fit over the whole history, so it
+	// reacts faster to genuine direction changes.
+	KalmanMotion bool
+	// ProcessNoise is the standard deviation, in pixels per frame squared,
+	// of the random change in a track's acceleration per frame, where a
+	// frame is the interval between the track's first two points. Larger
+	// values follow changes faster but smooth less. Zero means
+	// DefaultProcessNoise.
+	ProcessNoise float64
+	// MeasurementNoise is the standard deviation, in pixels, of the error in
+	// the observed positions. Zero means DefaultMeasurementNoise.
+	MeasurementNoise float64
+	// AutoResize rescales every frame to the size of the first one instead
+	// of rejecting frames of another size with ErrDimensionMismatch. Track
+	// coordinates stay in the coordinate system of the first frame.
+	AutoResize bool
+	// ProcessingScale, between 0 and 1, downsamples every frame by this
+	// factor before detection and tracking, which makes AddImage faster on
+	// large frames at the price of some precision. Track coordinates,
+	// velocities, and the distances of the other options stay in pixels of
+	// the full-size frames, while the LK search window and ActivityMap are
+	// at the processed size. Zero means 1, no downsampling.
+	ProcessingScale float64
+	// Record, if set, receives the results of the image processing of every
+	// successful AddImage and Reseed call, so that a ReplayTracker can
+	// repeat the run without the frames. A Recorder serves one tracker.
+	Record *Recorder `json:"-"`
+}
+
+// ErrDimensionMismatch is returned by AddImage for a frame whose size
+// differs from that of the first frame, unless TrackerOptions.AutoResize is
+// set.
+type ErrDimensionMismatch struct {
+	Expected, Got image.Point // frame sizes, Expected being the first frame's
+}
+
+func (e *ErrDimensionMismatch) Error() string {
+	return fmt.Sprintf("newcast: frame is %dx%d, but the first frame is %dx%d", e.Got.X, e.Got.Y, e.Expected.X, e.Expected.Y)
+}
+
+// ErrNoFeatures is returned by AddImage when no features are found in the
+// first frame, such as a blank one. The tracker is left awaiting its first
+// frame, so the next AddImage starts it afresh.
+var ErrNoFeatures = errors.New("newcast: no features found in the first frame")
+
+// FrameStats summarises how the tracks fared over one frame pair.
+type FrameStats struct {
+	Time     time.Time `json:"time"`
+	Tracked  int       `json:"tracked"`  // tracks carried into the frame, including rescued ones
+	Lost     int       `json:"lost"`     // tracks lost in the frame
+	Rescued  int       `json:"rescued"`  // tracks kept or corrected by the group-motion retry
+	Reseeded int       `json:"reseeded"` // tracks started by reseeding after the frame
+	// NonFinite counts the tracks lost because LK matched them to a NaN or
+	// infinite position; they are included in Lost.
+	NonFinite int `json:"non_finite,omitempty"`
 }
 
 // Tracker manages the tracking of features across multiple images.
 type Tracker struct {
 	maxFeatures int
+	opts        TrackerOptions
 	nextTrackID int
 	tracks      []*Track
-	prevImg     gocv.Mat
-	prevPoints  gocv.Mat
+	started     bool
+	prevImg     gocv.Mat // newest frame; empty when replaying
+	prevPoints  []gocv.Point2f
+	frameSize   image.Point // size of the frames, set by the first
+	lastTime    time.Time   // capture time of the newest frame
+	stats       []FrameStats
+	// accelerating holds the tracks whose acceleration is above
+	// TrackerOptions.AccelerationThreshold, so each crossing is reported once.
+	accelerating map[int]bool
+	// activity is the StaticSuppression activity map, CV32F the size of the
+	// frames. It is empty until the second frame.
+	activity gocv.Mat
+	// outputFilters are applied by GetTracks.
+	outputFilters FilterChain
 }
 
 // NewTracker creates a new feature tracker.
 // maxFeatures is the number of features to detect in the first image.
 func NewTracker(maxFeatures int) (*Tracker, error) {
+	return NewTrackerWithOptions(maxFeatures, TrackerOptions{})
+}
+
+// NewTrackerWithOptions creates a new feature tracker configured by opts.
+func NewTrackerWithOptions(maxFeatures int, opts TrackerOptions) (*Tracker, error) {
 	if maxFeatures <= 0 {
 		return nil, fmt.Errorf("maxFeatures must be positive")
 	}
+	if opts.ProcessingScale < 0 || opts.ProcessingScale > 1 {
+		return nil, fmt.Errorf("processing scale must be between 0 and 1, got %v", opts.ProcessingScale)
+	}
 	return &Tracker{
-		maxFeatures: maxFeatures,
-		nextTrackID: 0,
-		tracks:      []*Track{},
-		prevImg:     gocv.NewMat(),
-		prevPoints:  gocv.NewMat(),
+		maxFeatures:  maxFeatures,
+		opts:         opts,
+		nextTrackID:  0,
+		tracks:       []*Track{},
+		prevImg:      gocv.NewMat(),
+		accelerating: make(map[int]bool),
+		activity:     gocv.NewMat(),
 	}, nil
 }
 
 // Close releases the memory used by the tracker.
 func (t *Tracker) Close() {
 	t.prevImg.Close()
-	t.prevPoints.Close()
+	t.activity.Close()
 }
 
 // AddImage processes a new image in the sequence.
-// img is the new image.
+// img is the new image, the same size as the first one.
 // timestamp is the time the image was captured.
 func (t *Tracker) AddImage(img gocv.Mat, timestamp time.Time) error {
 	if img.Empty() {
 		return fmt.Errorf("input image is empty")
 	}
+	if size := image.Pt(img.Cols(), img.Rows()); t.started && size != t.frameSize {
+		if !t.opts.AutoResize {
+			return &ErrDimensionMismatch{Expected: t.frameSize, Got: size}
+		}
+		resized := gocv.NewMat()
+		defer resized.Close()
+		gocv.Resize(img, &resized, t.frameSize, 0, 0, gocv.InterpolationLinear)
+		img = resized
+	}
+	full := image.Pt(img.Cols(), img.Rows())
+	if proc := t.opts.processingSize(full); proc != full {
+		scaled := gocv.NewMat()
+		defer scaled.Close()
+		gocv.Resize(img, &scaled, proc, 0, 0, gocv.InterpolationArea)
+		img = scaled
+	}
+	src := t.recording(&opencvFrame{t: t, img: img, full: full}, RecordedStep{Time: timestamp})
+	if err := t.step(src, timestamp); err != nil {
+		return err
+	}
+	t.record(src)
+	return nil
+}
 
+// step advances the tracker to the frame src describes.
+func (t *Tracker) step(src frameSource, timestamp time.Time) error {
 	// If this is the first image, find features to track.
-	if t.prevImg.Empty() {
-		return t.initializeTracks(img, timestamp)
+	if !t.started {
+		return t.initializeTracks(src, timestamp)
 	}
 
 	// Track features from the previous image to the current one.
-	nextPoints := gocv.NewMat()
-	defer nextPoints.Close()
-	status := gocv.NewMat()
-	defer status.Close()
-	errMat := gocv.NewMat()
-	defer errMat.Close()
+	lk := src.track(t.prevPoints)
+	next, found := readMatches(lk)
 
-	gocv.CalcOpticalFlowPyrLK(t.prevImg, img, t.prevPoints, nextPoints, &status, &errMat)
+	stats := FrameStats{Time: timestamp, NonFinite: lk.NonFinite}
+	if t.opts.GroupMotionRescue {
+		stats.Rescued = t.rescueMatches(src, next, found)
+	}
 
 	// Update tracks with the new points.
-	t.updateTracks(nextPoints, status, timestamp)
+	stats.Tracked, stats.Lost = t.updateTracks(next, found, timestamp)
 
-	// Update the previous image and points for the next iteration.
-	t.prevImg.Close()
-	t.prevImg = img.Clone()
+	// Move on to the new image for the next iteration.
+	src.advance()
+	t.frameSize = src.size()
+	t.lastTime = timestamp
+	if (t.opts.ReseedBelow > 0 && len(t.tracks) < t.opts.ReseedBelow) || (t.opts.StaticSuppression && len(t.tracks) == 0) {
+		stats.Reseeded = t.reseed(src)
+	}
 	t.updatePrevPoints()
+	t.stats = append(t.stats, stats)
+	t.emitSnapshot(stats)
 
 	return nil
 }
 
 // initializeTracks finds good features in the first image and creates initial tracks.
-func (t *Tracker) initializeTracks(img gocv.Mat, timestamp time.Time) error {
-	points := gocv.NewMat()
-	defer points.Close()
-
-	gocv.GoodFeaturesToTrack(img, &points, t.maxFeatures, 0.01, 10)
-	if points.Rows() == 0 {
-		return fmt.Errorf("no features found in the first image")
+func (t *Tracker) initializeTracks(src frameSource, timestamp time.Time) error {
+	var points []gocv.Point2f
+	// Under StaticSuppression nothing is known to move yet, so seeding
+	// waits for the next frame.
+	if !t.opts.StaticSuppression {
+		points = src.detect(t.maxFeatures, t.opts.minFeatureSeparation(), false)
+		if len(points) == 0 && t.opts.RetryDetection {
+			points = src.detect(t.maxFeatures, t.opts.minFeatureSeparation(), true)
+		}
+		if len(points) == 0 {
+			return ErrNoFeatures
+		}
 	}
 
-	for i := 0; i < points.Rows(); i++ {
-		ptVec := points.GetVecfAt(i, 0)
-		track := &Track{
-			ID:     t.nextTrackID,
-			Points: []Point{{Time: timestamp, Vec: gocv.Point2f{X: ptVec[0], Y: ptVec[1]}}},
-			Lost:   false,
-		}
-		t.tracks = append(t.tracks, track)
-		t.nextTrackID++
+	for _, pt := range points {
+		t.startTrack(pt, timestamp)
 	}
 
-	t.prevImg = img.Clone()
-	t.prevPoints.Close()
-	t.prevPoints = points.Clone()
+	src.advance()
+	t.started = true
+	t.frameSize = src.size()
+	t.lastTime = timestamp
+	t.prevPoints = points
+	t.emitSnapshot(FrameStats{Time: timestamp})
 
 	return nil
 }
 
-// updateTracks updates the feature tracks with new points and manages lost tracks.
-func (t *Tracker) updateTracks(nextPoints, status gocv.Mat, timestamp time.Time) {
+// startTrack begins a new track at pt.
+func (t *Tracker) startTrack(pt gocv.Point2f, timestamp time.Time) {
+	track := &Track{
+		ID:     t.nextTrackID,
+		Points: []Point{{Time: timestamp, Vec: pt}},
+	}
+	t.tracks = append(t.tracks, track)
+	t.nextTrackID++
+	t.emit(TrackEvent{Kind: TrackCreated, Time: timestamp}, track)
+}
+
+// updateTracks updates the feature tracks with new points and manages lost
+// tracks. It returns how many tracks were kept and lost.
+func (t *Tracker) updateTracks(next []gocv.Point2f, found []bool, timestamp time.Time) (kept, lost int) {
 	survivingTracks := []*Track{}
 	for i, track := range t.tracks {
 		if track.Lost {
 			continue
 		}
-		if status.GetUCharAt(i, 0) == 1 {
-			var newPoint Point
-			if nextPoints.Channels() == 2 {
-				ptVec := nextPoints.GetVecfAt(i, 0)
-				newPoint = Point{
-					Time: timestamp,
-					Vec:  gocv.Point2f{X: ptVec[0], Y: ptVec[1]},
-				}
-			} else {
-				x := nextPoints.GetFloatAt(i, 0)
-				y := nextPoints.GetFloatAt(i, 1)
-				newPoint = Point{
-					Time: timestamp,
-					Vec:  gocv.Point2f{X: x, Y: y},
-				}
+		if found[i] {
+			track.Points = append(track.Points, Point{Time: timestamp, Vec: next[i]})
+			if t.opts.kalman() {
+				t.updateFilter(track)
 			}
-			track.Points = append(track.Points, newPoint)
 			t.estimateMotion(track)
 			survivingTracks = append(survivingTracks, track)
+			t.checkMotionEvents(track, timestamp)
 		} else {
 			track.Lost = true
+			lost++
+			t.emitLost(track, timestamp)
 		}
 	}
 	t.tracks = survivingTracks
+	return len(survivingTracks), lost
 }
 
-// updatePrevPoints creates a new set of points to track for the next frame.
-func (t *Tracker) updatePrevPoints() {
-	t.prevPoints.Close()
-	if len(t.tracks) == 0 {
-		t.prevPoints = gocv.NewMat()
-		return
+// readMatches returns the points LK found and whether each was found.
+func readMatches(lk *LKCall) ([]gocv.Point2f, []bool) {
+	next := make([]gocv.Point2f, len(lk.Status))
+	found := make([]bool, len(lk.Status))
+	for i := range next {
+		if lk.Status[i] == 1 {
+			next[i] = lk.Next[i]
+			found[i] = true
+		}
 	}
+	return next, found
+}
 
-	newPoints := gocv.NewMatWithSize(len(t.tracks), 2, gocv.MatTypeCV32F)
+// updatePrevPoints collects the track endpoints to track into the next
+// frame.
+func (t *Tracker) updatePrevPoints() {
+	t.prevPoints = make([]gocv.Point2f, len(t.tracks))
 	for i, track := range t.tracks {
-		lastPoint := track.Points[len(track.Points)-1].Vec
-		newPoints.SetFloatAt(i, 0, lastPoint.X)
-		newPoints.SetFloatAt(i, 1, lastPoint.Y)
+		t.prevPoints[i] = track.Points[len(track.Points)-1].Vec
 	}
-	t.prevPoints = newPoints
 }
 
 // estimateMotion estimates the velocity and acceleration of a track.
-// It first attempts to fit a quadratic curve, falling back to finite differences.
+// Under TrackerOptions.KalmanMotion it reads the Kalman filter once that has
+// started. Otherwise it first attempts to fit a quadratic curve, falling
+// back to finite differences. An estimate that is not finite is discarded,
+// keeping the previous one.
 func (t *Tracker) estimateMotion(track *Track) {
-	numPoints := len(track.Points)
-	if numPoints < 2 {
+	if len(track.Points) < 2 {
 		return // Not enough data
 	}
+	if t.opts.KalmanMotion && t.filteredMotion(track) {
+		return
+	}
+	fitMotion(track, MotionQuadratic)
+}
+
+// fitMotion estimates the velocity and acceleration of a track of at least
+// 2 points with model, keeping the previous estimate where the new one is
+// not finite.
+func fitMotion(track *Track, model MotionModel) {
+	numPoints := len(track.Points)
 
 	// Attempt to fit a quadratic polynomial for better estimation
-	if numPoints >= 4 {
+	if model == MotionQuadratic && numPoints >= 4 {
 		polyX, polyY, err := FitQuadratic(track.Points)
 		if err == nil {
-			track.PolyX = polyX
-			track.PolyY = polyY
 			t0 := track.Points[0].Time
 			lastT := track.Points[numPoints-1].Time.Sub(t0).Seconds()
 
-			vx := float32(polyX.Velocity(lastT))
-			vy := float32(polyY.Velocity(lastT))
-			track.LatestVelocity = gocv.Point2f{X: vx, Y: vy}
-
-			ax := float32(polyX.Acceleration())
-			ay := float32(polyY.Acceleration())
-			track.LatestAcceleration = gocv.Point2f{X: ax, Y: ay}
-			return
+			velocity := gocv.Point2f{X: float32(polyX.Velocity(lastT)), Y: float32(polyY.Velocity(lastT))}
+			acceleration := gocv.Point2f{X: float32(polyX.Acceleration()), Y: float32(polyY.Acceleration())}
+			// A nearly singular fit can overflow; fall back rather than
+			// let NaN or infinite motion into the track.
+			if finitePoint(velocity) && finitePoint(acceleration) {
+				track.PolyX = polyX
+				track.PolyY = polyY
+				track.ResidualX, track.ResidualY = ResidualRMS(track.Points, polyX, polyY)
+				track.LatestVelocity = velocity
+				track.LatestAcceleration = acceleration
+				return
+			}
 		}
 	}
 
@@ -201,7 +426,9 @@ func (t *Tracker) estimateMotion(track *Track) {
 	if dt > 0 {
 		vx := (p1.Vec.X - p0.Vec.X) / float32(dt)
 		vy := (p1.Vec.Y - p0.Vec.Y) / float32(dt)
-		track.LatestVelocity = gocv.Point2f{X: vx, Y: vy}
+		if v := (gocv.Point2f{X: vx, Y: vy}); finitePoint(v) {
+			track.LatestVelocity = v
+		}
 	}
 
 	if numPoints < 3 {
@@ -217,18 +444,50 @@ func (t *Tracker) estimateMotion(track *Track) {
 		if avg_dt > 0 {
 			ax := (track.LatestVelocity.X - vx_prev) / float32(avg_dt)
 			ay := (track.LatestVelocity.Y - vy_prev) / float32(avg_dt)
-			track.LatestAcceleration = gocv.Point2f{X: ax, Y: ay}
+			if a := (gocv.Point2f{X: ax, Y: ay}); finitePoint(a) {
+				track.LatestAcceleration = a
+			}
 		}
 	}
 }
 
-// GetTracks returns the current set of active tracks.
+// Stats returns the statistics of every frame pair processed so far, in
+// order.
+func (t *Tracker) Stats() []FrameStats {
+	return t.stats
+}
+
+// SetOutputFilters sets the filters GetTracks applies, in order, to the
+// active tracks. Tracking itself is unaffected. Calling it with no filters
+// removes them.
+func (t *Tracker) SetOutputFilters(filters ...TrackFilter) {
+	t.outputFilters = FilterChain(filters)
+}
+
+// GetTracks returns the current set of active tracks that pass the output
+// filters, by ascending ID; see SetOutputFilters and GetTracksPage.
 func (t *Tracker) GetTracks() []*Track {
+	if len(t.outputFilters) == 0 {
+		return t.GetAllTracks()
+	}
+	tracks := t.outputFilters.Apply(t.GetAllTracks())
+	if tracks == nil {
+		tracks = []*Track{}
+	}
+	// Filters may regroup the tracks, such as DensityFilter by cell.
+	SortTracks(tracks, TrackSortID)
+	return tracks
+}
+
+// GetAllTracks returns the current set of active tracks, unfiltered, by
+// ascending ID.
+func (t *Tracker) GetAllTracks() []*Track {
 	activeTracks := []*Track{}
 	for _, track := range t.tracks {
 		if !track.Lost {
 			activeTracks = append(activeTracks, track)
 		}
 	}
+	SortTracks(activeTracks, TrackSortID)
 	return activeTracks
 }
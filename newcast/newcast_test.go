@@ -2,6 +2,8 @@ package newcast
 
 import (
 	"bytes"
+	"errors"
+	"example/goflow/flow"
 	"fmt"
 	"image"
 	_ "image/png"
@@ -121,3 +123,110 @@ func TestTracker(t *testing.T) {
 		t.Errorf("Expected velocity close to (%f, %f), but got (%f, %f)", expectedDx, expectedDy, vx, vy)
 	}
 }
+
+func TestReadLKDropsNonFinite(t *testing.T) {
+	prev := []gocv.Point2f{{X: 10, Y: 10}, {X: 20, Y: 20}, {X: 30, Y: 30}}
+	// LK reports every point found, one at a NaN and one at an infinite
+	// position.
+	next := pointsMat([]gocv.Point2f{{X: 11, Y: 10}, {X: float32(math.NaN()), Y: 20}, {X: 31, Y: float32(math.Inf(1))}})
+	defer next.Close()
+	status := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(1, 0, 0, 0), 3, 1, gocv.MatTypeCV8U)
+	defer status.Close()
+	errMat := gocv.NewMatWithSize(3, 1, gocv.MatTypeCV32F)
+	defer errMat.Close()
+
+	lk := readLK(prev, nil, next, status, errMat)
+	if lk.NonFinite != 2 {
+		t.Errorf("Expected 2 non-finite matches, got %d", lk.NonFinite)
+	}
+	points, found := readMatches(lk)
+	if !found[0] || found[1] || found[2] {
+		t.Errorf("Expected only the finite match to be found, got %v", found)
+	}
+	for i, pt := range points {
+		if !finitePoint(pt) {
+			t.Errorf("Match %d is not finite: %v", i, pt)
+		}
+	}
+}
+
+func TestEstimateMotionKeepsFiniteMotion(t *testing.T) {
+	tracker, err := NewTracker(10)
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+
+	// The float32 velocities of these points overflow.
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	track := &Track{LatestVelocity: gocv.Point2f{X: 1, Y: 2}, LatestAcceleration: gocv.Point2f{X: 0.5, Y: 0}}
+	for i, x := range []float32{0, 3e38, -3e38, 3e38} {
+		track.Points = append(track.Points, Point{Time: ts.Add(time.Duration(i) * time.Millisecond), Vec: gocv.Point2f{X: x, Y: 0}})
+	}
+	tracker.estimateMotion(track)
+	if track.LatestVelocity != (gocv.Point2f{X: 1, Y: 2}) || track.LatestAcceleration != (gocv.Point2f{X: 0.5, Y: 0}) {
+		t.Errorf("Expected the previous motion to be kept, got velocity %v and acceleration %v", track.LatestVelocity, track.LatestAcceleration)
+	}
+}
+
+// TestTrackerNoFeatures feeds a blank frame, in which no features are
+// found, and checks that the tracker starts on the textured frames after it
+// as if the blank one had never been added.
+func TestTrackerNoFeatures(t *testing.T) {
+	frames := make([]gocv.Mat, 3)
+	for i, name := range []string{"blank", "centered", "shifted"} {
+		img, err := loadImageAsGrayscale("../test_data/" + name + ".png")
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		defer img.Close()
+		frames[i] = img
+	}
+	snapshots := 0
+	tracker, err := NewTrackerWithOptions(50, TrackerOptions{
+		RetryDetection: true,
+		OnFrame:        func(FrameSnapshot) { snapshots++ },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	if err := tracker.AddImage(frames[0], ts); !errors.Is(err, ErrNoFeatures) {
+		t.Fatalf("Expected ErrNoFeatures for a blank first frame, even after the retry, got %v", err)
+	}
+	if len(tracker.GetTracks()) != 0 || len(tracker.Stats()) != 0 || snapshots != 0 {
+		t.Fatal("Expected the blank frame to leave the tracker awaiting its first frame")
+	}
+	for i, f := range frames[1:] {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i+1)*5*time.Minute)); err != nil {
+			t.Fatalf("Failed to add textured frame %d: %v", i, err)
+		}
+	}
+	stats := tracker.Stats()
+	if len(stats) != 1 || stats[0].Tracked == 0 || stats[0].Lost != 0 || snapshots != 2 {
+		t.Fatalf("Expected the tracker to start on the centered frame and follow it into the shifted one, got %+v and %d snapshots", stats, snapshots)
+	}
+	for _, track := range tracker.GetTracks() {
+		if d := track.Points[1].Vec; math.Abs(float64(d.X-track.Points[0].Vec.X)-20) > 1 || math.Abs(float64(d.Y-track.Points[0].Vec.Y)-10) > 1 {
+			t.Errorf("Expected track %d to move by (20, 10), got %v to %v", track.ID, track.Points[0].Vec, d)
+		}
+	}
+
+	// No 8-bit cell varies by more than 127.5^2 grey levels squared, but the
+	// retry drops the threshold.
+	for _, retry := range []bool{false, true} {
+		tracker, err := NewTrackerWithOptions(50, TrackerOptions{Seeding: flow.SeedGrid, MinVariance: 20000, RetryDetection: retry})
+		if err != nil {
+			t.Fatalf("Failed to create tracker: %v", err)
+		}
+		defer tracker.Close()
+		err = tracker.AddImage(frames[1], ts)
+		if retry && (err != nil || len(tracker.GetTracks()) == 0) {
+			t.Errorf("Expected the retry to seed the grid without MinVariance, got %v and %d tracks", err, len(tracker.GetTracks()))
+		} else if !retry && !errors.Is(err, ErrNoFeatures) {
+			t.Errorf("Expected ErrNoFeatures without the retry, got %v", err)
+		}
+	}
+}
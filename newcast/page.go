@@ -0,0 +1,110 @@
+package newcast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// TrackSort selects the order of the tracks of GetTracksPage.
+type TrackSort int
+
+const (
+	// TrackSortID orders tracks by ascending ID, the order they were
+	// detected in and the one GetTracks returns them in.
+	TrackSortID TrackSort = iota
+	// TrackSortLength orders the tracks with the most points first.
+	TrackSortLength
+	// TrackSortScore orders the smoothest tracks first, by the average turn
+	// between consecutive steps that SmoothnessFilter thresholds. Tracks too
+	// short, or too still, for it to be measured come last.
+	TrackSortScore
+)
+
+func (s TrackSort) String() string {
+	switch s {
+	case TrackSortID:
+		return "id"
+	case TrackSortLength:
+		return "length"
+	case TrackSortScore:
+		return "score"
+	}
+	return "unknown"
+}
+
+// ParseTrackSort returns the TrackSort named s: "id", "length" or "score".
+func ParseTrackSort(s string) (TrackSort, error) {
+	for _, k := range []TrackSort{TrackSortID, TrackSortLength, TrackSortScore} {
+		if k.String() == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown track sort %q (want id, length or score)", s)
+}
+
+// SortTracks sorts tracks in place by the given order. Tracks that order
+// ties with are left by ascending ID, so the order is the same from call to
+// call.
+func SortTracks(tracks []*Track, by TrackSort) {
+	switch by {
+	case TrackSortLength:
+		sort.Slice(tracks, func(i, j int) bool {
+			if li, lj := len(tracks[i].Points), len(tracks[j].Points); li != lj {
+				return li > lj
+			}
+			return tracks[i].ID < tracks[j].ID
+		})
+	case TrackSortScore:
+		scores := make(map[*Track]float64, len(tracks))
+		for _, track := range tracks {
+			scores[track] = trackScore(track)
+		}
+		sort.Slice(tracks, func(i, j int) bool {
+			if si, sj := scores[tracks[i]], scores[tracks[j]]; si != sj {
+				return si < sj
+			}
+			return tracks[i].ID < tracks[j].ID
+		})
+	default:
+		sort.Slice(tracks, func(i, j int) bool { return tracks[i].ID < tracks[j].ID })
+	}
+}
+
+// trackScore returns the score TrackSortScore orders track by, lowest
+// first: its smoothness metric, or +Inf if that cannot be measured.
+func trackScore(track *Track) float64 {
+	if len(track.Points) < 3 {
+		return math.Inf(1)
+	}
+	if s := calculateSmoothnessMetric(track); s != math.MaxFloat64 {
+		return s
+	}
+	return math.Inf(1)
+}
+
+// GetTracksPage returns up to limit of the tracks GetTracks returns,
+// sorted by the given order, starting at offset, together with the number
+// of those tracks. Consecutive pages of one order neither overlap nor skip
+// a track as long as no frame is added between them. See PageTracks for
+// the offset and limit.
+func (t *Tracker) GetTracksPage(offset, limit int, by TrackSort) ([]*Track, int) {
+	return PageTracks(t.GetTracks(), offset, limit, by)
+}
+
+// PageTracks returns up to limit of tracks, sorted by the given order,
+// starting at offset, together with len(tracks). tracks itself is left in
+// its order, so one slice can be paged several ways. A negative offset is
+// taken as zero, and a limit of zero or less means no limit; an offset
+// past the last track gives an empty page.
+func PageTracks(tracks []*Track, offset, limit int, by TrackSort) ([]*Track, int) {
+	tracks = append([]*Track(nil), tracks...)
+	SortTracks(tracks, by)
+	total := len(tracks)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 && limit < total-offset {
+		end = offset + limit
+	}
+	return tracks[offset:end], total
+}
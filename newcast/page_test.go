@@ -0,0 +1,128 @@
+package newcast
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// pagedTracker returns a tracker holding 23 active tracks of 1 to 8
+// points, out of ID order, and a lost one. Track i turns by an angle that
+// grows with i % 5, so scores differ too.
+func pagedTracker(t *testing.T) *Tracker {
+	t.Helper()
+	tracker, err := NewTracker(10)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	start := time.Date(2025, 10, 4, 9, 0, 0, 0, time.UTC)
+	for _, id := range []int{7, 3, 19, 0, 12, 22, 5, 16, 1, 9, 14, 20, 2, 11, 8, 17, 4, 21, 6, 13, 10, 18, 15, 23} {
+		track := &Track{ID: id, Lost: id == 13}
+		turn := float32(id%5) * 0.5
+		for k := 0; k < 1+id%8; k++ {
+			track.Points = append(track.Points, Point{Time: start.Add(time.Duration(k) * time.Minute), Vec: gocv.Point2f{X: float32(10 * k), Y: turn * float32(k*k)}})
+		}
+		tracker.tracks = append(tracker.tracks, track)
+	}
+	return tracker
+}
+
+// TestGetTracksPageByLength requests two consecutive pages sorted by length
+// and checks that together they cover every active track once, longest
+// first.
+func TestGetTracksPageByLength(t *testing.T) {
+	tracker := pagedTracker(t)
+	defer tracker.Close()
+
+	first, total := tracker.GetTracksPage(0, 12, TrackSortLength)
+	second, total2 := tracker.GetTracksPage(12, 12, TrackSortLength)
+	if total != 23 || total2 != 23 {
+		t.Fatalf("Expected a total of 23 active tracks, got %d and %d", total, total2)
+	}
+	if len(first) != 12 || len(second) != 11 {
+		t.Fatalf("Expected pages of 12 and 11 tracks, got %d and %d", len(first), len(second))
+	}
+	seen := map[int]bool{}
+	pages := append(append([]*Track(nil), first...), second...)
+	for _, track := range pages {
+		if seen[track.ID] {
+			t.Errorf("Track %d is on both pages", track.ID)
+		}
+		seen[track.ID] = true
+	}
+	for _, track := range tracker.GetTracks() {
+		if !seen[track.ID] {
+			t.Errorf("Track %d is on neither page", track.ID)
+		}
+	}
+	if seen[13] {
+		t.Error("Expected the lost track to be left out")
+	}
+	for i := 1; i < len(pages); i++ {
+		a, b := pages[i-1], pages[i]
+		if len(a.Points) < len(b.Points) || len(a.Points) == len(b.Points) && a.ID > b.ID {
+			t.Fatalf("Expected longest first, ties by ID, got %d (%d points) before %d (%d points)", a.ID, len(a.Points), b.ID, len(b.Points))
+		}
+	}
+
+	if page, total := tracker.GetTracksPage(30, 5, TrackSortLength); len(page) != 0 || total != 23 {
+		t.Errorf("Expected an empty page past the end, got %d tracks of %d", len(page), total)
+	}
+	if page, _ := tracker.GetTracksPage(-4, 0, TrackSortLength); len(page) != 23 {
+		t.Errorf("Expected every track without a limit, got %d", len(page))
+	}
+}
+
+// TestTrackSortOrders checks the ID order of GetTracks and the score order.
+func TestTrackSortOrders(t *testing.T) {
+	tracker := pagedTracker(t)
+	defer tracker.Close()
+
+	ids := trackIDs(tracker.GetTracks())
+	if !sort.IntsAreSorted(ids) {
+		t.Errorf("Expected GetTracks by ascending ID, got %v", ids)
+	}
+	tracker.SetOutputFilters(DensityFilter{GridCellSize: 1, MaxTracksPerCell: 100})
+	if ids := trackIDs(tracker.GetTracks()); !sort.IntsAreSorted(ids) {
+		t.Errorf("Expected filtered tracks by ascending ID, got %v", ids)
+	}
+	tracker.SetOutputFilters()
+
+	byScore, _ := tracker.GetTracksPage(0, 0, TrackSortScore)
+	for i := 1; i < len(byScore); i++ {
+		a, b := trackScore(byScore[i-1]), trackScore(byScore[i])
+		if a > b || a == b && byScore[i-1].ID > byScore[i].ID {
+			t.Fatalf("Expected the smoothest first, ties by ID, got %d (%v) before %d (%v)", byScore[i-1].ID, a, byScore[i].ID, b)
+		}
+	}
+	if last := byScore[len(byScore)-1]; len(last.Points) >= 3 {
+		t.Errorf("Expected a track too short to score last, got %d of %d points", last.ID, len(last.Points))
+	}
+
+	for _, s := range []TrackSort{TrackSortID, TrackSortLength, TrackSortScore} {
+		if got, err := ParseTrackSort(s.String()); err != nil || got != s {
+			t.Errorf("ParseTrackSort(%q) = %v, %v", s.String(), got, err)
+		}
+	}
+	if _, err := ParseTrackSort("speed"); err == nil {
+		t.Error("Expected an error for an unknown track sort")
+	}
+}
+
+// TestPageTracksKeepsInput pages one slice of tracks by length and checks
+// that the slice itself stays by ascending ID.
+func TestPageTracksKeepsInput(t *testing.T) {
+	tracker := pagedTracker(t)
+	defer tracker.Close()
+
+	tracks := tracker.GetTracks()
+	page, total := PageTracks(tracks, 0, 5, TrackSortLength)
+	if total != len(tracks) || len(page) != 5 {
+		t.Fatalf("Expected a page of 5 of %d tracks, got %d of %d", len(tracks), len(page), total)
+	}
+	if !sort.SliceIsSorted(tracks, func(i, j int) bool { return tracks[i].ID < tracks[j].ID }) {
+		t.Error("Expected PageTracks to leave its input by ascending ID")
+	}
+}
@@ -0,0 +1,51 @@
+package newcast
+
+import (
+	"math"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// PredictedPosition is an extrapolated future position of a track.
+type PredictedPosition struct {
+	Time time.Time
+	Vec  gocv.Point2f
+	// Radius is the uncertainty radius in pixels around Vec. It is derived
+	// from the fit residuals and grows with the lead time.
+	Radius float64
+}
+
+// PredictPositions extrapolates the track's fitted polynomials for
+// numFuturePoints steps past its last point, spaced by the track's average
+// time interval. The uncertainty radius of step j is the combined RMS fit
+// residual scaled by sqrt(1 + j). It returns nil if the track has no fitted
+// polynomial.
+func (tr *Track) PredictPositions(numFuturePoints int) []PredictedPosition {
+	if numFuturePoints <= 0 || len(tr.Points) < 2 {
+		return nil
+	}
+	if tr.PolyX == (Polynomial{}) && tr.PolyY == (Polynomial{}) {
+		return nil
+	}
+
+	first := tr.Points[0]
+	last := tr.Points[len(tr.Points)-1]
+	lastT := last.Time.Sub(first.Time).Seconds()
+	avgDt := lastT / float64(len(tr.Points)-1)
+	residual := math.Hypot(tr.ResidualX, tr.ResidualY)
+
+	predictions := make([]PredictedPosition, numFuturePoints)
+	for j := 1; j <= numFuturePoints; j++ {
+		futureT := lastT + float64(j)*avgDt
+		predictions[j-1] = PredictedPosition{
+			Time: first.Time.Add(time.Duration(futureT * float64(time.Second))),
+			Vec: gocv.Point2f{
+				X: float32(tr.PolyX.Eval(futureT)),
+				Y: float32(tr.PolyY.Eval(futureT)),
+			},
+			Radius: residual * math.Sqrt(1+float64(j)),
+		}
+	}
+	return predictions
+}
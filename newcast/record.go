@@ -0,0 +1,153 @@
+package newcast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// recordingVersion is the version of the recording format written by a
+// Recorder.
+const recordingVersion = 1
+
+// LKCall is one recorded call of pyramidal Lucas-Kanade optical flow: the
+// points followed, the initial guesses of a retry, and for each point its
+// match, whether it was found (Status 1) and the match error. NonFinite
+// counts the points LK reported found at a NaN or infinite position, which
+// are recorded as not found.
+type LKCall struct {
+	Prev      []gocv.Point2f `json:"prev"`
+	Guess     []gocv.Point2f `json:"guess,omitempty"`
+	Next      []gocv.Point2f `json:"next"`
+	Status    []int          `json:"status"`
+	Err       []float32      `json:"err"`
+	NonFinite int            `json:"non_finite,omitempty"`
+}
+
+// RecordedStep holds what the image processing returned during one AddImage
+// or Reseed call.
+type RecordedStep struct {
+	// Reseed marks a Tracker.Reseed call; other steps are AddImage calls.
+	Reseed bool      `json:"reseed,omitempty"`
+	Time   time.Time `json:"time"`
+	// Width and Height are the size of the step's frame.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Features are the features detected in the first frame.
+	Features []gocv.Point2f `json:"features,omitempty"`
+	// LK is the tracking of every track into the frame, and Rescue the
+	// retry under TrackerOptions.GroupMotionRescue.
+	LK     *LKCall `json:"lk,omitempty"`
+	Rescue *LKCall `json:"rescue,omitempty"`
+	// Candidates are the features a reseed could start, strongest first.
+	Candidates []gocv.Point2f `json:"candidates,omitempty"`
+}
+
+// recordingHeader is the first line of a recording, with the tracker
+// configuration the steps were recorded under.
+type recordingHeader struct {
+	Version     int            `json:"version"`
+	MaxFeatures int            `json:"max_features"`
+	Options     TrackerOptions `json:"options"`
+}
+
+// Recorder writes a tracker's configuration and recorded steps as JSON
+// lines, for replay by a ReplayTracker. Set it as TrackerOptions.Record.
+// Floats are written in full, so a replay is exact.
+type Recorder struct {
+	bw      *bufio.Writer
+	enc     *json.Encoder
+	started bool
+	err     error
+}
+
+// NewRecorder writes a recording to w. Close must be called to flush the
+// buffered output.
+func NewRecorder(w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+	return &Recorder{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+// write appends step, preceded by the header of t on the first call. As
+// with SnapshotWriter, the first error is kept and later steps are dropped.
+func (r *Recorder) write(t *Tracker, step RecordedStep) {
+	if r.err != nil {
+		return
+	}
+	if !r.started {
+		r.started = true
+		header := recordingHeader{Version: recordingVersion, MaxFeatures: t.maxFeatures, Options: t.opts}
+		if err := r.enc.Encode(header); err != nil {
+			r.err = fmt.Errorf("failed to write recording header: %w", err)
+			return
+		}
+	}
+	if err := r.enc.Encode(step); err != nil {
+		r.err = fmt.Errorf("failed to write recorded step: %w", err)
+	}
+}
+
+// Err returns the first error encountered while writing, if any.
+func (r *Recorder) Err() error {
+	return r.err
+}
+
+// Close flushes the buffered steps. It returns the first error encountered;
+// the underlying writer is left open.
+func (r *Recorder) Close() error {
+	if err := r.bw.Flush(); err != nil && r.err == nil {
+		r.err = fmt.Errorf("failed to flush recording: %w", err)
+	}
+	return r.err
+}
+
+// recordingFrame passes the image processing through to a frameSource and
+// keeps its results.
+type recordingFrame struct {
+	frameSource
+	step RecordedStep
+}
+
+// detect keeps the features of the last detection, so that a replay finds
+// those of a successful retry at the first attempt.
+func (f *recordingFrame) detect(maxCorners int, minDistance float64, relaxed bool) []gocv.Point2f {
+	f.step.Features = f.frameSource.detect(maxCorners, minDistance, relaxed)
+	return f.step.Features
+}
+
+func (f *recordingFrame) track(prev []gocv.Point2f) *LKCall {
+	f.step.LK = f.frameSource.track(prev)
+	return f.step.LK
+}
+
+func (f *recordingFrame) retrack(prev, guesses []gocv.Point2f) *LKCall {
+	f.step.Rescue = f.frameSource.retrack(prev, guesses)
+	return f.step.Rescue
+}
+
+func (f *recordingFrame) candidates(sep float64) []gocv.Point2f {
+	f.step.Candidates = f.frameSource.candidates(sep)
+	return f.step.Candidates
+}
+
+// recording wraps src to record step if TrackerOptions.Record is set, and
+// returns src unchanged otherwise.
+func (t *Tracker) recording(src frameSource, step RecordedStep) frameSource {
+	if t.opts.Record == nil {
+		return src
+	}
+	size := src.size()
+	step.Width, step.Height = size.X, size.Y
+	return &recordingFrame{frameSource: src, step: step}
+}
+
+// record writes the step src recorded, if it is recording.
+func (t *Tracker) record(src frameSource) {
+	if f, ok := src.(*recordingFrame); ok {
+		t.opts.Record.write(t, f.step)
+	}
+}
@@ -0,0 +1,51 @@
+package newcast
+
+import (
+	"errors"
+	"fmt"
+
+	"gocv.io/x/gocv"
+)
+
+// ErrUnknownMotionModel is returned by RefitTracks for a MotionModel it does
+// not know.
+var ErrUnknownMotionModel = errors.New("newcast: unknown motion model")
+
+// MotionModel selects how RefitTracks estimates the motion of a track.
+type MotionModel int
+
+const (
+	// MotionQuadratic fits a quadratic to all the points of a track, as
+	// Track.Refit does, and falls back to MotionFiniteDifference for a
+	// track of 2 points or a fit that is not finite. Unlike the tracker it
+	// fits tracks of 3 points, such as simplified ones.
+	MotionQuadratic MotionModel = iota
+	// MotionFiniteDifference takes the velocity from the last two points
+	// and the acceleration from the last three, and fits no polynomials.
+	MotionFiniteDifference
+)
+
+// RefitTracks re-estimates the motion of each track from its current
+// points with model: its LatestVelocity, LatestAcceleration, polynomials
+// and residuals. Call it after editing the points of tracks, so the motion
+// does not describe points that are gone. The previous motion is cleared
+// first, so a track with fewer than 2 points is left without motion, and
+// so is any part of the estimate that is not finite. The tracks' Kalman
+// filters and the smoothed values of their points are not updated.
+func RefitTracks(tracks []*Track, model MotionModel) error {
+	if model != MotionQuadratic && model != MotionFiniteDifference {
+		return fmt.Errorf("%w: %d", ErrUnknownMotionModel, model)
+	}
+	for _, track := range tracks {
+		track.LatestVelocity, track.LatestAcceleration = gocv.Point2f{}, gocv.Point2f{}
+		track.PolyX, track.PolyY = Polynomial{}, Polynomial{}
+		track.ResidualX, track.ResidualY = 0, 0
+		if model == MotionQuadratic && track.Refit() == nil {
+			continue
+		}
+		if len(track.Points) >= 2 {
+			fitMotion(track, MotionFiniteDifference)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package newcast
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// quadraticTrack returns a track of n points 10 s apart on
+// x = 10 + 0.5t + 0.001t², y = 20 - 0.2t + 0.0005t², and its analytic
+// velocity at t seconds.
+func quadraticTrack(n int) (*Track, func(t float64) (vx, vy float64)) {
+	t0 := time.Date(2025, 10, 5, 12, 0, 0, 0, time.UTC)
+	track := &Track{ID: 1}
+	for i := 0; i < n; i++ {
+		t := float64(10 * i)
+		track.Points = append(track.Points, Point{
+			Time: t0.Add(time.Duration(i) * 10 * time.Second),
+			Vec:  gocv.Point2f{X: float32(10 + 0.5*t + 0.001*t*t), Y: float32(20 - 0.2*t + 0.0005*t*t)},
+		})
+	}
+	return track, func(t float64) (float64, float64) { return 0.5 + 0.002*t, -0.2 + 0.001*t }
+}
+
+// TestRefitTracksAfterClip checks that a quadratic track clipped to its
+// first half is refit to the analytic velocity at its new last point.
+func TestRefitTracksAfterClip(t *testing.T) {
+	track, velocity := quadraticTrack(20)
+	if err := RefitTracks([]*Track{track}, MotionQuadratic); err != nil {
+		t.Fatalf("RefitTracks failed: %v", err)
+	}
+	if vx, vy := velocity(190); math.Abs(float64(track.LatestVelocity.X)-vx) > 1e-3 || math.Abs(float64(track.LatestVelocity.Y)-vy) > 1e-3 {
+		t.Fatalf("Expected velocity (%.3f, %.3f) at the end of the full track, got %v", vx, vy, track.LatestVelocity)
+	}
+
+	clipped := *track
+	clipped.Points = track.Points[:10]
+	if err := RefitTracks([]*Track{&clipped}, MotionQuadratic); err != nil {
+		t.Fatalf("RefitTracks failed: %v", err)
+	}
+	vx, vy := velocity(90)
+	if math.Abs(float64(clipped.LatestVelocity.X)-vx) > 1e-3 || math.Abs(float64(clipped.LatestVelocity.Y)-vy) > 1e-3 {
+		t.Errorf("Expected velocity (%.3f, %.3f) at the new last point, got %v", vx, vy, clipped.LatestVelocity)
+	}
+	if math.Abs(float64(clipped.LatestAcceleration.X)-0.002) > 1e-4 || math.Abs(float64(clipped.LatestAcceleration.Y)-0.001) > 1e-4 {
+		t.Errorf("Expected acceleration (0.002, 0.001), got %v", clipped.LatestAcceleration)
+	}
+
+	// Finite differences give the velocity halfway between the last two
+	// points, and no fit.
+	if err := RefitTracks([]*Track{&clipped}, MotionFiniteDifference); err != nil {
+		t.Fatalf("RefitTracks failed: %v", err)
+	}
+	vx, vy = velocity(85)
+	if math.Abs(float64(clipped.LatestVelocity.X)-vx) > 1e-3 || math.Abs(float64(clipped.LatestVelocity.Y)-vy) > 1e-3 {
+		t.Errorf("Expected finite difference velocity (%.3f, %.3f), got %v", vx, vy, clipped.LatestVelocity)
+	}
+	if clipped.PolyX != (Polynomial{}) || clipped.PolyY != (Polynomial{}) {
+		t.Errorf("Expected no fit under MotionFiniteDifference, got %v and %v", clipped.PolyX, clipped.PolyY)
+	}
+
+	single := &Track{Points: track.Points[:1], LatestVelocity: track.LatestVelocity}
+	if err := RefitTracks([]*Track{single}, MotionQuadratic); err != nil || single.LatestVelocity != (gocv.Point2f{}) {
+		t.Errorf("Expected a single point track to be left without motion, got %v and %v", single.LatestVelocity, err)
+	}
+	if err := RefitTracks([]*Track{track}, MotionModel(7)); !errors.Is(err, ErrUnknownMotionModel) {
+		t.Errorf("Expected ErrUnknownMotionModel, got %v", err)
+	}
+}
+
+// TestSimplifyTrackRefits checks that a simplified track's motion is fitted
+// to its remaining points.
+func TestSimplifyTrackRefits(t *testing.T) {
+	track, _ := quadraticTrack(20)
+	simple := SimplifyTrack(track, 0.5)
+	if len(simple.Points) >= len(track.Points) {
+		t.Fatalf("Expected SimplifyTrack to drop points, kept %d of %d", len(simple.Points), len(track.Points))
+	}
+	polyX, polyY, err := FitQuadratic(simple.Points)
+	if err != nil {
+		t.Fatalf("FitQuadratic failed: %v", err)
+	}
+	if simple.PolyX != polyX || simple.PolyY != polyY {
+		t.Errorf("Expected the fit of the remaining points, got %v and %v, want %v and %v", simple.PolyX, simple.PolyY, polyX, polyY)
+	}
+}
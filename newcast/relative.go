@@ -0,0 +1,89 @@
+package newcast
+
+import "gocv.io/x/gocv"
+
+// MeanVelocity returns the mean motion of tracks in pixels per second: the
+// mean over the tracks of the displacement from their first to their last
+// point divided by the time between them. Tracks with fewer than 2 points,
+// or whose points share a time, are left out, and the mean of no tracks is
+// zero. It is the usual reference motion for ToStormRelative.
+func MeanVelocity(tracks []*Track) gocv.Point2f {
+	var sumX, sumY float64
+	n := 0
+	for _, track := range tracks {
+		if len(track.Points) < 2 {
+			continue
+		}
+		first, last := track.Points[0], track.Points[len(track.Points)-1]
+		dt := last.Time.Sub(first.Time).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		sumX += float64(last.Vec.X-first.Vec.X) / dt
+		sumY += float64(last.Vec.Y-first.Vec.Y) / dt
+		n++
+	}
+	if n == 0 {
+		return gocv.Point2f{}
+	}
+	return gocv.Point2f{X: float32(sumX / float64(n)), Y: float32(sumY / float64(n))}
+}
+
+// ToStormRelative returns copies of tracks in a reference frame moving at
+// meanVelocity, in pixels per second, such as the one returned by
+// MeanVelocity. Each point is moved back by meanVelocity times the time
+// elapsed since its track's first point, so the first points stay put and
+// a track moving with the mean flow stands still. Curvature masked by fast
+// common motion, from rotation or propagation of a cell, then shows in the
+// tracks and in fits of them.
+//
+// The velocities and fitted polynomials of the copies are shifted to match;
+// accelerations and residuals do not change. The copies do not carry the
+// tracks' Kalman filters. FromStormRelative is the inverse transform.
+func ToStormRelative(tracks []*Track, meanVelocity gocv.Point2f) []*Track {
+	return shiftTracks(tracks, gocv.Point2f{X: -meanVelocity.X, Y: -meanVelocity.Y})
+}
+
+// FromStormRelative returns copies of storm-relative tracks, as returned by
+// ToStormRelative with the same meanVelocity, back in the fixed frame of
+// the images.
+func FromStormRelative(tracks []*Track, meanVelocity gocv.Point2f) []*Track {
+	return shiftTracks(tracks, meanVelocity)
+}
+
+// shiftTracks returns copies of tracks with velocity, in pixels per second,
+// added to their motion since their first point.
+func shiftTracks(tracks []*Track, velocity gocv.Point2f) []*Track {
+	out := make([]*Track, len(tracks))
+	for i, track := range tracks {
+		shifted := *track
+		shifted.filter = nil
+		shifted.Points = make([]Point, len(track.Points))
+		for j, p := range track.Points {
+			var elapsed float32
+			if j > 0 {
+				elapsed = float32(p.Time.Sub(track.Points[0].Time).Seconds())
+			}
+			p.Vec = addScaled(p.Vec, velocity, elapsed)
+			if p.Filtered {
+				p.Smoothed = addScaled(p.Smoothed, velocity, elapsed)
+				p.SmoothedVelocity = addScaled(p.SmoothedVelocity, velocity, 1)
+			}
+			shifted.Points[j] = p
+		}
+		shifted.LatestVelocity = addScaled(track.LatestVelocity, velocity, 1)
+		// Fits are in seconds since the first point, so only the linear
+		// term changes. An unset fit stays unset.
+		if track.PolyX != (Polynomial{}) || track.PolyY != (Polynomial{}) {
+			shifted.PolyX.B += float64(velocity.X)
+			shifted.PolyY.B += float64(velocity.Y)
+		}
+		out[i] = &shifted
+	}
+	return out
+}
+
+// addScaled returns p + k*v.
+func addScaled(p, v gocv.Point2f, k float32) gocv.Point2f {
+	return gocv.Point2f{X: p.X + k*v.X, Y: p.Y + k*v.Y}
+}
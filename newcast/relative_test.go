@@ -0,0 +1,91 @@
+package newcast
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// rotatingTracks returns n fitted tracks that all translate by drift pixels
+// per second while each circles its own centre with the given radius and
+// angular speed, starting at evenly spread phases, over a quarter turn.
+func rotatingTracks(n int, drift gocv.Point2f, radius, omega float64) []*Track {
+	t0 := time.Date(2025, 10, 3, 14, 0, 0, 0, time.UTC)
+	const points = 11
+	step := math.Pi / 2 / omega / (points - 1)
+	var tracks []*Track
+	for i := 0; i < n; i++ {
+		phase := 2 * math.Pi * float64(i) / float64(n)
+		cx, cy := 100+50*float64(i), 200.0
+		track := &Track{ID: i + 1}
+		for k := 0; k < points; k++ {
+			s := float64(k) * step
+			a := phase + omega*s
+			track.Points = append(track.Points, Point{
+				Time: t0.Add(time.Duration(s * float64(time.Second))),
+				Vec: gocv.Point2f{
+					X: float32(cx + radius*math.Cos(a) + float64(drift.X)*s),
+					Y: float32(cy + radius*math.Sin(a) + float64(drift.Y)*s),
+				},
+			})
+		}
+		track.PolyX, track.PolyY, _ = FitQuadratic(track.Points)
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// fitCurvature returns the signed curvature of a track's fit halfway
+// through it, positive when it turns clockwise on screen.
+func fitCurvature(track *Track) float64 {
+	mid := track.Points[len(track.Points)-1].Time.Sub(track.Points[0].Time).Seconds() / 2
+	vx, vy := track.PolyX.Velocity(mid), track.PolyY.Velocity(mid)
+	ax, ay := track.PolyX.Acceleration(), track.PolyY.Acceleration()
+	return (vx*ay - vy*ax) / math.Pow(math.Hypot(vx, vy), 3)
+}
+
+func TestToStormRelativeRevealsRotation(t *testing.T) {
+	const radius = 8.0
+	drift := gocv.Point2f{X: 2, Y: 1}
+	tracks := rotatingTracks(6, drift, radius, 2*math.Pi/1200)
+
+	mean := MeanVelocity(tracks)
+	if math.Abs(float64(mean.X-drift.X)) > 1e-3 || math.Abs(float64(mean.Y-drift.Y)) > 1e-3 {
+		t.Fatalf("Expected a mean velocity of %v, got %v", drift, mean)
+	}
+
+	relative := ToStormRelative(tracks, mean)
+	for i, track := range relative {
+		fixed := fitCurvature(tracks[i])
+		got := fitCurvature(track)
+		// The refit must agree with the shifted fit.
+		polyX, polyY, err := FitQuadratic(track.Points)
+		if err != nil {
+			t.Fatalf("FitQuadratic failed: %v", err)
+		}
+		if math.Abs(polyX.B-track.PolyX.B) > 1e-3 || math.Abs(polyY.B-track.PolyY.B) > 1e-3 {
+			t.Errorf("Track %d: shifted fit (%v, %v) differs from the refit (%v, %v)", track.ID, track.PolyX, track.PolyY, polyX, polyY)
+		}
+		if math.Abs(got-1/radius) > 0.25/radius {
+			t.Errorf("Track %d: expected a storm-relative curvature near %.3f, got %.3f", track.ID, 1/radius, got)
+		}
+		if math.Abs(fixed) > math.Abs(got)/100 {
+			t.Errorf("Track %d: expected the drift to mask the rotation, got a curvature of %.5f", track.ID, fixed)
+		}
+	}
+
+	back := FromStormRelative(relative, mean)
+	for i, track := range back {
+		for j, p := range track.Points {
+			want := tracks[i].Points[j].Vec
+			if math.Abs(float64(p.Vec.X-want.X)) > 1e-3 || math.Abs(float64(p.Vec.Y-want.Y)) > 1e-3 {
+				t.Fatalf("Track %d point %d: expected %v after the round trip, got %v", track.ID, j, want, p.Vec)
+			}
+		}
+	}
+	if tracks[0].Points[5] == relative[0].Points[5] {
+		t.Error("Expected ToStormRelative to leave the original tracks alone")
+	}
+}
@@ -0,0 +1,169 @@
+package newcast
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+
+	"gocv.io/x/gocv"
+)
+
+// ErrReplayDiverged is returned by ReplayTracker when the tracker asks for
+// image processing the recording does not hold, or with different inputs.
+// That happens when the recording was made by a different version of the
+// tracker logic.
+var ErrReplayDiverged = errors.New("newcast: replay diverged from the recording")
+
+// ReplayOptions configures a ReplayTracker. The tracker itself is configured
+// as it was when the recording was made.
+type ReplayOptions struct {
+	OnFrame func(snapshot FrameSnapshot)
+	OnEvent func(event TrackEvent)
+}
+
+// ReplayTracker repeats a run recorded through TrackerOptions.Record. The
+// image processing results come from the recording, so no frames are needed
+// and no optical flow or feature detection runs, while the tracking logic
+// (track updates, motion fitting, Kalman filtering, rescue and reseed
+// bookkeeping, events and snapshots) runs as it did, giving the same tracks
+// on any machine.
+type ReplayTracker struct {
+	tracker *Tracker
+	dec     *json.Decoder
+}
+
+// NewReplayTracker reads the header of the recording in r and returns a
+// tracker configured as the recorded one, positioned before the first step.
+func NewReplayTracker(r io.Reader, opts ReplayOptions) (*ReplayTracker, error) {
+	dec := json.NewDecoder(r)
+	var header recordingHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read recording header: %w", err)
+	}
+	if header.Version != recordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %d", header.Version)
+	}
+	trackerOpts := header.Options
+	trackerOpts.OnFrame = opts.OnFrame
+	trackerOpts.OnEvent = opts.OnEvent
+	tracker, err := NewTrackerWithOptions(header.MaxFeatures, trackerOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayTracker{tracker: tracker, dec: dec}, nil
+}
+
+// Tracker returns the replayed tracker, for reading its tracks and
+// statistics. Adding images to it mixes live frames into the replay.
+func (rt *ReplayTracker) Tracker() *Tracker {
+	return rt.tracker
+}
+
+// Close releases the tracker.
+func (rt *ReplayTracker) Close() {
+	rt.tracker.Close()
+}
+
+// Next replays the next recorded step. It returns io.EOF after the last.
+func (rt *ReplayTracker) Next() error {
+	var step RecordedStep
+	if err := rt.dec.Decode(&step); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return fmt.Errorf("failed to read recorded step: %w", err)
+	}
+	src := &replayFrame{step: &step}
+	if step.Reseed {
+		rt.tracker.reseedNow(src)
+	} else if err := rt.tracker.step(src, step.Time); err != nil {
+		return err
+	}
+	return src.check()
+}
+
+// Run replays the remaining steps.
+func (rt *ReplayTracker) Run() error {
+	for {
+		if err := rt.Next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// replayFrame is the frameSource of a recorded step. It notes requests the
+// step cannot answer instead of failing them, since frameSource calls
+// cannot fail; check reports them after the step.
+type replayFrame struct {
+	step     *RecordedStep
+	diverged string // the first divergence, if any
+	// usedLK and usedRescue record whether the step asked for the tracking
+	// and rescue results.
+	usedLK, usedRescue bool
+}
+
+func (f *replayFrame) fail(format string, args ...any) {
+	if f.diverged == "" {
+		f.diverged = fmt.Sprintf(format, args...)
+	}
+}
+
+func (f *replayFrame) size() image.Point {
+	return image.Pt(f.step.Width, f.step.Height)
+}
+
+func (f *replayFrame) detect(maxCorners int, minDistance float64, relaxed bool) []gocv.Point2f {
+	return f.step.Features
+}
+
+func (f *replayFrame) track(prev []gocv.Point2f) *LKCall {
+	f.usedLK = true
+	return f.call(f.step.LK, "tracking", prev, nil)
+}
+
+func (f *replayFrame) retrack(prev, guesses []gocv.Point2f) *LKCall {
+	f.usedRescue = true
+	return f.call(f.step.Rescue, "rescue", prev, guesses)
+}
+
+// call returns the recorded LK call if its inputs match.
+func (f *replayFrame) call(lk *LKCall, name string, prev, guesses []gocv.Point2f) *LKCall {
+	if lk == nil {
+		f.fail("%v: no %s recorded", f.step.Time, name)
+	} else if !slices.Equal(lk.Prev, prev) || !slices.Equal(lk.Guess, guesses) {
+		f.fail("%v: %s of different points than recorded", f.step.Time, name)
+	} else if len(lk.Next) != len(prev) || len(lk.Status) != len(prev) || len(lk.Err) != len(prev) {
+		f.fail("%v: malformed %s record", f.step.Time, name)
+	} else {
+		return lk
+	}
+	// Carry on with every point lost.
+	return &LKCall{Prev: prev, Next: make([]gocv.Point2f, len(prev)), Status: make([]int, len(prev)), Err: make([]float32, len(prev))}
+}
+
+func (f *replayFrame) advance() {}
+
+func (f *replayFrame) candidates(sep float64) []gocv.Point2f {
+	return f.step.Candidates
+}
+
+// check returns an ErrReplayDiverged error if the step asked for results
+// the recording lacks, or did not use results it holds.
+func (f *replayFrame) check() error {
+	switch {
+	case f.step.LK != nil && !f.usedLK:
+		f.fail("%v: tracking recorded but not replayed", f.step.Time)
+	case f.step.Rescue != nil && !f.usedRescue:
+		f.fail("%v: rescue recorded but not replayed", f.step.Time)
+	}
+	if f.diverged != "" {
+		return fmt.Errorf("%w: %s", ErrReplayDiverged, f.diverged)
+	}
+	return nil
+}
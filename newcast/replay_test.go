@@ -0,0 +1,117 @@
+package newcast
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordRun tracks a drifting low-texture scene with most of the optional
+// logic switched on, recording it, and returns the recording with the
+// tracker and the events it emitted.
+func recordRun(t *testing.T) ([]byte, *Tracker, []TrackEvent) {
+	t.Helper()
+	frames := lowTextureFrames(6, 256, 15, 7.5)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	var events []TrackEvent
+	tracker, err := NewTrackerWithOptions(60, TrackerOptions{
+		GroupMotionRescue:     true,
+		ReseedBelow:           60,
+		KalmanMotion:          true,
+		AccelerationThreshold: 0.001,
+		Regions:               []Region{{Name: "east", Bounds: image.Rect(128, 0, 256, 256)}},
+		OnEvent:               func(e TrackEvent) { events = append(events, e) },
+		Record:                recorder,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*5*time.Minute)); err != nil {
+			t.Fatalf("Failed to add frame %d: %v", i, err)
+		}
+		if i == 2 {
+			tracker.Reseed()
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	return buf.Bytes(), tracker, events
+}
+
+func TestReplayReproducesTracks(t *testing.T) {
+	recording, live, liveEvents := recordRun(t)
+	defer live.Close()
+
+	var events []TrackEvent
+	replay, err := NewReplayTracker(bytes.NewReader(recording), ReplayOptions{
+		OnEvent: func(e TrackEvent) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatalf("NewReplayTracker failed: %v", err)
+	}
+	defer replay.Close()
+	if err := replay.Run(); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	replayed := replay.Tracker()
+
+	// No frame ever reached the replayed tracker.
+	if !replayed.prevImg.Empty() {
+		t.Error("Expected the replay to run without images")
+	}
+	if !reflect.DeepEqual(replayed.Stats(), live.Stats()) {
+		t.Errorf("Stats differ:\nlive   %+v\nreplay %+v", live.Stats(), replayed.Stats())
+	}
+	liveTracks, tracks := live.GetAllTracks(), replayed.GetAllTracks()
+	if len(liveTracks) == 0 {
+		t.Fatal("Expected live tracks")
+	}
+	if !reflect.DeepEqual(tracks, liveTracks) {
+		t.Errorf("Replayed tracks differ from the live run: %d vs %d tracks", len(tracks), len(liveTracks))
+	}
+	if !reflect.DeepEqual(events, liveEvents) {
+		t.Errorf("Replayed events differ from the live run: %d vs %d events", len(events), len(liveEvents))
+	}
+
+	stats := live.Stats()
+	var rescued, reseeded int
+	for _, s := range stats {
+		rescued += s.Rescued
+		reseeded += s.Reseeded
+	}
+	t.Logf("%d tracks, %d events, %d rescued and %d reseeded over %d frame pairs", len(tracks), len(events), rescued, reseeded, len(stats))
+}
+
+func TestReplayDetectsDivergence(t *testing.T) {
+	recording, live, _ := recordRun(t)
+	live.Close()
+
+	// Replaying without the rescue leaves the recorded rescues unused.
+	tampered := strings.Replace(string(recording), `"GroupMotionRescue":true`, `"GroupMotionRescue":false`, 1)
+	replay, err := NewReplayTracker(strings.NewReader(tampered), ReplayOptions{})
+	if err != nil {
+		t.Fatalf("NewReplayTracker failed: %v", err)
+	}
+	defer replay.Close()
+	if err := replay.Run(); !errors.Is(err, ErrReplayDiverged) {
+		t.Errorf("Expected ErrReplayDiverged, got %v", err)
+	}
+
+	if _, err := NewReplayTracker(strings.NewReader(`{"version":99}`), ReplayOptions{}); err == nil {
+		t.Error("Expected an unknown recording version to be rejected")
+	}
+}
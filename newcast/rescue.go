@@ -0,0 +1,100 @@
+package newcast
+
+import (
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// DefaultRescueMaxError is the default TrackerOptions.RescueMaxError.
+	DefaultRescueMaxError = 12
+	// DefaultOutlierDistance is the default TrackerOptions.OutlierDistance.
+	DefaultOutlierDistance = 3.0
+
+	// minRescueSupport is the number of tracked points needed before their
+	// median displacement is trusted as the group motion.
+	minRescueSupport = 3
+)
+
+func (o TrackerOptions) rescueMaxError() float32 {
+	if o.RescueMaxError > 0 {
+		return o.RescueMaxError
+	}
+	return DefaultRescueMaxError
+}
+
+func (o TrackerOptions) outlierDistance() float64 {
+	if o.OutlierDistance > 0 {
+		return o.OutlierDistance
+	}
+	return DefaultOutlierDistance
+}
+
+// rescueMatches re-runs LK from the previous frame to the new one for the
+// points that were not found or moved unlike the rest, starting each search
+// at the point's previous position plus the median displacement. The retry
+// uses a shallow pyramid so it corrects the prediction locally rather than
+// searching afresh. A retried match replaces the first one only if its error
+// is below the rescue threshold and it agrees with the median displacement;
+// outliers whose retry is rejected keep their original match. next and
+// found are updated in place and the number of rescued points is returned.
+func (t *Tracker) rescueMatches(src frameSource, next []gocv.Point2f, found []bool) int {
+	prev := t.prevPoints
+	var dxs, dys []float64
+	for i := range next {
+		if found[i] {
+			dxs = append(dxs, float64(next[i].X-prev[i].X))
+			dys = append(dys, float64(next[i].Y-prev[i].Y))
+		}
+	}
+	if len(dxs) < minRescueSupport {
+		return 0
+	}
+	mdx, mdy := median(dxs), median(dys)
+
+	var retry []int
+	for i := range next {
+		if !found[i] || math.Hypot(float64(next[i].X-prev[i].X)-mdx, float64(next[i].Y-prev[i].Y)-mdy) > t.opts.outlierDistance() {
+			retry = append(retry, i)
+		}
+	}
+	if len(retry) == 0 {
+		return 0
+	}
+
+	retryPrev := make([]gocv.Point2f, len(retry))
+	guesses := make([]gocv.Point2f, len(retry))
+	for k, i := range retry {
+		retryPrev[k] = prev[i]
+		guesses[k] = gocv.Point2f{X: prev[i].X + float32(mdx), Y: prev[i].Y + float32(mdy)}
+	}
+	lk := src.retrack(retryPrev, guesses)
+
+	rescued := 0
+	maxErr := t.opts.rescueMaxError()
+	for k, i := range retry {
+		if lk.Status[k] != 1 || lk.Err[k] >= maxErr {
+			continue
+		}
+		pt := lk.Next[k]
+		if math.Hypot(float64(pt.X-prev[i].X)-mdx, float64(pt.Y-prev[i].Y)-mdy) > t.opts.outlierDistance() {
+			continue // converged back to a match unlike the rest
+		}
+		next[i] = pt
+		found[i] = true
+		rescued++
+	}
+	return rescued
+}
+
+// median returns the median of values, reordering them.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,93 @@
+package newcast
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// lowTextureFrames returns n size x size grayscale frames of a smooth,
+// low-contrast field of broad blobs translating by (dx, dy) pixels per frame.
+func lowTextureFrames(n, size int, dx, dy float64) []gocv.Mat {
+	rng := rand.New(rand.NewSource(7))
+	type blob struct{ x, y, r, a float64 }
+	blobs := make([]blob, 40)
+	for i := range blobs {
+		blobs[i] = blob{
+			x: (rng.Float64()*1.5 - 0.25) * float64(size),
+			y: (rng.Float64()*1.5 - 0.25) * float64(size),
+			r: 6 + rng.Float64()*14,
+			a: 10 * (rng.Float64()*2 - 1),
+		}
+	}
+
+	frames := make([]gocv.Mat, n)
+	for f := range frames {
+		mat := gocv.NewMatWithSize(size, size, gocv.MatTypeCV8U)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				v := 128.0
+				for _, b := range blobs {
+					ox := float64(x) - b.x - dx*float64(f)
+					oy := float64(y) - b.y - dy*float64(f)
+					v += b.a * math.Exp(-(ox*ox+oy*oy)/(2*b.r*b.r))
+				}
+				mat.SetUCharAt(y, x, uint8(math.Max(0, math.Min(255, v))))
+			}
+		}
+		frames[f] = mat
+	}
+	return frames
+}
+
+func TestGroupMotionRescue(t *testing.T) {
+	const dx, dy = 45.0, 22.5
+	frames := lowTextureFrames(2, 256, dx, dy)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	run := func(opts TrackerOptions) *Tracker {
+		tracker, err := NewTrackerWithOptions(80, opts)
+		if err != nil {
+			t.Fatalf("Failed to create tracker: %v", err)
+		}
+		ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+		for i, f := range frames {
+			if err := tracker.AddImage(f, ts.Add(time.Duration(i)*5*time.Minute)); err != nil {
+				t.Fatalf("Failed to add frame %d: %v", i, err)
+			}
+		}
+		return tracker
+	}
+
+	plain := run(TrackerOptions{})
+	defer plain.Close()
+	rescued := run(TrackerOptions{GroupMotionRescue: true})
+	defer rescued.Close()
+
+	plainStats, rescuedStats := plain.Stats(), rescued.Stats()
+	if len(plainStats) != 1 || len(rescuedStats) != 1 {
+		t.Fatalf("Expected stats for one frame pair, got %d and %d", len(plainStats), len(rescuedStats))
+	}
+	if plainStats[0].Rescued != 0 {
+		t.Errorf("Expected no rescued points without the option, got %d", plainStats[0].Rescued)
+	}
+	if rescuedStats[0].Rescued == 0 {
+		t.Error("Expected some points to be rescued")
+	}
+	if got := len(rescued.GetTracks()); got != rescuedStats[0].Tracked {
+		t.Errorf("Stats report %d tracked points, but %d tracks are active", rescuedStats[0].Tracked, got)
+	}
+
+	plainCount, rescuedCount := len(plain.GetTracks()), len(rescued.GetTracks())
+	t.Logf("%d tracks survived without the option, %d with it", plainCount, rescuedCount)
+	if rescuedCount < plainCount+plainCount/5 {
+		t.Errorf("Expected materially more surviving tracks with the option on, got %d vs %d", rescuedCount, plainCount)
+	}
+}
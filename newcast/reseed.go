@@ -0,0 +1,119 @@
+package newcast
+
+import (
+	"example/goflow/flow"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultMinFeatureSeparation is the default TrackerOptions.MinFeatureSeparation.
+const DefaultMinFeatureSeparation = 10.0
+
+func (o TrackerOptions) minFeatureSeparation() float64 {
+	if o.MinFeatureSeparation > 0 {
+		return o.MinFeatureSeparation
+	}
+	return DefaultMinFeatureSeparation
+}
+
+func (o TrackerOptions) gridSpacing() int {
+	if o.GridSpacing > 0 {
+		return o.GridSpacing
+	}
+	return flow.DefaultGridSpacing
+}
+
+// scaledGridSpacing returns the grid spacing in pixels of the processed
+// image.
+func (o TrackerOptions) scaledGridSpacing() int {
+	return max(1, int(math.Round(float64(o.gridSpacing())*o.processingScale())))
+}
+
+// Reseed detects new features in the most recent frame and starts tracks
+// for them, bringing the tracker back up to maxFeatures. New features keep
+// at least TrackerOptions.MinFeatureSeparation from the endpoints of the
+// existing tracks and from each other, and under
+// TrackerOptions.StaticSuppression they must lie on active pixels. It returns
+// the number of tracks started. AddImage calls it automatically when
+// TrackerOptions.ReseedBelow is set.
+func (t *Tracker) Reseed() int {
+	src := t.recording(&opencvFrame{t: t, img: t.prevImg, full: t.frameSize}, RecordedStep{Reseed: true, Time: t.lastTime})
+	started := t.reseedNow(src)
+	t.record(src)
+	return started
+}
+
+// reseedNow is Reseed with the image processing done by src.
+func (t *Tracker) reseedNow(src frameSource) int {
+	started := t.reseed(src)
+	if started > 0 {
+		t.updatePrevPoints()
+	}
+	return started
+}
+
+// reseed starts new tracks without rebuilding prevPoints.
+func (t *Tracker) reseed(src frameSource) int {
+	want := t.maxFeatures - len(t.tracks)
+	if !t.started || want <= 0 {
+		return 0
+	}
+	sep := t.opts.minFeatureSeparation()
+
+	grid := newSeparationGrid(sep)
+	for _, track := range t.tracks {
+		grid.add(track.Points[len(track.Points)-1].Vec)
+	}
+
+	started := 0
+	for _, pt := range src.candidates(sep) {
+		if started == want {
+			break
+		}
+		// Safety net for candidates the rasterised mask lets through.
+		if grid.near(pt) {
+			continue
+		}
+		grid.add(pt)
+		t.startTrack(pt, t.lastTime)
+		started++
+	}
+	return started
+}
+
+// separationGrid buckets points into cells of the separation size so that
+// checking a candidate only visits the neighbouring cells.
+type separationGrid struct {
+	sep   float64
+	cells map[image.Point][]gocv.Point2f
+}
+
+func newSeparationGrid(sep float64) *separationGrid {
+	return &separationGrid{sep: sep, cells: make(map[image.Point][]gocv.Point2f)}
+}
+
+func (g *separationGrid) cell(pt gocv.Point2f) image.Point {
+	return image.Pt(int(math.Floor(float64(pt.X)/g.sep)), int(math.Floor(float64(pt.Y)/g.sep)))
+}
+
+func (g *separationGrid) add(pt gocv.Point2f) {
+	c := g.cell(pt)
+	g.cells[c] = append(g.cells[c], pt)
+}
+
+// near reports whether any point in the grid lies closer than sep to pt.
+func (g *separationGrid) near(pt gocv.Point2f) bool {
+	c := g.cell(pt)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			for _, q := range g.cells[image.Pt(c.X+dx, c.Y+dy)] {
+				if math.Hypot(float64(pt.X-q.X), float64(pt.Y-q.Y)) < g.sep {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
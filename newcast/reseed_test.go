@@ -0,0 +1,210 @@
+package newcast
+
+import (
+	"example/goflow/flow"
+	"math"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+func TestReseedKeepsMinimumSeparation(t *testing.T) {
+	const sep = 15.0
+	frames := lowTextureFrames(2, 256, 3, 1.5)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	tracker, err := NewTrackerWithOptions(200, TrackerOptions{MinFeatureSeparation: sep})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*5*time.Minute)); err != nil {
+			t.Fatalf("Failed to add frame %d: %v", i, err)
+		}
+	}
+
+	old := tracker.GetTracks()
+	ends := make(map[int]Point, len(old))
+	for _, track := range old {
+		ends[track.ID] = track.Points[len(track.Points)-1]
+	}
+
+	// Without the occupancy mask, detection in the newest frame finds
+	// features right next to the surviving tracks.
+	corners := gocv.NewMat()
+	defer corners.Close()
+	gocv.GoodFeaturesToTrack(frames[1], &corners, 0, 0.01, sep)
+	crowded := 0
+	for i := 0; i < corners.Rows(); i++ {
+		c := pointAt(corners, i)
+		for _, end := range ends {
+			if math.Hypot(float64(c.X-end.Vec.X), float64(c.Y-end.Vec.Y)) < sep {
+				crowded++
+				break
+			}
+		}
+	}
+	if crowded == 0 {
+		t.Fatal("Test frame should produce candidates within the separation radius")
+	}
+
+	started := tracker.Reseed()
+	if started == 0 {
+		t.Fatal("Expected the forced reseed to start new tracks")
+	}
+
+	tracks := tracker.GetTracks()
+	if len(tracks) != len(old)+started {
+		t.Fatalf("Expected %d tracks after reseeding, got %d", len(old)+started, len(tracks))
+	}
+	var fresh []Point
+	for _, track := range tracks {
+		if _, ok := ends[track.ID]; ok {
+			continue
+		}
+		if len(track.Points) != 1 || !track.Points[0].Time.Equal(ends[old[0].ID].Time) {
+			t.Errorf("New track %d should start with a single point at the latest frame time", track.ID)
+		}
+		fresh = append(fresh, track.Points[0])
+	}
+	if len(fresh) != started {
+		t.Fatalf("Expected %d new tracks, found %d", started, len(fresh))
+	}
+
+	for _, p := range fresh {
+		for id, end := range ends {
+			if d := math.Hypot(float64(p.Vec.X-end.Vec.X), float64(p.Vec.Y-end.Vec.Y)); d < sep {
+				t.Errorf("New track at %v is %.1f px from track %d's endpoint %v", p.Vec, d, id, end.Vec)
+			}
+		}
+	}
+	for i := range fresh {
+		for j := i + 1; j < len(fresh); j++ {
+			if d := math.Hypot(float64(fresh[i].Vec.X-fresh[j].Vec.X), float64(fresh[i].Vec.Y-fresh[j].Vec.Y)); d < sep {
+				t.Errorf("New tracks at %v and %v are only %.1f px apart", fresh[i].Vec, fresh[j].Vec, d)
+			}
+		}
+	}
+	t.Logf("%d tracks survived, %d reseeded, %d candidates rejected", len(old), started, crowded)
+
+	// The reseeded tracks are followed into the next frame.
+	next := lowTextureFrames(3, 256, 3, 1.5)
+	defer func() {
+		for _, f := range next {
+			f.Close()
+		}
+	}()
+	if err := tracker.AddImage(next[2], ts.Add(10*time.Minute)); err != nil {
+		t.Fatalf("Failed to add frame after reseeding: %v", err)
+	}
+	if stats := tracker.Stats(); stats[len(stats)-1].Tracked+stats[len(stats)-1].Lost != len(tracks) {
+		t.Errorf("Expected all %d tracks to be carried into the next frame, got %+v", len(tracks), stats[len(stats)-1])
+	}
+}
+
+func TestReseedBelowOption(t *testing.T) {
+	frames := lowTextureFrames(2, 256, 3, 1.5)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	tracker, err := NewTrackerWithOptions(200, TrackerOptions{ReseedBelow: 1000})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*5*time.Minute)); err != nil {
+			t.Fatalf("Failed to add frame %d: %v", i, err)
+		}
+	}
+	stats := tracker.Stats()[0]
+	if stats.Reseeded == 0 {
+		t.Error("Expected AddImage to reseed when below ReseedBelow")
+	}
+	if got := len(tracker.GetTracks()); got != stats.Tracked+stats.Reseeded {
+		t.Errorf("Expected %d active tracks, got %d", stats.Tracked+stats.Reseeded, got)
+	}
+}
+
+// TestGridSeeding checks that grid seeding starts tracks over every
+// quadrant of the shifted test frame, whose corners are all on its square,
+// and reseeds from the grid away from the surviving tracks.
+func TestGridSeeding(t *testing.T) {
+	img, err := loadImageAsGrayscale("../test_data/shifted.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	tracker, err := NewTrackerWithOptions(64, TrackerOptions{Seeding: flow.SeedGrid})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	if err := tracker.AddImage(img, time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Failed to add the frame: %v", err)
+	}
+	// The 32 pixel grid over the 1024 pixel frame is widened to 8x8 cells
+	// for the 64 features.
+	var quadrants [4]int
+	for _, track := range tracker.GetTracks() {
+		pt := track.Points[0].Vec
+		q := 0
+		if pt.X >= float32(img.Cols())/2 {
+			q++
+		}
+		if pt.Y >= float32(img.Rows())/2 {
+			q += 2
+		}
+		quadrants[q]++
+	}
+	if quadrants != [4]int{16, 16, 16, 16} {
+		t.Errorf("Expected 16 grid features in every quadrant, got %v", quadrants)
+	}
+
+	frames := lowTextureFrames(2, 256, 3, 1.5)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	const sep = 20.0
+	tracker, err = NewTrackerWithOptions(200, TrackerOptions{Seeding: flow.SeedGrid, GridSpacing: 16, MinFeatureSeparation: sep})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*5*time.Minute)); err != nil {
+			t.Fatalf("Failed to add frame %d: %v", i, err)
+		}
+	}
+	old := tracker.GetTracks()
+	if tracker.Reseed() == 0 {
+		t.Fatal("Expected the grid to reseed the tracks lost on the low-texture frames")
+	}
+	for _, track := range tracker.GetTracks() {
+		if len(track.Points) != 1 {
+			continue
+		}
+		p := track.Points[0].Vec
+		for _, o := range old {
+			end := o.Points[len(o.Points)-1].Vec
+			if d := math.Hypot(float64(p.X-end.X), float64(p.Y-end.Y)); d < sep {
+				t.Errorf("Reseeded grid feature %v is %.1f px from track %d", p, d, o.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package newcast
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// TestAddImageFrameSize checks that a half-size second frame is rejected
+// with ErrDimensionMismatch, and that under AutoResize it is tracked in the
+// coordinates of the first frame.
+func TestAddImageFrameSize(t *testing.T) {
+	frames := crossAndBlobFrames(2)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	half := gocv.NewMat()
+	defer half.Close()
+	gocv.Resize(frames[1], &half, image.Pt(frames[1].Cols()/2, frames[1].Rows()/2), 0, 0, gocv.InterpolationArea)
+	ts := time.Date(2025, 10, 4, 9, 0, 0, 0, time.UTC)
+
+	run := func(opts TrackerOptions, second gocv.Mat) (*Tracker, error) {
+		t.Helper()
+		tracker, err := NewTrackerWithOptions(20, opts)
+		if err != nil {
+			t.Fatalf("Failed to create tracker: %v", err)
+		}
+		if err := tracker.AddImage(frames[0], ts); err != nil {
+			t.Fatalf("AddImage failed on the first frame: %v", err)
+		}
+		return tracker, tracker.AddImage(second, ts.Add(time.Second))
+	}
+
+	strict, err := run(TrackerOptions{}, half)
+	defer strict.Close()
+	var mismatch *ErrDimensionMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected ErrDimensionMismatch, got %v", err)
+	}
+	if mismatch.Expected != image.Pt(200, 120) || mismatch.Got != image.Pt(100, 60) {
+		t.Errorf("Expected 200x120 and 100x60 in the error, got %v and %v", mismatch.Expected, mismatch.Got)
+	}
+	for _, track := range strict.GetTracks() {
+		if len(track.Points) != 1 {
+			t.Fatalf("Track %d was extended by the rejected frame", track.ID)
+		}
+	}
+
+	want, err := run(TrackerOptions{}, frames[1])
+	if err != nil {
+		t.Fatalf("AddImage failed on the full-size frame: %v", err)
+	}
+	defer want.Close()
+	resized, err := run(TrackerOptions{AutoResize: true}, half)
+	if err != nil {
+		t.Fatalf("AddImage failed on the half-size frame under AutoResize: %v", err)
+	}
+	defer resized.Close()
+
+	ends := make(map[int]gocv.Point2f)
+	for _, track := range want.GetTracks() {
+		ends[track.ID] = track.Points[len(track.Points)-1].Vec
+	}
+	compared := 0
+	for _, track := range resized.GetTracks() {
+		end, ok := ends[track.ID]
+		if !ok || len(track.Points) != 2 {
+			continue
+		}
+		got := track.Points[1].Vec
+		if d := math.Hypot(float64(got.X-end.X), float64(got.Y-end.Y)); d > 1.5 {
+			t.Errorf("Track %d ended at %v, %.2f px from %v in the full-size frame", track.ID, got, d, end)
+		}
+		compared++
+	}
+	if compared == 0 {
+		t.Fatal("Expected tracks followed into the resized frame")
+	}
+}
+
+// TestProcessingScale tracks an 8 pixel shift on frames processed at half
+// size and checks that the tracks and their velocities are in pixels of
+// the full-size frames.
+func TestProcessingScale(t *testing.T) {
+	const dx = 8.0
+	frames := squareFrames(256, 160, 64, 48, []int{40, 40 + dx, 40 + 2*dx})
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	if _, err := NewTrackerWithOptions(20, TrackerOptions{ProcessingScale: 1.5}); err == nil {
+		t.Error("Expected an error for a processing scale above 1")
+	}
+
+	tracker, err := NewTrackerWithOptions(20, TrackerOptions{ProcessingScale: 0.5})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+	ts := time.Date(2025, 10, 4, 9, 0, 0, 0, time.UTC)
+	for i, frame := range frames {
+		if err := tracker.AddImage(frame, ts.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("AddImage failed on frame %d: %v", i, err)
+		}
+	}
+
+	tracked := 0
+	for _, track := range tracker.GetTracks() {
+		if len(track.Points) != len(frames) {
+			continue
+		}
+		// The square starts at (40, 48) and is 64 pixels wide.
+		if start := track.Points[0].Vec; start.X < 38 || start.X > 106 || start.Y < 46 || start.Y > 114 {
+			t.Errorf("Track %d starts at %v, off the square in the full-size frame", track.ID, start)
+		}
+		for i := 1; i < len(track.Points); i++ {
+			d := track.Points[i].Vec
+			d.X -= track.Points[i-1].Vec.X
+			d.Y -= track.Points[i-1].Vec.Y
+			if math.Abs(float64(d.X)-dx) > 0.5 || math.Abs(float64(d.Y)) > 0.5 {
+				t.Errorf("Track %d moved by %v in step %d, want (%v, 0)", track.ID, d, i, dx)
+			}
+		}
+		if v := track.LatestVelocity; math.Abs(float64(v.X)-dx) > 0.5 || math.Abs(float64(v.Y)) > 0.5 {
+			t.Errorf("Track %d has velocity %v, want (%v, 0) px/s", track.ID, v, dx)
+		}
+		tracked++
+	}
+	if tracked == 0 {
+		t.Fatal("Expected tracks followed through every frame")
+	}
+}
+
+// BenchmarkAddImage adds a pair of 512x512 frames to a tracker at full size
+// and at the processing scale of half and a quarter.
+func BenchmarkAddImage(b *testing.B) {
+	frames := lowTextureFrames(2, 512, 8, 4)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	ts := time.Date(2025, 10, 4, 9, 0, 0, 0, time.UTC)
+	for _, scale := range []float64{1, 0.5, 0.25} {
+		b.Run(fmt.Sprintf("scale=%v", scale), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tracker, err := NewTrackerWithOptions(100, TrackerOptions{ProcessingScale: scale})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j, frame := range frames {
+					if err := tracker.AddImage(frame, ts.Add(time.Duration(j)*time.Second)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				tracker.Close()
+			}
+		})
+	}
+}
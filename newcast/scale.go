@@ -0,0 +1,64 @@
+package newcast
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+func (o TrackerOptions) processingScale() float64 {
+	if o.ProcessingScale > 0 {
+		return o.ProcessingScale
+	}
+	return 1
+}
+
+// processingSize returns the size a frame of size full is processed at
+// under TrackerOptions.ProcessingScale, at least one pixel each way.
+func (o TrackerOptions) processingSize(full image.Point) image.Point {
+	s := o.processingScale()
+	return image.Pt(max(1, int(math.Round(float64(full.X)*s))), max(1, int(math.Round(float64(full.Y)*s))))
+}
+
+// toFrame maps points of f.img into the coordinates of the frame it was
+// resized from. Pixel centers map to pixel centers, as gocv.Resize samples
+// them.
+func (f *opencvFrame) toFrame(points []gocv.Point2f) []gocv.Point2f {
+	return rescalePoints(points, f.imgSize(), f.full)
+}
+
+// toImage maps points of the frame into the coordinates of f.img; it is the
+// inverse of toFrame.
+func (f *opencvFrame) toImage(points []gocv.Point2f) []gocv.Point2f {
+	return rescalePoints(points, f.full, f.imgSize())
+}
+
+// frameLK maps the matches of an LK call on f.img into the coordinates of
+// the frame, leaving the zero matches of points not found.
+func (f *opencvFrame) frameLK(lk *LKCall) *LKCall {
+	full := f.toFrame(lk.Next)
+	for i := range lk.Next {
+		if lk.Status[i] == 1 {
+			lk.Next[i] = full[i]
+		}
+	}
+	return lk
+}
+
+func (f *opencvFrame) imgSize() image.Point {
+	return image.Pt(f.img.Cols(), f.img.Rows())
+}
+
+// rescalePoints maps points of an image of size from onto one of size to.
+func rescalePoints(points []gocv.Point2f, from, to image.Point) []gocv.Point2f {
+	if from == to {
+		return points
+	}
+	sx, sy := float32(to.X)/float32(from.X), float32(to.Y)/float32(from.Y)
+	out := make([]gocv.Point2f, len(points))
+	for i, pt := range points {
+		out[i] = gocv.Point2f{X: (pt.X+0.5)*sx - 0.5, Y: (pt.Y+0.5)*sy - 0.5}
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package newcast
+
+import "math"
+
+// DefaultSimplifyTimeScale is the default SimplifyOptions.TimeScale.
+const DefaultSimplifyTimeScale = 1.0
+
+// SimplifyOptions configures SimplifyTrackWithOptions.
+type SimplifyOptions struct {
+	// Epsilon is the largest distance, in pixels, a dropped point may lie
+	// from the simplified space-time polyline. Zero or less disables
+	// simplification.
+	Epsilon float64
+	// TimeScale converts each point's time to a third coordinate, in pixels
+	// per second, when distances are measured on the (t, x, y) polyline.
+	// The larger it is, the closer the distance comes to the spatial offset
+	// at the point's own time, so timing as well as shape is preserved.
+	// Zero or less means DefaultSimplifyTimeScale.
+	TimeScale float64
+}
+
+func (o SimplifyOptions) timeScale() float64 {
+	if o.TimeScale <= 0 {
+		return DefaultSimplifyTimeScale
+	}
+	return o.TimeScale
+}
+
+// SimplifyTrack is SimplifyTrackWithOptions with the default time scale.
+func SimplifyTrack(track *Track, epsilon float64) *Track {
+	return SimplifyTrackWithOptions(track, SimplifyOptions{Epsilon: epsilon})
+}
+
+// SimplifyTrackWithOptions returns a copy of track whose points are pruned
+// with the Ramer–Douglas–Peucker algorithm on its space-time polyline: a
+// point is dropped if it lies within opts.Epsilon of the simplified
+// polyline. The first and last points are always kept, and so is the point
+// nearest the middle in time if that would leave fewer than 3, so the
+// simplified track can still be fitted over its whole span. Tracks of 3
+// points or fewer are copied unchanged. A copy that lost points is refit
+// with RefitTracks and MotionQuadratic. The copy does not carry the track's
+// Kalman filter.
+func SimplifyTrackWithOptions(track *Track, opts SimplifyOptions) *Track {
+	out := *track
+	out.filter = nil
+	n := len(track.Points)
+	if n <= 3 || opts.Epsilon <= 0 {
+		out.Points = append([]Point(nil), track.Points...)
+		return &out
+	}
+
+	coords := spaceTimeCoords(track.Points, opts.timeScale())
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	kept := 2
+	// Split the polyline at its farthest point until every dropped point is
+	// within epsilon, using a stack of spans rather than recursion.
+	spans := [][2]int{{0, n - 1}}
+	for len(spans) > 0 {
+		span := spans[len(spans)-1]
+		spans = spans[:len(spans)-1]
+		i, dist := farthestPoint(coords, span[0], span[1])
+		if i < 0 || dist <= opts.Epsilon {
+			continue
+		}
+		keep[i] = true
+		kept++
+		spans = append(spans, [2]int{span[0], i}, [2]int{i, span[1]})
+	}
+	if kept < 3 {
+		keep[middlePoint(coords)] = true
+	}
+
+	out.Points = make([]Point, 0, kept+1)
+	for i, p := range track.Points {
+		if keep[i] {
+			out.Points = append(out.Points, p)
+		}
+	}
+	if len(out.Points) < n {
+		RefitTracks([]*Track{&out}, MotionQuadratic)
+	}
+	return &out
+}
+
+// spaceTimeCoords returns the (t, x, y) coordinates of points, with t in
+// seconds since the first point times timeScale.
+func spaceTimeCoords(points []Point, timeScale float64) [][3]float64 {
+	coords := make([][3]float64, len(points))
+	for i, p := range points {
+		coords[i] = [3]float64{
+			p.Time.Sub(points[0].Time).Seconds() * timeScale,
+			float64(p.Vec.X),
+			float64(p.Vec.Y),
+		}
+	}
+	return coords
+}
+
+// middlePoint returns the index of the inner point nearest in time to the
+// middle of coords, which has at least 3 points.
+func middlePoint(coords [][3]float64) int {
+	mid := coords[len(coords)-1][0] / 2
+	best := 1
+	for i := 2; i < len(coords)-1; i++ {
+		if math.Abs(coords[i][0]-mid) < math.Abs(coords[best][0]-mid) {
+			best = i
+		}
+	}
+	return best
+}
+
+// farthestPoint returns the point strictly between first and last farthest
+// from the segment joining them, and its distance. It returns -1 if there is
+// no point between them, or if every one lies on the segment.
+func farthestPoint(coords [][3]float64, first, last int) (int, float64) {
+	best, bestDist := -1, 0.0
+	for i := first + 1; i < last; i++ {
+		if d := segmentDistance(coords[i], coords[first], coords[last]); d > bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best, bestDist
+}
+
+// segmentDistance returns the distance from p to the segment from a to b.
+func segmentDistance(p, a, b [3]float64) float64 {
+	var ab, ap [3]float64
+	var abLen2, dot float64
+	for k := range ab {
+		ab[k] = b[k] - a[k]
+		ap[k] = p[k] - a[k]
+		abLen2 += ab[k] * ab[k]
+		dot += ab[k] * ap[k]
+	}
+	u := 0.0
+	if abLen2 > 0 {
+		u = math.Max(0, math.Min(1, dot/abLen2))
+	}
+	var d2 float64
+	for k := range ab {
+		d := ap[k] - u*ab[k]
+		d2 += d * d
+	}
+	return math.Sqrt(d2)
+}
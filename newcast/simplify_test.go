@@ -0,0 +1,108 @@
+package newcast
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"gocv.io/x/gocv"
+)
+
+// noisyStraightTrack returns a track of n points a minute apart moving at
+// (0.1, 0.05) pixels per second, each offset by up to noise pixels.
+func noisyStraightTrack(n int, noise float64) *Track {
+	rng := rand.New(rand.NewSource(7))
+	t0 := time.Date(2025, 10, 3, 14, 0, 0, 0, time.UTC)
+	track := &Track{ID: 3}
+	for i := 0; i < n; i++ {
+		s := float64(60 * i)
+		track.Points = append(track.Points, Point{
+			Time: t0.Add(time.Duration(i) * time.Minute),
+			Vec: gocv.Point2f{
+				X: float32(20 + 0.1*s + noise*(2*rng.Float64()-1)),
+				Y: float32(40 + 0.05*s + noise*(2*rng.Float64()-1)),
+			},
+		})
+	}
+	return track
+}
+
+func TestSimplifyTrackKeepsVelocity(t *testing.T) {
+	track := noisyStraightTrack(240, 0.3)
+	simple := SimplifyTrack(track, 1)
+	t.Logf("kept %d of %d points", len(simple.Points), len(track.Points))
+	if len(simple.Points) > len(track.Points)/10 {
+		t.Errorf("Expected fewer than a tenth of the points to remain, got %d of %d", len(simple.Points), len(track.Points))
+	}
+	n := len(simple.Points)
+	if simple.Points[0] != track.Points[0] || simple.Points[n-1] != track.Points[len(track.Points)-1] {
+		t.Error("Expected the first and last points to be kept")
+	}
+
+	fullX, fullY, err := FitQuadratic(track.Points)
+	if err != nil {
+		t.Fatalf("FitQuadratic failed: %v", err)
+	}
+	simpleX, simpleY, err := FitQuadratic(simple.Points)
+	if err != nil {
+		t.Fatalf("FitQuadratic of the simplified track failed: %v", err)
+	}
+	end := track.Points[len(track.Points)-1].Time.Sub(track.Points[0].Time).Seconds()
+	for _, s := range []float64{0, end / 2, end} {
+		dvx := math.Abs(simpleX.Velocity(s) - fullX.Velocity(s))
+		dvy := math.Abs(simpleY.Velocity(s) - fullY.Velocity(s))
+		if dvx > 0.005 || dvy > 0.005 {
+			t.Errorf("At %.0fs the refit velocity moved by (%.4f, %.4f) px/s, more than 5%% of the speed", s, dvx, dvy)
+		}
+	}
+	if len(track.Points) != 240 {
+		t.Error("Expected SimplifyTrack to leave the original track alone")
+	}
+}
+
+func TestSimplifyTrackKeepsThreePoints(t *testing.T) {
+	track := noisyStraightTrack(50, 0)
+	if n := len(SimplifyTrack(track, 1).Points); n != 3 {
+		t.Errorf("Expected a straight track to keep 3 points, got %d", n)
+	}
+	if n := len(SimplifyTrack(track, 0).Points); n != 50 {
+		t.Errorf("Expected a zero epsilon to keep every point, got %d", n)
+	}
+	short := noisyStraightTrack(3, 0.3)
+	if n := len(SimplifyTrack(short, 100).Points); n != 3 {
+		t.Errorf("Expected a 3-point track to be kept whole, got %d", n)
+	}
+}
+
+func TestWriteTracksArrowSimplify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracks.arrow")
+	track := noisyStraightTrack(240, 0.3)
+	if err := WriteTracksArrowWithOptions(path, []*Track{track}, ExportOptions{Simplify: SimplifyOptions{Epsilon: 1}}); err != nil {
+		t.Fatalf("WriteTracksArrowWithOptions failed: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := ipc.NewFileReader(file)
+	if err != nil {
+		t.Fatalf("NewFileReader failed: %v", err)
+	}
+	defer r.Close()
+	rows := 0
+	for i := 0; i < r.NumRecords(); i++ {
+		rec, err := r.RecordBatch(i)
+		if err != nil {
+			t.Fatalf("RecordBatch(%d) failed: %v", i, err)
+		}
+		rows += int(rec.NumRows())
+	}
+	if want := len(SimplifyTrack(track, 1).Points); rows != want {
+		t.Errorf("Expected the %d simplified points to be written, got %d", want, rows)
+	}
+}
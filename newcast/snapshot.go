@@ -0,0 +1,113 @@
+package newcast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SnapshotPoint is the state of one active track in a FrameSnapshot.
+type SnapshotPoint struct {
+	TrackID int     `json:"track_id"`
+	X       float32 `json:"x"`
+	Y       float32 `json:"y"`
+	// VX and VY are the finite-difference velocity from the track's previous
+	// point in pixels per second, or zero for a track's first point.
+	VX float32 `json:"vx"`
+	VY float32 `json:"vy"`
+}
+
+// FrameSnapshot is the motion field sampled by the tracker at one frame. It
+// is passed to TrackerOptions.OnFrame.
+type FrameSnapshot struct {
+	Time   time.Time       `json:"time"`
+	Points []SnapshotPoint `json:"points"`
+	// Stats are the frame's statistics as reported by Tracker.Stats. The
+	// first frame has no predecessor, so only its Time is set.
+	Stats FrameStats `json:"stats"`
+}
+
+// emitSnapshot calls the OnFrame hook, if any, with the current tracks.
+func (t *Tracker) emitSnapshot(stats FrameStats) {
+	if t.opts.OnFrame == nil {
+		return
+	}
+	snapshot := FrameSnapshot{
+		Time:   t.lastTime,
+		Points: make([]SnapshotPoint, 0, len(t.tracks)),
+		Stats:  stats,
+	}
+	for _, track := range t.GetAllTracks() {
+		n := len(track.Points)
+		p := SnapshotPoint{TrackID: track.ID, X: track.Points[n-1].Vec.X, Y: track.Points[n-1].Vec.Y}
+		if dt := pointInterval(track.Points, n-1); dt > 0 {
+			p.VX = (track.Points[n-1].Vec.X - track.Points[n-2].Vec.X) / float32(dt)
+			p.VY = (track.Points[n-1].Vec.Y - track.Points[n-2].Vec.Y) / float32(dt)
+		}
+		snapshot.Points = append(snapshot.Points, p)
+	}
+	t.opts.OnFrame(snapshot)
+}
+
+// SnapshotWriter writes frame snapshots as JSON lines, one snapshot per
+// line. Its Write method can be used directly as TrackerOptions.OnFrame.
+type SnapshotWriter struct {
+	bw     *bufio.Writer
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+}
+
+// NewSnapshotWriter writes snapshots to w. Close must be called to flush
+// the buffered output.
+func NewSnapshotWriter(w io.Writer) *SnapshotWriter {
+	bw := bufio.NewWriter(w)
+	return &SnapshotWriter{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+// OpenSnapshotFile opens the JSON lines file at path for appending,
+// creating it if needed.
+func OpenSnapshotFile(path string) (*SnapshotWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	sw := NewSnapshotWriter(f)
+	sw.closer = f
+	return sw, nil
+}
+
+// Write appends snapshot. Because the tracker hook cannot return an error,
+// the first error is kept and reported by Err and Close; later snapshots are
+// dropped.
+func (sw *SnapshotWriter) Write(snapshot FrameSnapshot) {
+	if sw.err != nil {
+		return
+	}
+	if err := sw.enc.Encode(snapshot); err != nil {
+		sw.err = fmt.Errorf("failed to write snapshot: %w", err)
+	}
+}
+
+// Err returns the first error encountered while writing, if any.
+func (sw *SnapshotWriter) Err() error {
+	return sw.err
+}
+
+// Close flushes the buffered snapshots and closes the file opened by
+// OpenSnapshotFile. It returns the first error encountered.
+func (sw *SnapshotWriter) Close() error {
+	if err := sw.bw.Flush(); err != nil && sw.err == nil {
+		sw.err = fmt.Errorf("failed to flush snapshots: %w", err)
+	}
+	if sw.closer != nil {
+		if err := sw.closer.Close(); err != nil && sw.err == nil {
+			sw.err = fmt.Errorf("failed to close snapshot file: %w", err)
+		}
+		sw.closer = nil
+	}
+	return sw.err
+}
@@ -0,0 +1,94 @@
+package newcast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOnFrameSnapshots(t *testing.T) {
+	frames := lowTextureFrames(3, 128, 2, 1)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	var tracker *Tracker
+	var snapshots []FrameSnapshot
+	var activeCounts []int
+	var buf bytes.Buffer
+	writer := NewSnapshotWriter(&buf)
+	tracker, err := NewTrackerWithOptions(50, TrackerOptions{OnFrame: func(s FrameSnapshot) {
+		snapshots = append(snapshots, s)
+		activeCounts = append(activeCounts, len(tracker.GetTracks()))
+		writer.Write(s)
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	defer tracker.Close()
+
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("Failed to add frame %d: %v", i, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot writer: %v", err)
+	}
+
+	if len(snapshots) != len(frames) {
+		t.Fatalf("Expected %d snapshots, got %d", len(frames), len(snapshots))
+	}
+	stats := tracker.Stats()
+	for i, s := range snapshots {
+		if want := ts.Add(time.Duration(i) * time.Minute); !s.Time.Equal(want) {
+			t.Errorf("Snapshot %d: time %v, want %v", i, s.Time, want)
+		}
+		if len(s.Points) != activeCounts[i] {
+			t.Errorf("Snapshot %d: %d points, but %d tracks were active", i, len(s.Points), activeCounts[i])
+		}
+		if i == 0 {
+			for _, p := range s.Points {
+				if p.VX != 0 || p.VY != 0 {
+					t.Errorf("Snapshot 0: track %d has velocity (%v, %v) on its first point", p.TrackID, p.VX, p.VY)
+				}
+			}
+			continue
+		}
+		if s.Stats != stats[i-1] {
+			t.Errorf("Snapshot %d: stats %+v, want %+v", i, s.Stats, stats[i-1])
+		}
+		if len(s.Points) != s.Stats.Tracked+s.Stats.Reseeded {
+			t.Errorf("Snapshot %d: %d points, stats report %d tracked and %d reseeded", i, len(s.Points), s.Stats.Tracked, s.Stats.Reseeded)
+		}
+	}
+
+	// The last frame's velocities are the per-minute displacement in pixels
+	// per second.
+	for _, p := range snapshots[2].Points {
+		if p.VX < 1.0/60 || p.VX > 3.0/60 {
+			t.Errorf("Track %d: vx = %v px/s, expected about %v", p.TrackID, p.VX, 2.0/60)
+		}
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	lines := 0
+	for scanner.Scan() {
+		var decoded FrameSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &decoded); err != nil {
+			t.Fatalf("Line %d is not a valid snapshot: %v", lines, err)
+		}
+		if len(decoded.Points) != len(snapshots[lines].Points) {
+			t.Errorf("Line %d: %d points, want %d", lines, len(decoded.Points), len(snapshots[lines].Points))
+		}
+		lines++
+	}
+	if lines != len(frames) {
+		t.Errorf("Expected %d JSON lines, got %d", len(frames), lines)
+	}
+}
@@ -0,0 +1,255 @@
+package newcast
+
+import (
+	"example/goflow/flow"
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// Corner quality levels of the tracker's GoodFeaturesToTrack calls,
+// relative to the strongest corner of the frame: that of every detection,
+// and that of the retry under TrackerOptions.RetryDetection.
+const (
+	qualityLevel      = 0.01
+	retryQualityLevel = qualityLevel / 10
+)
+
+// frameSource does the image processing of one tracker step: everything
+// that looks at pixels, as opposed to the bookkeeping of the tracks. The
+// live source runs OpenCV on the frames; a replay reads the results from a
+// recording.
+type frameSource interface {
+	// size returns the size of the new frame.
+	size() image.Point
+	// detect returns up to maxCorners features of the new frame at least
+	// minDistance apart, strongest first. relaxed lowers the detection
+	// thresholds for TrackerOptions.RetryDetection.
+	detect(maxCorners int, minDistance float64, relaxed bool) []gocv.Point2f
+	// track follows prev from the previous frame into the new one.
+	track(prev []gocv.Point2f) *LKCall
+	// retrack follows prev again, starting each search at its guess, for
+	// TrackerOptions.GroupMotionRescue.
+	retrack(prev, guesses []gocv.Point2f) *LKCall
+	// advance makes the new frame the previous one.
+	advance()
+	// candidates returns the features of the newest frame a reseed may
+	// start, strongest first: those at least sep from every track endpoint
+	// and, under TrackerOptions.StaticSuppression, on active pixels.
+	candidates(sep float64) []gocv.Point2f
+}
+
+// opencvFrame is the live frameSource for img, a frame of size full
+// resized to TrackerOptions.ProcessingScale. It takes and returns points in
+// the coordinates of the frame.
+type opencvFrame struct {
+	t    *Tracker
+	img  gocv.Mat
+	full image.Point
+}
+
+func (f *opencvFrame) size() image.Point {
+	return f.full
+}
+
+func (f *opencvFrame) detect(maxCorners int, minDistance float64, relaxed bool) []gocv.Point2f {
+	opts := f.t.opts
+	if opts.Seeding == flow.SeedGrid {
+		minVariance := opts.MinVariance
+		if relaxed {
+			minVariance = 0
+		}
+		return f.toFrame(flow.GridFeatures(f.img, opts.scaledGridSpacing(), maxCorners, minVariance))
+	}
+	quality := qualityLevel
+	if relaxed {
+		quality = retryQualityLevel
+	}
+	points := gocv.NewMat()
+	defer points.Close()
+	gocv.GoodFeaturesToTrack(f.img, &points, maxCorners, quality, minDistance*opts.processingScale())
+	return f.toFrame(readPoints(points))
+}
+
+func (f *opencvFrame) track(prev []gocv.Point2f) *LKCall {
+	// CalcOpticalFlowPyrLK rejects an empty point set, which there is when
+	// StaticSuppression has seeded nothing yet or every track was lost.
+	if len(prev) == 0 {
+		return &LKCall{}
+	}
+	prevPoints := pointsMat(f.toImage(prev))
+	defer prevPoints.Close()
+	nextPoints := gocv.NewMat()
+	defer nextPoints.Close()
+	status := gocv.NewMat()
+	defer status.Close()
+	errMat := gocv.NewMat()
+	defer errMat.Close()
+
+	gocv.CalcOpticalFlowPyrLK(f.t.prevImg, f.img, prevPoints, nextPoints, &status, &errMat)
+	return f.frameLK(readLK(prev, nil, nextPoints, status, errMat))
+}
+
+func (f *opencvFrame) retrack(prev, guesses []gocv.Point2f) *LKCall {
+	prevPoints := pointsMat(f.toImage(prev))
+	defer prevPoints.Close()
+	nextPoints := pointsMat(f.toImage(guesses))
+	defer nextPoints.Close()
+	status := gocv.NewMat()
+	defer status.Close()
+	errMat := gocv.NewMat()
+	defer errMat.Close()
+
+	criteria := gocv.NewTermCriteria(gocv.Count+gocv.EPS, 30, 0.01)
+	gocv.CalcOpticalFlowPyrLKWithParams(f.t.prevImg, f.img, prevPoints, nextPoints, &status, &errMat,
+		image.Pt(21, 21), 1, criteria, gocv.OptflowUseInitialFlow, 1e-4)
+	return f.frameLK(readLK(prev, guesses, nextPoints, status, errMat))
+}
+
+func (f *opencvFrame) advance() {
+	t := f.t
+	if t.opts.StaticSuppression && !t.prevImg.Empty() {
+		t.updateActivity(t.prevImg, f.img)
+	}
+	t.prevImg.Close()
+	t.prevImg = f.img.Clone()
+}
+
+func (f *opencvFrame) candidates(sep float64) []gocv.Point2f {
+	t := f.t
+	// The masks and detection work on the processed image, in whose
+	// pixels sep is shorter.
+	sep *= t.opts.processingScale()
+	occupancy := t.occupancyMask(sep, f.toImage)
+	defer occupancy.Close()
+	var active gocv.Mat
+	if t.opts.StaticSuppression {
+		if t.activity.Empty() {
+			return nil
+		}
+		active = t.activeMask()
+		defer active.Close()
+	}
+
+	var candidates []gocv.Point2f
+	for _, pt := range t.unmaskedCandidates(sep) {
+		x, y := int(math.Round(float64(pt.X))), int(math.Round(float64(pt.Y)))
+		inside := x >= 0 && y >= 0 && x < occupancy.Cols() && y < occupancy.Rows()
+		if inside && occupancy.GetUCharAt(y, x) == 0 {
+			continue
+		}
+		if t.opts.StaticSuppression && (!inside || active.GetUCharAt(y, x) == 0) {
+			continue
+		}
+		candidates = append(candidates, pt)
+	}
+	return f.toFrame(candidates)
+}
+
+// unmaskedCandidates returns the features of the newest frame, strongest
+// first, before the masks of candidates apply, in the coordinates of the
+// processed image. gocv does not expose GoodFeaturesToTrack's mask
+// argument, so it detects without a limit.
+func (t *Tracker) unmaskedCandidates(sep float64) []gocv.Point2f {
+	if t.opts.Seeding == flow.SeedGrid {
+		return flow.GridFeatures(t.prevImg, t.opts.scaledGridSpacing(), 0, t.opts.MinVariance)
+	}
+	corners := gocv.NewMat()
+	defer corners.Close()
+	gocv.GoodFeaturesToTrack(t.prevImg, &corners, 0, qualityLevel, sep)
+	return readPoints(corners)
+}
+
+// occupancyMask returns a mask the size of the newest processed image that
+// is zero within sep pixels of any current track endpoint, mapped onto the
+// image by toImage, and 255 elsewhere.
+func (t *Tracker) occupancyMask(sep float64, toImage func([]gocv.Point2f) []gocv.Point2f) gocv.Mat {
+	mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(255, 0, 0, 0), t.prevImg.Rows(), t.prevImg.Cols(), gocv.MatTypeCV8U)
+	radius := int(math.Ceil(sep))
+	ends := make([]gocv.Point2f, len(t.tracks))
+	for i, track := range t.tracks {
+		ends[i] = track.Points[len(track.Points)-1].Vec
+	}
+	for _, end := range toImage(ends) {
+		center := image.Pt(int(math.Round(float64(end.X))), int(math.Round(float64(end.Y))))
+		gocv.Circle(&mask, center, radius, color.RGBA{}, -1)
+	}
+	return mask
+}
+
+// pointsMat returns points as an Nx2 CV32F matrix, or an empty matrix if
+// there are none.
+func pointsMat(points []gocv.Point2f) gocv.Mat {
+	if len(points) == 0 {
+		return gocv.NewMat()
+	}
+	mat := gocv.NewMatWithSize(len(points), 2, gocv.MatTypeCV32F)
+	for i, pt := range points {
+		mat.SetFloatAt(i, 0, pt.X)
+		mat.SetFloatAt(i, 1, pt.Y)
+	}
+	return mat
+}
+
+// readPoints returns the rows of a point matrix.
+func readPoints(points gocv.Mat) []gocv.Point2f {
+	out := make([]gocv.Point2f, points.Rows())
+	for i := range out {
+		out[i] = pointAt(points, i)
+	}
+	return out
+}
+
+// readLK collects the inputs and outputs of an LK call. Points that were not
+// found get a zero match and error, since LK leaves them undefined. Points
+// found at a non-finite position, as LK reports on degenerate pyramids, are
+// counted and treated as not found.
+func readLK(prev, guesses []gocv.Point2f, nextPoints, status, errMat gocv.Mat) *LKCall {
+	lk := &LKCall{
+		Prev:   prev,
+		Guess:  guesses,
+		Next:   make([]gocv.Point2f, status.Rows()),
+		Status: make([]int, status.Rows()),
+		Err:    make([]float32, status.Rows()),
+	}
+	for i := range lk.Status {
+		lk.Status[i] = int(status.GetUCharAt(i, 0))
+		if lk.Status[i] != 1 {
+			continue
+		}
+		pt := pointAt(nextPoints, i)
+		if !finitePoint(pt) {
+			lk.Status[i] = 0
+			lk.NonFinite++
+			continue
+		}
+		lk.Next[i] = pt
+		if i < errMat.Rows() {
+			lk.Err[i] = errMat.GetFloatAt(i, 0)
+		}
+	}
+	return lk
+}
+
+// finitePoint reports whether both coordinates of pt are neither NaN nor
+// infinite.
+func finitePoint(pt gocv.Point2f) bool {
+	return finite(pt.X) && finite(pt.Y)
+}
+
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float32) bool {
+	return !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+}
+
+// pointAt returns row i of a point matrix, which holds either one
+// two-channel element or two single-channel elements per row.
+func pointAt(points gocv.Mat, i int) gocv.Point2f {
+	if points.Channels() == 2 {
+		ptVec := points.GetVecfAt(i, 0)
+		return gocv.Point2f{X: ptVec[0], Y: ptVec[1]}
+	}
+	return gocv.Point2f{X: points.GetFloatAt(i, 0), Y: points.GetFloatAt(i, 1)}
+}
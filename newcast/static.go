@@ -0,0 +1,79 @@
+package newcast
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// DefaultActivitySmoothing is the default TrackerOptions.ActivitySmoothing.
+	DefaultActivitySmoothing = 0.2
+	// DefaultMinActivity is the default TrackerOptions.MinActivity.
+	DefaultMinActivity = 4.0
+)
+
+// activityRadius is how far, in pixels, a candidate feature may be from an
+// active pixel. Corners sit on the edge of moving structure, where the
+// frame difference can fall a pixel or two to either side.
+const activityRadius = 3
+
+func (o TrackerOptions) activitySmoothing() float64 {
+	if o.ActivitySmoothing > 0 && o.ActivitySmoothing <= 1 {
+		return o.ActivitySmoothing
+	}
+	return DefaultActivitySmoothing
+}
+
+func (o TrackerOptions) minActivity() float64 {
+	if o.MinActivity > 0 {
+		return o.MinActivity
+	}
+	return DefaultMinActivity
+}
+
+// updateActivity folds the absolute difference between two consecutive
+// frames into the activity map.
+func (t *Tracker) updateActivity(prev, next gocv.Mat) {
+	absDiff := gocv.NewMat()
+	defer absDiff.Close()
+	gocv.AbsDiff(prev, next, &absDiff)
+	diff := gocv.NewMat()
+	defer diff.Close()
+	absDiff.ConvertTo(&diff, gocv.MatTypeCV32F)
+
+	if t.activity.Empty() {
+		t.activity.Close()
+		t.activity = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), next.Rows(), next.Cols(), gocv.MatTypeCV32F)
+	}
+	alpha := t.opts.activitySmoothing()
+	gocv.AddWeighted(t.activity, 1-alpha, diff, alpha, 0, &t.activity)
+}
+
+// activeMask returns a mask the size of the activity map that is 255 within
+// activityRadius of any pixel whose activity exceeds
+// TrackerOptions.MinActivity and zero elsewhere.
+func (t *Tracker) activeMask() gocv.Mat {
+	above := gocv.NewMat()
+	defer above.Close()
+	gocv.Threshold(t.activity, &above, float32(t.opts.minActivity()), 255, gocv.ThresholdBinary)
+	binary := gocv.NewMat()
+	defer binary.Close()
+	above.ConvertTo(&binary, gocv.MatTypeCV8U)
+
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Pt(2*activityRadius+1, 2*activityRadius+1))
+	defer kernel.Close()
+	mask := gocv.NewMat()
+	gocv.Dilate(binary, &mask, kernel)
+	return mask
+}
+
+// ActivityMap returns a copy of the static-suppression activity map: the
+// exponentially smoothed absolute difference between consecutive frames, as
+// a single-channel float matrix the size of the frames as processed under
+// TrackerOptions.ProcessingScale. It is empty unless
+// TrackerOptions.StaticSuppression is set and at least two frames have been
+// added. The caller must close it.
+func (t *Tracker) ActivityMap() gocv.Mat {
+	return t.activity.Clone()
+}
@@ -0,0 +1,117 @@
+package newcast
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	blobSize  = 16
+	blobY     = 50
+	blobSpeed = 3 // pixels per frame
+)
+
+// blobX returns the left edge of the moving blob in frame i.
+func blobX(i int) int {
+	return 110 + blobSpeed*i
+}
+
+// crossAndBlobFrames returns frames with a static bright cross on the left
+// and a bright square blob moving right.
+func crossAndBlobFrames(n int) []gocv.Mat {
+	frames := make([]gocv.Mat, n)
+	for i := range frames {
+		mat := gocv.NewMatWithSize(120, 200, gocv.MatTypeCV8U)
+		fill := func(r image.Rectangle, v uint8) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					mat.SetUCharAt(y, x, v)
+				}
+			}
+		}
+		fill(image.Rect(30, 56, 70, 64), 255)
+		fill(image.Rect(46, 40, 54, 80), 255)
+		fill(image.Rect(blobX(i), blobY, blobX(i)+blobSize, blobY+blobSize), 200)
+		frames[i] = mat
+	}
+	return frames
+}
+
+// trackStart is where and in which frame a track was created.
+type trackStart struct {
+	Frame int
+	Pt    gocv.Point2f
+}
+
+// createdTracks runs a tracker with opts over frames, one second apart, and
+// returns the start of every track it created. The caller must close the
+// tracker.
+func createdTracks(t *testing.T, frames []gocv.Mat, opts TrackerOptions) ([]trackStart, *Tracker) {
+	t.Helper()
+	ts := time.Date(2025, 10, 4, 9, 0, 0, 0, time.UTC)
+	var starts []trackStart
+	opts.OnEvent = func(e TrackEvent) {
+		if e.Kind == TrackCreated {
+			starts = append(starts, trackStart{Frame: int(e.Time.Sub(ts) / time.Second), Pt: e.Track.Points[0].Vec})
+		}
+	}
+	tracker, err := NewTrackerWithOptions(20, opts)
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	for i, f := range frames {
+		if err := tracker.AddImage(f, ts.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("AddImage failed on frame %d: %v", i, err)
+		}
+	}
+	return starts, tracker
+}
+
+func TestStaticSuppression(t *testing.T) {
+	frames := crossAndBlobFrames(6)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	// Without suppression the cross's corners attract features.
+	plain, tracker := createdTracks(t, frames, TrackerOptions{})
+	tracker.Close()
+	crossTracks := 0
+	for _, s := range plain {
+		if s.Pt.X < 80 {
+			crossTracks++
+		}
+	}
+	if crossTracks == 0 {
+		t.Fatal("Expected tracks on the static cross without suppression")
+	}
+
+	suppressed, tracker := createdTracks(t, frames, TrackerOptions{StaticSuppression: true})
+	defer tracker.Close()
+	if len(suppressed) == 0 {
+		t.Fatal("Expected tracks on the moving blob")
+	}
+	for _, s := range suppressed {
+		blob := image.Rect(blobX(s.Frame), blobY, blobX(s.Frame)+blobSize, blobY+blobSize).Inset(-activityRadius - 1)
+		if !(image.Point{X: int(s.Pt.X), Y: int(s.Pt.Y)}).In(blob) {
+			t.Errorf("Track started off the blob at %v in frame %d", s.Pt, s.Frame)
+		}
+	}
+
+	activity := tracker.ActivityMap()
+	defer activity.Close()
+	if activity.Rows() != 120 || activity.Cols() != 200 {
+		t.Fatalf("Expected a 200x120 activity map, got %dx%d", activity.Cols(), activity.Rows())
+	}
+	if a := activity.GetFloatAt(60, 50); a != 0 {
+		t.Errorf("Expected no activity on the static cross, got %v", a)
+	}
+	if a := activity.GetFloatAt(blobY+blobSize/2, blobX(5)+blobSize-1); a <= DefaultMinActivity {
+		t.Errorf("Expected activity at the blob's leading edge, got %v", a)
+	}
+}
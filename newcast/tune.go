@@ -0,0 +1,108 @@
+package newcast
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// errNonFiniteFit is returned by Track.Refit when the fitted motion is NaN
+// or infinite.
+var errNonFiniteFit = errors.New("quadratic fit of the track is not finite")
+
+// Refit fits the track's quadratic motion to all its points, as the tracker
+// does, and sets its polynomials, residuals, and its velocity and
+// acceleration at the last point. It returns an error, leaving the track
+// unchanged, if the track has fewer than 3 points or the fit is not finite.
+func (tr *Track) Refit() error {
+	polyX, polyY, err := FitQuadratic(tr.Points)
+	if err != nil {
+		return err
+	}
+	lastT := tr.Points[len(tr.Points)-1].Time.Sub(tr.Points[0].Time).Seconds()
+	velocity := gocv.Point2f{X: float32(polyX.Velocity(lastT)), Y: float32(polyY.Velocity(lastT))}
+	acceleration := gocv.Point2f{X: float32(polyX.Acceleration()), Y: float32(polyY.Acceleration())}
+	if !finitePoint(velocity) || !finitePoint(acceleration) {
+		return errNonFiniteFit
+	}
+	tr.PolyX, tr.PolyY = polyX, polyY
+	tr.ResidualX, tr.ResidualY = ResidualRMS(tr.Points, polyX, polyY)
+	tr.LatestVelocity, tr.LatestAcceleration = velocity, acceleration
+	return nil
+}
+
+// TruncateTrack returns a copy of track without its last drop points, refit
+// with Refit. The copy does not carry the track's Kalman filter. It returns
+// the error of Refit, and no copy, if fewer than 3 points remain or their
+// fit is not finite, rather than a copy still moving as the whole track.
+func TruncateTrack(track *Track, drop int) (*Track, error) {
+	out := *track
+	out.filter = nil
+	keep := max(0, len(track.Points)-max(0, drop))
+	out.Points = append([]Point(nil), track.Points[:keep]...)
+	if err := out.Refit(); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// positionAt evaluates the track's fitted polynomials at time ts.
+func (tr *Track) positionAt(ts time.Time) gocv.Point2f {
+	t := ts.Sub(tr.Points[0].Time).Seconds()
+	return gocv.Point2f{X: float32(tr.PolyX.Eval(t)), Y: float32(tr.PolyY.Eval(t))}
+}
+
+// TuneFilters chooses the filter chain whose tracks predict best. Every
+// track with at least holdoutFrames+3 points is truncated by its last
+// holdoutFrames points and refit; each candidate chain is applied to the
+// truncated tracks, and the tracks it keeps are extrapolated to the held-out
+// times. A chain's score is the median distance in pixels between those
+// predictions and the held-out positions, and +Inf if it keeps no track.
+//
+// TuneFilters returns the candidate with the lowest score, the first on a
+// tie, and the scores in the order of candidates. If holdoutFrames is not
+// positive, or no candidate keeps a track, best is nil.
+func TuneFilters(tracks []*Track, holdoutFrames int, candidates []FilterChain) (best FilterChain, scores []float64) {
+	scores = make([]float64, len(candidates))
+	if holdoutFrames <= 0 {
+		for i := range scores {
+			scores[i] = math.Inf(1)
+		}
+		return nil, scores
+	}
+
+	var truncated []*Track
+	heldOut := make(map[*Track][]Point)
+	for _, track := range tracks {
+		if len(track.Points) < holdoutFrames+3 {
+			continue
+		}
+		short, err := TruncateTrack(track, holdoutFrames)
+		if err != nil {
+			continue
+		}
+		truncated = append(truncated, short)
+		heldOut[short] = track.Points[len(track.Points)-holdoutFrames:]
+	}
+
+	bestScore := math.Inf(1)
+	for i, chain := range candidates {
+		var errs []float64
+		for _, track := range chain.Apply(truncated) {
+			for _, p := range heldOut[track] {
+				predicted := track.positionAt(p.Time)
+				errs = append(errs, math.Hypot(float64(predicted.X-p.Vec.X), float64(predicted.Y-p.Vec.Y)))
+			}
+		}
+		scores[i] = math.Inf(1)
+		if len(errs) > 0 {
+			scores[i] = median(errs)
+		}
+		if scores[i] < bestScore {
+			best, bestScore = chain, scores[i]
+		}
+	}
+	return best, scores
+}
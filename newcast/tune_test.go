@@ -0,0 +1,88 @@
+package newcast
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// mixedTracks returns smooth tracks moving right at 4 pixels a frame, with
+// IDs from 0, and noisy ones whose positions jump by up to 8 pixels, with
+// IDs from 100.
+func mixedTracks(smooth, noisy int) []*Track {
+	rng := rand.New(rand.NewSource(11))
+	ts := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	newTrack := func(id int, jitter float32) *Track {
+		x, y := rng.Float32()*200, rng.Float32()*200
+		track := &Track{ID: id}
+		for j := 0; j < 12; j++ {
+			track.Points = append(track.Points, Point{
+				Time: ts.Add(time.Duration(j) * time.Minute),
+				Vec: gocv.Point2f{
+					X: x + 4*float32(j) + jitter*(rng.Float32()-0.5),
+					Y: y + jitter*(rng.Float32()-0.5),
+				},
+			})
+		}
+		return track
+	}
+	var tracks []*Track
+	for i := 0; i < smooth; i++ {
+		tracks = append(tracks, newTrack(i, 0.2))
+	}
+	for i := 0; i < noisy; i++ {
+		tracks = append(tracks, newTrack(100+i, 16))
+	}
+	return tracks
+}
+
+func TestTuneFiltersPrefersRemovingNoise(t *testing.T) {
+	tracks := mixedTracks(30, 15)
+	strict := FilterChain{SmoothnessFilter{MaxAverageAngleChange: 0.3}}
+	for _, track := range strict.Apply(tracks) {
+		if track.ID >= 100 {
+			t.Fatalf("Test data should let the strict chain remove the noisy tracks, it kept %d", track.ID)
+		}
+	}
+
+	candidates := []FilterChain{
+		{},
+		{SmoothnessFilter{MaxAverageAngleChange: math.Pi}},
+		strict,
+		{MinLengthFilter{MinPoints: 100}},
+	}
+	best, scores := TuneFilters(tracks, 3, candidates)
+	t.Logf("scores: %v", scores)
+	if len(scores) != len(candidates) {
+		t.Fatalf("Expected %d scores, got %d", len(candidates), len(scores))
+	}
+	if len(best) != 1 || best[0] != strict[0] {
+		t.Errorf("Expected the strict smoothness chain to win, got %v", best)
+	}
+	if !(scores[2] < scores[0] && scores[2] < scores[1]) {
+		t.Errorf("Expected removing the noisy tracks to lower the error, got %v", scores)
+	}
+	if !math.IsInf(scores[3], 1) {
+		t.Errorf("Expected +Inf for a chain that keeps no track, got %v", scores[3])
+	}
+	if len(tracks[0].Points) != 12 {
+		t.Error("Expected TuneFilters to leave the tracks alone")
+	}
+
+	short, err := TruncateTrack(tracks[0], 3)
+	if err != nil {
+		t.Fatalf("TruncateTrack failed: %v", err)
+	}
+	if len(short.Points) != 9 || short.PolyX == tracks[0].PolyX {
+		t.Errorf("Expected a refit copy of 9 points, got %d points and fit %v", len(short.Points), short.PolyX)
+	}
+	if v := short.LatestVelocity.X * 60; math.Abs(float64(v)-4) > 0.2 {
+		t.Errorf("Expected the refit to move about 4 pixels a minute, got %.2f", v)
+	}
+	if short, err := TruncateTrack(tracks[0], 10); err == nil {
+		t.Errorf("Expected an error for 2 remaining points, got a copy moving at %v", short.LatestVelocity)
+	}
+}
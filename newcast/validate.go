@@ -0,0 +1,137 @@
+package newcast
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// flippedStep is how far, in pixels, a step's motion must point against
+	// the cumulative motion of the sequence for ValidateSequence to flag it.
+	// It keeps the jitter of a near-stationary step from counting.
+	flippedStep = 0.5
+	// maxBackwardFraction is the share of the sequence's motion that may
+	// point against its cumulative direction before ValidateSequence warns.
+	maxBackwardFraction = 0.15
+)
+
+// SequenceDiagnostic describes how well the content of a frame sequence
+// agrees with the order of its timestamps.
+type SequenceDiagnostic struct {
+	// Shifts holds the global shift, in pixels, from each frame to the
+	// next: Shifts[i] is the motion from frame i to frame i+1.
+	Shifts []gocv.Point2f
+	// Flipped lists the steps i whose shift points against the cumulative
+	// motion of the sequence.
+	Flipped []int
+	// BackwardFraction is the share of the motion along the cumulative
+	// direction that points against it: 0 for a sequence moving steadily
+	// one way, approaching 0.5 for one whose steps cancel out.
+	BackwardFraction float64
+	// Warnings describes each problem found. It is empty for a sequence
+	// that looks consistent.
+	Warnings []string
+}
+
+// Suspicious reports whether ValidateSequence raised any warning.
+func (d SequenceDiagnostic) Suspicious() bool {
+	return len(d.Warnings) > 0
+}
+
+// ValidateSequence checks that a frame sequence is in the order its
+// timestamps claim before it is given to a Tracker. It warns about
+// timestamps that do not strictly increase, and about sequences whose
+// frame-to-frame motion, estimated by phase correlation of the whole frames,
+// systematically flips against the cumulative motion, as it does when some
+// frames are out of order. A sequence reversed as a whole moves
+// consistently, just the other way, and cannot be told from a correct one
+// by its content.
+//
+// Problems with the ordering are reported in the diagnostic, not as an
+// error; the error is for input that cannot be checked: mismatched lengths,
+// fewer than two frames, an empty frame or frames of different sizes.
+func ValidateSequence(images []gocv.Mat, timestamps []time.Time) (SequenceDiagnostic, error) {
+	var diag SequenceDiagnostic
+	if len(images) != len(timestamps) {
+		return diag, fmt.Errorf("newcast: %d images but %d timestamps", len(images), len(timestamps))
+	}
+	if len(images) < 2 {
+		return diag, errors.New("newcast: at least two frames are needed to validate a sequence")
+	}
+
+	for i := 1; i < len(timestamps); i++ {
+		if !timestamps[i].After(timestamps[i-1]) {
+			diag.Warnings = append(diag.Warnings, fmt.Sprintf("timestamp of frame %d (%s) is not after that of frame %d (%s)",
+				i, timestamps[i].Format(time.RFC3339Nano), i-1, timestamps[i-1].Format(time.RFC3339Nano)))
+		}
+	}
+
+	size := image.Pt(images[0].Cols(), images[0].Rows())
+	frames := make([]gocv.Mat, len(images))
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	for i, img := range images {
+		frames[i] = gocv.NewMat()
+		if img.Empty() {
+			return diag, fmt.Errorf("newcast: frame %d is empty", i)
+		}
+		if got := image.Pt(img.Cols(), img.Rows()); got != size {
+			return diag, fmt.Errorf("newcast: frame %d: %w", i, &ErrDimensionMismatch{Expected: size, Got: got})
+		}
+		correlationPlane(img, &frames[i])
+	}
+
+	window := gocv.NewMat()
+	defer window.Close()
+	var cumX, cumY float64
+	diag.Shifts = make([]gocv.Point2f, len(frames)-1)
+	for i := range diag.Shifts {
+		diag.Shifts[i], _ = gocv.PhaseCorrelate(frames[i], frames[i+1], window)
+		cumX += float64(diag.Shifts[i].X)
+		cumY += float64(diag.Shifts[i].Y)
+	}
+
+	norm := math.Hypot(cumX, cumY)
+	if norm == 0 {
+		return diag, nil
+	}
+	var backward, total float64
+	for i, s := range diag.Shifts {
+		along := (float64(s.X)*cumX + float64(s.Y)*cumY) / norm
+		total += math.Abs(along)
+		if along < 0 {
+			backward -= along
+		}
+		if along < -flippedStep {
+			diag.Flipped = append(diag.Flipped, i)
+		}
+	}
+	diag.BackwardFraction = backward / total
+	if len(diag.Flipped) > 0 && diag.BackwardFraction >= maxBackwardFraction {
+		diag.Warnings = append(diag.Warnings, fmt.Sprintf("motion of steps %v runs against the sequence's overall motion (%.0f%% of it backward); frames may be out of order",
+			diag.Flipped, 100*diag.BackwardFraction))
+	}
+	return diag, nil
+}
+
+// correlationPlane writes img to dst as the single-channel float matrix
+// phase correlation works on.
+func correlationPlane(img gocv.Mat, dst *gocv.Mat) {
+	switch img.Channels() {
+	case 3:
+		gocv.CvtColor(img, dst, gocv.ColorBGRToGray)
+	case 4:
+		gocv.CvtColor(img, dst, gocv.ColorBGRAToGray)
+	default:
+		img.CopyTo(dst)
+	}
+	dst.ConvertTo(dst, gocv.MatTypeCV32F)
+}
@@ -0,0 +1,80 @@
+package newcast
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+func TestValidateSequence(t *testing.T) {
+	const n, step = 8, 3.0
+	frames := lowTextureFrames(n, 128, step, 0)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+	start := time.Date(2025, 10, 3, 14, 40, 0, 0, time.UTC)
+	timestamps := make([]time.Time, n)
+	for i := range timestamps {
+		timestamps[i] = start.Add(time.Duration(i) * time.Minute)
+	}
+	reorder := func(order []int) []gocv.Mat {
+		mats := make([]gocv.Mat, len(order))
+		for i, j := range order {
+			mats[i] = frames[j]
+		}
+		return mats
+	}
+
+	diag, err := ValidateSequence(frames, timestamps)
+	if err != nil {
+		t.Fatalf("ValidateSequence failed: %v", err)
+	}
+	if diag.Suspicious() {
+		t.Errorf("Expected no warning for frames in order, got %q", diag.Warnings)
+	}
+	for i, s := range diag.Shifts {
+		if s.X < step-1 || s.X > step+1 {
+			t.Errorf("Expected a shift of about (%v, 0) at step %d, got %v", step, i, s)
+		}
+	}
+
+	// The back half of the sequence runs backwards: its frames move left
+	// while the sequence as a whole moves right.
+	diag, err = ValidateSequence(reorder([]int{0, 1, 2, 3, 7, 6, 5, 4}), timestamps)
+	if err != nil {
+		t.Fatalf("ValidateSequence failed: %v", err)
+	}
+	if !diag.Suspicious() {
+		t.Error("Expected a warning for a partly reversed sequence")
+	}
+	if want := []int{4, 5, 6}; !slices.Equal(diag.Flipped, want) {
+		t.Errorf("Expected steps %v flipped, got %v", want, diag.Flipped)
+	}
+
+	reversed := make([]time.Time, n)
+	for i := range reversed {
+		reversed[i] = timestamps[n-1-i]
+	}
+	diag, err = ValidateSequence(frames, reversed)
+	if err != nil {
+		t.Fatalf("ValidateSequence failed: %v", err)
+	}
+	if len(diag.Warnings) != n-1 {
+		t.Errorf("Expected a warning for each of the %d descending timestamps, got %q", n-1, diag.Warnings)
+	}
+
+	small := gocv.NewMatWithSize(64, 64, gocv.MatTypeCV8U)
+	defer small.Close()
+	var mismatch *ErrDimensionMismatch
+	if _, err := ValidateSequence([]gocv.Mat{frames[0], small}, timestamps[:2]); !errors.As(err, &mismatch) {
+		t.Errorf("Expected ErrDimensionMismatch for frames of different sizes, got %v", err)
+	}
+	if _, err := ValidateSequence(frames, timestamps[:2]); err == nil {
+		t.Error("Expected an error for mismatched lengths")
+	}
+}
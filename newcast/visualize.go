@@ -8,23 +8,21 @@ import (
 )
 
 // VisualizeTracks draws the paths of the tracks on a black background.
-// Each track is drawn in a different color.
-func VisualizeTracks(tracks []*Track, width, height int) gocv.Mat {
+// Each track is drawn in its color from colors; nil uses a new
+// ColorAssigner.
+func VisualizeTracks(tracks []*Track, width, height int, colors *ColorAssigner) gocv.Mat {
 	img := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
 	img.SetTo(gocv.NewScalar(0, 0, 0, 0)) // Black background
+	if colors == nil {
+		colors = NewColorAssigner()
+	}
 
-	for i, track := range tracks {
+	for _, track := range tracks {
 		if len(track.Points) < 2 {
 			continue
 		}
 
-		// Assign a color based on the track ID
-		c := color.RGBA{
-			R: uint8((i * 40) % 255),
-			G: uint8((i * 60) % 255),
-			B: uint8((i * 80) % 255),
-			A: 255,
-		}
+		c := colors.Color(track.ID)
 
 		// Draw lines between consecutive points in the track
 		for j := 0; j < len(track.Points)-1; j++ {
@@ -37,23 +35,43 @@ func VisualizeTracks(tracks []*Track, width, height int) gocv.Mat {
 	return img
 }
 
-// VisualizeExtrapolatedTracks draws the actual and extrapolated future paths of tracks.
-func VisualizeExtrapolatedTracks(tracks []*Track, width, height, numFuturePoints int) gocv.Mat {
+// VisualizeExtrapolatedTracks draws the actual and extrapolated future paths
+// of tracks. The actual paths are drawn in their colors from colors, as by
+// VisualizeTracks, and the extrapolated ones in red.
+func VisualizeExtrapolatedTracks(tracks []*Track, width, height, numFuturePoints int, colors *ColorAssigner) gocv.Mat {
+	return visualizeExtrapolatedTracks(tracks, width, height, numFuturePoints, false, colors)
+}
+
+// VisualizeExtrapolatedTracksWithUncertainty is like VisualizeExtrapolatedTracks
+// but also draws a translucent circle of the predicted uncertainty radius
+// around each extrapolated point, so the path widens with lead time.
+func VisualizeExtrapolatedTracksWithUncertainty(tracks []*Track, width, height, numFuturePoints int, colors *ColorAssigner) gocv.Mat {
+	return visualizeExtrapolatedTracks(tracks, width, height, numFuturePoints, true, colors)
+}
+
+// uncertaintyAlpha is the opacity of the uncertainty circles.
+const uncertaintyAlpha = 0.3
+
+func visualizeExtrapolatedTracks(tracks []*Track, width, height, numFuturePoints int, uncertainty bool, colors *ColorAssigner) gocv.Mat {
 	img := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
 	img.SetTo(gocv.NewScalar(0, 0, 0, 0)) // Black background
+	if colors == nil {
+		colors = NewColorAssigner()
+	}
 
-	for i, track := range tracks {
+	// Circles are drawn opaquely onto a separate overlay and blended in at
+	// the end so overlapping circles do not accumulate opacity.
+	overlay := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
+	defer overlay.Close()
+	overlay.SetTo(gocv.NewScalar(0, 0, 0, 0))
+
+	for _, track := range tracks {
 		if len(track.Points) < 2 {
 			continue
 		}
 
 		// --- Draw existing track ---
-		c := color.RGBA{
-			R: uint8((i * 40) % 255),
-			G: uint8((i * 60) % 255),
-			B: uint8((i * 80) % 255),
-			A: 255,
-		}
+		c := colors.Color(track.ID)
 		for j := 0; j < len(track.Points)-1; j++ {
 			p1 := image.Point{int(track.Points[j].Vec.X), int(track.Points[j].Vec.Y)}
 			p2 := image.Point{int(track.Points[j+1].Vec.X), int(track.Points[j+1].Vec.Y)}
@@ -62,40 +80,35 @@ func VisualizeExtrapolatedTracks(tracks []*Track, width, height, numFuturePoints
 
 		// --- Draw extrapolated future path ---
 		if numFuturePoints > 0 && track.PolyX.A != 0 { // Check if polynomial has been fitted
-			t0 := track.Points[0].Time
 			lastPoint := track.Points[len(track.Points)-1]
-			lastT := lastPoint.Time.Sub(t0).Seconds()
-			
-			// Calculate the average time interval between tracked points
-			var avgDt float64
-			if len(track.Points) > 1 {
-				totalDuration := track.Points[len(track.Points)-1].Time.Sub(track.Points[0].Time).Seconds()
-				avgDt = totalDuration / float64(len(track.Points)-1)
-			} else {
-				avgDt = 1.0 // Default if only one point
-			}
-
 			p1 := image.Point{int(lastPoint.Vec.X), int(lastPoint.Vec.Y)}
 
-			for j := 1; j <= numFuturePoints; j++ {
-				futureT := lastT + float64(j)*avgDt
-				futureX := track.PolyX.Eval(futureT)
-				futureY := track.PolyY.Eval(futureT)
-				p2 := image.Point{int(futureX), int(futureY)}
-
+			for _, pred := range track.PredictPositions(numFuturePoints) {
+				p2 := image.Point{int(pred.Vec.X), int(pred.Vec.Y)}
+				if uncertainty && pred.Radius >= 1 {
+					gocv.Circle(&overlay, p2, int(pred.Radius), color.RGBA{R: 255, G: 0, B: 0, A: 255}, -1)
+				}
 				gocv.Line(&img, p1, p2, color.RGBA{R: 255, G: 0, B: 0, A: 255}, 1)
 				p1 = p2
 			}
 		}
 	}
 
+	if uncertainty {
+		gocv.AddWeighted(img, 1, overlay, uncertaintyAlpha, 0, &img)
+	}
+
 	return img
 }
 
-// VisualizeVectors draws the final velocity vectors of the tracks.
-func VisualizeVectors(tracks []*Track, width, height int, scale float32) gocv.Mat {
+// VisualizeVectors draws the final velocity vectors of the tracks, each in
+// its color from colors; nil uses a new ColorAssigner.
+func VisualizeVectors(tracks []*Track, width, height int, scale float32, colors *ColorAssigner) gocv.Mat {
 	img := gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
 	img.SetTo(gocv.NewScalar(0, 0, 0, 0)) // Black background
+	if colors == nil {
+		colors = NewColorAssigner()
+	}
 
 	for _, track := range tracks {
 		if len(track.Points) < 1 {
@@ -111,7 +124,7 @@ func VisualizeVectors(tracks []*Track, width, height int, scale float32) gocv.Ma
 			int(lastPoint.Vec.Y + track.LatestVelocity.Y*scale),
 		}
 
-		gocv.ArrowedLine(&img, p1, p2, color.RGBA{R: 0, G: 255, B: 0, A: 255}, 2)
+		gocv.ArrowedLine(&img, p1, p2, colors.Color(track.ID), 2)
 	}
 
 	return img
@@ -0,0 +1,84 @@
+package nowcast
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// AccelerationLimit bounds the fitted accelerations of grid cells before
+// they are extrapolated. A linear fit over two or three flow fields can
+// give a cell an acceleration that, over a long lead time, moves it
+// thousands of pixels. Each cell's acceleration is scaled down, keeping its
+// direction, so that the displacement it contributes over MaxLead, |a|
+// MaxLead²/2, stays within MaxFraction of the displacement of the velocity
+// alone, |v| MaxLead, and within MaxPixels; a zero bound is not applied.
+type AccelerationLimit struct {
+	// MaxLead is the longest lead time, in frames, the data is
+	// extrapolated to. Zero disables the limit.
+	MaxLead float64
+	// MaxFraction bounds the displacement due to acceleration relative to
+	// that due to velocity. A cell at rest loses its acceleration.
+	MaxFraction float64
+	// MaxPixels bounds the displacement due to acceleration in pixels.
+	MaxPixels float64
+}
+
+func (l AccelerationLimit) validate() error {
+	if !(l.MaxLead >= 0) || !(l.MaxFraction >= 0) || !(l.MaxPixels >= 0) {
+		return fmt.Errorf("acceleration limit must not be negative, got lead %v, fraction %v and pixels %v", l.MaxLead, l.MaxFraction, l.MaxPixels)
+	}
+	return nil
+}
+
+func (l AccelerationLimit) enabled() bool {
+	return l.MaxLead > 0 && (l.MaxFraction > 0 || l.MaxPixels > 0)
+}
+
+// clamp returns v with its acceleration bounded by l, and whether it was
+// scaled down. perFrame converts the acceleration into pixels per frame
+// squared: the time step of the fit.
+func (l AccelerationLimit) clamp(v GridVector, perFrame float64) (GridVector, bool) {
+	if !l.enabled() {
+		return v, false
+	}
+	bound := math.Inf(1)
+	if l.MaxFraction > 0 {
+		bound = l.MaxFraction * math.Hypot(v.Vx, v.Vy) * l.MaxLead
+	}
+	if l.MaxPixels > 0 {
+		bound = min(bound, l.MaxPixels)
+	}
+	// The largest acceleration, in pixels per frame squared, whose
+	// displacement over MaxLead is within bound.
+	maxAccel := 2 * bound / (l.MaxLead * l.MaxLead)
+	accel := math.Hypot(v.Ax, v.Ay) * math.Abs(perFrame)
+	if accel <= maxAccel {
+		return v, false
+	}
+	scale := maxAccel / accel
+	v.Ax *= scale
+	v.Ay *= scale
+	return v, true
+}
+
+// ClampAccelerations returns a copy of e with the accelerations bounded
+// by limit, taking them in pixels per frame squared as a fit with a time
+// step of one gives, and ClampedAccelerations set to the number of cells
+// scaled down. e itself is left unchanged.
+func (e ExtrapolationData) ClampAccelerations(limit AccelerationLimit) (ExtrapolationData, error) {
+	if err := limit.validate(); err != nil {
+		return ExtrapolationData{}, err
+	}
+	data := make(map[image.Point]GridVector, len(e.Data))
+	e.ClampedAccelerations = 0
+	for pt, v := range e.Data {
+		v, clamped := limit.clamp(v, 1)
+		if clamped {
+			e.ClampedAccelerations++
+		}
+		data[pt] = v
+	}
+	e.Data = data
+	return e, nil
+}
@@ -0,0 +1,125 @@
+package nowcast
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// coordinateFields returns advectSize frames holding the x and y coordinate
+// of each pixel's center, so that advecting them gives the departure point
+// of every pixel's trajectory.
+func coordinateFields() (fx, fy [][]float32) {
+	fx, fy = make([][]float32, advectSize), make([][]float32, advectSize)
+	for y := range fx {
+		fx[y], fy[y] = make([]float32, advectSize), make([]float32, advectSize)
+		for x := range fx[y] {
+			fx[y][x], fy[y][x] = float32(x)+0.5, float32(y)+0.5
+		}
+	}
+	return fx, fy
+}
+
+// advectedDisplacement returns the displacement of the contents of pixel
+// (x, y) after lead frames of motion under data, with the accelerations.
+func advectedDisplacement(t *testing.T, data ExtrapolationData, x, y int, lead float64) (dx, dy float64) {
+	t.Helper()
+	fx, fy := coordinateFields()
+	opts := AdvectOptions{Accelerate: true}
+	ax, err := Advect(fx, data, lead, opts)
+	if err != nil {
+		t.Fatalf("Advect failed: %v", err)
+	}
+	ay, err := Advect(fy, data, lead, opts)
+	if err != nil {
+		t.Fatalf("Advect failed: %v", err)
+	}
+	return float64(x) + 0.5 - float64(ax[y][x]), float64(y) + 0.5 - float64(ay[y][x])
+}
+
+// TestClampAccelerations fabricates a cell whose acceleration would carry it
+// hundreds of pixels over the lead time and checks that, once clamped, its
+// advected displacement is bounded by the limit while the other cells move
+// as before.
+func TestClampAccelerations(t *testing.T) {
+	const lead = 20.0
+	const vx, vy = 1.0, 0.5
+	data := motionGrid(func(x, y float64) (float64, float64) { return vx, vy })
+	huge := image.Pt(16, 16)
+	data.Data[huge] = GridVector{Vx: vx, Vy: vy, Ax: 3, Ay: 1.5}
+
+	limit := AccelerationLimit{MaxLead: lead, MaxFraction: 0.25}
+	clamped, err := data.ClampAccelerations(limit)
+	if err != nil {
+		t.Fatalf("ClampAccelerations failed: %v", err)
+	}
+	if clamped.ClampedAccelerations != 1 {
+		t.Errorf("Expected 1 clamped cell, got %d", clamped.ClampedAccelerations)
+	}
+	if data.Data[huge].Ax != 3 {
+		t.Errorf("Expected the input to be left unchanged, got Ax=%v", data.Data[huge].Ax)
+	}
+	for pt, v := range data.Data {
+		if pt != huge && clamped.Data[pt] != v {
+			t.Errorf("Cell %v changed from %+v to %+v", pt, v, clamped.Data[pt])
+		}
+	}
+	c := clamped.Data[huge]
+	if got, want := math.Hypot(c.Ax, c.Ay)*lead*lead/2, 0.25*math.Hypot(vx, vy)*lead; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected the clamped acceleration to move the cell %v px, got %v", want, got)
+	}
+	if math.Abs(c.Ax*vy-c.Ay*vx) > 1e-12 {
+		t.Errorf("Expected the clamped acceleration to keep its direction, got (%v, %v)", c.Ax, c.Ay)
+	}
+
+	// The pixel at the center of the cell only meets cells that accelerate
+	// along the same direction, and no more than it.
+	center := int(16.5*advectSize/advectGridRes) - 1
+	speed := math.Hypot(vx, vy) * lead
+	dx, dy := advectedDisplacement(t, clamped, center, center, lead)
+	if d := math.Hypot(dx, dy); !(d > speed && d <= speed*(1+limit.MaxFraction)+1e-3) {
+		t.Errorf("Expected the clamped cell to move between %v and %v px, got %v", speed, speed*(1+limit.MaxFraction), d)
+	}
+	if dx, dy := advectedDisplacement(t, data, center, center, lead); math.Hypot(dx, dy) <= speed*(1+limit.MaxFraction) {
+		t.Errorf("Expected the unclamped cell to move beyond the limit, got %v px", math.Hypot(dx, dy))
+	}
+	for _, d := range []ExtrapolationData{data, clamped} {
+		if dx, dy := advectedDisplacement(t, d, 100, 100, lead); math.Abs(dx-vx*lead) > 1e-3 || math.Abs(dy-vy*lead) > 1e-3 {
+			t.Errorf("Expected a cell far from the accelerating one to move (%v, %v), got (%v, %v)", vx*lead, vy*lead, dx, dy)
+		}
+	}
+
+	pixels, err := data.ClampAccelerations(AccelerationLimit{MaxLead: lead, MaxPixels: 2})
+	if err != nil {
+		t.Fatalf("ClampAccelerations failed: %v", err)
+	}
+	if c := pixels.Data[huge]; pixels.ClampedAccelerations != 1 || math.Abs(math.Hypot(c.Ax, c.Ay)*lead*lead/2-2) > 1e-9 {
+		t.Errorf("Expected the acceleration to move the cell 2 px, got %v px in %d clamped cells", math.Hypot(c.Ax, c.Ay)*lead*lead/2, pixels.ClampedAccelerations)
+	}
+	if _, err := data.ClampAccelerations(AccelerationLimit{MaxLead: lead, MaxFraction: -1}); err == nil {
+		t.Error("Expected an error for a negative fraction")
+	}
+}
+
+// TestFitGridHistoryAccelerationLimit checks that Options.AccelerationLimit
+// counts MaxLead in frames whatever the time step of the fit.
+func TestFitGridHistoryAccelerationLimit(t *testing.T) {
+	const timeStep, lead = 5.0, 12.0
+	cell := image.Point{}
+	var history []map[image.Point]GridVector
+	for _, vx := range []float64{1, 1, 4} {
+		history = append(history, map[image.Point]GridVector{cell: {Vx: vx}})
+	}
+	opts := Options{AccelerationLimit: AccelerationLimit{MaxLead: lead, MaxFraction: 0.5}}
+	data, err := fitGridHistory(history, 1, timeStep, opts)
+	if err != nil {
+		t.Fatalf("fitGridHistory failed: %v", err)
+	}
+	if data.ClampedAccelerations != 1 {
+		t.Fatalf("Expected 1 clamped cell, got %d", data.ClampedAccelerations)
+	}
+	v := data.Data[cell]
+	if got, want := math.Abs(v.Ax*timeStep)*lead*lead/2, 0.5*math.Abs(v.Vx)*lead; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected the acceleration to move the cell %v px over %v frames, got %v", want, lead, got)
+	}
+}
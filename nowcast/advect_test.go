@@ -0,0 +1,137 @@
+package nowcast
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// advectSize is the side of the frames of the advection tests, split into
+// 32x32 cells of 4 pixels.
+const (
+	advectSize    = 128
+	advectGridRes = 32
+)
+
+// motionGrid returns the grid of velocities v(x, y) at the cell centers of
+// an advectSize frame.
+func motionGrid(v func(x, y float64) (vx, vy float64)) ExtrapolationData {
+	data := ExtrapolationData{GridRes: advectGridRes, Data: make(map[image.Point]GridVector)}
+	cell := float64(advectSize) / advectGridRes
+	for j := 0; j < advectGridRes; j++ {
+		for i := 0; i < advectGridRes; i++ {
+			vx, vy := v((float64(i)+0.5)*cell, (float64(j)+0.5)*cell)
+			data.Data[image.Pt(i, j)] = GridVector{Vx: vx, Vy: vy}
+		}
+	}
+	return data
+}
+
+// gaussianBlob returns an advectSize frame holding a Gaussian blob of
+// standard deviation 3 pixels centered at (cx, cy).
+func gaussianBlob(cx, cy float64) [][]float32 {
+	field := make([][]float32, advectSize)
+	for y := range field {
+		field[y] = make([]float32, advectSize)
+		for x := range field[y] {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			field[y][x] = float32(200 * math.Exp(-(dx*dx+dy*dy)/(2*3*3)))
+		}
+	}
+	return field
+}
+
+// centroid returns the intensity-weighted center of field, skipping NaN.
+func centroid(field [][]float32) (x, y float64) {
+	var sum float64
+	for j, row := range field {
+		for i, v := range row {
+			if math.IsNaN(float64(v)) {
+				continue
+			}
+			x += float64(v) * (float64(i) + 0.5)
+			y += float64(v) * (float64(j) + 0.5)
+			sum += float64(v)
+		}
+	}
+	return x / sum, y / sum
+}
+
+// TestAdvectRotation advects a blob under a solid-body rotation of 0.1
+// radians per frame for 20 frames, two radians. The explicit midpoint
+// method roughly halves Euler's lag in angle and all but removes its
+// contraction toward the center, so its displacement error is far below
+// half of Euler's.
+func TestAdvectRotation(t *testing.T) {
+	const omega, lead, radius = 0.1, 20.0, 40.0
+	const center = advectSize / 2
+	data := motionGrid(func(x, y float64) (float64, float64) {
+		return -omega * (y - center), omega * (x - center)
+	})
+	field := gaussianBlob(center+radius, center)
+	angle := omega * lead
+	wantX, wantY := center+radius*math.Cos(angle), center+radius*math.Sin(angle)
+
+	var displacementErr, angleErr [2]float64
+	for i, integrator := range []Integrator{IntegratorEuler, IntegratorRK2} {
+		out, err := Advect(field, data, lead, AdvectOptions{Integrator: integrator})
+		if err != nil {
+			t.Fatalf("Advect with integrator %d failed: %v", integrator, err)
+		}
+		x, y := centroid(out)
+		displacementErr[i] = math.Hypot(x-wantX, y-wantY)
+		angleErr[i] = math.Abs(math.Atan2(y-center, x-center) - angle)
+	}
+	t.Logf("Euler: %.3f px, %.4f rad; RK2: %.3f px, %.4f rad", displacementErr[0], angleErr[0], displacementErr[1], angleErr[1])
+	if displacementErr[1] > displacementErr[0]/2 {
+		t.Errorf("Expected RK2's displacement error of %.3f px to be at most half of Euler's %.3f px", displacementErr[1], displacementErr[0])
+	}
+	if angleErr[1] >= angleErr[0] {
+		t.Errorf("Expected RK2 to lag less in angle than Euler, got %.4f and %.4f rad", angleErr[1], angleErr[0])
+	}
+	if displacementErr[1] > 0.5 {
+		t.Errorf("Expected RK2 to place the blob within 0.5 px, got %.3f px", displacementErr[1])
+	}
+}
+
+// TestAdvectTranslation checks that both integrators move a blob by the
+// lead time times a uniform velocity and agree with each other.
+func TestAdvectTranslation(t *testing.T) {
+	const vx, vy, lead = 1.5, -0.75, 10.0
+	data := motionGrid(func(x, y float64) (float64, float64) { return vx, vy })
+	field := gaussianBlob(40, 80)
+
+	euler, err := Advect(field, data, lead, AdvectOptions{})
+	if err != nil {
+		t.Fatalf("Advect failed: %v", err)
+	}
+	rk2, err := Advect(field, data, lead, AdvectOptions{Integrator: IntegratorRK2, StepLength: 0.5})
+	if err != nil {
+		t.Fatalf("Advect failed: %v", err)
+	}
+	if x, y := centroid(euler); math.Abs(x-(40+vx*lead)) > 0.05 || math.Abs(y-(80+vy*lead)) > 0.05 {
+		t.Errorf("Expected the blob at (%v, %v), got (%.3f, %.3f)", 40+vx*lead, 80+vy*lead, x, y)
+	}
+	for y := range euler {
+		for x := range euler[y] {
+			a, b := float64(euler[y][x]), float64(rk2[y][x])
+			if math.IsNaN(a) != math.IsNaN(b) || math.Abs(a-b) > 1e-3 {
+				t.Fatalf("Pixel (%d, %d): Euler %v, RK2 %v", x, y, a, b)
+			}
+		}
+	}
+	// The left edge, 15 pixels wide, flows in from outside the frame.
+	if v := euler[advectSize/2][0]; !math.IsNaN(float64(v)) {
+		t.Errorf("Expected NaN where the trajectory leaves the frame, got %v", v)
+	}
+	if v := euler[advectSize/2][advectSize-1]; v != 0 {
+		t.Errorf("Expected the right edge to stay empty, got %v", v)
+	}
+
+	if _, err := Advect(field, data, -1, AdvectOptions{}); err == nil {
+		t.Error("Expected an error for a negative lead time")
+	}
+	if _, err := Advect(field, data, 1, AdvectOptions{Integrator: 7}); err == nil {
+		t.Error("Expected an error for an unknown integrator")
+	}
+}
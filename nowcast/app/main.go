@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"example/goflow/fileutil"
+	"example/goflow/nowcast"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// gridCell is one cell of a -grid-out JSON file.
+type gridCell struct {
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Vx         float64 `json:"vx"`
+	Vy         float64 `json:"vy"`
+	Ax         float64 `json:"ax"`
+	Ay         float64 `json:"ay"`
+	Unreliable bool    `json:"unreliable,omitempty"`
+}
+
+// gridFile is a -grid-out JSON file.
+type gridFile struct {
+	GridRes              int        `json:"grid_res"`
+	OutlierCells         int        `json:"outlier_cells"`
+	OutlierSamples       int        `json:"outlier_samples"`
+	ClampedAccelerations int        `json:"clamped_accelerations"`
+	Cells                []gridCell `json:"cells"`
+}
+
+// run fits the nowcast grid of the frames named in args and writes it to
+// -grid-out, printing a summary to stdout.
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("nowcast", flag.ContinueOnError)
+	gridRes := fs.Int("grid-res", 64, "Number of grid cells on each side of the frames.")
+	timeStep := fs.Float64("time-step", 1, "Time between consecutive frames, in the unit of the fitted velocities.")
+	gridOut := fs.String("grid-out", "", "If set, write the fitted grid to this file, in the compact binary grid format for a .bin extension and as JSON otherwise.")
+	overwrite := fs.Bool("overwrite", false, "Replace output files that already exist instead of failing.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	imagePaths := fs.Args()
+	if len(imagePaths) < 3 {
+		return fmt.Errorf("usage: nowcast [flags] <frame> <frame> <frame>...: at least 3 frames are required, got %d", len(imagePaths))
+	}
+
+	data, err := nowcast.ProcessImages(imagePaths, *gridRes, *timeStep)
+	if err != nil {
+		return fmt.Errorf("error processing frames: %w", err)
+	}
+	fmt.Fprintf(stdout, "Successfully generated extrapolation data for %d grid cells.\n", len(data.Data))
+
+	if *gridOut == "" {
+		return nil
+	}
+	if err := fileutil.WriteAtomic(*gridOut, func(w io.Writer) error { return writeGrid(w, *gridOut, data) }, *overwrite); err != nil {
+		return fmt.Errorf("error writing grid: %w", err)
+	}
+	fmt.Fprintf(stdout, "Grid saved to %s\n", *gridOut)
+	return nil
+}
+
+// writeGrid writes data to w in the format chosen by the extension of path.
+func writeGrid(w io.Writer, path string, data nowcast.ExtrapolationData) error {
+	if strings.EqualFold(filepath.Ext(path), ".bin") {
+		return data.Encode(w)
+	}
+	file := gridFile{
+		GridRes:              data.GridRes,
+		OutlierCells:         data.OutlierCells,
+		OutlierSamples:       data.OutlierSamples,
+		ClampedAccelerations: data.ClampedAccelerations,
+		Cells:                []gridCell{},
+	}
+	for _, pt := range data.Points() {
+		v := data.Data[pt]
+		file.Cells = append(file.Cells, gridCell{X: pt.X, Y: pt.Y, Vx: v.Vx, Vy: v.Vy, Ax: v.Ax, Ay: v.Ay, Unreliable: v.Unreliable})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(file)
+}
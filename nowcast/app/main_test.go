@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"example/goflow/fileutil"
+	"example/goflow/nowcast"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testFrames = []string{
+	"../../rainfall_data/2025-10-03T14:40:00Z.png",
+	"../../rainfall_data/2025-10-03T14:45:00Z.png",
+	"../../rainfall_data/2025-10-03T14:50:00Z.png",
+}
+
+// TestGridOut checks that -grid-out writes the binary grid format for a
+// .bin file, decoding to the fitted grid, and JSON otherwise, and that it
+// does not replace an existing file without -overwrite.
+func TestGridOut(t *testing.T) {
+	want, err := nowcast.ProcessImages(testFrames, 8, 1)
+	if err != nil {
+		t.Fatalf("ProcessImages failed: %v", err)
+	}
+	dir := t.TempDir()
+
+	binPath := filepath.Join(dir, "grid.bin")
+	if err := run(append([]string{"-grid-res", "8", "-grid-out", binPath}, testFrames...), io.Discard); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	encoded, err := os.ReadFile(binPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got nowcast.ExtrapolationData
+	if err := got.Decode(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	var wantEncoded bytes.Buffer
+	if err := want.Encode(&wantEncoded); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(encoded, wantEncoded.Bytes()) || len(got.Data) != len(want.Data) {
+		t.Errorf("Expected the .bin grid to hold the %d fitted cells, got %d", len(want.Data), len(got.Data))
+	}
+
+	jsonPath := filepath.Join(dir, "grid.json")
+	if err := run(append([]string{"-grid-res", "8", "-grid-out", jsonPath}, testFrames...), io.Discard); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	raw, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file gridFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		t.Fatalf("Expected JSON for a .json grid: %v", err)
+	}
+	if file.GridRes != 8 || len(file.Cells) != len(want.Data) {
+		t.Errorf("Expected %d cells at grid resolution 8, got %d at %d", len(want.Data), len(file.Cells), file.GridRes)
+	}
+	first := file.Cells[0]
+	if v := want.Data[want.Points()[0]]; !reflect.DeepEqual(first, gridCell{X: first.X, Y: first.Y, Vx: v.Vx, Vy: v.Vy, Ax: v.Ax, Ay: v.Ay, Unreliable: v.Unreliable}) {
+		t.Errorf("Expected the first cell to be %+v, got %+v", v, first)
+	}
+
+	err = run(append([]string{"-grid-res", "8", "-grid-out", binPath}, testFrames...), io.Discard)
+	if !errors.Is(err, fileutil.ErrExists) {
+		t.Errorf("Expected ErrExists without -overwrite, got %v", err)
+	}
+	if err := run(append([]string{"-grid-res", "8", "-grid-out", binPath, "-overwrite"}, testFrames...), io.Discard); err != nil {
+		t.Errorf("Expected -overwrite to replace the grid, got %v", err)
+	}
+}
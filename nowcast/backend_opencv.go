@@ -0,0 +1,170 @@
+//go:build cgo && !purego
+
+package nowcast
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+
+	"gocv.io/x/gocv"
+)
+
+// defaultBackend is the backend used for BackendDefault in this build.
+const defaultBackend = BackendOpenCV
+
+// LoadGrayscaleImage loads a PNG, decodes it, and converts it to a grayscale gocv.Mat.
+func LoadGrayscaleImage(filePath string) (gocv.Mat, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return gocv.Mat{}, fmt.Errorf("failed to open image file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return gocv.Mat{}, fmt.Errorf("failed to decode png %s: %w", filePath, err)
+	}
+
+	// Convert to RGBA first, as gocv.ImageToMatRGBA is robust
+	rgbaImg, ok := img.(*image.RGBA)
+	if !ok {
+		// If not RGBA, create a new RGBA image and draw the decoded image onto it
+		bounds := img.Bounds()
+		rgbaImg = image.NewRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				rgbaImg.Set(x, y, img.At(x, y))
+			}
+		}
+	}
+
+	matRGBA, err := gocv.ImageToMatRGBA(rgbaImg)
+	if err != nil {
+		return gocv.Mat{}, fmt.Errorf("failed to convert image to MatRGBA: %w", err)
+	}
+	defer matRGBA.Close()
+
+	matGray := gocv.NewMat()
+	gocv.CvtColor(matRGBA, &matGray, gocv.ColorRGBAToGray)
+	return matGray, nil
+}
+
+// CalculateGridVelocities aggregates pixel-wise flow into a grid using trimmed mean.
+// NaN and infinite pixels are ignored, and cells without any valid pixel are
+// left out of the result.
+func CalculateGridVelocities(flow gocv.Mat, gridRes int) (map[image.Point]GridVector, error) {
+	rows, cols, at, err := matFlow(flow)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateGrid(rows, cols, gridRes, at), nil
+}
+
+// matFlow returns the size of a Farneback flow matrix and a function
+// returning its flow at pixel (x, y).
+func matFlow(flow gocv.Mat) (rows, cols int, at func(y, x int) (vx, vy float64), err error) {
+	if flow.Empty() || flow.Type() != gocv.MatTypeCV32FC2 {
+		return 0, 0, nil, fmt.Errorf("invalid flow matrix: empty or wrong type")
+	}
+
+	rows = flow.Rows()
+	cols = flow.Cols()
+	if rows == 0 || cols == 0 {
+		return 0, 0, nil, fmt.Errorf("flow matrix has zero dimensions")
+	}
+
+	// Farneback returns a 2-channel float matrix (CV_32FC2)
+	return rows, cols, func(y, x int) (float64, float64) {
+		vec := flow.GetVecfAt(y, x)
+		return float64(vec[0]), float64(vec[1])
+	}, nil
+}
+
+// opencvFlows computes the Farneback flow between consecutive frames, after
+// filtering them with pre, and passes each flow field to visit. The frames
+// are decoded as they are needed, so the sequence is never all in memory.
+func opencvFlows(imagePaths []string, pre Preprocess, visit flowVisitor) error {
+	src := NewPathFrameSource(imagePaths, 0)
+	defer src.Close()
+	return farnebackFlows(src.Len(), func(i int) (gocv.Mat, error) {
+		img, err := src.At(i)
+		if err != nil {
+			return gocv.Mat{}, err
+		}
+		return pre.applyMat(img), nil
+	}, visit)
+}
+
+// farnebackFlows computes the Farneback flow between each of numFrames
+// consecutive frames and passes it to visit. frame returns frame i, which
+// farnebackFlows closes; each frame is requested once, in order.
+func farnebackFlows(numFrames int, frame func(i int) (gocv.Mat, error), visit flowVisitor) error {
+	prevImg, err := frame(0)
+	if err != nil {
+		return err
+	}
+	defer func() { prevImg.Close() }()
+
+	for i := 1; i < numFrames; i++ {
+		currImg, err := frame(i)
+		if err != nil {
+			return err
+		}
+
+		flow := gocv.NewMat()
+		// Farneback parameters (tuned for general use)
+		// pyr_scale=0.5, levels=3, winsize=15, iterations=3, poly_n=5, poly_sigma=1.2, flags=0
+		gocv.CalcOpticalFlowFarneback(prevImg, currImg, &flow, 0.5, 3, 15, 3, 5, 1.2, 0)
+		rows, cols, at, err := matFlow(flow)
+		if err == nil {
+			visit(rows, cols, at)
+		}
+		flow.Close()
+
+		prevImg.Close()   // Close the previous image
+		prevImg = currImg // The current image becomes the next previous image
+		if err != nil {
+			return fmt.Errorf("error calculating grid velocities for flow %d: %w", i-1, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMat returns frame filtered by p, or a copy of it for PreprocessNone.
+// The caller closes the result.
+func (p Preprocess) applyMat(frame gocv.Mat) gocv.Mat {
+	if p.Filter == PreprocessNone {
+		return frame.Clone()
+	}
+	filtered := gocv.NewMat()
+	switch p.Filter {
+	case PreprocessMedian:
+		gocv.MedianBlur(frame, &filtered, p.Kernel)
+	case PreprocessGaussian:
+		r := gaussianRadius(p.Sigma)
+		gocv.GaussianBlur(frame, &filtered, image.Pt(2*r+1, 2*r+1), p.Sigma, p.Sigma, gocv.BorderReflect101)
+	}
+	return filtered
+}
+
+// ProcessMats is like ProcessImagesWithOptions for frames already loaded as
+// 8-bit single-channel Mats, ordered from oldest to newest. It always uses
+// the OpenCV backend and leaves the frames unchanged. It is ProcessFrames
+// over a MatFrameSource, checking every frame before computing any flow.
+func ProcessMats(frames []gocv.Mat, gridRes int, timeStep float64, opts Options) (ExtrapolationData, error) {
+	if len(frames) < 3 {
+		return ExtrapolationData{}, fmt.Errorf("at least 3 frames are required, but got %d", len(frames))
+	}
+	if opts.Backend == BackendPureGo {
+		return ExtrapolationData{}, fmt.Errorf("ProcessMats only supports the OpenCV backend")
+	}
+	for i, f := range frames {
+		if f.Empty() || f.Type() != gocv.MatTypeCV8U {
+			return ExtrapolationData{}, fmt.Errorf("frame %d is not an 8-bit single-channel image", i)
+		}
+	}
+	return ProcessFrames(MatFrameSource(frames), gridRes, timeStep, opts)
+}
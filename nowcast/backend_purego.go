@@ -0,0 +1,11 @@
+//go:build !cgo || purego
+
+package nowcast
+
+// defaultBackend is the backend used for BackendDefault in this build.
+const defaultBackend = BackendPureGo
+
+// opencvFlows is unavailable in builds without OpenCV.
+func opencvFlows(imagePaths []string, pre Preprocess, visit flowVisitor) error {
+	return ErrOpenCVUnavailable
+}
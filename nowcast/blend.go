@@ -0,0 +1,129 @@
+//go:build cgo && !purego
+
+package nowcast
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// BlendTarget is the field an advection forecast decays toward.
+type BlendTarget int
+
+const (
+	// TargetSmoothed blends toward a Gaussian-smoothed copy of the last
+	// observation, so small-scale detail fades while the broad pattern stays.
+	TargetSmoothed BlendTarget = iota
+	// TargetZero blends toward an empty field.
+	TargetZero
+)
+
+// DefaultBlendSigma is the default BlendOptions.SmoothSigma.
+const DefaultBlendSigma = 8.0
+
+// BlendOptions configures BlendForecast.
+type BlendOptions struct {
+	// HalfLife is the lead time, in the units of the lead times, at which
+	// the advected field and the target get equal weight. The advected
+	// field's weight is 0.5^(lead time / HalfLife).
+	HalfLife float64
+	// Weights, if set, gives the advected field's weight for each lead time
+	// directly, in place of the exponential decay. Each must be between 0
+	// and 1.
+	Weights []float64
+	// Target selects the field the forecast decays toward.
+	Target BlendTarget
+	// LastObservation is the most recent observed frame, smoothed to form
+	// the target under TargetSmoothed. It must have the size and type of the
+	// advected frames.
+	LastObservation gocv.Mat
+	// SmoothSigma is the standard deviation, in pixels, of the Gaussian used
+	// under TargetSmoothed. Zero means DefaultBlendSigma.
+	SmoothSigma float64
+}
+
+// blendWeights returns the advected field's weight for each lead time.
+func (o BlendOptions) blendWeights(leadTimes []float64) ([]float64, error) {
+	if o.Weights != nil {
+		if len(o.Weights) != len(leadTimes) {
+			return nil, fmt.Errorf("got %d blend weights for %d lead times", len(o.Weights), len(leadTimes))
+		}
+		for i, w := range o.Weights {
+			if !(w >= 0 && w <= 1) {
+				return nil, fmt.Errorf("blend weight %d is %v, want a value between 0 and 1", i, w)
+			}
+		}
+		return o.Weights, nil
+	}
+	if !(o.HalfLife > 0) {
+		return nil, fmt.Errorf("blend half-life must be positive, got %v", o.HalfLife)
+	}
+	weights := make([]float64, len(leadTimes))
+	for i, lead := range leadTimes {
+		if lead < 0 {
+			return nil, fmt.Errorf("lead time %d is negative (%v)", i, lead)
+		}
+		weights[i] = math.Pow(0.5, lead/o.HalfLife)
+	}
+	return weights, nil
+}
+
+// BlendForecast blends each advected frame toward a target field with a
+// weight that decays with lead time, since raw advection loses skill as the
+// lead time grows. Frame i becomes w·advected[i] + (1−w)·target, with w from
+// opts for leadTimes[i]. The target is the smoothed last observation or
+// zeros; see BlendTarget. 8-bit frames are rounded and saturated as by
+// gocv.AddWeighted. The caller must close the returned frames.
+func BlendForecast(advected []gocv.Mat, leadTimes []float64, opts BlendOptions) ([]gocv.Mat, error) {
+	if len(advected) != len(leadTimes) {
+		return nil, fmt.Errorf("got %d advected frames for %d lead times", len(advected), len(leadTimes))
+	}
+	weights, err := opts.blendWeights(leadTimes)
+	if err != nil {
+		return nil, err
+	}
+	if len(advected) == 0 {
+		return nil, nil
+	}
+
+	rows, cols, matType := advected[0].Rows(), advected[0].Cols(), advected[0].Type()
+	for i, frame := range advected {
+		if frame.Rows() != rows || frame.Cols() != cols || frame.Type() != matType {
+			return nil, fmt.Errorf("advected frame %d does not match the size and type of frame 0", i)
+		}
+	}
+
+	var target gocv.Mat
+	switch opts.Target {
+	case TargetSmoothed:
+		// A zero Mat, left when no observation is set, has no matrix to query.
+		last := opts.LastObservation
+		if last.Ptr() == nil || last.Empty() {
+			return nil, fmt.Errorf("the smoothed blend target needs the last observation")
+		}
+		if last.Rows() != rows || last.Cols() != cols || last.Type() != matType {
+			return nil, fmt.Errorf("the last observation does not match the size and type of the advected frames")
+		}
+		sigma := opts.SmoothSigma
+		if sigma <= 0 {
+			sigma = DefaultBlendSigma
+		}
+		target = gocv.NewMat()
+		gocv.GaussianBlur(last, &target, image.Point{}, sigma, sigma, gocv.BorderReflect101)
+	case TargetZero:
+		target = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), rows, cols, matType)
+	default:
+		return nil, fmt.Errorf("unknown blend target %d", opts.Target)
+	}
+	defer target.Close()
+
+	blended := make([]gocv.Mat, len(advected))
+	for i, frame := range advected {
+		blended[i] = gocv.NewMat()
+		gocv.AddWeighted(frame, weights[i], target, 1-weights[i], 0, &blended[i])
+	}
+	return blended, nil
+}
@@ -0,0 +1,107 @@
+//go:build cgo && !purego
+
+package nowcast
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// rampMat returns a rows x cols CV32F frame whose value at (x, y) is
+// scale*(x + 2y).
+func rampMat(rows, cols int, scale float32) gocv.Mat {
+	m := gocv.NewMatWithSize(rows, cols, gocv.MatTypeCV32F)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			m.SetFloatAt(y, x, scale*float32(x+2*y))
+		}
+	}
+	return m
+}
+
+// checkBlend verifies that blended = w*advected + (1-w)*target pixel by
+// pixel.
+func checkBlend(t *testing.T, blended, advected, target gocv.Mat, w float64) {
+	t.Helper()
+	for y := 0; y < advected.Rows(); y++ {
+		for x := 0; x < advected.Cols(); x++ {
+			want := w*float64(advected.GetFloatAt(y, x)) + (1-w)*float64(target.GetFloatAt(y, x))
+			if got := float64(blended.GetFloatAt(y, x)); math.Abs(got-want) > 1e-3 {
+				t.Fatalf("Pixel (%d, %d) with weight %v: got %v, want %v", x, y, w, got, want)
+			}
+		}
+	}
+}
+
+func closeAll(mats []gocv.Mat) {
+	for _, m := range mats {
+		m.Close()
+	}
+}
+
+func TestBlendForecastHalfLifeToZero(t *testing.T) {
+	advected := []gocv.Mat{rampMat(12, 16, 1), rampMat(12, 16, 2)}
+	defer closeAll(advected)
+	zero := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), 12, 16, gocv.MatTypeCV32F)
+	defer zero.Close()
+
+	blended, err := BlendForecast(advected, []float64{30, 60}, BlendOptions{HalfLife: 30, Target: TargetZero})
+	if err != nil {
+		t.Fatalf("BlendForecast failed: %v", err)
+	}
+	defer closeAll(blended)
+	checkBlend(t, blended[0], advected[0], zero, 0.5)
+	checkBlend(t, blended[1], advected[1], zero, 0.25)
+}
+
+func TestBlendForecastWeightsToSmoothed(t *testing.T) {
+	advected := []gocv.Mat{rampMat(12, 16, 1), rampMat(12, 16, 3)}
+	defer closeAll(advected)
+	last := rampMat(12, 16, 0.5)
+	defer last.Close()
+	target := gocv.NewMat()
+	defer target.Close()
+	gocv.GaussianBlur(last, &target, image.Point{}, 2, 2, gocv.BorderReflect101)
+
+	weights := []float64{0.9, 0.2}
+	blended, err := BlendForecast(advected, []float64{10, 90}, BlendOptions{
+		Weights:         weights,
+		Target:          TargetSmoothed,
+		LastObservation: last,
+		SmoothSigma:     2,
+	})
+	if err != nil {
+		t.Fatalf("BlendForecast failed: %v", err)
+	}
+	defer closeAll(blended)
+	checkBlend(t, blended[0], advected[0], target, weights[0])
+	checkBlend(t, blended[1], advected[1], target, weights[1])
+}
+
+func TestBlendForecastErrors(t *testing.T) {
+	advected := []gocv.Mat{rampMat(4, 4, 1)}
+	defer closeAll(advected)
+	empty := gocv.NewMat()
+	defer empty.Close()
+	tests := []struct {
+		name      string
+		leadTimes []float64
+		opts      BlendOptions
+	}{
+		{"lead time count", []float64{10, 20}, BlendOptions{HalfLife: 30, Target: TargetZero}},
+		{"no decay", []float64{10}, BlendOptions{Target: TargetZero}},
+		{"weight out of range", []float64{10}, BlendOptions{Weights: []float64{1.5}, Target: TargetZero}},
+		{"negative lead time", []float64{-5}, BlendOptions{HalfLife: 30, Target: TargetZero}},
+		{"no observation under the default target", []float64{10}, BlendOptions{HalfLife: 30}},
+		{"empty observation", []float64{10}, BlendOptions{HalfLife: 30, LastObservation: empty}},
+	}
+	for _, tt := range tests {
+		if blended, err := BlendForecast(advected, tt.leadTimes, tt.opts); err == nil {
+			closeAll(blended)
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
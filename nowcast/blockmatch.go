@@ -0,0 +1,336 @@
+package nowcast
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+)
+
+// ErrOpenCVUnavailable is returned when BackendOpenCV is requested from a
+// build without OpenCV (built with the purego tag or without cgo).
+var ErrOpenCVUnavailable = errors.New("nowcast: built without OpenCV support")
+
+// FlowField is a dense optical flow field held in plain Go slices.
+// Vx[y][x] and Vy[y][x] are the displacement of pixel (x, y) in pixels per
+// frame; NaN marks pixels without a reliable estimate.
+type FlowField struct {
+	Vx [][]float32
+	Vy [][]float32
+}
+
+// Block matching parameters. The matching window is the same size at every
+// pyramid level, so coarse levels see a wider area.
+const (
+	matchBlockSize   = 8    // pixels sharing one flow vector
+	matchWindow      = 12   // side of the compared window, per level
+	matchLevels      = 3    // pyramid levels, including full resolution
+	matchCoarseRange = 4    // search radius at the coarsest level
+	matchFineRange   = 2    // search radius at full resolution
+	matchBlurRadius  = 2    // box blur applied before matching
+	minEigenQuality  = 0.05 // minimum texture, relative to the frame's best block
+	maxMatchRatio    = 0.5  // best cost over the mean cost of its neighbours
+)
+
+// LoadGrayscaleField loads a PNG as rows of grayscale intensities in 0..255,
+// using the same luma weights as LoadGrayscaleImage.
+func LoadGrayscaleField(filePath string) ([][]float32, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode png %s: %w", filePath, err)
+	}
+
+	bounds := img.Bounds()
+	field := make([][]float32, bounds.Dy())
+	for y := range field {
+		field[y] = make([]float32, bounds.Dx())
+		for x := range field[y] {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			field[y][x] = float32(gray.Y)
+		}
+	}
+	return field, nil
+}
+
+// BlockMatchFlow estimates the dense flow from prev to next, which must have
+// the same non-zero size, by coarse-to-fine block matching on blurred
+// frames. Every 8x8 block gets one vector with sub-pixel refinement.
+//
+// It is a fallback for builds without OpenCV and is less accurate than
+// Farneback: displacements are found up to about 20 pixels per frame, and
+// blocks without enough two-dimensional texture, or whose best match is not
+// clearly better than its neighbours, are left NaN rather than guessed. On
+// the moving rectangle used by the tests the fitted grid velocity is within
+// 2 pixels per frame of the truth and the acceleration within 2 pixels per
+// frame squared, against 1.5 and 0.5 for Farneback.
+func BlockMatchFlow(prev, next [][]float32) (FlowField, error) {
+	rows := len(prev)
+	if rows == 0 || len(prev[0]) == 0 {
+		return FlowField{}, fmt.Errorf("flow input has zero dimensions")
+	}
+	cols := len(prev[0])
+	if len(next) != rows || len(next[0]) != cols {
+		return FlowField{}, fmt.Errorf("flow inputs differ in size: %dx%d vs %dx%d", cols, rows, len(next[0]), len(next))
+	}
+
+	prevPyr := buildPyramid(boxBlur(prev, matchBlurRadius))
+	nextPyr := buildPyramid(boxBlur(next, matchBlurRadius))
+	top := len(prevPyr) - 1
+
+	blocksX := (cols + matchBlockSize - 1) / matchBlockSize
+	blocksY := (rows + matchBlockSize - 1) / matchBlockSize
+	type blockMatch struct {
+		ux, uy   float32
+		minEigen float64
+		ok       bool
+	}
+	matches := make([]blockMatch, blocksX*blocksY)
+	bestEigen := 0.0
+
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			cx := min(bx*matchBlockSize+matchBlockSize/2, cols-1)
+			cy := min(by*matchBlockSize+matchBlockSize/2, rows-1)
+
+			// Coarse to fine: search widely at the top, then refine the
+			// doubled estimate at each finer level.
+			ux, uy := 0, 0
+			var centreX, centreY int // start of the full-resolution search
+			var costs [2*matchFineRange + 1][2*matchFineRange + 1]float64
+			for level := top; level >= 0; level-- {
+				radius := 1
+				switch level {
+				case top:
+					radius = matchCoarseRange
+				case 0:
+					radius = matchFineRange
+				}
+				if level < top {
+					ux, uy = 2*ux, 2*uy
+				}
+				centreX, centreY = ux, uy
+				p, n := prevPyr[level], nextPyr[level]
+				lx, ly := cx>>level, cy>>level
+				bestX, bestY, best := ux, uy, math.Inf(1)
+				for dy := -radius; dy <= radius; dy++ {
+					for dx := -radius; dx <= radius; dx++ {
+						cost := windowSSD(p, n, lx, ly, ux+dx, uy+dy)
+						if level == 0 {
+							costs[dy+radius][dx+radius] = cost
+						}
+						// Ties go to the smaller displacement.
+						if cost < best || cost == best && (ux+dx)*(ux+dx)+(uy+dy)*(uy+dy) < bestX*bestX+bestY*bestY {
+							bestX, bestY, best = ux+dx, uy+dy, cost
+						}
+					}
+				}
+				ux, uy = bestX, bestY
+			}
+
+			m := &matches[by*blocksX+bx]
+			m.minEigen = windowMinEigen(prevPyr[0], cx, cy)
+			bestEigen = math.Max(bestEigen, m.minEigen)
+
+			ix, iy := ux-centreX+matchFineRange, uy-centreY+matchFineRange
+			best, others := costs[iy][ix], 0.0
+			for j := range costs {
+				for i := range costs[j] {
+					others += costs[j][i]
+				}
+			}
+			others = (others - best) / float64(len(costs)*len(costs[0])-1)
+			if others <= 0 || best > maxMatchRatio*others {
+				continue
+			}
+			m.ok = true
+			m.ux = float32(ux)
+			m.uy = float32(uy)
+			if ix > 0 && ix < len(costs[0])-1 {
+				m.ux += subpixelOffset(costs[iy][ix-1], best, costs[iy][ix+1])
+			}
+			if iy > 0 && iy < len(costs)-1 {
+				m.uy += subpixelOffset(costs[iy-1][ix], best, costs[iy+1][ix])
+			}
+		}
+	}
+
+	nan := float32(math.NaN())
+	field := FlowField{Vx: make([][]float32, rows), Vy: make([][]float32, rows)}
+	for y := 0; y < rows; y++ {
+		field.Vx[y] = make([]float32, cols)
+		field.Vy[y] = make([]float32, cols)
+		for x := 0; x < cols; x++ {
+			m := matches[(y/matchBlockSize)*blocksX+x/matchBlockSize]
+			if !m.ok || m.minEigen < minEigenQuality*bestEigen {
+				field.Vx[y][x], field.Vy[y][x] = nan, nan
+				continue
+			}
+			field.Vx[y][x], field.Vy[y][x] = m.ux, m.uy
+		}
+	}
+	return field, nil
+}
+
+// CalculateGridVelocitiesFromField is CalculateGridVelocities for a plain
+// Go flow field. NaN and infinite pixels are ignored, and cells without any
+// valid pixel are left out of the result.
+func CalculateGridVelocitiesFromField(field FlowField, gridRes int) (map[image.Point]GridVector, error) {
+	rows, cols, at, err := fieldFlow(field)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateGrid(rows, cols, gridRes, at), nil
+}
+
+// fieldFlow returns the size of field and a function returning its flow at
+// pixel (x, y).
+func fieldFlow(field FlowField) (rows, cols int, at func(y, x int) (vx, vy float64), err error) {
+	rows = len(field.Vx)
+	if rows == 0 || len(field.Vx[0]) == 0 {
+		return 0, 0, nil, fmt.Errorf("flow field has zero dimensions")
+	}
+	cols = len(field.Vx[0])
+	if len(field.Vy) != rows || len(field.Vy[0]) != cols {
+		return 0, 0, nil, fmt.Errorf("flow field components differ in size")
+	}
+	return rows, cols, func(y, x int) (float64, float64) {
+		return float64(field.Vx[y][x]), float64(field.Vy[y][x])
+	}, nil
+}
+
+// pureGoFlows is the BackendPureGo counterpart of opencvFlows.
+func pureGoFlows(imagePaths []string, pre Preprocess, visit flowVisitor) error {
+	prev, err := LoadGrayscaleField(imagePaths[0])
+	if err != nil {
+		return err
+	}
+	prev = pre.applyField(prev)
+	for i := 1; i < len(imagePaths); i++ {
+		curr, err := LoadGrayscaleField(imagePaths[i])
+		if err != nil {
+			return err
+		}
+		curr = pre.applyField(curr)
+		field, err := BlockMatchFlow(prev, curr)
+		if err != nil {
+			return fmt.Errorf("error calculating flow %d: %w", i-1, err)
+		}
+		rows, cols, at, err := fieldFlow(field)
+		if err != nil {
+			return fmt.Errorf("error calculating grid velocities for flow %d: %w", i-1, err)
+		}
+		visit(rows, cols, at)
+		prev = curr
+	}
+	return nil
+}
+
+// at returns img[y][x] with coordinates clamped to the image.
+func at(img [][]float32, x, y int) float32 {
+	y = max(0, min(y, len(img)-1))
+	x = max(0, min(x, len(img[y])-1))
+	return img[y][x]
+}
+
+// windowSSD is the sum of squared differences between the window of prev
+// centred on (cx, cy) and the window of next displaced by (ux, uy).
+func windowSSD(prev, next [][]float32, cx, cy, ux, uy int) float64 {
+	var sum float64
+	for j := -matchWindow / 2; j < matchWindow/2; j++ {
+		for i := -matchWindow / 2; i < matchWindow/2; i++ {
+			d := float64(at(prev, cx+i, cy+j) - at(next, cx+i+ux, cy+j+uy))
+			sum += d * d
+		}
+	}
+	return sum
+}
+
+// windowMinEigen is the smaller eigenvalue of the gradient structure tensor
+// over the window centred on (cx, cy). It is small where the window has no
+// texture or only a straight edge, where the match is ambiguous.
+func windowMinEigen(img [][]float32, cx, cy int) float64 {
+	var sxx, sxy, syy float64
+	for j := -matchWindow / 2; j < matchWindow/2; j++ {
+		for i := -matchWindow / 2; i < matchWindow/2; i++ {
+			x, y := cx+i, cy+j
+			gx := float64(at(img, x+1, y)-at(img, x-1, y)) / 2
+			gy := float64(at(img, x, y+1)-at(img, x, y-1)) / 2
+			sxx += gx * gx
+			sxy += gx * gy
+			syy += gy * gy
+		}
+	}
+	return (sxx+syy)/2 - math.Sqrt((sxx-syy)*(sxx-syy)/4+sxy*sxy)
+}
+
+// subpixelOffset fits a parabola through three costs at offsets -1, 0 and 1
+// and returns the offset of its minimum, within half a pixel.
+func subpixelOffset(left, centre, right float64) float32 {
+	denom := left - 2*centre + right
+	if denom <= 0 {
+		return 0
+	}
+	return float32(math.Max(-0.5, math.Min(0.5, (left-right)/(2*denom))))
+}
+
+// boxBlur averages every pixel with its neighbours within radius, using
+// separable passes with clamped borders.
+func boxBlur(img [][]float32, radius int) [][]float32 {
+	rows, cols := len(img), len(img[0])
+	tmp := make([][]float32, rows)
+	out := make([][]float32, rows)
+	n := float32(2*radius + 1)
+	for y := range img {
+		tmp[y] = make([]float32, cols)
+		for x := range img[y] {
+			var sum float32
+			for k := -radius; k <= radius; k++ {
+				sum += at(img, x+k, y)
+			}
+			tmp[y][x] = sum / n
+		}
+	}
+	for y := range img {
+		out[y] = make([]float32, cols)
+		for x := range img[y] {
+			var sum float32
+			for k := -radius; k <= radius; k++ {
+				sum += at(tmp, x, y+k)
+			}
+			out[y][x] = sum / n
+		}
+	}
+	return out
+}
+
+// buildPyramid returns img followed by up to matchLevels-1 successive 2x2
+// averaged downsamplings, stopping before a level gets smaller than the
+// matching window.
+func buildPyramid(img [][]float32) [][][]float32 {
+	pyr := [][][]float32{img}
+	for len(pyr) < matchLevels {
+		last := pyr[len(pyr)-1]
+		rows, cols := len(last)/2, len(last[0])/2
+		if rows < matchWindow || cols < matchWindow {
+			break
+		}
+		down := make([][]float32, rows)
+		for y := range down {
+			down[y] = make([]float32, cols)
+			for x := range down[y] {
+				down[y][x] = (last[2*y][2*x] + last[2*y][2*x+1] + last[2*y+1][2*x] + last[2*y+1][2*x+1]) / 4
+			}
+		}
+		pyr = append(pyr, down)
+	}
+	return pyr
+}
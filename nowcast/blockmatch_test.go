@@ -0,0 +1,148 @@
+package nowcast
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestProcessImagesPureGoMovingRectangle(t *testing.T) {
+	const vx, vy = 10, 0
+	imagePaths := createTestSequence(t, 4, 256, 256, 50, 50, 100, vx, vy)
+
+	data, err := ProcessImagesWithOptions(imagePaths, 4, 1.0, Options{Backend: BackendPureGo})
+	if err != nil {
+		t.Fatalf("ProcessImagesWithOptions failed: %v", err)
+	}
+
+	// Same cell as TestProcessImagesWithMovingRectangle, with the relaxed
+	// accuracy documented for BlockMatchFlow.
+	cell := image.Point{X: 1, Y: 1}
+	gv, ok := data.Data[cell]
+	if !ok {
+		t.Fatalf("No extrapolation data found for grid point %v", cell)
+	}
+	const tolerance = 2.0
+	if abs(gv.Vx-vx) > tolerance || abs(gv.Vy-vy) > tolerance {
+		t.Errorf("Expected V near (%d, %d), got (%.2f, %.2f)", vx, vy, gv.Vx, gv.Vy)
+	}
+	t.Logf("V=(%.3f, %.3f) A=(%.3f, %.3f)", gv.Vx, gv.Vy, gv.Ax, gv.Ay)
+}
+
+// shiftedNoise returns a smooth random texture and a copy translated by
+// (dx, dy) pixels.
+func shiftedNoise(size, dx, dy int) (prev, next [][]float32) {
+	rng := rand.New(rand.NewSource(3))
+	base := make([][]float32, size+2*abs2(dx)+2*abs2(dy))
+	for y := range base {
+		base[y] = make([]float32, len(base))
+		for x := range base[y] {
+			base[y][x] = float32(rng.Intn(256))
+		}
+	}
+	base = boxBlur(base, 1)
+	off := abs2(dx) + abs2(dy)
+	prev = make([][]float32, size)
+	next = make([][]float32, size)
+	for y := 0; y < size; y++ {
+		prev[y] = append([]float32(nil), base[y+off][off:off+size]...)
+		next[y] = append([]float32(nil), base[y+off-dy][off-dx:off-dx+size]...)
+	}
+	return prev, next
+}
+
+func abs2(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestBlockMatchFlowShiftedTexture(t *testing.T) {
+	const dx, dy = 7, -5
+	prev, next := shiftedNoise(96, dx, dy)
+	field, err := BlockMatchFlow(prev, next)
+	if err != nil {
+		t.Fatalf("BlockMatchFlow failed: %v", err)
+	}
+
+	valid, wrong := 0, 0
+	for y := 16; y < 80; y++ {
+		for x := 16; x < 80; x++ {
+			u, v := field.Vx[y][x], field.Vy[y][x]
+			if math.IsNaN(float64(u)) {
+				continue
+			}
+			valid++
+			if math.Abs(float64(u)-dx) > 0.5 || math.Abs(float64(v)-dy) > 0.5 {
+				wrong++
+			}
+		}
+	}
+	if valid < 64*64/2 {
+		t.Errorf("Expected most interior pixels to have a flow estimate, got %d of %d", valid, 64*64)
+	}
+	if wrong > 0 {
+		t.Errorf("%d of %d interior estimates are more than half a pixel off (%d, %d)", wrong, valid, dx, dy)
+	}
+
+	grid, err := CalculateGridVelocitiesFromField(field, 2)
+	if err != nil {
+		t.Fatalf("CalculateGridVelocitiesFromField failed: %v", err)
+	}
+	if len(grid) == 0 {
+		t.Fatal("Expected grid velocities")
+	}
+}
+
+func TestBlockMatchFlowFlatImage(t *testing.T) {
+	flat := make([][]float32, 32)
+	for y := range flat {
+		flat[y] = make([]float32, 32)
+		for x := range flat[y] {
+			flat[y][x] = 100
+		}
+	}
+	field, err := BlockMatchFlow(flat, flat)
+	if err != nil {
+		t.Fatalf("BlockMatchFlow failed: %v", err)
+	}
+	grid, err := CalculateGridVelocitiesFromField(field, 4)
+	if err != nil {
+		t.Fatalf("CalculateGridVelocitiesFromField failed: %v", err)
+	}
+	if len(grid) != 0 {
+		t.Errorf("Expected no grid cells for a textureless image, got %d", len(grid))
+	}
+
+	if _, err := BlockMatchFlow(flat, flat[:16]); err == nil {
+		t.Error("Expected an error for frames of different sizes")
+	}
+}
+
+func TestPreprocessFields(t *testing.T) {
+	img := make([][]float32, 9)
+	for y := range img {
+		img[y] = make([]float32, 9)
+		for x := range img[y] {
+			img[y][x] = 100
+		}
+	}
+	img[4][4] = 255
+
+	median := MedianBlur(3).applyField(img)
+	if median[4][4] != 100 {
+		t.Errorf("Expected the median filter to remove the speckle, got %f", median[4][4])
+	}
+	blurred := GaussianBlur(1).applyField(img)
+	if blurred[4][4] <= 100 || blurred[4][4] >= 255 || blurred[4][5] <= 100 {
+		t.Errorf("Expected the Gaussian blur to spread the speckle, got %f and %f", blurred[4][4], blurred[4][5])
+	}
+	if math.Abs(float64(blurred[0][0]-100)) > 1e-3 {
+		t.Errorf("Expected the Gaussian blur to keep flat areas, got %f", blurred[0][0])
+	}
+	if got := (Preprocess{}).applyField(img); &got[0][0] != &img[0][0] {
+		t.Error("Expected no filter to return the frame unchanged")
+	}
+}
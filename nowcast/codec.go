@@ -0,0 +1,178 @@
+package nowcast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// The binary grid format is little-endian:
+//
+//	magic          4 bytes, "GFGD"
+//	version        uint16
+//	gridRes        uint32
+//	cols, rows     uint32 each; cells are numbered row by row
+//	outlierCells   uint32
+//	outlierSamples uint32
+//	clampedAccels  uint32
+//	present        bitmap of cols*rows bits, one per cell
+//	unreliable     bitmap of cols*rows bits, one per cell
+//	records        Vx, Vy, Ax, Ay as float32 for each present cell, in order
+//
+// Bitmaps are padded to a whole number of bytes; bit i of a bitmap is bit
+// i%8 of byte i/8.
+const (
+	gridMagic = "GFGD"
+	// gridVersion 2 added clampedAccels; Decode rejects version 1.
+	gridVersion = 2
+
+	// maxGridCells bounds the grid size accepted by Decode so a corrupted
+	// header cannot trigger a huge allocation.
+	maxGridCells = 1 << 24
+)
+
+// ErrBadGridFormat is returned by Decode for streams that are not in the
+// binary grid format or are corrupted.
+var ErrBadGridFormat = errors.New("nowcast: bad binary grid format")
+
+type gridHeader struct {
+	Version        uint16
+	GridRes        uint32
+	Cols, Rows     uint32
+	OutlierCells   uint32
+	OutlierSamples uint32
+	ClampedAccels  uint32
+}
+
+// Encode writes e to w in the compact binary grid format. Velocities and
+// accelerations are stored as float32. Grid coordinates must not be negative.
+// History and HistoryTimes are not stored.
+func (e ExtrapolationData) Encode(w io.Writer) error {
+	var cols, rows int
+	for pt := range e.Data {
+		if pt.X < 0 || pt.Y < 0 {
+			return fmt.Errorf("cannot encode negative grid coordinate %v", pt)
+		}
+		cols = max(cols, pt.X+1)
+		rows = max(rows, pt.Y+1)
+	}
+	if cols*rows > maxGridCells {
+		return fmt.Errorf("grid of %dx%d cells is too large to encode", cols, rows)
+	}
+
+	cells := cols * rows
+	present := make([]byte, (cells+7)/8)
+	unreliable := make([]byte, (cells+7)/8)
+	for pt, v := range e.Data {
+		i := pt.Y*cols + pt.X
+		present[i/8] |= 1 << (i % 8)
+		if v.Unreliable {
+			unreliable[i/8] |= 1 << (i % 8)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	header := gridHeader{
+		Version:        gridVersion,
+		GridRes:        uint32(e.GridRes),
+		Cols:           uint32(cols),
+		Rows:           uint32(rows),
+		OutlierCells:   uint32(e.OutlierCells),
+		OutlierSamples: uint32(e.OutlierSamples),
+		ClampedAccels:  uint32(e.ClampedAccelerations),
+	}
+	bw.WriteString(gridMagic)
+	binary.Write(bw, binary.LittleEndian, header)
+	bw.Write(present)
+	bw.Write(unreliable)
+
+	var record [16]byte
+	for _, pt := range e.Points() {
+		v := e.Data[pt]
+		for k, f := range [4]float64{v.Vx, v.Vy, v.Ax, v.Ay} {
+			binary.LittleEndian.PutUint32(record[4*k:], math.Float32bits(float32(f)))
+		}
+		bw.Write(record[:])
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write grid: %w", err)
+	}
+	return nil
+}
+
+// Decode replaces e with the grid read from r, which must hold the binary
+// grid format written by Encode. Corrupted or truncated input yields an
+// error wrapping ErrBadGridFormat.
+func (e *ExtrapolationData) Decode(r io.Reader) error {
+	var magic [len(gridMagic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return badGrid("reading magic", err)
+	}
+	if string(magic[:]) != gridMagic {
+		return fmt.Errorf("%w: unknown magic %q", ErrBadGridFormat, magic[:])
+	}
+	var header gridHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return badGrid("reading header", err)
+	}
+	if header.Version != gridVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadGridFormat, header.Version)
+	}
+	cells := uint64(header.Cols) * uint64(header.Rows)
+	if cells > maxGridCells {
+		return fmt.Errorf("%w: grid of %dx%d cells is too large", ErrBadGridFormat, header.Cols, header.Rows)
+	}
+
+	bitmaps := make([]byte, 2*((cells+7)/8))
+	if _, err := io.ReadFull(r, bitmaps); err != nil {
+		return badGrid("reading cell bitmaps", err)
+	}
+	present, unreliable := bitmaps[:len(bitmaps)/2], bitmaps[len(bitmaps)/2:]
+
+	data := make(map[image.Point]GridVector)
+	var record [16]byte
+	cols := int(header.Cols)
+	for i := 0; i < int(cells); i++ {
+		if present[i/8]&(1<<(i%8)) == 0 {
+			if unreliable[i/8]&(1<<(i%8)) != 0 {
+				return fmt.Errorf("%w: missing cell %d marked unreliable", ErrBadGridFormat, i)
+			}
+			continue
+		}
+		if _, err := io.ReadFull(r, record[:]); err != nil {
+			return badGrid("reading cell records", err)
+		}
+		var f [4]float64
+		for k := range f {
+			f[k] = float64(math.Float32frombits(binary.LittleEndian.Uint32(record[4*k:])))
+		}
+		data[image.Pt(i%cols, i/cols)] = GridVector{
+			Vx:         f[0],
+			Vy:         f[1],
+			Ax:         f[2],
+			Ay:         f[3],
+			Unreliable: unreliable[i/8]&(1<<(i%8)) != 0,
+		}
+	}
+
+	*e = ExtrapolationData{
+		GridRes:              int(header.GridRes),
+		Data:                 data,
+		OutlierCells:         int(header.OutlierCells),
+		OutlierSamples:       int(header.OutlierSamples),
+		ClampedAccelerations: int(header.ClampedAccels),
+	}
+	return nil
+}
+
+// badGrid wraps a read error, reporting a short stream as corruption.
+func badGrid(what string, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated while %s", ErrBadGridFormat, what)
+	}
+	return fmt.Errorf("failed %s: %w", what, err)
+}
@@ -0,0 +1,109 @@
+package nowcast
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+func sampleGrid() ExtrapolationData {
+	data := map[image.Point]GridVector{}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			if (x+y)%3 == 0 {
+				continue // leave some cells missing
+			}
+			data[image.Pt(x, y)] = GridVector{
+				Vx:         float64(x) * 0.1,
+				Vy:         -float64(y) / 3,
+				Ax:         math.Pi * float64(x-y),
+				Ay:         1e-7 * float64(x*y),
+				Unreliable: x == 4,
+			}
+		}
+	}
+	return ExtrapolationData{GridRes: 64, Data: data, OutlierCells: 3, OutlierSamples: 11, ClampedAccelerations: 2}
+}
+
+func TestGridCodecRoundTrip(t *testing.T) {
+	original := sampleGrid()
+	var buf bytes.Buffer
+	if err := original.Encode(&buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var decoded ExtrapolationData
+	if err := decoded.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.GridRes != original.GridRes || decoded.OutlierCells != original.OutlierCells || decoded.OutlierSamples != original.OutlierSamples || decoded.ClampedAccelerations != original.ClampedAccelerations {
+		t.Errorf("Header mismatch: got %+v", decoded)
+	}
+	if len(decoded.Data) != len(original.Data) {
+		t.Fatalf("Expected %d cells, got %d", len(original.Data), len(decoded.Data))
+	}
+	bits := func(f float64) uint32 { return math.Float32bits(float32(f)) }
+	for pt, want := range original.Data {
+		got, ok := decoded.Data[pt]
+		if !ok {
+			t.Errorf("Cell %v missing after round trip", pt)
+			continue
+		}
+		if bits(got.Vx) != bits(want.Vx) || bits(got.Vy) != bits(want.Vy) || bits(got.Ax) != bits(want.Ax) || bits(got.Ay) != bits(want.Ay) {
+			t.Errorf("Cell %v: got %+v, want float32 of %+v", pt, got, want)
+		}
+		if got.Unreliable != want.Unreliable {
+			t.Errorf("Cell %v: Unreliable = %v, want %v", pt, got.Unreliable, want.Unreliable)
+		}
+	}
+
+	// A decoded grid re-encodes to the same bytes.
+	var again bytes.Buffer
+	if err := decoded.Encode(&again); err != nil {
+		t.Fatalf("Re-encode failed: %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Error("Re-encoded grid differs from the original encoding")
+	}
+}
+
+func TestGridCodecCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := sampleGrid().Encode(&buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte(nil), encoded...)
+		c[i] = b
+		return c
+	}
+	cases := map[string][]byte{
+		"empty":        nil,
+		"bad magic":    corrupt(0, 'X'),
+		"bad version":  corrupt(4, 9),
+		"version 1":    corrupt(4, 1),
+		"huge grid":    corrupt(13, 0xff),
+		"mid header":   encoded[:10],
+		"mid bitmap":   encoded[:32],
+		"mid records":  encoded[:len(encoded)-5],
+		"last missing": encoded[:len(encoded)-16],
+	}
+	for name, stream := range cases {
+		var decoded ExtrapolationData
+		err := decoded.Decode(bytes.NewReader(stream))
+		if !errors.Is(err, ErrBadGridFormat) {
+			t.Errorf("%s: expected ErrBadGridFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestGridCodecRejectsNegativeCoordinates(t *testing.T) {
+	grid := ExtrapolationData{GridRes: 8, Data: map[image.Point]GridVector{{X: -1, Y: 0}: {}}}
+	if err := grid.Encode(&bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for a negative grid coordinate")
+	}
+}
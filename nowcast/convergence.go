@@ -0,0 +1,114 @@
+package nowcast
+
+import (
+	"image"
+	"sort"
+)
+
+// Zone is a group of edge-adjacent grid cells where the motion field
+// converges, a candidate area for new convection to develop.
+type Zone struct {
+	// Cells are the grid coordinates of the zone ordered row by row.
+	Cells []image.Point
+	// CentroidX and CentroidY are the mean grid coordinates of the cells.
+	CentroidX, CentroidY float64
+	// Area is the number of cells.
+	Area int
+	// MeanDivergence is the mean divergence of the cells, as returned by
+	// Divergence, and is negative.
+	MeanDivergence float64
+}
+
+// Divergence returns the discrete divergence dVx/dx + dVy/dy of the grid
+// velocities at every cell, in pixels per frame per cell. Derivatives are
+// central differences over the neighbouring cells; where one neighbour has
+// no data the one-sided difference to the other is used. A cell without a
+// neighbour holding data along either axis has no divergence and is left
+// out.
+func Divergence(data ExtrapolationData) map[image.Point]float64 {
+	div := make(map[image.Point]float64, len(data.Data))
+	for pt := range data.Data {
+		dvx, okX := gridDerivative(data.Data, pt, image.Pt(1, 0), func(v GridVector) float64 { return v.Vx })
+		dvy, okY := gridDerivative(data.Data, pt, image.Pt(0, 1), func(v GridVector) float64 { return v.Vy })
+		if okX && okY {
+			div[pt] = dvx + dvy
+		}
+	}
+	return div
+}
+
+// gridDerivative returns the derivative of value at pt along step in units
+// per cell, and false if neither neighbour along step has data.
+func gridDerivative(grid map[image.Point]GridVector, pt, step image.Point, value func(GridVector) float64) (float64, bool) {
+	next, hasNext := grid[pt.Add(step)]
+	prev, hasPrev := grid[pt.Sub(step)]
+	switch {
+	case hasNext && hasPrev:
+		return (value(next) - value(prev)) / 2, true
+	case hasNext:
+		return value(next) - value(grid[pt]), true
+	case hasPrev:
+		return value(grid[pt]) - value(prev), true
+	}
+	return 0, false
+}
+
+// FindConvergenceZones returns the zones of cells whose divergence is below
+// -threshold, grouping cells that share an edge, strongest convergence
+// first. threshold is in the units of Divergence and should be positive.
+func FindConvergenceZones(data ExtrapolationData, threshold float64) []Zone {
+	div := Divergence(data)
+	converging := make(map[image.Point]bool)
+	for pt, d := range div {
+		if d < -threshold {
+			converging[pt] = true
+		}
+	}
+
+	var zones []Zone
+	seen := make(map[image.Point]bool)
+	for _, start := range sortedCells(converging) {
+		if seen[start] {
+			continue
+		}
+		seen[start] = true
+		var zone Zone
+		var sum float64
+		queue := []image.Point{start}
+		for len(queue) > 0 {
+			pt := queue[0]
+			queue = queue[1:]
+			zone.Cells = append(zone.Cells, pt)
+			zone.CentroidX += float64(pt.X)
+			zone.CentroidY += float64(pt.Y)
+			sum += div[pt]
+			for _, step := range []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+				next := pt.Add(step)
+				if converging[next] && !seen[next] {
+					seen[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		zone.Area = len(zone.Cells)
+		zone.CentroidX /= float64(zone.Area)
+		zone.CentroidY /= float64(zone.Area)
+		zone.MeanDivergence = sum / float64(zone.Area)
+		sortPoints(zone.Cells)
+		zones = append(zones, zone)
+	}
+	sort.SliceStable(zones, func(i, j int) bool {
+		return zones[i].MeanDivergence < zones[j].MeanDivergence
+	})
+	return zones
+}
+
+// sortedCells returns the cells of a set ordered row by row.
+func sortedCells(set map[image.Point]bool) []image.Point {
+	points := make([]image.Point, 0, len(set))
+	for pt := range set {
+		points = append(points, pt)
+	}
+	sortPoints(points)
+	return points
+}
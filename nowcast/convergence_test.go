@@ -0,0 +1,72 @@
+package nowcast
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// gridOf returns an 8x8 grid whose cell velocities are given by v.
+func gridOf(v func(pt image.Point) GridVector) ExtrapolationData {
+	data := ExtrapolationData{GridRes: 8, Data: make(map[image.Point]GridVector)}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			pt := image.Pt(x, y)
+			data.Data[pt] = v(pt)
+		}
+	}
+	return data
+}
+
+func TestFindConvergenceZones(t *testing.T) {
+	// Vectors point toward the line between columns 3 and 4.
+	convergent := gridOf(func(pt image.Point) GridVector {
+		if pt.X < 4 {
+			return GridVector{Vx: 1}
+		}
+		return GridVector{Vx: -1}
+	})
+	// A missing cell next to the line leaves its neighbours one-sided
+	// differences, which still see the convergence.
+	delete(convergent.Data, image.Pt(2, 5))
+
+	zones := FindConvergenceZones(convergent, 0.5)
+	if len(zones) != 1 {
+		t.Fatalf("Expected one zone along the line, got %d: %+v", len(zones), zones)
+	}
+	zone := zones[0]
+	if zone.Area != 16 || len(zone.Cells) != 16 {
+		t.Errorf("Expected the zone to cover columns 3 and 4, got %d cells: %v", zone.Area, zone.Cells)
+	}
+	for _, pt := range zone.Cells {
+		if pt.X != 3 && pt.X != 4 {
+			t.Errorf("Cell %v is off the convergence line", pt)
+		}
+	}
+	if math.Abs(zone.CentroidX-3.5) > 1e-9 || math.Abs(zone.CentroidY-3.5) > 1e-9 {
+		t.Errorf("Expected the centroid at (3.5, 3.5), got (%v, %v)", zone.CentroidX, zone.CentroidY)
+	}
+	// Every cell has a divergence of -1 but (3, 5), whose one-sided
+	// difference spans a single cell and gives -2.
+	if want := -17.0 / 16; math.Abs(zone.MeanDivergence-want) > 1e-9 {
+		t.Errorf("Expected a mean divergence of %v, got %v", want, zone.MeanDivergence)
+	}
+
+	uniform := gridOf(func(image.Point) GridVector { return GridVector{Vx: 2, Vy: -1} })
+	if zones := FindConvergenceZones(uniform, 0.5); len(zones) != 0 {
+		t.Errorf("Expected no zones in a uniform field, got %+v", zones)
+	}
+
+	// The quiver plot shades the zone and leaves the rest dark.
+	img := Quiver(convergent, 256, 256, QuiverOptions{Zones: zones})
+	if got := img.RGBAAt(3*32+2, 2); got != quiverZone {
+		t.Errorf("Expected the zone to be shaded, got %v", got)
+	}
+	if got := img.RGBAAt(2, 2); got != quiverBackground {
+		t.Errorf("Expected no shading away from the zone, got %v", got)
+	}
+	// The arrow of cell (0, 0) starts at its centre and points right.
+	if got := img.RGBAAt(16+2, 16); got != quiverArrow {
+		t.Errorf("Expected the arrow of cell (0, 0) at (18, 16), got %v", got)
+	}
+}
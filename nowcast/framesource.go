@@ -0,0 +1,151 @@
+//go:build cgo && !purego
+
+package nowcast
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultFrameCacheSize is the number of decoded frames a PathFrameSource
+// keeps when its capacity is not positive: the two frames of a flow pair.
+const DefaultFrameCacheSize = 2
+
+// FrameSource provides the frames of a sequence, ordered from oldest to
+// newest, without requiring all of them to be in memory at once.
+//
+// The Mat returned by At belongs to the source and is only valid until the
+// next call to At or Close; callers that keep a frame longer clone it. Close
+// releases whatever the source holds.
+type FrameSource interface {
+	// Len returns the number of frames.
+	Len() int
+	// At returns frame i, for 0 <= i < Len().
+	At(i int) (gocv.Mat, error)
+	// Close releases the frames held by the source.
+	Close() error
+}
+
+// MatFrameSource is a FrameSource over frames already in memory. It returns
+// the frames themselves and never closes them.
+type MatFrameSource []gocv.Mat
+
+// Len returns the number of frames.
+func (s MatFrameSource) Len() int { return len(s) }
+
+// At returns frame i.
+func (s MatFrameSource) At(i int) (gocv.Mat, error) {
+	if i < 0 || i >= len(s) {
+		return gocv.Mat{}, fmt.Errorf("frame %d out of range [0, %d)", i, len(s))
+	}
+	return s[i], nil
+}
+
+// Close does nothing: the frames belong to the caller.
+func (s MatFrameSource) Close() error { return nil }
+
+// PathFrameSource is a FrameSource over image files that decodes each frame
+// with LoadGrayscaleImage when it is requested, keeping the most recently
+// used decoded frames so that a frame requested again soon is not decoded
+// again.
+type PathFrameSource struct {
+	paths    []string
+	capacity int
+	// cached holds the decoded frames, least recently used first.
+	cached []cachedFrame
+	// decode loads a frame; tests replace it to count decodes.
+	decode func(path string) (gocv.Mat, error)
+}
+
+// cachedFrame is a decoded frame of a PathFrameSource.
+type cachedFrame struct {
+	index int
+	mat   gocv.Mat
+}
+
+// NewPathFrameSource returns a FrameSource over the images at paths that
+// keeps at most capacity decoded frames, or DefaultFrameCacheSize if
+// capacity is not positive. Close it to release them.
+func NewPathFrameSource(paths []string, capacity int) *PathFrameSource {
+	if capacity <= 0 {
+		capacity = DefaultFrameCacheSize
+	}
+	return &PathFrameSource{paths: paths, capacity: capacity, decode: LoadGrayscaleImage}
+}
+
+// Len returns the number of frames.
+func (s *PathFrameSource) Len() int { return len(s.paths) }
+
+// At returns frame i, decoding it unless it is cached. Decoding it may
+// evict, and close, the least recently used frame.
+func (s *PathFrameSource) At(i int) (gocv.Mat, error) {
+	if i < 0 || i >= len(s.paths) {
+		return gocv.Mat{}, fmt.Errorf("frame %d out of range [0, %d)", i, len(s.paths))
+	}
+	for j, c := range s.cached {
+		if c.index == i {
+			// Move the frame to the most recently used end.
+			copy(s.cached[j:], s.cached[j+1:])
+			s.cached[len(s.cached)-1] = c
+			return c.mat, nil
+		}
+	}
+
+	mat, err := s.decode(s.paths[i])
+	if err != nil {
+		return gocv.Mat{}, err
+	}
+	if len(s.cached) == s.capacity {
+		s.cached[0].mat.Close()
+		s.cached = append(s.cached[:0], s.cached[1:]...)
+	}
+	s.cached = append(s.cached, cachedFrame{index: i, mat: mat})
+	return mat, nil
+}
+
+// Close closes the cached frames. The source may be used again afterwards,
+// decoding frames afresh.
+func (s *PathFrameSource) Close() error {
+	for _, c := range s.cached {
+		c.mat.Close()
+	}
+	s.cached = nil
+	return nil
+}
+
+// ProcessFrames is like ProcessImagesWithOptions for frames read from src,
+// ordered from oldest to newest. Every frame must be an 8-bit single-channel
+// Mat. It always uses the OpenCV backend, requests each frame once, in
+// order, and does not close src.
+func ProcessFrames(src FrameSource, gridRes int, timeStep float64, opts Options) (ExtrapolationData, error) {
+	if src.Len() < 3 {
+		return ExtrapolationData{}, fmt.Errorf("at least 3 frames are required, but got %d", src.Len())
+	}
+	if opts.Backend == BackendPureGo {
+		return ExtrapolationData{}, fmt.Errorf("ProcessFrames only supports the OpenCV backend")
+	}
+	if err := opts.Preprocess.validate(); err != nil {
+		return ExtrapolationData{}, err
+	}
+	if err := opts.AccelerationLimit.validate(); err != nil {
+		return ExtrapolationData{}, err
+	}
+
+	var history []map[image.Point]GridVector
+	err := farnebackFlows(src.Len(), func(i int) (gocv.Mat, error) {
+		f, err := src.At(i)
+		if err != nil {
+			return gocv.Mat{}, err
+		}
+		if f.Empty() || f.Type() != gocv.MatTypeCV8U {
+			return gocv.Mat{}, fmt.Errorf("frame %d is not an 8-bit single-channel image", i)
+		}
+		return opts.Preprocess.applyMat(f), nil
+	}, gridHistory(gridRes, &history))
+	if err != nil {
+		return ExtrapolationData{}, err
+	}
+	return fitGridHistory(history, gridRes, timeStep, opts)
+}
@@ -0,0 +1,94 @@
+//go:build cgo && !purego
+
+package nowcast
+
+import (
+	"reflect"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// countingSource is a FrameSource that counts the requests for each frame of
+// the source it wraps.
+type countingSource struct {
+	FrameSource
+	requests []int
+}
+
+func (s *countingSource) At(i int) (gocv.Mat, error) {
+	s.requests[i]++
+	return s.FrameSource.At(i)
+}
+
+// countDecodes makes src count the frames it decodes, by index.
+func countDecodes(src *PathFrameSource, paths []string) []int {
+	decodes := make([]int, len(paths))
+	index := make(map[string]int, len(paths))
+	for i, path := range paths {
+		index[path] = i
+	}
+	src.decode = func(path string) (gocv.Mat, error) {
+		decodes[index[path]]++
+		return LoadGrayscaleImage(path)
+	}
+	return decodes
+}
+
+func TestProcessFramesDecodesEachFrameOnce(t *testing.T) {
+	paths := createTestSequence(t, 5, 64, 64, 16, 10, 20, 2, 1)
+	want, err := ProcessImagesWithOptions(paths, 8, 1.0, Options{Backend: BackendOpenCV})
+	if err != nil {
+		t.Fatalf("ProcessImagesWithOptions failed: %v", err)
+	}
+
+	src := NewPathFrameSource(paths, 1)
+	defer src.Close()
+	decodes := countDecodes(src, paths)
+	counting := &countingSource{FrameSource: src, requests: make([]int, len(paths))}
+	got, err := ProcessFrames(counting, 8, 1.0, Options{})
+	if err != nil {
+		t.Fatalf("ProcessFrames failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessFrames differs from ProcessImagesWithOptions")
+	}
+	for i := range paths {
+		if counting.requests[i] != 1 || decodes[i] != 1 {
+			t.Errorf("frame %d requested %d times and decoded %d times, want 1 and 1", i, counting.requests[i], decodes[i])
+		}
+	}
+}
+
+func TestPathFrameSourceEvictsLeastRecentlyUsed(t *testing.T) {
+	paths := createTestSequence(t, 4, 32, 32, 8, 4, 4, 1, 0)
+	src := NewPathFrameSource(paths, 2)
+	defer src.Close()
+	decodes := countDecodes(src, paths)
+
+	// With room for 2 frames, 0 is evicted by 2 and decoded again, 2 is
+	// evicted by that, and 1 by 3; the last request for 0 is a hit.
+	for _, i := range []int{0, 1, 2, 1, 0, 3, 0} {
+		mat, err := src.At(i)
+		if err != nil {
+			t.Fatalf("At(%d) failed: %v", i, err)
+		}
+		if mat.Empty() || mat.Rows() != 32 {
+			t.Fatalf("At(%d) returned a %dx%d frame", i, mat.Cols(), mat.Rows())
+		}
+	}
+	if want := []int{2, 1, 1, 1}; !reflect.DeepEqual(decodes, want) {
+		t.Errorf("decodes = %v, want %v", decodes, want)
+	}
+	if _, err := src.At(len(paths)); err == nil {
+		t.Errorf("At(%d) succeeded past the end", len(paths))
+	}
+
+	src.Close()
+	if _, err := src.At(0); err != nil {
+		t.Fatalf("At(0) after Close failed: %v", err)
+	}
+	if decodes[0] != 3 {
+		t.Errorf("frame 0 decoded %d times after Close, want 3", decodes[0])
+	}
+}
@@ -0,0 +1,322 @@
+package nowcast
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// DefaultHierarchyLevels is the default HierarchyOptions.Levels.
+const DefaultHierarchyLevels = 2
+
+// HierarchyOptions configures the coarse-to-fine grid of
+// ProcessImagesHierarchical.
+type HierarchyOptions struct {
+	// Levels is the number of times a coarse cell may be split into 2x2
+	// finer cells. Zero means DefaultHierarchyLevels.
+	Levels int
+	// VarianceThreshold is the internal velocity variance, in pixels^2 per
+	// frame^2, above which a cell is split: the sum of the variances of the
+	// x and y velocities of its pixels in the last flow field. It must be
+	// positive.
+	VarianceThreshold float64
+}
+
+func (o HierarchyOptions) levels() int {
+	if o.Levels == 0 {
+		return DefaultHierarchyLevels
+	}
+	return o.Levels
+}
+
+func (o HierarchyOptions) validate() error {
+	if o.Levels < 0 {
+		return fmt.Errorf("hierarchy levels must not be negative, got %d", o.Levels)
+	}
+	if !(o.VarianceThreshold > 0) {
+		return fmt.Errorf("hierarchy variance threshold must be positive, got %v", o.VarianceThreshold)
+	}
+	return nil
+}
+
+// GridCell identifies a cell of a HierarchicalData grid: cell (X, Y) of the
+// grid at Level, which has GridRes<<Level cells on each side. Level 0 is the
+// coarse grid, and cell (X, Y) at Level covers cells (2X, 2Y) to
+// (2X+1, 2Y+1) at Level+1.
+type GridCell struct {
+	Level int
+	X, Y  int
+}
+
+// HierarchicalData is an ExtrapolationData whose cells vary in size: coarse
+// cells where the motion is uniform and finer ones, down to Levels splits,
+// where it is not. Data holds the leaf cells, which cover every pixel with
+// data exactly once. VelocityAt interpolates the cells at any pixel.
+type HierarchicalData struct {
+	GridRes int // The resolution of the coarse grid
+	Levels  int // The most times a coarse cell was split
+	// Width and Height are the size of the flow fields in pixels.
+	Width, Height int
+	// Data maps each leaf cell to its motion vector.
+	Data map[GridCell]GridVector
+	// OutlierCells, OutlierSamples and ClampedAccelerations are as for
+	// ExtrapolationData, counted over the leaf cells.
+	OutlierCells         int
+	OutlierSamples       int
+	ClampedAccelerations int
+}
+
+// Cells returns the leaf cells ordered by level, then row by row.
+func (h HierarchicalData) Cells() []GridCell {
+	cells := make([]GridCell, 0, len(h.Data))
+	for c := range h.Data {
+		cells = append(cells, c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		a, b := cells[i], cells[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+	return cells
+}
+
+// Bounds returns the pixels covered by c.
+func (h HierarchicalData) Bounds(c GridCell) image.Rectangle {
+	n := h.GridRes << c.Level
+	return image.Rect(cellStart(c.X, h.Width, n), cellStart(c.Y, h.Height, n),
+		cellStart(c.X+1, h.Width, n), cellStart(c.Y+1, h.Height, n))
+}
+
+// cellStart returns the first of size pixels that falls in cell i or later
+// when they are split into n cells as by gridCellIndex.
+func cellStart(i, size, n int) int {
+	if i >= n {
+		return size
+	}
+	// Start from the exact boundary and correct for its rounding.
+	x := int(math.Ceil(float64(i) * float64(size) / float64(n)))
+	for x > 0 && gridCellIndex(x-1, size, n) >= i {
+		x--
+	}
+	for x < size && gridCellIndex(x, size, n) < i {
+		x++
+	}
+	return x
+}
+
+// CellAt returns the leaf cell covering pixel (x, y), if it holds data.
+func (h HierarchicalData) CellAt(x, y int) (GridCell, bool) {
+	if x < 0 || y < 0 || x >= h.Width || y >= h.Height {
+		return GridCell{}, false
+	}
+	for level := 0; level <= h.Levels; level++ {
+		n := h.GridRes << level
+		c := GridCell{Level: level, X: gridCellIndex(x, h.Width, n), Y: gridCellIndex(y, h.Height, n)}
+		if _, ok := h.Data[c]; ok {
+			return c, true
+		}
+	}
+	return GridCell{}, false
+}
+
+// VelocityAt returns the motion vector at (x, y), in pixels with pixel
+// (i, j) covering [i, i+1) x [j, j+1). It interpolates bilinearly between
+// the centres of the cells around (x, y) at the level of the leaf covering
+// it, so the interpolation is as fine as the grid is there. Samples falling
+// on cells without data are left out, and the result is unreliable if any
+// sample used is. It returns false if no leaf covers (x, y).
+func (h HierarchicalData) VelocityAt(x, y float64) (GridVector, bool) {
+	leaf, ok := h.CellAt(int(math.Floor(x)), int(math.Floor(y)))
+	if !ok {
+		return GridVector{}, false
+	}
+	n := h.GridRes << leaf.Level
+	cellW := float64(h.Width) / float64(n)
+	cellH := float64(h.Height) / float64(n)
+	ux, uy := x/cellW-0.5, y/cellH-0.5
+	x0, y0 := math.Floor(ux), math.Floor(uy)
+	fx, fy := ux-x0, uy-y0
+
+	wx := [2]float64{1 - fx, fx}
+	wy := [2]float64{1 - fy, fy}
+	var sum GridVector
+	var weights float64
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 2; i++ {
+			w := wx[i] * wy[j]
+			if w == 0 {
+				continue
+			}
+			// Sample the leaf at the centre of the neighbouring cell, which
+			// may be finer or coarser than it.
+			cx := max(0, min(int(x0)+i, n-1))
+			cy := max(0, min(int(y0)+j, n-1))
+			px := min(int(math.Floor((float64(cx)+0.5)*cellW)), h.Width-1)
+			py := min(int(math.Floor((float64(cy)+0.5)*cellH)), h.Height-1)
+			c, ok := h.CellAt(px, py)
+			if !ok {
+				continue
+			}
+			v := h.Data[c]
+			sum.Vx += w * v.Vx
+			sum.Vy += w * v.Vy
+			sum.Ax += w * v.Ax
+			sum.Ay += w * v.Ay
+			sum.Unreliable = sum.Unreliable || v.Unreliable
+			weights += w
+		}
+	}
+	if weights == 0 {
+		return h.Data[leaf], true
+	}
+	sum.Vx /= weights
+	sum.Vy /= weights
+	sum.Ax /= weights
+	sum.Ay /= weights
+	return sum, true
+}
+
+// ProcessImagesHierarchical is like ProcessImagesWithOptions but returns a
+// coarse-to-fine grid: trimmed mean velocities are computed on the gridRes
+// grid and on grids up to hopts.Levels times finer, and each cell whose
+// internal velocity variance in the last flow field exceeds
+// hopts.VarianceThreshold is replaced by its four finer cells, recursively.
+// The velocity history of each remaining cell is then fitted as by
+// ProcessImages.
+func ProcessImagesHierarchical(imagePaths []string, gridRes int, timeStep float64, opts Options, hopts HierarchyOptions) (HierarchicalData, error) {
+	numFrames := len(imagePaths)
+	if numFrames < 3 {
+		return HierarchicalData{}, fmt.Errorf("at least 3 image frames are required, but got %d", numFrames)
+	}
+	if err := opts.Preprocess.validate(); err != nil {
+		return HierarchicalData{}, err
+	}
+	if err := opts.AccelerationLimit.validate(); err != nil {
+		return HierarchicalData{}, err
+	}
+	if err := hopts.validate(); err != nil {
+		return HierarchicalData{}, err
+	}
+	b := newHierarchyBuilder(gridRes, hopts.levels())
+	if err := visitFlows(imagePaths, opts, b.visit); err != nil {
+		return HierarchicalData{}, err
+	}
+	return b.build(timeStep, opts, hopts.VarianceThreshold)
+}
+
+// HierarchicalFromFields is ProcessImagesHierarchical for flow fields that
+// are already computed, ordered from oldest to newest.
+func HierarchicalFromFields(fields []FlowField, gridRes int, timeStep float64, opts Options, hopts HierarchyOptions) (HierarchicalData, error) {
+	if len(fields) == 0 {
+		return HierarchicalData{}, fmt.Errorf("at least 1 flow field is required")
+	}
+	if err := opts.AccelerationLimit.validate(); err != nil {
+		return HierarchicalData{}, err
+	}
+	if err := hopts.validate(); err != nil {
+		return HierarchicalData{}, err
+	}
+	b := newHierarchyBuilder(gridRes, hopts.levels())
+	for i, field := range fields {
+		rows, cols, at, err := fieldFlow(field)
+		if err != nil {
+			return HierarchicalData{}, fmt.Errorf("flow field %d: %w", i, err)
+		}
+		b.visit(rows, cols, at)
+	}
+	return b.build(timeStep, opts, hopts.VarianceThreshold)
+}
+
+// hierarchyBuilder collects the grid velocities of a sequence of flow fields
+// at every level of a HierarchicalData grid.
+type hierarchyBuilder struct {
+	gridRes, levels int
+	rows, cols      int
+	// history holds the grid velocity history of each level.
+	history [][]map[image.Point]GridVector
+	// variance holds the internal velocity variance of each cell of each
+	// level in the last flow field.
+	variance []map[image.Point]float64
+}
+
+func newHierarchyBuilder(gridRes, levels int) *hierarchyBuilder {
+	return &hierarchyBuilder{
+		gridRes:  gridRes,
+		levels:   levels,
+		history:  make([][]map[image.Point]GridVector, levels+1),
+		variance: make([]map[image.Point]float64, levels+1),
+	}
+}
+
+// visit is the flowVisitor adding a flow field.
+func (b *hierarchyBuilder) visit(rows, cols int, at func(y, x int) (vx, vy float64)) {
+	b.rows, b.cols = rows, cols
+	for level := 0; level <= b.levels; level++ {
+		samples := gridSamples(rows, cols, b.gridRes<<level, at)
+		velocities := make(map[image.Point]GridVector, len(samples))
+		variance := make(map[image.Point]float64, len(samples))
+		for pt, data := range samples {
+			velocities[pt] = data.mean()
+			variance[pt] = data.variance()
+		}
+		b.history[level] = append(b.history[level], velocities)
+		b.variance[level] = variance
+	}
+}
+
+// build splits the cells of the last flow field whose variance exceeds
+// threshold and fits the history of the resulting leaves.
+func (b *hierarchyBuilder) build(timeStep float64, opts Options, threshold float64) (HierarchicalData, error) {
+	numFlows := len(b.history[0])
+	if numFlows == 0 {
+		return HierarchicalData{}, fmt.Errorf("no grid velocities to fit")
+	}
+	h := HierarchicalData{
+		GridRes: b.gridRes,
+		Levels:  b.levels,
+		Width:   b.cols,
+		Height:  b.rows,
+		Data:    make(map[GridCell]GridVector),
+	}
+	times := fitTimes(numFlows, timeStep)
+	var add func(c GridCell)
+	add = func(c GridCell) {
+		if c.Level < b.levels && b.variance[c.Level][image.Pt(c.X, c.Y)] > threshold {
+			var children []GridCell
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					child := GridCell{Level: c.Level + 1, X: 2*c.X + dx, Y: 2*c.Y + dy}
+					if _, ok := b.variance[child.Level][image.Pt(child.X, child.Y)]; ok {
+						children = append(children, child)
+					}
+				}
+			}
+			if len(children) > 0 {
+				for _, child := range children {
+					add(child)
+				}
+				return
+			}
+		}
+		vec, outliers := fitCell(b.history[c.Level], image.Pt(c.X, c.Y), times, opts)
+		if outliers > 0 {
+			h.OutlierCells++
+			h.OutlierSamples += outliers
+		}
+		vec, clamped := opts.AccelerationLimit.clamp(vec, timeStep)
+		if clamped {
+			h.ClampedAccelerations++
+		}
+		h.Data[c] = vec
+	}
+	for _, pt := range sortedGridPoints(b.history[0][numFlows-1]) {
+		add(GridCell{X: pt.X, Y: pt.Y})
+	}
+	return h, nil
+}
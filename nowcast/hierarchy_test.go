@@ -0,0 +1,111 @@
+package nowcast
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// shearField returns a size x size flow field moving down at speed left of
+// column line and up at speed from it on.
+func shearField(size, line int, speed float32) FlowField {
+	field := FlowField{Vx: make([][]float32, size), Vy: make([][]float32, size)}
+	for y := range field.Vx {
+		field.Vx[y] = make([]float32, size)
+		field.Vy[y] = make([]float32, size)
+		for x := range field.Vy[y] {
+			field.Vy[y][x] = speed
+			if x >= line {
+				field.Vy[y][x] = -speed
+			}
+		}
+	}
+	return field
+}
+
+func TestHierarchicalRefinesAlongShearLine(t *testing.T) {
+	// The line runs through the second column of the 4x4 coarse grid of
+	// 64-pixel cells, away from any cell boundary.
+	const size, line, gridRes = 256, 100, 4
+	field := shearField(size, line, 4)
+	fields := []FlowField{field, field}
+
+	grid, err := HierarchicalFromFields(fields, gridRes, 1, Options{}, HierarchyOptions{VarianceThreshold: 1})
+	if err != nil {
+		t.Fatalf("HierarchicalFromFields failed: %v", err)
+	}
+	single, err := HierarchicalFromFields(fields, gridRes, 1, Options{}, HierarchyOptions{VarianceThreshold: math.Inf(1)})
+	if err != nil {
+		t.Fatalf("HierarchicalFromFields failed: %v", err)
+	}
+	if len(single.Data) != gridRes*gridRes {
+		t.Fatalf("Expected the single-resolution grid to have %d cells, got %d", gridRes*gridRes, len(single.Data))
+	}
+
+	covered := 0
+	for _, c := range grid.Cells() {
+		bounds := grid.Bounds(c)
+		covered += bounds.Dx() * bounds.Dy()
+		switch {
+		case bounds.Min.X < line && bounds.Max.X > line:
+			if c.Level != DefaultHierarchyLevels {
+				t.Errorf("Cell %+v %v crosses the line but was not fully refined", c, bounds)
+			}
+		case bounds.Max.X <= 64 || bounds.Min.X >= 128:
+			if c.Level != 0 {
+				t.Errorf("Cell %+v %v away from the line was refined", c, bounds)
+			}
+		}
+	}
+	if covered != size*size {
+		t.Errorf("Expected the leaves to cover %d pixels, got %d", size*size, covered)
+	}
+
+	// Away from the line, both grids hold the coarse values.
+	for _, pt := range []image.Point{{32, 32}, {224, 200}} {
+		fine, _ := grid.VelocityAt(float64(pt.X), float64(pt.Y))
+		coarse, _ := single.VelocityAt(float64(pt.X), float64(pt.Y))
+		if math.Abs(fine.Vy-coarse.Vy) > 1e-9 {
+			t.Errorf("At %v the grids differ: %v vs %v", pt, fine.Vy, coarse.Vy)
+		}
+	}
+
+	// Across the line, the refined grid is sharper.
+	jump := func(h HierarchicalData) float64 {
+		left, ok := h.VelocityAt(line-20, 128)
+		right, ok2 := h.VelocityAt(line+20, 128)
+		if !ok || !ok2 {
+			t.Fatal("VelocityAt found no data near the line")
+		}
+		return left.Vy - right.Vy
+	}
+	fineJump, coarseJump := jump(grid), jump(single)
+	t.Logf("velocity jump across the line: %.2f refined, %.2f single-resolution", fineJump, coarseJump)
+	if fineJump < 7 || fineJump < 2*coarseJump {
+		t.Errorf("Expected the refined grid to resolve the jump of 8, got %.2f against %.2f", fineJump, coarseJump)
+	}
+}
+
+func TestHierarchicalMatchesSingleResolution(t *testing.T) {
+	field := shearField(64, 20, 2)
+	grid, err := HierarchicalFromFields([]FlowField{field}, 4, 1, Options{}, HierarchyOptions{VarianceThreshold: math.Inf(1)})
+	if err != nil {
+		t.Fatalf("HierarchicalFromFields failed: %v", err)
+	}
+	want, err := CalculateGridVelocitiesFromField(field, 4)
+	if err != nil {
+		t.Fatalf("CalculateGridVelocitiesFromField failed: %v", err)
+	}
+	for pt, v := range want {
+		if got := grid.Data[GridCell{X: pt.X, Y: pt.Y}]; got != v {
+			t.Errorf("Cell %v: got %+v, want %+v", pt, got, v)
+		}
+	}
+
+	if _, err := HierarchicalFromFields([]FlowField{field}, 4, 1, Options{}, HierarchyOptions{}); err == nil {
+		t.Error("Expected a zero variance threshold to be rejected")
+	}
+	if _, ok := grid.VelocityAt(64, 0); ok {
+		t.Error("Expected no velocity outside the field")
+	}
+}
@@ -0,0 +1,43 @@
+package nowcast
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrNoHistory is returned by ExportHistoryCSV for data computed without
+// Options.KeepHistory.
+var ErrNoHistory = errors.New("nowcast: no velocity history kept")
+
+// ExportHistoryCSV writes the grid velocity history of e as CSV with the
+// columns t, cellX, cellY, vx and vy: one row per cell of every flow field,
+// oldest flow field first and its cells row by row, t being its
+// HistoryTimes entry.
+func (e ExtrapolationData) ExportHistoryCSV(w io.Writer) error {
+	if len(e.History) == 0 || len(e.HistoryTimes) != len(e.History) {
+		return ErrNoHistory
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"t", "cellX", "cellY", "vx", "vy"}); err != nil {
+		return fmt.Errorf("failed to write history header: %w", err)
+	}
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	for i, cells := range e.History {
+		for _, pt := range sortedGridPoints(cells) {
+			v := cells[pt]
+			if err := cw.Write([]string{format(e.HistoryTimes[i]), strconv.Itoa(pt.X), strconv.Itoa(pt.Y), format(v.Vx), format(v.Vy)}); err != nil {
+				return fmt.Errorf("failed to write history of flow field %d: %w", i, err)
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write velocity history: %w", err)
+	}
+	return nil
+}
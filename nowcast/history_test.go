@@ -0,0 +1,106 @@
+package nowcast
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"example/goflow/flow/synth"
+	"image"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestKeepHistory checks that the history kept over a sequence of three
+// frame pairs reproduces the fit, directly and through its CSV export.
+func TestKeepHistory(t *testing.T) {
+	const gridRes, timeStep = 4, 5.0
+	// A square moving over a textured background gives every cell a
+	// velocity in every flow field.
+	paths := writeSequence(t, synth.MotionSpec{Blobs: []synth.Blob{{
+		Shape:  synth.BlobSquare,
+		Center: synth.Point{X: 85, Y: 95},
+		Size:   55,
+		Motion: synth.Motion{Translate: synth.Point{X: 10}},
+	}}}, 4, 256, 256)
+	data, err := ProcessImagesWithOptions(paths, gridRes, timeStep, Options{KeepHistory: true})
+	if err != nil {
+		t.Fatalf("ProcessImagesWithOptions failed: %v", err)
+	}
+	if len(data.History) != 3 || !reflect.DeepEqual(data.HistoryTimes, []float64{-10, -5, 0}) {
+		t.Fatalf("Expected 3 flow fields at times [-10 -5 0], got %d at %v", len(data.History), data.HistoryTimes)
+	}
+	plain, err := ProcessImages(paths, gridRes, timeStep)
+	if err != nil {
+		t.Fatalf("ProcessImages failed: %v", err)
+	}
+	if plain.History != nil || plain.HistoryTimes != nil {
+		t.Error("Expected no history without KeepHistory")
+	}
+	if !reflect.DeepEqual(data.Data, plain.Data) {
+		t.Error("Expected KeepHistory not to change the fit")
+	}
+	refit, err := fitGridHistory(data.History, gridRes, timeStep, Options{})
+	if err != nil {
+		t.Fatalf("fitGridHistory failed: %v", err)
+	}
+	if !reflect.DeepEqual(refit.Data, data.Data) {
+		t.Error("Expected the history to reproduce the fit")
+	}
+
+	var buf bytes.Buffer
+	if err := data.ExportHistoryCSV(&buf); err != nil {
+		t.Fatalf("ExportHistoryCSV failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse the CSV: %v", err)
+	}
+	if !reflect.DeepEqual(rows[0], []string{"t", "cellX", "cellY", "vx", "vy"}) {
+		t.Fatalf("Unexpected header %v", rows[0])
+	}
+	cells := 0
+	for _, grid := range data.History {
+		cells += len(grid)
+	}
+	if len(rows)-1 != cells {
+		t.Fatalf("Expected a row per cell of every flow field, %d, got %d", cells, len(rows)-1)
+	}
+	// Fit every cell from the CSV alone.
+	type sample struct{ times, vx, vy []float64 }
+	samples := map[image.Point]*sample{}
+	parse := func(s string) float64 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			t.Fatalf("Bad CSV value %q: %v", s, err)
+		}
+		return v
+	}
+	for _, row := range rows[1:] {
+		pt := image.Pt(int(parse(row[1])), int(parse(row[2])))
+		if samples[pt] == nil {
+			samples[pt] = &sample{}
+		}
+		s := samples[pt]
+		s.times = append(s.times, parse(row[0]))
+		s.vx = append(s.vx, parse(row[3]))
+		s.vy = append(s.vy, parse(row[4]))
+	}
+	for pt, want := range data.Data {
+		s := samples[pt]
+		if s == nil || len(s.times) != 3 {
+			t.Errorf("Cell %v: expected 3 samples in the CSV, got %v", pt, s)
+			continue
+		}
+		vx, ax := FitPolynomial(s.times, s.vx)
+		vy, ay := FitPolynomial(s.times, s.vy)
+		if math.Abs(vx-want.Vx) > 1e-9 || math.Abs(vy-want.Vy) > 1e-9 || math.Abs(ax-want.Ax) > 1e-9 || math.Abs(ay-want.Ay) > 1e-9 {
+			t.Errorf("Cell %v: expected the CSV to fit to %+v, got (%v, %v, %v, %v)", pt, want, vx, vy, ax, ay)
+		}
+	}
+
+	if err := plain.ExportHistoryCSV(&buf); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("Expected ErrNoHistory without KeepHistory, got %v", err)
+	}
+}
@@ -9,8 +9,6 @@ import (
 	"math"
 	"os"
 	"sort"
-
-	"gocv.io/x/gocv"
 )
 
 // GridVector holds the extrapolated motion parameters for a single grid cell.
@@ -21,6 +19,10 @@ type GridVector struct {
 	Vy float64
 	Ax float64
 	Ay float64
+	// Unreliable is set when the cell's velocity history contained samples
+	// above Options.MaxCellSpeed and the policy is SpeedPolicyUnreliable, or
+	// when every sample was dropped under SpeedPolicyDrop.
+	Unreliable bool
 }
 
 // ExtrapolationData holds the complete set of motion vectors for the grid.
@@ -28,43 +30,149 @@ type ExtrapolationData struct {
 	GridRes int // The resolution (e.g., 64) of the grid
 	// Data maps a grid coordinate (e.g., [0,0], [0,1]) to its motion vector
 	Data map[image.Point]GridVector
+	// OutlierCells is the number of cells with at least one velocity sample
+	// above Options.MaxCellSpeed, and OutlierSamples the total number of
+	// such samples across all frames.
+	OutlierCells   int
+	OutlierSamples int
+	// ClampedAccelerations is the number of cells whose acceleration
+	// Options.AccelerationLimit, or ClampAccelerations, scaled down.
+	ClampedAccelerations int
+	// History holds, under Options.KeepHistory, the grid velocities of
+	// every flow field, oldest first, as they were before the fit: Vx and
+	// Vy only, without Options.SpeedPolicy applied. HistoryTimes holds the
+	// time the fit used for each, in the unit of timeStep with t=0 at the
+	// last one. See ExportHistoryCSV.
+	History      []map[image.Point]GridVector
+	HistoryTimes []float64
 }
 
-// LoadGrayscaleImage loads a PNG, decodes it, and converts it to a grayscale gocv.Mat.
-func LoadGrayscaleImage(filePath string) (gocv.Mat, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return gocv.Mat{}, fmt.Errorf("failed to open image file %s: %w", filePath, err)
-	}
-	defer file.Close()
+// SpeedPolicy selects how ProcessImagesWithOptions treats grid velocities
+// that exceed Options.MaxCellSpeed.
+type SpeedPolicy int
+
+const (
+	// SpeedPolicyClamp scales the offending velocity down to MaxCellSpeed,
+	// keeping its direction.
+	SpeedPolicyClamp SpeedPolicy = iota
+	// SpeedPolicyDrop removes the offending sample from the cell's history
+	// and fits over the remaining samples.
+	SpeedPolicyDrop
+	// SpeedPolicyUnreliable keeps the history unchanged but marks the cell
+	// as unreliable.
+	SpeedPolicyUnreliable
+)
 
-	img, err := png.Decode(file)
-	if err != nil {
-		return gocv.Mat{}, fmt.Errorf("failed to decode png %s: %w", filePath, err)
-	}
-
-	// Convert to RGBA first, as gocv.ImageToMatRGBA is robust
-	rgbaImg, ok := img.(*image.RGBA)
-	if !ok {
-		// If not RGBA, create a new RGBA image and draw the decoded image onto it
-		bounds := img.Bounds()
-		rgbaImg = image.NewRGBA(bounds)
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				rgbaImg.Set(x, y, img.At(x, y))
+// Backend selects the dense optical flow implementation used by
+// ProcessImagesWithOptions.
+type Backend int
+
+const (
+	// BackendDefault uses OpenCV when the package is built with it and the
+	// pure Go backend otherwise (with the purego build tag or without cgo).
+	BackendDefault Backend = iota
+	// BackendOpenCV uses OpenCV's Farneback flow through gocv.
+	BackendOpenCV
+	// BackendPureGo uses BlockMatchFlow, which needs neither OpenCV nor cgo.
+	// See BlockMatchFlow for its accuracy.
+	BackendPureGo
+)
+
+// Options configures ProcessImagesWithOptions.
+type Options struct {
+	// Backend selects the optical flow implementation.
+	Backend Backend
+	// MaxCellSpeed is the largest plausible grid cell speed in pixels per
+	// frame. Zero disables the check.
+	MaxCellSpeed float64
+	// SpeedPolicy selects what happens to samples above MaxCellSpeed.
+	SpeedPolicy SpeedPolicy
+	// Preprocess filters every frame before the flow is computed.
+	Preprocess Preprocess
+	// KeepHistory returns the grid velocities of every flow field in
+	// ExtrapolationData.History instead of discarding them after the fit,
+	// for fitting other temporal models offline. They take a map of up to
+	// gridRes^2 cells per flow field, so the memory grows with the length
+	// of the sequence.
+	KeepHistory bool
+	// AccelerationLimit bounds the fitted accelerations, with MaxLead in
+	// frames and the accelerations converted by the time step of the fit.
+	// The zero value leaves them as fitted.
+	AccelerationLimit AccelerationLimit
+}
+
+// Points returns the grid coordinates present in Data ordered row by row
+// (by Y, then X). Iterate over it instead of ranging over Data directly when
+// the output order matters.
+func (e ExtrapolationData) Points() []image.Point {
+	return sortedGridPoints(e.Data)
+}
+
+// VelocityAt returns the velocity and acceleration at (x, y) of an
+// imageWidth x imageHeight image split into GridRes x GridRes cells, in
+// pixels with pixel (i, j) covering [i, i+1) x [j, j+1). It interpolates
+// bilinearly between the centres of the four cells around (x, y), so
+// forecasts advected with it are not blocky along cell edges. Cells without
+// data or marked Unreliable are left out and the weights of the others
+// renormalized, and within half a cell of the image border the nearest
+// cells are used. It returns false if (x, y) lies outside the image or none
+// of the four cells has reliable data.
+func (e ExtrapolationData) VelocityAt(x, y float64, imageWidth, imageHeight int) (vx, vy, ax, ay float64, ok bool) {
+	if e.GridRes <= 0 || imageWidth <= 0 || imageHeight <= 0 ||
+		!(x >= 0 && x < float64(imageWidth) && y >= 0 && y < float64(imageHeight)) {
+		return 0, 0, 0, 0, false
+	}
+	n := e.GridRes
+	ux := x/(float64(imageWidth)/float64(n)) - 0.5
+	uy := y/(float64(imageHeight)/float64(n)) - 0.5
+	x0, y0 := math.Floor(ux), math.Floor(uy)
+	fx, fy := ux-x0, uy-y0
+
+	wx := [2]float64{1 - fx, fx}
+	wy := [2]float64{1 - fy, fy}
+	var weights float64
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 2; i++ {
+			w := wx[i] * wy[j]
+			if w == 0 {
+				continue
+			}
+			pt := image.Point{X: max(0, min(int(x0)+i, n-1)), Y: max(0, min(int(y0)+j, n-1))}
+			v, found := e.Data[pt]
+			if !found || v.Unreliable {
+				continue
 			}
+			vx += w * v.Vx
+			vy += w * v.Vy
+			ax += w * v.Ax
+			ay += w * v.Ay
+			weights += w
 		}
 	}
+	if weights == 0 {
+		return 0, 0, 0, 0, false
+	}
+	return vx / weights, vy / weights, ax / weights, ay / weights, true
+}
 
-	matRGBA, err := gocv.ImageToMatRGBA(rgbaImg)
-	if err != nil {
-		return gocv.Mat{}, fmt.Errorf("failed to convert image to MatRGBA: %w", err)
+// sortedGridPoints returns the keys of a grid map ordered by Y, then X.
+func sortedGridPoints(grid map[image.Point]GridVector) []image.Point {
+	points := make([]image.Point, 0, len(grid))
+	for pt := range grid {
+		points = append(points, pt)
 	}
-	defer matRGBA.Close()
+	sortPoints(points)
+	return points
+}
 
-	matGray := gocv.NewMat()
-	gocv.CvtColor(matRGBA, &matGray, gocv.ColorRGBAToGray)
-	return matGray, nil
+// sortPoints orders points by Y, then X.
+func sortPoints(points []image.Point) {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Y != points[j].Y {
+			return points[i].Y < points[j].Y
+		}
+		return points[i].X < points[j].X
+	})
 }
 
 // TrimmedMean calculates the mean of a slice of float64s, excluding outliers.
@@ -98,47 +206,51 @@ func TrimmedMean(data []float64, trimFactor float64) float64 {
 	return sum / float64(len(trimmedSlice))
 }
 
-// CalculateGridVelocities aggregates pixel-wise flow into a grid using trimmed mean.
-func CalculateGridVelocities(flow gocv.Mat, gridRes int) (map[image.Point]GridVector, error) {
-	if flow.Empty() || flow.Type() != gocv.MatTypeCV32FC2 {
-		return nil, fmt.Errorf("invalid flow matrix: empty or wrong type")
-	}
+// aggregateGrid splits a rows x cols flow field into gridRes x gridRes cells
+// and returns the trimmed mean velocity of each cell. at returns the flow at
+// pixel (x, y); NaN and infinite samples are skipped, so a degenerate flow
+// pixel cannot poison its cell, and cells without any valid sample are left
+// out.
+func aggregateGrid(rows, cols, gridRes int, at func(y, x int) (vx, vy float64)) map[image.Point]GridVector {
+	gridData := gridSamples(rows, cols, gridRes, at)
 
-	rows := flow.Rows()
-	cols := flow.Cols()
-	if rows == 0 || cols == 0 {
-		return nil, fmt.Errorf("flow matrix has zero dimensions")
+	// Now, calculate the trimmed mean for each grid cell
+	gridVelocities := make(map[image.Point]GridVector)
+	for pt, data := range gridData {
+		if len(data.Vx) > 0 { // Ensure there's data to process
+			gridVelocities[pt] = data.mean()
+		}
 	}
 
+	return gridVelocities
+}
+
+// cellSamples holds all flow vectors of a grid cell.
+type cellSamples struct {
+	Vx []float64
+	Vy []float64
+}
+
+// gridSamples splits a rows x cols flow field into gridRes x gridRes cells
+// and returns the valid samples of each cell, as described for aggregateGrid.
+func gridSamples(rows, cols, gridRes int, at func(y, x int) (vx, vy float64)) map[image.Point]*cellSamples {
 	// This map will temporarily hold all flow vectors for each grid cell
 	// The key is the grid coordinate (e.g., 0,0)
-	// The value contains slices of all Vx and Vy values in that cell
-	type cellData struct {
-		Vx []float64
-		Vy []float64
-	}
-	gridData := make(map[image.Point]*cellData)
-
-	// Determine block size
-	blockWidth := float64(cols) / float64(gridRes)
-	blockHeight := float64(rows) / float64(gridRes)
+	gridData := make(map[image.Point]*cellSamples)
 
 	for y := 0; y < rows; y++ {
 		for x := 0; x < cols; x++ {
-			// Calculate which grid cell this pixel belongs to
-			gridX := int(math.Floor(float64(x) / blockWidth))
-			gridY := int(math.Floor(float64(y) / blockHeight))
-			pt := image.Point{X: gridX, Y: gridY}
+			vx, vy := at(y, x)
+			if !finite(vx) || !finite(vy) {
+				continue
+			}
 
-			// Get the flow vector at (y, x)
-			// Farneback returns a 2-channel float matrix (CV_32FC2)
-			vec := flow.GetVecfAt(y, x)
-			vx := float64(vec[0])
-			vy := float64(vec[1])
+			// Calculate which grid cell this pixel belongs to
+			pt := image.Point{X: gridCellIndex(x, cols, gridRes), Y: gridCellIndex(y, rows, gridRes)}
 
 			// Initialize the struct for this grid cell if it doesn't exist
 			if _, ok := gridData[pt]; !ok {
-				gridData[pt] = &cellData{
+				gridData[pt] = &cellSamples{
 					Vx: make([]float64, 0, 100), // Pre-allocate
 					Vy: make([]float64, 0, 100),
 				}
@@ -149,19 +261,50 @@ func CalculateGridVelocities(flow gocv.Mat, gridRes int) (map[image.Point]GridVe
 			gridData[pt].Vy = append(gridData[pt].Vy, vy)
 		}
 	}
+	return gridData
+}
 
-	// Now, calculate the trimmed mean for each grid cell
-	gridVelocities := make(map[image.Point]GridVector)
-	for pt, data := range gridData {
-		if len(data.Vx) > 0 { // Ensure there's data to process
-			vxMean := TrimmedMean(data.Vx, 0.1) // Trim 10% from each end
-			vyMean := TrimmedMean(data.Vy, 0.1)
-			// We only have velocity (Vx, Vy) at this stage. Ax, Ay will be 0.
-			gridVelocities[pt] = GridVector{Vx: vxMean, Vy: vyMean, Ax: 0, Ay: 0}
-		}
-	}
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// gridCellIndex returns the cell that pixel i of a row or column of size
+// pixels falls in, when the row or column is split into gridRes cells.
+func gridCellIndex(i, size, gridRes int) int {
+	blockSize := float64(size) / float64(gridRes)
+	return int(math.Floor(float64(i) / blockSize))
+}
 
-	return gridVelocities, nil
+// mean returns the trimmed mean velocity of the samples.
+func (c *cellSamples) mean() GridVector {
+	vxMean := TrimmedMean(c.Vx, 0.1) // Trim 10% from each end
+	vyMean := TrimmedMean(c.Vy, 0.1)
+	// We only have velocity (Vx, Vy) at this stage. Ax, Ay will be 0.
+	return GridVector{Vx: vxMean, Vy: vyMean, Ax: 0, Ay: 0}
+}
+
+// variance returns the sum of the variances of the x and y velocities of
+// the samples.
+func (c *cellSamples) variance() float64 {
+	return sampleVariance(c.Vx) + sampleVariance(c.Vy)
+}
+
+// sampleVariance returns the population variance of data.
+func sampleVariance(data []float64) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range data {
+		sum += v
+	}
+	mean := sum / float64(len(data))
+	var sq float64
+	for _, v := range data {
+		sq += (v - mean) * (v - mean)
+	}
+	return sq / float64(len(data))
 }
 
 // FitPolynomial performs a linear regression (1st-order polynomial fit) on the data.
@@ -203,71 +346,77 @@ func FitPolynomial(times []float64, values []float64) (intercept float64, slope
 // gridRes: The desired grid resolution (e.g., 64 for a 64x64 grid).
 // timeStep: The time in minutes (or any unit) between frames (e.g., 5.0).
 func ProcessImages(imagePaths []string, gridRes int, timeStep float64) (ExtrapolationData, error) {
+	return ProcessImagesWithOptions(imagePaths, gridRes, timeStep, Options{})
+}
+
+// ProcessImagesWithOptions is like ProcessImages but applies opts when
+// assembling the grid velocity history before fitting.
+func ProcessImagesWithOptions(imagePaths []string, gridRes int, timeStep float64, opts Options) (ExtrapolationData, error) {
 	numFrames := len(imagePaths)
 	if numFrames < 3 {
 		// Need at least 3 frames to get 2 flow fields to fit a line (v, a)
 		return ExtrapolationData{}, fmt.Errorf("at least 3 image frames are required, but got %d", numFrames)
 	}
-	numFlows := numFrames - 1
+	if err := opts.Preprocess.validate(); err != nil {
+		return ExtrapolationData{}, err
+	}
+	if err := opts.AccelerationLimit.validate(); err != nil {
+		return ExtrapolationData{}, err
+	}
 
-	// --- 1. Calculate all flow fields ---
-	flowFields := make([]gocv.Mat, numFlows)
-	prevImg, err := LoadGrayscaleImage(imagePaths[0])
-	if err != nil {
+	// --- 1 & 2. Calculate the flow fields and their grid velocities ---
+	var gridVelocitiesHistory []map[image.Point]GridVector
+	if err := visitFlows(imagePaths, opts, gridHistory(gridRes, &gridVelocitiesHistory)); err != nil {
 		return ExtrapolationData{}, err
 	}
-	defer prevImg.Close()
+	// --- 3. Fit polynomial to find velocity and acceleration ---
+	return fitGridHistory(gridVelocitiesHistory, gridRes, timeStep, opts)
+}
+
+// flowVisitor receives the flow fields of a sequence in order, each as its
+// size and a function returning the flow at pixel (x, y).
+type flowVisitor func(rows, cols int, at func(y, x int) (vx, vy float64))
+
+// visitFlows computes the flow between consecutive frames with the backend
+// selected by opts and passes each flow field to visit.
+func visitFlows(imagePaths []string, opts Options, visit flowVisitor) error {
+	backend := opts.Backend
+	if backend == BackendDefault {
+		backend = defaultBackend
+	}
+	switch backend {
+	case BackendOpenCV:
+		return opencvFlows(imagePaths, opts.Preprocess, visit)
+	case BackendPureGo:
+		return pureGoFlows(imagePaths, opts.Preprocess, visit)
+	default:
+		return fmt.Errorf("unknown backend %d", backend)
+	}
+}
 
-	for i := 1; i < numFrames; i++ {
-		currImg, err := LoadGrayscaleImage(imagePaths[i])
-		if err != nil {
-			return ExtrapolationData{}, err
-		}
-		// Note: currImg will be closed at the end of the loop iteration
-
-		flow := gocv.NewMat()
-		// Farneback parameters (tuned for general use)
-		// pyr_scale=0.5, levels=3, winsize=15, iterations=3, poly_n=5, poly_sigma=1.2, flags=0
-		gocv.CalcOpticalFlowFarneback(prevImg, currImg, &flow, 0.5, 3, 15, 3, 5, 1.2, 0)
-
-		flowFields[i-1] = flow // Store the calculated flow
-
-		prevImg.Close()   // Close the previous image
-		prevImg = currImg // The current image becomes the next previous image
-		// We defer closing the *last* currImg (which is now prevImg) until after the loop
-	}
-	defer prevImg.Close() // Clean up the final image
-
-	// --- 2. Calculate grid velocities for each flow field ---
-	// This will be a slice of maps
-	gridVelocitiesHistory := make([]map[image.Point]GridVector, numFlows)
-	for i, flow := range flowFields {
-		gridVels, err := CalculateGridVelocities(flow, gridRes)
-		if err != nil {
-			// Clean up allocated flow mats before returning error
-			for j := 0; j <= i; j++ {
-				flowFields[j].Close()
-			}
-			return ExtrapolationData{}, fmt.Errorf("error calculating grid velocities for flow %d: %w", i, err)
-		}
-		gridVelocitiesHistory[i] = gridVels
-		flow.Close() // We are done with this flow field
+// gridHistory returns a flowVisitor appending the grid velocities of each
+// flow field to history.
+func gridHistory(gridRes int, history *[]map[image.Point]GridVector) flowVisitor {
+	return func(rows, cols int, at func(y, x int) (vx, vy float64)) {
+		*history = append(*history, aggregateGrid(rows, cols, gridRes, at))
+	}
+}
+
+// fitGridHistory fits v(t) = a*t + b to the velocity history of every grid
+// cell present in the last frame of history, with t=0 at the last frame.
+// Samples above opts.MaxCellSpeed are handled according to opts.SpeedPolicy,
+// and the accelerations bounded by opts.AccelerationLimit.
+func fitGridHistory(gridVelocitiesHistory []map[image.Point]GridVector, gridRes int, timeStep float64, opts Options) (ExtrapolationData, error) {
+	numFlows := len(gridVelocitiesHistory)
+	if numFlows == 0 {
+		return ExtrapolationData{}, fmt.Errorf("no grid velocities to fit")
 	}
 
-	// --- 3. Fit polynomial to find velocity and acceleration ---
 	extrapolation := ExtrapolationData{
 		GridRes: gridRes,
 		Data:    make(map[image.Point]GridVector),
 	}
 
-	// Define the time coordinates for the fit.
-	// We want t=0 to be the *last* flow field.
-	// E.g., for 4 frames (3 flows): times = [-10, -5, 0]
-	times := make([]float64, numFlows)
-	for i := 0; i < numFlows; i++ {
-		times[i] = (float64(i) - float64(numFlows-1)) * timeStep
-	}
-
 	// Iterate over all grid points present in the *last* flow field
 	// Assumes the grid is mostly stable
 	lastGridVels := gridVelocitiesHistory[numFlows-1]
@@ -275,36 +424,87 @@ func ProcessImages(imagePaths []string, gridRes int, timeStep float64) (Extrapol
 		return ExtrapolationData{}, fmt.Errorf("no grid velocities found for the last frame")
 	}
 
-	for pt := range lastGridVels {
-		vxValues := make([]float64, numFlows)
-		vyValues := make([]float64, numFlows)
-
-		// Gather the history of Vx and Vy for this specific grid point 'pt'
-		for j := 0; j < numFlows; j++ {
-			if vel, ok := gridVelocitiesHistory[j][pt]; ok {
-				vxValues[j] = vel.Vx
-				vyValues[j] = vel.Vy
-			}
-			// if not ok, it defaults to 0.0, which is acceptable
+	times := fitTimes(numFlows, timeStep)
+	for _, pt := range sortedGridPoints(lastGridVels) {
+		vec, outliers := fitCell(gridVelocitiesHistory, pt, times, opts)
+		if outliers > 0 {
+			extrapolation.OutlierCells++
+			extrapolation.OutlierSamples += outliers
 		}
-
-		// Fit v(t) = a*t + b
-		// b (intercept) is the velocity at t=0 (Vx/Vy)
-		// a (slope) is the acceleration (Ax/Ay)
-		v0x, accelX := FitPolynomial(times, vxValues)
-		v0y, accelY := FitPolynomial(times, vyValues)
-
-		extrapolation.Data[pt] = GridVector{
-			Vx: v0x,
-			Vy: v0y,
-			Ax: accelX,
-			Ay: accelY,
+		vec, clamped := opts.AccelerationLimit.clamp(vec, timeStep)
+		if clamped {
+			extrapolation.ClampedAccelerations++
 		}
+		extrapolation.Data[pt] = vec
+	}
+	if opts.KeepHistory {
+		extrapolation.History, extrapolation.HistoryTimes = gridVelocitiesHistory, times
 	}
 
 	return extrapolation, nil
 }
 
+// fitTimes returns the time coordinates of numFlows flow fields timeStep
+// apart, with t=0 at the last one.
+func fitTimes(numFlows int, timeStep float64) []float64 {
+	// E.g., for 4 frames (3 flows): times = [-10, -5, 0]
+	times := make([]float64, numFlows)
+	for i := 0; i < numFlows; i++ {
+		times[i] = (float64(i) - float64(numFlows-1)) * timeStep
+	}
+	return times
+}
+
+// fitCell fits v(t) = a*t + b to the velocity history of grid cell pt, with
+// the samples taken at times. It returns the fitted vector and the number of
+// samples above opts.MaxCellSpeed, which are handled according to
+// opts.SpeedPolicy.
+func fitCell(gridVelocitiesHistory []map[image.Point]GridVector, pt image.Point, times []float64, opts Options) (GridVector, int) {
+	numFlows := len(gridVelocitiesHistory)
+	cellTimes := make([]float64, 0, numFlows)
+	vxValues := make([]float64, 0, numFlows)
+	vyValues := make([]float64, 0, numFlows)
+	outliers := 0
+
+	// Gather the history of Vx and Vy for this specific grid point 'pt'
+	for j := 0; j < numFlows; j++ {
+		// A missing cell defaults to 0.0, which is acceptable
+		vel := gridVelocitiesHistory[j][pt]
+		speed := math.Hypot(vel.Vx, vel.Vy)
+		if opts.MaxCellSpeed > 0 && speed > opts.MaxCellSpeed {
+			outliers++
+			switch opts.SpeedPolicy {
+			case SpeedPolicyDrop:
+				continue
+			case SpeedPolicyClamp:
+				scale := opts.MaxCellSpeed / speed
+				vel.Vx *= scale
+				vel.Vy *= scale
+			}
+		}
+		cellTimes = append(cellTimes, times[j])
+		vxValues = append(vxValues, vel.Vx)
+		vyValues = append(vyValues, vel.Vy)
+	}
+	if len(cellTimes) == 0 {
+		return GridVector{Unreliable: true}, outliers
+	}
+
+	// Fit v(t) = a*t + b
+	// b (intercept) is the velocity at t=0 (Vx/Vy)
+	// a (slope) is the acceleration (Ax/Ay)
+	v0x, accelX := FitPolynomial(cellTimes, vxValues)
+	v0y, accelY := FitPolynomial(cellTimes, vyValues)
+
+	return GridVector{
+		Vx:         v0x,
+		Vy:         v0y,
+		Ax:         accelX,
+		Ay:         accelY,
+		Unreliable: outliers > 0 && opts.SpeedPolicy == SpeedPolicyUnreliable,
+	}, outliers
+}
+
 // Example main function (replace with your actual image paths)
 func main() {
 	// This requires OpenCV to be installed on your system
@@ -344,10 +544,11 @@ func main() {
 	log.Printf("Successfully generated extrapolation data for %d grid cells.", len(extrapolationData.Data))
 	// Print data for a few cells
 	count := 0
-	for pt, data := range extrapolationData.Data {
+	for _, pt := range extrapolationData.Points() {
 		if count > 5 {
 			break
 		}
+		data := extrapolationData.Data[pt]
 		log.Printf("Grid[%d, %d]: V=(%.2f, %.2f), A=(%.2f, %.2f)", pt.X, pt.Y, data.Vx, data.Vy, data.Ax, data.Ay)
 		count++
 	}
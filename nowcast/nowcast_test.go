@@ -1,33 +1,50 @@
 package nowcast
 
 import (
+	"example/goflow/flow/synth"
 	"fmt"
 	"image"
-	"image/color"
 	"image/png"
-	"math/rand"
+	"math"
 	"os"
 	"testing"
 )
 
-// createImage creates a single PNG image with a rectangle at a specific position.
-func createImage(filePath string, width, height, rectX, rectY, rectSize int) error {
-	img := image.NewGray(image.Rect(0, 0, width, height))
-	// Fill background with random noise
-	for i := range img.Pix {
-		img.Pix[i] = uint8(rand.Intn(64)) // Low-level noise
-	}
+// createTestSequence generates a series of images showing a textured square
+// moving at a constant velocity over a flat dark background. It returns the
+// file paths of the created images.
+func createTestSequence(t *testing.T, numFrames, width, height, rectSize, startX, startY, vx, vy int) []string {
+	t.Helper()
+	half := float64(rectSize) / 2
+	spec := synth.MotionSpec{FlatBackground: true, Blobs: []synth.Blob{{
+		Shape:  synth.BlobSquare,
+		Center: synth.Point{X: float64(startX) + half, Y: float64(startY) + half},
+		Size:   half,
+		Motion: synth.Motion{Translate: synth.Point{X: float64(vx), Y: float64(vy)}},
+	}}}
+	return writeSequence(t, spec, numFrames, width, height)
+}
 
-	// Draw a rectangle with random noise for texture
-	for y := rectY; y < rectY+rectSize; y++ {
-		for x := rectX; x < rectX+rectSize; x++ {
-			if x >= 0 && x < width && y >= 0 && y < height {
-				// Add noise to provide texture for the optical flow algorithm
-				img.SetGray(x, y, color.Gray{Y: uint8(128 + rand.Intn(128))})
-			}
+// writeSequence renders numFrames frames of spec to a temporary directory
+// and returns their file paths.
+func writeSequence(t *testing.T, spec synth.MotionSpec, numFrames, width, height int) []string {
+	t.Helper()
+	frames, _ := synth.GenerateSequence(spec, numFrames, width, height)
+
+	var paths []string
+	tempDir := t.TempDir() // Use a temporary directory for test images
+	for i, frame := range frames {
+		filePath := fmt.Sprintf("%s/frame_%02d.png", tempDir, i)
+		if err := writePNG(filePath, frame); err != nil {
+			t.Fatalf("Failed to create test image %s: %v", filePath, err)
 		}
+		paths = append(paths, filePath)
 	}
+	return paths
+}
 
+// writePNG encodes img as a PNG file at filePath.
+func writePNG(filePath string, img image.Image) error {
 	file, err := os.Create(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to create image file: %w", err)
@@ -40,26 +57,6 @@ func createImage(filePath string, width, height, rectX, rectY, rectSize int) err
 	return nil
 }
 
-// createTestSequence generates a series of images showing a rectangle moving
-// at a constant velocity. It returns the file paths of the created images.
-func createTestSequence(t *testing.T, numFrames, width, height, rectSize, startX, startY, vx, vy int) []string {
-	t.Helper()
-	var paths []string
-	tempDir := t.TempDir() // Use a temporary directory for test images
-
-	for i := 0; i < numFrames; i++ {
-		filePath := fmt.Sprintf("%s/frame_%02d.png", tempDir, i)
-		rectX := startX + i*vx
-		rectY := startY + i*vy
-		err := createImage(filePath, width, height, rectX, rectY, rectSize)
-		if err != nil {
-			t.Fatalf("Failed to create test image %s: %v", filePath, err)
-		}
-		paths = append(paths, filePath)
-	}
-	return paths
-}
-
 func TestProcessImagesWithMovingRectangle(t *testing.T) {
 	// --- Test Parameters ---
 	numFrames := 4
@@ -101,6 +98,11 @@ func TestProcessImagesWithMovingRectangle(t *testing.T) {
 	// The calculated flow might not be exactly vx, vy due to algorithmic artifacts,
 	// so we check if it's within a reasonable tolerance.
 	tolerance := 1.5
+	accelTolerance := 0.5
+	if defaultBackend == BackendPureGo {
+		// Built without OpenCV: BlockMatchFlow's documented accuracy.
+		tolerance, accelTolerance = 2.0, 2.0
+	}
 	expectedVx := float64(vx)
 	if diff := abs(gv.Vx - expectedVx); diff > tolerance {
 		t.Errorf("Expected Vx for grid point %v to be around %.2f, but got %.2f (diff=%.2f)",
@@ -115,7 +117,6 @@ func TestProcessImagesWithMovingRectangle(t *testing.T) {
 
 	// --- Acceleration Assertions ---
 	// Since velocity is constant, acceleration should be close to zero.
-	accelTolerance := 0.5
 	if abs(gv.Ax) > accelTolerance {
 		t.Errorf("Expected Ax to be close to 0.0, but got %.2f", gv.Ax)
 	}
@@ -130,3 +131,162 @@ func abs(x float64) float64 {
 	}
 	return x
 }
+
+func TestExtrapolationDataPoints(t *testing.T) {
+	data := ExtrapolationData{
+		GridRes: 3,
+		Data:    make(map[image.Point]GridVector),
+	}
+	for y := 2; y >= 0; y-- {
+		for x := 2; x >= 0; x-- {
+			data.Data[image.Point{X: x, Y: y}] = GridVector{}
+		}
+	}
+
+	points := data.Points()
+	if len(points) != 9 {
+		t.Fatalf("Expected 9 points, got %d", len(points))
+	}
+	for i, pt := range points {
+		want := image.Point{X: i % 3, Y: i / 3}
+		if pt != want {
+			t.Errorf("Point %d: expected %v, got %v", i, want, pt)
+		}
+	}
+}
+
+// constantHistory returns numFlows frames of a single grid cell moving at
+// (vx, vy), with the sample at frame glitch replaced by an absurd velocity.
+func constantHistory(numFlows int, vx, vy float64, glitch int) []map[image.Point]GridVector {
+	history := make([]map[image.Point]GridVector, numFlows)
+	for i := range history {
+		vel := GridVector{Vx: vx, Vy: vy}
+		if i == glitch {
+			vel = GridVector{Vx: 80, Vy: -60}
+		}
+		history[i] = map[image.Point]GridVector{{X: 0, Y: 0}: vel}
+	}
+	return history
+}
+
+func TestFitGridHistoryMaxCellSpeed(t *testing.T) {
+	const vx, vy = 3.0, 1.0
+	history := constantHistory(5, vx, vy, 2)
+	cell := image.Point{X: 0, Y: 0}
+
+	tests := []struct {
+		name           string
+		policy         SpeedPolicy
+		wantUnreliable bool
+		tolerance      float64
+	}{
+		{"Drop", SpeedPolicyDrop, false, 1e-9},
+		{"Clamp", SpeedPolicyClamp, false, 5},
+		{"Unreliable", SpeedPolicyUnreliable, true, math.Inf(1)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := fitGridHistory(history, 1, 1.0, Options{MaxCellSpeed: 20, SpeedPolicy: tc.policy})
+			if err != nil {
+				t.Fatalf("fitGridHistory failed: %v", err)
+			}
+			if data.OutlierCells != 1 || data.OutlierSamples != 1 {
+				t.Errorf("Expected 1 outlier cell and sample, got %d and %d", data.OutlierCells, data.OutlierSamples)
+			}
+			gv := data.Data[cell]
+			if gv.Unreliable != tc.wantUnreliable {
+				t.Errorf("Expected Unreliable=%v, got %v", tc.wantUnreliable, gv.Unreliable)
+			}
+			if abs(gv.Vx-vx) > tc.tolerance || abs(gv.Vy-vy) > tc.tolerance {
+				t.Errorf("Expected V near (%.2f, %.2f), got (%.2f, %.2f)", vx, vy, gv.Vx, gv.Vy)
+			}
+		})
+	}
+
+	// Without a limit the glitch pulls the fit well away from the true value.
+	data, err := fitGridHistory(history, 1, 1.0, Options{})
+	if err != nil {
+		t.Fatalf("fitGridHistory failed: %v", err)
+	}
+	if data.OutlierCells != 0 {
+		t.Errorf("Expected no outlier cells without a limit, got %d", data.OutlierCells)
+	}
+	if gv := data.Data[cell]; abs(gv.Vx-vx) < 5 {
+		t.Errorf("Expected the unfiltered fit to be distorted, got Vx=%.2f", gv.Vx)
+	}
+}
+
+func TestGridVelocitiesSkipNonFinite(t *testing.T) {
+	field := shearField(8, 8, 2)
+	field.Vy[0][0] = float32(math.NaN())
+	field.Vy[1][5] = float32(math.Inf(1))
+	field.Vx[6][2] = float32(math.Inf(-1))
+	for x := 4; x < 8; x++ {
+		for y := 4; y < 8; y++ {
+			field.Vx[y][x] = float32(math.NaN())
+		}
+	}
+	grid, err := CalculateGridVelocitiesFromField(field, 2)
+	if err != nil {
+		t.Fatalf("CalculateGridVelocitiesFromField failed: %v", err)
+	}
+	for pt, v := range grid {
+		if v.Vx != 0 || v.Vy != 2 {
+			t.Errorf("Cell %v: got (%v, %v), want (0, 2)", pt, v.Vx, v.Vy)
+		}
+	}
+	if _, ok := grid[image.Pt(1, 1)]; ok || len(grid) != 3 {
+		t.Errorf("Expected the cell without finite pixels to be left out, got %v", grid)
+	}
+}
+
+// TestExtrapolationDataVelocityAt checks that VelocityAt recovers each cell
+// at its centre, averages two cells halfway between their centres, clamps
+// to the nearest cells at the border and renormalizes around a missing or
+// unreliable cell.
+func TestExtrapolationDataVelocityAt(t *testing.T) {
+	// A 2x2 grid over a 100x100 image, so the cell centres lie at 25 and 75.
+	data := ExtrapolationData{GridRes: 2, Data: map[image.Point]GridVector{
+		{X: 0, Y: 0}: {Vx: 1, Vy: 2, Ax: 0.1, Ay: 0.2},
+		{X: 1, Y: 0}: {Vx: 3, Vy: -2, Ax: 0.3, Ay: -0.2},
+		{X: 0, Y: 1}: {Vx: 5, Vy: 0, Ax: 0.5, Ay: 0},
+		{X: 1, Y: 1}: {Vx: 7, Vy: 4, Ax: 0.7, Ay: 0.4},
+	}}
+	check := func(x, y float64, want GridVector) {
+		t.Helper()
+		vx, vy, ax, ay, ok := data.VelocityAt(x, y, 100, 100)
+		if !ok {
+			t.Fatalf("VelocityAt(%v, %v) found no data", x, y)
+		}
+		got := GridVector{Vx: vx, Vy: vy, Ax: ax, Ay: ay}
+		if abs(got.Vx-want.Vx) > 1e-9 || abs(got.Vy-want.Vy) > 1e-9 || abs(got.Ax-want.Ax) > 1e-9 || abs(got.Ay-want.Ay) > 1e-9 {
+			t.Errorf("VelocityAt(%v, %v) = %+v, want %+v", x, y, got, want)
+		}
+	}
+
+	for pt, v := range data.Data {
+		check(float64(25+50*pt.X), float64(25+50*pt.Y), v)
+	}
+	check(50, 25, GridVector{Vx: 2, Vy: 0, Ax: 0.2, Ay: 0})
+	check(50, 50, GridVector{Vx: 4, Vy: 1, Ax: 0.4, Ay: 0.1})
+	check(0, 0, data.Data[image.Pt(0, 0)])
+	check(99.5, 50, GridVector{Vx: 5, Vy: 1, Ax: 0.5, Ay: 0.1})
+
+	// Without cell (1, 1) the midpoint averages the three remaining cells.
+	delete(data.Data, image.Pt(1, 1))
+	check(50, 50, GridVector{Vx: 3, Vy: 0, Ax: 0.3, Ay: 0})
+	check(50, 75, GridVector{Vx: 5, Vy: 0, Ax: 0.5, Ay: 0})
+	if _, _, _, _, ok := data.VelocityAt(75, 75, 100, 100); ok {
+		t.Error("Expected no velocity at the centre of the missing cell")
+	}
+	if _, _, _, _, ok := data.VelocityAt(100, 50, 100, 100); ok {
+		t.Error("Expected no velocity outside the image")
+	}
+
+	// An unreliable cell is left out like a missing one.
+	data.Data[image.Pt(1, 1)] = GridVector{Unreliable: true}
+	check(50, 50, GridVector{Vx: 3, Vy: 0, Ax: 0.3, Ay: 0})
+	if _, _, _, _, ok := data.VelocityAt(75, 75, 100, 100); ok {
+		t.Error("Expected no velocity at the centre of the unreliable cell")
+	}
+}
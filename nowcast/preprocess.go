@@ -0,0 +1,163 @@
+package nowcast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// PreprocessFilter selects the filter applied to every frame before the
+// optical flow is computed.
+type PreprocessFilter int
+
+const (
+	// PreprocessNone leaves the frames as they are.
+	PreprocessNone PreprocessFilter = iota
+	// PreprocessMedian replaces every pixel with the median of its
+	// Kernel x Kernel neighbourhood, removing radar speckle that the flow
+	// would otherwise turn into salt-and-pepper velocity noise.
+	PreprocessMedian
+	// PreprocessGaussian blurs every frame with a Gaussian of standard
+	// deviation Sigma.
+	PreprocessGaussian
+)
+
+// String returns the name of the filter.
+func (f PreprocessFilter) String() string {
+	switch f {
+	case PreprocessNone:
+		return "none"
+	case PreprocessMedian:
+		return "median"
+	case PreprocessGaussian:
+		return "gaussian"
+	default:
+		return fmt.Sprintf("PreprocessFilter(%d)", int(f))
+	}
+}
+
+// maxMedianKernel is the largest median kernel accepted.
+const maxMedianKernel = 15
+
+// Preprocess configures the filter applied to every frame before the flow
+// is computed. It only affects the flow: the frames themselves, such as the
+// one a forecast advects, are not changed. The zero value applies no filter.
+type Preprocess struct {
+	Filter PreprocessFilter
+	// Kernel is the odd side length of the PreprocessMedian neighbourhood,
+	// from 3 to 15.
+	Kernel int
+	// Sigma is the standard deviation in pixels of PreprocessGaussian.
+	Sigma float64
+}
+
+// MedianBlur returns a Preprocess applying a k x k median filter.
+func MedianBlur(k int) Preprocess {
+	return Preprocess{Filter: PreprocessMedian, Kernel: k}
+}
+
+// GaussianBlur returns a Preprocess applying a Gaussian blur of standard
+// deviation sigma pixels.
+func GaussianBlur(sigma float64) Preprocess {
+	return Preprocess{Filter: PreprocessGaussian, Sigma: sigma}
+}
+
+// validate reports an error if the parameters of the selected filter are out
+// of range.
+func (p Preprocess) validate() error {
+	switch p.Filter {
+	case PreprocessNone:
+	case PreprocessMedian:
+		if p.Kernel < 3 || p.Kernel > maxMedianKernel || p.Kernel%2 == 0 {
+			return fmt.Errorf("median kernel must be odd and between 3 and %d, got %d", maxMedianKernel, p.Kernel)
+		}
+	case PreprocessGaussian:
+		if !(p.Sigma > 0) || math.IsInf(p.Sigma, 0) {
+			return fmt.Errorf("gaussian sigma must be positive and finite, got %v", p.Sigma)
+		}
+	default:
+		return fmt.Errorf("unknown preprocess filter %d", p.Filter)
+	}
+	return nil
+}
+
+// gaussianRadius returns the half-width of the Gaussian kernel for sigma,
+// matching the kernel size OpenCV derives from sigma for 8-bit images.
+func gaussianRadius(sigma float64) int {
+	return (int(math.Round(sigma*6+1)) | 1) / 2
+}
+
+// applyField returns img filtered by p, the BackendPureGo counterpart of
+// the OpenCV filters. Borders are clamped. With PreprocessNone it returns
+// img itself.
+func (p Preprocess) applyField(img [][]float32) [][]float32 {
+	switch p.Filter {
+	case PreprocessMedian:
+		return medianField(img, p.Kernel/2)
+	case PreprocessGaussian:
+		return gaussianField(img, p.Sigma)
+	default:
+		return img
+	}
+}
+
+// medianField replaces every pixel with the median of the pixels within
+// radius of it.
+func medianField(img [][]float32, radius int) [][]float32 {
+	out := make([][]float32, len(img))
+	window := make([]float32, 0, (2*radius+1)*(2*radius+1))
+	for y := range img {
+		out[y] = make([]float32, len(img[y]))
+		for x := range img[y] {
+			window = window[:0]
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					window = append(window, at(img, x+dx, y+dy))
+				}
+			}
+			sort.Slice(window, func(i, j int) bool { return window[i] < window[j] })
+			out[y][x] = window[len(window)/2]
+		}
+	}
+	return out
+}
+
+// gaussianField blurs img with a Gaussian of standard deviation sigma using
+// separable passes.
+func gaussianField(img [][]float32, sigma float64) [][]float32 {
+	radius := gaussianRadius(sigma)
+	kernel := make([]float32, 2*radius+1)
+	var total float64
+	for k := -radius; k <= radius; k++ {
+		w := math.Exp(-float64(k*k) / (2 * sigma * sigma))
+		kernel[k+radius] = float32(w)
+		total += w
+	}
+	for i := range kernel {
+		kernel[i] /= float32(total)
+	}
+
+	tmp := make([][]float32, len(img))
+	out := make([][]float32, len(img))
+	for y := range img {
+		tmp[y] = make([]float32, len(img[y]))
+		for x := range img[y] {
+			var sum float32
+			for k := -radius; k <= radius; k++ {
+				sum += kernel[k+radius] * at(img, x+k, y)
+			}
+			tmp[y][x] = sum
+		}
+	}
+	for y := range img {
+		out[y] = make([]float32, len(img[y]))
+		for x := range img[y] {
+			var sum float32
+			for k := -radius; k <= radius; k++ {
+				sum += kernel[k+radius] * at(tmp, x, y+k)
+			}
+			out[y][x] = sum
+		}
+	}
+	return out
+}
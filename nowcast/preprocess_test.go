@@ -0,0 +1,111 @@
+//go:build cgo && !purego
+
+package nowcast
+
+import (
+	"example/goflow/flow/synth"
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// speckledFrames returns numFrames synth frames of a texture translating by
+// (vx, vy) pixels per frame, with a fraction of the pixels of every frame
+// replaced by independent salt-and-pepper speckle drawn from rng.
+func speckledFrames(t *testing.T, rng *rand.Rand, numFrames, size int, vx, vy, speckle float64) []gocv.Mat {
+	t.Helper()
+	spec := synth.MotionSpec{Background: synth.Motion{Translate: synth.Point{X: vx, Y: vy}}}
+	images, _ := synth.GenerateSequence(spec, numFrames, size, size)
+	frames := make([]gocv.Mat, numFrames)
+	for i, img := range images {
+		data := append([]byte(nil), img.(*image.Gray).Pix...)
+		for j := range data {
+			if rng.Float64() < speckle {
+				data[j] = uint8(255 * rng.Intn(2))
+			}
+		}
+		mat, err := gocv.NewMatFromBytes(size, size, gocv.MatTypeCV8U, data)
+		if err != nil {
+			t.Fatalf("Failed to create frame: %v", err)
+		}
+		frames[i] = mat
+	}
+	return frames
+}
+
+// velocityNoise processes realizations independently speckled sequences with
+// opts and returns the mean over grid cells of the standard deviation of Vx
+// across realizations, and the mean Vx over all cells and realizations.
+func velocityNoise(t *testing.T, opts Options, realizations int, vx float64) (noise, mean float64) {
+	t.Helper()
+	const size, gridRes = 128, 8
+	rng := rand.New(rand.NewSource(11))
+	samples := make(map[[2]int][]float64)
+	for r := 0; r < realizations; r++ {
+		frames := speckledFrames(t, rng, 3, size, vx, 0, 0.05)
+		data, err := ProcessMats(frames, gridRes, 1.0, opts)
+		closeAll(frames)
+		if err != nil {
+			t.Fatalf("ProcessMats failed: %v", err)
+		}
+		for pt, v := range data.Data {
+			// Leave out the border cells, where the flow is unreliable.
+			if pt.X == 0 || pt.Y == 0 || pt.X == gridRes-1 || pt.Y == gridRes-1 {
+				continue
+			}
+			samples[[2]int{pt.X, pt.Y}] = append(samples[[2]int{pt.X, pt.Y}], v.Vx)
+		}
+	}
+
+	var n int
+	for _, vs := range samples {
+		var sum, sumSq float64
+		for _, v := range vs {
+			sum += v
+			sumSq += v * v
+		}
+		m := sum / float64(len(vs))
+		noise += math.Sqrt(math.Max(0, sumSq/float64(len(vs))-m*m))
+		mean += sum
+		n += len(vs)
+	}
+	return noise / float64(len(samples)), mean / float64(n)
+}
+
+func TestPreprocessMedianReducesVelocityNoise(t *testing.T) {
+	const realizations, vx = 6, 2.0
+	rawNoise, rawMean := velocityNoise(t, Options{}, realizations, vx)
+	medianNoise, medianMean := velocityNoise(t, Options{Preprocess: MedianBlur(3)}, realizations, vx)
+	t.Logf("Vx noise: raw %.3f, median %.3f; mean Vx: raw %.3f, median %.3f", rawNoise, medianNoise, rawMean, medianMean)
+
+	if medianNoise > 0.8*rawNoise {
+		t.Errorf("Expected the median filter to reduce the velocity noise by at least 20%%, got %.3f from %.3f", medianNoise, rawNoise)
+	}
+	if math.Abs(medianMean-vx) > 0.2 {
+		t.Errorf("Expected an unbiased mean Vx of %.1f with the median filter, got %.3f", vx, medianMean)
+	}
+}
+
+func TestPreprocessValidation(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	frames := speckledFrames(t, rng, 3, 32, 1, 0, 0)
+	defer closeAll(frames)
+	for _, pre := range []Preprocess{
+		MedianBlur(4),
+		MedianBlur(1),
+		MedianBlur(maxMedianKernel + 2),
+		GaussianBlur(0),
+		GaussianBlur(math.NaN()),
+		{Filter: PreprocessFilter(9)},
+	} {
+		if _, err := ProcessMats(frames, 4, 1.0, Options{Preprocess: pre}); err == nil {
+			t.Errorf("Expected an error for %s preprocessing %+v", pre.Filter, pre)
+		}
+	}
+	if _, err := ProcessMats(frames, 4, 1.0, Options{Preprocess: GaussianBlur(1.5)}); err != nil {
+		t.Errorf("Expected a Gaussian blur to be accepted: %v", err)
+	}
+}
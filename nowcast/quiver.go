@@ -0,0 +1,99 @@
+package nowcast
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// DefaultQuiverScale is the default QuiverOptions.Scale.
+const DefaultQuiverScale = 4.0
+
+// QuiverOptions configures Quiver.
+type QuiverOptions struct {
+	// Scale is the arrow length in pixels per pixel per frame of velocity.
+	// Zero means DefaultQuiverScale.
+	Scale float64
+	// Zones are shaded under the arrows, such as those returned by
+	// FindConvergenceZones.
+	Zones []Zone
+}
+
+func (o QuiverOptions) scale() float64 {
+	if o.Scale == 0 {
+		return DefaultQuiverScale
+	}
+	return o.Scale
+}
+
+// Colors of the quiver plot.
+var (
+	quiverBackground = color.RGBA{A: 255}
+	quiverArrow      = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	quiverUnreliable = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	quiverZone       = color.RGBA{R: 160, G: 64, B: 0, A: 255}
+)
+
+// Quiver draws the grid velocities of data over a width x height image, the
+// size of the frames they were computed from, as one arrow per cell from its
+// centre. Unreliable cells are drawn in grey, and the cells of opts.Zones
+// are shaded.
+func Quiver(data ExtrapolationData, width, height int, opts QuiverOptions) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: quiverBackground}, image.Point{}, draw.Src)
+	if data.GridRes <= 0 {
+		return img
+	}
+	bounds := func(pt image.Point) image.Rectangle {
+		n := data.GridRes
+		return image.Rect(cellStart(pt.X, width, n), cellStart(pt.Y, height, n),
+			cellStart(pt.X+1, width, n), cellStart(pt.Y+1, height, n))
+	}
+	for _, zone := range opts.Zones {
+		for _, pt := range zone.Cells {
+			draw.Draw(img, bounds(pt), &image.Uniform{C: quiverZone}, image.Point{}, draw.Src)
+		}
+	}
+
+	scale := opts.scale()
+	for _, pt := range data.Points() {
+		v := data.Data[pt]
+		cell := bounds(pt)
+		x0 := float64(cell.Min.X+cell.Max.X) / 2
+		y0 := float64(cell.Min.Y+cell.Max.Y) / 2
+		c := quiverArrow
+		if v.Unreliable {
+			c = quiverUnreliable
+		}
+		drawArrow(img, x0, y0, x0+v.Vx*scale, y0+v.Vy*scale, c)
+	}
+	return img
+}
+
+// drawArrow draws a line from (x0, y0) to (x1, y1) with a head at (x1, y1).
+func drawArrow(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	drawLine(img, x0, y0, x1, y1, c)
+	length := math.Hypot(x1-x0, y1-y0)
+	if length < 1 {
+		return
+	}
+	head := math.Min(6, length/3)
+	angle := math.Atan2(y1-y0, x1-x0)
+	for _, side := range []float64{-1, 1} {
+		a := angle + math.Pi - side*math.Pi/6
+		drawLine(img, x1, y1, x1+head*math.Cos(a), y1+head*math.Sin(a), c)
+	}
+}
+
+// drawLine draws a one-pixel line from (x0, y0) to (x1, y1), clipped to img.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		f := 0.0
+		if steps > 0 {
+			f = float64(i) / float64(steps)
+		}
+		img.SetRGBA(int(math.Floor(x0+f*(x1-x0))), int(math.Floor(y0+f*(y1-y0))), c)
+	}
+}
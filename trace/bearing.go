@@ -0,0 +1,25 @@
+package trace
+
+import "math"
+
+// DirectionFromBearing returns the unit direction of a compass bearing in
+// degrees clockwise from north. Image Y grows downward, so north, the top of
+// the image, is (0, -1) and a bearing of 90 (east) is (1, 0).
+func DirectionFromBearing(degreesClockwiseFromNorth float64) Point {
+	rad := degreesClockwiseFromNorth * math.Pi / 180
+	return Point{X: math.Sin(rad), Y: -math.Cos(rad)}
+}
+
+// BearingFromDirection returns the compass bearing of an image-coordinate
+// direction in degrees clockwise from north, in [0, 360). It is the inverse
+// of DirectionFromBearing, and returns NaN for the zero vector.
+func BearingFromDirection(direction Point) float64 {
+	if direction.X == 0 && direction.Y == 0 {
+		return math.NaN()
+	}
+	bearing := math.Atan2(direction.X, -direction.Y) * 180 / math.Pi
+	if bearing < 0 {
+		bearing += 360
+	}
+	return bearing
+}
@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBearingConversions(t *testing.T) {
+	for _, tc := range []struct {
+		bearing float64
+		want    Point
+	}{
+		{0, Point{X: 0, Y: -1}},
+		{90, Point{X: 1, Y: 0}},
+		{180, Point{X: 0, Y: 1}},
+		{270, Point{X: -1, Y: 0}},
+	} {
+		got := DirectionFromBearing(tc.bearing)
+		if math.Abs(got.X-tc.want.X) > 1e-12 || math.Abs(got.Y-tc.want.Y) > 1e-12 {
+			t.Errorf("DirectionFromBearing(%v) = %v, want %v", tc.bearing, got, tc.want)
+		}
+		if back := BearingFromDirection(got); math.Abs(back-tc.bearing) > 1e-9 {
+			t.Errorf("BearingFromDirection(%v) = %v, want %v", got, back, tc.bearing)
+		}
+	}
+	if b := BearingFromDirection(Point{X: -1, Y: -1}); math.Abs(b-315) > 1e-9 {
+		t.Errorf("Expected north-west to be bearing 315, got %v", b)
+	}
+	if b := BearingFromDirection(Point{}); !math.IsNaN(b) {
+		t.Errorf("Expected NaN for the zero vector, got %v", b)
+	}
+}
+
+func TestSearchByBearingFindsHotPixel(t *testing.T) {
+	image := make([][]float64, 21)
+	for i := range image {
+		image[i] = make([]float64, 21)
+	}
+	image[10][17] = 100 // Seven pixels due east of the origin
+
+	origin := Point{X: 10.5, Y: 10.5}
+	east, _, err := ProjectAngularSearch(image, origin, DirectionFromBearing(90), math.Pi/12, 10)
+	if err != nil {
+		t.Fatalf("ProjectAngularSearch failed: %v", err)
+	}
+	if peak := maxOf(east); peak != 100 {
+		t.Errorf("Expected bearing 90 to find the hot pixel, got a peak of %v", peak)
+	}
+	for _, bearing := range []float64{0, 180, 270} {
+		projection, _, err := ProjectAngularSearch(image, origin, DirectionFromBearing(bearing), math.Pi/12, 10)
+		if err != nil {
+			t.Fatalf("ProjectAngularSearch failed: %v", err)
+		}
+		if peak := maxOf(projection); peak != 0 {
+			t.Errorf("Expected bearing %v to miss the hot pixel, got a peak of %v", bearing, peak)
+		}
+	}
+}
+
+func maxOf(values []float64) float64 {
+	peak := math.Inf(-1)
+	for _, v := range values {
+		peak = math.Max(peak, v)
+	}
+	return peak
+}
@@ -0,0 +1,103 @@
+package trace
+
+import "math"
+
+// binWidth is the width, in pixels, of every projection bin.
+const binWidth = 1.0
+
+// CumulativeProfile returns the running integral of a projection profile from
+// the origin outward. Each bin contributes its value multiplied by the bin
+// width in pixels; empty bins (negative infinity) and NaN bins count as zero.
+func CumulativeProfile(profile []float64) []float64 {
+	cumulative := make([]float64, len(profile))
+	total := 0.0
+	for i, v := range profile {
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			total += v * binWidth
+		}
+		cumulative[i] = total
+	}
+	return cumulative
+}
+
+// ProfileDistances returns, for each of n bins of a search starting at origin
+// along dirUnitVec, the distance in pixels from the origin to the outer edge
+// of that bin. uMin is the smallest projected coordinate of the search
+// triangle, which for ProjectAngularSearch is the projection of the origin.
+func ProfileDistances(origin, dirUnitVec Point, uMin float64, n int) []float64 {
+	uOrigin := dot(origin, dirUnitVec)
+	start := math.Floor(uMin)
+	distances := make([]float64, n)
+	for i := range distances {
+		distances[i] = start + float64(i+1)*binWidth - uOrigin
+	}
+	return distances
+}
+
+// ProjectAngularSearchCumulative runs an angular search with the given
+// reducer and returns the cumulative profile together with the outer-edge
+// distance of every bin, suitable for DistanceForFraction. Use ReduceMean or
+// ReduceSum for mass-style questions; ReduceMax is accepted but rarely
+// meaningful here.
+func ProjectAngularSearchCumulative(
+	image [][]float64,
+	origin Point,
+	direction Point,
+	fieldOfViewAngleRadians float64,
+	distance float64,
+	reducer Reducer,
+) ([]float64, []float64, Triangle, error) {
+	if err := ValidateImage(image); err != nil {
+		return nil, nil, Triangle{}, err
+	}
+	tri, dirUnitVec, err := searchTriangle(origin, direction, fieldOfViewAngleRadians, distance)
+	if err != nil {
+		return nil, nil, Triangle{}, err
+	}
+
+	profile := ProjectTriangle(image, tri, dirUnitVec, reducer)
+	uMin, _ := projectionRange(tri, dirUnitVec)
+
+	return CumulativeProfile(profile), ProfileDistances(origin, dirUnitVec, uMin, len(profile)), tri, nil
+}
+
+// DistanceForFraction returns the distance from the origin that encloses the
+// given fraction (0 to 1) of the total mass of a projection profile. profile
+// holds the per-bin values (not the cumulative sum) and distances the outer
+// edge of each bin, as returned by ProfileDistances. The mass of a bin is
+// assumed to be spread evenly across it. Empty bins count as zero. If the
+// profile holds no positive mass, 0 is returned.
+func DistanceForFraction(profile, distances []float64, fraction float64) float64 {
+	n := min(len(profile), len(distances))
+	if n == 0 {
+		return 0
+	}
+	fraction = math.Max(0, math.Min(1, fraction))
+
+	cumulative := CumulativeProfile(profile[:n])
+	total := cumulative[n-1]
+	if total <= 0 {
+		return 0
+	}
+	target := fraction * total
+
+	for i := 0; i < n; i++ {
+		if cumulative[i] < target {
+			continue
+		}
+		inner := math.Max(0, distances[i]-binWidth)
+		if i > 0 {
+			inner = distances[i-1]
+		}
+		prev := 0.0
+		if i > 0 {
+			prev = cumulative[i-1]
+		}
+		mass := cumulative[i] - prev
+		if mass <= 0 {
+			return inner
+		}
+		return inner + (target-prev)/mass*(distances[i]-inner)
+	}
+	return distances[n-1]
+}
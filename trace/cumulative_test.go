@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"math"
+	"testing"
+)
+
+// corridorImage returns a size x size image that is zero except for a
+// horizontal band of the given value centred on row size/2.
+func corridorImage(size, halfWidth int, value float64) [][]float64 {
+	image := make([][]float64, size)
+	for y := range image {
+		image[y] = make([]float64, size)
+		if y >= size/2-halfWidth && y <= size/2+halfWidth {
+			for x := range image[y] {
+				image[y][x] = value
+			}
+		}
+	}
+	return image
+}
+
+func TestProjectTriangleReducers(t *testing.T) {
+	image := [][]float64{
+		{1, 2},
+		{3, 6},
+	}
+	// Covers the whole 2x2 image; projecting onto X gives two columns.
+	tri := Triangle{V1: Point{X: -1, Y: -1}, V2: Point{X: 5, Y: -1}, V3: Point{X: -1, Y: 5}}
+	dir := Point{X: 1, Y: 0}
+
+	tests := []struct {
+		name    string
+		reducer Reducer
+		want    []float64
+	}{
+		{"Max", ReduceMax, []float64{3, 6}},
+		{"Mean", ReduceMean, []float64{2, 4}},
+		{"Sum", ReduceSum, []float64{4, 8}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			projection := ProjectTriangle(image, tri, dir, tc.reducer)
+			// Bin 0 is u=-1 and has no pixels.
+			if !math.IsInf(projection[0], -1) {
+				t.Errorf("Expected empty first bin, got %f", projection[0])
+			}
+			for i, want := range tc.want {
+				if got := projection[i+1]; got != want {
+					t.Errorf("bin %d: expected %f, got %f", i+1, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCumulativeProfileUniformCorridor(t *testing.T) {
+	const value = 5.0
+	image := corridorImage(100, 10, value)
+	origin := Point{X: 10, Y: 50}
+	direction := Point{X: 1, Y: 0}
+	fov := 10 * math.Pi / 180 // stays inside the corridor over 60 pixels
+	distance := 60.0
+
+	cumulative, distances, _, err := ProjectAngularSearchCumulative(image, origin, direction, fov, distance, ReduceMean)
+	if err != nil {
+		t.Fatalf("ProjectAngularSearchCumulative returned error: %v", err)
+	}
+	if len(cumulative) != len(distances) {
+		t.Fatalf("Expected matching lengths, got %d and %d", len(cumulative), len(distances))
+	}
+
+	// A uniform corridor must integrate linearly with distance.
+	for i, c := range cumulative {
+		want := value * distances[i]
+		if math.Abs(c-want) > 1e-9 {
+			t.Fatalf("bin %d: expected cumulative %f at distance %f, got %f", i, want, distances[i], c)
+		}
+	}
+
+	profile, _, err := ProjectAngularSearchReduce(image, origin, direction, fov, distance, ReduceMean)
+	if err != nil {
+		t.Fatalf("ProjectAngularSearchReduce returned error: %v", err)
+	}
+	totalDistance := distances[len(distances)-1]
+	half := DistanceForFraction(profile, distances, 0.5)
+	if math.Abs(half-totalDistance/2) > 1e-9 {
+		t.Errorf("Expected half-mass distance %f, got %f", totalDistance/2, half)
+	}
+	if got := DistanceForFraction(profile, distances, 1); math.Abs(got-totalDistance) > 1e-9 {
+		t.Errorf("Expected full-mass distance %f, got %f", totalDistance, got)
+	}
+}
+
+func TestDistanceForFractionEmptyBins(t *testing.T) {
+	empty := math.Inf(-1)
+	profile := []float64{empty, 2, empty, 2}
+	distances := []float64{1, 2, 3, 4}
+
+	cumulative := CumulativeProfile(profile)
+	want := []float64{0, 2, 2, 4}
+	for i := range want {
+		if cumulative[i] != want[i] {
+			t.Errorf("bin %d: expected cumulative %f, got %f", i, want[i], cumulative[i])
+		}
+	}
+
+	// Half of the mass sits entirely in the second bin.
+	if got := DistanceForFraction(profile, distances, 0.5); got != 2 {
+		t.Errorf("Expected half-mass distance 2, got %f", got)
+	}
+	if got := DistanceForFraction(profile, distances, 0.75); got != 3.5 {
+		t.Errorf("Expected 75%% distance 3.5, got %f", got)
+	}
+	if got := DistanceForFraction([]float64{empty, empty}, []float64{1, 2}, 0.5); got != 0 {
+		t.Errorf("Expected 0 for a profile without mass, got %f", got)
+	}
+}
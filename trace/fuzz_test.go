@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// fold maps v into [-limit, limit), keeping NaN and infinities invalid so
+// that input validation is exercised too.
+func fold(v, limit float64) float64 {
+	if math.IsInf(v, 0) {
+		return v
+	}
+	return math.Mod(v, limit)
+}
+
+func FuzzProjectAngularSearch(f *testing.F) {
+	f.Add(uint8(20), uint8(20), int64(1), 10.0, 10.0, 1.0, 0.0, 0.5, 8.0, uint8(0))
+	f.Add(uint8(1), uint8(1), int64(2), 0.0, 0.0, 0.0, 1.0, 3.0, 0.5, uint8(0))
+	f.Add(uint8(7), uint8(30), int64(3), -5.0, 40.0, 1.0, -1.0, 1.0, 60.0, uint8(0))
+	f.Add(uint8(16), uint8(9), int64(4), 3.5, 2.25, -0.3, 0.7, 0.01, 100.0, uint8(3))
+	f.Add(uint8(5), uint8(5), int64(5), math.NaN(), 1.0, 1.0, 1.0, 1.0, 5.0, uint8(0))
+	f.Add(uint8(5), uint8(5), int64(6), 2.0, 2.0, 0.0, 0.0, 1.0, 5.0, uint8(0))
+	f.Add(uint8(12), uint8(12), int64(7), 6.0, 6.0, 1.0, 1.0, math.Pi, math.Inf(1), uint8(0))
+
+	f.Fuzz(func(t *testing.T, w, h uint8, seed int64, ox, oy, dx, dy, fov, distance float64, shortRow uint8) {
+		width, height := int(w%64)+1, int(h%64)+1
+		rng := rand.New(rand.NewSource(seed))
+		present := map[float64]bool{}
+		image := make([][]float64, height)
+		for y := range image {
+			image[y] = make([]float64, width)
+			for x := range image[y] {
+				v := float64(rng.Intn(16))
+				image[y][x] = v
+				present[v] = true
+			}
+		}
+		// A non-zero shortRow truncates one row other than the first.
+		ragged := shortRow != 0 && height > 1
+		if ragged {
+			row := 1 + int(shortRow)%(height-1)
+			image[row] = image[row][:int(shortRow)%width]
+		}
+
+		limit := 4 * float64(max(width, height))
+		projection, _, err := ProjectAngularSearch(image,
+			Point{X: fold(ox, limit), Y: fold(oy, limit)},
+			Point{X: dx, Y: dy},
+			fold(fov, 4),
+			fold(distance, limit))
+		if ragged {
+			if !errors.Is(err, ErrRaggedImage) {
+				t.Fatalf("Expected ErrRaggedImage for a ragged image, got %v", err)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		for i, v := range projection {
+			if !math.IsInf(v, -1) && !present[v] {
+				t.Fatalf("Bin %d holds %v, which is not a pixel value of the image", i, v)
+			}
+		}
+	})
+}
+
+func TestProjectTriangleRaggedImage(t *testing.T) {
+	image := [][]float64{
+		{1, 1, 1, 1, 1, 1},
+		{2, 2},
+		{3, 3, 3, 3, 3, 3},
+	}
+	tri := Triangle{V1: Point{X: 0, Y: 0}, V2: Point{X: 5, Y: -3}, V3: Point{X: 5, Y: 3}}
+	// Must not panic; the missing pixels of row 1 are skipped.
+	ProjectTriangleMax(image, tri, Point{X: 1, Y: 0})
+
+	if _, _, err := ProjectAngularSearch(image, Point{X: 0, Y: 1}, Point{X: 1, Y: 0}, 1, 5); !errors.Is(err, ErrRaggedImage) {
+		t.Errorf("ProjectAngularSearch: expected ErrRaggedImage, got %v", err)
+	}
+	if _, _, _, err := ProjectAngularSearchWithOptions(image, Point{X: 0, Y: 1}, Point{X: 1, Y: 0}, 1, 5, ProjectOptions{}); !errors.Is(err, ErrRaggedImage) {
+		t.Errorf("ProjectAngularSearchWithOptions: expected ErrRaggedImage, got %v", err)
+	}
+	if _, err := MarchRay(image, Point{X: 0, Y: 0}, Point{X: 1, Y: 0}, 4, 1); !errors.Is(err, ErrRaggedImage) {
+		t.Errorf("MarchRay: expected ErrRaggedImage, got %v", err)
+	}
+	if err := ValidateImage(nil); err != nil {
+		t.Errorf("ValidateImage(nil): %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package trace
+
+import (
+	"image"
+	"math"
+)
+
+// Area returns the area of the triangle, whatever the order of its vertices.
+func (t Triangle) Area() float64 {
+	return math.Abs(cross(t.V1, t.V2, t.V3)) / 2
+}
+
+// Contains reports whether p lies inside the triangle or on its boundary,
+// by the rasterizer's own scan-line rule: p is inside if it lies between
+// the edges of one of the triangle's flat-top and flat-bottom parts at its
+// Y. So pixel (x, y) is covered by a search exactly when
+// Contains(Point{X: x, Y: y}) holds, even where rounding puts an edge a
+// hair off a pixel it passes through. Like the rasterizer, it finds nothing
+// in a triangle whose vertices all share the same Y.
+func (t Triangle) Contains(p Point) bool {
+	for _, half := range scanHalves(t) {
+		if half.yBot == half.yTop || p.Y < half.yTop || p.Y > half.yBot {
+			continue
+		}
+		if x1, x2 := half.span(p.Y); p.X >= x1 && p.X <= x2 {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the corners of the smallest axis-aligned rectangle holding
+// the triangle.
+func (t Triangle) Bounds() (min, max Point) {
+	min = Point{X: math.Min(t.V1.X, math.Min(t.V2.X, t.V3.X)), Y: math.Min(t.V1.Y, math.Min(t.V2.Y, t.V3.Y))}
+	max = Point{X: math.Max(t.V1.X, math.Max(t.V2.X, t.V3.X)), Y: math.Max(t.V1.Y, math.Max(t.V2.Y, t.V3.Y))}
+	return min, max
+}
+
+// ClipToRect returns the polygon left of the triangle inside r, taken as the
+// closed region from r.Min to r.Max, with its vertices in the triangle's
+// order. It returns nil if the triangle lies outside r. To clip a search to
+// the pixels of a w x h image, pass image.Rect(0, 0, w-1, h-1); the polygon
+// for drawing over the image is that of image.Rect(0, 0, w, h).
+func (t Triangle) ClipToRect(r image.Rectangle) []Point {
+	minX, minY := float64(r.Min.X), float64(r.Min.Y)
+	maxX, maxY := float64(r.Max.X), float64(r.Max.Y)
+	polygon := []Point{t.V1, t.V2, t.V3}
+	// Sutherland-Hodgman: clip against each edge of r in turn. inside
+	// reports which side of the edge a point is on, and at where the edge
+	// cuts the segment between two points.
+	for _, edge := range []struct {
+		inside func(p Point) bool
+		at     func(a, b Point) Point
+	}{
+		{func(p Point) bool { return p.X >= minX }, func(a, b Point) Point { return atX(a, b, minX) }},
+		{func(p Point) bool { return p.X <= maxX }, func(a, b Point) Point { return atX(a, b, maxX) }},
+		{func(p Point) bool { return p.Y >= minY }, func(a, b Point) Point { return atY(a, b, minY) }},
+		{func(p Point) bool { return p.Y <= maxY }, func(a, b Point) Point { return atY(a, b, maxY) }},
+	} {
+		var clipped []Point
+		for i, b := range polygon {
+			a := polygon[(i+len(polygon)-1)%len(polygon)]
+			switch {
+			case edge.inside(b):
+				if !edge.inside(a) {
+					clipped = appendDistinct(clipped, edge.at(a, b))
+				}
+				clipped = appendDistinct(clipped, b)
+			case edge.inside(a):
+				clipped = appendDistinct(clipped, edge.at(a, b))
+			}
+		}
+		if len(clipped) > 1 && clipped[0] == clipped[len(clipped)-1] {
+			clipped = clipped[:len(clipped)-1]
+		}
+		if len(clipped) == 0 {
+			return nil
+		}
+		polygon = clipped
+	}
+	return polygon
+}
+
+// cross returns the cross product of b - a and p - a, twice the signed area
+// of the triangle a, b, p.
+func cross(a, b, p Point) float64 {
+	return (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+}
+
+// atX returns the point of the segment from a to b at the given X.
+func atX(a, b Point, x float64) Point {
+	return Point{X: x, Y: a.Y + (x-a.X)*(b.Y-a.Y)/(b.X-a.X)}
+}
+
+// atY returns the point of the segment from a to b at the given Y.
+func atY(a, b Point, y float64) Point {
+	return Point{X: a.X + (y-a.Y)*(b.X-a.X)/(b.Y-a.Y), Y: y}
+}
+
+// appendDistinct appends p to polygon unless it repeats the last vertex.
+func appendDistinct(polygon []Point, p Point) []Point {
+	if len(polygon) > 0 && polygon[len(polygon)-1] == p {
+		return polygon
+	}
+	return append(polygon, p)
+}
+
+// clippedProjectionRange is like projectionRange but only counts the bins
+// up to the part of tri inside a width x height image. The rasterizer never
+// visits the pixels beyond, so a triangle reaching far past the image does
+// not allocate bins no pixel can fall in. The bins keep their numbering from
+// the whole triangle. It returns zero bins if tri misses the image.
+func clippedProjectionRange(tri Triangle, dirUnitVec Point, width, height int) (float64, int) {
+	uMin, bins := projectionRange(tri, dirUnitVec)
+	clipped := tri.ClipToRect(image.Rect(0, 0, width-1, height-1))
+	if len(clipped) == 0 {
+		return uMin, 0
+	}
+	uMax := math.Inf(-1)
+	for _, p := range clipped {
+		uMax = math.Max(uMax, dot(p, dirUnitVec))
+	}
+	return uMin, min(bins, int(math.Ceil(uMax))-int(math.Floor(uMin))+1)
+}
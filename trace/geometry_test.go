@@ -0,0 +1,130 @@
+package trace
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// shoelace returns the area of a polygon by the shoelace formula.
+func shoelace(polygon []Point) float64 {
+	var sum float64
+	for i, a := range polygon {
+		b := polygon[(i+1)%len(polygon)]
+		sum += a.X*b.Y - b.X*a.Y
+	}
+	return math.Abs(sum) / 2
+}
+
+// TestTriangleContainsMatchesRasterizer checks that Contains agrees with the
+// pixels the rasterizer visits, for triangles whose edges and vertices fall
+// on pixel coordinates.
+func TestTriangleContainsMatchesRasterizer(t *testing.T) {
+	const size = 16
+	img := make([][]float64, size)
+	for y := range img {
+		img[y] = make([]float64, size)
+	}
+	for _, tri := range []Triangle{
+		{V1: Point{X: 2, Y: 2}, V2: Point{X: 12, Y: 2}, V3: Point{X: 2, Y: 12}},           // flat top
+		{V1: Point{X: 7, Y: 1}, V2: Point{X: 1, Y: 13}, V3: Point{X: 13, Y: 13}},          // flat bottom
+		{V1: Point{X: 0, Y: 0}, V2: Point{X: 8, Y: 4}, V3: Point{X: 4, Y: 12}},            // general
+		{V1: Point{X: 14, Y: 3}, V2: Point{X: 3, Y: 5}, V3: Point{X: 9, Y: 15}},           // general
+		{V1: Point{X: 5, Y: -4}, V2: Point{X: 20, Y: 8}, V3: Point{X: -3, Y: 10}},         // beyond the image
+		{V1: Point{X: 3.5, Y: 2.25}, V2: Point{X: 11.5, Y: 6}, V3: Point{X: 6, Y: 13.75}}, // off the grid
+		{V1: Point{X: 2, Y: 6}, V2: Point{X: 8, Y: 6}, V3: Point{X: 14, Y: 6}},            // no height
+	} {
+		covered := make(map[image.Point]bool)
+		rasterizeTriangle(img, tri, func(_ [][]float64, x, y int) {
+			covered[image.Pt(x, y)] = true
+		})
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if got := tri.Contains(Point{X: float64(x), Y: float64(y)}); got != covered[image.Pt(x, y)] {
+					t.Errorf("%v: Contains(%d, %d) = %v, but the rasterizer covered it: %v", tri, x, y, got, covered[image.Pt(x, y)])
+				}
+			}
+		}
+	}
+
+	tri := Triangle{V1: Point{X: 0, Y: 0}, V2: Point{X: 8, Y: 4}, V3: Point{X: 4, Y: 12}}
+	for _, p := range []Point{tri.V1, tri.V2, tri.V3, {X: 4, Y: 2}, {X: 2, Y: 6}} {
+		if !tri.Contains(p) {
+			t.Errorf("Expected vertex or edge point %v to be contained", p)
+		}
+	}
+	if tri.Contains(Point{X: 8, Y: 0}) {
+		t.Error("Expected (8, 0) to lie outside")
+	}
+}
+
+// TestTriangleArea checks Area against the shoelace formula, and Bounds
+// against the vertices, on random triangles.
+func TestTriangleArea(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() Point { return Point{X: rng.Float64()*200 - 100, Y: rng.Float64()*200 - 100} }
+	for i := 0; i < 100; i++ {
+		tri := Triangle{V1: random(), V2: random(), V3: random()}
+		if got, want := tri.Area(), shoelace([]Point{tri.V1, tri.V2, tri.V3}); math.Abs(got-want) > 1e-9*math.Max(1, want) {
+			t.Errorf("%v: Area() = %v, want %v", tri, got, want)
+		}
+		min, max := tri.Bounds()
+		for _, v := range []Point{tri.V1, tri.V2, tri.V3} {
+			if v.X < min.X || v.Y < min.Y || v.X > max.X || v.Y > max.Y {
+				t.Errorf("%v: vertex %v lies outside Bounds() %v, %v", tri, v, min, max)
+			}
+		}
+	}
+}
+
+// TestTriangleClipToRect checks the clipped polygon of triangles inside,
+// across and outside a rectangle.
+func TestTriangleClipToRect(t *testing.T) {
+	r := image.Rect(0, 0, 10, 10)
+	inside := Triangle{V1: Point{X: 1, Y: 1}, V2: Point{X: 8, Y: 2}, V3: Point{X: 3, Y: 9}}
+	if got := inside.ClipToRect(r); len(got) != 3 || got[0] != inside.V1 || got[1] != inside.V2 || got[2] != inside.V3 {
+		t.Errorf("Expected a triangle inside the rectangle to be kept, got %v", got)
+	}
+
+	// Three quarters of this triangle lie right of the rectangle's left edge.
+	across := Triangle{V1: Point{X: -4, Y: 2}, V2: Point{X: 4, Y: 2}, V3: Point{X: 4, Y: 6}}
+	clipped := across.ClipToRect(r)
+	if len(clipped) != 4 {
+		t.Fatalf("Expected a quadrilateral, got %v", clipped)
+	}
+	if got, want := shoelace(clipped), across.Area()*3/4; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected the clipped area %v, got %v", want, got)
+	}
+	for _, p := range clipped {
+		if p.X < 0 || !across.Contains(p) {
+			t.Errorf("Clipped vertex %v lies outside the rectangle or the triangle", p)
+		}
+	}
+
+	outside := Triangle{V1: Point{X: 12, Y: 1}, V2: Point{X: 20, Y: 1}, V3: Point{X: 15, Y: 8}}
+	if got := outside.ClipToRect(r); got != nil {
+		t.Errorf("Expected nil for a triangle outside the rectangle, got %v", got)
+	}
+}
+
+// TestProjectionBoundedByImage checks that a search reaching far past the
+// image only allocates the bins the image can fill.
+func TestProjectionBoundedByImage(t *testing.T) {
+	img := make([][]float64, 10)
+	for y := range img {
+		img[y] = make([]float64, 10)
+	}
+	projection, _, err := ProjectAngularSearch(img, Point{X: 0, Y: 5}, Point{X: 1, Y: 0}, math.Pi/6, 1e7)
+	if err != nil {
+		t.Fatalf("ProjectAngularSearch failed: %v", err)
+	}
+	if len(projection) != 10 {
+		t.Errorf("Expected the 10 bins across the image, got %d", len(projection))
+	}
+	for i, v := range projection {
+		if math.IsInf(v, -1) {
+			t.Errorf("Bin %d is empty", i)
+		}
+	}
+}
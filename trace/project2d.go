@@ -0,0 +1,91 @@
+package trace
+
+import (
+	"errors"
+	"math"
+)
+
+// ProjectTriangle2D is like ProjectTriangle but keeps the across-direction
+// position of each pixel, producing a small rectified map of the wedge. The
+// result is indexed [along][across]: the along bins are those of
+// ProjectTriangle, and the across bins split the signed perpendicular offset
+// from the centreline, the line through tri.V1 along dirUnitVec, into
+// acrossBins equal parts spanning the widest vertex offset on either side.
+// Across bin 0 is on the left of the centreline looking along dirUnitVec in
+// image coordinates (y down), the side of V3 for a triangle built by
+// ProjectAngularSearch. Bins that receive no pixels are left at negative
+// infinity for every reducer.
+func ProjectTriangle2D(image [][]float64, tri Triangle, dirUnitVec Point, acrossBins int, reducer Reducer) ([][]float64, error) {
+	if acrossBins < 1 {
+		return nil, errors.New("acrossBins must be at least 1")
+	}
+	if err := ValidateImage(image); err != nil {
+		return nil, err
+	}
+	if len(image) == 0 || len(image[0]) == 0 {
+		return nil, nil
+	}
+
+	uMin, alongBins := clippedProjectionRange(tri, dirUnitVec, len(image[0]), len(image))
+	if alongBins <= 0 {
+		return nil, nil
+	}
+	values := make([][]float64, alongBins)
+	counts := make([][]int, alongBins)
+	for i := range values {
+		values[i] = make([]float64, acrossBins)
+		counts[i] = make([]int, acrossBins)
+		for j := range values[i] {
+			values[i][j] = math.Inf(-1)
+		}
+	}
+
+	perpVec := Point{X: -dirUnitVec.Y, Y: dirUnitVec.X}
+	vOrigin := dot(tri.V1, perpVec)
+	halfWidth := math.Max(math.Abs(dot(tri.V2, perpVec)-vOrigin), math.Abs(dot(tri.V3, perpVec)-vOrigin))
+	uMinFloored := math.Floor(uMin)
+
+	rasterizeTriangle(image, tri, func(image [][]float64, x, y int) {
+		p := Point{X: float64(x), Y: float64(y)}
+		i := int(math.Floor(dot(p, dirUnitVec)) - uMinFloored)
+		if i < 0 || i >= alongBins {
+			return
+		}
+		j := acrossBins / 2
+		if halfWidth > 0 {
+			offset := dot(p, perpVec) - vOrigin
+			j = int(math.Floor((offset + halfWidth) / (2 * halfWidth) * float64(acrossBins)))
+			j = max(0, min(acrossBins-1, j))
+		}
+		reduceInto(values[i], counts[i], j, image[y][x], reducer)
+	})
+	for i := range values {
+		finishReduce(values[i], counts[i], reducer)
+	}
+
+	return values, nil
+}
+
+// ProjectAngularSearch2D is like ProjectAngularSearchReduce but returns the
+// 2D map of ProjectTriangle2D, with acrossBins bins across the wedge.
+func ProjectAngularSearch2D(
+	image [][]float64,
+	origin Point,
+	direction Point,
+	fieldOfViewAngleRadians float64,
+	distance float64,
+	acrossBins int,
+	reducer Reducer,
+) ([][]float64, Triangle, error) {
+	tri, dirUnitVec, err := searchTriangle(origin, direction, fieldOfViewAngleRadians, distance)
+	if err != nil {
+		return nil, Triangle{}, err
+	}
+
+	projection, err := ProjectTriangle2D(image, tri, dirUnitVec, acrossBins, reducer)
+	if err != nil {
+		return nil, Triangle{}, err
+	}
+
+	return projection, tri, nil
+}
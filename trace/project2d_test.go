@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestProjectTriangle2DSeparatesFlanks(t *testing.T) {
+	image := make([][]float64, 20)
+	for i := range image {
+		image[i] = make([]float64, 20)
+	}
+	// Two hot pixels three pixels either side of the centreline y = 10.
+	image[7][10] = 5
+	image[13][10] = 9
+
+	tri, dir, err := searchTriangle(Point{X: 2, Y: 10}, Point{X: 1, Y: 0}, math.Pi/3, 15)
+	if err != nil {
+		t.Fatalf("searchTriangle failed: %v", err)
+	}
+	projection, err := ProjectTriangle2D(image, tri, dir, 4, ReduceMax)
+	if err != nil {
+		t.Fatalf("ProjectTriangle2D failed: %v", err)
+	}
+
+	const along = 10 - 2
+	row := projection[along]
+	left, right := -1, -1
+	for j, v := range row {
+		switch v {
+		case 5:
+			left = j
+		case 9:
+			right = j
+		}
+	}
+	if left < 0 || right < 0 || left == right {
+		t.Fatalf("Expected the hot pixels in different across bins of along bin %d, got %v", along, row)
+	}
+	if left > right {
+		t.Errorf("Expected the pixel above the centreline in a lower across bin, got %d and %d", left, right)
+	}
+	if got := ProjectTriangle(image, tri, dir, ReduceMax)[along]; got != 9 {
+		t.Errorf("Expected the 1D profile to merge the flanks into 9, got %f", got)
+	}
+}
+
+func TestProjectTriangle2DMatchesProfile(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	image := make([][]float64, 60)
+	for i := range image {
+		image[i] = make([]float64, 80)
+		for j := range image[i] {
+			image[i][j] = rng.Float64() * 10
+		}
+	}
+	tri, dir, err := searchTriangle(Point{X: 5, Y: 8}, Point{X: 3, Y: 2}, 0.8, 55)
+	if err != nil {
+		t.Fatalf("searchTriangle failed: %v", err)
+	}
+
+	for _, reducer := range []Reducer{ReduceMax, ReduceSum} {
+		profile := ProjectTriangle(image, tri, dir, reducer)
+		projection, err := ProjectTriangle2D(image, tri, dir, 7, reducer)
+		if err != nil {
+			t.Fatalf("ProjectTriangle2D failed: %v", err)
+		}
+		if len(projection) != len(profile) {
+			t.Fatalf("Expected %d along bins, got %d", len(profile), len(projection))
+		}
+		// Reducing each row across the wedge gives back the 1D profile.
+		for i, row := range projection {
+			got := math.Inf(-1)
+			for _, v := range row {
+				switch {
+				case math.IsInf(v, -1):
+				case reducer == ReduceMax || math.IsInf(got, -1):
+					got = math.Max(got, v)
+				default:
+					got += v
+				}
+			}
+			if math.Abs(got-profile[i]) > 1e-9 && !(math.IsInf(got, -1) && math.IsInf(profile[i], -1)) {
+				t.Errorf("Reducer %d, along bin %d: rows reduce to %f, profile has %f", reducer, i, got, profile[i])
+			}
+		}
+	}
+
+	if _, err := ProjectTriangle2D(image, tri, dir, 0, ReduceMax); err == nil {
+		t.Error("Expected an error for zero across bins")
+	}
+}
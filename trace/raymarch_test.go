@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// diagonalImage returns a size x size image whose pixel (x, y) is 100 on the
+// main diagonal and 10*x elsewhere.
+func diagonalImage(size int) [][]float64 {
+	image := make([][]float64, size)
+	for y := range image {
+		image[y] = make([]float64, size)
+		for x := range image[y] {
+			image[y][x] = 10 * float64(x)
+		}
+		image[y][y] = 100
+	}
+	return image
+}
+
+func TestMarchRayAlongDiagonal(t *testing.T) {
+	image := diagonalImage(10)
+	step := math.Sqrt2 // one pixel along the diagonal per step
+	samples, err := MarchRay(image, Point{X: 0, Y: 0}, Point{X: 1, Y: 1}, 9*math.Sqrt2, step)
+	if err != nil {
+		t.Fatalf("MarchRay failed: %v", err)
+	}
+	if len(samples) != 10 {
+		t.Fatalf("Expected 10 samples, got %d", len(samples))
+	}
+	for i, s := range samples {
+		if math.Abs(s.Position.X-float64(i)) > 1e-9 || math.Abs(s.Position.Y-float64(i)) > 1e-9 {
+			t.Errorf("Sample %d at (%f, %f), expected (%d, %d)", i, s.Position.X, s.Position.Y, i, i)
+		}
+		if math.Abs(s.Distance-float64(i)*step) > 1e-9 {
+			t.Errorf("Sample %d distance %f, expected %f", i, s.Distance, float64(i)*step)
+		}
+		if math.Abs(s.Value-100) > 1e-9 {
+			t.Errorf("Sample %d value %f, expected 100", i, s.Value)
+		}
+	}
+}
+
+func TestMarchRayInterpolatesAndStopsAtBoundary(t *testing.T) {
+	image := diagonalImage(10)
+	// Half-pixel steps along row 3, which is 10*x except at the diagonal.
+	samples, err := MarchRay(image, Point{X: 5, Y: 3}, Point{X: 1, Y: 0}, 10, 0.5)
+	if !errors.Is(err, ErrRayLeftImage) {
+		t.Fatalf("Expected ErrRayLeftImage, got %v", err)
+	}
+	// x = 5, 5.5, ..., 9 lies inside; 9.5 does not.
+	if len(samples) != 9 {
+		t.Fatalf("Expected 9 samples before the boundary, got %d", len(samples))
+	}
+	for i, s := range samples {
+		x := 5 + 0.5*float64(i)
+		if s.Position.X != x || s.Position.Y != 3 {
+			t.Errorf("Sample %d at (%f, %f), expected (%f, 3)", i, s.Position.X, s.Position.Y, x)
+		}
+		if want := 10 * x; math.Abs(s.Value-want) > 1e-9 {
+			t.Errorf("Sample %d value %f, expected %f", i, s.Value, want)
+		}
+	}
+
+	// Starting outside the image yields no samples.
+	if samples, err := MarchRay(image, Point{X: -1, Y: 0}, Point{X: 1, Y: 0}, 5, 1); !errors.Is(err, ErrRayLeftImage) || len(samples) != 0 {
+		t.Errorf("Expected no samples and ErrRayLeftImage from outside the image, got %d and %v", len(samples), err)
+	}
+}
+
+func TestMarchRayNaNNeighbour(t *testing.T) {
+	image := [][]float64{{1, math.NaN()}, {3, 4}}
+	samples, err := MarchRay(image, Point{X: 0, Y: 0}, Point{X: 0, Y: 1}, 1, 1)
+	if err != nil {
+		t.Fatalf("MarchRay failed: %v", err)
+	}
+	if samples[0].Value != 1 || samples[1].Value != 3 {
+		t.Errorf("Expected values 1 and 3 on pixel centres, got %f and %f", samples[0].Value, samples[1].Value)
+	}
+}
+
+func TestMarchRayInvalidInput(t *testing.T) {
+	image := diagonalImage(4)
+	cases := map[string]func() ([]RaySample, error){
+		"empty image":    func() ([]RaySample, error) { return MarchRay(nil, Point{}, Point{X: 1}, 1, 1) },
+		"zero distance":  func() ([]RaySample, error) { return MarchRay(image, Point{}, Point{X: 1}, 0, 1) },
+		"zero step":      func() ([]RaySample, error) { return MarchRay(image, Point{}, Point{X: 1}, 1, 0) },
+		"zero direction": func() ([]RaySample, error) { return MarchRay(image, Point{}, Point{}, 1, 1) },
+	}
+	for name, march := range cases {
+		if _, err := march(); err == nil || errors.Is(err, ErrRayLeftImage) {
+			t.Errorf("%s: expected a validation error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// Bins is a projection profile, or one along bin of a 2D projection, as it
+// is encoded in JSON. JSON has no number for the negative infinity of the
+// bins no pixel reached, nor for NaN, so those bins are encoded as null,
+// and null decodes back to negative infinity.
+type Bins []float64
+
+// MarshalJSON encodes b as an array of numbers, with null for the empty and
+// NaN bins.
+func (b Bins) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	out := make([]*float64, len(b))
+	for i := range b {
+		if !math.IsInf(b[i], 0) && !math.IsNaN(b[i]) {
+			out[i] = &b[i]
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes an array of numbers, reading null as an empty bin.
+func (b *Bins) UnmarshalJSON(data []byte) error {
+	var in []*float64
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in == nil {
+		*b = nil
+		return nil
+	}
+	*b = make(Bins, len(in))
+	for i, v := range in {
+		if v == nil {
+			(*b)[i] = math.Inf(-1)
+		} else {
+			(*b)[i] = *v
+		}
+	}
+	return nil
+}
+
+// ProjectionResult is the outcome of an angular search, as returned by
+// SearchProjection and encoded by the /trace API.
+type ProjectionResult struct {
+	// Projection is the profile along the search direction; see Bins for
+	// how its empty bins are encoded.
+	Projection Bins `json:"projection"`
+	// Counts is the number of pixels that contributed to each bin.
+	Counts []int `json:"counts"`
+	// Triangle is the search region.
+	Triangle Triangle `json:"triangle"`
+}
+
+// FanResult is the outcome of a 2D angular search, as returned by
+// SearchFan and encoded by the /trace API's "2d" mode.
+type FanResult struct {
+	// Projection is indexed [along][across], as ProjectTriangle2D; see Bins
+	// for how its empty bins are encoded.
+	Projection []Bins `json:"projection"`
+	// Triangle is the search region.
+	Triangle Triangle `json:"triangle"`
+}
+
+// RaySampleResult is the outcome of a ray march, as returned by
+// SampleRay and encoded by the /trace API's "march" mode.
+type RaySampleResult struct {
+	// Samples are those of MarchRay, in order from the origin.
+	Samples []RaySample `json:"samples"`
+	// Complete is false if the ray left the image before covering the
+	// requested distance.
+	Complete bool `json:"complete"`
+}
+
+// SearchProjection runs ProjectAngularSearchWithOptions and returns its
+// outcome as a ProjectionResult.
+func SearchProjection(image [][]float64, origin, direction Point, fieldOfViewAngleRadians, distance float64, opts ProjectOptions) (ProjectionResult, error) {
+	projection, counts, tri, err := ProjectAngularSearchWithOptions(image, origin, direction, fieldOfViewAngleRadians, distance, opts)
+	if err != nil {
+		return ProjectionResult{}, err
+	}
+	return ProjectionResult{Projection: projection, Counts: counts, Triangle: tri}, nil
+}
+
+// SearchFan runs ProjectAngularSearch2D and returns its outcome as a
+// FanResult.
+func SearchFan(image [][]float64, origin, direction Point, fieldOfViewAngleRadians, distance float64, acrossBins int, reducer Reducer) (FanResult, error) {
+	projection, tri, err := ProjectAngularSearch2D(image, origin, direction, fieldOfViewAngleRadians, distance, acrossBins, reducer)
+	if err != nil {
+		return FanResult{}, err
+	}
+	rows := make([]Bins, len(projection))
+	for i, row := range projection {
+		rows[i] = row
+	}
+	return FanResult{Projection: rows, Triangle: tri}, nil
+}
+
+// SampleRay runs MarchRay and returns its outcome as a RaySampleResult. A
+// ray that leaves the image is not an error: its samples so far are
+// returned with Complete false.
+func SampleRay(image [][]float64, origin, direction Point, distance, stepSize float64) (RaySampleResult, error) {
+	samples, err := MarchRay(image, origin, direction, distance, stepSize)
+	if err != nil && !errors.Is(err, ErrRayLeftImage) {
+		return RaySampleResult{}, err
+	}
+	return RaySampleResult{Samples: samples, Complete: err == nil}, nil
+}
@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestProjectionResultJSON checks the field names of an encoded
+// ProjectionResult, that its empty and NaN bins encode as null, and that it
+// decodes back with empty bins at negative infinity.
+func TestProjectionResultJSON(t *testing.T) {
+	result := ProjectionResult{
+		Projection: Bins{1.5, math.Inf(-1), math.NaN(), 0},
+		Counts:     []int{2, 0, 1, 3},
+		Triangle:   Triangle{V1: Point{X: 1, Y: 2}},
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	if len(fields) != 3 || fields["projection"] == nil || fields["counts"] == nil || fields["triangle"] == nil {
+		t.Errorf("Expected the fields projection, counts and triangle, got %v", names)
+	}
+	if got := string(fields["projection"]); got != "[1.5,null,null,0]" {
+		t.Errorf("Expected empty and NaN bins to encode as null, got %s", got)
+	}
+
+	var decoded ProjectionResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := Bins{1.5, math.Inf(-1), math.Inf(-1), 0}
+	if !reflect.DeepEqual(decoded.Projection, want) || !reflect.DeepEqual(decoded.Counts, result.Counts) || decoded.Triangle != result.Triangle {
+		t.Errorf("Expected %+v to decode with empty bins at negative infinity, got %+v", result, decoded)
+	}
+}
+
+// TestResultsMatchSearches checks that the result functions return what the
+// searches they wrap do.
+func TestResultsMatchSearches(t *testing.T) {
+	image := diagonalImage(20)
+	origin, direction := Point{X: 2, Y: 10}, Point{X: 1, Y: 0}
+
+	result, err := SearchProjection(image, origin, direction, 0.5, 12, ProjectOptions{Reducer: ReduceMean})
+	if err != nil {
+		t.Fatalf("SearchProjection failed: %v", err)
+	}
+	projection, counts, tri, _ := ProjectAngularSearchWithOptions(image, origin, direction, 0.5, 12, ProjectOptions{Reducer: ReduceMean})
+	if !reflect.DeepEqual([]float64(result.Projection), projection) || !reflect.DeepEqual(result.Counts, counts) || result.Triangle != tri {
+		t.Error("Expected SearchProjection to return the profile, counts and triangle of ProjectAngularSearchWithOptions")
+	}
+
+	fan, err := SearchFan(image, origin, direction, 0.5, 12, 3, ReduceMax)
+	if err != nil {
+		t.Fatalf("SearchFan failed: %v", err)
+	}
+	rows, _, _ := ProjectAngularSearch2D(image, origin, direction, 0.5, 12, 3, ReduceMax)
+	if len(fan.Projection) != len(rows) {
+		t.Fatalf("Expected %d along bins, got %d", len(rows), len(fan.Projection))
+	}
+	for i, row := range rows {
+		if !reflect.DeepEqual([]float64(fan.Projection[i]), row) {
+			t.Errorf("Along bin %d: expected %v, got %v", i, row, fan.Projection[i])
+		}
+	}
+	if _, err := SearchFan(image, origin, direction, 0.5, 12, 0, ReduceMax); err == nil {
+		t.Error("Expected an error for no across bins")
+	}
+
+	ray, err := SampleRay(image, origin, direction, 100, 1)
+	if err != nil {
+		t.Fatalf("SampleRay failed: %v", err)
+	}
+	if ray.Complete || len(ray.Samples) != 18 {
+		t.Errorf("Expected the ray to leave the image after 18 samples, got %d, complete %v", len(ray.Samples), ray.Complete)
+	}
+	if ray, err := SampleRay(image, origin, direction, 5, 1); err != nil || !ray.Complete {
+		t.Errorf("Expected a complete ray within the image, got %v, %v", ray.Complete, err)
+	}
+	if _, err := SampleRay(image, origin, Point{}, 5, 1); err == nil {
+		t.Error("Expected an error for a zero direction")
+	}
+}
@@ -0,0 +1,215 @@
+package trace
+
+import (
+	"errors"
+	"math"
+)
+
+// Defaults for the zero fields of RidgeOptions.
+const (
+	DefaultRidgeStepSize    = 2.0
+	DefaultRidgeLookAhead   = 8.0
+	DefaultRidgeFieldOfView = math.Pi / 8
+	DefaultRidgeMaxTurn     = math.Pi / 6
+	DefaultRidgeCandidates  = 9
+)
+
+// RidgeOptions configures FollowRidge. Zero fields take the defaults above.
+type RidgeOptions struct {
+	// StepSize is the distance in pixels between consecutive path points.
+	StepSize float64
+	// LookAhead is the length in pixels of the wedge scored for each
+	// candidate direction.
+	LookAhead float64
+	// FieldOfViewRadians is the total angular width of each wedge.
+	FieldOfViewRadians float64
+	// MaxTurnRadians is the largest change of direction in one step.
+	MaxTurnRadians float64
+	// Candidates is the number of directions tried per step, spread evenly
+	// from -MaxTurnRadians to MaxTurnRadians around the current heading.
+	Candidates int
+	// Threshold stops the path before the first step whose interpolated
+	// value is below it.
+	Threshold float64
+	// MaxLength is the longest path in pixels. Zero means the image's
+	// width plus height.
+	MaxLength float64
+	// Project bins each wedge and skips its no-data pixels, as in
+	// ProjectAngularSearchWithOptions.
+	Project ProjectOptions
+}
+
+func (o RidgeOptions) stepSize() float64 {
+	if o.StepSize == 0 {
+		return DefaultRidgeStepSize
+	}
+	return o.StepSize
+}
+
+func (o RidgeOptions) lookAhead() float64 {
+	if o.LookAhead == 0 {
+		return DefaultRidgeLookAhead
+	}
+	return o.LookAhead
+}
+
+func (o RidgeOptions) fieldOfView() float64 {
+	if o.FieldOfViewRadians == 0 {
+		return DefaultRidgeFieldOfView
+	}
+	return o.FieldOfViewRadians
+}
+
+func (o RidgeOptions) maxTurn() float64 {
+	if o.MaxTurnRadians == 0 {
+		return DefaultRidgeMaxTurn
+	}
+	return o.MaxTurnRadians
+}
+
+func (o RidgeOptions) candidates() int {
+	if o.Candidates == 0 {
+		return DefaultRidgeCandidates
+	}
+	return o.Candidates
+}
+
+func (o RidgeOptions) validate() error {
+	switch {
+	case o.StepSize < 0 || o.LookAhead < 0 || o.MaxLength < 0:
+		return errors.New("ridge step size, look-ahead and maximum length must not be negative")
+	case o.MaxTurnRadians < 0 || o.MaxTurnRadians >= math.Pi:
+		return errors.New("ridge maximum turn must be between 0 and Pi (180 degrees)")
+	case o.Candidates < 0:
+		return errors.New("ridge candidates must not be negative")
+	}
+	return nil
+}
+
+// FollowRidge traces the locally strongest values of image from origin,
+// starting along initialDirection. At every step it scores a short wedge,
+// LookAhead long with its apex at the current point, for each candidate
+// heading within MaxTurnRadians of the current one, and moves StepSize
+// along the best. A wedge's score is the mean of its non-empty profile
+// bins, reduced with opts.Project; ties go to the smallest turn.
+//
+// The path stops before a step whose bilinearly interpolated value is
+// below opts.Threshold or that leaves the image, or once it is MaxLength
+// long. FollowRidge returns the path, starting at origin, and the value at
+// each of its points. It returns an error for a ragged or empty image, an
+// origin outside the image, or invalid options.
+func FollowRidge(image [][]float64, origin Point, initialDirection Point, opts RidgeOptions) ([]Point, []float64, error) {
+	if len(image) == 0 || len(image[0]) == 0 {
+		return nil, nil, errors.New("image must not be empty")
+	}
+	if err := ValidateImage(image); err != nil {
+		return nil, nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	heading, mag := normalize(initialDirection)
+	if mag == 0 {
+		return nil, nil, errors.New("direction vector cannot be zero")
+	}
+	value, ok := bilinear(image, origin)
+	if !ok {
+		return nil, nil, errors.New("origin must lie inside the image")
+	}
+	// Check the wedge geometry once, so errors are not mistaken for the
+	// end of the ridge.
+	if _, _, err := searchTriangle(origin, heading, opts.fieldOfView(), opts.lookAhead()); err != nil {
+		return nil, nil, err
+	}
+
+	maxLength := opts.MaxLength
+	if maxLength == 0 {
+		maxLength = float64(len(image) + len(image[0]))
+	}
+	step := opts.stepSize()
+	steps := int(math.Floor(maxLength/step + boundsEpsilon))
+
+	path := []Point{origin}
+	values := []float64{value}
+	p := origin
+	for k := 0; k < steps; k++ {
+		next, ok := bestHeading(image, p, heading, opts)
+		if !ok {
+			break
+		}
+		q := Point{X: p.X + next.X*step, Y: p.Y + next.Y*step}
+		v, inside := bilinear(image, q)
+		if !inside || v < opts.Threshold || math.IsNaN(v) {
+			break
+		}
+		path = append(path, q)
+		values = append(values, v)
+		p, heading = q, next
+	}
+	return path, values, nil
+}
+
+// bestHeading returns the candidate heading from p whose look-ahead wedge
+// scores highest, trying them from the smallest turn outwards, and false if
+// no wedge holds any data.
+func bestHeading(image [][]float64, p, heading Point, opts RidgeOptions) (Point, bool) {
+	n := opts.candidates()
+	maxTurn := opts.maxTurn()
+	var best Point
+	bestScore := math.Inf(-1)
+	found := false
+	for i := 0; i < n; i++ {
+		// The turns are -maxTurn + j*2*maxTurn/(n-1). In half steps of
+		// that grid from straight ahead they go 0, +2, -2, +4, -4, ...
+		// for odd n and +1, -1, +3, -3, ... for even n.
+		h := 2 * ((i + 1) / 2)
+		if i%2 == 0 {
+			h = -h
+		}
+		if n%2 == 0 {
+			h = 2*(i/2) + 1
+			if i%2 == 1 {
+				h = -h
+			}
+		}
+		turn := 0.0
+		if n > 1 {
+			turn = float64(h) * maxTurn / float64(n-1)
+		}
+		dir := rotate(heading, turn)
+		tri, dirUnitVec, err := searchTriangle(p, dir, opts.fieldOfView(), opts.lookAhead())
+		if err != nil {
+			continue
+		}
+		profile, counts := ProjectTriangleWithOptions(image, tri, dirUnitVec, opts.Project)
+		score, ok := profileMean(profile, counts)
+		if ok && score > bestScore {
+			best, bestScore, found = dirUnitVec, score, true
+		}
+	}
+	return best, found
+}
+
+// profileMean returns the mean of the non-empty bins of a profile, and false
+// if every bin is empty.
+func profileMean(profile []float64, counts []int) (float64, bool) {
+	var sum float64
+	n := 0
+	for i, v := range profile {
+		if counts[i] > 0 && !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// rotate turns v by angle radians, clockwise on screen for positive angles
+// since image Y grows downward.
+func rotate(v Point, angle float64) Point {
+	s, c := math.Sincos(angle)
+	return Point{X: v.X*c - v.Y*s, Y: v.X*s + v.Y*c}
+}
@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"math"
+	"testing"
+)
+
+// arcBand returns a size x size image with a bright band of Gaussian
+// cross-section along the circle of the given centre and radius, from the
+// top of the circle clockwise through the given angle, and zero elsewhere.
+func arcBand(size int, centre Point, radius, sweep float64) [][]float64 {
+	image := make([][]float64, size)
+	for y := range image {
+		image[y] = make([]float64, size)
+		for x := range image[y] {
+			dx, dy := float64(x)-centre.X, float64(y)-centre.Y
+			// Angle clockwise on screen from the top of the circle.
+			angle := math.Atan2(dx, -dy)
+			if angle < 0 || angle > sweep {
+				continue
+			}
+			d := math.Hypot(dx, dy) - radius
+			image[y][x] = 10 * math.Exp(-d*d/(2*3*3))
+		}
+	}
+	return image
+}
+
+func TestFollowRidgeStaysOnCurvedBand(t *testing.T) {
+	centre := Point{X: 40, Y: 140}
+	const radius = 100.0
+	image := arcBand(200, centre, radius, math.Pi/2)
+	origin := Point{X: centre.X, Y: centre.Y - radius}
+
+	path, values, err := FollowRidge(image, origin, Point{X: 1, Y: 0}, RidgeOptions{Threshold: 5})
+	if err != nil {
+		t.Fatalf("FollowRidge failed: %v", err)
+	}
+	if len(values) != len(path) || path[0] != origin {
+		t.Fatalf("Expected a path from the origin with one value per point, got %d points and %d values", len(path), len(values))
+	}
+	for i, p := range path {
+		if values[i] < 5 {
+			t.Errorf("Point %d has value %.2f below the threshold", i, values[i])
+		}
+		// Near the end of the band the look-ahead wedges run past it, so
+		// only the points before then must stay centred.
+		if math.Atan2(p.X-centre.X, centre.Y-p.Y) > math.Pi/2-DefaultRidgeLookAhead/radius {
+			continue
+		}
+		if off := math.Abs(math.Hypot(p.X-centre.X, p.Y-centre.Y) - radius); off > 1 {
+			t.Errorf("Point %d at %v is %.2f pixels off the band", i, p, off)
+		}
+	}
+	// The band is a quarter circle ending due east of the centre.
+	end := path[len(path)-1]
+	if d := math.Hypot(end.X-(centre.X+radius), end.Y-centre.Y); d > 2*DefaultRidgeStepSize+1 {
+		t.Errorf("Expected the path to end where the band does, at (%v, %v), got %v", centre.X+radius, centre.Y, end)
+	}
+
+	if _, _, err := FollowRidge(image, origin, Point{}, RidgeOptions{}); err == nil {
+		t.Error("Expected an error for a zero direction")
+	}
+	if _, _, err := FollowRidge(image, Point{X: -5, Y: 0}, Point{X: 1}, RidgeOptions{}); err == nil {
+		t.Error("Expected an error for an origin outside the image")
+	}
+}
+
+// TestBestHeadingReachesMaxTurnWithEvenCandidates points a straight band
+// MaxTurnRadians off the heading and checks that an even number of
+// candidates still tries the extreme turns and follows the band.
+func TestBestHeadingReachesMaxTurnWithEvenCandidates(t *testing.T) {
+	const size = 200
+	p := Point{X: 40, Y: 100}
+	opts := RidgeOptions{MaxTurnRadians: math.Pi / 6, Candidates: 4}
+	for _, turn := range []float64{math.Pi / 6, -math.Pi / 6} {
+		band := rotate(Point{X: 1}, turn)
+		image := make([][]float64, size)
+		for y := range image {
+			image[y] = make([]float64, size)
+			for x := range image[y] {
+				dx, dy := float64(x)-p.X, float64(y)-p.Y
+				d := dx*band.Y - dy*band.X
+				image[y][x] = 10 * math.Exp(-d*d/(2*2*2))
+			}
+		}
+		got, ok := bestHeading(image, p, Point{X: 1}, opts)
+		if !ok {
+			t.Fatalf("Turn %.3f: expected a heading", turn)
+		}
+		if math.Abs(got.X-band.X) > 1e-9 || math.Abs(got.Y-band.Y) > 1e-9 {
+			t.Errorf("Turn %.3f: expected heading %v along the band, got %v", turn, band, got)
+		}
+	}
+}
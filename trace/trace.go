@@ -36,6 +36,85 @@ type Triangle struct {
 	V1, V2, V3 Point
 }
 
+// Reducer selects how the pixel values that fall into the same projection bin
+// are combined into a single profile value.
+type Reducer int
+
+const (
+	// ReduceMax keeps the largest value in each bin.
+	ReduceMax Reducer = iota
+	// ReduceMean averages the values in each bin.
+	ReduceMean
+	// ReduceSum adds up the values in each bin.
+	ReduceSum
+)
+
+// ProjectOptions configures ProjectTriangleWithOptions and
+// ProjectAngularSearchWithOptions.
+type ProjectOptions struct {
+	// Reducer combines the pixels of each bin.
+	Reducer Reducer
+	// HasNoData enables NoDataValue. Pixels equal to NoDataValue are then
+	// excluded from every reducer and from the bin counts. A NaN NoDataValue
+	// matches NaN pixels.
+	HasNoData   bool
+	NoDataValue float64
+	// HasMinValue enables MinValue. Pixels below MinValue, such as the
+	// zeros of empty sky, are then skipped as they are rasterized, before
+	// they are projected, so they count for no reducer and in no bin: a
+	// ReduceMean bin is the mean of its included pixels and its count is
+	// theirs alone, and a bin with none is empty. On a mostly empty frame
+	// this skips nearly all the work.
+	HasMinValue bool
+	MinValue    float64
+	// HasSaturation enables Saturation for ReduceMax. A bin whose maximum
+	// reaches Saturation then takes no more pixels: its value is the first
+	// one at or above Saturation and its count stops there. Other reducers
+	// ignore it.
+	HasSaturation bool
+	Saturation    float64
+}
+
+// ErrRaggedImage is returned for images whose rows are not all the same
+// length.
+var ErrRaggedImage = errors.New("image rows differ in length")
+
+// ValidateImage reports an error wrapping ErrRaggedImage if the rows of
+// image are not all as long as the first. The search functions that return
+// an error call it themselves; the ProjectTriangle functions skip pixels
+// beyond the end of a short row instead.
+func ValidateImage(image [][]float64) error {
+	for y := 1; y < len(image); y++ {
+		if len(image[y]) != len(image[0]) {
+			return fmt.Errorf("%w: row %d has %d columns, row 0 has %d", ErrRaggedImage, y, len(image[y]), len(image[0]))
+		}
+	}
+	return nil
+}
+
+// isNoData reports whether v is the no-data sentinel of opts.
+func (opts ProjectOptions) isNoData(v float64) bool {
+	if !opts.HasNoData {
+		return false
+	}
+	if math.IsNaN(opts.NoDataValue) {
+		return math.IsNaN(v)
+	}
+	return v == opts.NoDataValue
+}
+
+// skips reports whether the pixel value v is left out of the projection,
+// as no data or below MinValue.
+func (opts ProjectOptions) skips(v float64) bool {
+	return opts.isNoData(v) || opts.HasMinValue && v < opts.MinValue
+}
+
+// saturated reports whether bin i of values, holding counts[i] pixels, has
+// reached the Saturation of opts and takes no more pixels.
+func (opts ProjectOptions) saturated(values []float64, counts []int, i int) bool {
+	return opts.HasSaturation && opts.Reducer == ReduceMax && counts[i] > 0 && values[i] >= opts.Saturation
+}
+
 // ProjectAngularSearch performs an angular search of an image using a triangular region.
 // It creates a triangle with the apex at 'origin', pointing in 'direction' with
 // a field of view specified by 'fieldOfViewAngleRadians' and extending to 'distance'.
@@ -60,19 +139,78 @@ func ProjectAngularSearch(
 	fieldOfViewAngleRadians float64,
 	distance float64,
 ) ([]float64, Triangle, error) {
+	return ProjectAngularSearchReduce(image, origin, direction, fieldOfViewAngleRadians, distance, ReduceMax)
+}
+
+// ProjectAngularSearchReduce is like ProjectAngularSearch but combines the
+// pixels of each bin with the given reducer instead of always taking the
+// maximum.
+func ProjectAngularSearchReduce(
+	image [][]float64,
+	origin Point,
+	direction Point,
+	fieldOfViewAngleRadians float64,
+	distance float64,
+	reducer Reducer,
+) ([]float64, Triangle, error) {
+	if err := ValidateImage(image); err != nil {
+		return nil, Triangle{}, err
+	}
+	tri, dirUnitVec, err := searchTriangle(origin, direction, fieldOfViewAngleRadians, distance)
+	if err != nil {
+		return nil, Triangle{}, err
+	}
+
+	projection := ProjectTriangle(image, tri, dirUnitVec, reducer)
+
+	return projection, tri, nil
+}
 
+// ProjectAngularSearchWithOptions is like ProjectAngularSearchReduce but
+// takes its reducer and no-data handling from opts. Alongside the profile it
+// returns the number of pixels that contributed to each bin; a count of zero
+// marks an empty bin regardless of the data's value range.
+func ProjectAngularSearchWithOptions(
+	image [][]float64,
+	origin Point,
+	direction Point,
+	fieldOfViewAngleRadians float64,
+	distance float64,
+	opts ProjectOptions,
+) ([]float64, []int, Triangle, error) {
+	if err := ValidateImage(image); err != nil {
+		return nil, nil, Triangle{}, err
+	}
+	tri, dirUnitVec, err := searchTriangle(origin, direction, fieldOfViewAngleRadians, distance)
+	if err != nil {
+		return nil, nil, Triangle{}, err
+	}
+
+	projection, counts := ProjectTriangleWithOptions(image, tri, dirUnitVec, opts)
+
+	return projection, counts, tri, nil
+}
+
+// searchTriangle validates the search parameters and builds the triangle
+// with its apex at origin. It also returns the normalized direction.
+func searchTriangle(origin, direction Point, fieldOfViewAngleRadians, distance float64) (Triangle, Point, error) {
 	// --- 1. Validate Inputs ---
+	for _, v := range []float64{origin.X, origin.Y, direction.X, direction.Y, fieldOfViewAngleRadians, distance} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Triangle{}, Point{}, errors.New("search parameters must be finite")
+		}
+	}
 	if distance <= 0 {
-		return nil, Triangle{}, errors.New("distance must be positive")
+		return Triangle{}, Point{}, errors.New("distance must be positive")
 	}
 	if fieldOfViewAngleRadians <= 0 || fieldOfViewAngleRadians >= math.Pi {
-		return nil, Triangle{}, errors.New("fieldOfViewAngleRadians must be between 0 and Pi (180 degrees)")
+		return Triangle{}, Point{}, errors.New("fieldOfViewAngleRadians must be between 0 and Pi (180 degrees)")
 	}
 
 	// Normalize the direction vector
 	dirUnitVec, mag := normalize(direction)
 	if mag == 0 {
-		return nil, Triangle{}, errors.New("direction vector cannot be zero")
+		return Triangle{}, Point{}, errors.New("direction vector cannot be zero")
 	}
 
 	// --- 2. Calculate Triangle Vertices ---
@@ -92,65 +230,170 @@ func ProjectAngularSearch(
 		X: baseCenter.X - perpVec.X*halfWidth,
 		Y: baseCenter.Y - perpVec.Y*halfWidth,
 	}
-	tri := Triangle{V1: v1, V2: v2, V3: v3}
-
-	// --- 3. Call Core Projection Algorithm ---
-	projection := ProjectTriangleMax(image, tri, dirUnitVec)
-
-	return projection, tri, nil
+	return Triangle{V1: v1, V2: v2, V3: v3}, dirUnitVec, nil
 }
 
 // ProjectTriangleMax sets up the 1D projection array and calls the
-// high-performance scan-line rasterizer.
+// high-performance scan-line rasterizer. It does not validate image; use
+// ValidateImage to reject ragged input.
 func ProjectTriangleMax(image [][]float64, tri Triangle, dirUnitVec Point) []float64 {
+	return ProjectTriangle(image, tri, dirUnitVec, ReduceMax)
+}
+
+// ProjectTriangle projects the pixels inside tri onto dirUnitVec and combines
+// each bin with the given reducer. Bins that receive no pixels are left at
+// negative infinity for every reducer.
+func ProjectTriangle(image [][]float64, tri Triangle, dirUnitVec Point, reducer Reducer) []float64 {
+	values, _ := ProjectTriangleWithOptions(image, tri, dirUnitVec, ProjectOptions{Reducer: reducer})
+	return values
+}
+
+// ProjectTriangleWithOptions projects the pixels inside tri onto dirUnitVec,
+// skipping no-data pixels and those below MinValue, and returns the reduced value and the pixel count
+// of every bin. Bins are reduced from their first pixel, so negative data is
+// handled correctly; bins with a count of zero are left at negative infinity.
+// The bins end with the last one the part of tri inside the image reaches,
+// and there are none if tri misses the image.
+func ProjectTriangleWithOptions(image [][]float64, tri Triangle, dirUnitVec Point, opts ProjectOptions) ([]float64, []int) {
 	imgHeight := len(image)
 	if imgHeight == 0 {
-		return nil
+		return nil, nil
 	}
 	imgWidth := len(image[0])
 	if imgWidth == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// --- 1. Create 1D Result Array ---
-	p1 := dot(tri.V1, dirUnitVec)
-	p2 := dot(tri.V2, dirUnitVec)
-	p3 := dot(tri.V3, dirUnitVec)
-
-	uMin := math.Min(p1, math.Min(p2, p3))
-	uMax := math.Max(p1, math.Max(p2, p3))
-
-	arraySize := int(math.Ceil(uMax)) - int(math.Floor(uMin)) + 1
+	uMin, arraySize := clippedProjectionRange(tri, dirUnitVec, imgWidth, imgHeight)
 	if arraySize <= 0 {
-		return nil
+		return nil, nil
 	}
 
-	maxValues := make([]float64, arraySize)
-	for i := range maxValues {
-		maxValues[i] = math.Inf(-1) // Initialize with negative infinity
+	values := make([]float64, arraySize)
+	counts := make([]int, arraySize)
+	for i := range values {
+		values[i] = math.Inf(-1) // Initialize with negative infinity
 	}
 
 	// --- 2. Run Scan-line Rasterizer ---
-	// This function does all the work and modifies maxValues in place
-	rasterizeTriangleAndProject(image, tri, dirUnitVec, uMin, maxValues)
+	// This function does all the work and calls back for every covered pixel
+	rasterizeTriangleAndProject(image, tri, dirUnitVec, uMin, opts.skips, func(i int, pixelValue float64) {
+		if i < 0 || i >= len(values) || opts.saturated(values, counts, i) {
+			return
+		}
+		reduceInto(values, counts, i, pixelValue, opts.Reducer)
+	})
+	finishReduce(values, counts, opts.Reducer)
+
+	return values, counts
+}
 
-	return maxValues
+// reduceInto adds pixelValue to bin i of values, which already holds
+// counts[i] pixels, using reducer. Bins are reduced from their first pixel.
+func reduceInto(values []float64, counts []int, i int, pixelValue float64, reducer Reducer) {
+	if counts[i] == 0 {
+		values[i] = pixelValue
+	} else if reducer == ReduceMax {
+		values[i] = math.Max(values[i], pixelValue)
+	} else {
+		values[i] += pixelValue
+	}
+	counts[i]++
 }
 
-// rasterizeTriangleAndProject implements the scan-line algorithm.
-// It sorts the vertices by Y and splits the triangle into a
-// flat-top and flat-bottom part, then fills them.
+// finishReduce turns the sums left by reduceInto into means for ReduceMean.
+func finishReduce(values []float64, counts []int, reducer Reducer) {
+	if reducer != ReduceMean {
+		return
+	}
+	for i, n := range counts {
+		if n > 0 {
+			values[i] /= float64(n)
+		}
+	}
+}
+
+// projectionRange returns the smallest projected coordinate of the triangle
+// and the number of unit-width bins needed to cover it.
+func projectionRange(tri Triangle, dirUnitVec Point) (float64, int) {
+	p1 := dot(tri.V1, dirUnitVec)
+	p2 := dot(tri.V2, dirUnitVec)
+	p3 := dot(tri.V3, dirUnitVec)
+
+	uMin := math.Min(p1, math.Min(p2, p3))
+	uMax := math.Max(p1, math.Max(p2, p3))
+
+	return uMin, int(math.Ceil(uMax)) - int(math.Floor(uMin)) + 1
+}
+
+// rasterizeTriangleAndProject visits every pixel inside tri with its bin
+// along dirUnitVec, counted from the bin of uMin, except those skip reports
+// true for, which are passed over before they are projected.
 func rasterizeTriangleAndProject(
 	image [][]float64,
 	tri Triangle,
 	dirUnitVec Point,
 	uMin float64,
-	maxValues []float64,
+	skip func(pixelValue float64) bool,
+	visit func(bin int, pixelValue float64),
 ) {
+	uMinFloored := math.Floor(uMin)
+	rasterizeTriangle(image, tri, func(image [][]float64, x, y int) {
+		v := image[y][x]
+		if skip(v) {
+			return
+		}
+		u := (float64(x)*dirUnitVec.X + float64(y)*dirUnitVec.Y)
+		i := int(math.Floor(u) - uMinFloored)
+		visit(i, v)
+	})
+}
+
+// rasterizeTriangle implements the scan-line algorithm, calling processPixel
+// for every pixel of image covered by tri. It splits the triangle into a
+// flat-top and flat-bottom part with scanHalves, then fills them.
+func rasterizeTriangle(image [][]float64, tri Triangle, processPixel func(image [][]float64, x, y int)) {
 	imgHeight := len(image)
 	imgWidth := len(image[0])
-	uMinFloored := math.Floor(uMin)
 
+	// Wrap the caller's processor so the fillers never pass it a pixel
+	// beyond the end of a row. Spans are clipped to the first row's width;
+	// a shorter row ends early.
+	visitPixel := processPixel
+	processPixel = func(image [][]float64, x, y int) {
+		if x >= len(image[y]) {
+			return
+		}
+		visitPixel(image, x, y)
+	}
+
+	for _, half := range scanHalves(tri) {
+		fillHalf(image, half, imgWidth, imgHeight, processPixel)
+	}
+}
+
+// scanHalf is a flat-bottom or flat-top part of a triangle, between the
+// scan lines yTop and yBot. At scan line y it spans between its two edges,
+// each given by a point on it and its inverse slope (dx/dy).
+type scanHalf struct {
+	yTop, yBot     float64
+	p1, p2         Point
+	slope1, slope2 float64
+}
+
+// span returns the X range of the half at scan line y, computed the same
+// way for the rasterizer and Triangle.Contains.
+func (h scanHalf) span(y float64) (float64, float64) {
+	// Absolute interpolation is more stable than incremental curX += slope
+	x1 := h.p1.X + y*h.slope1 - h.p1.Y*h.slope1
+	x2 := h.p2.X + y*h.slope2 - h.p2.Y*h.slope2
+	return minF64(x1, x2), maxF64(x1, x2)
+}
+
+// scanHalves sorts the vertices of tri by Y and splits it into a flat-top
+// and flat-bottom part. It returns no part for a triangle with no height.
+func scanHalves(tri Triangle) []scanHalf {
 	// Put vertices into a slice and sort them by Y-coordinate (v[0] is top)
 	vertices := []Point{tri.V1, tri.V2, tri.V3}
 	sort.Slice(vertices, func(i, j int) bool {
@@ -160,32 +403,19 @@ func rasterizeTriangleAndProject(
 
 	// Handle degenerate triangle (horizontal line)
 	if v1.Y == v3.Y {
-		return // Or handle as a single line, but for 2D it has no area
-	}
-
-	// Create a "processor" function to pass into the fillers.
-	// This avoids duplicating the projection logic.
-	processPixel := func(image [][]float64, x, y int) {
-		pixelValue := image[y][x]
-		u := (float64(x)*dirUnitVec.X + float64(y)*dirUnitVec.Y)
-		i := int(math.Floor(u) - uMinFloored)
-		if i >= 0 && i < len(maxValues) {
-			maxValues[i] = math.Max(maxValues[i], pixelValue)
-		}
+		return nil // Or handle as a single line, but for 2D it has no area
 	}
 
 	// --- Split the triangle into flat-bottom and flat-top ---
 
 	// Case 1: Flat-bottom triangle (v2.Y == v3.Y)
 	if v2.Y == v3.Y {
-		fillFlatBottomTriangle(image, v1, v2, v3, imgWidth, imgHeight, processPixel)
-		return
+		return []scanHalf{flatBottomHalf(v1, v2, v3)}
 	}
 
 	// Case 2: Flat-top triangle (v1.Y == v2.Y)
 	if v1.Y == v2.Y {
-		fillFlatTopTriangle(image, v1, v2, v3, imgWidth, imgHeight, processPixel)
-		return
+		return []scanHalf{flatTopHalf(v1, v2, v3)}
 	}
 
 	// Case 3: General triangle. Need to split it.
@@ -196,81 +426,53 @@ func rasterizeTriangleAndProject(
 		Y: v2.Y,
 	}
 
-	// Split into two triangles and fill them
+	// Split into two triangles
 	if v2.X < v4.X {
 		// V2 is left, V4 is right
-		fillFlatBottomTriangle(image, v1, v2, v4, imgWidth, imgHeight, processPixel)
-		fillFlatTopTriangle(image, v2, v4, v3, imgWidth, imgHeight, processPixel)
-	} else {
-		// V4 is left, V2 is right
-		fillFlatBottomTriangle(image, v1, v4, v2, imgWidth, imgHeight, processPixel)
-		fillFlatTopTriangle(image, v4, v2, v3, imgWidth, imgHeight, processPixel)
+		return []scanHalf{flatBottomHalf(v1, v2, v4), flatTopHalf(v2, v4, v3)}
 	}
+	// V4 is left, V2 is right
+	return []scanHalf{flatBottomHalf(v1, v4, v2), flatTopHalf(v4, v2, v3)}
 }
 
-// fillFlatBottomTriangle fills a triangle where vBotLeft and vBotRight are at the same Y
-func fillFlatBottomTriangle(
-	image [][]float64,
-	vTop, vBotLeft, vBotRight Point,
-	imgWidth, imgHeight int,
-	processPixel func(image [][]float64, x, y int),
-) {
+// flatBottomHalf returns a triangle where vBotLeft and vBotRight are at the
+// same Y.
+func flatBottomHalf(vTop, vBotLeft, vBotRight Point) scanHalf {
 	dy := vBotLeft.Y - vTop.Y
-	if dy == 0 {
-		return // Avoid divide-by-zero, zero-height triangle
+	return scanHalf{
+		yTop: vTop.Y, yBot: vBotLeft.Y,
+		p1: vTop, p2: vTop,
+		slope1: (vBotLeft.X - vTop.X) / dy,
+		slope2: (vBotRight.X - vTop.X) / dy,
 	}
+}
 
-	// Calculate inverse slopes (dx/dy)
-	slope1 := (vBotLeft.X - vTop.X) / dy
-	slope2 := (vBotRight.X - vTop.X) / dy
-
-	// Get Y scan range (pixel centers)
-	yStart := int(math.Ceil(vTop.Y))
-	yEnd := int(math.Floor(vBotLeft.Y))
-
-	// Clamp Y to image bounds
-	yStart = max(0, yStart)
-	yEnd = min(imgHeight-1, yEnd)
-
-	for y := yStart; y <= yEnd; y++ {
-		// Get x start/end for this scan-line using absolute interpolation
-		// (more stable than incremental curX += slope)
-		x1 := vTop.X + float64(y)*slope1 - vTop.Y*slope1
-		x2 := vTop.X + float64(y)*slope2 - vTop.Y*slope2
-
-		xStart := int(math.Ceil(minF64(x1, x2)))
-		xEnd := int(math.Floor(maxF64(x1, x2)))
-
-		// Clamp X to image bounds
-		xStart = max(0, xStart)
-		xEnd = min(imgWidth-1, xEnd)
-
-		// Fill the span
-		for x := xStart; x <= xEnd; x++ {
-			processPixel(image, x, y)
-		}
+// flatTopHalf returns a triangle where vTopLeft and vTopRight are at the
+// same Y.
+func flatTopHalf(vTopLeft, vTopRight, vBot Point) scanHalf {
+	dy := vBot.Y - vTopLeft.Y
+	return scanHalf{
+		yTop: vTopLeft.Y, yBot: vBot.Y,
+		p1: vTopLeft, p2: vTopRight,
+		slope1: (vBot.X - vTopLeft.X) / dy,
+		slope2: (vBot.X - vTopRight.X) / dy,
 	}
 }
 
-// fillFlatTopTriangle fills a triangle where vTopLeft and vTopRight are at the same Y
-func fillFlatTopTriangle(
+// fillHalf visits the pixels of image covered by half.
+func fillHalf(
 	image [][]float64,
-	vTopLeft, vTopRight, vBot Point,
+	half scanHalf,
 	imgWidth, imgHeight int,
 	processPixel func(image [][]float64, x, y int),
 ) {
-	dy := vBot.Y - vTopLeft.Y
-	if dy == 0 {
+	if half.yBot == half.yTop {
 		return // Avoid divide-by-zero, zero-height triangle
 	}
 
-	// Calculate inverse slopes (dx/dy)
-	slope1 := (vBot.X - vTopLeft.X) / dy
-	slope2 := (vBot.X - vTopRight.X) / dy
-
 	// Get Y scan range (pixel centers)
-	yStart := int(math.Ceil(vTopLeft.Y))
-	yEnd := int(math.Floor(vBot.Y))
+	yStart := int(math.Ceil(half.yTop))
+	yEnd := int(math.Floor(half.yBot))
 
 	// Clamp Y to image bounds
 	yStart = max(0, yStart)
@@ -278,11 +480,10 @@ func fillFlatTopTriangle(
 
 	for y := yStart; y <= yEnd; y++ {
 		// Get x start/end for this scan-line
-		x1 := vTopLeft.X + float64(y)*slope1 - vTopLeft.Y*slope1
-		x2 := vTopRight.X + float64(y)*slope2 - vTopRight.Y*slope2
+		x1, x2 := half.span(float64(y))
 
-		xStart := int(math.Ceil(minF64(x1, x2)))
-		xEnd := int(math.Floor(maxF64(x1, x2)))
+		xStart := int(math.Ceil(x1))
+		xEnd := int(math.Floor(x2))
 
 		// Clamp X to image bounds
 		xStart = max(0, xStart)
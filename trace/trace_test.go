@@ -191,6 +191,132 @@ func TestProjectTriangleMax(t *testing.T) {
 	}
 }
 
+func TestProjectTriangleNoDataAndNegativeValues(t *testing.T) {
+	const noData = -999.0
+	image := [][]float64{
+		{noData, -4, noData},
+		{-2, -6, noData},
+	}
+	// Covers the whole image; projecting onto X gives one bin per column.
+	tri := Triangle{V1: Point{X: -1, Y: -1}, V2: Point{X: 7, Y: -1}, V3: Point{X: -1, Y: 7}}
+	dir := Point{X: 1, Y: 0}
+	opts := ProjectOptions{Reducer: ReduceMean, HasNoData: true, NoDataValue: noData}
+
+	values, counts := ProjectTriangleWithOptions(image, tri, dir, opts)
+	// Bin 0 is u=-1, so column x is bin x+1.
+	if values[1] != -2 || counts[1] != 1 {
+		t.Errorf("Column 0: expected mean -2 over 1 pixel, got %f over %d", values[1], counts[1])
+	}
+	if values[2] != -5 || counts[2] != 2 {
+		t.Errorf("Column 1: expected mean -5 over 2 pixels, got %f over %d", values[2], counts[2])
+	}
+	if counts[3] != 0 || !math.IsInf(values[3], -1) {
+		t.Errorf("Column 2 holds only no-data and should be empty, got %f over %d", values[3], counts[3])
+	}
+
+	// Max and sum must also ignore the sentinel and start from the data.
+	opts.Reducer = ReduceMax
+	if values, _ := ProjectTriangleWithOptions(image, tri, dir, opts); values[1] != -2 || values[2] != -4 {
+		t.Errorf("Expected maxima -2 and -4, got %f and %f", values[1], values[2])
+	}
+	opts.Reducer = ReduceSum
+	if values, _ := ProjectTriangleWithOptions(image, tri, dir, opts); values[1] != -2 || values[2] != -10 {
+		t.Errorf("Expected sums -2 and -10, got %f and %f", values[1], values[2])
+	}
+
+	// Without the option the sentinel poisons the mean.
+	if values := ProjectTriangle(image, tri, dir, ReduceMean); values[1] == -2 {
+		t.Error("Expected the sentinel to affect the mean when NoDataValue is not set")
+	}
+}
+
+// sparseImage returns a size x size image of zeros with a value of 1 to 8
+// every step pixels along the diagonal.
+func sparseImage(size, step int) [][]float64 {
+	image := make([][]float64, size)
+	for y := range image {
+		image[y] = make([]float64, size)
+	}
+	for i := 0; i < size; i += step {
+		image[i][i] = float64(1 + i/step%8)
+	}
+	return image
+}
+
+// TestProjectTriangleMinValue checks that MinValue=1 leaves the maxima of a
+// mostly empty image as they are with MinValue=0, takes the mean over the
+// nonzero pixels alone and counts only them.
+func TestProjectTriangleMinValue(t *testing.T) {
+	image := sparseImage(64, 9)
+	tri := Triangle{V1: Point{X: -1, Y: -1}, V2: Point{X: 200, Y: -1}, V3: Point{X: -1, Y: 200}}
+	dir := Point{X: 1, Y: 0}
+
+	all, allCounts := ProjectTriangleWithOptions(image, tri, dir, ProjectOptions{HasMinValue: true, MinValue: 0})
+	nonzero, counts := ProjectTriangleWithOptions(image, tri, dir, ProjectOptions{HasMinValue: true, MinValue: 1})
+	if len(all) != len(nonzero) {
+		t.Fatalf("Expected profiles of the same length, got %d and %d", len(all), len(nonzero))
+	}
+	for i := range all {
+		// Bin 0 is u=-1, so column x is bin x+1.
+		x := i - 1
+		if x >= 0 && x < 64 && image[x][x] != 0 {
+			if nonzero[i] != all[i] || counts[i] != 1 {
+				t.Errorf("Column %d: expected max %v over 1 pixel, got %v over %d", x, all[i], nonzero[i], counts[i])
+			}
+			continue
+		}
+		if counts[i] != 0 || !math.IsInf(nonzero[i], -1) {
+			t.Errorf("Column %d holds only zeros and should be empty, got %v over %d", x, nonzero[i], counts[i])
+		}
+		if x >= 0 && x < 64 && (all[i] != 0 || allCounts[i] != 64) {
+			t.Errorf("Column %d: expected max 0 over 64 pixels with MinValue=0, got %v over %d", x, all[i], allCounts[i])
+		}
+	}
+
+	// Each column holds one nonzero pixel, which is then its whole mean.
+	mean, meanCounts := ProjectTriangleWithOptions(image, tri, dir, ProjectOptions{Reducer: ReduceMean, HasMinValue: true, MinValue: 1})
+	for i := range mean {
+		if meanCounts[i] != counts[i] || meanCounts[i] > 0 && mean[i] != nonzero[i] {
+			t.Errorf("Bin %d: expected the mean %v of the nonzero pixels over %d, got %v over %d", i, nonzero[i], counts[i], mean[i], meanCounts[i])
+		}
+	}
+}
+
+// TestProjectTriangleSaturation checks that a ReduceMax bin stops taking
+// pixels once it reaches Saturation.
+func TestProjectTriangleSaturation(t *testing.T) {
+	image := [][]float64{
+		{1, 5},
+		{7, 9},
+		{3, 2},
+	}
+	tri := Triangle{V1: Point{X: -1, Y: -1}, V2: Point{X: 7, Y: -1}, V3: Point{X: -1, Y: 7}}
+	dir := Point{X: 1, Y: 0}
+
+	values, counts := ProjectTriangleWithOptions(image, tri, dir, ProjectOptions{HasSaturation: true, Saturation: 6})
+	// Column 0 saturates at 7 in row 1 and never sees row 2; column 1
+	// saturates at 9 in row 1.
+	if values[1] != 7 || counts[1] != 2 || values[2] != 9 || counts[2] != 2 {
+		t.Errorf("Expected 7 and 9 over 2 pixels each, got %v over %v", values[1:3], counts[1:3])
+	}
+	if values, counts := ProjectTriangleWithOptions(image, tri, dir, ProjectOptions{Reducer: ReduceSum, HasSaturation: true, Saturation: 6}); values[1] != 11 || counts[1] != 3 {
+		t.Errorf("Expected ReduceSum to ignore Saturation, got %v over %d", values[1], counts[1])
+	}
+}
+
+func TestProjectOptionsNaNNoData(t *testing.T) {
+	opts := ProjectOptions{HasNoData: true, NoDataValue: math.NaN()}
+	if !opts.isNoData(math.NaN()) {
+		t.Error("Expected NaN pixels to match a NaN NoDataValue")
+	}
+	if opts.isNoData(0) {
+		t.Error("Expected 0 not to match a NaN NoDataValue")
+	}
+	if (ProjectOptions{}).isNoData(0) {
+		t.Error("Expected no pixels to match when HasNoData is unset")
+	}
+}
+
 func TestNormalize(t *testing.T) {
 	// Test normalizing a simple vector
 	vec := Point{X: 3, Y: 4}
@@ -451,3 +577,24 @@ func TestPalettedImageCoordinateSystem(t *testing.T) {
 		}
 	})
 }
+
+// BenchmarkProjectTriangleSparse projects a 2048x2048 image with a nonzero
+// pixel in every 64 along its diagonal, with and without MinValue.
+func BenchmarkProjectTriangleSparse(b *testing.B) {
+	image := sparseImage(2048, 64)
+	tri := Triangle{V1: Point{X: 0, Y: 0}, V2: Point{X: 2047, Y: 0}, V3: Point{X: 0, Y: 2047}}
+	dir, _ := normalize(Point{X: 1, Y: 1})
+	for _, bc := range []struct {
+		name string
+		opts ProjectOptions
+	}{
+		{"all", ProjectOptions{}},
+		{"MinValue=1", ProjectOptions{HasMinValue: true, MinValue: 1}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ProjectTriangleWithOptions(image, tri, dir, bc.opts)
+			}
+		})
+	}
+}